@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvaluateClock(t *testing.T) {
+	floor := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	early := time.Date(2019, 3, 1, 8, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		now       time.Time
+		floor     time.Time
+		reference time.Duration // Эталон минус система
+		noRef     bool
+		suspect   bool
+		reason    string
+	}{
+		{"plausible, no reference", now, floor, 0, true, false, ""},
+		{"zero floor disables the date check", early, time.Time{}, 0, true, false, ""},
+		{"before floor", early, floor, 0, true, true, "system clock 2019-03-01 08:00:00 is before 2025-01-01"},
+		{"skew within limit", now, floor, 4 * time.Minute, false, false, ""},
+		{"skew at the limit", now, floor, -5 * time.Minute, false, false, ""},
+		{"system clock behind", now, floor, 2 * time.Hour, false, true, "system clock differs from reference by 2h0m0s (limit 5m0s)"},
+		{"system clock ahead", now, floor, -301 * time.Second, false, true, "system clock differs from reference by -5m1s (limit 5m0s)"},
+		// Эталон согласен с системой, но дата неправдоподобна: у BMC тоже неверное время
+		{"reference agrees, date before floor", early, floor, time.Second, false, true, "system clock 2019-03-01 08:00:00 is before 2025-01-01"},
+	} {
+		var reference *time.Time
+		if !tc.noRef {
+			ref := tc.now.Add(tc.reference)
+			reference = &ref
+		}
+		suspect, skew, reason := evaluateClock(tc.now, tc.floor, reference, 5*time.Minute)
+		if suspect != tc.suspect || skew != tc.reference || reason != tc.reason {
+			t.Errorf("%s: %v %s %q, want %v %s %q", tc.name, suspect, skew, reason, tc.suspect, tc.reference, tc.reason)
+		}
+	}
+}
+
+func TestClockLimits(t *testing.T) {
+	if got := clockFloor(SystemConfig{}); got.Format("2006-01-02") != clockFloorDate {
+		t.Errorf("default floor %s, want build date %s", got, clockFloorDate)
+	}
+	if got := clockFloor(SystemConfig{ClockFloor: "2026-03-01"}); got.Format("2006-01-02") != "2026-03-01" {
+		t.Errorf("configured floor: %s", got)
+	}
+	if got := clockFloor(SystemConfig{ClockFloor: "01.03.2026"}); !got.IsZero() {
+		t.Errorf("invalid floor not disabled: %s", got)
+	}
+	if maxClockSkew(SystemConfig{}) != 5*time.Minute || maxClockSkew(SystemConfig{MaxClockSkewMinutes: 2}) != 2*time.Minute {
+		t.Error("max clock skew defaults")
+	}
+}
+
+// fakeTimeTool кладет в отдельный PATH скрипт NTP утилиты с заданным выводом
+func fakeTimeTool(t *testing.T, name, output string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\nprintf '%s' " + shellQuote(output) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestQueryNTPOffset(t *testing.T) {
+	fakeTimeTool(t, "ntpdate", "server 10.0.0.1, stratum 2, offset -0.002345, delay 0.02563\n"+
+		"15 May 09:00:00 ntpdate[1234]: adjust time server 10.0.0.1 offset -0.002345 sec\n")
+	if offset, err := queryNTPOffset("10.0.0.1"); err != nil || offset != -2345*time.Microsecond {
+		t.Errorf("ntpdate: %s %v", offset, err)
+	}
+
+	fakeTimeTool(t, "chronyd", "2024-05-15T09:00:00Z chronyd version 4.2 starting\n"+
+		"2024-05-15T09:00:04Z System clock wrong by 3600.500000 seconds (ignored)\n")
+	if offset, err := queryNTPOffset("10.0.0.1"); err != nil || offset != 3600500*time.Millisecond {
+		t.Errorf("chronyd: %s %v", offset, err)
+	}
+
+	fakeTimeTool(t, "ntpdate", "no server suitable for synchronization found\n")
+	if _, err := queryNTPOffset("10.0.0.1"); err == nil {
+		t.Error("offset parsed from a failed query")
+	}
+}
+
+// Системное время на час позади NTP: без fix_clock сессия помечается clock_suspect
+func TestCheckSystemClockSuspect(t *testing.T) {
+	fakeTimeTool(t, "ntpdate", "server 10.0.0.1, stratum 2, offset 3600.000000, delay 0.02563\n")
+	check := checkSystemClock(SystemConfig{NTPServer: "10.0.0.1"})
+	if !check.Suspect || check.Corrected || check.Reference != "ntp:10.0.0.1" || check.SkewSeconds != 3600 {
+		t.Errorf("check: %+v", check)
+	}
+
+	var log SessionLog
+	annotateClockCheck(&log, check)
+	if !log.ClockSuspect || log.Clock != check {
+		t.Errorf("session log not annotated: %v %+v", log.ClockSuspect, log.Clock)
+	}
+	annotateClockCheck(&log, nil)
+	if log.Clock != check {
+		t.Error("nil check cleared the annotation")
+	}
+}
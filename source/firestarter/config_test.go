@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Встроенный шаблон разбирается тем же загрузчиком, что и рабочий конфиг, и проходит проверку
+func TestConfigTemplateLoads(t *testing.T) {
+	config, err := loadConfigData(configTemplate)
+	if err != nil {
+		t.Fatalf("config.example.yaml: %v", err)
+	}
+	if err := validateConfig(config); err != nil {
+		t.Errorf("config.example.yaml does not validate: %v", err)
+	}
+}
+
+// example/config.yaml - ссылка на шаблон, а не копия
+func TestExampleConfigIsTemplate(t *testing.T) {
+	path := filepath.Join("..", "..", "example", "config.yaml")
+	target, err := os.Readlink(path)
+	if err != nil {
+		t.Fatalf("%s is not a symlink: %v", path, err)
+	}
+	if filepath.Base(target) != "config.example.yaml" {
+		t.Errorf("%s -> %s", path, target)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, configTemplate) {
+		t.Error("example/config.yaml differs from the embedded template")
+	}
+}
+
+func TestGenerateConfigDoesNotOverwrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "conf", "config.yaml")
+	if err := generateConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, configTemplate) {
+		t.Error("generated config differs from the template")
+	}
+	if err := os.WriteFile(path, []byte("edited\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := generateConfig(path); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("second run: %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "edited\n" {
+		t.Errorf("existing config overwritten: %q", data)
+	}
+}
+
+func TestApplyConfigDefaults(t *testing.T) {
+	var buf bytes.Buffer
+	saved := messageOutput
+	messageOutput = &buf
+	defer func() { messageOutput = saved }()
+
+	config := &Config{}
+	config.Flash.Enabled = true
+	applyConfigDefaults(config)
+	if config.Flash.Method != "eeupdate" || config.Tests.Timeout != "30s" || config.Tests.MaxRetries != 5 || config.Log.LogDir != "logs" || config.Flash.FRUBlankSizeBytes != 2048 {
+		t.Errorf("defaults: method %q, timeout %q, retries %d, log_dir %q, fru_blank_size_bytes %d",
+			config.Flash.Method, config.Tests.Timeout, config.Tests.MaxRetries, config.Log.LogDir, config.Flash.FRUBlankSizeBytes)
+	}
+	// Без -debug о подставленных значениях не сообщается
+	if buf.Len() != 0 {
+		t.Errorf("output without -debug: %q", buf.String())
+	}
+
+	auto := &Config{}
+	auto.Flash.Enabled = true
+	auto.Flash.Method = "auto"
+	debugMode = true
+	defer func() { debugMode = false }()
+	applyConfigDefaults(auto)
+	if auto.Flash.Method != "auto" {
+		t.Errorf("explicit method replaced with %q", auto.Flash.Method)
+	}
+	if !strings.Contains(buf.String(), "[config] applied default: tests.timeout = 30s") || strings.Contains(buf.String(), "flash.method") {
+		t.Errorf("debug output: %q", buf.String())
+	}
+}
+
+// loadConfig возвращает конфиг как в файле и развернутый, генератор тестов запускается только для сессии
+func TestLoadConfigRawAndExpanded(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "generator-ran")
+	generator := filepath.Join(dir, "gen.sh")
+	script := "#!/bin/sh\ntouch " + marker + "\necho '- {name: generated, command: \"true\"}'\n"
+	if err := os.WriteFile(generator, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	library := "test_library:\n  cpu:\n    name: cpu\n    command: stress --cpu 4\n    timeout: 10s\n"
+	if err := os.WriteFile(filepath.Join(dir, "common.yaml"), []byte(library), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := "include: [common.yaml]\ntests:\n  test_generator_command: " + generator + "\n" +
+		"  sequential_groups:\n    - - use: cpu\n        timeout: 20s\n"
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, expanded, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw.Include) != 1 || raw.Include[0] != "common.yaml" {
+		t.Errorf("raw include = %v", raw.Include)
+	}
+	if test := raw.Tests.SequentialGroups[0].Tests[0]; test.Use != "cpu" || test.Command != "" || test.Timeout != "20s" {
+		t.Errorf("raw test = %+v", test)
+	}
+	if expanded.Include != nil || expanded.TestLibrary != nil {
+		t.Errorf("expanded config keeps include/test_library: %v %v", expanded.Include, expanded.TestLibrary)
+	}
+	tests := expanded.Tests.SequentialGroups[0].Tests
+	if len(tests) != 1 || tests[0].Name != "cpu" || tests[0].Command != "stress --cpu 4" || tests[0].Timeout != "20s" || tests[0].Use != "" {
+		t.Errorf("expanded tests = %+v", tests)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("loadConfig ran the test generator")
+	}
+
+	if err := expandGeneratedTests(expanded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("test generator did not run: %v", err)
+	}
+	tests = expanded.Tests.SequentialGroups[0].Tests
+	if len(tests) != 2 || tests[1].Name != "generated" {
+		t.Errorf("tests after generator = %+v", tests)
+	}
+	if expanded.Tests.TestGeneratorCommand != "" {
+		t.Errorf("generator command kept in the session config: %q", expanded.Tests.TestGeneratorCommand)
+	}
+}
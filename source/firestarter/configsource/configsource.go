@@ -0,0 +1,369 @@
+// Package configsource получает конфиг станции с центрального сервера (scp или HTTP)
+// с проверкой SHA-256 и локальным кэшем на случай недоступности сервера.
+package configsource
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Источник конфига, которым запущена сессия
+const (
+	SourceRemote = "remote"
+	SourceCache  = "cache"
+	SourceLocal  = "local"
+)
+
+// maxConfigSize - предел размера загружаемого конфига (защита от ошибочного URL на большой файл)
+const maxConfigSize = 4 << 20
+
+// Fetcher загружает файл по пути относительно корня источника
+type Fetcher interface {
+	Fetch(name string) ([]byte, error)
+	Location(name string) string // Полный адрес файла для логов
+}
+
+// CommandRunner запускает команду и возвращает объединенный stdout/stderr (в тестах подменяется)
+type CommandRunner func(name string, args ...string) ([]byte, error)
+
+func execRunner(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// SCPFetcher - источник user@host:path, файлы копируются scp
+type SCPFetcher struct {
+	Server  string   // user@host
+	Dir     string   // Каталог на сервере
+	Options []string // Опции ssh/scp (те же, что у выгрузки логов)
+	Run     CommandRunner
+}
+
+func (f SCPFetcher) Location(name string) string {
+	return f.Server + ":" + path.Join(f.Dir, name)
+}
+
+func (f SCPFetcher) Fetch(name string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "firestarter-config-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	run := f.Run
+	if run == nil {
+		run = execRunner
+	}
+	local := filepath.Join(dir, "fetched")
+	args := append(append([]string{}, f.Options...), f.Location(name), local)
+	if output, err := run("scp", args...); err != nil {
+		return nil, fmt.Errorf("scp %s failed: %v (%s)", f.Location(name), err, strings.TrimSpace(string(output)))
+	}
+	return readLimited(local)
+}
+
+// HTTPFetcher - источник http(s)://host/path
+type HTTPFetcher struct {
+	BaseURL string
+	Client  *http.Client // nil - клиент с таймаутом 30s
+}
+
+func (f HTTPFetcher) Location(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.TrimSuffix(f.BaseURL, "/") + "/" + strings.Join(parts, "/")
+}
+
+func (f HTTPFetcher) Fetch(name string) ([]byte, error) {
+	client := f.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	location := f.Location(name)
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s failed: %v", location, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %v", location, err)
+	}
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("GET %s: file is larger than %d bytes", location, maxConfigSize)
+	}
+	return data, nil
+}
+
+// ErrNotModified - ответ 304 на условный запрос: копия с переданным ETag актуальна
+var ErrNotModified = errors.New("not modified")
+
+// FetchURL загружает файл по полному URL. С непустым etag запрос условный (If-None-Match),
+// неизмененный файл дает ErrNotModified. Возвращает данные и новый ETag сервера
+func FetchURL(client *http.Client, rawURL, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url %q: %v", rawURL, err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("GET %s failed: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, ErrNotModified
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("GET %s: %v", rawURL, err)
+	}
+	if len(data) > maxConfigSize {
+		return nil, "", fmt.Errorf("GET %s: file is larger than %d bytes", rawURL, maxConfigSize)
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+func readLimited(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxConfigSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, maxConfigSize)
+	}
+	return os.ReadFile(path)
+}
+
+// NewFetcher выбирает источник по адресу: http(s)://... - HTTP, user@host:path - scp с sshOptions
+func NewFetcher(source string, sshOptions []string) (Fetcher, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		if _, err := url.Parse(source); err != nil {
+			return nil, fmt.Errorf("invalid config_source url %q: %v", source, err)
+		}
+		return HTTPFetcher{BaseURL: source}, nil
+	}
+	server, dir, ok := strings.Cut(source, ":")
+	if !ok || !strings.Contains(server, "@") || strings.HasPrefix(server, "@") || strings.HasSuffix(server, "@") {
+		return nil, fmt.Errorf("invalid config_source url %q: expected http(s)://... or user@host:path", source)
+	}
+	if dir == "" {
+		dir = "."
+	}
+	return SCPFetcher{Server: server, Dir: dir, Options: sshOptions}, nil
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeName делает из продукта/ID станции имя файла
+func safeName(s string) string {
+	return strings.Trim(unsafeNameChars.ReplaceAllString(s, "_"), "._")
+}
+
+// Candidates - файлы конфига на сервере в порядке предпочтения:
+// <product>/<station_id>.yaml (если ID станции задан), затем <product>/config.yaml
+func Candidates(product, stationID string) []string {
+	dir := safeName(product)
+	if dir == "" {
+		dir = "default"
+	}
+	var names []string
+	if station := safeName(stationID); station != "" {
+		names = append(names, dir+"/"+station+".yaml")
+	}
+	return append(names, dir+"/config.yaml")
+}
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Download загружает первый доступный кандидат и проверяет его по файлу <имя>.sha256 рядом с ним
+// (формат sha256sum или только хеш). Конфиг без контрольной суммы не принимается
+func Download(f Fetcher, names []string) (data []byte, name, sum string, err error) {
+	var errs []string
+	for _, name := range names {
+		data, err := f.Fetch(name)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		published, err := f.Fetch(name + ".sha256")
+		if err != nil {
+			return nil, name, "", fmt.Errorf("checksum for %s unavailable: %v", f.Location(name), err)
+		}
+		fields := strings.Fields(string(published))
+		if len(fields) == 0 || !sha256Pattern.MatchString(strings.ToLower(fields[0])) {
+			return nil, name, "", fmt.Errorf("invalid checksum file %s", f.Location(name+".sha256"))
+		}
+		expected := strings.ToLower(fields[0])
+		if actual := Sum(data); actual != expected {
+			return nil, name, "", fmt.Errorf("checksum mismatch for %s: published %s, downloaded %s", f.Location(name), expected, actual)
+		}
+		return data, name, expected, nil
+	}
+	return nil, "", "", fmt.Errorf("no configuration found: %s", strings.Join(errs, "; "))
+}
+
+// Sum - SHA-256 в hex
+func Sum(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+// Meta - сведения о копии конфига в кэше
+type Meta struct {
+	Location  string    `json:"location"`
+	SHA256    string    `json:"sha256"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// Cache - последняя успешно загруженная версия конфига для каждой пары продукт/станция
+type Cache struct {
+	Dir string
+}
+
+func (c Cache) paths(product, stationID string) (string, string) {
+	key := safeName(product)
+	if station := safeName(stationID); station != "" {
+		key += "_" + station
+	}
+	if key == "" {
+		key = "default"
+	}
+	return filepath.Join(c.Dir, key+".yaml"), filepath.Join(c.Dir, key+".meta.json")
+}
+
+// Store атомарно сохраняет конфиг и его метаданные; возвращает путь к файлу конфига
+func (c Cache) Store(product, stationID string, data []byte, meta Meta) (string, error) {
+	configPath, metaPath := c.paths(product, stationID)
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache dir: %v", err)
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := writeAtomic(configPath, data); err != nil {
+		return "", err
+	}
+	if err := writeAtomic(metaPath, metaData); err != nil {
+		return "", err
+	}
+	return configPath, nil
+}
+
+// Load возвращает путь к кэшированному конфигу, если его содержимое совпадает с сохраненным хешем
+func (c Cache) Load(product, stationID string) (string, Meta, error) {
+	configPath, metaPath := c.paths(product, stationID)
+	var meta Meta
+	metaData, err := os.ReadFile(metaPath)
+	if err != nil {
+		return "", meta, fmt.Errorf("no cached configuration: %v", err)
+	}
+	if err := json.Unmarshal(metaData, &meta); err != nil {
+		return "", meta, fmt.Errorf("invalid cache metadata %s: %v", metaPath, err)
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return "", meta, fmt.Errorf("no cached configuration: %v", err)
+	}
+	if Sum(data) != meta.SHA256 {
+		return "", meta, fmt.Errorf("cached configuration %s does not match its checksum", configPath)
+	}
+	return configPath, meta, nil
+}
+
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %v", path, err)
+	}
+	return nil
+}
+
+// Options - откуда и для какой станции загружать конфиг
+type Options struct {
+	Fetcher   Fetcher
+	Cache     Cache
+	Product   string
+	StationID string
+	Validate  func(data []byte) error // Проверка конфига до записи в кэш (nil - без проверки)
+	Now       func() time.Time
+}
+
+// Result - какой конфиг использовать
+type Result struct {
+	Source    string // remote, cache или local
+	Path      string // Файл конфига
+	Location  string // Адрес на сервере (remote и cache)
+	SHA256    string
+	FetchedAt time.Time // Время загрузки с сервера (для cache - возраст копии)
+	FetchErr  error     // Почему не remote
+}
+
+// Refresh загружает конфиг с сервера, проверяет и сохраняет в кэш
+func Refresh(opts Options) (Result, error) {
+	data, name, sum, err := Download(opts.Fetcher, Candidates(opts.Product, opts.StationID))
+	if err != nil {
+		return Result{}, err
+	}
+	location := opts.Fetcher.Location(name)
+	if opts.Validate != nil {
+		if err := opts.Validate(data); err != nil {
+			return Result{}, fmt.Errorf("configuration %s is invalid: %v", location, err)
+		}
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	meta := Meta{Location: location, SHA256: sum, FetchedAt: now()}
+	configPath, err := opts.Cache.Store(opts.Product, opts.StationID, data, meta)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Source: SourceRemote, Path: configPath, Location: location, SHA256: sum, FetchedAt: meta.FetchedAt}, nil
+}
+
+// Resolve выбирает конфиг: свежий с сервера, иначе последний из кэша, иначе локальный localPath
+func Resolve(opts Options, localPath string) Result {
+	result, fetchErr := Refresh(opts)
+	if fetchErr == nil {
+		return result
+	}
+
+	if cached, meta, err := opts.Cache.Load(opts.Product, opts.StationID); err == nil {
+		return Result{Source: SourceCache, Path: cached, Location: meta.Location, SHA256: meta.SHA256, FetchedAt: meta.FetchedAt, FetchErr: fetchErr}
+	}
+
+	local := Result{Source: SourceLocal, Path: localPath, FetchErr: fetchErr}
+	if data, err := os.ReadFile(localPath); err == nil {
+		local.SHA256 = Sum(data)
+	}
+	return local
+}
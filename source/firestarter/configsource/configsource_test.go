@@ -0,0 +1,313 @@
+package configsource
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const stationConfig = "tests:\n  timeout: 30s\n"
+
+// serverFiles - файлы конфига на сервере с опубликованными контрольными суммами
+func serverFiles(files map[string]string) map[string]string {
+	published := make(map[string]string)
+	for name, data := range files {
+		published[name] = data
+		published[name+".sha256"] = Sum([]byte(data)) + "  " + filepath.Base(name) + "\n"
+	}
+	return published
+}
+
+// fakeSCP - scp, копирующий файл из files; сервер недоступен, если files nil. Аргументы вызовов - в calls
+type fakeSCP struct {
+	files map[string]string
+	calls [][]string
+}
+
+func (s *fakeSCP) run(name string, args ...string) ([]byte, error) {
+	s.calls = append(s.calls, append([]string{name}, args...))
+	if name != "scp" || len(args) < 2 {
+		return nil, fmt.Errorf("unexpected command %s %v", name, args)
+	}
+	if s.files == nil {
+		return []byte("ssh: connect to host logs port 22: No route to host\n"), errors.New("exit status 255")
+	}
+	remote, local := args[len(args)-2], args[len(args)-1]
+	_, file, _ := strings.Cut(remote, ":")
+	data, ok := s.files[strings.TrimPrefix(file, "/srv/configs/")]
+	if !ok {
+		return []byte("scp: " + file + ": No such file or directory\n"), errors.New("exit status 1")
+	}
+	return nil, os.WriteFile(local, []byte(data), 0644)
+}
+
+func scpFetcher(scp *fakeSCP) SCPFetcher {
+	return SCPFetcher{Server: "cfg@logs", Dir: "/srv/configs", Options: []string{"-o", "BatchMode=yes"}, Run: scp.run}
+}
+
+func TestSCPFetcher(t *testing.T) {
+	scp := &fakeSCP{files: serverFiles(map[string]string{"R2100/config.yaml": stationConfig})}
+	f := scpFetcher(scp)
+	data, err := f.Fetch("R2100/config.yaml")
+	if err != nil || string(data) != stationConfig {
+		t.Fatalf("fetch: %q %v", data, err)
+	}
+	call := strings.Join(scp.calls[0][:4], " ")
+	if call != "scp -o BatchMode=yes cfg@logs:/srv/configs/R2100/config.yaml" {
+		t.Errorf("scp call: %s", call)
+	}
+
+	_, err = f.Fetch("R2100/st01.yaml")
+	if err == nil || !strings.Contains(err.Error(), "No such file or directory") {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+// httpServer отдает files; запросы записываются в requests
+func httpServer(t *testing.T, files map[string]string, requests *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			*requests = append(*requests, r.URL.EscapedPath())
+		}
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/configs/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPFetcher(t *testing.T) {
+	var requests []string
+	files := serverFiles(map[string]string{"Acme R2100/config.yaml": stationConfig})
+	files["big.yaml"] = strings.Repeat("#", maxConfigSize+1)
+	server := httpServer(t, files, &requests)
+
+	f := HTTPFetcher{BaseURL: server.URL + "/configs/"}
+	data, err := f.Fetch("Acme R2100/config.yaml")
+	if err != nil || string(data) != stationConfig {
+		t.Fatalf("fetch: %q %v", data, err)
+	}
+	if requests[0] != "/configs/Acme%20R2100/config.yaml" {
+		t.Errorf("request path: %s", requests[0])
+	}
+	if _, err := f.Fetch("missing.yaml"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("missing file: %v", err)
+	}
+	if _, err := f.Fetch("big.yaml"); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("oversized file: %v", err)
+	}
+}
+
+func TestFetchURLConditional(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v2"`)
+		fmt.Fprint(w, stationConfig)
+	}))
+	defer server.Close()
+
+	data, etag, err := FetchURL(server.Client(), server.URL, "")
+	if err != nil || string(data) != stationConfig || etag != `"v2"` {
+		t.Fatalf("first fetch: %q %q %v", data, etag, err)
+	}
+	if _, etag, err := FetchURL(server.Client(), server.URL, `"v2"`); !errors.Is(err, ErrNotModified) || etag != `"v2"` {
+		t.Errorf("unchanged: %q %v", etag, err)
+	}
+	if data, _, err := FetchURL(server.Client(), server.URL, `"v1"`); err != nil || string(data) != stationConfig {
+		t.Errorf("changed: %q %v", data, err)
+	}
+}
+
+func TestNewFetcher(t *testing.T) {
+	if f, err := NewFetcher("https://cfg.example/configs", nil); err != nil || f.Location("a/config.yaml") != "https://cfg.example/configs/a/config.yaml" {
+		t.Errorf("https: %v %v", f, err)
+	}
+	f, err := NewFetcher("cfg@logs:/srv/configs", []string{"-P", "2222"})
+	if scp, ok := f.(SCPFetcher); err != nil || !ok || scp.Server != "cfg@logs" || scp.Dir != "/srv/configs" || len(scp.Options) != 2 {
+		t.Errorf("scp: %+v %v", f, err)
+	}
+	if f, err := NewFetcher("cfg@logs:", nil); err != nil || f.Location("config.yaml") != "cfg@logs:config.yaml" {
+		t.Errorf("scp without a dir: %v %v", f, err)
+	}
+	for _, source := range []string{"logs:/srv/configs", "@logs:/srv", "cfg@:/srv", "ftp.example/configs"} {
+		if _, err := NewFetcher(source, nil); err == nil {
+			t.Errorf("%q accepted", source)
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	if got := strings.Join(Candidates("Acme R2100", "st 01/a"), ","); got != "Acme_R2100/st_01_a.yaml,Acme_R2100/config.yaml" {
+		t.Errorf("with station: %s", got)
+	}
+	if got := strings.Join(Candidates("../..", ""), ","); got != "default/config.yaml" {
+		t.Errorf("no product or station: %s", got)
+	}
+}
+
+// Конфиг станции предпочтительнее общего; без контрольной суммы или с неверной конфиг не принимается
+func TestDownload(t *testing.T) {
+	files := serverFiles(map[string]string{
+		"R2100/st01.yaml":   "station\n",
+		"R2100/config.yaml": "product\n",
+	})
+	scp := &fakeSCP{files: files}
+	data, name, sum, err := Download(scpFetcher(scp), Candidates("R2100", "st01"))
+	if err != nil || string(data) != "station\n" || name != "R2100/st01.yaml" || sum != Sum([]byte("station\n")) {
+		t.Fatalf("station config: %q %s %s %v", data, name, sum, err)
+	}
+	if data, _, _, err := Download(scpFetcher(scp), Candidates("R2100", "st02")); err != nil || string(data) != "product\n" {
+		t.Errorf("product config: %q %v", data, err)
+	}
+
+	// Только хеш, без имени файла, в верхнем регистре
+	files["R2100/st01.yaml.sha256"] = strings.ToUpper(Sum([]byte("station\n")))
+	if _, _, _, err := Download(scpFetcher(scp), Candidates("R2100", "st01")); err != nil {
+		t.Errorf("bare checksum: %v", err)
+	}
+
+	files["R2100/st01.yaml.sha256"] = Sum([]byte("tampered\n"))
+	_, _, _, err = Download(scpFetcher(scp), Candidates("R2100", "st01"))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for cfg@logs:/srv/configs/R2100/st01.yaml") {
+		t.Errorf("mismatch: %v", err)
+	}
+
+	files["R2100/st01.yaml.sha256"] = "not a checksum\n"
+	if _, _, _, err := Download(scpFetcher(scp), Candidates("R2100", "st01")); err == nil || !strings.Contains(err.Error(), "invalid checksum file") {
+		t.Errorf("invalid checksum file: %v", err)
+	}
+
+	// Конфиг станции без контрольной суммы не заменяется общим конфигом продукта
+	delete(files, "R2100/st01.yaml.sha256")
+	if _, _, _, err := Download(scpFetcher(scp), Candidates("R2100", "st01")); err == nil || !strings.Contains(err.Error(), "checksum for") {
+		t.Errorf("missing checksum: %v", err)
+	}
+
+	if _, _, _, err := Download(scpFetcher(scp), Candidates("R3000", "")); err == nil || !strings.Contains(err.Error(), "no configuration found") {
+		t.Errorf("no config: %v", err)
+	}
+}
+
+func testOptions(t *testing.T, f Fetcher) Options {
+	t.Helper()
+	return Options{
+		Fetcher:   f,
+		Cache:     Cache{Dir: filepath.Join(t.TempDir(), "cache")},
+		Product:   "R2100",
+		StationID: "st01",
+		Now:       func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+}
+
+func writeLocal(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Сервер доступен - remote; недоступен - последняя копия из кэша; кэша нет - локальный файл
+func TestResolveFallbackOrder(t *testing.T) {
+	local := writeLocal(t)
+	scp := &fakeSCP{files: serverFiles(map[string]string{"R2100/st01.yaml": stationConfig})}
+	opts := testOptions(t, scpFetcher(scp))
+
+	remote := Resolve(opts, local)
+	if remote.Source != SourceRemote || remote.FetchErr != nil || remote.Location != "cfg@logs:/srv/configs/R2100/st01.yaml" || remote.SHA256 != Sum([]byte(stationConfig)) {
+		t.Fatalf("remote: %+v", remote)
+	}
+	if data, _ := os.ReadFile(remote.Path); string(data) != stationConfig {
+		t.Errorf("cached copy: %q", data)
+	}
+
+	scp.files = nil
+	cached := Resolve(opts, local)
+	if cached.Source != SourceCache || cached.Path != remote.Path || cached.Location != remote.Location || !cached.FetchedAt.Equal(remote.FetchedAt) {
+		t.Errorf("cache: %+v", cached)
+	}
+	if cached.FetchErr == nil || !strings.Contains(cached.FetchErr.Error(), "No route to host") {
+		t.Errorf("cache fetch error: %v", cached.FetchErr)
+	}
+
+	// Испорченная копия в кэше не используется
+	if err := os.WriteFile(remote.Path, []byte("edited by hand\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fallback := Resolve(opts, local)
+	if fallback.Source != SourceLocal || fallback.Path != local || fallback.SHA256 != Sum([]byte("local\n")) || fallback.FetchErr == nil {
+		t.Errorf("local: %+v", fallback)
+	}
+}
+
+// Загруженный конфиг с неверной суммой или не прошедший проверку не вытесняет кэш
+func TestResolveKeepsCacheOnBadDownload(t *testing.T) {
+	local := writeLocal(t)
+	var requests []string
+	files := serverFiles(map[string]string{"R2100/st01.yaml": stationConfig})
+	server := httpServer(t, files, &requests)
+	opts := testOptions(t, HTTPFetcher{BaseURL: server.URL + "/configs"})
+	if result := Resolve(opts, local); result.Source != SourceRemote {
+		t.Fatalf("first fetch: %+v", result)
+	}
+
+	files["R2100/st01.yaml"] = "tests: [\n"
+	result := Resolve(opts, local)
+	if result.Source != SourceCache || result.FetchErr == nil || !strings.Contains(result.FetchErr.Error(), "checksum mismatch") {
+		t.Errorf("tampered download: %+v", result)
+	}
+	if data, _ := os.ReadFile(result.Path); string(data) != stationConfig {
+		t.Errorf("cache overwritten: %q", data)
+	}
+
+	files["R2100/st01.yaml.sha256"] = Sum([]byte(files["R2100/st01.yaml"]))
+	opts.Validate = func(data []byte) error {
+		if strings.Contains(string(data), "[") {
+			return errors.New("yaml: line 1: did not find expected node content")
+		}
+		return nil
+	}
+	result = Resolve(opts, local)
+	if result.Source != SourceCache || result.FetchErr == nil || !strings.Contains(result.FetchErr.Error(), "is invalid") {
+		t.Errorf("invalid download: %+v", result)
+	}
+	if data, _ := os.ReadFile(result.Path); string(data) != stationConfig {
+		t.Errorf("cache overwritten by an invalid config: %q", data)
+	}
+}
+
+func TestCacheKeysByStation(t *testing.T) {
+	cache := Cache{Dir: t.TempDir()}
+	meta := func(data string) Meta { return Meta{Location: "x", SHA256: Sum([]byte(data))} }
+	if _, err := cache.Store("R2100", "st01", []byte("one\n"), meta("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Store("R2100", "", []byte("product\n"), meta("product\n")); err != nil {
+		t.Fatal(err)
+	}
+	path, _, err := cache.Load("R2100", "st01")
+	if data, _ := os.ReadFile(path); err != nil || string(data) != "one\n" {
+		t.Errorf("station copy: %q %v", data, err)
+	}
+	if _, _, err := cache.Load("R2100", "st02"); err == nil {
+		t.Error("copy of another station returned")
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache.Dir, "*.tmp")); len(entries) != 0 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseModinfo(t *testing.T) {
+	for _, tc := range []struct {
+		file, version, srcversion string
+	}{
+		// In-tree модуль: version нет, подпись занимает несколько строк
+		{"igb.txt", "unknown", "0A2A4C5B79A0C5D27D0E1F3"},
+		// Внешний модуль: version есть, parm с именем version не путается с ней
+		{"pgdrv.txt", "1.0.0.18", "8F2D6C1B0E9A7734C51D0B2"},
+	} {
+		version, srcversion := parseModinfo(testdataFile(t, "modinfo", tc.file))
+		if version != tc.version || srcversion != tc.srcversion {
+			t.Errorf("%s: version %q srcversion %q, want %q %q", tc.file, version, srcversion, tc.version, tc.srcversion)
+		}
+	}
+	if version, srcversion := parseModinfo("modinfo: ERROR: Module igb not found.\n"); version != "unknown" || srcversion != "unknown" {
+		t.Errorf("error output: %q %q", version, srcversion)
+	}
+}
+
+func TestParseEthtoolInfo(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want InterfaceDriver
+	}{
+		{"i_igb.txt", InterfaceDriver{Interface: "eno1", Driver: "igb", Version: "6.1.0-18-amd64", FirmwareVersion: "3.25, 0x800005cc", BusInfo: "0000:02:00.0"}},
+		// N/A и пустые значения - unknown и пустой bus-info
+		{"i_r8169.txt", InterfaceDriver{Interface: "enp3s0", Driver: "r8169", Version: "6.1.0-18-amd64", FirmwareVersion: "unknown", BusInfo: "0000:03:00.0"}},
+		{"i_bond.txt", InterfaceDriver{Interface: "bond0", Driver: "bonding", Version: "6.1.0-18-amd64", FirmwareVersion: "2"}},
+	} {
+		if got := parseEthtoolInfo(tc.want.Interface, testdataFile(t, "ethtool", tc.file)); got != tc.want {
+			t.Errorf("%s: %+v, want %+v", tc.file, got, tc.want)
+		}
+	}
+	want := InterfaceDriver{Interface: "eno1", Driver: "unknown", Version: "unknown", FirmwareVersion: "unknown"}
+	if got := parseEthtoolInfo("eno1", "Cannot get driver information: Operation not supported\n"); got != want {
+		t.Errorf("error output: %+v", got)
+	}
+}
+
+// Недоступные утилита, modinfo и ethtool дают "unknown", а не ошибку; интерфейсы чужих драйверов не попадают
+func TestCollectDriverContext(t *testing.T) {
+	useRunner(t, &fakeRunner{commands: map[string]string{
+		"modinfo igb":     testdataFile(t, "modinfo", "igb.txt"),
+		"ethtool -i eno1": testdataFile(t, "ethtool", "i_igb.txt"),
+	}})
+	tool := toolVersionCommand{filepath.Join(t.TempDir(), "eeupdate64e"), []string{"/h"}}
+	interfaces := []NetworkInterface{
+		{Name: "lo"},
+		{Name: "eno1", Driver: "igb"},
+		{Name: "eno2", Driver: "igb"},
+		{Name: "enp3s0", Driver: "r8169"},
+	}
+	dc := collectDriverContext(tool, []string{"igb", "", "igb", "e1000e"}, interfaces)
+
+	if dc.ToolVersion != "unknown" {
+		t.Errorf("tool version: %q", dc.ToolVersion)
+	}
+	wantModules := []ModuleVersion{
+		{Name: "igb", Version: "unknown", SrcVersion: "0A2A4C5B79A0C5D27D0E1F3"},
+		{Name: "e1000e", Version: "unknown", SrcVersion: "unknown"},
+	}
+	if len(dc.Modules) != len(wantModules) || dc.Modules[0] != wantModules[0] || dc.Modules[1] != wantModules[1] {
+		t.Errorf("modules: %+v", dc.Modules)
+	}
+	wantBefore := []InterfaceDriver{
+		{Interface: "eno1", Driver: "igb", Version: "6.1.0-18-amd64", FirmwareVersion: "3.25, 0x800005cc", BusInfo: "0000:02:00.0"},
+		{Interface: "eno2", Driver: "igb", Version: "unknown", FirmwareVersion: "unknown"},
+	}
+	if len(dc.Before) != len(wantBefore) || dc.Before[0] != wantBefore[0] || dc.Before[1] != wantBefore[1] {
+		t.Errorf("interfaces: %+v", dc.Before)
+	}
+	if got := formatDriverContext(dc); got != "drivers: "+tool.Tool+" unknown, igb unknown (srcversion 0A2A4C5B79A0C5D27D0E1F3), e1000e unknown (srcversion unknown)" {
+		t.Errorf("details: %s", got)
+	}
+}
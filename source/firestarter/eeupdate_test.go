@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func eeupdateOutput(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "eeupdate", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// fakeEeupdate подкладывает eeupdate, отвечающий сохраненным выводом: discover - на /MAC_DUMP_ALL,
+// nics - на /NIC=N с аргументами исправления суммы. Код выхода 2 (нет драйвера) - как у реальной утилиты
+func fakeEeupdate(t *testing.T, discover string, nics map[int]string) {
+	t.Helper()
+	testdata, err := filepath.Abs(filepath.Join("testdata", "eeupdate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\ncase \"$*\" in\n")
+	fmt.Fprintf(&script, "/MAC_DUMP_ALL) cat '%s' ;;\n", filepath.Join(testdata, discover))
+	for index, name := range nics {
+		fmt.Fprintf(&script, "\"/NIC=%d /CALCCHKSUM\") cat '%s' ;;\n", index, filepath.Join(testdata, name))
+	}
+	script.WriteString("*) echo \"unexpected arguments: $*\"; exit 1 ;;\nesac\nexit 2\n")
+
+	dir := t.TempDir()
+	for _, name := range []string{"eeupdate64e", "eeupdate32e"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script.String()), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := eeupdateSearchPaths
+	eeupdateSearchPaths = []string{dir}
+	t.Cleanup(func() { eeupdateSearchPaths = saved })
+}
+
+func TestParseChecksumRepairGolden(t *testing.T) {
+	cases := []struct {
+		file, before, after string
+	}{
+		{"checksum_valid_v5.txt", "valid", "valid"},
+		{"checksum_repaired_v5.txt", "invalid", "repaired"},
+		// Старые версии пишут о сумме другими словами
+		{"checksum_repaired_v4.txt", "invalid", "repaired"},
+		{"checksum_failed.txt", "invalid", "failed"},
+	}
+	for _, c := range cases {
+		before, after := parseChecksumRepair(eeupdateOutput(t, c.file))
+		if before != c.before || after != c.after {
+			t.Errorf("%s: %s -> %s, want %s -> %s", c.file, before, after, c.before, c.after)
+		}
+	}
+}
+
+func TestDiscoverGolden(t *testing.T) {
+	fakeEeupdate(t, "mac_dump_all.txt", nil)
+	client, err := newEeupdateClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nics, err := client.Discover(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := intelNICIndices(nics); got != "1, 2, 3" {
+		t.Fatalf("NICs %s", got)
+	}
+	if nics[2].VendorDevice != "8086-1533" || nics[2].PCIAddress != "4:00.00" || nics[2].Description != "Intel(R) I210 Gigabit Network Connection" {
+		t.Errorf("NIC 3: %+v", nics[2])
+	}
+	if nics, err := client.Discover([]string{"8086-1533"}); err != nil || intelNICIndices(nics) != "3" {
+		t.Errorf("ven_device filter: %v %v", nics, err)
+	}
+}
+
+func TestRepairNICChecksums(t *testing.T) {
+	fakeEeupdate(t, "mac_dump_all.txt", map[int]string{
+		1: "checksum_repaired_v5.txt",
+		2: "checksum_valid_v5.txt",
+		3: "checksum_repaired_v4.txt",
+	})
+	result := repairNICChecksums(FlashConfig{})
+	if result.Status != "PASSED" || result.Details != "repaired NIC 1, 3" {
+		t.Fatalf("%s: %s", result.Status, result.Details)
+	}
+	var states []string
+	for _, nic := range result.NICs {
+		states = append(states, fmt.Sprintf("%d:%s:%s->%s", nic.Index, nic.VendorDevice, nic.Before, nic.After))
+	}
+	if got := strings.Join(states, " "); got != "1:8086-1521:invalid->repaired 2:8086-1521:valid->valid 3:8086-1533:invalid->repaired" {
+		t.Errorf("NICs %s", got)
+	}
+
+	// Фильтр ven_device: остальные карты не трогаются
+	result = repairNICChecksums(FlashConfig{VenDevice: []string{"8086-1533"}})
+	if result.Status != "PASSED" || result.Details != "repaired NIC 3" || len(result.NICs) != 1 {
+		t.Errorf("filtered: %s: %s (%d NICs)", result.Status, result.Details, len(result.NICs))
+	}
+}
+
+func TestRepairNICChecksumsHealthy(t *testing.T) {
+	fakeEeupdate(t, "mac_dump_all.txt", map[int]string{1: "checksum_valid_v5.txt", 2: "checksum_valid_v5.txt", 3: "checksum_valid_v5.txt"})
+	result := repairNICChecksums(FlashConfig{})
+	if result.Status != "SKIPPED" || result.Details != "EEPROM checksum already valid on 3 NIC(s)" {
+		t.Errorf("%s: %s", result.Status, result.Details)
+	}
+}
+
+func TestRepairNICChecksumsFailures(t *testing.T) {
+	fakeEeupdate(t, "mac_dump_all.txt", map[int]string{1: "checksum_failed.txt", 2: "checksum_valid_v5.txt", 3: "checksum_repaired_v4.txt"})
+	result := repairNICChecksums(FlashConfig{})
+	if result.Status != "FAILED" || result.Details != "checksum repair failed on NIC 1; repaired NIC 3" {
+		t.Errorf("%s: %s", result.Status, result.Details)
+	}
+
+	// Вывод без таблицы карт: Discover угадывает индексы, но проверять нечего
+	fakeEeupdate(t, "mac_dump_all_no_nics.txt", nil)
+	result = repairNICChecksums(FlashConfig{})
+	if result.Status != "FAILED" || len(result.NICs) != 0 || !strings.Contains(result.Details, "no Intel NICs") {
+		t.Errorf("no NICs: %s: %s (%d NICs)", result.Status, result.Details, len(result.NICs))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x5a17ed/uefi/efi/efiguid"
+	"github.com/0x5a17ed/uefi/efi/efivario"
+)
+
+func TestEFICleanupOrphanAllowlist(t *testing.T) {
+	ours := efiguid.MustFromString("11111111-2222-3333-4444-555555555555")
+	old := efiguid.MustFromString("a1b2c3d4-0000-1111-2222-333344445555")
+	foreign := efiguid.MustFromString("deadbeef-0000-1111-2222-333344445555")
+
+	items := map[string]EFIMaintenanceItem{
+		"boot":    {Kind: efiItemBootEntry, Name: "Boot0003"},
+		"ours":    {Kind: efiItemVariable, Name: "SerialNumber", GUID: ours},
+		"old":     {Kind: efiItemVariable, Name: "SerialNumber", GUID: old, Orphan: true},
+		"foreign": {Kind: efiItemVariable, Name: "SerialNumber", GUID: foreign, Orphan: true},
+	}
+	cases := []struct {
+		name   string
+		policy EFICleanupPolicy
+		want   []string
+	}{
+		// other_guids без списка только показывает чужие GUID
+		{"other_guids only", EFICleanupPolicy{OtherGUIDs: true, Variables: []string{"*"}}, []string{"ours"}},
+		{"allowlisted", EFICleanupPolicy{OtherGUIDs: true, OrphanGUIDs: []string{"A1B2C3D4-0000-1111-2222-333344445555"}}, []string{"old"}},
+		{"boot entries", EFICleanupPolicy{BootEntries: true, Variables: []string{"MacAddress"}}, []string{"boot"}},
+		{"named variable", EFICleanupPolicy{Variables: []string{"SerialNumber"}}, []string{"ours"}},
+	}
+	for _, c := range cases {
+		var got []string
+		for _, key := range []string{"boot", "ours", "old", "foreign"} {
+			if efiCleanupSelected(c.policy, items[key]) {
+				got = append(got, key)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(c.want, ",") {
+			t.Errorf("%s: deletes %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestValidateOrphanGUIDs(t *testing.T) {
+	for guid, ok := range map[string]bool{
+		"a1b2c3d4-0000-1111-2222-333344445555": true,
+		"not-a-guid":                           false,
+		"8be4df61-93ca-11d2-aa0d-00e098032b8c": false, // EFI global
+	} {
+		config := &Config{}
+		config.System.EFICleanup.OrphanGUIDs = []string{guid}
+		err := validateConfig(config)
+		if ok && err != nil && strings.Contains(err.Error(), "orphan_guids") || !ok && (err == nil || !strings.Contains(err.Error(), "orphan_guids")) {
+			t.Errorf("%s: %v", guid, err)
+		}
+	}
+}
+
+// memEFIContext - efivarfs в памяти: атрибуты и значение по имени переменной
+type memEFIContext struct {
+	vars map[string]memEFIVar
+}
+
+type memEFIVar struct {
+	attrs efivario.Attributes
+	data  []byte
+}
+
+func (c *memEFIContext) Close() error { return nil }
+
+func (c *memEFIContext) GetSizeHint(name string, guid efiguid.GUID) (int64, error) {
+	v, ok := c.vars[name+"-"+guid.String()]
+	if !ok {
+		return 0, efivario.ErrNotFound
+	}
+	return int64(len(v.data)), nil
+}
+
+func (c *memEFIContext) Get(name string, guid efiguid.GUID, out []byte) (efivario.Attributes, int, error) {
+	v, ok := c.vars[name+"-"+guid.String()]
+	if !ok {
+		return 0, 0, efivario.ErrNotFound
+	}
+	return v.attrs, copy(out, v.data), nil
+}
+
+func (c *memEFIContext) Set(name string, guid efiguid.GUID, attrs efivario.Attributes, value []byte) error {
+	c.vars[name+"-"+guid.String()] = memEFIVar{attrs: attrs, data: append([]byte{}, value...)}
+	return nil
+}
+
+func (c *memEFIContext) Delete(name string, guid efiguid.GUID) error {
+	delete(c.vars, name+"-"+guid.String())
+	return nil
+}
+
+func (c *memEFIContext) VariableNames() (efivario.VariableNameIterator, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestEFIBackupRestoresAttributes(t *testing.T) {
+	ctx := &memEFIContext{vars: map[string]memEFIVar{}}
+	m := &EFIVarManager{
+		GUID:  efiguid.MustFromString("11111111-2222-3333-4444-555555555555"),
+		ctx:   ctx,
+		attrs: efivario.NonVolatile | efivario.BootServiceAccess | efivario.RuntimeAccess,
+	}
+	key := "SerialNumber-" + m.GUID.String()
+	// Исходная переменная без RuntimeAccess
+	ctx.vars[key] = memEFIVar{attrs: efivario.NonVolatile | efivario.BootServiceAccess, data: []byte("OLD123")}
+
+	dir := t.TempDir()
+	backup := &efiBackup{Dir: dir, Serial: "MB123456", Session: "20260101-abcd"}
+	if err := setEFIVariable(m, "SerialNumber", "NEW456", "ascii", backup, 0); err != nil {
+		t.Fatal(err)
+	}
+	before := filepath.Join(dir, "MB123456_efi_SerialNumber_before_20260101-abcd.bin")
+	if len(backup.Files) != 2 || backup.Files[0] != before || !strings.HasSuffix(backup.Files[1], "_after_20260101-abcd.bin") {
+		t.Fatalf("dumps %v", backup.Files)
+	}
+	if attrs, _ := os.ReadFile(before + efiAttrsSuffix); string(attrs) != "0x00000003\n" {
+		t.Errorf("saved attributes %q", attrs)
+	}
+	if v := ctx.vars[key]; string(v.data) != "NEW456" || v.attrs != m.attrs {
+		t.Fatalf("after write: %+v", v)
+	}
+
+	if err := restoreEFIVariable(m, "SerialNumber", before); err != nil {
+		t.Fatal(err)
+	}
+	if v := ctx.vars[key]; string(v.data) != "OLD123" || v.attrs != efivario.NonVolatile|efivario.BootServiceAccess {
+		t.Errorf("restored %q with attrs 0x%X", v.data, uint32(v.attrs))
+	}
+
+	// Дамп старой версии без .attrs - атрибуты менеджера
+	old := filepath.Join(dir, "MB123456_efi_SerialNumber_before.bin")
+	if err := os.WriteFile(old, []byte("OLDEST"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreEFIVariable(m, "SerialNumber", old); err != nil {
+		t.Fatal(err)
+	}
+	if v := ctx.vars[key]; string(v.data) != "OLDEST" || v.attrs != m.attrs {
+		t.Errorf("restored legacy dump %q with attrs 0x%X", v.data, uint32(v.attrs))
+	}
+}
+
+// Повторная прошивка той же платы в другой сессии не затирает дампы первой
+func TestEFIBackupPathPerSession(t *testing.T) {
+	first := (&efiBackup{Dir: "/logs", Serial: "MB1", Session: "s1"}).path("SerialNumber", "before")
+	second := (&efiBackup{Dir: "/logs", Serial: "MB1", Session: "s2"}).path("SerialNumber", "before")
+	if first == second {
+		t.Fatalf("same dump path %s for two sessions", first)
+	}
+}
+
+func TestEFIRollbackDumpNames(t *testing.T) {
+	details := "Updated 2 variable(s) | EFI dumps: logs/MB1_efi_Serial_Number_before_20260101-abcd.bin, logs/MB1_efi_Serial_Number_after_20260101-abcd.bin, logs/MB1_efi_UUID_before.bin"
+	found := efiBeforeDumpRegex.FindAllString(details, -1)
+	if strings.Join(found, " ") != "logs/MB1_efi_Serial_Number_before_20260101-abcd.bin logs/MB1_efi_UUID_before.bin" {
+		t.Fatalf("dumps %q", found)
+	}
+	for path, want := range map[string]string{
+		found[0]:               "Serial_Number",
+		found[1]:               "UUID",
+		"MB1_serial_after.bin": "",
+	} {
+		if got, _ := efiBackupVarName(path); got != want {
+			t.Errorf("%s: %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func variantFlashConfig() FlashConfig {
+	return FlashConfig{
+		Enabled:        true,
+		Method:         "eeupdate",
+		VariantCommand: "echo $FIRESTARTER_TEST_VARIANT",
+		Fields: []FlashField{
+			{
+				Name: "System Serial", ID: "system-serial-number", Flash: false,
+				Variants: map[string]FlashFieldVariant{
+					"A": {Regex: `^A\d{4}$`},
+					"B": {Regex: `^B\d{4}$`},
+				},
+			},
+		},
+	}
+}
+
+func TestCloneFlashFieldsIsDeep(t *testing.T) {
+	fields := variantFlashConfig().Fields
+	clone := cloneFlashFields(fields)
+	pinFlashVariant(clone, "A")
+	clone[0].Name = "changed"
+
+	if fields[0].Name != "System Serial" || fields[0].Regex != "" || len(fields[0].Variants) != 2 {
+		t.Fatalf("original fields changed by pinning the clone: %+v", fields[0])
+	}
+	if clone[0].Regex != `^A\d{4}$` || clone[0].Variants != nil {
+		t.Fatalf("clone not pinned: %+v", clone[0])
+	}
+
+	clone = cloneFlashFields(fields)
+	clone[0].Variants["C"] = FlashFieldVariant{Regex: "x"}
+	if _, ok := fields[0].Variants["C"]; ok {
+		t.Fatal("clone shares the Variants map with the original")
+	}
+}
+
+// Пакетный режим: вариант первой строки не должен закрепляться для следующих
+func TestGetFlashDataDoesNotPinSharedVariant(t *testing.T) {
+	config := variantFlashConfig()
+	info := SystemInfo{Product: "TEST"}
+
+	rows := []struct {
+		variant, serial string
+	}{
+		{"A", "A0001"},
+		{"B", "B0002"},
+	}
+	for _, row := range rows {
+		t.Setenv("FIRESTARTER_TEST_VARIANT", row.variant)
+		data, err := getFlashData(config, SystemConfig{}, info, map[string]string{"system-serial-number": row.serial})
+		if err != nil {
+			t.Fatalf("row %s: %v", row.serial, err)
+		}
+		if data.Variant == nil || data.Variant.ID != row.variant {
+			t.Fatalf("row %s: variant %+v, want %s", row.serial, data.Variant, row.variant)
+		}
+		if data.SystemSerial != row.serial {
+			t.Fatalf("row %s: serial %q", row.serial, data.SystemSerial)
+		}
+	}
+	if config.Fields[0].Regex != "" || len(config.Fields[0].Variants) != 2 {
+		t.Fatalf("config fields mutated: %+v", config.Fields[0])
+	}
+}
+
+// Без оператора данных нет: операции, которым они нужны, пропускаются с причиной, а не прошивают пустые значения
+func TestFlashingWithoutFlashData(t *testing.T) {
+	saved := nonInteractive
+	nonInteractive = true
+	t.Cleanup(func() { nonInteractive = saved })
+
+	config := FlashConfig{
+		Enabled:    true,
+		Method:     "eeupdate",
+		Operations: []string{"mac", "efi", "fru"},
+		Fields: []FlashField{
+			{Name: "System Serial", ID: "system-serial-number", Regex: `^SN\d+$`, Flash: true},
+			{Name: "MAC Address", ID: "mac_address", Regex: `^[0-9A-F:]+$`, Flash: true},
+		},
+	}
+	data, err := getFlashData(config, SystemConfig{}, SystemInfo{Product: "TEST"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data == nil || data.Missing != noFlashDataReason || data.SystemSerial != "" || data.MAC != "" {
+		t.Fatalf("flash data = %+v", data)
+	}
+
+	results, changed := runFlashing(config, data, SystemConfig{}, t.TempDir())
+	if changed {
+		t.Error("serial reported as changed")
+	}
+	if len(results) != len(config.Operations) {
+		t.Fatalf("results = %+v", results)
+	}
+	for i, result := range results {
+		if result.Operation != config.Operations[i] || result.Status != "SKIPPED" || result.Details != noFlashDataReason {
+			t.Errorf("result %d = %+v", i, result)
+		}
+	}
+}
+
+// forceInteractive подменяет consoleIn символьным устройством, чтобы isInteractive() вернул true
+func forceInteractive(t *testing.T) {
+	t.Helper()
+	tty, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	saved, savedNonInteractive := consoleIn, nonInteractive
+	consoleIn, nonInteractive = tty, false
+	t.Cleanup(func() {
+		consoleIn, nonInteractive = saved, savedNonInteractive
+		tty.Close()
+	})
+}
+
+func reviewFields() (FlashConfig, map[string]string) {
+	config := FlashConfig{Enabled: true, Fields: []FlashField{{Name: "System Serial", ID: "system-serial-number", Regex: `^SN\d+$`}}}
+	return config, map[string]string{"system-serial-number": "SN1"}
+}
+
+func TestReviewFlashDataTimeout(t *testing.T) {
+	forceInteractive(t)
+	config, provided := reviewFields()
+
+	// Оператор отошел: ввода нет
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := reviewFlashData(ctx, 100*time.Millisecond, config, SystemConfig{}, SystemInfo{}, provided, bufio.NewReader(pr))
+	if err == nil || !strings.Contains(err.Error(), "input timeout") {
+		t.Fatalf("got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Fatalf("review waited %s", time.Since(start))
+	}
+}
+
+func TestReviewFlashDataEditTimeout(t *testing.T) {
+	forceInteractive(t)
+	config, provided := reviewFields()
+
+	// Выбрали Edit и не ввели новое значение
+	pr, pw := io.Pipe()
+	go pw.Write([]byte("E 1\n"))
+	defer pw.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	_, err := reviewFlashData(ctx, 200*time.Millisecond, config, SystemConfig{}, SystemInfo{}, provided, bufio.NewReader(pr))
+	if err == nil || !strings.Contains(err.Error(), "input timeout") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestReviewFlashDataContinue(t *testing.T) {
+	forceInteractive(t)
+	config, provided := reviewFields()
+
+	review, err := reviewFlashData(context.Background(), 0, config, SystemConfig{}, SystemInfo{}, provided,
+		bufio.NewReader(strings.NewReader("E 1\nSN2\nC\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if review.Confirmed["system-serial-number"] != "SN2" || len(review.Edits) != 1 {
+		t.Fatalf("got %+v", review)
+	}
+}
+
+// Лишний Enter (сканер шлет CR LF) не подтверждает данные
+func TestReviewFlashDataRequiresExplicitChoice(t *testing.T) {
+	forceInteractive(t)
+	config, provided := reviewFields()
+
+	review, err := reviewFlashData(context.Background(), 0, config, SystemConfig{}, SystemInfo{}, provided,
+		bufio.NewReader(strings.NewReader("\n  \nA\n")))
+	if !errors.Is(err, errFlashAborted) || !review.Aborted {
+		t.Fatalf("empty input confirmed the review: %+v, %v", review, err)
+	}
+
+	_, err = reviewFlashData(context.Background(), 0, config, SystemConfig{}, SystemInfo{}, provided,
+		bufio.NewReader(strings.NewReader("\n")))
+	if err == nil {
+		t.Fatal("review confirmed by an empty line before EOF")
+	}
+}
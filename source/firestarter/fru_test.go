@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeIpmitool подставляет в PATH ipmitool, который отвечает на fru print 0 снятым выводом
+// (с кодом выхода printExit), а на fru read 0 <файл> копирует снятый образ чипа
+func fakeIpmitool(t *testing.T, print string, printExit int, image string) {
+	t.Helper()
+	testdata, err := filepath.Abs(filepath.Join("testdata", "fru"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+case "$1 $2 $3" in
+"fru print 0") cat '%s'; exit %d ;;
+"fru read 0") cp '%s' "$4" ;;
+*) echo "unexpected arguments: $*"; exit 1 ;;
+esac
+`, filepath.Join(testdata, print), printExit, filepath.Join(testdata, image))
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ipmitool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestParseFRUFields(t *testing.T) {
+	fields := parseFRUFields(testdataFile(t, "fru", "print_healthy.txt"))
+	if len(fields) != 10 {
+		t.Fatalf("%d field(s): %+v", len(fields), fields)
+	}
+	// Значение с двоеточиями (дата) не режется, описание устройства в поля не попадает
+	if fields[0] != (FRUField{"Board Mfg Date", "Thu Jun 13 09:36:00 2024"}) || fields[3] != (FRUField{"Board Serial", "WM23AS004712"}) {
+		t.Errorf("fields: %+v", fields[:4])
+	}
+}
+
+// Решение о полной очистке: только пустой чип или испорченный common header; испорченную область
+// board перестраивает обычная запись FRU
+func TestAnalyzeFRUBinary(t *testing.T) {
+	for _, tc := range []struct {
+		image string
+		size  int // 0 - весь образ
+		want  string
+		code  int
+		blank bool
+	}{
+		{"healthy.bin", 0, "healthy", 0, false},
+		{"blank.bin", 0, "empty", 2, true},
+		{"header_bad.bin", 0, "common header checksum bad", 3, true},
+		{"board_bad.bin", 0, "board area checksum bad", 4, false},
+		{"healthy.bin", 100, "product area checksum bad", 4, false}, // Чип прочитан не полностью
+	} {
+		raw := []byte(testdataFile(t, "fru", tc.image))
+		if tc.size > 0 {
+			raw = raw[:tc.size]
+		}
+		status := &FRUStatus{CanRead: true, Raw: raw}
+		analyzeFRUBinary(raw, status)
+		health, code := status.Health()
+		if health != tc.want || code != tc.code || status.needsBlankFlash() != tc.blank {
+			t.Errorf("%s[%d]: %s (%d), blank flash %v; want %s (%d), %v",
+				tc.image, tc.size, health, code, status.needsBlankFlash(), tc.want, tc.code, tc.blank)
+		}
+	}
+}
+
+// checkFRUStatus по снятым выводам ipmitool здорового, пустого и испорченного чипов
+func TestCheckFRUStatus(t *testing.T) {
+	for _, tc := range []struct {
+		print     string
+		printExit int
+		image     string
+		want      string
+		fields    int
+		blank     bool
+	}{
+		{"print_healthy.txt", 0, "healthy.bin", "healthy", 10, false},
+		{"print_blank.txt", 1, "blank.bin", "empty", 0, true},
+		// ipmitool пропускает область с неверной суммой: по тексту чип выглядит пустым, по образу - нет
+		{"print_board_bad.txt", 0, "board_bad.bin", "board area checksum bad", 5, false},
+	} {
+		t.Run(tc.image, func(t *testing.T) {
+			fakeIpmitool(t, tc.print, tc.printExit, tc.image)
+			status, err := checkFRUStatus()
+			if err != nil {
+				t.Fatal(err)
+			}
+			health, _ := status.Health()
+			if health != tc.want || len(status.Fields) != tc.fields || status.needsBlankFlash() != tc.blank {
+				t.Errorf("%s, %d field(s), blank flash %v; want %s, %d, %v", health, len(status.Fields), status.needsBlankFlash(), tc.want, tc.fields, tc.blank)
+			}
+		})
+	}
+}
+
+func TestGetCurrentFRUSerial(t *testing.T) {
+	fakeIpmitool(t, "print_healthy.txt", 0, "healthy.bin")
+	if serial, err := getCurrentFRUSerial(); err != nil || serial != "WM23AS004712" {
+		t.Errorf("serial %q: %v", serial, err)
+	}
+	fakeIpmitool(t, "print_board_bad.txt", 0, "board_bad.bin")
+	if serial, err := getCurrentFRUSerial(); err == nil {
+		t.Errorf("serial %q read without a board area", serial)
+	}
+}
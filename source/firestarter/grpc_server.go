@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"firestarter/statuspb"
+
+	"google.golang.org/grpc"
+)
+
+// testResultEvents - завершенные тесты для StatusService (только с -grpc-addr, иначе nil).
+// В канал пишут runTest и параллельный запуск, читает statusServer.
+var testResultEvents chan TestResult
+
+// statusSrv - сервер статуса сессии, nil без -grpc-addr
+var statusSrv *statusServer
+
+// statusServer - реализация StatusService: текущее состояние сессии и подписчики на результаты тестов
+type statusServer struct {
+	statuspb.UnimplementedStatusServiceServer
+
+	mu          sync.Mutex
+	sessionID   string
+	product     string
+	serial      string
+	phase       string
+	started     time.Time
+	total       int
+	completed   int
+	passed      int
+	failed      int
+	skipped     int
+	active      map[string]int // Выполняемые сейчас тесты (имя -> число запусков)
+	subscribers map[chan *statuspb.TestResultEvent]struct{}
+}
+
+// startStatusServer поднимает gRPC StatusService на addr и начинает разбирать testResultEvents
+func startStatusServer(addr, sessionID, product, serial string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+
+	srv := &statusServer{
+		sessionID:   sessionID,
+		product:     product,
+		serial:      serial,
+		phase:       "starting",
+		started:     time.Now(),
+		active:      make(map[string]int),
+		subscribers: make(map[chan *statuspb.TestResultEvent]struct{}),
+	}
+	events := make(chan TestResult, 64)
+	grpcServer := grpc.NewServer()
+	statuspb.RegisterStatusServiceServer(grpcServer, srv)
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			printWarning(fmt.Sprintf("gRPC status server stopped: %v", err))
+		}
+	}()
+	go srv.consume(events)
+
+	statusSrv = srv
+	testResultEvents = events
+
+	// При выходе завершаем потоки подписчиков, чтобы дашборд увидел конец сессии
+	addShutdownHook(func() {
+		srv.setPhase("done")
+		srv.closeSubscribers()
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(2 * time.Second):
+			grpcServer.Stop()
+		}
+	})
+
+	printInfo(fmt.Sprintf("gRPC status server listening on %s", listener.Addr()))
+	return nil
+}
+
+// consume обновляет счетчики по завершенным тестам и рассылает события подписчикам
+func (s *statusServer) consume(events <-chan TestResult) {
+	for result := range events {
+		s.mu.Lock()
+		s.completed++
+		switch result.Status {
+		case "PASSED":
+			s.passed++
+		case "SKIPPED":
+			s.skipped++
+		default:
+			s.failed++
+		}
+		event := &statuspb.TestResultEvent{
+			SessionId:      s.sessionID,
+			Name:           result.Name,
+			Status:         result.Status,
+			DurationMs:     result.Duration.Milliseconds(),
+			Error:          result.Error,
+			Required:       result.Required,
+			Attempts:       int32(result.Attempts),
+			FinishedUnixMs: time.Now().UnixMilli(),
+		}
+		for ch := range s.subscribers {
+			// Медленный клиент не тормозит тесты - событие для него теряется
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// StreamTestResults отдает клиенту результаты тестов, завершившихся после подключения
+func (s *statusServer) StreamTestResults(_ *statuspb.Empty, stream grpc.ServerStreamingServer[statuspb.TestResultEvent]) error {
+	ch := make(chan *statuspb.TestResultEvent, 32)
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if _, ok := s.subscribers[ch]; ok {
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// GetCurrentSession возвращает фазу, счетчики и выполняемые сейчас тесты
+func (s *statusServer) GetCurrentSession(context.Context, *statuspb.Empty) (*statuspb.SessionStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var active []string
+	for name := range s.active {
+		active = append(active, name)
+	}
+	sort.Strings(active)
+
+	return &statuspb.SessionStatus{
+		SessionId:      s.sessionID,
+		Product:        s.product,
+		Serial:         s.serial,
+		Phase:          s.phase,
+		ActiveTest:     strings.Join(active, ", "),
+		TestsTotal:     int32(s.total),
+		TestsCompleted: int32(s.completed),
+		Passed:         int32(s.passed),
+		Failed:         int32(s.failed),
+		Skipped:        int32(s.skipped),
+		StartedUnixMs:  s.started.UnixMilli(),
+	}, nil
+}
+
+func (s *statusServer) setPhase(phase string) {
+	s.mu.Lock()
+	s.phase = phase
+	s.mu.Unlock()
+}
+
+// closeSubscribers завершает все потоки StreamTestResults; новые подписки после этого не принимаются
+func (s *statusServer) closeSubscribers() {
+	s.mu.Lock()
+	for ch := range s.subscribers {
+		close(ch)
+	}
+	s.subscribers = nil
+	s.mu.Unlock()
+}
+
+// publishTestResult передает итог теста (после всех повторов) серверу статуса
+func publishTestResult(result TestResult) {
+	if testResultEvents != nil {
+		testResultEvents <- result
+	}
+}
+
+// setSessionPhase отмечает текущую фазу сессии (tests, flash, finishing)
+func setSessionPhase(phase string) {
+	if statusSrv != nil {
+		statusSrv.setPhase(phase)
+	}
+}
+
+// addPlannedTests увеличивает число тестов сессии, о котором сообщает GetCurrentSession
+func addPlannedTests(n int) {
+	if statusSrv != nil {
+		statusSrv.mu.Lock()
+		statusSrv.total += n
+		statusSrv.mu.Unlock()
+	}
+}
+
+// setSessionSerial обновляет серийный номер после ввода данных прошивки
+func setSessionSerial(serial string) {
+	if statusSrv != nil && serial != "" {
+		statusSrv.mu.Lock()
+		statusSrv.serial = serial
+		statusSrv.mu.Unlock()
+	}
+}
+
+// markTestActive отмечает начало попытки теста; возвращает функцию для отметки завершения
+func markTestActive(name string) func() {
+	if statusSrv == nil {
+		return func() {}
+	}
+	statusSrv.mu.Lock()
+	statusSrv.active[name]++
+	statusSrv.mu.Unlock()
+
+	return func() {
+		statusSrv.mu.Lock()
+		if statusSrv.active[name]--; statusSrv.active[name] <= 0 {
+			delete(statusSrv.active, name)
+		}
+		statusSrv.mu.Unlock()
+	}
+}
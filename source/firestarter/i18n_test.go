@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// readCatalogs разбирает встроенные каталоги напрямую: loadMessageCatalogs молча пропускает битый файл
+func readCatalogs(t *testing.T) map[string]map[string]string {
+	t.Helper()
+	files, err := messageCatalogFS.ReadDir("i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	catalogs := make(map[string]map[string]string)
+	for _, file := range files {
+		data, err := messageCatalogFS.ReadFile("i18n/" + file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			t.Fatalf("%s: %v", file.Name(), err)
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".yaml")] = messages
+	}
+	if len(catalogs) < 2 || catalogs[defaultLanguage] == nil {
+		t.Fatalf("catalogs: %d, %s present: %v", len(catalogs), defaultLanguage, catalogs[defaultLanguage] != nil)
+	}
+	return catalogs
+}
+
+var formatVerbRegex = regexp.MustCompile(`%[-+# 0]*[0-9]*(?:\.[0-9]+)?[a-zA-Z]`)
+
+// Ключи всех каталогов совпадают в обе стороны, и у каждого перевода те же подстановки, что в английском
+func TestMessageCatalogParity(t *testing.T) {
+	catalogs := readCatalogs(t)
+	var languages []string
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	for _, language := range languages {
+		for _, other := range languages {
+			if language == other {
+				continue
+			}
+			var missing []string
+			for key := range catalogs[language] {
+				if _, ok := catalogs[other][key]; !ok {
+					missing = append(missing, key)
+				}
+			}
+			sort.Strings(missing)
+			if len(missing) > 0 {
+				t.Errorf("keys in %s.yaml but not in %s.yaml: %s", language, other, strings.Join(missing, ", "))
+			}
+		}
+	}
+
+	for key, english := range catalogs[defaultLanguage] {
+		want := strings.Join(formatVerbRegex.FindAllString(english, -1), " ")
+		for _, language := range languages {
+			translated, ok := catalogs[language][key]
+			if !ok {
+				continue
+			}
+			if got := strings.Join(formatVerbRegex.FindAllString(translated, -1), " "); got != want {
+				t.Errorf("%s.yaml %s: format verbs %q, en.yaml has %q", language, key, got, want)
+			}
+		}
+	}
+}
+
+// Каждый ID, переданный в tr в коде, есть в английском каталоге: иначе оператор увидит сам ID
+func TestTranslatedIDsExist(t *testing.T) {
+	catalogs := readCatalogs(t)
+	sources, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idRegex := regexp.MustCompile(`\btr\("([^"]+)"`)
+	found := 0
+	for _, source := range sources {
+		if strings.HasSuffix(source, "_test.go") {
+			continue
+		}
+		data, err := os.ReadFile(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range idRegex.FindAllStringSubmatch(string(data), -1) {
+			found++
+			if _, ok := catalogs[defaultLanguage][match[1]]; !ok {
+				t.Errorf("%s: tr(%q) has no entry in en.yaml", source, match[1])
+			}
+		}
+	}
+	if found == 0 {
+		t.Error("no tr calls found")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func parseIperf3Fixture(t *testing.T, name string, direction string) NetworkResult {
+	t.Helper()
+	measured, err := parseIperf3Result([]byte(testdataFile(t, "iperf3", name)), NetworkResult{Server: "10.0.0.1", Protocol: "tcp", Direction: direction})
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return measured
+}
+
+func describeNetwork(n NetworkResult) string {
+	return fmt.Sprintf("%s %s %.1f/%.1f Mbit/s, %d retransmits, loss %.2f%%, jitter %.3f ms",
+		n.Protocol, n.Direction, n.Mbps, n.ReverseMbps, n.Retransmits, n.LostPercent, n.JitterMs)
+}
+
+// Скорость берется по принятым данным, направление - из самого отчета (-R и --bidir)
+func TestParseIperf3Result(t *testing.T) {
+	for _, tc := range []struct {
+		fixture   string
+		direction string // из конфига
+		want      string
+	}{
+		{"tcp_upload.json", "upload", "tcp upload 937.2/0.0 Mbit/s, 12 retransmits, loss 0.00%, jitter 0.000 ms"},
+		{"tcp_reverse.json", "upload", "tcp download 940.1/0.0 Mbit/s, 0 retransmits, loss 0.00%, jitter 0.000 ms"},
+		{"tcp_bidir.json", "bidir", "tcp bidir 883.7/696.8 Mbit/s, 38 retransmits, loss 0.00%, jitter 0.000 ms"},
+		{"udp.json", "upload", "udp upload 500.0/0.0 Mbit/s, 0 retransmits, loss 0.30%, jitter 0.021 ms"},
+	} {
+		if got := describeNetwork(parseIperf3Fixture(t, tc.fixture, tc.direction)); got != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.fixture, got, tc.want)
+		}
+	}
+}
+
+func TestEvaluateIperf3Thresholds(t *testing.T) {
+	for _, tc := range []struct {
+		fixture string
+		spec    Iperf3Spec
+		want    string // Error; пусто - PASSED
+	}{
+		{"tcp_upload.json", Iperf3Spec{MinMbps: 900, MaxRetransmits: 50}, ""},
+		{"tcp_upload.json", Iperf3Spec{MinMbps: 950, MaxRetransmits: 10}, "throughput 937.2 Mbit/s below 950.0; 12 retransmits exceed 10"},
+		// bidir: порог по худшему направлению
+		{"tcp_bidir.json", Iperf3Spec{MinMbps: 700}, "throughput 696.8 Mbit/s below 700.0"},
+		{"udp.json", Iperf3Spec{MinMbps: 400, MaxLossPercent: 1}, ""},
+		{"udp.json", Iperf3Spec{MaxLossPercent: 0.1}, "loss 0.30% exceeds 0.10%"},
+	} {
+		result := TestResult{Status: "PASSED"}
+		info := &NetworkResult{Server: "10.0.0.1", Protocol: "tcp", Direction: "upload"}
+		output := evaluateIperf3Result(&result, info, &tc.spec, []byte(testdataFile(t, "iperf3", tc.fixture)), "")
+		wantStatus := "PASSED"
+		if tc.want != "" {
+			wantStatus = "FAILED"
+		}
+		if result.Status != wantStatus || result.Error != tc.want {
+			t.Errorf("%s %+v: %s %q, want %s %q", tc.fixture, tc.spec, result.Status, result.Error, wantStatus, tc.want)
+		}
+		if result.Network == nil || !strings.Contains(output, "Throughput") {
+			t.Errorf("%s: no structured result or summary:\n%s", tc.fixture, output)
+		}
+	}
+}
+
+// Недоступный сервер и отсутствующий iperf3 - разные понятные ошибки
+func TestIperf3Errors(t *testing.T) {
+	result := TestResult{Status: "FAILED"}
+	info := &NetworkResult{Server: "10.0.0.1", Protocol: "tcp", Direction: "upload"}
+	evaluateIperf3Result(&result, info, &Iperf3Spec{}, []byte(testdataFile(t, "iperf3", "error_connect.json")), "")
+	if result.Error != "iperf3 server 10.0.0.1 unreachable: unable to connect to server: Connection refused" {
+		t.Errorf("unreachable server: %q", result.Error)
+	}
+
+	result = TestResult{Status: "FAILED"}
+	evaluateIperf3Result(&result, info, &Iperf3Spec{}, []byte("iperf3: error - unable to connect to server"), "")
+	if !strings.HasPrefix(result.Error, "failed to parse iperf3 JSON output") {
+		t.Errorf("non-JSON output: %q", result.Error)
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, _, _, _, err := buildIperf3Command(&Iperf3Spec{Server: "10.0.0.1"}); err == nil || !strings.Contains(err.Error(), "iperf3 binary not found") {
+		t.Errorf("missing binary: %v", err)
+	}
+}
@@ -4,20 +4,42 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"embed"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	texttemplate "text/template"
 	"time"
+	"unicode/utf8"
+
+	"firestarter/configsource"
+	"firestarter/runner"
+	"firestarter/smart"
 
 	"github.com/0x5a17ed/uefi/efi/efiguid"
 	"github.com/0x5a17ed/uefi/efi/efivario"
+	"golang.org/x/text/encoding/unicode"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,10 +66,45 @@ const (
 
 // Configuration structures
 type Config struct {
-	System SystemConfig `yaml:"system"`
-	Tests  TestsConfig  `yaml:"tests"`
-	Flash  FlashConfig  `yaml:"flash,omitempty"`
-	Log    LogConfig    `yaml:"log"`
+	System   SystemConfig   `yaml:"system"`
+	Tests    TestsConfig    `yaml:"tests"`
+	Flash    FlashConfig    `yaml:"flash,omitempty"`
+	Log      LogConfig      `yaml:"log"`
+	Pipeline PipelineConfig `yaml:"pipeline,omitempty"`
+	UI       UIConfig       `yaml:"ui,omitempty"`
+
+	Include     []string            `yaml:"include,omitempty"`      // Файлы с общими библиотеками тестов (пути относительно конфига)
+	TestLibrary map[string]TestSpec `yaml:"test_library,omitempty"` // Именованные тесты для ссылок "use: <name>"
+
+	ConfigSource *ConfigSourceConfig `yaml:"config_source,omitempty"` // Центральный конфиг станции на сервере
+}
+
+// ConfigSourceConfig - откуда загружать конфиг станции при старте (локальный файл - запасной вариант)
+type ConfigSourceConfig struct {
+	URL      string `yaml:"url,omitempty"`       // https://host/configs или user@host:path; пусто - <log.server>:<log.server_dir>/configs
+	CacheDir string `yaml:"cache_dir,omitempty"` // Последняя проверенная загрузка (по умолчанию /var/lib/firestarter/config-cache)
+}
+
+// UIConfig - настройки интерфейса оператора
+type UIConfig struct {
+	Language string `yaml:"language,omitempty"` // en или ru; пусто - по переменной LANG
+	Mode     string `yaml:"mode,omitempty"`     // compact - одна строка статуса вместо вывода тестов (как -quiet)
+}
+
+// PipelineConfig задает порядок фаз: "tests", "flash", "tests:<group>", "flash:<operation>"
+type PipelineConfig struct {
+	Order []string `yaml:"order,omitempty"`
+
+	FinishAction    string `yaml:"finish_action,omitempty"`    // prompt (по умолчанию), reboot, shutdown, none, reboot-if-serial-changed
+	FinishCountdown *int   `yaml:"finish_countdown,omitempty"` // Секунд на отмену автоматического действия (по умолчанию 10, 0 - без отсчета)
+}
+
+// EFICleanupPolicy - что удаляет -efi-cleanup (BootOrder/BootCurrent и чужие переменные не удаляются никогда)
+type EFICleanupPolicy struct {
+	BootEntries bool     `yaml:"boot_entries,omitempty"` // Записи efibootmgr с меткой efi_boot_entry_label
+	Variables   []string `yaml:"variables,omitempty"`    // Переменные под guid_prefix; "*" - все
+	OtherGUIDs  bool     `yaml:"other_guids,omitempty"`  // efi_sn_name/efi_mac_name под другими GUID (старые guid_prefix); в -efi-maintenance - показывать их
+	OrphanGUIDs []string `yaml:"orphan_guids,omitempty"` // GUID, под которыми -efi-cleanup удаляет efi_sn_name/efi_mac_name (остальные только перечисляются)
 }
 
 type SystemConfig struct {
@@ -58,12 +115,115 @@ type SystemConfig struct {
 	EfiSnName    string `yaml:"efi_sn_name"`
 	EfiMacName   string `yaml:"efi_mac_name"`
 	DriverDir    string `yaml:"driver_dir"`
+
+	EFIVarEncoding             string           `yaml:"efi_var_encoding,omitempty"`               // "ascii" (по умолчанию) или "utf16le"
+	EFICleanup                 EFICleanupPolicy `yaml:"efi_cleanup,omitempty"`                    // Что удаляет -efi-cleanup
+	EFIVariableReadBackTimeout string           `yaml:"efi_variable_read_back_timeout,omitempty"` // Сколько ждать появления переменной после записи (по умолчанию 2s)
+	DriverUnloadTimeoutSeconds int              `yaml:"driver_unload_timeout_seconds,omitempty"`  // Таймаут одного rmmod (по умолчанию 10)
+	PCIRescanPath              string           `yaml:"pci_rescan_path,omitempty"`                // Файл пересканирования PCI (по умолчанию /sys/bus/pci/rescan)
+
+	Identification ProductIdentification `yaml:"identification,omitempty"` // Альтернативные признаки продукта
+
+	RequireLiveEnvironment bool   `yaml:"require_live_environment,omitempty"` // Прошивка только из live/provisioning образа
+	LiveMarkerPath         string `yaml:"live_marker_path,omitempty"`         // Файл-маркер live образа (кроме airootfs/loop)
+
+	FixClock            bool   `yaml:"fix_clock,omitempty"`              // Исправлять системное время по NTP/BMC при расхождении
+	NTPServer           string `yaml:"ntp_server,omitempty"`             // Эталон времени; без него - часы BMC
+	MaxClockSkewMinutes int    `yaml:"max_clock_skew_minutes,omitempty"` // Допустимое расхождение с эталоном (по умолчанию 5)
+	ClockFloor          string `yaml:"clock_floor,omitempty"`            // Время раньше этой даты заведомо неверно (по умолчанию дата сборки)
+
+	MinBIOSVersion       string `yaml:"min_bios_version,omitempty"`        // На более старых BIOS запись EFI переменных молча теряется
+	BIOSVersionCheckMode string `yaml:"bios_version_check_mode,omitempty"` // "warn" (по умолчанию) или "abort"
+
+	EeupdateSearchPaths []string `yaml:"eeupdate_search_paths,omitempty"` // Каталоги с eeupdate64e/eeupdate32e (просматриваются до PATH)
+
+	EFIShellPath      string `yaml:"efi_shell_path,omitempty"`       // Загрузчик одноразовой записи после смены серийного номера (по умолчанию \EFI\BOOT\shellx64.efi -delay:0)
+	EFIBootEntryLabel string `yaml:"efi_boot_entry_label,omitempty"` // Метка этой записи в efibootmgr (по умолчанию OneTimeBoot)
+}
+
+// ProductIdentification задает признаки, по которым плата считается совместимой с конфигурацией.
+// Без identifiers проверяется только product (как раньше).
+type ProductIdentification struct {
+	Match       string              `yaml:"match,omitempty"` // "any" (по умолчанию) или "all"
+	Identifiers []ProductIdentifier `yaml:"identifiers"`     // В порядке приоритета
+}
+
+type ProductIdentifier struct {
+	Type    string   `yaml:"type"`              // product_name, baseboard_product, baseboard_manufacturer, bios_version, pci_devices
+	Value   string   `yaml:"value,omitempty"`   // Точное значение (bios_version - регулярное выражение)
+	Devices []string `yaml:"devices,omitempty"` // vendor:device, которые должны присутствовать (pci_devices)
 }
 
 type TestsConfig struct {
-	Timeout          string       `yaml:"timeout,omitempty"`
-	ParallelGroups   [][]TestSpec `yaml:"parallel_groups,omitempty"`
-	SequentialGroups [][]TestSpec `yaml:"sequential_groups,omitempty"`
+	Timeout          string          `yaml:"timeout,omitempty"`
+	ParallelGroups   []TestGroupSpec `yaml:"parallel_groups,omitempty"`
+	SequentialGroups []TestGroupSpec `yaml:"sequential_groups,omitempty"`
+	ShowResources    bool            `yaml:"show_resources,omitempty"` // Показывать память/CPU тестов в итогах групп
+	MaxRetries       int             `yaml:"max_retries,omitempty"`    // Попыток упавшего теста с вопросом оператору (по умолчанию 5)
+	MaxParallel      int             `yaml:"max_parallel,omitempty"`   // Одновременно выполняемых тестов параллельной группы (0 - все)
+
+	SMART SMARTConfig `yaml:"smart,omitempty"` // Снимки SMART дисков до и после тестов (тест smart-delta)
+
+	// Повтор после TIMEOUT идет с таймаутом, умноженным на timeout_retry_multiplier (0 или 1 - без увеличения),
+	// но не больше max_timeout (пусто - 4 исходных таймаута теста). Тест может задать свои значения
+	TimeoutRetryMultiplier float64 `yaml:"timeout_retry_multiplier,omitempty"`
+	MaxTimeout             string  `yaml:"max_timeout,omitempty"`
+
+	ExcludeSkippedFromRate bool `yaml:"exclude_skipped_from_rate,omitempty"` // Процент успешных считается без пропущенных тестов
+
+	DefaultTags []string `yaml:"default_tags,omitempty"` // Выполнять только тесты с любым из этих тегов, если не задан -tags
+
+	// Понятные имена для resources тестов: "scratch-disk" -> "disk:nvme0n1"
+	ResourceAliases map[string]string `yaml:"resource_aliases,omitempty"`
+
+	// Команда, печатающая YAML список тестов (например "discover-tests --product Silver", без shell).
+	// Тесты добавляются в группу test_generator_group: parallel1, sequential2, ... (по умолчанию sequential1)
+	TestGeneratorCommand string `yaml:"test_generator_command,omitempty"`
+	TestGeneratorGroup   string `yaml:"test_generator_group,omitempty"`
+
+	// Группы, которым нужен уже прошитый серийный номер: выполняются после перезагрузки запуском -continue
+	PostRebootGroups   [][]TestSpec `yaml:"post_reboot_groups,omitempty"`
+	ContinuationMaxAge string       `yaml:"continuation_max_age,omitempty"` // Старше - файл продолжения игнорируется (по умолчанию 24h)
+}
+
+// SMARTConfig - снимки SMART всех дисков (кроме загрузочного) до и после тестов. Тест smart-delta
+// не проходит, если показатель ухудшился больше допустимого или диск пропал
+type SMARTConfig struct {
+	Enabled                bool  `yaml:"enabled"`
+	MaxReallocatedIncrease int64 `yaml:"max_reallocated_increase,omitempty"`  // Допустимый прирост переназначенных секторов (по умолчанию 0)
+	MaxMediaErrorsIncrease int64 `yaml:"max_media_errors_increase,omitempty"` // Допустимый прирост ошибок носителя (по умолчанию 0)
+	MaxWearIncrease        int64 `yaml:"max_wear_increase_percent,omitempty"` // Допустимый прирост износа, % (по умолчанию 0)
+	MaxTemperatureCelsius  int64 `yaml:"max_temperature_celsius,omitempty"`   // Предел максимальной температуры диска за прогон (0 - не проверяется)
+}
+
+// TestGroupSpec - группа тестов. В конфиге группа - либо просто список тестов (старый формат),
+// либо объект с tests и настройками группы
+type TestGroupSpec struct {
+	Name          string     `yaml:"name,omitempty"` // Заголовок в выводе (по умолчанию "Parallel Group N"); годится и для pipeline.order
+	Tests         []TestSpec `yaml:"tests"`
+	Timeout       string     `yaml:"timeout,omitempty"`        // Таймаут тестов группы без своего timeout (вместо tests.timeout)
+	SkipCondition string     `yaml:"skip_condition,omitempty"` // Команда shell: код 0 - группа пропускается, тесты SKIPPED
+	MaxParallel   int        `yaml:"max_parallel,omitempty"`   // Вместо tests.max_parallel для этой группы
+	Tags          []string   `yaml:"tags,omitempty"`           // Теги тестов группы без своих tags
+}
+
+// UnmarshalYAML принимает и список тестов, и объект группы
+func (g *TestGroupSpec) UnmarshalYAML(value *yaml.Node) error {
+	if resolveYAMLNode(value).Kind == yaml.SequenceNode {
+		*g = TestGroupSpec{}
+		return value.Decode(&g.Tests)
+	}
+	type plain TestGroupSpec
+	return value.Decode((*plain)(g))
+}
+
+// groupTests возвращает списки тестов групп (срезы общие с конфигом - изменения тестов видны в нем)
+func groupTests(groups []TestGroupSpec) [][]TestSpec {
+	lists := make([][]TestSpec, len(groups))
+	for i := range groups {
+		lists[i] = groups[i].Tests
+	}
+	return lists
 }
 
 type TestSpec struct {
@@ -74,6 +234,73 @@ type TestSpec struct {
 	Timeout  string   `yaml:"timeout,omitempty"`
 	Required bool     `yaml:"required"`
 	Collapse bool     `yaml:"collapse,omitempty"` // Новое поле: если true — при успехе не показываем вывод
+	Use      string   `yaml:"use,omitempty"`      // Ссылка на test_library; остальные поля переопределяют библиотечные
+
+	TimeoutRetryMultiplier float64 `yaml:"timeout_retry_multiplier,omitempty"` // Вместо tests.timeout_retry_multiplier
+	MaxTimeout             string  `yaml:"max_timeout,omitempty"`              // Вместо tests.max_timeout
+
+	Iperf3 *Iperf3Spec `yaml:"iperf3,omitempty"` // Параметры встроенного теста type: iperf3
+
+	Description string `yaml:"description,omitempty"` // Что проверяет тест (в выводе, итогах и HTML отчете)
+
+	SkipCommandValidation bool `yaml:"skip_command_validation,omitempty"` // Не проверять command при старте (утилиты, которых может не быть)
+
+	// Эксклюзивные ресурсы ("disk:nvme0n1", "gpu", "port:eth1" или алиас из tests.resource_aliases):
+	// тесты параллельной группы с общим ресурсом выполняются по очереди, остальные - одновременно
+	Resources []string `yaml:"resources,omitempty"`
+
+	Tags []string `yaml:"tags,omitempty"` // Роли станций (quick, burnin, thermal) для -tags/-exclude-tags; пусто - tags группы
+
+	// Составной тест: шаги выполняются по порядку в пределах timeout теста (вместо command);
+	// повтор теста выполняет все шаги с начала
+	Steps []TestStep `yaml:"steps,omitempty"`
+
+	compiledArgs []*texttemplate.Template // Шаблоны args ({{.MBSerial}} и т.п.), разобранные в validateConfig; nil - аргумент без шаблона
+}
+
+// TestStep - шаг составного теста (configure, stimulate, measure, cleanup)
+type TestStep struct {
+	Name        string   `yaml:"name,omitempty"` // По умолчанию "step N"
+	Command     string   `yaml:"command"`
+	Args        []string `yaml:"args,omitempty"`
+	Timeout     string   `yaml:"timeout,omitempty"`      // Предел шага; общий таймаут теста действует всегда
+	PassPattern string   `yaml:"pass_pattern,omitempty"` // Regex: шаг прошел, только если вывод совпал
+	FailPattern string   `yaml:"fail_pattern,omitempty"` // Regex: совпадение в выводе - провал даже при коде 0
+
+	ContinueOnFail bool `yaml:"continue_on_fail,omitempty"` // Провал шага записывается, но не валит тест и не останавливает шаги
+
+	compiledArgs []*texttemplate.Template
+	passRegex    *regexp.Regexp
+	failRegex    *regexp.Regexp
+}
+
+// commandLine - команда шага для вывода и лога
+func (s TestStep) commandLine() string {
+	return strings.TrimSpace(s.Command + " " + strings.Join(s.Args, " "))
+}
+
+// StepResult - итог шага составного теста в логе
+type StepResult struct {
+	Name     string        `yaml:"name"`
+	Status   string        `yaml:"status"` // PASSED, FAILED, TIMEOUT; SKIPPED - не запускался после провала
+	Duration time.Duration `yaml:"duration"`
+	Error    string        `yaml:"error,omitempty"`
+}
+
+// Iperf3Spec - встроенный тест пропускной способности до iperf3 сервера (command не нужен)
+type Iperf3Spec struct {
+	Server         string  `yaml:"server"`
+	Port           int     `yaml:"port,omitempty"`
+	Duration       string  `yaml:"duration,omitempty"`         // По умолчанию 10s
+	Direction      string  `yaml:"direction,omitempty"`        // upload (по умолчанию), download (-R), bidir
+	UDP            bool    `yaml:"udp,omitempty"`              // UDP вместо TCP
+	Bandwidth      string  `yaml:"bandwidth,omitempty"`        // Целевая скорость (-b), для UDP обязательно разумное значение
+	Parallel       int     `yaml:"parallel,omitempty"`         // Число потоков (-P)
+	MinMbps        float64 `yaml:"min_mbps,omitempty"`         // Минимальная скорость, Мбит/с
+	MaxRetransmits int     `yaml:"max_retransmits,omitempty"`  // TCP: максимум ретрансмитов (0 - не проверять)
+	MaxLossPercent float64 `yaml:"max_loss_percent,omitempty"` // UDP: максимум потерь, % (0 - не проверять)
+	Interface      string  `yaml:"interface,omitempty"`        // Интерфейс, с адреса которого идет тест
+	BindFlashedMAC bool    `yaml:"bind_flashed_mac,omitempty"` // Интерфейс с только что прошитым MAC
 }
 
 type FlashField struct {
@@ -81,14 +308,71 @@ type FlashField struct {
 	Flash bool   `yaml:"flash"`
 	ID    string `yaml:"id"`
 	Regex string `yaml:"regex"`
+
+	Variants map[string]FlashFieldVariant `yaml:"variants,omitempty"` // Формат по варианту изделия (ключ - SKU или вывод variant_command)
+}
+
+// FlashFieldVariant - формат поля для одного варианта изделия
+type FlashFieldVariant struct {
+	Regex   string `yaml:"regex"`
+	Example string `yaml:"example,omitempty"`
 }
 
 type FlashConfig struct {
 	Enabled    bool         `yaml:"enabled"`
 	Operations []string     `yaml:"operations,omitempty"`
 	Fields     []FlashField `yaml:"fields,omitempty"`
-	Method     string       `yaml:"method,omitempty"`
+	Method     string       `yaml:"method,omitempty"` // rtnicpg, eeupdate или auto (пусто = eeupdate)
 	VenDevice  []string     `yaml:"ven_device,omitempty"`
+
+	// Порядок, в котором Intel NIC получают MAC (target, target+1, ...) при eeupdate: [3, 1, 2, 4],
+	// "by-pci-address" или "by-mac-current"; пусто - порядок обнаружения eeupdate
+	NICOrder NICOrder `yaml:"nic_order,omitempty"`
+
+	VariantCommand string `yaml:"variant_command,omitempty"` // Команда, печатающая вариант изделия (по умолчанию SKU Number из dmidecode)
+	InputTimeout   string `yaml:"input_timeout,omitempty"`   // Сколько ждать ввода данных прошивки ("60s"); пусто - без ограничения
+
+	// Теги операций ("fru": [burnin]) для -tags/-exclude-tags; операция без тегов фильтром не отсекается
+	OperationTags map[string][]string `yaml:"operation_tags,omitempty"`
+
+	PostFlashTests []TestSpec `yaml:"post_flash_tests,omitempty"` // Проверка результата прошивки сразу после нее
+
+	RequireDualOperator bool   `yaml:"require_dual_operator,omitempty"` // Подтверждение прошивки вторым оператором
+	OperatorPattern     string `yaml:"operator_pattern,omitempty"`      // Формат бейджа оператора (regex)
+
+	SendGratuitousARP  *bool `yaml:"send_gratuitous_arp,omitempty"` // arping -U после восстановления IP (по умолчанию true)
+	VerifyConnectivity bool  `yaml:"verify_connectivity,omitempty"` // ping шлюза по умолчанию после прошивки MAC
+
+	PCIRescanBeforeFlash bool `yaml:"pci_rescan_before_flash,omitempty"` // Пересканировать PCI перед поиском Intel NIC (hot-plug)
+
+	AllowOnRequiredFailure bool `yaml:"allow_on_required_failure,omitempty"` // Прошивать и после провала required теста (лаборатории)
+
+	ArpScan        bool   `yaml:"arp_scan,omitempty"`         // Проверка, что прошитый MAC не отвечает в подсети с другой станции
+	ArpScanTimeout string `yaml:"arp_scan_timeout,omitempty"` // Длительность сканирования (по умолчанию 5s)
+
+	RequireUniqueMACFlash bool `yaml:"require_unique_mac_flash,omitempty"` // MAC из log_dir/mac_history.yaml другой платы - отказ вместо предупреждения
+	EnforceUniqueSerials  bool `yaml:"enforce_unique_serials,omitempty"`   // Серийный номер из serial_history.yaml другой сессии - отказ вместо предупреждения
+
+	FRUBlankSizeBytes int `yaml:"fru_blank_size_bytes,omitempty"` // Размер нулевого образа для очистки FRU (по умолчанию 2048)
+
+	AllowSpecialMAC bool `yaml:"allow_special_mac,omitempty"` // Принимать multicast, нулевой и широковещательный MAC (лабораторные тесты)
+
+	SMBIOS SMBIOSConfig `yaml:"smbios,omitempty"` // Операция smbios: запись строк DMI утилитой вендора
+
+	ChecksumFixArgs []string `yaml:"checksum_fix_args,omitempty"` // Аргументы eeupdate64e для nic-checksum (по умолчанию /CALCCHKSUM)
+
+	TemperatureCheckEnabled bool    `yaml:"temperature_check_enabled,omitempty"` // Проверять датчики BMC перед прошивкой (перегретая плата)
+	MaxTemperatureCelsius   float64 `yaml:"max_temperature_celsius,omitempty"`   // Предел для любого датчика Temperature
+
+	PSUCheckEnabled     bool               `yaml:"psu_check_enabled,omitempty"`     // Проверять блоки питания и линии VIN/VOUT перед прошивкой
+	PSUSensorThresholds map[string]float64 `yaml:"psu_sensor_thresholds,omitempty"` // Датчик ipmitool sdr -> минимально допустимое показание
+}
+
+// SMBIOSConfig - запись строк SMBIOS type 1/2 утилитой вендора (AMIDEEFIx64, Insyde H2OSDE)
+type SMBIOSConfig struct {
+	ToolPath     string            `yaml:"tool_path"`               // Путь к утилите или имя в PATH
+	Strings      map[string]string `yaml:"strings"`                 // Опция утилиты -> источник: system_serial, io_board, mac, manufacturer, product
+	SuccessRegex string            `yaml:"success_regex,omitempty"` // Признак успеха в выводе утилиты (по умолчанию только код возврата 0)
 }
 
 type FRUStatus struct {
@@ -97,6 +381,17 @@ type FRUStatus struct {
 	HasBadSum    bool
 	CanRead      bool
 	ErrorMessage string
+
+	HeaderBadSum bool       // Неверный common header - чип нужно инициализировать целиком
+	BadAreas     []string   // Области с неверной суммой (chassis, board, product) - перестраиваются записью FRU
+	Fields       []FRUField // Поля из ipmitool fru print
+	Raw          []byte     // Содержимое чипа из ipmitool fru read (nil - не прочитано)
+}
+
+// FRUField - одно поле вывода ipmitool fru print
+type FRUField struct {
+	Name  string
+	Value string
 }
 
 type LogConfig struct {
@@ -106,12 +401,96 @@ type LogConfig struct {
 	Server    string `yaml:"server,omitempty"`
 	ServerDir string `yaml:"server_dir,omitempty"`
 	OpName    string `yaml:"op_name,omitempty"`
+
+	MinFreeSpaceMB int  `yaml:"min_free_space_mb,omitempty"` // Минимум свободного места в LogDir для pre-flight
+	SaveTranscript bool `yaml:"save_transcript,omitempty"`   // Текстовая копия консоли в <log_dir>/<session>/console.txt
+	SplitAttempts  bool `yaml:"split_attempts,omitempty"`    // Повторные попытки теста в отдельных файлах tests/NN_name_attemptN.log
+
+	UploadRetries       int    `yaml:"upload_retries,omitempty"`        // Попыток загрузки с проверкой контрольной суммы (по умолчанию 3)
+	UploadWaitTimeout   string `yaml:"upload_wait_timeout,omitempty"`   // Сколько ждать выгрузку после итогов (по умолчанию 10s), дальше - без ожидания
+	OperatorAuthCommand string `yaml:"operator_auth_command,omitempty"` // Проверка оператора перед прошивкой (бейдж, LDAP); аргумент - имя оператора
+
+	// Дневной манифест станции <log_dir>/manifest_YYYYMMDD.yaml для сверки смены на сервере
+	Manifest           bool   `yaml:"manifest,omitempty"`
+	ManifestUploadTime string `yaml:"manifest_upload_time,omitempty"` // "HH:MM": после этого времени манифест дня выгружается в конце сессии
+
+	HTMLReport     bool   `yaml:"html_report,omitempty"`     // HTML отчет в <log_dir>/<session>/report.html
+	ReportTemplate string `yaml:"report_template,omitempty"` // Свой шаблон отчета (брендирование), по умолчанию встроенный
+
+	Retention RetentionConfig `yaml:"retention,omitempty"` // Ограничение размера/возраста log_dir
+
+	StationID      string `yaml:"station_id,omitempty"`       // ID рабочего места (иначе FIRESTARTER_STATION_ID)
+	GroupByStation bool   `yaml:"group_by_station,omitempty"` // Каталог станции на сервере: server_dir/<station>/product/op_name
+
+	// Локальный интерфейс/адрес для ssh/scp (станции с лабораторным VLAN и портом к DUT).
+	// bind_address важнее bind_interface; если путь через них недоступен - fallback на любой маршрут с предупреждением.
+	BindInterface string `yaml:"bind_interface,omitempty"`
+	BindAddress   string `yaml:"bind_address,omitempty"`
+
+	SELOnFailure bool `yaml:"sel_on_failure,omitempty"` // Забирать новые записи SEL сразу после каждого упавшего теста
+
+	SerialHistoryDir string `yaml:"serial_history_dir,omitempty"` // Каталог serial_history.yaml (общий для станций, например NFS); пусто - log_dir
+
+	Redact RedactConfig `yaml:"redact,omitempty"` // Обезличивание копии, уходящей на сервер (локальный лог остается полным)
+
+	// Трассировка внешних команд: <log_dir>/<session>/commands.trace и commands.replay.sh для этапа прошивки
+	TraceCommands    bool `yaml:"trace_commands,omitempty"`
+	TraceMaxSizeMB   int  `yaml:"trace_max_size_mb,omitempty"`  // Предел commands.trace (по умолчанию 10), дальше вызовы не пишутся
+	TraceOutputBytes int  `yaml:"trace_output_bytes,omitempty"` // Сколько байт начала и конца вывода сохранять (по умолчанию 1024)
+}
+
+// RedactConfig - какие данные скрывать в выгружаемом логе и артефактах и как
+type RedactConfig struct {
+	Fields  []string `yaml:"fields,omitempty"`   // Категории: serials, macs, ips, operator; пусто - обезличивание выключено
+	Mode    string   `yaml:"mode,omitempty"`     // hash (по умолчанию), mask или drop
+	Salt    string   `yaml:"salt,omitempty"`     // Соль hash этой площадки (иначе FIRESTARTER_REDACT_SALT)
+	MapFile string   `yaml:"map_file,omitempty"` // Локальный YAML оригинал -> замена для авторизованного поиска (hash/mask)
+}
+
+// RetentionConfig - политика очистки log_dir. outbox, audit.log и текущая сессия не удаляются никогда.
+type RetentionConfig struct {
+	MaxTotalMB int  `yaml:"max_total_mb,omitempty"` // Удалять самые старые сессии, пока log_dir больше лимита
+	MaxAgeDays int  `yaml:"max_age_days,omitempty"` // Удалять сессии старше N дней
+	Strict     bool `yaml:"strict,omitempty"`       // Удалять и посторонние файлы (по умолчанию пропускаются)
 }
 
 type FlashData struct {
 	SystemSerial string
 	IOBoard      string
 	MAC          string
+	Review       *FlashReview
+	Variant      *ProductVariant
+	Missing      string // Почему данных нет (non-interactive): операции, которым они нужны, SKIPPED с этой причиной
+}
+
+// noFlashDataReason - причина пропуска операций прошивки без ввода оператора
+const noFlashDataReason = "no flash data (non-interactive)"
+
+// ProductVariant - вариант изделия, по формату которого проверялся ввод оператора
+type ProductVariant struct {
+	ID     string `yaml:"id"`
+	Source string `yaml:"source"` // detected - определен до ввода, matched - не определен, взят по совпавшему формату
+}
+
+// FlashFieldEdit фиксирует правку значения на экране подтверждения
+type FlashFieldEdit struct {
+	Field     string    `yaml:"field"`
+	OldValue  string    `yaml:"old"`
+	NewValue  string    `yaml:"new"`
+	Timestamp time.Time `yaml:"timestamp"`
+
+	TimestampOffset time.Duration `yaml:"timestamp_offset"`
+}
+
+// FlashReview - результат экрана подтверждения собранных данных прошивки
+type FlashReview struct {
+	Confirmed   map[string]string `yaml:"confirmed"`
+	Edits       []FlashFieldEdit  `yaml:"edits,omitempty"`
+	AutoConfirm bool              `yaml:"auto_confirm,omitempty"` // Подтверждено автоматически (non-interactive)
+	Aborted     bool              `yaml:"aborted,omitempty"`
+	Timestamp   time.Time         `yaml:"timestamp"`
+
+	TimestampOffset time.Duration `yaml:"timestamp_offset"`
 }
 
 // Result structures
@@ -123,6 +502,84 @@ type TestResult struct {
 	Output   string        `yaml:"-"` // Not saved to log
 	Required bool          `yaml:"required"`
 	Attempts int           `yaml:"attempts,omitempty"`
+	Flappy   bool          `yaml:"flappy,omitempty"` // Прошел только после повторов (нестабильность, пограничное железо)
+	Phase    string        `yaml:"phase,omitempty"`  // "post-flash" для проверок после прошивки
+
+	Description string `yaml:"description,omitempty"`
+
+	SkipReason string `yaml:"skip_reason,omitempty"` // Для SKIPPED: operator, dependency, condition, budget, blocked, other
+	SkipDetail string `yaml:"skip_detail,omitempty"` // Пояснение к причине пропуска
+
+	Group      string        `yaml:"group,omitempty"`       // Группа из конфига (parallel1, sequential2, post-flash)
+	OutputFile string        `yaml:"output_file,omitempty"` // Путь к полному выводу относительно каталога сессии
+	Command    string        `yaml:"-"`
+	Started    time.Time     `yaml:"-"`
+	History    []TestAttempt `yaml:"-"` // Все попытки с выводом (для файлов тестов)
+
+	StartedOffset time.Duration `yaml:"started_offset,omitempty"` // Монотонное смещение начала последней попытки
+
+	Resources *ResourceUsage `yaml:"resources,omitempty"` // Только с -show-resources / tests.show_resources
+	Network   *NetworkResult `yaml:"network,omitempty"`   // Результат встроенного iperf3 теста
+
+	WaitDuration time.Duration `yaml:"wait_duration,omitempty"` // Ожидание занятых другими тестами resources перед запуском
+
+	Steps []StepResult `yaml:"steps,omitempty"` // Шаги составного теста последней попытки
+
+	Timeout         time.Duration `yaml:"timeout,omitempty"`          // Таймаут последней попытки
+	TimeoutExtended bool          `yaml:"timeout_extended,omitempty"` // Прошел только с таймаутом, увеличенным после TIMEOUT
+}
+
+// Причины SKIPPED в TestResult.SkipReason (общие с runner)
+const (
+	SkipOperator   = runner.SkipOperator
+	SkipDependency = runner.SkipDependency
+	SkipCondition  = runner.SkipCondition
+	SkipBudget     = runner.SkipBudget
+	SkipBlocked    = runner.SkipBlocked
+	SkipOther      = runner.SkipOther
+)
+
+// skipTest помечает результат как SKIPPED с причиной; Error сохраняет прежний текст для старых потребителей лога
+func skipTest(result TestResult, reason, detail, message string) TestResult {
+	result.Status = "SKIPPED"
+	result.SkipReason = reason
+	result.SkipDetail = detail
+	result.Error = message
+	return result
+}
+
+// NetworkResult - измерения iperf3 теста
+type NetworkResult struct {
+	Server      string  `yaml:"server"`
+	Interface   string  `yaml:"interface,omitempty"`
+	Protocol    string  `yaml:"protocol"`
+	Direction   string  `yaml:"direction"`
+	Mbps        float64 `yaml:"mbps"`                   // Минимум по направлениям для bidir
+	ReverseMbps float64 `yaml:"reverse_mbps,omitempty"` // bidir: скорость обратного направления
+	Retransmits int     `yaml:"retransmits,omitempty"`
+	LostPercent float64 `yaml:"lost_percent,omitempty"`
+	JitterMs    float64 `yaml:"jitter_ms,omitempty"`
+}
+
+// ResourceUsage - потребление ресурсов деревом процессов теста (последняя попытка)
+type ResourceUsage struct {
+	PeakRSSKB    int64   `yaml:"peak_rss_kb,omitempty"`
+	UserSec      float64 `yaml:"user_sec"`
+	SystemSec    float64 `yaml:"system_sec"`
+	IOReadBytes  int64   `yaml:"io_read_bytes,omitempty"`  // Только через cgroup
+	IOWriteBytes int64   `yaml:"io_write_bytes,omitempty"` // Только через cgroup
+	Source       string  `yaml:"source"`                   // "cgroup" или "rusage"
+}
+
+// TestAttempt - одна попытка выполнения теста
+type TestAttempt struct {
+	Number   int
+	Status   string
+	Started  time.Time
+	Duration time.Duration
+	Timeout  time.Duration // Таймаут этой попытки (после TIMEOUT может быть увеличен)
+	Error    string
+	Output   string
 }
 
 type SystemInfo struct {
@@ -133,14 +590,62 @@ type SystemInfo struct {
 	IP        string    `yaml:"ip,omitempty"`
 	Timestamp time.Time `yaml:"timestamp"`
 
+	TimestampOffset time.Duration `yaml:"timestamp_offset"`
+
 	// Оригинальные значения (до прошивки)
 	OriginalMBSerial string   `yaml:"original_mb_serial,omitempty"` // Оригинальный серийник материнской платы
 	OriginalMACs     []string `yaml:"original_macs,omitempty"`      // Список всех оригинальных MAC адресов
 
+	// Версии прошивки и платы (поведение EFI переменных зависит от версии BIOS)
+	BIOSVendor        string `yaml:"bios_vendor,omitempty"`
+	BIOSVersion       string `yaml:"bios_version,omitempty"`
+	BIOSReleaseDate   string `yaml:"bios_release_date,omitempty"`
+	BoardVersion      string `yaml:"board_version,omitempty"`
+	BoardManufacturer string `yaml:"board_manufacturer,omitempty"`
+	BoardProduct      string `yaml:"board_product,omitempty"`
+
+	// PCI устройства (vendor:device) и результат идентификации продукта - для аудита приемки
+	PCIDevices     []string                     `yaml:"pci_devices,omitempty"`
+	Identification *ProductIdentificationResult `yaml:"identification,omitempty"`
+
+	// Откуда запущена программа: live образ или установленная ОС
+	Environment *RuntimeEnvironment `yaml:"environment,omitempty"`
+
+	// Версии утилит прошивки (для разбора проблем на линии)
+	ToolVersions map[string]string `yaml:"tool_versions,omitempty"`
+
+	// Какая станция и какой версией firestarter сделала лог; часы BMC относительно системных
+	Station  StationInfo `yaml:"station"`
+	BMCClock *BMCClock   `yaml:"bmc_clock,omitempty"`
+
 	// DMIDecode данные в конце для лучшей читаемости
 	DMIDecode map[string]interface{} `yaml:"dmidecode"`
 }
 
+// StationInfo идентифицирует рабочее место, на котором прошла сессия
+type StationInfo struct {
+	ID                 string `yaml:"id,omitempty"` // log.station_id или FIRESTARTER_STATION_ID
+	Hostname           string `yaml:"hostname,omitempty"`
+	FirestarterVersion string `yaml:"firestarter_version"`
+}
+
+// BMCClock - время BMC (ipmitool sel time get) и его расхождение с системным
+type BMCClock struct {
+	Time        time.Time `yaml:"time"`
+	SkewSeconds int64     `yaml:"skew_seconds"` // BMC минус система; большие значения ломают сопоставление SEL
+}
+
+// SELEvent - запись System Event Log BMC, появившаяся во время сессии
+type SELEvent struct {
+	ID        uint32    `yaml:"id"`                  // Record ID (ipmitool показывает в hex)
+	Timestamp time.Time `yaml:"timestamp,omitempty"` // Время BMC; нулевое у записей Pre-Init
+	Sensor    string    `yaml:"sensor"`
+	Event     string    `yaml:"event"`
+	Direction string    `yaml:"direction,omitempty"`  // Asserted или Deasserted
+	Severity  string    `yaml:"severity"`             // info, warning или critical
+	AfterTest string    `yaml:"after_test,omitempty"` // Получена сразу после провала этого теста (log.sel_on_failure)
+}
+
 // Обновленная структура SessionLog - тесты перенесены ближе к началу
 type SessionLog struct {
 	SessionID    string        `yaml:"session"`
@@ -149,7 +654,105 @@ type SessionLog struct {
 	Pipeline     PipelineInfo  `yaml:"pipeline"`
 	TestResults  []TestResult  `yaml:"test_results"`
 	FlashResults []FlashResult `yaml:"flash_results,omitempty"`
-	System       SystemInfo    `yaml:"system"`
+	FlashReview  *FlashReview  `yaml:"flash_review,omitempty"`
+	Upload       *UploadCheck  `yaml:"upload,omitempty"`     // Проверка пути до сервера логов перед загрузкой
+	SELEvents    []SELEvent    `yaml:"sel_events,omitempty"` // Записи SEL, добавленные BMC за время сессии
+	Timings      *Timings      `yaml:"timings,omitempty"`    // Куда ушло время сессии (до сохранения лога)
+
+	PrerequisiteFailures []PrereqFailure `yaml:"prerequisite_failures,omitempty"` // Почему прошивка не начиналась (state precheck_failed)
+	Variant              *ProductVariant `yaml:"variant,omitempty"`               // Вариант изделия (flash.fields[].variants)
+
+	NICConsistency *NICConsistency `yaml:"nic_consistency,omitempty"` // Сетевые порты в начале и в конце сессии
+	SMART          *SMARTReport    `yaml:"smart,omitempty"`           // SMART дисков до и после тестов (tests.smart)
+	System         SystemInfo      `yaml:"system"`
+
+	TimestampOffset time.Duration `yaml:"timestamp_offset"`        // Монотонное смещение начала сессии от запуска программы
+	ClockSuspect    bool          `yaml:"clock_suspect,omitempty"` // Системному времени нельзя доверять (не исправлено)
+	Clock           *ClockCheck   `yaml:"clock,omitempty"`
+}
+
+// Timings - разбивка времени сессии по этапам. Ожидание оператора (вопросы, ввод данных прошивки)
+// считается отдельно от машинного времени этапа, чтобы балансировка линии видела реальную загрузку станции
+type Timings struct {
+	Total        time.Duration   `yaml:"total"`
+	Machine      time.Duration   `yaml:"machine"`       // Сумма машинного времени этапов
+	OperatorWait time.Duration   `yaml:"operator_wait"` // Сумма ожидания оператора по всем этапам
+	Untracked    time.Duration   `yaml:"untracked,omitempty"`
+	Phases       []TimingSegment `yaml:"phases"`
+}
+
+// TimingSegment - один этап: identification, tests: <группа>, flash data entry, flash: <операция> и т.д.
+type TimingSegment struct {
+	Name         string        `yaml:"name"`
+	Machine      time.Duration `yaml:"machine"`
+	OperatorWait time.Duration `yaml:"operator_wait,omitempty"`
+	Slowest      []TestTiming  `yaml:"slowest,omitempty"` // Для групп тестов - три самых долгих теста
+}
+
+type TestTiming struct {
+	Name     string        `yaml:"name"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// NICPort - физический сетевой порт в инвентаре
+type NICPort struct {
+	Name      string `yaml:"name"`
+	MAC       string `yaml:"mac,omitempty"`
+	PermMAC   string `yaml:"permanent_mac,omitempty"` // ethtool -P: не меняется при переименовании интерфейса
+	Driver    string `yaml:"driver,omitempty"`
+	Link      bool   `yaml:"link"`
+	SpeedMbps int    `yaml:"speed_mbps,omitempty"`
+}
+
+// NICChange - отличие порта в конце сессии от начала
+type NICChange struct {
+	Port       string `yaml:"port"`
+	Change     string `yaml:"change"` // missing, driver, link_lost, speed, renamed, added
+	Before     string `yaml:"before,omitempty"`
+	After      string `yaml:"after,omitempty"`
+	Regression bool   `yaml:"regression"` // renamed и added - только для информации
+}
+
+// NICConsistency - инвентарь портов до и после сессии и их разница (тест nic-consistency)
+type NICConsistency struct {
+	Before  []NICPort   `yaml:"before"`
+	After   []NICPort   `yaml:"after"`
+	Changes []NICChange `yaml:"changes,omitempty"`
+}
+
+// SMARTReport - снимки SMART дисков до и после тестов и их разница (тест smart-delta)
+type SMARTReport struct {
+	Before  []smart.Device `yaml:"before"`
+	After   []smart.Device `yaml:"after"`
+	Changes []smart.Change `yaml:"changes,omitempty"`
+	Files   []string       `yaml:"files,omitempty"` // Полный вывод smartctl/nvme-cli относительно каталога сессии
+}
+
+// ClockCheck - проверка системного времени перед началом сессии
+type ClockCheck struct {
+	CheckedAt   time.Time `yaml:"checked_at"`
+	Reference   string    `yaml:"reference"`    // ntp:<server>, bmc или none (только нижняя граница даты)
+	SkewSeconds int64     `yaml:"skew_seconds"` // Эталон минус система
+	Suspect     bool      `yaml:"suspect"`
+	Reason      string    `yaml:"reason,omitempty"`
+	Corrected   bool      `yaml:"corrected,omitempty"`
+	CorrectedBy string    `yaml:"corrected_by,omitempty"` // ntpdate, chronyd или bmc
+	Error       string    `yaml:"error,omitempty"`
+
+	CheckedAtOffset time.Duration `yaml:"checked_at_offset"`
+}
+
+// UploadCheck - результат проверки связи с сервером логов непосредственно перед загрузкой
+type UploadCheck struct {
+	CheckedAt   time.Time `yaml:"checked_at"`
+	Route       string    `yaml:"route"`                  // bound, default, fallback или unreachable
+	Interface   string    `yaml:"interface,omitempty"`    // log.bind_interface
+	BindAddress string    `yaml:"bind_address,omitempty"` // Фактический локальный адрес ssh/scp (пусто - любой маршрут)
+	Reachable   bool      `yaml:"reachable"`
+	Warning     string    `yaml:"warning,omitempty"` // Почему предпочтительный путь не использован
+	Error       string    `yaml:"error,omitempty"`
+
+	CheckedAtOffset time.Duration `yaml:"checked_at_offset"` // Монотонное смещение от запуска программы
 }
 
 type PipelineInfo struct {
@@ -157,6 +760,42 @@ type PipelineInfo struct {
 	Config   string        `yaml:"config"`
 	Duration time.Duration `yaml:"duration"`
 	Operator string        `yaml:"operator"`
+	Order    []string      `yaml:"order,omitempty"` // Фактический порядок шагов
+
+	ConfiguredFlashOps []string `yaml:"configured_flash_ops,omitempty"` // flash.operations из конфига
+	FlashOps           []string `yaml:"flash_ops,omitempty"`            // После -flash-ops / -skip-flash-ops
+
+	Operators OperatorPair `yaml:"operators,flow,omitempty"` // Основной и подтверждающий оператор (require_dual_operator)
+
+	PostRebootPending bool `yaml:"post_reboot_pending,omitempty"` // post_reboot_groups ждут перезагрузки (-continue)
+	Continued         bool `yaml:"continued,omitempty"`           // Лог дополнен результатами после перезагрузки
+
+	ResumedFrom string `yaml:"resumed_from,omitempty"` // Сессия, прерванная до завершения и продолженная -resume
+
+	ConfigSource *ConfigSourceInfo `yaml:"config_source,omitempty"` // Откуда взят конфиг (config_source)
+
+	Tags *TagSelection `yaml:"tags,omitempty"` // Фильтр тестов и операций по тегам
+
+	FinishAction           string `yaml:"finish_action,omitempty"`            // pipeline.finish_action или -finish-action
+	FinishActionOverridden bool   `yaml:"finish_action_overridden,omitempty"` // Задано -finish-action, а не конфигом
+	FinishPowerAction      string `yaml:"finish_power_action,omitempty"`      // reboot, shutdown или none; пусто - решает оператор (prompt)
+}
+
+// OperatorPair - основной и подтверждающий оператор; второй пуст, если подтверждение пропущено (-non-interactive)
+type OperatorPair [2]string
+
+// IsZero - пара без операторов в лог не пишется (omitempty)
+func (p OperatorPair) IsZero() bool {
+	return p == OperatorPair{}
+}
+
+// ConfigSourceInfo - какой конфиг станции использован: remote, cache или local
+type ConfigSourceInfo struct {
+	Source    string    `yaml:"source"`
+	Location  string    `yaml:"location,omitempty"`
+	SHA256    string    `yaml:"sha256,omitempty"`
+	FetchedAt time.Time `yaml:"fetched_at,omitempty"`
+	Error     string    `yaml:"error,omitempty"` // Почему не удалось загрузить с сервера
 }
 
 type FlashResult struct {
@@ -164,6 +803,39 @@ type FlashResult struct {
 	Status    string        `yaml:"status"`
 	Duration  time.Duration `yaml:"duration"`
 	Details   string        `yaml:"details,omitempty"`
+
+	Drivers    *DriverContext `yaml:"drivers,omitempty"`     // Только mac: версии драйверов до и после прошивки
+	NICMapping *NICMapping    `yaml:"nic_mapping,omitempty"` // Только mac (eeupdate): порядок карт и назначенные MAC
+	NICs       []NICChecksum  `yaml:"nics,omitempty"`        // Только nic-checksum: состояние контрольной суммы каждой карты
+
+	SerialChanged bool `yaml:"serial_changed,omitempty"` // Серийный номер изменен и вступит в силу после перезагрузки
+
+	Network *MACNetworkCheck `yaml:"network,omitempty"` // Только mac: вернулась ли сеть на интерфейс с новым MAC
+}
+
+// MACNetworkCheck - сеть интерфейса с прошитым MAC после перезагрузки драйвера
+type MACNetworkCheck struct {
+	Interface          string `yaml:"interface,omitempty"`   // Пусто - прошитый MAC не найден ни на одном интерфейсе
+	OriginalIP         string `yaml:"original_ip,omitempty"` // Адрес с префиксом до выгрузки драйвера
+	IPRestored         *bool  `yaml:"ip_restored,omitempty"` // nil - адреса не было
+	IPRestoreError     string `yaml:"ip_restore_error,omitempty"`
+	DefaultRoute       string `yaml:"default_route,omitempty"`  // Маршрут по умолчанию через интерфейс до выгрузки драйвера
+	RouteRestored      *bool  `yaml:"route_restored,omitempty"` // nil - маршрута не было
+	RouteRestoreError  string `yaml:"route_restore_error,omitempty"`
+	GratuitousARP      string `yaml:"gratuitous_arp,omitempty"` // sent, skipped (нет arping) или failed
+	GratuitousARPError string `yaml:"gratuitous_arp_error,omitempty"`
+	Gateway            string `yaml:"gateway,omitempty"`
+	GatewayReachable   *bool  `yaml:"gateway_reachable,omitempty"` // nil - проверка не выполнялась (flash.verify_connectivity)
+	GatewayError       string `yaml:"gateway_error,omitempty"`
+}
+
+// NICChecksum - контрольная сумма EEPROM одной Intel NIC до и после nic-checksum
+type NICChecksum struct {
+	Index        int    `yaml:"index"`
+	VendorDevice string `yaml:"ven_device"`
+	Before       string `yaml:"before"` // valid, invalid
+	After        string `yaml:"after"`  // valid, repaired, failed
+	Details      string `yaml:"details,omitempty"`
 }
 
 // Network interface management
@@ -179,90 +851,544 @@ type IntelNIC struct {
 	Index        int
 	VendorDevice string
 	Description  string
+	PCIAddress   string // bus:dev.fun из вывода eeupdate (пусто, если не разобран)
 }
 
-type FlashMACSummary struct {
-	Method         string
-	TargetMAC      string
-	ExistingMAC    bool
-	InterfaceName  string
-	OriginalIP     string
-	OriginalDriver string
-	NICIndices     []int // For eeupdate method
-	Success        bool
-	Error          string
+// Упорядочивания flash.nic_order кроме явного списка индексов
+const (
+	nicOrderByPCIAddress = "by-pci-address"
+	nicOrderByMACCurrent = "by-mac-current"
+)
+
+// NICOrder - flash.nic_order: список индексов eeupdate или одно из упорядочиваний nicOrderBy*
+type NICOrder struct {
+	Indices []int
+	Mode    string
 }
 
-// Output manager for synchronized output
-type OutputManager struct {
-	mutex sync.Mutex
+// UnmarshalYAML принимает и список индексов, и строку
+func (o *NICOrder) UnmarshalYAML(value *yaml.Node) error {
+	*o = NICOrder{}
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&o.Indices)
+	}
+	return value.Decode(&o.Mode)
 }
 
-// Структура для резервной копии сетевого состояния
-type NetworkBackup struct {
-	Timestamp     time.Time
-	Interfaces    []NetworkInterface
-	LoadedModules []string
+func (o NICOrder) MarshalYAML() (interface{}, error) {
+	if len(o.Indices) > 0 {
+		return o.Indices, nil
+	}
+	return o.Mode, nil
 }
 
-// getTerminalWidth получает ширину терминала
-func getTerminalWidth() int {
-	// Попробуем получить через stty
-	cmd := exec.Command("stty", "size")
-	cmd.Stdin = os.Stdin
-	if output, err := cmd.Output(); err == nil {
-		parts := strings.Fields(string(output))
-		if len(parts) >= 2 {
-			if w, err := strconv.Atoi(parts[1]); err == nil && w > 0 {
-				return w
-			}
+// configured - порядок задан в конфиге (иначе MAC назначаются в порядке обнаружения)
+func (o NICOrder) configured() bool {
+	return len(o.Indices) > 0 || o.Mode != ""
+}
+
+func (o NICOrder) String() string {
+	if len(o.Indices) > 0 {
+		parts := make([]string, len(o.Indices))
+		for i, index := range o.Indices {
+			parts[i] = strconv.Itoa(index)
 		}
+		return "[" + strings.Join(parts, ",") + "]"
 	}
+	if o.Mode != "" {
+		return o.Mode
+	}
+	return "discovery"
+}
 
-	// Fallback на переменную окружения
-	if width := os.Getenv("COLUMNS"); width != "" {
-		if w, err := strconv.Atoi(width); err == nil && w > 0 {
-			return w
+// validateNICOrder проверяет flash.nic_order без карт; индексы сверяются с обнаруженными при прошивке
+func validateNICOrder(order NICOrder) error {
+	switch order.Mode {
+	case "", nicOrderByPCIAddress, nicOrderByMACCurrent:
+	default:
+		return fmt.Errorf("unknown order %q (list of NIC indices, %s or %s)", order.Mode, nicOrderByPCIAddress, nicOrderByMACCurrent)
+	}
+	seen := make(map[int]bool)
+	for _, index := range order.Indices {
+		if index < 1 {
+			return fmt.Errorf("NIC index must be >= 1, got %d", index)
 		}
+		if seen[index] {
+			return fmt.Errorf("NIC index %d listed twice", index)
+		}
+		seen[index] = true
 	}
-
-	// Значение по умолчанию
-	return 80
+	return nil
 }
 
-// printSeparator печатает горизонтальную линию по ширине терминала
-func printSeparator() {
-	width := getTerminalWidth()
-	fmt.Printf("%s%s%s\n", ColorGray, strings.Repeat("─", width), ColorReset)
+// NICMACAssignment - MAC, который получает одна Intel NIC
+type NICMACAssignment struct {
+	Index        int    `yaml:"index"`
+	VendorDevice string `yaml:"ven_device,omitempty"`
+	PCIAddress   string `yaml:"pci_address,omitempty"`
+	CurrentMAC   string `yaml:"current_mac,omitempty"` // MAC в NVM до прошивки (by-mac-current)
+	MAC          string `yaml:"mac"`
 }
 
-// printThickSeparator печатает толстую горизонтальную линию
-func printThickSeparator() {
-	width := getTerminalWidth()
-	fmt.Printf("%s%s%s\n", ColorGray, strings.Repeat("═", width), ColorReset)
+// NICMapping - каким порядком и какие MAC назначены картам при прошивке eeupdate
+type NICMapping struct {
+	Order       string             `yaml:"order"` // discovery, [3,1,2,4], by-pci-address, by-mac-current
+	Assignments []NICMACAssignment `yaml:"assignments"`
 }
 
-func (om *OutputManager) PrintSection(title, content string) {
-	om.mutex.Lock()
-	defer om.mutex.Unlock()
-
-	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
-	printSeparator()
-
-	// Выводим контент как есть
-	fmt.Print(content)
-	if !strings.HasSuffix(content, "\n") {
-		fmt.Println()
+// pciAddressKey - bus, dev, fun из "bus:dev.fun" eeupdate для сортировки; false - адрес не разобран
+func pciAddressKey(address string) ([3]int, bool) {
+	var key [3]int
+	if _, err := fmt.Sscanf(address, "%d:%d.%d", &key[0], &key[1], &key[2]); err != nil {
+		return key, false
 	}
-
-	// Пустая строка после контента для отделения от результата
-	fmt.Println()
+	return key, true
 }
 
-func (om *OutputManager) PrintResult(timestamp time.Time, name, status string, duration time.Duration, err string) {
-	om.mutex.Lock()
-	defer om.mutex.Unlock()
-
+// orderIntelNICs раскладывает обнаруженные карты в порядке flash.nic_order. Индекс списка, которого нет
+// среди обнаруженных, - ошибка; не перечисленные карты идут следом в порядке обнаружения.
+// currentMACs (индекс -> MAC в NVM) нужен только для by-mac-current
+func orderIntelNICs(nics []IntelNIC, order NICOrder, currentMACs map[int]string) ([]IntelNIC, error) {
+	ordered := append([]IntelNIC{}, nics...)
+	switch {
+	case len(order.Indices) > 0:
+		byIndex := make(map[int]IntelNIC)
+		for _, nic := range nics {
+			byIndex[nic.Index] = nic
+		}
+		ordered = ordered[:0]
+		listed := make(map[int]bool)
+		for _, index := range order.Indices {
+			nic, ok := byIndex[index]
+			if !ok {
+				return nil, fmt.Errorf("flash.nic_order references NIC %d, not discovered (found: %s)", index, intelNICIndices(nics))
+			}
+			ordered = append(ordered, nic)
+			listed[index] = true
+		}
+		for _, nic := range nics {
+			if !listed[nic.Index] {
+				printWarning(fmt.Sprintf("NIC %d is not listed in flash.nic_order - it gets the next MAC after the listed ones", nic.Index))
+				ordered = append(ordered, nic)
+			}
+		}
+	case order.Mode == nicOrderByPCIAddress:
+		for _, nic := range nics {
+			if _, ok := pciAddressKey(nic.PCIAddress); !ok {
+				return nil, fmt.Errorf("cannot order by PCI address: NIC %d has no parsed PCI address", nic.Index)
+			}
+		}
+		sort.SliceStable(ordered, func(i, j int) bool {
+			a, _ := pciAddressKey(ordered[i].PCIAddress)
+			b, _ := pciAddressKey(ordered[j].PCIAddress)
+			for k := range a {
+				if a[k] != b[k] {
+					return a[k] < b[k]
+				}
+			}
+			return false
+		})
+	case order.Mode == nicOrderByMACCurrent:
+		normalized := make(map[int]string)
+		for _, nic := range nics {
+			mac, err := normalizeMAC(currentMACs[nic.Index])
+			if err != nil {
+				return nil, fmt.Errorf("cannot order by current MAC: NIC %d: %v", nic.Index, err)
+			}
+			normalized[nic.Index] = mac
+		}
+		// AA:BB:CC:DD:EE:FF одной длины и регистра - строковое сравнение совпадает с числовым
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return normalized[ordered[i].Index] < normalized[ordered[j].Index]
+		})
+	}
+	return ordered, nil
+}
+
+// intelNICIndices - индексы карт через запятую для сообщений
+func intelNICIndices(nics []IntelNIC) string {
+	parts := make([]string, len(nics))
+	for i, nic := range nics {
+		parts[i] = strconv.Itoa(nic.Index)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// assignNICMACs назначает MAC в порядке карт: первая получает targetMAC, каждая следующая - на единицу больше
+func assignNICMACs(nics []IntelNIC, targetMAC string, currentMACs map[int]string) ([]NICMACAssignment, error) {
+	assignments := make([]NICMACAssignment, 0, len(nics))
+	mac := targetMAC
+	for i, nic := range nics {
+		if i > 0 {
+			next, err := incrementMAC(mac)
+			if err != nil {
+				return nil, fmt.Errorf("failed to increment MAC address for NIC %d: %v", nic.Index, err)
+			}
+			mac = next
+		}
+		assignments = append(assignments, NICMACAssignment{
+			Index:        nic.Index,
+			VendorDevice: nic.VendorDevice,
+			PCIAddress:   nic.PCIAddress,
+			CurrentMAC:   currentMACs[nic.Index],
+			MAC:          mac,
+		})
+	}
+	return assignments, nil
+}
+
+// printNICMapping печатает таблицу индекс -> MAC в порядке прошивки
+func printNICMapping(mapping NICMapping) {
+	dash := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	fmt.Printf("  NIC order: %s\n", mapping.Order)
+	fmt.Printf("  %-4s %-12s %-10s %-18s %s\n", "NIC", "PCI", "Device", "Current MAC", "Target MAC")
+	for _, a := range mapping.Assignments {
+		fmt.Printf("  %-4d %-12s %-10s %-18s %s%s%s\n",
+			a.Index, dash(a.PCIAddress), dash(a.VendorDevice), dash(a.CurrentMAC), ColorCyan, a.MAC, ColorReset)
+	}
+}
+
+// confirmNICMapping спрашивает оператора, прошивать ли карты по этой таблице; без терминала - подтверждено.
+// Прошить можно только явным y: пустая строка (лишний Enter сканера) и ошибка чтения - отказ
+func confirmNICMapping(reader *bufio.Reader) bool {
+	if !isInteractive() {
+		printInfo("Non-interactive mode: NIC mapping confirmed automatically")
+		return true
+	}
+	defer sessionTimer.operatorWait()()
+	fmt.Printf("Flash NICs with this mapping? %s[y/N]%s: ", ColorYellow, ColorReset)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	input = strings.ToUpper(strings.TrimSpace(input))
+	return input == "Y" || input == "YES"
+}
+
+type FlashMACSummary struct {
+	Method         string
+	TargetMAC      string
+	ExistingMAC    bool
+	InterfaceName  string
+	OriginalIP     string
+	OriginalIPCIDR string // Адрес с длиной префикса ("192.168.1.5/27") - восстанавливается с той же маской
+	OriginalDriver string
+	NICIndices     []int       // For eeupdate method
+	NICMapping     *NICMapping // eeupdate: flash.nic_order и итоговое соответствие индекс -> MAC
+	Success        bool
+	Error          string
+
+	// Маршрут по умолчанию через интерфейс ("default via 10.0.0.1 proto dhcp metric 100") -
+	// пропадает вместе с адресом при выгрузке драйвера
+	OriginalDefaultRoute string
+
+	IPRestored         bool   // Исходный IP возвращен на интерфейс с новым MAC
+	IPRestoreError     string // Почему исходный IP не вернулся
+	RouteRestored      bool   // Маршрут по умолчанию добавлен обратно
+	RouteRestoreError  string // Почему маршрут не вернулся (IP не вернулся - маршрут не восстанавливался)
+	GratuitousARP      string // arpSent, arpSkipped (нет arping) или arpFailed; пусто - не отправлялся
+	GratuitousARPError string
+	NetworkReachable   bool // Шлюз по умолчанию отвечает на ping после прошивки
+	GatewayIP          string
+	GatewayError       string // Почему шлюз не найден или не отвечает (пусто - проверка прошла или не выполнялась)
+
+	Driver *DriverContext // Версии драйверов и утилиты прошивки
+}
+
+// DriverContext - драйверы и утилита, участвовавшие в прошивке MAC. Недоступные поля - "unknown".
+type DriverContext struct {
+	Tool        string            `yaml:"tool" json:"tool"`
+	ToolVersion string            `yaml:"tool_version" json:"tool_version"`
+	Modules     []ModuleVersion   `yaml:"modules" json:"modules"`
+	Before      []InterfaceDriver `yaml:"interfaces_before,omitempty" json:"interfaces_before,omitempty"`
+	After       []InterfaceDriver `yaml:"interfaces_after,omitempty" json:"interfaces_after,omitempty"`
+}
+
+// ModuleVersion - версия модуля ядра по modinfo
+type ModuleVersion struct {
+	Name       string `yaml:"name" json:"name"`
+	Version    string `yaml:"version" json:"version"`
+	SrcVersion string `yaml:"srcversion" json:"srcversion"`
+}
+
+// InterfaceDriver - драйвер и прошивка интерфейса по ethtool -i
+type InterfaceDriver struct {
+	Interface       string `yaml:"interface" json:"interface"`
+	Driver          string `yaml:"driver" json:"driver"`
+	Version         string `yaml:"version" json:"version"`
+	FirmwareVersion string `yaml:"firmware_version" json:"firmware_version"`
+	BusInfo         string `yaml:"bus_info,omitempty" json:"bus_info,omitempty"`
+}
+
+// Output manager for synchronized output
+type OutputManager struct {
+	mutex sync.Mutex
+
+	compact *compactStatus // Режим -quiet (ui.mode: compact); nil - обычный вывод
+}
+
+// compactStatus - состояние строки статуса компактного режима.
+// Строка перерисовывается на месте (\r), пока ее не сотрет другой вывод (ClearStatus)
+type compactStatus struct {
+	mu      sync.Mutex
+	started time.Time
+	phase   string            // Текущая группа тестов
+	total   int               // Тестов в текущей группе
+	results map[string]string // группа/имя -> последний итоговый статус
+	active  map[string]int    // Выполняемые сейчас тесты (имя -> число запусков)
+	drawn   bool              // Строка статуса сейчас на экране, курсор в ее конце
+	ticker  *time.Ticker      // Перерисовка раз в секунду, останавливается в DisableCompact
+	done    chan struct{}
+}
+
+// compactFrame - содержимое одного кадра строки статуса
+type compactFrame struct {
+	Phase   string
+	Active  []string
+	Done    int
+	Total   int
+	Elapsed time.Duration
+	Passed  int
+	Failed  int
+}
+
+// renderCompactFrame форматирует кадр строки статуса без цветов.
+// Строка обрезается до width-1 символов: перенесенную строку \r уже не перерисует
+func renderCompactFrame(f compactFrame, width int) string {
+	current := "-"
+	if len(f.Active) > 0 {
+		current = strings.Join(f.Active, ", ")
+	}
+	elapsed := int(f.Elapsed.Round(time.Second) / time.Second)
+	line := fmt.Sprintf("[%s] %d/%d | %02d:%02d:%02d | passed %d, failed %d | %s",
+		f.Phase, f.Done, f.Total, elapsed/3600, elapsed/60%60, elapsed%60, f.Passed, f.Failed, current)
+
+	runes := []rune(line)
+	if width > 1 && len(runes) > width-1 {
+		line = string(runes[:width-2]) + "…"
+	}
+	return line
+}
+
+// EnableCompact включает компактный режим: вместо секций тестов одна строка статуса, обновляемая раз в секунду
+func (om *OutputManager) EnableCompact() {
+	c := &compactStatus{
+		started: time.Now(),
+		results: make(map[string]string),
+		active:  make(map[string]int),
+		ticker:  time.NewTicker(time.Second),
+		done:    make(chan struct{}),
+	}
+	om.compact = c
+
+	go func() {
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-c.ticker.C:
+			}
+			c.mu.Lock()
+			// Перерисовываем только свою строку: если после нее уже что-то напечатано, ждем следующего события
+			if c.drawn {
+				c.draw()
+			}
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// DisableCompact стирает строку статуса и останавливает ее перерисовку; итоги сессии печатаются обычным выводом
+func (om *OutputManager) DisableCompact() {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+	c := om.compact
+	if c == nil {
+		return
+	}
+	c.ticker.Stop()
+	close(c.done)
+	c.mu.Lock()
+	c.clear()
+	c.mu.Unlock()
+	om.compact = nil
+}
+
+// BeginGroup начинает группу тестов в строке статуса (прогресс x/y считается по группе)
+func (om *OutputManager) BeginGroup(name string, total int) {
+	if c := om.compact; c != nil {
+		c.mu.Lock()
+		c.phase = name
+		c.total = total
+		c.mu.Unlock()
+	}
+}
+
+// ClearStatus стирает строку статуса перед обычным выводом; без компактного режима ничего не делает
+func (om *OutputManager) ClearStatus() {
+	if c := om.compact; c != nil {
+		c.mu.Lock()
+		c.clear()
+		c.mu.Unlock()
+	}
+}
+
+// CountResult учитывает в строке статуса тест, результат которого получен без запуска (-resume)
+func (om *OutputManager) CountResult(name, status string) {
+	if c := om.compact; c != nil {
+		c.mu.Lock()
+		c.results[c.phase+"/"+name] = status
+		c.mu.Unlock()
+	}
+}
+
+// update учитывает событие теста; вызывается под c.mu
+func (c *compactStatus) update(name, status string) {
+	if status == "RUNNING" {
+		c.active[name]++
+		return
+	}
+	if c.active[name]--; c.active[name] <= 0 {
+		delete(c.active, name)
+	}
+	c.results[c.phase+"/"+name] = status
+}
+
+// frame собирает текущий кадр; вызывается под c.mu
+func (c *compactStatus) frame() compactFrame {
+	f := compactFrame{Phase: c.phase, Total: c.total, Elapsed: time.Since(c.started)}
+	for name := range c.active {
+		f.Active = append(f.Active, name)
+	}
+	sort.Strings(f.Active)
+	for key, status := range c.results {
+		if strings.HasPrefix(key, c.phase+"/") {
+			f.Done++
+		}
+		switch status {
+		case "PASSED":
+			f.Passed++
+		case "FAILED", "TIMEOUT":
+			f.Failed++
+		}
+	}
+	return f
+}
+
+// draw перерисовывает строку статуса на месте; вызывается под c.mu
+func (c *compactStatus) draw() {
+	fmt.Printf("\r\033[K%s", renderCompactFrame(c.frame(), getTerminalWidth()))
+	c.drawn = true
+}
+
+// clear стирает строку статуса, если она на экране; вызывается под c.mu
+func (c *compactStatus) clear() {
+	if c.drawn {
+		fmt.Print("\r\033[K")
+		c.drawn = false
+	}
+}
+
+// Структура для резервной копии сетевого состояния
+type NetworkBackup struct {
+	Timestamp     time.Time
+	Interfaces    []NetworkInterface
+	LoadedModules []string
+}
+
+// getTerminalWidth получает ширину терминала
+func getTerminalWidth() int {
+	// Попробуем получить через stty
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = consoleIn
+	if output, err := cmd.Output(); err == nil {
+		parts := strings.Fields(string(output))
+		if len(parts) >= 2 {
+			if w, err := strconv.Atoi(parts[1]); err == nil && w > 0 {
+				return w
+			}
+		}
+	}
+
+	// Fallback на переменную окружения
+	if width := os.Getenv("COLUMNS"); width != "" {
+		if w, err := strconv.Atoi(width); err == nil && w > 0 {
+			return w
+		}
+	}
+
+	// Значение по умолчанию
+	return 80
+}
+
+// printSeparator печатает горизонтальную линию по ширине терминала
+func printSeparator() {
+	outputManager.ClearStatus()
+	width := getTerminalWidth()
+	fmt.Printf("%s%s%s\n", ColorGray, strings.Repeat("─", width), ColorReset)
+}
+
+// printThickSeparator печатает толстую горизонтальную линию
+func printThickSeparator() {
+	outputManager.ClearStatus()
+	width := getTerminalWidth()
+	fmt.Printf("%s%s%s\n", ColorGray, strings.Repeat("═", width), ColorReset)
+}
+
+// PrintSection печатает блок вывода теста; description - строка под заголовком (пусто - без нее),
+// status - статус попытки, к которой относится вывод
+func (om *OutputManager) PrintSection(title, description, content, status string) {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	// В компактном режиме вывод успешного теста не показываем, вывод упавшего - полностью
+	if c := om.compact; c != nil {
+		if status == "PASSED" {
+			return
+		}
+		c.mu.Lock()
+		c.clear()
+		c.mu.Unlock()
+	}
+
+	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
+	if description != "" {
+		fmt.Printf("%s%s%s\n", ColorGray, description, ColorReset)
+	}
+	printSeparator()
+
+	// Выводим контент как есть
+	fmt.Print(content)
+	if !strings.HasSuffix(content, "\n") {
+		fmt.Println()
+	}
+
+	// Пустая строка после контента для отделения от результата
+	fmt.Println()
+}
+
+func (om *OutputManager) PrintResult(timestamp time.Time, name, status string, duration time.Duration, err string) {
+	om.mutex.Lock()
+	defer om.mutex.Unlock()
+
+	// Компактный режим: запуск и успех только обновляют строку статуса, провал выводится как обычно
+	if c := om.compact; c != nil {
+		c.mu.Lock()
+		c.update(name, status)
+		if status == "RUNNING" || status == "PASSED" || status == "SKIPPED" {
+			c.draw()
+			c.mu.Unlock()
+			return
+		}
+		c.clear()
+		c.mu.Unlock()
+	}
+
 	// Форматируем статус в enterprise стиле
 	var statusBlock string
 	switch status {
@@ -299,9 +1425,104 @@ func (om *OutputManager) PrintResult(timestamp time.Time, name, status string, d
 	fmt.Println()
 }
 
+// messageCatalogFS - каталоги сообщений оператору (ID -> формат printf), по файлу на язык
+//
+//go:embed i18n/*.yaml
+var messageCatalogFS embed.FS
+
+// defaultLanguage - язык, к которому откатываются отсутствующие ключи
+const defaultLanguage = "en"
+
+var (
+	messageCatalogs = loadMessageCatalogs()
+	uiLanguage      = defaultLanguage
+)
+
+// loadMessageCatalogs читает встроенные каталоги; битый каталог пропускается (остается английский или ID)
+func loadMessageCatalogs() map[string]map[string]string {
+	catalogs := make(map[string]map[string]string)
+	files, _ := messageCatalogFS.ReadDir("i18n")
+	for _, file := range files {
+		data, err := messageCatalogFS.ReadFile("i18n/" + file.Name())
+		if err != nil {
+			continue
+		}
+		var messages map[string]string
+		if err := yaml.Unmarshal(data, &messages); err != nil {
+			continue
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".yaml")] = messages
+	}
+	return catalogs
+}
+
+// languageFromLocale выделяет код языка из значения вида "ru_RU.UTF-8"
+func languageFromLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(locale, "_.@"); i >= 0 {
+		locale = locale[:i]
+	}
+	return locale
+}
+
+// setUILanguage выбирает язык сообщений: ui.language из конфига, иначе LANG, иначе английский
+func setUILanguage(configured string) {
+	language := languageFromLocale(configured)
+	if language == "" {
+		language = languageFromLocale(os.Getenv("LANG"))
+		if _, ok := messageCatalogs[language]; !ok {
+			language = defaultLanguage
+		}
+	} else if _, ok := messageCatalogs[language]; !ok {
+		printWarning(fmt.Sprintf("UI language %q is not available, using %s", configured, defaultLanguage))
+		language = defaultLanguage
+	}
+	uiLanguage = language
+
+	if missing := missingCatalogKeys(language); len(missing) > 0 {
+		printWarning(fmt.Sprintf("Message catalog %q is missing %d key(s), English is used for: %s",
+			language, len(missing), strings.Join(missing, ", ")))
+	}
+}
+
+// missingCatalogKeys - ключи английского каталога, которых нет в каталоге языка
+func missingCatalogKeys(language string) []string {
+	var missing []string
+	for key := range messageCatalogs[defaultLanguage] {
+		if _, ok := messageCatalogs[language][key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// tr возвращает сообщение оператору на текущем языке; нет ключа - английский, нет и там - сам ID
+func tr(id string, args ...interface{}) string {
+	format, ok := messageCatalogs[uiLanguage][id]
+	if !ok {
+		format, ok = messageCatalogs[defaultLanguage][id]
+	}
+	if !ok {
+		format = id
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// testDescription - описание теста для вывода; без description - имя теста
+func testDescription(test TestSpec) string {
+	if test.Description != "" {
+		return test.Description
+	}
+	return test.Name
+}
+
 func printTestsSummary(results []TestResult, duration time.Duration) {
 	// Заголовок
-	fmt.Printf("\n%sTESTS SUMMARY%s\n", ColorWhite, ColorReset)
+	fmt.Printf("\n%s%s%s\n", ColorWhite, tr("summary.tests_title"), ColorReset)
 	printThickSeparator()
 
 	// Подсчёт статусов
@@ -321,15 +1542,22 @@ func printTestsSummary(results []TestResult, duration time.Duration) {
 	}
 
 	// Отображение метрик
-	fmt.Printf("  %-15s: %s%4d%s\n", "Total Tests", ColorWhite, total, ColorReset)
-	fmt.Printf("  %-15s: %s%4d%s\n", "Passed", ColorGreen, passed, ColorReset)
-	fmt.Printf("  %-15s: %s%4d%s\n", "Failed", ColorRed, failed, ColorReset)
-	fmt.Printf("  %-15s: %s%4d%s\n", "Skipped", ColorYellow, skipped, ColorReset)
-	fmt.Printf("  %-15s: %s%4d%s\n", "Timed Out", ColorYellow, timedOut, ColorReset)
+	fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.total_tests"), ColorWhite, total, ColorReset)
+	fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.passed"), ColorGreen, passed, ColorReset)
+	fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.failed"), ColorRed, failed, ColorReset)
+	fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.skipped"), ColorYellow, skipped, ColorReset)
+	fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.timed_out"), ColorYellow, timedOut, ColorReset)
+	flappy := flappyTests(results)
+	if len(flappy) > 0 {
+		fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.flappy"), ColorYellow, len(flappy), ColorReset)
+	}
+	extended := timeoutExtendedTests(results)
+	if len(extended) > 0 {
+		fmt.Printf("  %-15s: %s%4d%s\n", tr("summary.timeout_extended"), ColorYellow, len(extended), ColorReset)
+	}
 
 	// Процент успешных
-	if total > 0 {
-		rate := (passed * 100) / total
+	if rate, ok := successRate(results); ok {
 		rateColor := ColorRed
 		switch {
 		case rate == 100:
@@ -337,128 +1565,335 @@ func printTestsSummary(results []TestResult, duration time.Duration) {
 		case rate >= 80:
 			rateColor = ColorYellow
 		}
-		fmt.Printf("  %-15s: %s%3d%%%s\n", "Success Rate", rateColor, rate, ColorReset)
+		fmt.Printf("  %-15s: %s%3d%%%s\n", tr("summary.success_rate"), rateColor, rate, ColorReset)
 	}
 
 	// Время выполнения
-	fmt.Printf("  %-15s: %s%v%s\n", "Elapsed Time", ColorGray, duration.Round(time.Second), ColorReset)
+	fmt.Printf("  %-15s: %s%v%s\n", tr("summary.elapsed"), ColorGray, duration.Round(time.Second), ColorReset)
 
 	// Разделитель перед списком
 	printThickSeparator()
 
 	// Список тестов, которые не прошли
 	if failed+timedOut > 0 {
-		fmt.Printf("\n%sNOT PASSED TESTS (%d)%s\n", ColorRed, failed+timedOut, ColorReset)
+		fmt.Printf("\n%s%s%s\n", ColorRed, tr("summary.not_passed", failed+timedOut), ColorReset)
 		for _, r := range results {
 			if r.Status == "FAILED" || r.Status == "TIMEOUT" {
-				fmt.Printf("  - %s%s%s\n", ColorRed, r.Name, ColorReset)
+				fmt.Printf("  - %s%s%s", ColorRed, r.Name, ColorReset)
+				if r.Description != "" {
+					fmt.Printf(": %s%s%s", ColorGray, r.Description, ColorReset)
+				}
+				fmt.Println()
 			}
 		}
 	} else {
-		fmt.Printf("\n%sALL TESTS PASSED%s\n", ColorGreen, ColorReset)
+		fmt.Printf("\n%s%s%s\n", ColorGreen, tr("summary.all_passed"), ColorReset)
 	}
+	printSkippedTests("", skippedResults(results))
+	printFlappyTests(flappy)
+	printTimeoutExtendedTests(extended)
 
 	fmt.Println()
 }
 
-var outputManager = &OutputManager{}
-
-func printSectionHeader(title string) {
-	fmt.Printf("\n%s%s%s Hardware Validation System %sv%s%s\n",
-		ColorBlue, "FIRESTARTER", ColorReset, ColorGray, VERSION, ColorReset)
-	printThickSeparator()
-	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
+// flappyTests возвращает тесты, прошедшие только после повторов. Прошедшие с увеличенным таймаутом
+// показываются отдельно (timeoutExtendedTests): это медленные, а не нестабильные тесты
+func flappyTests(results []TestResult) []TestResult {
+	var flappy []TestResult
+	for _, r := range results {
+		if r.Flappy && !r.TimeoutExtended {
+			flappy = append(flappy, r)
+		}
+	}
+	return flappy
 }
 
-func printSubHeader(title, subtitle string) {
-	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
-	if subtitle != "" {
-		fmt.Printf("%s%s%s\n", ColorGray, subtitle, ColorReset)
+// printFlappyTests выводит список нестабильных тестов: прошли, но на них стоит обратить внимание
+func printFlappyTests(flappy []TestResult) {
+	if len(flappy) == 0 {
+		return
+	}
+	fmt.Printf("\n%s%s%s\n", ColorYellow, tr("summary.flappy_title", len(flappy)), ColorReset)
+	for _, r := range flappy {
+		fmt.Printf("  ! %s%s%s %s(%s)%s\n", ColorYellow, r.Name, ColorReset, ColorGray, tr("summary.flappy_attempts", r.Attempts), ColorReset)
 	}
 }
 
-// printExecutionSummary выводит сводку по сессии и затем детальный вывод всех упавших тестов
-func printExecutionSummary(allResults []TestResult, flashResults []FlashResult, totalDuration time.Duration) {
-	fmt.Printf("\n%sSESSION SUMMARY%s\n", ColorWhite, ColorReset)
-	printThickSeparator()
-
-	// Собираем статистику тестов
-	totalTests := len(allResults)
-	passedTests := 0
-	failedTests := 0
-	skippedTests := 0
-	timeoutTests := 0
-
-	for _, result := range allResults {
-		switch result.Status {
-		case "PASSED":
-			passedTests++
-		case "FAILED":
-			failedTests++
-		case "SKIPPED":
-			skippedTests++
-		case "TIMEOUT":
-			timeoutTests++
+// timeoutExtendedTests возвращает тесты, прошедшие только с таймаутом, увеличенным после TIMEOUT
+func timeoutExtendedTests(results []TestResult) []TestResult {
+	var extended []TestResult
+	for _, r := range results {
+		if r.TimeoutExtended {
+			extended = append(extended, r)
 		}
 	}
+	return extended
+}
 
-	// Собираем статистику прошивки
-	totalFlash := len(flashResults)
-	successFlash := 0
-	failedFlash := 0
+// printTimeoutExtendedTests - подсказка в конце сессии: каким тестам поднять timeout в конфиге и до какого значения
+func printTimeoutExtendedTests(extended []TestResult) {
+	if len(extended) == 0 {
+		return
+	}
+	fmt.Printf("\n%s%s%s\n", ColorYellow, tr("summary.timeout_extended_title", len(extended)), ColorReset)
+	for _, r := range extended {
+		// Предлагаем таймаут, с которым тест прошел, округленный вверх до секунды
+		suggested := (r.Timeout + time.Second - 1).Truncate(time.Second)
+		fmt.Printf("  ! %s%s%s %s(%s)%s\n", ColorYellow, r.Name, ColorReset, ColorGray,
+			tr("summary.timeout_extended_hint", r.Attempts, r.Duration.Round(time.Second), suggested), ColorReset)
+	}
+}
+
+// skippedResults возвращает пропущенные тесты
+func skippedResults(results []TestResult) []TestResult {
+	var skipped []TestResult
+	for _, r := range results {
+		if r.Status == "SKIPPED" {
+			skipped = append(skipped, r)
+		}
+	}
+	return skipped
+}
+
+// printSkippedTests выводит пропущенные тесты по причинам: "Skipped (operator): fan-test, gpu-test"
+func printSkippedTests(indent string, skipped []TestResult) {
+	var reasons []string
+	byReason := make(map[string][]string)
+	for _, r := range skipped {
+		reason := r.SkipReason
+		if reason == "" {
+			reason = SkipOther
+		}
+		if _, ok := byReason[reason]; !ok {
+			reasons = append(reasons, reason)
+		}
+		byReason[reason] = append(byReason[reason], r.Name)
+	}
+	for _, reason := range reasons {
+		fmt.Printf("%s%s%s (%s):%s %s\n", indent, ColorYellow, tr("summary.skipped"), reason, ColorReset, strings.Join(byReason[reason], ", "))
+	}
+}
+
+// excludeSkippedFromRate - пропущенные тесты не входят в знаменатель процента успешных (tests.exclude_skipped_from_rate)
+var excludeSkippedFromRate bool
+
+// successRate - процент PASSED для итогов; ok=false, если считать не из чего.
+// Одна политика для итогов тестов и итогов сессии
+func successRate(results []TestResult) (rate int, ok bool) {
+	total, passed := 0, 0
+	for _, r := range results {
+		if r.Status == "SKIPPED" && excludeSkippedFromRate {
+			continue
+		}
+		total++
+		if r.Status == "PASSED" {
+			passed++
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return passed * 100 / total, true
+}
+
+var outputManager = &OutputManager{}
+
+// nonInteractive отключает вопросы оператору (флаг -non-interactive или stdin не терминал)
+var nonInteractive bool
+
+// blockFlashOnRequiredFailure - провал required теста блокирует прошивку (нет flash.allow_on_required_failure)
+var blockFlashOnRequiredFailure bool
+
+// flashBlockedPrefix - начало Details у операций прошивки, пропущенных из-за провала required теста
+const flashBlockedPrefix = "blocked by failed required test "
+
+// flashPrecheckPrefix - начало Details у операций, пропущенных из-за непройденных требований к BIOS/ядру
+const flashPrecheckPrefix = "prerequisite check failed: "
+
+// errFlashAborted возвращается, когда оператор отменил прошивку на экране подтверждения
+var errFlashAborted = errors.New("flash aborted by operator at review screen")
+
+// consoleIn - stdin терминала оператора (в тестах подменяется псевдотерминалом)
+var consoleIn = os.Stdin
+
+// isInteractive сообщает, можно ли задавать вопросы оператору
+func isInteractive() bool {
+	if nonInteractive {
+		return false
+	}
+	stat, err := consoleIn.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// stdoutIsTerminal сообщает, выводится ли консоль на терминал (с транскриптом - настоящий stdout)
+func stdoutIsTerminal() bool {
+	out := os.Stdout
+	if transcript != nil {
+		out = transcript.realStdout
+	}
+	stat, err := out.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+func printSectionHeader(title string) {
+	fmt.Printf("\n%s%s%s Hardware Validation System %sv%s%s\n",
+		ColorBlue, "FIRESTARTER", ColorReset, ColorGray, VERSION, ColorReset)
+	printThickSeparator()
+	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
+}
+
+func printSubHeader(title, subtitle string) {
+	outputManager.ClearStatus()
+	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(title), ColorReset)
+	if subtitle != "" {
+		fmt.Printf("%s%s%s\n", ColorGray, subtitle, ColorReset)
+	}
+}
+
+// printExecutionSummary выводит сводку по сессии и затем детальный вывод всех упавших тестов
+func printExecutionSummary(allResults []TestResult, flashResults []FlashResult, totalDuration time.Duration, upload *UploadCheck, uploadErr error, selEvents []SELEvent) {
+	fmt.Printf("\n%s%s%s\n", ColorWhite, tr("summary.session_title"), ColorReset)
+	printThickSeparator()
+
+	// Собираем статистику тестов
+	totalTests := len(allResults)
+	passedTests := 0
+	failedTests := 0
+	skippedTests := 0
+	timeoutTests := 0
+
+	for _, result := range allResults {
+		switch result.Status {
+		case "PASSED":
+			passedTests++
+		case "FAILED":
+			failedTests++
+		case "SKIPPED":
+			skippedTests++
+		case "TIMEOUT":
+			timeoutTests++
+		}
+	}
+
+	// Собираем статистику прошивки
+	totalFlash := len(flashResults)
+	successFlash := 0
+	failedFlash := 0
+	blockedFlash := 0
 	for _, fr := range flashResults {
 		if fr.Status == "SUCCESS" || fr.Status == "COMPLETED" || fr.Status == "PASSED" {
 			successFlash++
+		} else if fr.Status == "SKIPPED" && strings.HasPrefix(fr.Details, flashBlockedPrefix) {
+			blockedFlash++
 		} else {
 			failedFlash++
 		}
 	}
 
 	// Выводим основные цифры
-	fmt.Printf("  Total Tests       : %s%d%s\n", ColorWhite, totalTests, ColorReset)
-	fmt.Printf("  Passed            : %s%d%s\n", ColorGreen, passedTests, ColorReset)
-	fmt.Printf("  Failed            : %s%d%s\n", ColorRed, failedTests, ColorReset)
-	fmt.Printf("  Skipped           : %s%d%s\n", ColorYellow, skippedTests, ColorReset)
-	fmt.Printf("  Timeout           : %s%d%s\n", ColorYellow, timeoutTests, ColorReset)
-	if totalTests > 0 {
-		successRate := (passedTests * 100) / totalTests
+	fmt.Printf("  %-18s: %s%d%s\n", tr("summary.total_tests"), ColorWhite, totalTests, ColorReset)
+	fmt.Printf("  %-18s: %s%d%s\n", tr("summary.passed"), ColorGreen, passedTests, ColorReset)
+	fmt.Printf("  %-18s: %s%d%s\n", tr("summary.failed"), ColorRed, failedTests, ColorReset)
+	fmt.Printf("  %-18s: %s%d%s\n", tr("summary.skipped"), ColorYellow, skippedTests, ColorReset)
+	fmt.Printf("  %-18s: %s%d%s\n", tr("summary.timeout"), ColorYellow, timeoutTests, ColorReset)
+	flappy := flappyTests(allResults)
+	if len(flappy) > 0 {
+		fmt.Printf("  %-18s: %s%d%s\n", tr("summary.flappy"), ColorYellow, len(flappy), ColorReset)
+	}
+	extended := timeoutExtendedTests(allResults)
+	if len(extended) > 0 {
+		fmt.Printf("  %-18s: %s%d%s\n", tr("summary.timeout_extended"), ColorYellow, len(extended), ColorReset)
+	}
+	if successRate, ok := successRate(allResults); ok {
 		color := ColorRed
 		if successRate >= 100 {
 			color = ColorGreen
 		} else if successRate >= 80 {
 			color = ColorYellow
 		}
-		fmt.Printf("  Success Rate      : %s%d%%%s\n", color, successRate, ColorReset)
+		fmt.Printf("  %-18s: %s%d%%%s\n", tr("summary.success_rate"), color, successRate, ColorReset)
 	}
 
 	if totalFlash > 0 {
-		fmt.Printf("\n  Flash Operations  : %s%d Total%s\n", ColorWhite, totalFlash, ColorReset)
-		fmt.Printf("  Flash Success     : %s%d%s\n", ColorGreen, successFlash, ColorReset)
-		fmt.Printf("  Flash Failed      : %s%d%s\n", ColorRed, failedFlash, ColorReset)
+		fmt.Printf("\n  %-18s: %s%s%s\n", tr("summary.flash_operations"), ColorWhite, tr("summary.flash_total", totalFlash), ColorReset)
+		fmt.Printf("  %-18s: %s%d%s\n", tr("summary.flash_success"), ColorGreen, successFlash, ColorReset)
+		fmt.Printf("  %-18s: %s%d%s\n", tr("summary.flash_failed"), ColorRed, failedFlash, ColorReset)
+		if blockedFlash > 0 {
+			fmt.Printf("  %-18s: %s%d%s\n", tr("summary.flash_blocked"), ColorRed, blockedFlash, ColorReset)
+		}
+	}
+
+	fmt.Printf("\n  %-18s: %s%s%s\n", tr("summary.total_duration"), ColorGray, totalDuration.Round(time.Second), ColorReset)
+
+	// Новые записи SEL - только если SEL читался (есть BMC) или записи сохранены до перезагрузки
+	if selTracker != nil || len(selEvents) > 0 {
+		if len(selEvents) == 0 {
+			fmt.Printf("  %-18s: %s%s%s\n", tr("summary.sel_events"), ColorGray, tr("summary.sel_none"), ColorReset)
+		} else {
+			worst := worstSELSeverity(selEvents)
+			color := ColorGray
+			switch worst {
+			case "critical":
+				color = ColorRed
+			case "warning":
+				color = ColorYellow
+			}
+			fmt.Printf("  %-18s: %s%s%s\n", tr("summary.sel_events"), color, tr("summary.sel_count", len(selEvents), worst), ColorReset)
+		}
 	}
 
-	fmt.Printf("\n  Total Duration    : %s%s%s\n", ColorGray, totalDuration.Round(time.Second), ColorReset)
+	// Результат загрузки лога - неудача не должна пройти незамеченной
+	if upload != nil {
+		route := upload.Route
+		if upload.BindAddress != "" {
+			route = fmt.Sprintf("%s (%s)", route, upload.BindAddress)
+		}
+		switch {
+		case errors.Is(uploadErr, errUploadInProgress):
+			fmt.Printf("  %-18s: %sIN PROGRESS%s %s[%s]%s\n", tr("summary.log_upload"), ColorYellow, ColorReset, ColorGray, route, ColorReset)
+		case uploadErr != nil:
+			fmt.Printf("  %-18s: %sFAILED%s %s[%s] %v%s\n", tr("summary.log_upload"), ColorRed, ColorReset, ColorGray, route, uploadErr, ColorReset)
+		case upload.Route == "fallback":
+			fmt.Printf("  %-18s: %sSENT via fallback route%s %s(%s)%s\n", tr("summary.log_upload"), ColorYellow, ColorReset, ColorGray, upload.Warning, ColorReset)
+		default:
+			fmt.Printf("  %-18s: %sSENT%s %s[%s]%s\n", tr("summary.log_upload"), ColorGreen, ColorReset, ColorGray, route, ColorReset)
+		}
+	}
 
 	// Определяем и выводим общий статус
 	sessionStatus := "SUCCESS"
-	if failedTests > 0 || failedFlash > 0 {
+	blockedReason := flashBlockedReason(flashResults)
+	if blockedReason != "" {
+		sessionStatus = "BLOCKED"
+	} else if failedTests > 0 || failedFlash > 0 {
 		sessionStatus = "FAILED"
 	} else if skippedTests > 0 || timeoutTests > 0 {
 		sessionStatus = "PARTIAL"
 	}
-	fmt.Printf("  Session Status    : ")
+	fmt.Printf("  %-18s: ", tr("summary.session_status"))
 	switch sessionStatus {
+	case "BLOCKED":
+		fmt.Printf("%s FAILED - FLASH BLOCKED %s %s(%s)%s\n", ColorBgRed, ColorReset, ColorGray, blockedReason, ColorReset)
 	case "SUCCESS":
 		fmt.Printf("%s SUCCESS %s\n", ColorBgGreen, ColorReset)
 	case "FAILED":
-		fmt.Printf("%s FAILED %s %s(issues detected)%s\n", ColorBgRed, ColorReset, ColorGray, ColorReset)
+		fmt.Printf("%s FAILED %s %s(%s)%s\n", ColorBgRed, ColorReset, ColorGray, tr("summary.issues_detected"), ColorReset)
 	case "PARTIAL":
-		fmt.Printf("%s PARTIAL %s %s(some tests skipped)%s\n", ColorBgYellow, ColorReset, ColorGray, ColorReset)
+		fmt.Printf("%s PARTIAL %s %s(%s)%s\n", ColorBgYellow, ColorReset, ColorGray, tr("summary.some_skipped"), ColorReset)
 	}
+	printSkippedTests("", skippedResults(allResults))
+	printFlappyTests(flappy)
+	printTimeoutExtendedTests(extended)
 
 	// Если есть упавшие тесты — показываем их список
 	if failedTests > 0 {
-		fmt.Printf("\n%sCRITICAL ISSUES REQUIRING ATTENTION%s\n", ColorWhite, ColorReset)
+		fmt.Printf("\n%s%s%s\n", ColorWhite, tr("summary.critical_issues"), ColorReset)
 		printSeparator()
 		for _, result := range allResults {
 			if result.Status == "FAILED" || result.Status == "TIMEOUT" {
@@ -467,3621 +1902,15810 @@ func printExecutionSummary(allResults []TestResult, flashResults []FlashResult,
 						if result.Error != "" {
 							return result.Error
 						}
-						return "Test execution failed"
+						return tr("summary.test_execution_failed")
 					}())
 			}
 		}
 	}
 }
 
-func printColored(color, message string) {
-	fmt.Printf("%s%s%s\n", color, message, ColorReset)
+// phaseTimer делит время сессии на этапы. Код вызывает begin на границах этапов,
+// а вопросы оператору оборачивает в operatorWait - это время вычитается из машинного времени этапа.
+// Методы безопасны для nil (режимы без разбивки)
+type phaseTimer struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	started   time.Time
+	current   int // Индекс открытого этапа в segments, -1 - нет
+	since     time.Time
+	segments  []TimingSegment
+	waitDepth int // Вложенные/параллельные ожидания считаются одним интервалом
+	waitSince time.Time
+	waited    time.Duration // Ожидание внутри открытого этапа
 }
 
-func printInfo(message string) {
-	printColored(ColorBlue, message)
+// sessionTimer - разбивка времени текущей сессии, nil вне основной сессии
+var sessionTimer *phaseTimer
+
+func newPhaseTimer(now func() time.Time) *phaseTimer {
+	return &phaseTimer{now: now, started: now(), current: -1}
 }
 
-func printDebug(message string) {
-	printColored(ColorWhite, message)
+// begin закрывает текущий этап и открывает name (повторный этап с тем же именем суммируется)
+func (t *phaseTimer) begin(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.closeLocked(now)
+	t.current = -1
+	for i := range t.segments {
+		if t.segments[i].Name == name {
+			t.current = i
+		}
+	}
+	if t.current < 0 {
+		t.segments = append(t.segments, TimingSegment{Name: name})
+		t.current = len(t.segments) - 1
+	}
+	t.since = now
 }
 
-func printSuccess(message string) {
-	printColored(ColorGreen, message)
+// phase - имя открытого этапа ("" - нет или вне сессии)
+func (t *phaseTimer) phase() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current < 0 {
+		return ""
+	}
+	return t.segments[t.current].Name
 }
 
-func printWarning(message string) {
-	printColored(ColorYellow, message)
+// end закрывает текущий этап; время до следующего begin попадает в untracked
+func (t *phaseTimer) end() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeLocked(t.now())
+	t.current = -1
 }
 
-func printError(message string) {
-	printColored(ColorRed, message)
+// closeLocked переносит время открытого этапа в segments; идущее ожидание продолжается в следующем этапе
+func (t *phaseTimer) closeLocked(now time.Time) {
+	if t.waitDepth > 0 {
+		t.waited += now.Sub(t.waitSince)
+		t.waitSince = now
+	}
+	if t.current >= 0 {
+		seg := &t.segments[t.current]
+		seg.OperatorWait += t.waited
+		seg.Machine += now.Sub(t.since) - t.waited
+	}
+	t.waited = 0
 }
 
-func showHelp() {
-	fmt.Printf("System Validator %s\n", VERSION)
-	fmt.Println("Parameters:")
-	fmt.Println("  -V          Show program version")
-	fmt.Println("  -c <path>   Path to configuration file (default: config.yaml)")
-	fmt.Println("  -tests-only Run only tests (skip flashing)")
-	fmt.Println("  -flash-only Run only flashing (skip tests)")
-	fmt.Println("  -h          Show this help")
+// operatorWait отмечает начало ожидания оператора; возвращает функцию для отметки окончания
+func (t *phaseTimer) operatorWait() func() {
+	if t == nil {
+		return func() {}
+	}
+	t.mu.Lock()
+	if t.waitDepth == 0 {
+		t.waitSince = t.now()
+	}
+	t.waitDepth++
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.waitDepth--; t.waitDepth == 0 {
+				t.waited += t.now().Sub(t.waitSince)
+			}
+		})
+	}
 }
 
-func loadConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, err
+// setSlowest запоминает самые долгие тесты для этапа name
+func (t *phaseTimer) setSlowest(name string, results []TestResult) {
+	if t == nil {
+		return
 	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.segments {
+		if t.segments[i].Name == name {
+			t.segments[i].Slowest = slowestTests(results, 3)
+		}
+	}
+}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
-	if err != nil {
-		return nil, err
+// timings возвращает разбивку на текущий момент, открытый этап учитывается до now
+func (t *phaseTimer) timings() *Timings {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	result := &Timings{Total: now.Sub(t.started)}
+	for i, seg := range t.segments {
+		if i == t.current {
+			waited := t.waited
+			if t.waitDepth > 0 {
+				waited += now.Sub(t.waitSince)
+			}
+			seg.OperatorWait += waited
+			seg.Machine += now.Sub(t.since) - waited
+		}
+		seg.Slowest = append([]TestTiming(nil), seg.Slowest...)
+		result.Phases = append(result.Phases, seg)
+		result.Machine += seg.Machine
+		result.OperatorWait += seg.OperatorWait
+	}
+	if untracked := result.Total - result.Machine - result.OperatorWait; untracked > 0 {
+		result.Untracked = untracked
 	}
+	return result
+}
 
-	return &config, nil
+// slowestTests возвращает n самых долгих тестов (пропущенные не учитываются)
+func slowestTests(results []TestResult, n int) []TestTiming {
+	var timings []TestTiming
+	for _, r := range results {
+		if r.Status != "SKIPPED" {
+			timings = append(timings, TestTiming{Name: r.Name, Duration: r.Duration})
+		}
+	}
+	sort.SliceStable(timings, func(i, j int) bool { return timings[i].Duration > timings[j].Duration })
+	if len(timings) > n {
+		timings = timings[:n]
+	}
+	return timings
 }
 
-func runCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &out
-	err := cmd.Run()
-	return strings.TrimSpace(out.String()), err
+// printTimingBreakdown выводит таблицу этапов с долей от общего времени сессии
+func printTimingBreakdown(timings *Timings) {
+	if timings == nil || len(timings.Phases) == 0 || timings.Total <= 0 {
+		return
+	}
+	share := func(d time.Duration) string {
+		return fmt.Sprintf("%5.1f%%", float64(d)*100/float64(timings.Total))
+	}
+	wait := func(d time.Duration) string {
+		if d <= 0 {
+			return "-"
+		}
+		return formatTimingDuration(d)
+	}
+
+	fmt.Printf("\n%s%s%s\n", ColorWhite, tr("summary.timings_title"), ColorReset)
+	printSeparator()
+	fmt.Printf("  %s%-34s %10s %10s %7s%s\n", ColorGray, tr("summary.timing_phase"), tr("summary.timing_machine"), tr("summary.timing_operator"), "%", ColorReset)
+	for _, seg := range timings.Phases {
+		fmt.Printf("  %-34.34s %10s %10s %7s\n", seg.Name, formatTimingDuration(seg.Machine), wait(seg.OperatorWait), share(seg.Machine+seg.OperatorWait))
+		if len(seg.Slowest) > 0 {
+			var names []string
+			for _, s := range seg.Slowest {
+				names = append(names, fmt.Sprintf("%s %s", s.Name, formatTimingDuration(s.Duration)))
+			}
+			fmt.Printf("    %s%s: %s%s\n", ColorGray, tr("summary.timing_slowest"), strings.Join(names, ", "), ColorReset)
+		}
+	}
+	printSeparator()
+	fmt.Printf("  %-34s %10s %10s %7s\n", tr("summary.machine_time"), formatTimingDuration(timings.Machine), "", share(timings.Machine))
+	fmt.Printf("  %s%-34s %10s %10s %7s%s\n", ColorYellow, tr("summary.operator_wait"), "", wait(timings.OperatorWait), share(timings.OperatorWait), ColorReset)
+	if timings.Untracked >= time.Second {
+		fmt.Printf("  %s%-34s %10s %10s %7s%s\n", ColorGray, tr("summary.timing_other"), formatTimingDuration(timings.Untracked), "", share(timings.Untracked), ColorReset)
+	}
 }
 
-func runCommandNoOutput(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	// Do not show full output, keep only debug messages
-	var dummy bytes.Buffer
-	cmd.Stdout = &dummy
-	cmd.Stderr = &dummy
-	return cmd.Run()
+// formatTimingDuration - длительность для таблицы: доли секунды только для коротких этапов
+func formatTimingDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
 }
 
-func askUserAction(testName string) string {
-	fmt.Printf("\n%s=== TEST FAILED ===%s\n", ColorRed, ColorReset)
-	fmt.Printf("Test '%s' has failed.\n", testName)
-	fmt.Printf("Choose action:\n")
-	fmt.Printf("  %s[Y]%s Yes - Retry test (default)\n", ColorGreen, ColorReset)
-	fmt.Printf("  %s[N]%s No  - Continue with next test\n", ColorYellow, ColorReset)
-	fmt.Printf("  %s[S]%s Skip - Mark as skipped by operator\n", ColorBlue, ColorReset)
-	fmt.Printf("Choice [Y/n/s]: ")
+// consoleTranscript дублирует весь вывод консоли и ответы оператора в текстовый файл сессии.
+// Подменяет os.Stdout пайпом, поэтому любой новый вывод попадает в транскрипт автоматически.
+// stdin не подменяется: ответы попадают в транскрипт через operatorInput()
+type consoleTranscript struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	buf        *bufio.Writer
+	line       []byte // Текущая строка до \n
+	lineTime   string // Метка времени первого символа строки
+	carriage   bool   // Был \r: если дальше не \n, строку перерисовывают поверх
+	escState   int    // 0 - текст, 1 - после ESC, 2 - внутри CSI последовательности
+	closed     bool
+	realStdout *os.File
+	pipeW      *os.File
+	done       chan struct{}
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
+var transcript *consoleTranscript
+
+// startConsoleTranscript открывает файл транскрипта и перехватывает stdout
+func startConsoleTranscript(path string) (*consoleTranscript, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transcript directory: %v", err)
+	}
+	file, err := os.Create(path)
 	if err != nil {
-		return "Y" // Default on error
+		return nil, fmt.Errorf("failed to create transcript file: %v", err)
 	}
 
-	choice := strings.ToUpper(strings.TrimSpace(input))
-	if choice == "" {
-		choice = "Y" // Default
+	t := &consoleTranscript{
+		path:       path,
+		file:       file,
+		buf:        bufio.NewWriter(file),
+		realStdout: os.Stdout,
 	}
 
-	switch choice {
-	case "Y", "YES":
-		return "RETRY"
-	case "N", "NO":
-		return "CONTINUE"
-	case "S", "SKIP":
-		return "SKIP"
-	default:
-		fmt.Printf("Invalid choice '%s', defaulting to retry.\n", choice)
-		return "RETRY"
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
+	t.pipeW = outW
+	t.done = make(chan struct{})
+	t.startCopy(outR, t.done)
+	os.Stdout = outW
+
+	return t, nil
 }
 
-func askUserProductMismatch(configProduct, detectedProduct string) bool {
-	reader := bufio.NewReader(os.Stdin)
+func (t *consoleTranscript) startCopy(r *os.File, done chan struct{}) {
+	go func() {
+		io.Copy(io.MultiWriter(t.realStdout, t), r)
+		r.Close()
+		close(done)
+	}()
+}
 
-	fmt.Printf("\n%s⚠️  PRODUCT MISMATCH WARNING ⚠️%s\n", ColorRed, ColorReset)
-	fmt.Printf("Configuration file is designed for: %s%s%s\n", ColorYellow, configProduct, ColorReset)
-	fmt.Printf("Detected system product: %s%s%s\n", ColorYellow, detectedProduct, ColorReset)
-	fmt.Printf("\nThis configuration may not be suitable for your hardware.\n")
-	fmt.Printf("Continuing may lead to unexpected behavior or hardware damage.\n\n")
+// Write пишет текст без ANSI кодов, каждая строка с меткой времени.
+// Строка, перерисованная через \r (строка статуса -quiet, прогресс утилит), попадает в файл в последнем виде
+func (t *consoleTranscript) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	for {
-		fmt.Printf("Do you want to close the program? %s[Y/n]%s: ", ColorGreen, ColorReset)
+	if t.closed {
+		return len(p), nil
+	}
 
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			fmt.Printf("%sError reading input: %v%s\n", ColorRed, err, ColorReset)
+	for _, c := range p {
+		switch t.escState {
+		case 1:
+			if c == '[' {
+				t.escState = 2
+			} else {
+				t.escState = 0
+			}
+			continue
+		case 2:
+			if c >= 0x40 && c <= 0x7e {
+				t.escState = 0
+			}
 			continue
 		}
 
-		input = strings.TrimSpace(strings.ToLower(input))
-
-		// Default is 'Y' (close program)
-		if input == "" || input == "y" || input == "yes" {
-			return true // Close program
-		} else if input == "n" || input == "no" {
-			return false // Continue
-		} else {
-			fmt.Printf("%sPlease enter 'Y' to close or 'N' to continue.%s\n", ColorRed, ColorReset)
+		if c == '\r' {
+			t.carriage = true
+			continue
+		}
+		if t.carriage {
+			t.carriage = false
+			if c != '\n' {
+				t.line = t.line[:0]
+				t.lineTime = ""
+			}
+		}
+		if c == 0x1b {
+			t.escState = 1
+			continue
+		}
+		if t.lineTime == "" {
+			t.lineTime = time.Now().Format("2006-01-02 15:04:05.000")
+		}
+		t.line = append(t.line, c)
+		if c == '\n' {
+			t.writeLine()
 		}
 	}
+	return len(p), nil
 }
 
-func executeTest(test TestSpec, globalTimeout string) (TestResult, string) {
-	result := TestResult{
-		Name:     test.Name,
-		Status:   "FAILED",
-		Required: test.Required,
+// writeLine переносит накопленную строку в буфер файла; вызывается под t.mu
+func (t *consoleTranscript) writeLine() {
+	if len(t.line) == 0 {
+		return
 	}
+	t.buf.WriteString(t.lineTime + " | ")
+	t.buf.Write(t.line)
+	t.line = t.line[:0]
+	t.lineTime = ""
+}
 
-	startTime := time.Now()
+// Flush сбрасывает буфер транскрипта на диск
+func (t *consoleTranscript) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.buf.Flush()
+	}
+}
 
-	// Parse timeout - приоритет: тест > глобальный > дефолт
-	timeout := 30 * time.Second
-	if test.Timeout != "" {
-		if t, err := time.ParseDuration(test.Timeout); err == nil {
-			timeout = t
-		}
-	} else if globalTimeout != "" {
-		if t, err := time.ParseDuration(globalTimeout); err == nil {
-			timeout = t
-		}
+// Sync дожидается, пока весь уже напечатанный вывод попадет в файл, и сбрасывает его на диск
+func (t *consoleTranscript) Sync() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.mu.Unlock()
+		t.Flush()
+		return
 	}
+	oldW, oldDone := t.pipeW, t.done
+	t.pipeW = w
+	t.done = make(chan struct{})
+	t.startCopy(r, t.done)
+	os.Stdout = w
+	t.mu.Unlock()
 
-	// Create command
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	oldW.Close()
+	<-oldDone
+	t.Flush()
+}
 
-	cmd := exec.CommandContext(ctx, test.Command, test.Args...)
+// Close возвращает настоящий stdout, дописывает остаток вывода и закрывает файл
+func (t *consoleTranscript) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	os.Stdout = t.realStdout
+	pipeW, done := t.pipeW, t.done
+	t.mu.Unlock()
 
-	// Capture both stdout and stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	pipeW.Close()
+	<-done
 
-	// Run command
-	err := cmd.Run()
-	result.Duration = time.Since(startTime)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	// Незавершенная строка (вопрос без ответа) тоже остается в файле
+	if len(t.line) > 0 && !t.carriage {
+		t.line = append(t.line, '\n')
+		t.writeLine()
+	}
+	t.buf.Flush()
+	return t.file.Close()
+}
 
-	// Combine output for display
-	output := stdout.String() + stderr.String()
-	result.Output = output
+// operatorInput - stdin для чтения ответов оператора; с транскриптом прочитанное попадает и в него.
+// stdin читается только по запросу программы: фоновое копирование забирало бы ввод у дочерних процессов
+// (stty, интерактивные тесты), которые читают тот же терминал
+func operatorInput() io.Reader {
+	if transcript != nil {
+		return io.TeeReader(os.Stdin, transcriptFlusher{transcript})
+	}
+	return os.Stdin
+}
 
-	// Determine result
-	if ctx.Err() == context.DeadlineExceeded {
-		result.Status = "TIMEOUT"
-		result.Error = fmt.Sprintf("Test timed out after %s", timeout)
-	} else if err != nil {
-		result.Status = "FAILED"
-		// Try to get error message from stderr
-		if stderr.Len() > 0 {
-			lines := strings.Split(stderr.String(), "\n")
-			for _, line := range lines {
-				if strings.HasPrefix(line, "ERROR:") {
-					result.Error = strings.TrimPrefix(line, "ERROR:")
-					result.Error = strings.TrimSpace(result.Error)
-					break
-				}
-			}
-		}
-		if result.Error == "" {
-			result.Error = fmt.Sprintf("Exit code: %d", cmd.ProcessState.ExitCode())
+// transcriptFlusher пишет ввод оператора в транскрипт и сразу сбрасывает его на диск - перед ним всегда был вопрос
+type transcriptFlusher struct {
+	t *consoleTranscript
+}
+
+func (f transcriptFlusher) Write(p []byte) (int, error) {
+	n, err := f.t.Write(p)
+	f.t.Flush()
+	return n, err
+}
+
+var (
+	shutdownMutex sync.Mutex
+	shutdownHooks []func()
+	shutdownOnce  sync.Once
+)
+
+// addShutdownHook регистрирует действие, которое выполнится при выходе или по сигналу
+func addShutdownHook(hook func()) {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+	shutdownHooks = append(shutdownHooks, hook)
+}
+
+// runShutdownHooks выполняет зарегистрированные действия в обратном порядке (один раз)
+func runShutdownHooks() {
+	shutdownOnce.Do(func() {
+		shutdownMutex.Lock()
+		hooks := shutdownHooks
+		shutdownMutex.Unlock()
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i]()
 		}
-	} else {
-		result.Status = "PASSED"
+	})
+}
+
+// exitSession завершает программу, предварительно выполнив shutdown hooks
+func exitSession(code int) {
+	runShutdownHooks()
+	os.Exit(code)
+}
+
+// setupSignalHandler на SIGINT/SIGTERM сбрасывает состояние сессии и выходит
+func setupSignalHandler() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigCh
+		fmt.Printf("\n%sInterrupted by signal: %v%s\n", ColorRed, sig, ColorReset)
+		exitSession(130)
+	}()
+}
+
+// sessionDir возвращает директорию артефактов сессии: <log_dir>/<session>
+func sessionDir(config LogConfig, sessionID string) string {
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
 	}
+	return filepath.Join(logDir, sessionID)
+}
 
-	return result, output
+// messageOutput - куда идут сообщения print*; при -print-plan=json - stderr, чтобы stdout был чистым JSON.
+// nil - текущий os.Stdout: транскрипт подменяет его после старта, и сообщения должны попадать в транскрипт
+var messageOutput io.Writer
+
+func printColored(color, message string) {
+	outputManager.ClearStatus()
+	out := messageOutput
+	if out == nil {
+		out = os.Stdout
+	}
+	fmt.Fprintf(out, "%s%s%s\n", color, message, ColorReset)
 }
 
-// runTest выполняет тест и возвращает результат, не выводя сразу секцию с полным выводом
-func runTest(test TestSpec, outputMgr *OutputManager, globalTimeout string) TestResult {
-	attempts := 0
-	maxAttempts := 5
+func printInfo(message string) {
+	printColored(ColorBlue, message)
+}
 
-	var result TestResult
-	var output string
+// printDebug выводит сообщение только с -debug
+func printDebug(message string) {
+	if debugMode {
+		printColored(ColorWhite, message)
+	}
+}
 
-	for attempts < maxAttempts {
-		attempts++
-		outputMgr.PrintResult(time.Now(), test.Name, "RUNNING", 0, "")
+func printSuccess(message string) {
+	printColored(ColorGreen, message)
+}
 
-		result, output = executeTest(test, globalTimeout)
-		result.Attempts = attempts
-		result.Output = output
+func printWarning(message string) {
+	printColored(ColorYellow, message)
+}
+
+func printError(message string) {
+	printColored(ColorRed, message)
+}
+
+func showHelp() {
+	fmt.Printf("System Validator %s\n", VERSION)
+	fmt.Println("Parameters:")
+	fmt.Println("  -V          Show program version")
+	fmt.Println("  -c <path>   Path to configuration file (default: config.yaml)")
+	fmt.Println("  -config-url <url> Load the configuration over HTTP(S) instead of -c (cached in ~/.firestarter for offline use)")
+	fmt.Println("  -config-insecure  Do not verify the TLS certificate of the -config-url server")
+	fmt.Println("  -generate-config Write a commented configuration template to the -c path and exit")
+	fmt.Println("  -tests-only Run only tests (skip flashing)")
+	fmt.Println("  -flash-only Run only flashing (skip tests)")
+	fmt.Println("  -flash-ops <ops>      Run only these flash operations (e.g. fru or mac,efi)")
+	fmt.Println("  -skip-flash-ops <ops> Skip these flash operations")
+	fmt.Println("  -tags <tags>          Run only tests with any of these tags (default: tests.default_tags); tagged flash ops too")
+	fmt.Println("  -exclude-tags <tags>  Skip tests and flash operations with any of these tags (applied after -tags)")
+	fmt.Println("  -skip-tests <names>   Skip these tests (comma-separated), recorded as SKIPPED")
+	fmt.Println("  -only-tests <names>   Run only these tests, the rest are recorded as SKIPPED")
+	fmt.Println("  -interactive-tests    Toggle tests on/off from a numbered menu before testing (disabled = SKIPPED)")
+	fmt.Println("  -input-file <csv>     Batch mode: flash one unit per CSV row (header = flash field IDs)")
+	fmt.Println("  -rollback-fru <session.yaml> Restore FRU from the pre-flash backup of that session")
+	fmt.Println("  -rollback-efi <session.yaml> Restore EFI variables from that session's backups (needs -c for guid_prefix)")
+	fmt.Println("  -efi-maintenance Interactively list and delete firestarter boot entries and EFI variables")
+	fmt.Println("  -efi-cleanup     Delete boot entries and EFI variables per system.efi_cleanup without prompting, then exit")
+	fmt.Println("  -non-interactive Do not prompt the operator, use defaults")
+	fmt.Println("  -show-resources  Capture and show memory/CPU usage of each test")
+	fmt.Println("  -debug           Show debug output (configuration defaults applied, etc.)")
+	fmt.Println("  -prune-logs      Apply log.retention to the log directory and exit")
+	fmt.Println("  -upload-manifest Upload daily session manifests (log.manifest) to the log server and exit")
+	fmt.Println("  -print-plan[=json] Print groups, tests, timeouts, flash operations and log destinations, run nothing")
+	fmt.Println("  -continue        Run tests.post_reboot_groups for the session waiting on this board's serial (autostart)")
+	fmt.Println("  -resume <session_current.yaml> Resume an interrupted session, skipping completed tests and flash operations")
+	fmt.Println("  -fru-status      Print FRU health and raw dump; exit 0 healthy, 1 unreadable, 2 empty, 3 bad header, 4 bad area")
+	fmt.Println("  -inventory <path> Write hardware inventory to YAML and exit (no tests/flash)")
+	fmt.Println("  -grpc-addr <addr> Serve StatusService (test results stream, current session) for dashboards")
+	fmt.Println("  -quiet      One live status line instead of test sections; failures and summary print in full")
+	fmt.Println("  -refresh-config Download the station configuration from config_source into the cache and exit")
+	fmt.Println("  -validate-config Validate the configuration, print it as written and fully expanded, and exit")
+	fmt.Println("  -finish-action <action> prompt, reboot, shutdown, none or reboot-if-serial-changed (overrides pipeline.finish_action)")
+	fmt.Println("  -completion <shell> Print a bash, zsh or fish completion script and exit")
+	fmt.Println("  -h          Show this help")
+}
 
-		outputMgr.PrintResult(time.Now(), test.Name, result.Status, result.Duration, result.Error)
+// completionShells - оболочки, для которых -completion печатает скрипт
+var completionShells = []string{"bash", "zsh", "fish"}
 
-		// Решаем, показывать ли полный вывод:
-		if output != "" && !(result.Status == "PASSED" && test.Collapse) {
-			outputMgr.PrintSection(test.Name+" Output", output)
-		}
+// completionFlag - флаг для скрипта дополнения
+type completionFlag struct {
+	Name  string
+	Usage string
+	Bool  bool // Без значения (-debug, -print-plan)
+}
 
-		if result.Status == "PASSED" {
-			return result
+// completionFlags собирает все зарегистрированные флаги (flag.VisitAll - по алфавиту)
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		isBool := false
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok {
+			isBool = b.IsBoolFlag()
 		}
+		flags = append(flags, completionFlag{Name: f.Name, Usage: f.Usage, Bool: isBool})
+	})
+	return flags
+}
 
-		action := askUserAction(test.Name)
-		switch action {
-		case "RETRY":
-			// Показываем вывод предыдущего неудачного теста перед повтором
-			if result.Output != "" {
-				fmt.Printf("%sPrevious test output:%s\n", ColorYellow, ColorReset)
-				outputMgr.PrintSection(test.Name+" Previous Output", result.Output)
+// generateCompletion возвращает скрипт дополнения флагов для bash, zsh или fish.
+// Для -c дополняются YAML файлы, для -completion - имена оболочек, для остальных значений - файлы
+func generateCompletion(shell string) (string, error) {
+	flags := completionFlags()
+	var b strings.Builder
+
+	switch shell {
+	case "bash":
+		var names, valueFlags []string
+		for _, f := range flags {
+			names = append(names, "-"+f.Name)
+			if !f.Bool && f.Name != "c" && f.Name != "completion" {
+				valueFlags = append(valueFlags, "-"+f.Name, "--"+f.Name)
 			}
+		}
+		b.WriteString("# bash completion for firestarter (firestarter -completion bash)\n")
+		b.WriteString("_firestarter() {\n")
+		b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+		b.WriteString("    case \"$prev\" in\n")
+		b.WriteString("        -c|--c)\n")
+		b.WriteString("            COMPREPLY=( $(compgen -f -X '!*.@(yaml|yml)' -- \"$cur\") $(compgen -d -- \"$cur\") )\n")
+		b.WriteString("            return ;;\n")
+		b.WriteString("        -completion|--completion)\n")
+		fmt.Fprintf(&b, "            COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(completionShells, " "))
+		b.WriteString("            return ;;\n")
+		if len(valueFlags) > 0 {
+			fmt.Fprintf(&b, "        %s)\n", strings.Join(valueFlags, "|"))
+			b.WriteString("            COMPREPLY=( $(compgen -f -- \"$cur\") )\n")
+			b.WriteString("            return ;;\n")
+		}
+		b.WriteString("    esac\n")
+		fmt.Fprintf(&b, "    COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(names, " "))
+		b.WriteString("}\n")
+		b.WriteString("complete -F _firestarter firestarter\n")
+
+	case "zsh":
+		escape := strings.NewReplacer("'", "'\\''", "[", "\\[", "]", "\\]", ":", "\\:")
+		b.WriteString("#compdef firestarter\n")
+		b.WriteString("# zsh completion for firestarter (firestarter -completion zsh)\n")
+		b.WriteString("_arguments")
+		for _, f := range flags {
+			spec := fmt.Sprintf("-%s[%s]", f.Name, escape.Replace(f.Usage))
+			switch {
+			case f.Name == "c":
+				spec += ":config file:_files -g \"*.(yaml|yml)\""
+			case f.Name == "completion":
+				spec += fmt.Sprintf(":shell:(%s)", strings.Join(completionShells, " "))
+			case !f.Bool:
+				spec += ":value:_files"
+			}
+			fmt.Fprintf(&b, " \\\n    '%s'", spec)
+		}
+		b.WriteString("\n")
+
+	case "fish":
+		escape := strings.NewReplacer("\\", "\\\\", "'", "\\'")
+		b.WriteString("# fish completion for firestarter (firestarter -completion fish)\n")
+		for _, f := range flags {
+			line := fmt.Sprintf("complete -c firestarter -o %s -d '%s'", f.Name, escape.Replace(f.Usage))
+			switch {
+			case f.Name == "c":
+				line += " -r -a '(__fish_complete_suffix .yaml; __fish_complete_suffix .yml)'"
+			case f.Name == "completion":
+				line += fmt.Sprintf(" -x -a '%s'", strings.Join(completionShells, " "))
+			case !f.Bool:
+				line += " -r -F"
+			}
+			b.WriteString(line + "\n")
+		}
 
-			fmt.Printf("%sRetrying test '%s' (attempt %d)...%s\n\n", ColorBlue, test.Name, attempts+1, ColorReset)
-			continue
-		case "SKIP":
-			result.Status = "SKIPPED"
-			result.Error = "Skipped by operator"
-			return result
-		case "CONTINUE":
-			return result
+	default:
+		return "", fmt.Errorf("unsupported shell %q (expected %s)", shell, strings.Join(completionShells, ", "))
+	}
+	return b.String(), nil
+}
+
+// defaultMinFreeSpaceMB - минимальный запас места под логи, если в конфиге не задан
+const defaultMinFreeSpaceMB = 50
+
+// hasFlashOperation проверяет, включена ли операция прошивки в конфиге
+func hasFlashOperation(config FlashConfig, operation string) bool {
+	if !config.Enabled {
+		return false
+	}
+	for _, op := range config.Operations {
+		if op == operation {
+			return true
 		}
 	}
+	return false
+}
 
-	// Если дошли до лимита попыток
-	fmt.Printf("%sMaximum retry attempts (%d) reached for test '%s'%s\n", ColorRed, maxAttempts, test.Name, ColorReset)
-	finalResult, finalOutput := executeTest(test, globalTimeout)
-	finalResult.Attempts = attempts
-	finalResult.Output = finalOutput
+// requiredTools возвращает внешние утилиты, нужные активным секциям конфига
+func requiredTools(config Config) []string {
+	tools := []string{"dmidecode"}
 
-	outputMgr.PrintResult(time.Now(), test.Name, finalResult.Status, finalResult.Duration, finalResult.Error)
-	if finalOutput != "" && !(finalResult.Status == "PASSED" && test.Collapse) {
-		outputMgr.PrintSection(test.Name+" Output", finalOutput)
+	if hasFlashOperation(config.Flash, "mac") {
+		switch resolveFlashMethod(config.Flash.Method) {
+		case "rtnicpg":
+			tools = append(tools, "rtnic", "insmod", "rmmod", "modprobe")
+		default:
+			tools = append(tools, "rmmod", "modprobe") // eeupdate ищется по архитектуре (needsEeupdate)
+		}
 	}
-	return finalResult
+	if hasFlashOperation(config.Flash, "fru") {
+		tools = append(tools, "frugen", "ipmitool")
+	} else if config.Flash.Enabled && (config.Flash.TemperatureCheckEnabled || config.Flash.PSUCheckEnabled) {
+		tools = append(tools, "ipmitool")
+	}
+	if hasFlashOperation(config.Flash, "smbios") {
+		tools = append(tools, config.Flash.SMBIOS.ToolPath)
+	}
+	if hasFlashOperation(config.Flash, "efi") || hasFlashOperation(config.Flash, "fru") || hasFlashOperation(config.Flash, "smbios") {
+		// Смена серийника ведет к перезагрузке через one-time boot
+		tools = append(tools, "efibootmgr", "bootctl")
+	}
+	if config.Log.SendLogs {
+		tools = append(tools, "ssh", "scp")
+	}
+
+	return tools
 }
 
-// runParallelTestsWithRetries выполняет набор тестов параллельно, а потом последовательно обрабатывает упавшие,
-// показывая при этом сразу причину и вывод для каждого неудачного теста.
-func runParallelTestsWithRetries(tests []TestSpec, outputMgr *OutputManager, globalTimeout string) []TestResult {
-	results := make([]TestResult, len(tests))
-	finalResults := make([]TestResult, len(tests))
+var toolVersionRegex = regexp.MustCompile(`(\d+\.\d+[\.\d]*)`)
 
-	// --- Параллельный запуск ---
-	var wg sync.WaitGroup
-	for i, t := range tests {
-		wg.Add(1)
-		go func(idx int, test TestSpec) {
-			defer wg.Done()
+// toolVersionCommand - команда, печатающая версию утилиты
+type toolVersionCommand struct {
+	Tool string
+	Args []string
+}
 
-			outputMgr.PrintResult(time.Now(), test.Name, "RUNNING", 0, "")
-			res, out := executeTest(test, globalTimeout)
-			res.Attempts = 1
-			res.Output = out
+// Команды версий утилит прошивки MAC
+var (
+	rtnicVersionCommand = toolVersionCommand{"rtnic", []string{"--version"}}
+)
 
-			outputMgr.PrintResult(time.Now(), test.Name, res.Status, res.Duration, res.Error)
-			if out != "" && !(res.Status == "PASSED" && test.Collapse) {
-				outputMgr.PrintSection(test.Name+" Output", out)
-			}
+// eeupdateVersionCommand - версия той сборки eeupdate, что подходит архитектуре станции
+func eeupdateVersionCommand() toolVersionCommand {
+	binary, err := selectEeupdateBinary(eeupdateSearchPaths)
+	if err != nil {
+		binary = "eeupdate64e"
+	}
+	return toolVersionCommand{binary, []string{"/h"}}
+}
+
+// queryToolVersion запускает команду версии и ищет номер версии в выводе
+func queryToolVersion(c toolVersionCommand) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// Часть утилит печатает справку с ненулевым кодом - версию ищем в выводе в любом случае
+	output, err := tracedCombinedOutput(exec.CommandContext(ctx, c.Tool, c.Args...))
 
-			results[idx] = res
-		}(i, t)
+	match := toolVersionRegex.FindString(string(output))
+	if match == "" {
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("could not parse version from output")
 	}
-	wg.Wait()
+	return match, nil
+}
 
-	// --- Подсчитываем упавшие ---
-	failedCount := 0
-	for _, r := range results {
-		if r.Status == "FAILED" || r.Status == "TIMEOUT" {
-			failedCount++
+// collectToolVersions опрашивает версии утилит, используемых в текущей конфигурации
+func collectToolVersions(config Config) map[string]string {
+	var commands []toolVersionCommand
+
+	if hasFlashOperation(config.Flash, "fru") {
+		commands = append(commands,
+			toolVersionCommand{"ipmitool", []string{"-V"}},
+			toolVersionCommand{"frugen", []string{"--version"}})
+	}
+	if hasFlashOperation(config.Flash, "mac") {
+		switch resolveFlashMethod(config.Flash.Method) {
+		case "rtnicpg":
+			commands = append(commands, rtnicVersionCommand)
+		default:
+			commands = append(commands, eeupdateVersionCommand())
 		}
+		commands = append(commands, toolVersionCommand{"modinfo", []string{"--version"}})
 	}
-	if failedCount > 0 {
-		fmt.Printf("\n%sParallel complete: %d failed test(s)%s\n", ColorYellow, failedCount, ColorReset)
-	} else {
-		fmt.Printf("\n%sAll parallel tests passed%s\n", ColorGreen, ColorReset)
+	if hasFlashOperation(config.Flash, "efi") || hasFlashOperation(config.Flash, "fru") || hasFlashOperation(config.Flash, "smbios") {
+		commands = append(commands, toolVersionCommand{"efibootmgr", []string{"--version"}})
 	}
 
-	// --- Последовательная доработка упавших ---
-	proc := 0
-	for i, r := range results {
-		if r.Status == "PASSED" {
-			finalResults[i] = r
+	versions := make(map[string]string)
+	for _, c := range commands {
+		version, err := queryToolVersion(c)
+		if err != nil {
+			printWarning(fmt.Sprintf("Could not query %s version: %v", c.Tool, err))
 			continue
 		}
-		proc++
-		if proc > 1 {
-			fmt.Println()
-		}
-		fmt.Printf("%sProcessing failed test %d/%d: %s%s\n",
-			ColorBlue, proc, failedCount, tests[i].Name, ColorReset)
+		versions[c.Tool] = version
+	}
+
+	return versions
+}
 
-		// Всегда показываем причину и вывод перед retry/skip
-		fmt.Printf("  Status: %s%s%s\n", ColorRed, r.Status, ColorReset)
-		if r.Error != "" {
-			fmt.Printf("  Error : %s\n", r.Error)
+// freeSpaceMB возвращает свободное место в мегабайтах для пути (или ближайшего существующего родителя)
+func freeSpaceMB(path string) (uint64, error) {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
 		}
-		if r.Output != "" {
-			outputMgr.PrintSection(tests[i].Name+" Output", r.Output)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
 		}
-
-		finalResults[i] = handleFailedTestWithRetries(tests[i], r, outputMgr, globalTimeout)
+		dir = parent
 	}
 
-	return finalResults
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize) / (1024 * 1024), nil
 }
 
-// handleFailedTestWithRetries предлагает retry/skip/continue до 5 раз
-func handleFailedTestWithRetries(test TestSpec, initialResult TestResult, outputMgr *OutputManager, globalTimeout string) TestResult {
-	currentResult := initialResult
-	attempts := initialResult.Attempts
-	maxAttempts := 5
+// runPreFlightChecks проверяет окружение до начала сессии, чтобы не падать посреди прошивки
+func runPreFlightChecks(config Config) (warnings []string, errs []error) {
+	// Root privileges
+	if os.Geteuid() != 0 {
+		if config.System.RequireRoot {
+			errs = append(errs, fmt.Errorf("this program requires root privileges"))
+		} else {
+			warnings = append(warnings, "not running as root - hardware access may fail")
+		}
+	}
 
-	for attempts < maxAttempts && currentResult.Status != "PASSED" {
-		action := askUserAction(test.Name)
-		switch action {
-		case "RETRY":
-			attempts++
+	// EFI variables
+	if _, err := os.Stat("/sys/firmware/efi/efivars"); err != nil {
+		if hasFlashOperation(config.Flash, "efi") {
+			errs = append(errs, fmt.Errorf("efivars not available at /sys/firmware/efi/efivars (required for 'efi' operation)"))
+		} else {
+			warnings = append(warnings, "efivars not available at /sys/firmware/efi/efivars")
+		}
+	}
 
-			// Показываем вывод предыдущего неудачного теста перед повтором
-			if currentResult.Output != "" {
-				fmt.Printf("%sPrevious test output:%s\n", ColorYellow, ColorReset)
-				outputMgr.PrintSection(test.Name+" Previous Output", currentResult.Output)
-			}
+	// External tools
+	for _, tool := range requiredTools(config) {
+		if _, err := exec.LookPath(tool); err != nil {
+			errs = append(errs, fmt.Errorf("required tool not found in PATH: %s", tool))
+		}
+	}
+	if needsEeupdate(config.Flash) {
+		if _, err := selectEeupdateBinary(config.System.EeupdateSearchPaths); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
-			fmt.Printf("%sRetrying test '%s' (attempt %d)...%s\n\n", ColorBlue, test.Name, attempts, ColorReset)
-			outputMgr.PrintResult(time.Now(), test.Name, "RUNNING", 0, "")
-			result, output := executeTest(test, globalTimeout)
-			result.Attempts = attempts
-			result.Output = output
-			outputMgr.PrintResult(time.Now(), test.Name, result.Status, result.Duration, result.Error)
-			currentResult = result
-		case "SKIP":
-			currentResult.Status = "SKIPPED"
-			currentResult.Error = "Skipped by operator"
-			outputMgr.PrintResult(time.Now(), test.Name, currentResult.Status, currentResult.Duration, currentResult.Error)
-			return currentResult
-		case "CONTINUE":
-			return currentResult
+	// Free space for logs
+	if config.Log.SaveLocal {
+		logDir := config.Log.LogDir
+		if logDir == "" {
+			logDir = "logs"
+		}
+		minFree := config.Log.MinFreeSpaceMB
+		if minFree <= 0 {
+			minFree = defaultMinFreeSpaceMB
+		}
+		if free, err := freeSpaceMB(logDir); err != nil {
+			warnings = append(warnings, fmt.Sprintf("cannot determine free space for %s: %v", logDir, err))
+		} else if free < uint64(minFree) {
+			errs = append(errs, fmt.Errorf("not enough free space in %s: %d MB available, %d MB required", logDir, free, minFree))
 		}
 	}
 
-	if attempts >= maxAttempts && currentResult.Status != "PASSED" {
-		fmt.Printf("%sMaximum retry attempts (%d) reached for test '%s'%s\n", ColorRed, maxAttempts, test.Name, ColorReset)
+	// BIOS version
+	if config.System.MinBIOSVersion != "" {
+		if err := checkBIOSVersion(config.System); err != nil {
+			if config.System.BIOSVersionCheckMode == "abort" {
+				errs = append(errs, err)
+			} else {
+				printError("  ! " + err.Error())
+			}
+		}
 	}
 
-	return currentResult
+	return warnings, errs
 }
 
-func runTestGroup(tests []TestSpec, parallel bool, outputMgr *OutputManager, groupName, globalTimeout string) []TestResult {
-	fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(groupName), ColorReset)
-
-	mode := "Sequential"
-	if parallel {
-		mode = "Parallel"
+// checkBIOSVersion сравнивает версию BIOS из dmidecode (type 0) с system.min_bios_version
+func checkBIOSVersion(config SystemConfig) error {
+	output, err := runDMIDecodeType("0")
+	if err != nil {
+		return fmt.Errorf("cannot check BIOS version: %v", err)
+	}
+	_, detected, _ := parseBIOSInformation(parseDMIDecode(output))
+	if detected == "" {
+		return fmt.Errorf("cannot check BIOS version: no Version in BIOS Information")
 	}
+	cmp, err := compareBIOSVersions(detected, config.MinBIOSVersion)
+	if err != nil {
+		return fmt.Errorf("cannot check BIOS version: %v", err)
+	}
+	if cmp < 0 {
+		return fmt.Errorf("BIOS version %s is older than required %s (EFI variable writes may be lost)", detected, config.MinBIOSVersion)
+	}
+	return nil
+}
 
-	fmt.Printf("Mode: %s%s%s | Tests: %s%d%s | Timeout: %s%s%s\n",
-		ColorCyan, mode, ColorReset,
-		ColorGreen, len(tests), ColorReset,
-		ColorYellow, func() string {
-			if globalTimeout != "" {
-				return globalTimeout
+// compareBIOSVersions сравнивает версии вида 1.2.10 по числовым компонентам (недостающие - 0).
+// Возвращает -1, 0 или 1, как detected < = > minimum
+func compareBIOSVersions(detected, minimum string) (int, error) {
+	a, err := parseBIOSVersion(detected)
+	if err != nil {
+		return 0, err
+	}
+	b, err := parseBIOSVersion(minimum)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
 			}
-			return "30s (default)"
-		}(), ColorReset)
-
-	printSeparator()
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
 
-	var results []TestResult
-	if parallel {
-		results = runParallelTestsWithRetries(tests, outputMgr, globalTimeout)
-	} else {
-		results = make([]TestResult, len(tests))
-		for i, test := range tests {
-			results[i] = runTest(test, outputMgr, globalTimeout)
+// parseBIOSVersion разбирает "1.02.3" (допускается префикс v) на числа
+func parseBIOSVersion(version string) ([]int, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(version), "v"), "V")
+	var parts []int
+	for _, field := range strings.Split(trimmed, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("BIOS version %q is not dot-separated numbers", version)
 		}
+		parts = append(parts, n)
 	}
+	return parts, nil
+}
 
-	// Выводим сводку группы в enterprise стиле
-	fmt.Printf("\n%sGROUP RESULTS%s\n", ColorWhite, ColorReset)
-	printSeparator()
+// PrereqFailure - непройденное требование к BIOS/ядру для прошивки и что именно поменять
+type PrereqFailure struct {
+	Check      string   `yaml:"check"`
+	Problem    string   `yaml:"problem"`
+	Fix        string   `yaml:"fix"`
+	Operations []string `yaml:"operations"` // Операции прошивки этой сессии, которые не могут выполниться
+}
 
-	passed := 0
-	failed := 0
-	skipped := 0
+// prereqBlockedOperations - какие операции прошивки не работают при непройденной проверке.
+// nic-checksum, smbios и fru от EFI, Secure Boot и доступа к памяти не зависят
+var prereqBlockedOperations = map[string][]string{
+	"efivarfs":        {"efi"},
+	"Secure Boot":     {"efi", "mac"}, // Запись EFI и неподписанные драйверы прошивки NIC
+	"kernel lockdown": {"mac"},        // /dev/mem и загрузка драйверов eeupdate/pgdrv
+	"iomem":           {"mac"},
+}
 
-	var passedTests []string
-	var failedTests []string
-	var skippedTests []string
+// prereqPaths - файлы, по которым проверяются требования к прошивке (подменяются на дерево-фикстуру)
+type prereqPaths struct {
+	Mounts   string
+	EFIVars  string
+	Lockdown string
+	Cmdline  string
+}
 
-	for _, result := range results {
-		switch result.Status {
-		case "PASSED":
-			passed++
-			passedTests = append(passedTests, result.Name)
-		case "FAILED", "TIMEOUT":
-			failed++
-			failedTests = append(failedTests, result.Name)
-		case "SKIPPED":
-			skipped++
-			skippedTests = append(skippedTests, result.Name)
+var systemPrereqPaths = prereqPaths{
+	Mounts:   "/proc/mounts",
+	EFIVars:  "/sys/firmware/efi/efivars",
+	Lockdown: "/sys/kernel/security/lockdown",
+	Cmdline:  "/proc/cmdline",
+}
+
+// secureBootVariable - глобальная переменная SecureBoot (EFI_GLOBAL_VARIABLE GUID)
+const secureBootVariable = "SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// precheckVariable - пробная переменная под GUID станции: создается и сразу удаляется
+const precheckVariable = "FirestarterPrecheck"
+
+// needsFlashPrerequisites - проверка нужна для операций, которые пишут в EFI или работают с NIC/BMC напрямую
+func needsFlashPrerequisites(config FlashConfig) bool {
+	return hasFlashOperation(config, "efi") || hasFlashOperation(config, "mac") || hasFlashOperation(config, "fru")
+}
+
+// checkFlashPrerequisites проверяет настройки BIOS и ядра, без которых прошивка падает с невнятными ошибками
+func checkFlashPrerequisites(flash FlashConfig, system SystemConfig, paths prereqPaths) []PrereqFailure {
+	var failures []PrereqFailure
+	// Проверка учитывается, только если мешает одной из выбранных операций
+	add := func(f *PrereqFailure) {
+		if f == nil {
+			return
+		}
+		for _, op := range prereqBlockedOperations[f.Check] {
+			if hasFlashOperation(flash, op) {
+				f.Operations = append(f.Operations, op)
+			}
+		}
+		if len(f.Operations) > 0 {
+			failures = append(failures, *f)
 		}
 	}
 
-	// Определяем статус группы
-	groupStatus := "PASSED"
-	if failed > 0 {
-		groupStatus = "FAILED"
-	} else if skipped > 0 {
-		groupStatus = "PARTIAL"
+	add(checkEFIVarsWritable(paths.Mounts, paths.EFIVars, system.GuidPrefix))
+	add(checkSecureBoot(paths.EFIVars))
+	add(checkKernelLockdown(paths.Lockdown))
+	// iomem=strict закрывает BAR сетевой карты для pgdrv, eeupdate это не мешает
+	if hasFlashOperation(flash, "mac") && flash.Method != "eeupdate" {
+		add(checkIOMemRestriction(paths.Cmdline))
 	}
+	return failures
+}
 
-	// Выводим статистику
-	fmt.Printf("  %s%-20s%s: ", ColorWhite, groupName, ColorReset)
-	switch groupStatus {
-	case "PASSED":
-		fmt.Printf("%s PASSED %s", ColorBgGreen, ColorReset)
-	case "FAILED":
-		fmt.Printf("%s FAILED %s %s(%d of %d tests failed)%s",
-			ColorBgRed, ColorReset, ColorGray, failed, len(tests), ColorReset)
-	case "PARTIAL":
-		fmt.Printf("%s PARTIAL %s %s(%d passed, %d skipped)%s",
-			ColorBgYellow, ColorReset, ColorGray, passed, skipped, ColorReset)
+// checkEFIVarsWritable проверяет, что efivarfs смонтирован rw и принимает запись под GUID станции
+func checkEFIVarsWritable(mountsPath, efivarsDir, guid string) *PrereqFailure {
+	failure := func(problem, fix string) *PrereqFailure {
+		return &PrereqFailure{Check: "efivarfs", Problem: problem, Fix: fix}
 	}
-	fmt.Println()
 
-	// Выводим списки тестов
-	if len(passedTests) > 0 {
-		fmt.Printf("  %sPassed:%s %s\n", ColorGreen, ColorReset, strings.Join(passedTests, ", "))
+	data, err := os.ReadFile(mountsPath)
+	if err != nil {
+		return failure(fmt.Sprintf("cannot read %s: %v", mountsPath, err), "Run on a Linux system with /proc mounted")
 	}
-	if len(failedTests) > 0 {
-		fmt.Printf("  %sFailed:%s %s\n", ColorRed, ColorReset, strings.Join(failedTests, ", "))
+	var options string
+	mounted := false
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 4 && fields[2] == "efivarfs" && fields[1] == efivarsDir {
+			mounted = true
+			options = fields[3]
+		}
 	}
-	if len(skippedTests) > 0 {
-		fmt.Printf("  %sSkipped:%s %s\n", ColorYellow, ColorReset, strings.Join(skippedTests, ", "))
+	if !mounted {
+		if _, err := os.Stat(filepath.Dir(efivarsDir)); err != nil {
+			return failure("system booted in legacy (CSM) mode - no EFI runtime services",
+				"BIOS: disable CSM / Legacy boot and boot the station image in UEFI mode")
+		}
+		return failure(fmt.Sprintf("efivarfs is not mounted at %s", efivarsDir),
+			fmt.Sprintf("mount -t efivarfs efivarfs %s", efivarsDir))
+	}
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "ro" {
+			return failure(fmt.Sprintf("efivarfs is mounted read-only at %s", efivarsDir),
+				fmt.Sprintf("mount -o remount,rw %s (or drop 'ro' from its fstab entry)", efivarsDir))
+		}
 	}
 
-	return results
+	if guid == "" {
+		return nil
+	}
+	// Пробная запись: атрибуты NV|BS|RT (little endian) и один байт данных
+	path := filepath.Join(efivarsDir, precheckVariable+"-"+strings.ToLower(guid))
+	if err := os.WriteFile(path, []byte{0x07, 0x00, 0x00, 0x00, 0x00}, 0644); err != nil {
+		return failure(fmt.Sprintf("test variable write failed: %v", err),
+			"BIOS: disable Secure Boot and any \"UEFI variable write protection\" option; kernel: boot without efi=noruntime")
+	}
+	if err := os.Remove(path); err != nil {
+		return failure(fmt.Sprintf("test variable %s written but not deleted: %v", filepath.Base(path), err),
+			fmt.Sprintf("chattr -i %s && rm %s", path, path))
+	}
+	return nil
 }
 
-func getFlashData(config FlashConfig, productName string) (*FlashData, error) {
-	if !config.Enabled || len(config.Fields) == 0 {
-		return nil, nil
+// checkSecureBoot читает SecureBoot из efivarfs: 4 байта атрибутов и байт значения (1 - включен).
+// Нет переменной - прошивка не поддерживает Secure Boot, это не ошибка
+func checkSecureBoot(efivarsDir string) *PrereqFailure {
+	data, err := os.ReadFile(filepath.Join(efivarsDir, secureBootVariable))
+	if err != nil || len(data) < 5 {
+		return nil
 	}
-
-	if productName == "" {
-		return nil, fmt.Errorf("product name not detected")
+	if data[4] == 1 {
+		return &PrereqFailure{
+			Check:   "Secure Boot",
+			Problem: "Secure Boot is enabled - EFI writes and unsigned flashing drivers are rejected",
+			Fix:     "BIOS: Security > Secure Boot > Disabled",
+		}
 	}
+	return nil
+}
 
-	printSectionHeader("FLASH DATA COLLECTION")
-	fmt.Printf("Product: %s%s%s\n", ColorGreen, productName, ColorReset)
-	fmt.Printf("Method: %s%s%s\n", ColorGreen, config.Method, ColorReset)
-	if len(config.VenDevice) > 0 {
-		fmt.Printf("Target Devices: %s%s%s\n", ColorYellow, strings.Join(config.VenDevice, ", "), ColorReset)
+// checkKernelLockdown проверяет режим lockdown ("none [integrity] confidentiality" - активный в скобках)
+func checkKernelLockdown(lockdownPath string) *PrereqFailure {
+	data, err := os.ReadFile(lockdownPath)
+	if err != nil {
+		return nil // Нет LSM lockdown - ограничений нет
+	}
+	for _, mode := range strings.Fields(string(data)) {
+		if strings.HasPrefix(mode, "[") && mode != "[none]" {
+			return &PrereqFailure{
+				Check:   "kernel lockdown",
+				Problem: fmt.Sprintf("kernel lockdown is active (%s) - /dev/mem and module loading are restricted", strings.Trim(mode, "[]")),
+				Fix:     "BIOS: disable Secure Boot (lockdown follows it); kernel: add lockdown=none or remove lockdown=... from the command line",
+			}
+		}
 	}
+	return nil
+}
 
-	// Prepare fields that need flashing
-	requiredFields := make(map[string]*FlashField)
-	flashFields := make(map[string]*FlashField)
-
-	fmt.Printf("\nRequired fields:\n")
-	for i := range config.Fields {
-		field := &config.Fields[i]
-		_, err := regexp.Compile(field.Regex)
-		if err != nil {
-			return nil, fmt.Errorf("invalid regex for field %s: %v", field.Name, err)
+// checkIOMemRestriction ищет iomem=strict в командной строке ядра - с ним rtnic не получает доступ к регистрам NIC
+func checkIOMemRestriction(cmdlinePath string) *PrereqFailure {
+	data, err := os.ReadFile(cmdlinePath)
+	if err != nil {
+		return nil
+	}
+	for _, param := range strings.Fields(string(data)) {
+		if param == "iomem=strict" {
+			return &PrereqFailure{
+				Check:   "iomem",
+				Problem: "kernel booted with iomem=strict - rtnic cannot map NIC registers",
+				Fix:     "kernel: replace iomem=strict with iomem=relaxed on the command line",
+			}
 		}
+	}
+	return nil
+}
 
-		requiredFields[field.ID] = field
-		if field.Flash {
-			flashFields[field.ID] = field
-			fmt.Printf("  %s[FLASH]%s %s (format: %s)\n", ColorYellow, ColorReset, field.Name, field.Regex)
-		} else {
-			fmt.Printf("  %s[STORE]%s %s (format: %s)\n", ColorBlue, ColorReset, field.Name, field.Regex)
+// prereqBlockingChecks - непройденные проверки, из-за которых операция op не выполняется
+func prereqBlockingChecks(failures []PrereqFailure, op string) []string {
+	var checks []string
+	for _, f := range failures {
+		for _, blocked := range f.Operations {
+			if blocked == op {
+				checks = append(checks, f.Check)
+				break
+			}
 		}
 	}
+	return checks
+}
 
-	provided := make(map[string]string)
-	reader := bufio.NewReader(os.Stdin)
-
-	fmt.Printf("\nEnter values (program will auto-detect field type):\n")
-
-	for len(provided) < len(requiredFields) {
-		fmt.Printf("\nRemaining fields: %d\n", len(requiredFields)-len(provided))
-		fmt.Printf("Enter value: ")
-
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			return nil, err
+// confirmPrerequisiteFailures спрашивает оператора, продолжать ли сессию без заблокированных операций.
+// Нужен явный ответ y: без терминала сессия останавливается до тестов
+func confirmPrerequisiteFailures(failures []PrereqFailure, reader *bufio.Reader) bool {
+	blocked := make(map[string]bool)
+	var ops []string
+	for _, f := range failures {
+		for _, op := range f.Operations {
+			if !blocked[op] {
+				blocked[op] = true
+				ops = append(ops, op)
+			}
 		}
-		input = strings.TrimSpace(input)
+	}
+	printWarning(fmt.Sprintf("Blocked flash operation(s): %s - the rest of the session can still run", strings.Join(ops, ", ")))
+	if !isInteractive() {
+		printError("Non-interactive mode: stopping before tests - fix the prerequisites and run again")
+		return false
+	}
+	defer sessionTimer.operatorWait()()
+	fmt.Printf("Continue with tests and the remaining flash operations? %s[y/N]%s: ", ColorYellow, ColorReset)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	input = strings.ToUpper(strings.TrimSpace(input))
+	return input == "Y" || input == "YES"
+}
 
-		if input == "" {
-			fmt.Printf("%sInput cannot be empty. Please re-enter.%s\n", ColorRed, ColorReset)
-			continue
+// printPrerequisiteFailures выводит непройденные требования одной таблицей
+func printPrerequisiteFailures(failures []PrereqFailure) {
+	checkWidth := len("CHECK")
+	for _, f := range failures {
+		if len(f.Check) > checkWidth {
+			checkWidth = len(f.Check)
 		}
+	}
+	outputManager.ClearStatus()
+	fmt.Printf("  %s%-*s  %s%s\n", ColorWhite, checkWidth, "CHECK", "PROBLEM / HOW TO FIX", ColorReset)
+	for _, f := range failures {
+		fmt.Printf("  %s%-*s%s  %s (blocks %s)\n", ColorRed, checkWidth, f.Check, ColorReset, f.Problem, strings.Join(f.Operations, ", "))
+		fmt.Printf("  %-*s  %s→ %s%s\n", checkWidth, "", ColorYellow, f.Fix, ColorReset)
+	}
+}
 
-		matched := false
-		for fieldID, field := range requiredFields {
-			if _, ok := provided[fieldID]; ok {
-				continue
-			}
-
-			regex, _ := regexp.Compile(field.Regex) // Already validated above
-			if regex.MatchString(input) {
-				provided[fieldID] = input
-				flashStatus := ""
-				if field.Flash {
-					flashStatus = fmt.Sprintf(" %s[WILL FLASH]%s", ColorYellow, ColorReset)
-				} else {
-					flashStatus = fmt.Sprintf(" %s[STORED ONLY]%s", ColorBlue, ColorReset)
-				}
-				fmt.Printf("%s%s accepted: %s%s%s\n", ColorGreen, field.Name, input, flashStatus, ColorReset)
-				matched = true
-				break
-			}
-		}
+// loadConfig загружает конфиг, раскрывает include/test_library и подставляет значения по умолчанию.
+// Возвращает исходный конфиг (как в файле) и развернутый - тот, что реально выполняется.
+// tests.test_generator_command здесь не запускается - это делает expandGeneratedTests перед сессией
+func loadConfig(configPath string) (*Config, *Config, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		if !matched {
-			fmt.Printf("%sInput does not match any expected format. Please try again.%s\n", ColorRed, ColorReset)
-		}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, err
 	}
 
-	flashData := &FlashData{}
+	var raw Config
+	if err := root.Decode(&raw); err != nil {
+		return nil, nil, err
+	}
 
-	// Map fields to FlashData structure
-	for fieldID, value := range provided {
-		switch fieldID {
-		case "system-serial-number":
-			flashData.SystemSerial = value
-		case "io_board":
-			flashData.IOBoard = value
-		case "mac_address":
-			flashData.MAC = value
-		}
+	absPath, _ := filepath.Abs(configPath)
+	library, err := loadTestLibrary(configPath, &root, []string{absPath})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	fmt.Printf("\n%sCollected data summary:%s\n", ColorGreen, ColorReset)
-	if flashData.SystemSerial != "" {
-		fmt.Printf("  System Serial: %s\n", flashData.SystemSerial)
+	// Развернутая копия: декодируем заново и подставляем библиотечные тесты
+	var expanded Config
+	if err := root.Decode(&expanded); err != nil {
+		return nil, nil, err
 	}
-	if flashData.IOBoard != "" {
-		fmt.Printf("  IO Board: %s\n", flashData.IOBoard)
+	if err := expandTestReferences(&expanded, &root, library, configPath); err != nil {
+		return nil, nil, err
 	}
-	if flashData.MAC != "" {
-		fmt.Printf("  MAC Address: %s\n", flashData.MAC)
+	expanded.Include = nil
+	expanded.TestLibrary = nil
+	applyConfigDefaults(&expanded)
+	if err := validateConfig(&expanded); err != nil {
+		return nil, nil, err
 	}
 
-	return flashData, nil
+	return &raw, &expanded, nil
 }
 
-func getSystemInfo() (SystemInfo, error) {
-	info := SystemInfo{
-		Timestamp: time.Now(),
+// expandGeneratedTests добавляет в конфиг сессии тесты tests.test_generator_command.
+// Генератор - внешняя команда, поэтому он запускается только перед сессией, а не при каждой загрузке конфига
+func expandGeneratedTests(config *Config) error {
+	if config.Tests.TestGeneratorCommand == "" {
+		return nil
 	}
-
-	// Get IP address
-	if ip, err := getIPAddress(); err == nil {
-		info.IP = ip
+	if err := mergeGeneratedTests(&config.Tests); err != nil {
+		return err
 	}
+	return validateConfig(config)
+}
 
-	// Get original MAC addresses from all network interfaces
-	if interfaces, err := getCurrentNetworkInterfaces(); err == nil {
-		var originalMACs []string
-		for _, iface := range interfaces {
-			if iface.MAC != "" && iface.Name != "lo" { // Исключаем loopback
-				// Нормализуем MAC для единообразия
-				normalizedMAC := normalizeMAC(iface.MAC)
-				if normalizedMAC != "" {
-					originalMACs = append(originalMACs, normalizedMAC)
-				}
-			}
-		}
-		info.OriginalMACs = originalMACs
-
-		if len(originalMACs) > 0 {
-			printInfo(fmt.Sprintf("Collected %d original MAC address(es): %s",
-				len(originalMACs), strings.Join(originalMACs, ", ")))
+// runConfigValidation - режим -validate-config: проверить конфиг и показать его исходную и развернутую формы
+func runConfigValidation(configPath string) int {
+	raw, expanded, err := loadConfig(configPath)
+	if err != nil {
+		printError(fmt.Sprintf("Configuration %s is invalid: %v", configPath, err))
+		return 1
+	}
+	for _, form := range []struct {
+		title  string
+		config *Config
+	}{
+		{"Configuration as written", raw},
+		{"Expanded configuration (include, test_library and defaults applied)", expanded},
+	} {
+		data, err := yaml.Marshal(form.config)
+		if err != nil {
+			printError(fmt.Sprintf("Failed to marshal config: %v", err))
+			return 1
 		}
-	} else {
-		printWarning(fmt.Sprintf("Failed to collect original MAC addresses: %v", err))
+		fmt.Printf("\n%s%s%s\n", ColorWhite, form.title, ColorReset)
+		printSeparator()
+		fmt.Print(string(data))
 	}
-
-	// Run dmidecode
-	cmd := exec.Command("dmidecode")
-	output, err := cmd.Output()
-	if err != nil {
-		return info, fmt.Errorf("failed to run dmidecode: %v", err)
+	fmt.Println()
+	if command := expanded.Tests.TestGeneratorCommand; command != "" {
+		printInfo(fmt.Sprintf("Tests from test_generator_command %q are added at session start and are not shown", command))
 	}
+	printSuccess(fmt.Sprintf("Configuration %s is valid", configPath))
+	return 0
+}
 
-	// Parse dmidecode output
-	dmidecodeData := parseDMIDecode(string(output))
-	info.DMIDecode = dmidecodeData
+// testGeneratorTimeout - предел работы tests.test_generator_command
+const testGeneratorTimeout = 2 * time.Minute
 
-	// Extract key information and save original values
-	if systemInfo, ok := dmidecodeData["System Information"].(map[string]interface{}); ok {
-		if product, ok := systemInfo["Product Name"].(string); ok {
-			info.Product = product
-		}
+// generatedTests - вывод генераторов тестов за сессию (команда -> тесты), генератор запускается один раз
+var generatedTests = make(map[string][]TestSpec)
+
+// mergeGeneratedTests запускает tests.test_generator_command и добавляет его тесты в test_generator_group.
+// В развернутом конфиге остаются сами тесты, а не команда - effective_config.yaml воспроизводит сессию
+func mergeGeneratedTests(tests *TestsConfig) error {
+	if tests.TestGeneratorCommand == "" {
+		return nil
+	}
+	target := tests.TestGeneratorGroup
+	if target == "" {
+		target = "sequential1"
+	}
+	match := regexp.MustCompile(`^(parallel|sequential)([1-9][0-9]*)$`).FindStringSubmatch(strings.ToLower(target))
+	if match == nil {
+		return fmt.Errorf("tests.test_generator_group must be parallel<N> or sequential<N>, got %q", target)
+	}
+	groups := &tests.SequentialGroups
+	if match[1] == "parallel" {
+		groups = &tests.ParallelGroups
+	}
+	index, _ := strconv.Atoi(match[2])
+	// Следующий номер после последней группы создает новую группу
+	if index > len(*groups)+1 {
+		return fmt.Errorf("tests.test_generator_group %s: only %d %s group(s) configured", target, len(*groups), match[1])
 	}
 
-	if baseboardInfo, ok := dmidecodeData["Base Board Information"].(map[string]interface{}); ok {
-		if serial, ok := baseboardInfo["Serial Number"].(string); ok {
-			info.OriginalMBSerial = serial // Сохраняем оригинальный серийник
-			printInfo(fmt.Sprintf("Original motherboard serial: %s", serial))
+	generated, err := runTestGenerator(tests.TestGeneratorCommand)
+	if err != nil {
+		return err
+	}
+	for i, test := range generated {
+		if test.Name == "" {
+			return fmt.Errorf("test generator: test #%d has no name", i+1)
+		}
+		if test.Command == "" && test.Type != "iperf3" && len(test.Steps) == 0 {
+			return fmt.Errorf("test generator: test '%s' has no command", test.Name)
 		}
 	}
 
-	return info, nil
+	if index == len(*groups)+1 {
+		*groups = append(*groups, TestGroupSpec{})
+	}
+	(*groups)[index-1].Tests = append((*groups)[index-1].Tests, generated...)
+	tests.TestGeneratorCommand = ""
+	tests.TestGeneratorGroup = ""
+	return nil
 }
 
-func getIPAddress() (string, error) {
-	cmd := exec.Command("hostname", "-I")
-	output, err := cmd.Output()
+// runTestGenerator выполняет команду генератора и разбирает stdout как YAML []TestSpec
+func runTestGenerator(command string) ([]TestSpec, error) {
+	if tests, ok := generatedTests[command]; ok {
+		return tests, nil
+	}
+	fields := strings.Fields(command)
+	ctx, cancel := context.WithTimeout(context.Background(), testGeneratorTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := tracedOutput(cmd)
 	if err != nil {
-		return "", err
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("test generator %q failed: %v: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("test generator %q failed: %v", command, err)
 	}
 
-	ips := strings.Fields(string(output))
-	if len(ips) > 0 {
-		return ips[0], nil
+	var tests []TestSpec
+	if err := yaml.Unmarshal(out, &tests); err != nil {
+		return nil, fmt.Errorf("test generator %q: invalid YAML test list: %v", command, err)
+	}
+	if len(tests) == 0 {
+		printWarning(fmt.Sprintf("Test generator %q returned no tests", command))
 	}
+	generatedTests[command] = tests
+	return tests, nil
+}
 
-	return "", fmt.Errorf("no IP address found")
+// configuredTests перечисляет все тесты конфига: группы, post_reboot_groups и flash.post_flash_tests
+func configuredTests(config *Config) []TestSpec {
+	var tests []TestSpec
+	for _, groups := range [][][]TestSpec{groupTests(config.Tests.ParallelGroups), groupTests(config.Tests.SequentialGroups), config.Tests.PostRebootGroups} {
+		for _, group := range groups {
+			tests = append(tests, group...)
+		}
+	}
+	return append(tests, config.Flash.PostFlashTests...)
 }
 
-func parseDMIDecode(output string) map[string]interface{} {
-	result := make(map[string]interface{})
+// runnableTests - configuredTests без исключенных -skip-tests/-only-tests и тегами (их команды не проверяются)
+func runnableTests(config *Config) []TestSpec {
+	var tests []TestSpec
+	for _, g := range listTestGroups(config.Tests) {
+		for _, test := range g.Tests {
+			if !skipSet[test.Name] && !tagExcluded[sessionStateKey(g.Name, test.Name)] {
+				tests = append(tests, test)
+			}
+		}
+	}
+	for _, group := range append(slices.Clone(config.Tests.PostRebootGroups), config.Flash.PostFlashTests) {
+		for _, test := range group {
+			if !skipSet[test.Name] {
+				tests = append(tests, test)
+			}
+		}
+	}
+	return tests
+}
 
-	lines := strings.Split(output, "\n")
-	var currentSection string
-	var currentData map[string]interface{}
+// validateTestCommands проверяет, что команды тестов существуют, до начала сессии:
+// опечатка в command иначе всплывает через полчаса прогона.
+// Имя без "/" ищется в PATH, абсолютный путь - на диске, относительный - от текущего каталога
+func validateTestCommands(tests []TestSpec) []error {
+	var errs []error
+	for _, test := range tests {
+		if test.SkipCommandValidation {
+			continue
+		}
+		commands := []string{test.Command}
+		if test.Type == "iperf3" {
+			commands = []string{"iperf3"}
+		}
+		for _, step := range test.Steps {
+			commands = append(commands, step.Command)
+		}
+		for _, command := range commands {
+			if command != "" {
+				errs = append(errs, validateTestCommand(test.Name, command)...)
+			}
+		}
+	}
+	return errs
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+// validateTestCommand проверяет одну команду теста (или шага составного теста)
+func validateTestCommand(testName, command string) []error {
+	if filepath.IsAbs(command) {
+		if _, err := os.Stat(command); err != nil {
+			return []error{fmt.Errorf("test '%s': command %s does not exist", testName, command)}
+		}
+		return nil
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		if strings.ContainsRune(command, filepath.Separator) {
+			return []error{fmt.Errorf("test '%s': command %s is missing or not executable", testName, command)}
+		}
+		return []error{fmt.Errorf("test '%s': command '%s' not found in PATH", testName, command)}
+	}
+	return nil
+}
 
-		if line == "" {
+// compileTestArgs разбирает шаблоны в args теста и проверяет их на пустом SystemInfo (опечатка в имени поля - ошибка конфига)
+func compileTestArgs(test *TestSpec) error {
+	test.compiledArgs = make([]*texttemplate.Template, len(test.Args))
+	for i, arg := range test.Args {
+		if !strings.Contains(arg, "{{") {
 			continue
 		}
+		tmpl, err := texttemplate.New(test.Name).Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return fmt.Errorf("test '%s': invalid template in argument %q: %v", test.Name, arg, err)
+		}
+		if err := tmpl.Execute(io.Discard, SystemInfo{}); err != nil {
+			return fmt.Errorf("test '%s': invalid template in argument %q: %v", test.Name, arg, err)
+		}
+		test.compiledArgs[i] = tmpl
+	}
+	return compileTestSteps(test)
+}
 
-		// Check if this is a section header
-		if !strings.HasPrefix(line, "\t") && strings.Contains(line, "Information") {
-			if currentSection != "" && currentData != nil {
-				result[currentSection] = currentData
+// compileTestSteps проверяет шаги составного теста: без command теста, шаблоны args, таймауты и шаблоны вывода
+func compileTestSteps(test *TestSpec) error {
+	if len(test.Steps) == 0 {
+		return nil
+	}
+	if test.Command != "" || test.Type == "iperf3" {
+		return fmt.Errorf("test '%s': command and steps cannot be used together", test.Name)
+	}
+	for i := range test.Steps {
+		step := &test.Steps[i]
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step %d", i+1)
+		}
+		if step.Command == "" {
+			return fmt.Errorf("test '%s': %s has no command", test.Name, step.Name)
+		}
+		if step.Timeout != "" {
+			if _, err := time.ParseDuration(step.Timeout); err != nil {
+				return fmt.Errorf("test '%s': %s: invalid timeout: %v", test.Name, step.Name, err)
 			}
-			currentSection = line
-			currentData = make(map[string]interface{})
-			continue
 		}
-
-		// Parse key-value pairs
-		if strings.Contains(line, ":") && currentData != nil {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-				currentData[key] = value
+		var err error
+		if step.PassPattern != "" {
+			if step.passRegex, err = regexp.Compile(step.PassPattern); err != nil {
+				return fmt.Errorf("test '%s': %s: invalid pass_pattern: %v", test.Name, step.Name, err)
+			}
+		}
+		if step.FailPattern != "" {
+			if step.failRegex, err = regexp.Compile(step.FailPattern); err != nil {
+				return fmt.Errorf("test '%s': %s: invalid fail_pattern: %v", test.Name, step.Name, err)
 			}
 		}
+		// Шаблоны args шага разбираются так же, как у теста
+		stepSpec := TestSpec{Name: test.Name, Args: step.Args}
+		if err := compileTestArgs(&stepSpec); err != nil {
+			return err
+		}
+		step.compiledArgs = stepSpec.compiledArgs
 	}
+	return nil
+}
 
-	// Add the last section
-	if currentSection != "" && currentData != nil {
-		result[currentSection] = currentData
+// expandTestArgTemplates подставляет данные платы в args теста: {{.Product}}, {{.MBSerial}}, {{.MAC}}, {{.IP}} и другие поля SystemInfo
+func expandTestArgTemplates(test TestSpec, info SystemInfo) ([]string, error) {
+	args := make([]string, len(test.Args))
+	for i, arg := range test.Args {
+		var tmpl *texttemplate.Template
+		if i < len(test.compiledArgs) {
+			tmpl = test.compiledArgs[i]
+		}
+		if tmpl == nil {
+			args[i] = arg
+			continue
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, info); err != nil {
+			return nil, fmt.Errorf("failed to expand argument %q: %v", arg, err)
+		}
+		args[i] = buf.String()
 	}
-
-	return result
+	return args, nil
 }
 
-// Network interface management functions
-func getCurrentNetworkInterfaces() ([]NetworkInterface, error) {
-	var interfaces []NetworkInterface
+var (
+	testSystemInfoMutex sync.Mutex
+	testSystemInfo      SystemInfo // Данные платы для шаблонов args; после прошивки - прошитые значения
+)
 
-	// Get network interfaces using 'ip' command
-	cmd := exec.Command("ip", "addr", "show")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get network interfaces: %v", err)
+// setTestSystemInfo задает данные платы для шаблонов args тестов
+func setTestSystemInfo(info SystemInfo) {
+	testSystemInfoMutex.Lock()
+	testSystemInfo = info
+	testSystemInfoMutex.Unlock()
+}
+
+// applyFlashedValues переносит в данные для шаблонов значения, успешно прошитые операциями results
+func applyFlashedValues(flashData *FlashData, results []FlashResult) {
+	testSystemInfoMutex.Lock()
+	defer testSystemInfoMutex.Unlock()
+	for _, r := range results {
+		if r.Status != "SUCCESS" && r.Status != "COMPLETED" && r.Status != "PASSED" {
+			continue
+		}
+		for _, fieldID := range flashOperationFields[r.Operation] {
+			switch {
+			case fieldID == "system-serial-number" && flashData.SystemSerial != "":
+				testSystemInfo.MBSerial = flashData.SystemSerial
+			case fieldID == "io_board" && flashData.IOBoard != "":
+				testSystemInfo.IOSerial = flashData.IOBoard
+			case fieldID == "mac_address" && flashData.MAC != "":
+				testSystemInfo.MAC = flashData.MAC
+			}
+		}
 	}
+}
 
-	lines := strings.Split(string(output), "\n")
-	var currentInterface *NetworkInterface
+// maxTestDescription - предел длины description теста (строка под заголовком и в отчете)
+const maxTestDescription = 256
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+// validateTimeoutRetry проверяет timeout_retry_multiplier (0 - не задан, иначе не меньше 1) и max_timeout
+func validateTimeoutRetry(multiplier float64, maxTimeout string) error {
+	if multiplier != 0 && multiplier < 1 {
+		return fmt.Errorf("timeout_retry_multiplier must be >= 1 (or 0 to disable), got %g", multiplier)
+	}
+	if maxTimeout != "" {
+		if d, err := time.ParseDuration(maxTimeout); err != nil {
+			return fmt.Errorf("max_timeout: %v", err)
+		} else if d <= 0 {
+			return fmt.Errorf("max_timeout must be > 0, got %s", maxTimeout)
+		}
+	}
+	return nil
+}
 
-		// Parse interface name and state
-		if strings.Contains(line, ": ") && !strings.HasPrefix(line, " ") {
-			if currentInterface != nil {
-				interfaces = append(interfaces, *currentInterface)
+// validateConfig проверяет значения, которые нельзя молча заменить значением по умолчанию
+func validateConfig(config *Config) error {
+	if config.Tests.MaxParallel < 0 {
+		return fmt.Errorf("tests.max_parallel must be >= 1 (or 0 for no limit), got %d", config.Tests.MaxParallel)
+	}
+	for i, groups := range [][]TestGroupSpec{config.Tests.ParallelGroups, config.Tests.SequentialGroups} {
+		kind := []string{"parallel_groups", "sequential_groups"}[i]
+		for j, group := range groups {
+			if group.MaxParallel < 0 {
+				return fmt.Errorf("tests.%s[%d].max_parallel must be >= 1 (or 0 for tests.max_parallel), got %d", kind, j, group.MaxParallel)
 			}
-
-			// Extract interface name
-			parts := strings.Split(line, ":")
-			if len(parts) >= 2 {
-				name := strings.TrimSpace(parts[1])
-				currentInterface = &NetworkInterface{Name: name}
-
-				// Extract state
-				if strings.Contains(line, "state UP") {
-					currentInterface.State = "UP"
-				} else if strings.Contains(line, "state DOWN") {
-					currentInterface.State = "DOWN"
+			if group.Timeout != "" {
+				if _, err := time.ParseDuration(group.Timeout); err != nil {
+					return fmt.Errorf("tests.%s[%d].timeout: %v", kind, j, err)
 				}
 			}
 		}
-
-		// Parse MAC address
-		if currentInterface != nil && strings.Contains(line, "link/ether") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				currentInterface.MAC = strings.ToUpper(parts[1])
+	}
+	for alias, target := range config.Tests.ResourceAliases {
+		if strings.TrimSpace(alias) == "" || strings.TrimSpace(target) == "" {
+			return fmt.Errorf("tests.resource_aliases: empty resource name in %q -> %q", alias, target)
+		}
+	}
+	if config.System.MinBIOSVersion != "" {
+		if _, err := compareBIOSVersions(config.System.MinBIOSVersion, config.System.MinBIOSVersion); err != nil {
+			return fmt.Errorf("system.min_bios_version: %v", err)
+		}
+	}
+	for _, guid := range config.System.EFICleanup.OrphanGUIDs {
+		parsed, err := efiguid.FromString(guid)
+		if err != nil {
+			return fmt.Errorf("system.efi_cleanup.orphan_guids: %q: %v", guid, err)
+		}
+		if parsed == efiGlobalVariableGUID {
+			return fmt.Errorf("system.efi_cleanup.orphan_guids: %s is the EFI global variable GUID", guid)
+		}
+	}
+	if config.System.EFIShellPath != "" && !strings.HasPrefix(config.System.EFIShellPath, "\\EFI\\") {
+		return fmt.Errorf("system.efi_shell_path must start with \\EFI\\, got %q", config.System.EFIShellPath)
+	}
+	if config.Log.UploadWaitTimeout != "" {
+		if d, err := time.ParseDuration(config.Log.UploadWaitTimeout); err != nil {
+			return fmt.Errorf("log.upload_wait_timeout: %v", err)
+		} else if d < 0 {
+			return fmt.Errorf("log.upload_wait_timeout must be >= 0, got %s", config.Log.UploadWaitTimeout)
+		}
+	}
+	if config.Log.ManifestUploadTime != "" {
+		if _, err := time.Parse("15:04", config.Log.ManifestUploadTime); err != nil {
+			return fmt.Errorf("log.manifest_upload_time must be HH:MM, got %q", config.Log.ManifestUploadTime)
+		}
+	}
+	if err := validateRedactConfig(config.Log.Redact); err != nil {
+		return fmt.Errorf("log.redact: %v", err)
+	}
+	if config.Flash.InputTimeout != "" {
+		if _, err := time.ParseDuration(config.Flash.InputTimeout); err != nil {
+			return fmt.Errorf("flash.input_timeout: %v", err)
+		}
+	}
+	if err := validateNICOrder(config.Flash.NICOrder); err != nil {
+		return fmt.Errorf("flash.nic_order: %v", err)
+	}
+	if err := validateFlashVariants(config.Flash.Fields); err != nil {
+		return err
+	}
+	if err := validateFinishAction(config.Pipeline.FinishAction); err != nil {
+		return fmt.Errorf("pipeline.finish_action: %v", err)
+	}
+	if config.Pipeline.FinishCountdown != nil && *config.Pipeline.FinishCountdown < 0 {
+		return fmt.Errorf("pipeline.finish_countdown must be >= 0, got %d", *config.Pipeline.FinishCountdown)
+	}
+	switch config.System.BIOSVersionCheckMode {
+	case "", "warn", "abort":
+	default:
+		return fmt.Errorf("system.bios_version_check_mode must be warn or abort, got %q", config.System.BIOSVersionCheckMode)
+	}
+	if config.ConfigSource != nil && config.ConfigSource.URL == "" && config.Log.Server == "" {
+		return fmt.Errorf("config_source.url is required when log.server is not set")
+	}
+	if config.Flash.TemperatureCheckEnabled && config.Flash.MaxTemperatureCelsius <= 0 {
+		return fmt.Errorf("flash.max_temperature_celsius must be > 0 when flash.temperature_check_enabled is set")
+	}
+	switch config.UI.Mode {
+	case "", "normal", "compact":
+	default:
+		return fmt.Errorf("ui.mode must be normal or compact, got %q", config.UI.Mode)
+	}
+	if s := config.Tests.SMART; s.MaxReallocatedIncrease < 0 || s.MaxMediaErrorsIncrease < 0 || s.MaxWearIncrease < 0 || s.MaxTemperatureCelsius < 0 {
+		return fmt.Errorf("tests.smart: thresholds must be >= 0")
+	}
+	if err := validateTimeoutRetry(config.Tests.TimeoutRetryMultiplier, config.Tests.MaxTimeout); err != nil {
+		return fmt.Errorf("tests.%v", err)
+	}
+	for _, test := range configuredTests(config) {
+		if len([]rune(test.Description)) > maxTestDescription {
+			return fmt.Errorf("test '%s': description is longer than %d characters", test.Name, maxTestDescription)
+		}
+		if err := validateTimeoutRetry(test.TimeoutRetryMultiplier, test.MaxTimeout); err != nil {
+			return fmt.Errorf("test '%s': %v", test.Name, err)
+		}
+		for _, resource := range test.Resources {
+			if strings.TrimSpace(resource) == "" {
+				return fmt.Errorf("test '%s': empty name in resources", test.Name)
 			}
 		}
-
-		// Parse IP address
-		if currentInterface != nil && strings.Contains(line, "inet ") && !strings.Contains(line, "127.0.0.1") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				ip := strings.Split(parts[1], "/")[0]
-				currentInterface.IP = ip
+	}
+	for _, groups := range [][][]TestSpec{groupTests(config.Tests.ParallelGroups), groupTests(config.Tests.SequentialGroups), config.Tests.PostRebootGroups, {config.Flash.PostFlashTests}} {
+		for _, group := range groups {
+			for i := range group {
+				if err := compileTestArgs(&group[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if hasFlashOperation(config.Flash, "smbios") {
+		if config.Flash.SMBIOS.ToolPath == "" || len(config.Flash.SMBIOS.Strings) == 0 {
+			return fmt.Errorf("flash operation 'smbios' requires flash.smbios.tool_path and flash.smbios.strings")
+		}
+		for option, source := range config.Flash.SMBIOS.Strings {
+			if _, err := smbiosSourceValue(source, config.System, &FlashData{}); err != nil {
+				return fmt.Errorf("flash.smbios.strings[%s]: %v", option, err)
 			}
 		}
 	}
+	return nil
+}
 
-	// Add the last interface
-	if currentInterface != nil {
-		interfaces = append(interfaces, *currentInterface)
+// debugMode включает отладочный вывод (флаг -debug)
+var debugMode bool
+
+// applyConfigDefaults подставляет значения по умолчанию вместо пустых полей,
+// чтобы они были видны в effective config сессии, а не подразумевались нулевыми значениями
+func applyConfigDefaults(config *Config) {
+	applied := func(field string, value interface{}) {
+		printDebug(fmt.Sprintf("[config] applied default: %s = %v", field, value))
 	}
 
-	// Get driver information for each interface
-	for i := range interfaces {
-		if driver, err := getInterfaceDriver(interfaces[i].Name); err == nil {
-			interfaces[i].Driver = driver
+	if config.Tests.Timeout == "" {
+		config.Tests.Timeout = "30s"
+		applied("tests.timeout", config.Tests.Timeout)
+	}
+	if config.Tests.MaxRetries <= 0 {
+		config.Tests.MaxRetries = 5
+		applied("tests.max_retries", config.Tests.MaxRetries)
+	}
+	if config.Log.LogDir == "" {
+		config.Log.LogDir = "logs"
+		applied("log.log_dir", config.Log.LogDir)
+	}
+	if config.Flash.Enabled && config.Flash.Method == "" {
+		config.Flash.Method = "eeupdate"
+		applied("flash.method", config.Flash.Method)
+	}
+	if config.Flash.FRUBlankSizeBytes <= 0 {
+		config.Flash.FRUBlankSizeBytes = 2048
+		applied("flash.fru_blank_size_bytes", config.Flash.FRUBlankSizeBytes)
+	}
+	if config.System.EFIShellPath == "" {
+		config.System.EFIShellPath = defaultEFIShellPath
+		applied("system.efi_shell_path", config.System.EFIShellPath)
+	}
+	if config.System.EFIBootEntryLabel == "" {
+		config.System.EFIBootEntryLabel = defaultEFIBootEntryLabel
+		applied("system.efi_boot_entry_label", config.System.EFIBootEntryLabel)
+	}
+}
+
+// writeEffectiveConfig сохраняет развернутый конфиг сессии для воспроизводимости
+func writeEffectiveConfig(config *Config, path string) error {
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create session directory: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// yamlMappingValue возвращает значение ключа в YAML mapping (с учетом документа и алиасов)
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	node = resolveYAMLNode(node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveYAMLNode(node.Content[i+1])
 		}
 	}
+	return nil
+}
 
-	return interfaces, nil
+func resolveYAMLNode(node *yaml.Node) *yaml.Node {
+	for node != nil {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			if len(node.Content) == 0 {
+				return nil
+			}
+			node = node.Content[0]
+		case yaml.AliasNode:
+			node = node.Alias
+		default:
+			return node
+		}
+	}
+	return nil
 }
 
-func getInterfaceDriver(interfaceName string) (string, error) {
-	// Try ethtool first
-	cmd := exec.Command("ethtool", "-i", interfaceName)
-	output, err := cmd.Output()
-	if err == nil {
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.HasPrefix(line, "driver:") {
-				parts := strings.SplitN(line, ":", 2)
-				if len(parts) == 2 {
-					return strings.TrimSpace(parts[1]), nil
+// loadTestLibrary собирает test_library из include файлов (рекурсивно) и самого файла.
+// stack - цепочка подключений для обнаружения циклов.
+func loadTestLibrary(path string, root *yaml.Node, stack []string) (map[string]TestSpec, error) {
+	library := make(map[string]TestSpec)
+
+	if includes := yamlMappingValue(root, "include"); includes != nil {
+		if includes.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("%s:%d: include must be a list of paths", path, includes.Line)
+		}
+		for _, item := range includes.Content {
+			includePath := item.Value
+			if !filepath.IsAbs(includePath) {
+				includePath = filepath.Join(filepath.Dir(path), includePath)
+			}
+			absInclude, _ := filepath.Abs(includePath)
+
+			for _, seen := range stack {
+				if seen == absInclude {
+					return nil, fmt.Errorf("%s:%d: circular include: %s -> %s", path, item.Line, strings.Join(stack, " -> "), absInclude)
 				}
 			}
+
+			data, err := os.ReadFile(includePath)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: failed to read include %s: %v", path, item.Line, item.Value, err)
+			}
+			var includeRoot yaml.Node
+			if err := yaml.Unmarshal(data, &includeRoot); err != nil {
+				return nil, fmt.Errorf("%s: %v", includePath, err)
+			}
+
+			included, err := loadTestLibrary(includePath, &includeRoot, append(stack, absInclude))
+			if err != nil {
+				return nil, err
+			}
+			for name, spec := range included {
+				library[name] = spec
+			}
 		}
 	}
 
-	// Fallback: check /sys/class/net
-	driverPath := fmt.Sprintf("/sys/class/net/%s/device/driver", interfaceName)
-	if link, err := os.Readlink(driverPath); err == nil {
-		return filepath.Base(link), nil
+	// Собственные определения файла переопределяют подключенные
+	if own := yamlMappingValue(root, "test_library"); own != nil {
+		var specs map[string]TestSpec
+		if err := own.Decode(&specs); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid test_library: %v", path, own.Line, err)
+		}
+		for name, spec := range specs {
+			if spec.Name == "" {
+				spec.Name = name
+			}
+			library[name] = spec
+		}
 	}
 
-	return "", fmt.Errorf("driver not found for interface %s", interfaceName)
+	return library, nil
 }
 
-func getIntelNetworkDrivers() ([]string, error) {
-	printInfo("Detecting Intel network drivers...")
-
-	// Получаем список всех Intel сетевых карт через lspci
-	cmd := exec.Command("lspci", "-nn", "-d", "8086:")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to run lspci: %v", err)
+// expandTestReferences заменяет записи "use: <name>" в группах тестов и post_flash_tests
+// библиотечными тестами; заданные рядом поля переопределяют библиотечные
+func expandTestReferences(config *Config, root *yaml.Node, library map[string]TestSpec, path string) error {
+	expand := func(node *yaml.Node, target *TestSpec) error {
+		use := yamlMappingValue(node, "use")
+		if use == nil {
+			return nil
+		}
+		spec, ok := library[use.Value]
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown test library entry '%s'", path, use.Line, use.Value)
+		}
+		// Декодирование поверх библиотечного теста меняет только поля, указанные в конфиге
+		if err := node.Decode(&spec); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, node.Line, err)
+		}
+		spec.Use = ""
+		*target = spec
+		return nil
 	}
 
-	var drivers []string
-	driverSet := make(map[string]bool) // Для удаления дубликатов
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		// Ищем сетевые контроллеры (Ethernet controller, Network controller)
-		if strings.Contains(strings.ToLower(line), "ethernet") ||
-			strings.Contains(strings.ToLower(line), "network") {
-
-			// Извлекаем PCI адрес (первая часть строки до пробела)
-			parts := strings.Fields(line)
-			if len(parts) == 0 {
-				continue
+	tests := yamlMappingValue(root, "tests")
+	for _, kind := range []string{"parallel_groups", "sequential_groups", "post_reboot_groups"} {
+		groupsNode := yamlMappingValue(tests, kind)
+		if groupsNode == nil {
+			continue
+		}
+		groups := groupTests(config.Tests.ParallelGroups)
+		switch kind {
+		case "sequential_groups":
+			groups = groupTests(config.Tests.SequentialGroups)
+		case "post_reboot_groups":
+			groups = config.Tests.PostRebootGroups
+		}
+		for i, groupNode := range groupsNode.Content {
+			groupNode = resolveYAMLNode(groupNode)
+			if groupNode.Kind == yaml.MappingNode {
+				groupNode = resolveYAMLNode(yamlMappingValue(groupNode, "tests"))
+				if groupNode == nil {
+					continue
+				}
 			}
-			pciAddr := parts[0]
-
-			// Получаем драйвер для этого устройства
-			driverPath := fmt.Sprintf("/sys/bus/pci/devices/0000:%s/driver", pciAddr)
-			if link, err := os.Readlink(driverPath); err == nil {
-				driverName := filepath.Base(link)
-				if !driverSet[driverName] {
-					drivers = append(drivers, driverName)
-					driverSet[driverName] = true
-					printInfo(fmt.Sprintf("Found Intel driver: %s (PCI: %s)", driverName, pciAddr))
+			for j, testNode := range groupNode.Content {
+				if err := expand(testNode, &groups[i][j]); err != nil {
+					return err
 				}
 			}
 		}
 	}
 
-	if len(drivers) == 0 {
-		printWarning("No Intel network drivers found, trying common drivers...")
-		// Fallback к общим Intel драйверам
-		commonDrivers := []string{"igb", "e1000e", "ixgbe", "i40e", "ice"}
-		for _, driver := range commonDrivers {
-			// Проверяем, загружен ли драйвер
-			cmd := exec.Command("lsmod")
-			output, err := cmd.Output()
-			if err == nil && strings.Contains(string(output), driver) {
-				drivers = append(drivers, driver)
-				printInfo(fmt.Sprintf("Found loaded Intel driver: %s", driver))
+	if postNode := yamlMappingValue(yamlMappingValue(root, "flash"), "post_flash_tests"); postNode != nil {
+		for i, testNode := range postNode.Content {
+			if err := expand(testNode, &config.Flash.PostFlashTests[i]); err != nil {
+				return err
 			}
 		}
 	}
 
-	printSuccess(fmt.Sprintf("Detected %d Intel network driver(s)", len(drivers)))
-	return drivers, nil
+	return nil
 }
 
-func normalizeMAC(mac string) string {
-	// Remove any separators and convert to uppercase
-	mac = strings.ReplaceAll(mac, ":", "")
-	mac = strings.ReplaceAll(mac, "-", "")
-	mac = strings.ToUpper(mac)
+// CommandRunner выполняет системные запросы (команды, чтение /proc и /sys) и изменяющие команды (Exec).
+// Вынесен в интерфейс, чтобы опросные функции и загрузку модулей можно было проверять с подставным runner'ом.
+type CommandRunner interface {
+	Run(name string, args ...string) ([]byte, error) // stdout идемпотентной команды, как exec.Cmd.Output
+	// Exec выполняет команду, меняющую состояние системы (insmod, rmmod, mknod): stdout и stderr вместе,
+	// как exec.Cmd.CombinedOutput; никогда не кэшируется
+	Exec(ctx context.Context, name string, args ...string) ([]byte, error)
+	ReadFile(path string) ([]byte, error)
+	ReadDir(path string) ([]string, error)
+	Readlink(path string) (string, error)
+}
 
-	// Add colons in standard format
-	if len(mac) == 12 {
-		return fmt.Sprintf("%s:%s:%s:%s:%s:%s",
-			mac[0:2], mac[2:4], mac[4:6], mac[6:8], mac[8:10], mac[10:12])
+// execRunner - реальная реализация CommandRunner
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	return tracedOutput(exec.Command(name, args...))
+}
+
+func (execRunner) Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return tracedCombinedOutput(exec.CommandContext(ctx, name, args...))
+}
+
+func (execRunner) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func (execRunner) ReadDir(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
 	}
+	return names, nil
+}
 
-	return mac
+func (execRunner) Readlink(path string) (string, error) {
+	return os.Readlink(path)
 }
 
-func isTargetMACPresent(targetMAC string, interfaces []NetworkInterface) (bool, string) {
-	normalizedTarget := normalizeMAC(targetMAC)
+type cachedOutput struct {
+	output  []byte
+	err     error
+	expires time.Time
+}
 
-	for _, iface := range interfaces {
-		if normalizeMAC(iface.MAC) == normalizedTarget {
-			return true, iface.Name
+// cachingRunner кэширует результаты команд на короткий TTL в пределах одной операции.
+// Чтение файлов не кэшируется. Опросные циклы читают через Fresh(): команда выполняется всегда.
+type cachingRunner struct {
+	inner   CommandRunner
+	ttl     time.Duration
+	mutex   sync.Mutex
+	entries map[string]cachedOutput
+}
+
+func newCachingRunner(inner CommandRunner, ttl time.Duration) *cachingRunner {
+	return &cachingRunner{inner: inner, ttl: ttl, entries: make(map[string]cachedOutput)}
+}
+
+func (c *cachingRunner) Run(name string, args ...string) ([]byte, error) {
+	return c.run(false, name, args...)
+}
+
+// run выполняет команду или отдает результат из кэша; fresh - всегда выполнять, результат все равно кэшируется
+func (c *cachingRunner) run(fresh bool, name string, args ...string) ([]byte, error) {
+	key := name + "\x00" + strings.Join(args, "\x00")
+
+	if !fresh {
+		c.mutex.Lock()
+		if entry, ok := c.entries[key]; ok && time.Now().Before(entry.expires) {
+			c.mutex.Unlock()
+			return entry.output, entry.err
 		}
+		c.mutex.Unlock()
 	}
 
-	return false, ""
+	output, err := c.inner.Run(name, args...)
+
+	c.mutex.Lock()
+	c.entries[key] = cachedOutput{output: output, err: err, expires: time.Now().Add(c.ttl)}
+	c.mutex.Unlock()
+	return output, err
 }
 
-func askFlashRetryAction(message string) string {
-	fmt.Printf("\n%s=== MAC FLASHING ERROR ===%s\n", ColorRed, ColorReset)
-	fmt.Printf("%s\n", message)
-	fmt.Println("Choose action:")
-	fmt.Printf("  %s[Y]%s Yes - Retry flashing (default)\n", ColorGreen, ColorReset)
-	fmt.Printf("  %s[A]%s Abort - Stop flashing and continue program\n", ColorYellow, ColorReset)
-	fmt.Printf("  %s[S]%s Skip - Skip MAC flashing by operator decision\n", ColorBlue, ColorReset)
-	fmt.Printf("Choice [Y/a/s]: ")
+// Exec выполняет команду мимо кэша и сбрасывает его: после изменения снимки системы устарели
+func (c *cachingRunner) Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	output, err := c.inner.Exec(ctx, name, args...)
+	c.Invalidate()
+	return output, err
+}
 
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
-	if err != nil {
-		return "RETRY" // default on error
-	}
+func (c *cachingRunner) ReadFile(path string) ([]byte, error) {
+	return c.inner.ReadFile(path)
+}
 
-	choice := strings.ToUpper(strings.TrimSpace(input))
-	if choice == "" {
-		choice = "Y" // default
-	}
+func (c *cachingRunner) ReadDir(path string) ([]string, error) {
+	return c.inner.ReadDir(path)
+}
 
-	switch choice {
-	case "Y", "YES":
-		return "RETRY"
-	case "A", "ABORT":
-		return "ABORT"
-	case "S", "SKIP":
-		return "SKIP"
-	default:
-		fmt.Printf("Invalid choice '%s', defaulting to retry.\n", choice)
-		return "RETRY"
-	}
+func (c *cachingRunner) Readlink(path string) (string, error) {
+	return c.inner.Readlink(path)
 }
 
-func flashMAC(flashConfig FlashConfig, systemConfig SystemConfig, mac string) error {
-	method := flashConfig.Method
-	if method == "" {
-		method = "eeupdate" // default
+// Invalidate сбрасывает кэш (после загрузки/выгрузки драйверов и смены адресов)
+func (c *cachingRunner) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]cachedOutput)
+}
+
+// Fresh возвращает runner поверх того же кэша, который всегда выполняет команды и обновляет кэш их результатом.
+// Опрос не получает снимок, сделанный до ожидаемого изменения, а последующие обычные чтения видят результат опроса
+func (c *cachingRunner) Fresh() CommandRunner {
+	return freshRunner{c}
+}
+
+type freshRunner struct {
+	*cachingRunner
+}
+
+func (f freshRunner) Run(name string, args ...string) ([]byte, error) {
+	return f.run(true, name, args...)
+}
+
+// sysRunner используется опросными функциями; в тестах подменяется
+var sysRunner CommandRunner = newCachingRunner(execRunner{}, 2*time.Second)
+
+// invalidateSystemCache сбрасывает кэш sysRunner, если он кэширующий
+func invalidateSystemCache() {
+	if c, ok := sysRunner.(*cachingRunner); ok {
+		c.Invalidate()
 	}
+}
 
-	printSubHeader("MAC ADDRESS FLASHING", fmt.Sprintf("Method: %s | Target MAC: %s", method, mac))
+// pollingRunner - sysRunner для опросных циклов и чтений сразу после изменения: кэш не используется
+func pollingRunner() CommandRunner {
+	if c, ok := sysRunner.(*cachingRunner); ok {
+		return c.Fresh()
+	}
+	return sysRunner
+}
 
-	// Step 1: Get current network interfaces and save original MACs
-	interfaces, err := getCurrentNetworkInterfaces()
+// loadedModules читает список загруженных модулей ядра из /proc/modules (без запуска lsmod)
+func loadedModules() ([]string, error) {
+	data, err := sysRunner.ReadFile("/proc/modules")
 	if err != nil {
-		return fmt.Errorf("failed to get network interfaces: %v", err)
+		return nil, fmt.Errorf("failed to read /proc/modules: %v", err)
 	}
 
-	// Log original MAC addresses before flashing
-	printInfo("Original MAC addresses before flashing:")
-	for _, iface := range interfaces {
-		if iface.MAC != "" && iface.Name != "lo" {
-			printInfo(fmt.Sprintf("  %s: %s [%s]", iface.Name, iface.MAC, iface.Driver))
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
 		}
 	}
+	return lines, nil
+}
 
-	// Step 2: Check if target MAC already exists
-	exists, interfaceName := isTargetMACPresent(mac, interfaces)
-	if exists {
-		printSuccess(fmt.Sprintf("Target MAC %s already present on interface %s - skipping flash", mac, interfaceName))
-		return nil
+// isModuleLoaded проверяет, загружен ли модуль (имена с '-' и '_' эквивалентны)
+func isModuleLoaded(name string) (bool, error) {
+	lines, err := loadedModules()
+	if err != nil {
+		return false, err
 	}
-
-	// Step 3: Show current network state
-	fmt.Printf("\nCurrent network interfaces:\n")
-	for _, iface := range interfaces {
-		status := "DOWN"
-		if iface.State == "UP" {
-			status = fmt.Sprintf("UP (IP: %s)", iface.IP)
+	name = strings.ReplaceAll(name, "-", "_")
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == name {
+			return true, nil
 		}
-		fmt.Printf("  %s: %s [%s] - %s\n", iface.Name, iface.MAC, iface.Driver, status)
 	}
+	return false, nil
+}
 
-	// Step 4: Execute flashing based on method
-	var summary FlashMACSummary
-	summary.Method = method
-	summary.TargetMAC = mac
+// waitForModuleState ждет загрузки (loaded=true) или выгрузки модуля, опрашивая /proc/modules
+func waitForModuleState(name string, loaded bool, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	switch method {
-	case "rtnicpg":
-		err = flashMACWithRtnicpg(mac, interfaces, systemConfig, &summary)
-	case "eeupdate":
-		err = flashMACWithEeupdate(mac, interfaces, flashConfig, &summary)
-	default:
-		return fmt.Errorf("unknown flash method: %s", method)
-	}
+	ticker := time.NewTicker(100 * time.Millisecond) // Проверяем каждые 100мс
+	defer ticker.Stop()
 
-	if err != nil {
-		return fmt.Errorf("MAC flashing failed: %v", err)
+	for {
+		if present, err := isModuleLoaded(name); err == nil && present == loaded {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
 	}
+}
 
-	if summary.Success {
-		printSuccess(fmt.Sprintf("MAC address flashed successfully using %s method", method))
-	}
+func runCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := tracedRun(cmd)
+	return strings.TrimSpace(out.String()), err
+}
 
-	return nil
+func runCommandNoOutput(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	// Do not show full output, keep only debug messages
+	var dummy bytes.Buffer
+	cmd.Stdout = &dummy
+	cmd.Stderr = &dummy
+	return tracedRun(cmd)
 }
 
-func discoverIntelNICs(venDeviceFilter []string) ([]IntelNIC, error) {
-	printInfo("Discovering Intel network cards...")
+const (
+	defaultTraceMaxSizeMB   = 10
+	defaultTraceOutputBytes = 1024
+)
 
-	cmd := exec.Command("eeupdate64e", "/MAC_DUMP_ALL")
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+// traceEnvVars - переменные окружения, от которых зависит поведение утилит вендоров
+var traceEnvVars = []string{"PATH", "LD_LIBRARY_PATH", "LANG", "LC_ALL", "HOME", "TERM"}
+
+// CommandTraceEntry - запись commands.trace (JSON по строке)
+type CommandTraceEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Operation   string    `json:"operation"` // Тест ("test: name"), этап прошивки ("flash: mac"), identification...
+	Argv        []string  `json:"argv"`
+	Dir         string    `json:"cwd"`
+	Env         []string  `json:"env,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	ExitCode    int       `json:"exit_code"` // -1 - не запустилась или убита сигналом
+	Error       string    `json:"error,omitempty"`
+	OutputBytes int64     `json:"output_bytes"`
+	OutputHead  string    `json:"output_head,omitempty"`
+	OutputTail  string    `json:"output_tail,omitempty"` // Последние байты, если вывод не поместился в output_head
+	Destructive bool      `json:"destructive,omitempty"`
+}
 
-	// Check if command failed completely (exit codes other than 2 are critical)
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode := exitError.ExitCode()
-			if exitCode == 2 {
-				// Exit code 2 usually means no driver found, but utility can still work
-				printInfo("eeupdate64e reports no driver (exit code 2), but continuing...")
-			} else {
-				// Other exit codes are more serious errors
-				return nil, fmt.Errorf("eeupdate64e discovery failed with exit code %d: %v\nOutput: %s", exitCode, err, outputStr)
-			}
-		} else {
-			// Non-ExitError (like command not found)
-			return nil, fmt.Errorf("eeupdate64e discovery failed: %v\nOutput: %s", err, outputStr)
+// commandTracer пишет commands.trace и скрипт воспроизведения этапа прошивки
+type commandTracer struct {
+	mu          sync.Mutex
+	path        string
+	scriptPath  string
+	file        *os.File
+	script      *os.File
+	size        int64
+	maxSize     int64
+	outputBytes int
+	full        bool
+	tools       map[string]bool // Дополнительные утилиты, которые всегда пишут (smbios.tool_path)
+}
+
+// commandTrace - трассировка текущей сессии, nil - выключена (log.trace_commands)
+var commandTrace *commandTracer
+
+// startCommandTrace создает commands.trace и commands.replay.sh в каталоге сессии
+func startCommandTrace(dir string, config LogConfig, writeTools ...string) (*commandTracer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %v", err)
+	}
+	t := &commandTracer{
+		path:        filepath.Join(dir, "commands.trace"),
+		scriptPath:  filepath.Join(dir, "commands.replay.sh"),
+		maxSize:     int64(defaultTraceMaxSizeMB) << 20,
+		outputBytes: defaultTraceOutputBytes,
+		tools:       map[string]bool{},
+	}
+	if config.TraceMaxSizeMB > 0 {
+		t.maxSize = int64(config.TraceMaxSizeMB) << 20
+	}
+	if config.TraceOutputBytes > 0 {
+		t.outputBytes = config.TraceOutputBytes
+	}
+	for _, tool := range writeTools {
+		if tool != "" {
+			t.tools[filepath.Base(tool)] = true
 		}
 	}
 
-	// Parse output to find NIC indices regardless of exit code
-	var allNICs []IntelNIC
-	lines := strings.Split(outputStr, "\n")
-
-	for _, line := range lines {
-		// Parse lines with device IDs (8086-XXXX format indicates Intel)
-		if strings.Contains(line, "8086-") {
-			fields := strings.Fields(line)
-			if len(fields) >= 5 {
-				// First field should be NIC index
-				nicIndex, err := strconv.Atoi(fields[0])
-				if err != nil {
-					continue
-				}
+	var err error
+	if t.file, err = os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, fmt.Errorf("failed to create command trace: %v", err)
+	}
+	if t.script, err = os.OpenFile(t.scriptPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755); err != nil {
+		t.file.Close()
+		return nil, fmt.Errorf("failed to create replay script: %v", err)
+	}
+	fmt.Fprintf(t.script, "#!/bin/sh\n"+
+		"# Flash-phase commands recorded by firestarter on %s\n"+
+		"# Steps that write to the hardware are commented out - review and uncomment them deliberately.\n"+
+		"set -x\n", time.Now().Format("2006-01-02 15:04:05"))
+	return t, nil
+}
 
-				// Extract vendor-device ID (format: 8086-1521)
-				venDevice := fields[4]
-				description := strings.Join(fields[5:], " ")
+// Close закрывает файлы трассировки
+func (t *commandTracer) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.file.Close()
+	t.script.Close()
+}
 
-				nic := IntelNIC{
-					Index:        nicIndex,
-					VendorDevice: venDevice,
-					Description:  description,
-				}
+// traceOutput сохраняет первые и последние limit байт вывода команды
+type traceOutput struct {
+	mu    sync.Mutex
+	limit int
+	head  []byte
+	tail  []byte
+	total int64
+}
 
-				allNICs = append(allNICs, nic)
-				printInfo(fmt.Sprintf("Found Intel NIC %d: %s (%s)", nicIndex, venDevice, description))
-			}
+func (o *traceOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := len(p)
+	o.total += int64(n)
+	if room := o.limit - len(o.head); room > 0 {
+		k := min(room, len(p))
+		o.head = append(o.head, p[:k]...)
+		p = p[k:]
+	}
+	if len(p) > 0 {
+		o.tail = append(o.tail, p...)
+		if len(o.tail) > o.limit {
+			o.tail = append(o.tail[:0], o.tail[len(o.tail)-o.limit:]...)
 		}
 	}
+	return n, nil
+}
 
-	if len(allNICs) == 0 {
-		// If no NICs found in parsing, but we got output, try common indices
-		if len(outputStr) > 100 { // Substantial output suggests NICs might be there
-			printInfo("No NICs found in parsing, but substantial output detected. Trying common indices...")
-			for i := 1; i <= 6; i++ {
-				allNICs = append(allNICs, IntelNIC{Index: i, VendorDevice: "unknown", Description: "Unknown Intel NIC"})
-			}
-		} else {
-			return nil, fmt.Errorf("no Intel network cards found in output")
-		}
+// capture подключает запись вывода к cmd до запуска. Вывод в *os.File (терминал, консоль) не перехватывается,
+// чтобы утилита по-прежнему видела настоящий файл
+func (t *commandTracer) capture(cmd *exec.Cmd) *traceOutput {
+	if t == nil {
+		return nil
 	}
-
-	// Apply vendor-device filter if specified
-	var filteredNICs []IntelNIC
-	if len(venDeviceFilter) > 0 {
-		printInfo(fmt.Sprintf("Applying vendor-device filter: %s", strings.Join(venDeviceFilter, ", ")))
-		for _, nic := range allNICs {
-			for _, filter := range venDeviceFilter {
-				if nic.VendorDevice == filter {
-					filteredNICs = append(filteredNICs, nic)
-					printInfo(fmt.Sprintf("NIC %d matches filter %s", nic.Index, filter))
-					break
-				}
-			}
-		}
-		if len(filteredNICs) == 0 {
-			return nil, fmt.Errorf("no NICs match the specified vendor-device filter: %s", strings.Join(venDeviceFilter, ", "))
+	out := &traceOutput{limit: t.outputBytes}
+	wrap := func(w io.Writer) io.Writer {
+		switch w.(type) {
+		case nil:
+			return out
+		case *os.File:
+			return w
 		}
+		return io.MultiWriter(w, out)
+	}
+	if cmd.Stdout != nil && cmd.Stdout == cmd.Stderr {
+		// Общий writer остается общим: exec пишет в него из одного канала
+		w := wrap(cmd.Stdout)
+		cmd.Stdout, cmd.Stderr = w, w
 	} else {
-		filteredNICs = allNICs
+		cmd.Stdout, cmd.Stderr = wrap(cmd.Stdout), wrap(cmd.Stderr)
 	}
-
-	printSuccess(fmt.Sprintf("Discovery completed: found %d Intel NIC(s) (after filtering)", len(filteredNICs)))
-	return filteredNICs, nil
+	return out
 }
 
-// incrementMAC increases MAC address by 1 (handles hexadecimal arithmetic)
-func incrementMAC(mac string) (string, error) {
-	// Split MAC address into bytes
-	parts := strings.Split(mac, ":")
-	if len(parts) != 6 {
-		return "", fmt.Errorf("invalid MAC address format: %s", mac)
+// record дописывает завершенный вызов в commands.trace, а вызовы этапа прошивки - в скрипт воспроизведения
+func (t *commandTracer) record(operation string, cmd *exec.Cmd, started time.Time, runErr error, out *traceOutput) {
+	if t == nil {
+		return
+	}
+	if operation == "" {
+		operation = "setup"
+	}
+	entry := CommandTraceEntry{
+		Timestamp:  started,
+		Operation:  operation,
+		Argv:       append([]string{cmd.Path}, cmd.Args[1:]...),
+		Dir:        cmd.Dir,
+		Env:        traceEnv(cmd.Env),
+		DurationMs: time.Since(started).Milliseconds(),
+		ExitCode:   cmd.ProcessState.ExitCode(),
+	}
+	if entry.Dir == "" {
+		entry.Dir, _ = os.Getwd()
+	}
+	if runErr != nil {
+		entry.Error = runErr.Error()
 	}
+	if out != nil {
+		entry.OutputBytes = out.total
+		entry.OutputHead = string(out.head)
+		entry.OutputTail = string(out.tail)
+	}
+	entry.Destructive = t.destructive(cmd.Args)
 
-	// Convert the last byte to an integer, increment it, and convert back
-	lastByte, err := strconv.ParseUint(parts[5], 16, 8)
+	data, err := json.Marshal(entry)
 	if err != nil {
-		return "", fmt.Errorf("invalid MAC address byte: %s", parts[5])
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writeEntry(data)
+	if strings.HasPrefix(operation, "flash: ") {
+		t.writeReplay(entry, cmd)
 	}
+}
 
-	// Increment with overflow handling
-	lastByte = (lastByte + 1) % 256
+// writeEntry пишет строку трассировки с учетом предела размера; при его достижении - одна отметка и конец записи
+func (t *commandTracer) writeEntry(data []byte) {
+	if t.full {
+		return
+	}
+	if t.size+int64(len(data))+1 > t.maxSize {
+		t.full = true
+		data, _ = json.Marshal(map[string]string{
+			"timestamp": time.Now().Format(time.RFC3339),
+			"error":     fmt.Sprintf("trace size limit of %d bytes reached, further commands are not recorded", t.maxSize),
+		})
+	}
+	n, err := t.file.Write(append(data, '\n'))
+	t.size += int64(n)
+	if err != nil {
+		printDebug(fmt.Sprintf("Command trace write failed: %v", err))
+	}
+}
 
-	// If the last byte overflows, increment the previous byte
-	if lastByte == 0 {
-		fifthByte, err := strconv.ParseUint(parts[4], 16, 8)
-		if err != nil {
-			return "", fmt.Errorf("invalid MAC address byte: %s", parts[4])
+// writeReplay добавляет вызов в commands.replay.sh; пишущие в железо шаги закомментированы
+func (t *commandTracer) writeReplay(entry CommandTraceEntry, cmd *exec.Cmd) {
+	quoted := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		quoted[i] = shellQuote(arg)
+	}
+	line := strings.Join(quoted, " ")
+	if cmd.Env != nil {
+		var vars []string
+		for _, v := range traceEnv(cmd.Env) {
+			vars = append(vars, shellQuote(v))
 		}
-		fifthByte = (fifthByte + 1) % 256
-		parts[4] = fmt.Sprintf("%02x", fifthByte)
+		line = "env " + strings.Join(vars, " ") + " " + line
 	}
-
-	// Update the last byte
-	parts[5] = fmt.Sprintf("%02x", lastByte)
-
-	// Join parts back together
-	return strings.Join(parts, ":"), nil
+	if cmd.Dir != "" {
+		line = fmt.Sprintf("(cd %s && %s)", shellQuote(cmd.Dir), line)
+	}
+	if entry.Destructive {
+		line = "# " + line
+	}
+	fmt.Fprintf(t.script, "\n# [%s] %s, exit %d, %dms\n%s\n",
+		entry.Operation, entry.Timestamp.Format("15:04:05"), entry.ExitCode, entry.DurationMs, line)
 }
 
-func executeEeupdateFlashing(nicIndex int, targetMAC string) error {
-
-	cleanMac := strings.ReplaceAll(targetMAC, ":", "")
+// traceEnv - значимые переменные окружения вызова: заданные через cmd.Env и отличающиеся от окружения процесса,
+// плюс traceEnvVars
+func traceEnv(cmdEnv []string) []string {
+	inherited := map[string]bool{}
+	for _, kv := range os.Environ() {
+		inherited[kv] = true
+	}
+	env := cmdEnv
+	if env == nil {
+		env = os.Environ()
+	}
+	var result []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(traceEnvVars, name) || (cmdEnv != nil && !inherited[kv]) {
+			result = append(result, kv)
+		}
+	}
+	return result
+}
 
-	printInfo(fmt.Sprintf("Executing eeupdate flashing for NIC %d, MAC: %s", nicIndex, targetMAC))
+// destructive - вызов меняет прошивку, EEPROM, FRU или загрузку; в скрипте воспроизведения он закомментирован
+func (t *commandTracer) destructive(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	name := filepath.Base(args[0])
+	rest := args[1:]
+	has := func(values ...string) bool {
+		for _, arg := range rest {
+			if slices.Contains(values, arg) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case t.tools[name], name == "reboot", name == "shutdown", name == "poweroff":
+		return true
+	case strings.HasPrefix(name, "eeupdate"):
+		// Безопасны только чтение MAC и выбор NIC
+		for _, arg := range rest {
+			upper := strings.ToUpper(arg)
+			if !strings.HasPrefix(upper, "/NIC=") && !strings.HasPrefix(upper, "/MAC_DUMP") && upper != "/ALL" {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(name, "rtnic"):
+		return has("/efuse", "/nicmac", "/nodeid")
+	case name == "ipmitool":
+		return has("write", "edit", "clear") || (has("sel") && has("set"))
+	case name == "efibootmgr":
+		return has("-c", "-B", "-n", "-o", "-a", "-A", "--create", "--delete-bootnum", "--bootnext", "--bootorder")
+	case name == "bootctl":
+		return has("set-oneshot", "set-default")
+	case name == "date":
+		return has("-s")
+	}
+	return false
+}
 
-	// Execute eeupdate64e with NIC and MAC parameters
-	cmd := exec.Command("eeupdate64e",
-		fmt.Sprintf("/NIC=%d", nicIndex),
-		fmt.Sprintf("/MAC=%s", cleanMac))
+// commandOperation - к чему относится внешний вызов вне тестов: открытый этап сессии
+func commandOperation() string {
+	return sessionTimer.phase()
+}
 
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+// tracedRun - cmd.Run с записью вызова в трассировку команд
+func tracedRun(cmd *exec.Cmd) error {
+	started := time.Now()
+	out := commandTrace.capture(cmd)
+	err := cmd.Run()
+	commandTrace.record(commandOperation(), cmd, started, err, out)
+	return err
+}
 
-	// Get exit code for detailed error reporting
-	var exitCode int = 0
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
+// tracedOutput - cmd.Output с записью вызова в трассировку команд
+func tracedOutput(cmd *exec.Cmd) ([]byte, error) {
+	started := time.Now()
+	output, err := cmd.Output()
+	if commandTrace != nil {
+		out := &traceOutput{limit: commandTrace.outputBytes}
+		out.Write(output)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			out.Write(exitErr.Stderr)
 		}
+		commandTrace.record(commandOperation(), cmd, started, err, out)
 	}
+	return output, err
+}
 
-	// Handle exit codes specifically
-	if err != nil {
-		if exitCode == 2 {
-			// Exit code 2 usually means no driver, but flashing might still work
-			printInfo(fmt.Sprintf("eeupdate64e reports no driver (exit code 2) for NIC %d, checking output for success...", nicIndex))
-		} else {
-			// Other exit codes might be more serious
-			printError(fmt.Sprintf("eeupdate64e failed with exit code %d for NIC %d", exitCode, nicIndex))
-			printError(fmt.Sprintf("Output: %s", outputStr))
-			return fmt.Errorf("eeupdate64e command failed with exit code %d: %v", exitCode, err)
-		}
+// tracedCombinedOutput - cmd.CombinedOutput с записью вызова в трассировку команд
+func tracedCombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	if commandTrace != nil {
+		out := &traceOutput{limit: commandTrace.outputBytes}
+		out.Write(output)
+		commandTrace.record(commandOperation(), cmd, started, err, out)
 	}
+	return output, err
+}
 
-	// Check output for success/failure indicators regardless of exit code
-	outputLower := strings.ToLower(outputStr)
+// AuditEntry - запись журнала аудита (audit.log, JSON по строке, только дозапись)
+type AuditEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	OffsetMs  int64     `json:"session_offset_ms"` // Монотонное смещение от запуска (порядок событий при неверных часах)
+	SessionID string    `json:"session_id"`
+	Operator  string    `json:"operator"`
+	Action    string    `json:"action"` // flash_mac, flash_fru, set_efi_var, test_failed, operator_auth
+	Target    string    `json:"target"`
+	Result    string    `json:"result"`
+	Details   string    `json:"details,omitempty"`
+}
 
-	// Look for specific success patterns from eeupdate
-	if strings.Contains(outputStr, "Updating Mac Address") && strings.Contains(outputStr, "Done") {
-		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
-		return nil
-	}
+// auditSession - контекст текущей сессии для записей аудита (заполняется в main)
+var auditSession struct {
+	SessionID string
+	Operator  string
+	LogDir    string
+}
 
-	if strings.Contains(outputStr, "Updating Checksum and CRCs") && strings.Contains(outputStr, "Done") {
-		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
-		return nil
-	}
+// appendAuditLog дописывает запись в <logDir>/audit.log. Файл никогда не обрезается и не ротируется.
+func appendAuditLog(entry AuditEntry, logDir string) error {
+	return appendAuditLogFile(entry, logDir, "audit.log")
+}
 
-	// Other positive indicators
-	if strings.Contains(outputLower, "success") ||
-		strings.Contains(outputLower, "complete") ||
-		strings.Contains(outputLower, "updated") ||
-		strings.Contains(outputLower, "written") {
-		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
-		return nil
+// appendAuditLogFile дописывает запись в журнал name в logDir (audit.log, efi_maintenance.log)
+func appendAuditLogFile(entry AuditEntry, logDir, name string) error {
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %v", err)
 	}
 
-	// Negative indicators (but exclude our own error headers)
-	if (strings.Contains(outputLower, "error") && !strings.Contains(outputLower, "mac flashing error")) ||
-		strings.Contains(outputLower, "fail") ||
-		strings.Contains(outputLower, "invalid") {
-		return fmt.Errorf("eeupdate reported error for NIC %d (exit code %d): %s", nicIndex, exitCode, outputStr)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
 	}
 
-	// If no clear indicators but we got substantial output, assume it worked
-	if len(outputStr) > 50 && err == nil {
-		printSuccess(fmt.Sprintf("eeupdate command completed for NIC %d", nicIndex))
-		return nil
+	file, err := os.OpenFile(filepath.Join(logDir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
 	}
+	defer file.Close()
 
-	// If exit code 2 but minimal output, still try to continue
-	if err != nil && exitCode == 2 {
-		printInfo(fmt.Sprintf("eeupdate completed for NIC %d with driver warning (exit code 2)", nicIndex))
-		return nil
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %v", err)
 	}
+	return file.Sync()
+}
 
-	// Default case - if we get here, status is unclear
-	printInfo(fmt.Sprintf("eeupdate command status unclear for NIC %d (exit code %d), assuming success", nicIndex, exitCode))
-	return nil
+// recordAudit пишет запись аудита для текущей сессии; ошибка записи не прерывает работу
+func recordAudit(action, target, result, details string) {
+	if auditSession.LogDir == "" {
+		return
+	}
+	now := time.Now()
+	entry := AuditEntry{
+		Timestamp: now,
+		OffsetMs:  sessionOffset(now).Milliseconds(),
+		SessionID: auditSession.SessionID,
+		Operator:  auditSession.Operator,
+		Action:    action,
+		Target:    target,
+		Result:    result,
+		Details:   details,
+	}
+	if err := appendAuditLog(entry, auditSession.LogDir); err != nil {
+		printWarning(fmt.Sprintf("Audit log: %v", err))
+	}
 }
 
-func flashMACWithEeupdate(targetMAC string, interfaces []NetworkInterface, flashConfig FlashConfig, summary *FlashMACSummary) error {
-	printInfo("Starting eeupdate MAC flashing process...")
+func askUserAction(testName string) string {
+	defer sessionTimer.operatorWait()()
+
+	fmt.Printf("\n%s%s%s\n", ColorRed, tr("test_failed.title"), ColorReset)
+	fmt.Println(tr("test_failed.body", testName))
+	fmt.Println(tr("prompt.choose_action"))
+	fmt.Printf("  %s[Y]%s %s\n", ColorGreen, ColorReset, tr("test_failed.retry"))
+	fmt.Printf("  %s[N]%s %s\n", ColorYellow, ColorReset, tr("test_failed.continue"))
+	fmt.Printf("  %s[S]%s %s\n", ColorBlue, ColorReset, tr("test_failed.skip"))
+	fmt.Printf("Choice [Y/n/s]: ")
 
-	// Step 1: Save current IP
-	var originalIP string
-	for _, iface := range interfaces {
-		if iface.IP != "" && iface.State == "UP" {
-			originalIP = iface.IP
-			break
-		}
+	reader := bufio.NewReader(operatorInput())
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		recordAudit("test_failed", testName, "Y", "no operator input")
+		return "Y" // Default on error
 	}
-	summary.OriginalIP = originalIP
 
-	if originalIP != "" {
-		printInfo(fmt.Sprintf("Current IP address saved: %s", originalIP))
+	choice := strings.ToUpper(strings.TrimSpace(input))
+	if choice == "" {
+		choice = "Y" // Default
 	}
 
-	// Step 2: Get Intel network drivers before discovery
-	intelDrivers, err := getIntelNetworkDrivers()
-	if err != nil {
-		printWarning(fmt.Sprintf("Failed to detect Intel drivers: %v", err))
-		intelDrivers = []string{"igb"} // Fallback к наиболее распространенному
+	var action string
+	switch choice {
+	case "Y", "YES":
+		action = "RETRY"
+	case "N", "NO":
+		action = "CONTINUE"
+	case "S", "SKIP":
+		action = "SKIP"
+	default:
+		fmt.Println(tr("prompt.invalid_choice", choice))
+		action = "RETRY"
 	}
 
-	// Step 3: Discover Intel NICs with optional filtering
-	printInfo("Scanning for Intel network cards...")
-	intelNICs, err := discoverIntelNICs(flashConfig.VenDevice)
+	recordAudit("test_failed", testName, action, "operator choice: "+choice)
+	return action
+}
+
+// askRequiredTestAction - вопрос по упавшему required тесту: только повтор или принятие провала с блокировкой прошивки
+func askRequiredTestAction(testName string) string {
+	defer sessionTimer.operatorWait()()
+
+	fmt.Printf("\n%s%s%s\n", ColorRed, tr("required_failed.title"), ColorReset)
+	fmt.Printf("%s%s%s\n", ColorRed, tr("required_failed.body", testName), ColorReset)
+	fmt.Println(tr("prompt.choose_action"))
+	fmt.Printf("  %s[Y]%s %s\n", ColorGreen, ColorReset, tr("required_failed.retry"))
+	fmt.Printf("  %s[B]%s %s\n", ColorRed, ColorReset, tr("required_failed.block"))
+	fmt.Printf("Choice [Y/b]: ")
+
+	reader := bufio.NewReader(operatorInput())
+	input, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to discover Intel NICs: %v", err)
+		recordAudit("required_test_failed", testName, "Y", "no operator input")
+		return "RETRY" // Default on error
 	}
 
-	if len(intelNICs) == 0 {
-		return fmt.Errorf("no Intel network cards found")
+	choice := strings.ToUpper(strings.TrimSpace(input))
+	if choice == "" {
+		choice = "Y" // Default
 	}
 
-	// Extract indices for summary
-	var nicIndices []int
-	for _, nic := range intelNICs {
-		nicIndices = append(nicIndices, nic.Index)
+	var action string
+	switch choice {
+	case "Y", "YES":
+		action = "RETRY"
+	case "B", "BLOCK":
+		action = "BLOCK"
+	default:
+		fmt.Println(tr("prompt.invalid_choice", choice))
+		action = "RETRY"
 	}
-	summary.NICIndices = nicIndices
 
-	printSuccess(fmt.Sprintf("Found %d Intel NIC(s) for flashing:", len(intelNICs)))
-	for i, nic := range intelNICs {
-		// Calculate MAC for this NIC (first gets original, others get incremented)
-		currentMAC := targetMAC
-		if i > 0 {
-			for j := 0; j < i; j++ {
-				currentMAC, err = incrementMAC(currentMAC)
-				if err != nil {
-					return fmt.Errorf("failed to increment MAC address for NIC %d: %v", nic.Index, err)
-				}
+	recordAudit("required_test_failed", testName, action, "operator choice: "+choice)
+	return action
+}
+
+// askTestAction выбирает вопрос оператору: для required теста, блокирующего прошивку, "продолжить" и "пропустить" недоступны
+func askTestAction(test TestSpec) string {
+	outputManager.ClearStatus()
+	if test.Required && blockFlashOnRequiredFailure {
+		return askRequiredTestAction(test.Name)
+	}
+	return askUserAction(test.Name)
+}
+
+func askUserProductMismatch(configProduct, detectedProduct string, identification *ProductIdentificationResult) bool {
+	defer sessionTimer.operatorWait()()
+	reader := bufio.NewReader(operatorInput())
+
+	fmt.Printf("\n%s%s%s\n", ColorRed, tr("mismatch.title"), ColorReset)
+	fmt.Printf("%s %s%s%s\n", tr("mismatch.config_for"), ColorYellow, configProduct, ColorReset)
+	fmt.Printf("%s %s%s%s\n", tr("mismatch.detected"), ColorYellow, detectedProduct, ColorReset)
+
+	if identification != nil {
+		fmt.Printf("\n%s\n", tr("mismatch.checks", identification.Mode))
+		for _, check := range identification.Checks {
+			mark := ColorRed + "✗"
+			if check.Matched {
+				mark = ColorGreen + "✓"
 			}
+			fmt.Printf("  %s %-22s%s %s\n", mark, check.Type, ColorReset, tr("mismatch.check_values", check.Expected, check.Actual))
 		}
-		fmt.Printf("  NIC %d: %s (%s) -> MAC: %s\n", nic.Index, nic.VendorDevice, nic.Description, currentMAC)
 	}
 
-	// Step 4: Unload Intel drivers before flashing
-	printInfo("Unloading Intel network drivers for flashing...")
-	for _, driver := range intelDrivers {
-		if err := unloadNetworkDriver(driver); err != nil {
-			printWarning(fmt.Sprintf("Failed to unload driver %s: %v", driver, err))
+	fmt.Printf("\n%s\n", tr("mismatch.unsuitable"))
+	fmt.Printf("%s\n\n", tr("mismatch.damage"))
+
+	if !isInteractive() {
+		printWarning(tr("mismatch.non_interactive"))
+		return true
+	}
+
+	for {
+		fmt.Printf("%s %s[Y/n]%s: ", tr("mismatch.ask_close"), ColorGreen, ColorReset)
+
+		input, err := reader.ReadString('\n')
+		if err != nil {
+			fmt.Printf("%s%s%s\n", ColorRed, tr("mismatch.read_error", err), ColorReset)
+			continue
+		}
+
+		input = strings.TrimSpace(strings.ToLower(input))
+
+		// Default is 'Y' (close program)
+		if input == "" || input == "y" || input == "yes" {
+			return true // Close program
+		} else if input == "n" || input == "no" {
+			return false // Continue
 		} else {
-			printSuccess(fmt.Sprintf("Driver %s unloaded successfully", driver))
+			fmt.Printf("%s%s%s\n", ColorRed, tr("mismatch.enter_yn"), ColorReset)
 		}
 	}
+}
 
-	// Wait for drivers to fully unload
-	time.Sleep(2 * time.Second)
+// effectiveTestTimeout - таймаут теста с приоритетом тест > глобальный > дефолт и откуда он взят
+func effectiveTestTimeout(test TestSpec, globalTimeout string) (time.Duration, string) {
+	return runner.EffectiveTimeout(test.Timeout, globalTimeout)
+}
 
-	// Step 5: Flash each NIC with incremented MAC addresses
-	attempts := 0
-	maxAttempts := 3
-	var lastError error
+// executeTest выполняет одну попытку теста с таймаутом, выбранным runner
+func executeTest(ctx context.Context, test TestSpec, timeout time.Duration) (TestResult, string) {
+	result := TestResult{
+		Name:        test.Name,
+		Description: test.Description,
+		Status:      "FAILED",
+		Required:    test.Required,
+		Command:     strings.TrimSpace(test.Command + " " + strings.Join(test.Args, " ")),
+	}
 
-	for attempts < maxAttempts {
-		attempts++
-		printInfo(fmt.Sprintf("Flashing attempt %d/%d...", attempts, maxAttempts))
+	startTime := time.Now()
+	result.Started = startTime
+	result.StartedOffset = sessionOffset(startTime)
+	defer markTestActive(test.Name)()
+
+	testSystemInfoMutex.Lock()
+	info := testSystemInfo
+	testSystemInfoMutex.Unlock()
+	args, err := expandTestArgTemplates(test, info)
+	if err != nil {
+		result.Error = err.Error()
+		result.Duration = time.Since(startTime)
+		return result, result.Error + "\n"
+	}
+	test.Args = args
+	result.Command = strings.TrimSpace(test.Command + " " + strings.Join(args, " "))
 
-		success := true
-		flashedNICs := 0
+	// Встроенный iperf3: собираем команду из параметров теста
+	var network *NetworkResult
+	if test.Type == "iperf3" {
+		command, args, info, minTimeout, err := buildIperf3Command(test.Iperf3)
+		if err != nil {
+			result.Error = err.Error()
+			result.Duration = time.Since(startTime)
+			return result, result.Error + "\n"
+		}
+		test.Command, test.Args = command, args
+		result.Command = strings.TrimSpace(command + " " + strings.Join(args, " "))
+		network = info
+		if test.Timeout == "" && timeout < minTimeout {
+			timeout = minTimeout
+		}
+	}
 
-		for i, nic := range intelNICs {
-			// Calculate MAC for this NIC
-			currentMAC := targetMAC
-			if i > 0 {
-				for j := 0; j < i; j++ {
-					currentMAC, err = incrementMAC(currentMAC)
-					if err != nil {
-						lastError = fmt.Errorf("failed to increment MAC address for NIC %d: %v", nic.Index, err)
-						success = false
-						break
-					}
-				}
-			}
+	result.Timeout = timeout
 
-			if !success {
-				break
-			}
+	// Create command
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-			printInfo(fmt.Sprintf("Flashing NIC %d (%s) with MAC %s...", nic.Index, nic.VendorDevice, currentMAC))
-			if err := executeEeupdateFlashing(nic.Index, currentMAC); err != nil {
-				printError(fmt.Sprintf("Failed to flash NIC %d: %v", nic.Index, err))
-				lastError = fmt.Errorf("failed to flash NIC %d: %v", nic.Index, err)
-				success = false
-				break
-			} else {
-				flashedNICs++
-				printSuccess(fmt.Sprintf("NIC %d flashing completed with MAC %s", nic.Index, currentMAC))
-			}
+	// Каждый тест в своей cgroup, чтобы цифры параллельных тестов не смешивались
+	var cg *testCgroup
+	if showResources && cgroupV2Available() {
+		if c, err := newTestCgroup(test.Name); err != nil {
+			printDebug(fmt.Sprintf("Resource usage: cgroup for %s not created: %v", test.Name, err))
+		} else {
+			cg = c
+			defer cg.remove()
 		}
+	}
 
-		if success {
-			printSuccess(fmt.Sprintf("All %d NICs flashed successfully with incremented MAC addresses", flashedNICs))
-			lastError = nil
-			break
-		}
+	if len(test.Steps) > 0 {
+		output := executeTestSteps(ctx, test, timeout, info, &result, cg)
+		result.Duration = time.Since(startTime)
+		result.Output = output
+		return result, output
+	}
 
-		if attempts < maxAttempts {
-			action := askFlashRetryAction(fmt.Sprintf("eeupdate flashing failed (attempt %d/%d): %v", attempts, maxAttempts, lastError))
-			if action == "SKIP" {
-				summary.Success = false
-				summary.Error = "Skipped by operator"
-				// Reload drivers before exiting
-				reloadIntelDrivers(intelDrivers)
-				return nil
-			}
-			if action == "ABORT" {
-				summary.Success = false
-				summary.Error = fmt.Sprintf("Aborted by operator after %d attempts", attempts)
-				// Reload drivers before exiting
-				reloadIntelDrivers(intelDrivers)
-				return fmt.Errorf("flashing aborted by operator")
-			}
-			// Continue to retry if action == "RETRY"
-		}
+	// Capture both stdout and stderr
+	var stdout, stderr bytes.Buffer
+	cmd, cg, peakKB, err := runTestProcess(ctx, test.Name, test.Command, test.Args, cg, &stdout, &stderr)
+	if showResources && cmd.ProcessState != nil {
+		result.Resources = collectResourceUsage(cmd.ProcessState, cg, peakKB)
 	}
+	result.Duration = time.Since(startTime)
 
-	if lastError != nil && attempts >= maxAttempts {
-		summary.Success = false
-		summary.Error = fmt.Sprintf("Max attempts reached: %v", lastError)
-		// Reload drivers before exiting
-		reloadIntelDrivers(intelDrivers)
-		return lastError
+	// Combine output for display
+	output := stdout.String() + stderr.String()
+	result.Output = output
+
+	// Determine result
+	result.Status, result.Error = runner.Outcome(ctx.Err() == context.DeadlineExceeded, timeout, err, stderr.String(), cmd.ProcessState.ExitCode())
+
+	if network != nil && result.Status != "TIMEOUT" {
+		output = evaluateIperf3Result(&result, network, test.Iperf3, stdout.Bytes(), stderr.String())
+		result.Output = output
 	}
 
-	// Step 6: Reload Intel drivers after flashing
-	printInfo("Reloading Intel network drivers...")
-	reloadIntelDrivers(intelDrivers)
+	return result, output
+}
 
-	// Wait for drivers to fully load and interfaces to come up
-	time.Sleep(5 * time.Second)
+// runTestProcess запускает команду теста (в cgroup теста, если она есть) и ждет ее завершения.
+// Возвращает cgroup, которая реально использовалась (nil после отката на rusage), и пик RSS по /proc
+func runTestProcess(ctx context.Context, testName, command string, args []string, cg *testCgroup, stdout, stderr io.Writer) (*exec.Cmd, *testCgroup, int64, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if cg != nil {
+		cg.attach(cmd)
+	}
+
+	started := time.Now()
+	trace := commandTrace.capture(cmd)
+	err := cmd.Start()
+	if err != nil && cg != nil {
+		// Ядро не умеет запускать сразу в cgroup (clone3) - повторяем без нее
+		printDebug(fmt.Sprintf("Resource usage: start in cgroup failed (%v), using rusage", err))
+		cg.remove()
+		cg = nil
+		cmd = exec.CommandContext(ctx, command, args...)
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		trace = commandTrace.capture(cmd)
+		err = cmd.Start()
+	}
+	var peakKB int64
+	if err == nil {
+		var stopSampling func() int64
+		if showResources {
+			stopSampling = sampleProcessHWM(cmd.Process.Pid)
+		}
+		err = cmd.Wait()
+		if showResources {
+			peakKB = stopSampling()
+		}
+	}
+	commandTrace.record("test: "+testName, cmd, started, err, trace)
+	return cmd, cg, peakKB, err
+}
 
-	// Step 7: Verify that at least the first MAC address is present
-	printInfo("Verifying MAC address presence...")
-	newInterfaces, err := getCurrentNetworkInterfaces()
-	if err != nil {
-		printError(fmt.Sprintf("Warning: failed to verify MAC flashing: %v", err))
-	} else {
-		// Check for the primary MAC address (first one)
-		exists, interfaceName := isTargetMACPresent(targetMAC, newInterfaces)
-		if exists {
-			summary.Success = true
-			summary.InterfaceName = interfaceName
-			printSuccess(fmt.Sprintf("SUCCESS: Primary MAC %s found on interface %s", targetMAC, interfaceName))
+// executeTestSteps выполняет шаги составного теста по порядку; ctx уже ограничен таймаутом теста.
+// Первый упавший шаг останавливает тест, остальные - SKIPPED; провал шага с continue_on_fail
+// только записывается. Вывод шагов склеивается с заголовками, итог теста - по упавшему шагу
+func executeTestSteps(ctx context.Context, test TestSpec, timeout time.Duration, info SystemInfo, result *TestResult, cg *testCgroup) string {
+	var output strings.Builder
+	var commands []string
+	var usage *ResourceUsage
+	var peakKB int64
+	result.Status, result.Error = "PASSED", ""
+	result.Steps = make([]StepResult, len(test.Steps))
+
+	stopped := false
+	for i, step := range test.Steps {
+		stepResult := &result.Steps[i]
+		stepResult.Name = step.Name
+		if stopped {
+			stepResult.Status = "SKIPPED"
+			continue
+		}
 
-			// Also check for incremented MAC addresses and report them
-			currentMAC := targetMAC
-			for i := 1; i < len(intelNICs); i++ {
-				currentMAC, err = incrementMAC(currentMAC)
-				if err != nil {
-					printError(fmt.Sprintf("Warning: failed to increment MAC for verification: %v", err))
-					break
-				}
+		stepArgs, err := expandTestArgTemplates(TestSpec{Args: step.Args, compiledArgs: step.compiledArgs}, info)
+		if err != nil {
+			stepResult.Status, stepResult.Error = "FAILED", err.Error()
+		} else {
+			commands = append(commands, strings.TrimSpace(step.Command+" "+strings.Join(stepArgs, " ")))
+			stepCtx, stepTimeout := ctx, timeout
+			cancel := func() {}
+			if step.Timeout != "" {
+				stepTimeout, _ = time.ParseDuration(step.Timeout) // Проверено в validateConfig
+				stepCtx, cancel = context.WithTimeout(ctx, stepTimeout)
+			}
 
-				exists, ifaceName := isTargetMACPresent(currentMAC, newInterfaces)
-				if exists {
-					printSuccess(fmt.Sprintf("Additional MAC %s found on interface %s", currentMAC, ifaceName))
-				} else {
-					printError(fmt.Sprintf("Warning: Expected MAC %s not found on any interface", currentMAC))
+			var stdout, stderr bytes.Buffer
+			started := time.Now()
+			var cmd *exec.Cmd
+			var stepPeak int64
+			cmd, cg, stepPeak, err = runTestProcess(stepCtx, test.Name+" / "+step.Name, step.Command, stepArgs, cg, &stdout, &stderr)
+			stepResult.Duration = time.Since(started)
+			timedOut := stepCtx.Err() == context.DeadlineExceeded
+			cancel()
+			if ctx.Err() == context.DeadlineExceeded {
+				stepTimeout = timeout // Кончился таймаут всего теста, а не шага
+			}
+			stepResult.Status, stepResult.Error = runner.Outcome(timedOut, stepTimeout, err, stderr.String(), cmd.ProcessState.ExitCode())
+
+			stepOutput := stdout.String() + stderr.String()
+			if stepResult.Status == "PASSED" {
+				switch {
+				case step.failRegex != nil && step.failRegex.MatchString(stepOutput):
+					stepResult.Status, stepResult.Error = "FAILED", fmt.Sprintf("output matches fail_pattern %q", step.FailPattern)
+				case step.passRegex != nil && !step.passRegex.MatchString(stepOutput):
+					stepResult.Status, stepResult.Error = "FAILED", fmt.Sprintf("output does not match pass_pattern %q", step.PassPattern)
 				}
 			}
+			fmt.Fprintf(&output, "=== Step %d/%d: %s [%s, %s] ===\n%s", i+1, len(test.Steps), step.Name,
+				stepResult.Status, stepResult.Duration.Round(time.Millisecond), stepOutput)
+			if stepOutput != "" && !strings.HasSuffix(stepOutput, "\n") {
+				output.WriteString("\n")
+			}
 
-			// Try to restore IP address to the primary interface
-			if originalIP != "" {
-				printInfo(fmt.Sprintf("Restoring original IP address: %s", originalIP))
-				if err := restoreIPAddress(interfaceName, originalIP); err != nil {
-					printError(fmt.Sprintf("Warning: failed to restore IP %s: %v", originalIP, err))
+			if showResources && cmd.ProcessState != nil {
+				peakKB = max(peakKB, stepPeak)
+				stepUsage := collectResourceUsage(cmd.ProcessState, nil, stepPeak)
+				if usage == nil {
+					usage = stepUsage
 				} else {
-					printSuccess(fmt.Sprintf("IP address %s restored successfully", originalIP))
+					usage.PeakRSSKB = max(usage.PeakRSSKB, stepUsage.PeakRSSKB)
+					usage.UserSec += stepUsage.UserSec
+					usage.SystemSec += stepUsage.SystemSec
 				}
 			}
-		} else {
-			printError("Primary MAC not found on any interface after flashing")
-			action := askFlashRetryAction(fmt.Sprintf("Flashing completed but target MAC %s not found on any interface", targetMAC))
-			if action == "SKIP" {
-				summary.Success = false
-				summary.Error = "MAC not found after flashing - skipped by operator"
-				return nil
-			}
-			if action == "ABORT" {
-				summary.Success = false
-				summary.Error = "MAC not found after flashing - aborted by operator"
-				return fmt.Errorf("MAC not found after flashing - aborted by operator")
-			}
-			summary.Success = false
-			summary.Error = "MAC not found after flashing"
-			return fmt.Errorf("target MAC not found after flashing")
 		}
+
+		if stepResult.Status == "PASSED" {
+			continue
+		}
+		// Таймаут всего теста останавливает шаги и с continue_on_fail
+		if step.ContinueOnFail && ctx.Err() == nil {
+			fmt.Fprintf(&output, "(%s failed, continue_on_fail: %s)\n", step.Name, stepResult.Error)
+			continue
+		}
+		result.Status = stepResult.Status
+		result.Error = fmt.Sprintf("step '%s': %s", step.Name, stepResult.Error)
+		stopped = true
 	}
 
-	return nil
+	result.Command = strings.Join(commands, " ; ")
+	if showResources {
+		if cg != nil {
+			usage = collectResourceUsage(nil, cg, peakKB)
+		}
+		result.Resources = usage
+	}
+	return output.String()
 }
 
-// Функция для проверки загрузки pgdrv модуля с таймаутом
-func verifyPgdrvLoaded() error {
-	cmd := exec.Command("lsmod")
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to run lsmod: %v", err)
-	}
+// showResources включает сбор потребления ресурсов тестами (-show-resources или tests.show_resources)
+var showResources bool
 
-	if strings.Contains(string(output), "pgdrv") {
-		return nil
-	}
+const cgroupRoot = "/sys/fs/cgroup"
 
-	return fmt.Errorf("pgdrv module not found in lsmod output")
-}
+var (
+	cgroupOnce      sync.Once
+	cgroupAvailable bool
+	cgroupSeq       atomic.Int64
+	activeCgroups   = make(map[string]*testCgroup)
+	activeCgroupsMu sync.Mutex
+)
 
-// Функция ожидания загрузки pgdrv с циклом проверки
-func waitForPgdrvLoad(timeoutSeconds int) error {
-	for i := 0; i < timeoutSeconds*10; i++ { // Проверяем каждые 100мс
-		if err := verifyPgdrvLoaded(); err == nil {
-			return nil
+// cgroupV2Available проверяет (один раз), можно ли создавать cgroup v2 для тестов.
+// Иначе - одно предупреждение и rusage для всех тестов.
+func cgroupV2Available() bool {
+	cgroupOnce.Do(func() {
+		if os.Geteuid() != 0 {
+			printWarning("Resource usage: not running as root, falling back to rusage (no IO accounting)")
+			return
 		}
-		time.Sleep(100 * time.Millisecond) // Задержка цикла проверки
-	}
-	return fmt.Errorf("timeout waiting for pgdrv module to load")
-}
-
-// Функция ожидания выгрузки pgdrv с циклом проверки
-func waitForPgdrvUnload(timeoutSeconds int) error {
-	for i := 0; i < timeoutSeconds*10; i++ { // Проверяем каждые 100мс
-		if err := verifyPgdrvLoaded(); err != nil {
-			return nil // Модуль не найден = выгружен
+		if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+			printWarning("Resource usage: cgroup v2 is not available, falling back to rusage (no IO accounting)")
+			return
 		}
-		time.Sleep(100 * time.Millisecond) // Задержка цикла проверки
-	}
-	return fmt.Errorf("timeout waiting for pgdrv module to unload")
+		addShutdownHook(removeActiveCgroups)
+		cgroupAvailable = true
+	})
+	return cgroupAvailable
 }
 
-// Функция для загрузки rtnicpg драйвера из файла
-func loadRtnicpgDriverFromPath(driverPath string) error {
-	printInfo(fmt.Sprintf("Loading rtnicpg driver from: %s", driverPath))
+// testCgroup - временная cgroup одного запуска теста
+type testCgroup struct {
+	path string
+	dir  *os.File
+}
 
-	// Проверяем существование файла
-	if _, err := os.Stat(driverPath); os.IsNotExist(err) {
-		return fmt.Errorf("driver file not found: %s", driverPath)
+func newTestCgroup(testName string) (*testCgroup, error) {
+	name := fmt.Sprintf("firestarter-%d-%d-%s", os.Getpid(), cgroupSeq.Add(1), sanitizeFileName(testName))
+	path := filepath.Join(cgroupRoot, name)
+	if err := os.Mkdir(path, 0755); err != nil {
+		return nil, err
 	}
-
-	// Загружаем драйвер
-	cmd := exec.Command("insmod", driverPath)
-	output, err := cmd.CombinedOutput()
+	dir, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("insmod failed: %v\nOutput: %s", err, string(output))
-	}
-
-	// Ждем загрузки pgdrv модуля с таймаутом
-	if err := waitForPgdrvLoad(5); err != nil {
-		return fmt.Errorf("pgdrv driver verification failed: %v", err)
+		os.Remove(path)
+		return nil, err
 	}
 
-	printSuccess("pgdrv driver loaded and verified successfully")
-	return nil
+	cg := &testCgroup{path: path, dir: dir}
+	activeCgroupsMu.Lock()
+	activeCgroups[path] = cg
+	activeCgroupsMu.Unlock()
+	return cg, nil
 }
 
-// Функция для выгрузки pgdrv модуля
-func unloadPgdrvDriver() error {
-	printInfo("Unloading pgdrv module")
-
-	// Проверяем, загружен ли pgdrv
-	if err := verifyPgdrvLoaded(); err != nil {
-		printInfo("pgdrv module not loaded, nothing to unload")
-		return nil
+// attach запускает процесс сразу в cgroup, а по таймауту убивает все дерево, а не только прямого потомка
+func (cg *testCgroup) attach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: int(cg.dir.Fd())}
+	cmd.Cancel = func() error {
+		cg.kill()
+		return cmd.Process.Kill()
 	}
+}
 
-	// Выгружаем модуль pgdrv
-	cmd := exec.Command("rmmod", "pgdrv")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// Если не получилось, попробуем форсированно
-		printWarning(fmt.Sprintf("Normal rmmod failed, trying force: %v", err))
-		cmd = exec.Command("rmmod", "-f", "pgdrv")
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("rmmod pgdrv failed: %v\nOutput: %s", err, string(output))
-		}
+// kill завершает все процессы cgroup (cgroup.kill есть с 5.14, иначе по списку cgroup.procs)
+func (cg *testCgroup) kill() {
+	if err := os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0644); err == nil {
+		return
 	}
-
-	// Ждем выгрузки модуля с таймаутом
-	if err := waitForPgdrvUnload(3); err != nil {
-		printWarning("pgdrv module still appears loaded after rmmod")
-	} else {
-		printSuccess("pgdrv module unloaded successfully")
+	data, err := os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return
 	}
-
-	return nil
-}
-
-// Функция ожидания загрузки сетевого драйвера
-func waitForDriverLoad(driverName string, timeoutSeconds int) error {
-	for i := 0; i < timeoutSeconds*10; i++ { // Проверяем каждые 100мс
-		cmd := exec.Command("lsmod")
-		output, err := cmd.Output()
-		if err == nil && strings.Contains(string(output), driverName) {
-			return nil
+	for _, field := range strings.Fields(string(data)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
 		}
-		time.Sleep(100 * time.Millisecond) // Задержка цикла проверки
 	}
-	return fmt.Errorf("timeout waiting for driver %s to load", driverName)
 }
 
-// Функция для проверки первоначального состояния драйверов
-func checkInitialDriverState(primaryInterface *NetworkInterface) (pgdrvLoaded bool, realtekActive bool) {
-	// Проверяем загружен ли pgdrv
-	pgdrvLoaded = (verifyPgdrvLoaded() == nil)
-
-	// Проверяем активен ли Realtek драйвер
-	realtekActive = false
-	if primaryInterface != nil && primaryInterface.Driver != "" && isRealtekDriver(primaryInterface.Driver) {
-		cmd := exec.Command("lsmod")
-		if output, err := cmd.Output(); err == nil {
-			realtekActive = strings.Contains(string(output), primaryInterface.Driver)
-		}
+// remove убивает оставшиеся процессы и удаляет cgroup. Повторный вызов безопасен.
+func (cg *testCgroup) remove() {
+	activeCgroupsMu.Lock()
+	_, active := activeCgroups[cg.path]
+	delete(activeCgroups, cg.path)
+	activeCgroupsMu.Unlock()
+	if !active {
+		return
 	}
 
-	return pgdrvLoaded, realtekActive
+	cg.dir.Close()
+	cg.kill()
+	_, removed := pollUntil(2*time.Second, 50*time.Millisecond, func() bool {
+		return os.Remove(cg.path) == nil
+	})
+	if !removed {
+		printWarning(fmt.Sprintf("Resource usage: failed to remove cgroup %s", cg.path))
+	}
 }
 
-// Заменяем функцию loadFlashingDriver на версию без хардкодных sleep'ов
-func loadFlashingDriver(driverDir, originalDriver string) (string, error) {
-	printInfo(fmt.Sprintf("Loading flashing driver for: %s", originalDriver))
-
-	// Получаем версию ядра
-	kernelVersion, err := getKernelVersion()
-	if err != nil {
-		return "", fmt.Errorf("failed to get kernel version: %v", err)
+// removeActiveCgroups - shutdown hook: удаляет cgroup тестов, прерванных сигналом
+func removeActiveCgroups() {
+	activeCgroupsMu.Lock()
+	var groups []*testCgroup
+	for _, cg := range activeCgroups {
+		groups = append(groups, cg)
 	}
+	activeCgroupsMu.Unlock()
+	for _, cg := range groups {
+		cg.remove()
+	}
+}
 
-	// Step 1: Проверяем наличие готового скомпилированного драйвера
-	compiledDriverPath, found := checkCompiledDriver(driverDir, originalDriver, kernelVersion)
-	if found {
-		printInfo("Attempting to use pre-compiled rtnicpg driver...")
-		if err := loadRtnicpgDriverFromPath(compiledDriverPath); err == nil {
-			printSuccess("Pre-compiled pgdrv driver loaded successfully")
-			return compiledDriverPath, nil
-		} else {
-			printWarning(fmt.Sprintf("Pre-compiled driver failed to load: %v", err))
-			printInfo("Will attempt to recompile driver...")
+// usage читает memory.peak, cpu.stat и io.stat. Файлы отключенных контроллеров пропускаются.
+func (cg *testCgroup) usage() ResourceUsage {
+	usage := ResourceUsage{Source: "cgroup"}
 
-			// Убираем возможно частично загруженный модуль
-			unloadPgdrvDriver()
+	if data, err := os.ReadFile(filepath.Join(cg.path, "memory.peak")); err == nil {
+		if peak, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			usage.PeakRSSKB = peak / 1024
 		}
 	}
 
-	// Step 2: Компилируем новый драйвер
-	printInfo("Compiling new rtnicpg driver...")
-	compiledPath, err := compileFlashingDriver(driverDir, originalDriver)
-	if err != nil {
-		return "", fmt.Errorf("failed to compile driver: %v", err)
+	if data, err := os.ReadFile(filepath.Join(cg.path, "cpu.stat")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			usec, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "user_usec":
+				usage.UserSec = float64(usec) / 1e6
+			case "system_usec":
+				usage.SystemSec = float64(usec) / 1e6
+			}
+		}
 	}
 
-	// Step 3: Загружаем новый драйвер
-	if err := loadRtnicpgDriverFromPath(compiledPath); err != nil {
-		return "", fmt.Errorf("failed to load compiled pgdrv driver: %v", err)
+	if data, err := os.ReadFile(filepath.Join(cg.path, "io.stat")); err == nil {
+		for _, field := range strings.Fields(string(data)) {
+			if value, ok := strings.CutPrefix(field, "rbytes="); ok {
+				n, _ := strconv.ParseInt(value, 10, 64)
+				usage.IOReadBytes += n
+			} else if value, ok := strings.CutPrefix(field, "wbytes="); ok {
+				n, _ := strconv.ParseInt(value, 10, 64)
+				usage.IOWriteBytes += n
+			}
+		}
 	}
 
-	printSuccess("rtnicpg driver compiled and pgdrv module loaded successfully")
-	return compiledPath, nil
+	return usage
 }
 
-// Модифицированная функция flashMACWithRtnicpg для работы с Realtek драйверами
-func flashMACWithRtnicpg(targetMAC string, interfaces []NetworkInterface, systemConfig SystemConfig, summary *FlashMACSummary) error {
-	printInfo("Starting rtnicpg MAC flashing process with Realtek driver detection...")
-
-	// Диагностика интерфейсов для отладки
-	debugNetworkInterfaces(interfaces)
-	debugLoadedModules()
-
-	// Step 1: Сначала попытаемся найти Realtek интерфейс
-	primaryInterface := findRealtekInterface(interfaces)
-
-	// Step 1.1: Если Realtek не найден, используем fallback на старую логику
-	if primaryInterface == nil {
-		printWarning("No Realtek network interface found, using fallback to any active interface...")
-		printInfo("Available interfaces:")
-		for _, iface := range interfaces {
-			if iface.Name != "lo" {
-				driverType := "UNKNOWN"
-				if iface.Driver != "" {
-					if isRealtekDriver(iface.Driver) {
-						driverType = "REALTEK"
-					} else if strings.Contains(strings.ToLower(iface.Driver), "intel") ||
-						iface.Driver == "igb" || iface.Driver == "e1000e" ||
-						iface.Driver == "ixgbe" || iface.Driver == "i40e" || iface.Driver == "ice" {
-						driverType = "INTEL"
-					} else {
-						driverType = "OTHER"
-					}
+// sampleProcessHWM опрашивает VmHWM прямого потомка, пока он жив. Возвращает функцию остановки с пиком в KB.
+func sampleProcessHWM(pid int) func() int64 {
+	var peak atomic.Int64
+	statusPath := fmt.Sprintf("/proc/%d/status", pid)
+	sample := func() {
+		data, err := os.ReadFile(statusPath)
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(line, "VmHWM:"); ok {
+				kb, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+				if kb > peak.Load() {
+					peak.Store(kb)
 				}
-				printInfo(fmt.Sprintf("  [%s] %s: MAC=%s Driver=%s State=%s IP=%s",
-					driverType, iface.Name, iface.MAC, iface.Driver, iface.State, iface.IP))
+				return
 			}
 		}
+	}
 
-		// Fallback: ищем любой активный интерфейс с IP (как в оригинальном коде)
-		for i := range interfaces {
-			if interfaces[i].IP != "" && interfaces[i].State == "UP" {
-				primaryInterface = &interfaces[i]
-				printWarning(fmt.Sprintf("Using fallback interface %s (Driver: %s) - rtnicpg may work with non-Realtek drivers",
-					interfaces[i].Name, interfaces[i].Driver))
-				break
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(200 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			sample()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
 			}
 		}
+	}()
 
-		if primaryInterface == nil {
-			return fmt.Errorf("no active network interface with IP found")
-		}
+	return func() int64 {
+		close(done)
+		<-finished
+		return peak.Load()
 	}
+}
 
-	summary.OriginalIP = primaryInterface.IP
-	summary.OriginalDriver = primaryInterface.Driver
-
-	printInfo(fmt.Sprintf("Using interface %s (IP: %s, Driver: %s, State: %s)",
-		primaryInterface.Name, primaryInterface.IP, primaryInterface.Driver, primaryInterface.State))
-
-	// Step 2: Если интерфейс неактивен, попытаемся его поднять (но не будем ждать)
-	if primaryInterface.State != "UP" {
-		printInfo(fmt.Sprintf("Interface %s is DOWN, attempting to bring it UP...", primaryInterface.Name))
-		cmd := exec.Command("ip", "link", "set", primaryInterface.Name, "up")
-		if err := cmd.Run(); err != nil {
-			printWarning(fmt.Sprintf("Failed to bring interface UP: %v", err))
-		} else {
-			printInfo(fmt.Sprintf("Interface %s UP command sent (not waiting for activation)", primaryInterface.Name))
+// collectResourceUsage берет данные cgroup, а без нее - rusage завершенного процесса и выборку VmHWM
+func collectResourceUsage(state *os.ProcessState, cg *testCgroup, sampledPeakKB int64) *ResourceUsage {
+	if cg != nil {
+		usage := cg.usage()
+		if usage.PeakRSSKB == 0 {
+			usage.PeakRSSKB = sampledPeakKB // memory.peak появился только в 5.19
 		}
+		return &usage
 	}
 
-	// Step 3: Подготовка pgdrv драйвера с проверкой начального состояния
-	driverPath, err := preparePgdrvDriver(systemConfig.DriverDir, primaryInterface.Driver, primaryInterface)
-	if err != nil {
-		// Try to restore original driver if preparation failed
-		printWarning("Failed to prepare pgdrv driver, attempting to restore original...")
-		if restoreErr := loadNetworkDriver(primaryInterface.Driver); restoreErr != nil {
-			printError(fmt.Sprintf("Failed to restore original driver: %v", restoreErr))
+	usage := ResourceUsage{Source: "rusage", PeakRSSKB: sampledPeakKB}
+	if state != nil {
+		if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+			usage.PeakRSSKB = max(usage.PeakRSSKB, ru.Maxrss) // Linux: KB
+			usage.UserSec = time.Duration(syscall.TimevalToNsec(ru.Utime)).Seconds()
+			usage.SystemSec = time.Duration(syscall.TimevalToNsec(ru.Stime)).Seconds()
 		}
-		return fmt.Errorf("failed to prepare pgdrv driver: %v", err)
 	}
+	return &usage
+}
 
-	// Step 3.1: Verify pgdrv is loaded
-	if err := verifyPgdrvLoaded(); err != nil {
-		// Try to restore original driver
-		printError("pgdrv module not found after preparation, restoring original driver...")
-		loadNetworkDriver(primaryInterface.Driver)
-		return fmt.Errorf("pgdrv module verification failed: %v", err)
+// formatResourceUsage - строка для итогов группы
+func formatResourceUsage(usage *ResourceUsage) string {
+	text := fmt.Sprintf("RSS %.1f MB, CPU %.2fs user / %.2fs sys",
+		float64(usage.PeakRSSKB)/1024, usage.UserSec, usage.SystemSec)
+	if usage.Source == "cgroup" {
+		text += fmt.Sprintf(", IO %.1f MB read / %.1f MB written",
+			float64(usage.IOReadBytes)/(1<<20), float64(usage.IOWriteBytes)/(1<<20))
 	}
-	printSuccess("pgdrv module confirmed loaded and ready for flashing")
+	return text
+}
 
-	// Step 4: Flash MAC using rtnic
-	attempts := 0
-	maxAttempts := 3
-	var flashErr error
+// flashedMAC - MAC, прошитый в этой сессии (для iperf3 bind_flashed_mac)
+var flashedMAC string
 
-	for attempts < maxAttempts {
-		attempts++
-		printInfo(fmt.Sprintf("Flashing MAC attempt %d/%d using rtnic (pgdrv loaded)...", attempts, maxAttempts))
+// buildIperf3Command собирает вызов iperf3 --json и минимальный таймаут под длительность теста
+func buildIperf3Command(spec *Iperf3Spec) (string, []string, *NetworkResult, time.Duration, error) {
+	if spec == nil || spec.Server == "" {
+		return "", nil, nil, 0, fmt.Errorf("iperf3 test requires iperf3.server")
+	}
+	path, err := exec.LookPath("iperf3")
+	if err != nil {
+		return "", nil, nil, 0, fmt.Errorf("iperf3 binary not found in PATH, install iperf3 on the station")
+	}
 
-		flashErr = executeRtnicFlashing(targetMAC)
-		if flashErr == nil {
-			printSuccess(fmt.Sprintf("rtnic flashing completed successfully on attempt %d", attempts))
-			break
+	duration := 10 * time.Second
+	if spec.Duration != "" {
+		if duration, err = time.ParseDuration(spec.Duration); err != nil || duration < time.Second {
+			return "", nil, nil, 0, fmt.Errorf("invalid iperf3.duration %q", spec.Duration)
 		}
+	}
 
-		printError(fmt.Sprintf("rtnic flashing failed on attempt %d: %v", attempts, flashErr))
+	info := &NetworkResult{Server: spec.Server, Protocol: "tcp", Direction: "upload"}
+	args := []string{"--json", "-c", spec.Server, "-t", strconv.Itoa(int(duration.Seconds()))}
+	if spec.Port > 0 {
+		args = append(args, "-p", strconv.Itoa(spec.Port))
+		info.Server = fmt.Sprintf("%s:%d", spec.Server, spec.Port)
+	}
+	switch spec.Direction {
+	case "", "upload":
+	case "download":
+		args = append(args, "-R")
+		info.Direction = "download"
+	case "bidir":
+		args = append(args, "--bidir")
+		info.Direction = "bidir"
+	default:
+		return "", nil, nil, 0, fmt.Errorf("invalid iperf3.direction %q (expected upload, download or bidir)", spec.Direction)
+	}
+	if spec.UDP {
+		args = append(args, "-u")
+		info.Protocol = "udp"
+	}
+	if spec.Bandwidth != "" {
+		args = append(args, "-b", spec.Bandwidth)
+	}
+	if spec.Parallel > 1 {
+		args = append(args, "-P", strconv.Itoa(spec.Parallel))
+	}
 
-		if attempts < maxAttempts {
-			action := askFlashRetryAction(fmt.Sprintf("rtnic flashing failed (attempt %d): %v", attempts, flashErr))
-			if action == "SKIP" {
-				summary.Success = false
-				summary.Error = "Skipped by operator"
-				break
-			}
-			if action == "ABORT" {
-				summary.Success = false
-				summary.Error = "Aborted by operator"
-				flashErr = fmt.Errorf("flashing aborted by operator")
-				break
-			}
-			if action != "RETRY" {
-				break
-			}
+	iface := spec.Interface
+	if spec.BindFlashedMAC {
+		if flashedMAC == "" {
+			return "", nil, nil, 0, fmt.Errorf("bind_flashed_mac is set but no MAC was flashed in this session")
+		}
+		if iface, err = interfaceByMAC(flashedMAC); err != nil {
+			return "", nil, nil, 0, err
+		}
+	}
+	if iface != "" {
+		ip, err := interfaceIPv4(iface)
+		if err != nil {
+			return "", nil, nil, 0, err
 		}
+		args = append(args, "-B", ip)
+		info.Interface = iface
 	}
 
-	// Step 5: Cleanup - unload pgdrv module and restore original driver
-	printInfo("Cleaning up: unloading pgdrv and restoring original driver...")
+	// Запас на установку соединения и финальный обмен статистикой
+	return path, args, info, duration + 20*time.Second, nil
+}
 
-	// Выгружаем pgdrv модуль (если он не был предзагружен)
-	if driverPath != "pgdrv_already_loaded" {
-		if err := unloadPgdrvDriver(); err != nil {
-			printError(fmt.Sprintf("Warning: failed to unload pgdrv module: %v", err))
+// interfaceByMAC находит интерфейс с заданным MAC (после прошивки драйверы перезагружались - читаем заново)
+func interfaceByMAC(mac string) (string, error) {
+	interfaces, err := readNetworkInterfaces(pollingRunner())
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range interfaces {
+		if strings.EqualFold(iface.MAC, mac) {
+			return iface.Name, nil
 		}
+	}
+	return "", fmt.Errorf("no interface carries the flashed MAC %s", mac)
+}
 
-		// Восстанавливаем оригинальный драйвер
-		if err := loadNetworkDriver(primaryInterface.Driver); err != nil {
-			printError(fmt.Sprintf("Warning: failed to restore original driver %s: %v", primaryInterface.Driver, err))
-		} else {
-			printSuccess(fmt.Sprintf("Original driver %s restored successfully", primaryInterface.Driver))
+// interfaceIPv4 возвращает IPv4 адрес интерфейса для привязки (iperf3, ssh/scp)
+func interfaceIPv4(name string) (string, error) {
+	interfaces, err := getCurrentNetworkInterfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range interfaces {
+		if iface.Name != name {
+			continue
 		}
-	} else {
-		printInfo("pgdrv was pre-loaded, leaving it active (not restoring original driver)")
+		if iface.IP == "" {
+			return "", fmt.Errorf("interface %s has no IPv4 address, cannot bind to it", name)
+		}
+		return strings.SplitN(iface.IP, "/", 2)[0], nil
 	}
+	return "", fmt.Errorf("interface %s not found", name)
+}
 
-	// Step 5.1: Verify cleanup state
-	debugLoadedModules()
+// iperf3Report - нужная часть вывода iperf3 --json
+type iperf3Report struct {
+	Start struct {
+		TestStart struct {
+			Protocol string `json:"protocol"`
+			Reverse  int    `json:"reverse"`
+		} `json:"test_start"`
+	} `json:"start"`
+	End struct {
+		SumSent                 iperf3Sum  `json:"sum_sent"`
+		SumReceived             iperf3Sum  `json:"sum_received"`
+		SumSentBidirReverse     *iperf3Sum `json:"sum_sent_bidir_reverse"`
+		SumReceivedBidirReverse *iperf3Sum `json:"sum_received_bidir_reverse"`
+		Sum                     *iperf3Sum `json:"sum"` // UDP
+	} `json:"end"`
+	Error string `json:"error"`
+}
 
-	// Проверяем результат флэширования
-	if flashErr != nil && attempts >= maxAttempts {
-		summary.Success = false
-		summary.Error = fmt.Sprintf("Max attempts reached: %v", flashErr)
-		return flashErr
-	}
+type iperf3Sum struct {
+	BitsPerSecond float64 `json:"bits_per_second"`
+	Retransmits   int     `json:"retransmits"`
+	JitterMs      float64 `json:"jitter_ms"`
+	LostPercent   float64 `json:"lost_percent"`
+}
 
-	if summary.Error != "" {
-		return fmt.Errorf("%s", summary.Error)
+// parseIperf3Result разбирает вывод iperf3 --json в измерения (TCP/UDP, прямой, -R и --bidir)
+func parseIperf3Result(data []byte, info NetworkResult) (NetworkResult, error) {
+	var report iperf3Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return info, fmt.Errorf("failed to parse iperf3 JSON output: %v", err)
+	}
+	if report.Error != "" {
+		return info, errors.New(report.Error)
 	}
 
-	// Step 6: Verify MAC was flashed
-	printInfo("Verifying MAC address after flashing...")
+	// Скорость считаем по принятым данным - это то, что реально прошло по линку
+	received := report.End.SumReceived
+	if strings.EqualFold(report.Start.TestStart.Protocol, "UDP") {
+		info.Protocol = "udp"
+		if report.End.Sum != nil {
+			received = *report.End.Sum
+		}
+		info.JitterMs = received.JitterMs
+		info.LostPercent = received.LostPercent
+	} else {
+		info.Retransmits = report.End.SumSent.Retransmits
+	}
+	if report.Start.TestStart.Reverse != 0 && info.Direction == "upload" {
+		info.Direction = "download"
+	}
+	info.Mbps = received.BitsPerSecond / 1e6
 
-	newInterfaces, err := getCurrentNetworkInterfaces()
-	if err != nil {
-		printError(fmt.Sprintf("Warning: failed to verify MAC flashing: %v", err))
-		summary.Success = false
-		summary.Error = "Failed to verify flashing result"
-		return fmt.Errorf("failed to verify MAC flashing: %v", err)
+	if reverse := report.End.SumReceivedBidirReverse; reverse != nil {
+		info.Direction = "bidir"
+		info.ReverseMbps = reverse.BitsPerSecond / 1e6
+		if sent := report.End.SumSentBidirReverse; sent != nil {
+			info.Retransmits += sent.Retransmits
+		}
 	}
 
-	// Проверяем наличие целевого MAC адреса
-	exists, interfaceName := isTargetMACPresent(targetMAC, newInterfaces)
-	if exists {
-		summary.Success = true
-		summary.InterfaceName = interfaceName
-		printSuccess(fmt.Sprintf("SUCCESS: MAC %s found on interface %s", targetMAC, interfaceName))
+	return info, nil
+}
 
-		// Попытаемся восстановить IP адрес, если он был
-		if summary.OriginalIP != "" {
-			printInfo(fmt.Sprintf("Attempting to restore original IP address: %s", summary.OriginalIP))
-			if err := restoreIPAddress(interfaceName, summary.OriginalIP); err != nil {
-				printWarning(fmt.Sprintf("Failed to restore IP %s: %v", summary.OriginalIP, err))
-			} else {
-				printSuccess(fmt.Sprintf("IP address %s restored successfully", summary.OriginalIP))
-			}
+// evaluateIperf3Result проверяет пороги и формирует вывод теста вместо сырого JSON
+func evaluateIperf3Result(result *TestResult, info *NetworkResult, spec *Iperf3Spec, stdout []byte, stderr string) string {
+	measured, err := parseIperf3Result(stdout, *info)
+	if err != nil {
+		result.Status = "FAILED"
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "unable to connect") || strings.Contains(msg, "unable to send control message"):
+			result.Error = fmt.Sprintf("iperf3 server %s unreachable: %s", info.Server, msg)
+		case strings.Contains(msg, "failed to parse"):
+			result.Error = msg
+		default:
+			result.Error = "iperf3 error: " + msg
 		}
+		return result.Error + "\n" + stderr
+	}
+	result.Network = &measured
 
-		// Проверяем, что интерфейс активен
-		for _, iface := range newInterfaces {
-			if iface.Name == interfaceName {
-				if iface.State != "UP" {
-					printInfo(fmt.Sprintf("Bringing interface %s UP...", interfaceName))
-					cmd := exec.Command("ip", "link", "set", interfaceName, "up")
-					cmd.Run()
-				}
-				break
-			}
-		}
-	} else {
-		printError(fmt.Sprintf("FAILURE: Target MAC %s not found on any interface after flashing", targetMAC))
-
-		// Показываем текущие MAC адреса для отладки
-		printInfo("Current MAC addresses after flashing:")
-		for _, iface := range newInterfaces {
-			if iface.MAC != "" && iface.Name != "lo" {
-				driverType := "OTHER"
-				if isRealtekDriver(iface.Driver) {
-					driverType = "REALTEK"
-				}
-				printInfo(fmt.Sprintf("  [%s] %s: %s", driverType, iface.Name, iface.MAC))
-			}
-		}
+	var failures []string
+	minMbps := measured.Mbps
+	if measured.Direction == "bidir" && measured.ReverseMbps < minMbps {
+		minMbps = measured.ReverseMbps
+	}
+	if spec.MinMbps > 0 && minMbps < spec.MinMbps {
+		failures = append(failures, fmt.Sprintf("throughput %.1f Mbit/s below %.1f", minMbps, spec.MinMbps))
+	}
+	if spec.MaxRetransmits > 0 && measured.Retransmits > spec.MaxRetransmits {
+		failures = append(failures, fmt.Sprintf("%d retransmits exceed %d", measured.Retransmits, spec.MaxRetransmits))
+	}
+	if spec.MaxLossPercent > 0 && measured.LostPercent > spec.MaxLossPercent {
+		failures = append(failures, fmt.Sprintf("loss %.2f%% exceeds %.2f%%", measured.LostPercent, spec.MaxLossPercent))
+	}
+	if len(failures) > 0 {
+		result.Status = "FAILED"
+		result.Error = strings.Join(failures, "; ")
+	} else if result.Status == "FAILED" {
+		// Отчет разобрался, а iperf3 вернул ошибку - доверяем коду выхода
+		result.Error = "iperf3 exited with error: " + strings.TrimSpace(stderr)
+	}
 
-		action := askFlashRetryAction(fmt.Sprintf("Flashing completed but target MAC %s not found on any interface", targetMAC))
-		if action == "SKIP" {
-			summary.Success = false
-			summary.Error = "MAC not found after flashing - skipped by operator"
-			return nil
-		}
-		if action == "ABORT" {
-			summary.Success = false
-			summary.Error = "MAC not found after flashing - aborted by operator"
-			return fmt.Errorf("MAC not found after flashing - aborted by operator")
-		}
-		summary.Success = false
-		summary.Error = "MAC not found after flashing"
-		return fmt.Errorf("target MAC not found after flashing")
+	var out strings.Builder
+	fmt.Fprintf(&out, "iperf3 %s %s with %s", strings.ToUpper(measured.Protocol), measured.Direction, measured.Server)
+	if measured.Interface != "" {
+		fmt.Fprintf(&out, " via %s", measured.Interface)
+	}
+	out.WriteString("\n")
+	fmt.Fprintf(&out, "  Throughput  : %.1f Mbit/s", measured.Mbps)
+	if measured.Direction == "bidir" {
+		fmt.Fprintf(&out, " / %.1f Mbit/s reverse", measured.ReverseMbps)
+	}
+	if spec.MinMbps > 0 {
+		fmt.Fprintf(&out, " (min %.1f)", spec.MinMbps)
+	}
+	out.WriteString("\n")
+	if measured.Protocol == "udp" {
+		fmt.Fprintf(&out, "  Loss        : %.2f%%, jitter %.3f ms\n", measured.LostPercent, measured.JitterMs)
+	} else {
+		fmt.Fprintf(&out, "  Retransmits : %d\n", measured.Retransmits)
 	}
+	if result.Error != "" {
+		fmt.Fprintf(&out, "ERROR: %s\n", result.Error)
+	}
+	return out.String()
+}
 
-	return nil
+// recordAttempt добавляет последнюю попытку в историю результата.
+// После провала с log.sel_on_failure сразу забирает новые записи SEL
+func recordAttempt(result TestResult, history []TestAttempt) TestResult {
+	result.History = append(history, TestAttempt{
+		Number:   result.Attempts,
+		Status:   result.Status,
+		Started:  result.Started,
+		Duration: result.Duration,
+		Timeout:  result.Timeout,
+		Error:    result.Error,
+		Output:   result.Output,
+	})
+	if selOnFailure && (result.Status == "FAILED" || result.Status == "TIMEOUT") {
+		collectSELAfterFailure(result.Name)
+	}
+	return result
 }
 
-// Диагностическая функция для отладки модулей
-func debugLoadedModules() {
-	printInfo("=== Loaded Network Modules Debug ===")
+// timeoutRetryMultiplier и maxTestTimeout - увеличение таймаута повтора после TIMEOUT (tests.timeout_retry_multiplier, tests.max_timeout)
+var (
+	timeoutRetryMultiplier float64
+	maxTestTimeout         string
+)
 
-	cmd := exec.Command("lsmod")
-	output, err := cmd.Output()
-	if err != nil {
-		printError(fmt.Sprintf("Failed to run lsmod: %v", err))
-		return
-	}
+// maxParallelTests - предел одновременно выполняемых тестов параллельной группы (tests.max_parallel, 0 - без ограничения)
+var maxParallelTests int
 
-	lines := strings.Split(string(output), "\n")
-	printInfo("Network-related modules:")
+// resourceAliases - tests.resource_aliases
+var resourceAliases map[string]string
 
-	pgdrvFound := false
-	for _, line := range lines[1:] { // Skip header
-		if strings.Contains(line, "r8") ||
-			strings.Contains(line, "rtl") ||
-			strings.Contains(line, "8139") ||
-			strings.Contains(line, "igb") ||
-			strings.Contains(line, "e1000") ||
-			strings.Contains(line, "ixgbe") ||
-			strings.Contains(line, "i40e") ||
-			strings.Contains(line, "ice") ||
-			strings.Contains(line, "pgdrv") {
-			parts := strings.Fields(line)
-			if len(parts) >= 3 {
-				status := ""
-				if parts[0] == "pgdrv" {
-					status = " ← RTNICPG FLASHING DRIVER"
-					pgdrvFound = true
-				}
-				printInfo(fmt.Sprintf("  %s (used by %s, refs: %s)%s", parts[0], parts[2], parts[1], status))
-			}
+// testResources возвращает ресурсы теста после подстановки алиасов, без повторов и отсортированные
+func testResources(test TestSpec) []string {
+	if len(test.Resources) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var resources []string
+	for _, name := range test.Resources {
+		if alias, ok := resourceAliases[name]; ok {
+			name = alias
+		}
+		if !seen[name] {
+			seen[name] = true
+			resources = append(resources, name)
 		}
 	}
+	sort.Strings(resources)
+	return resources
+}
 
-	if pgdrvFound {
-		printSuccess("pgdrv module is currently loaded")
-	} else {
-		printInfo("pgdrv module is not loaded")
-	}
+// maxTestAttempts - предел попыток упавшего теста (tests.max_retries)
+var maxTestAttempts = 5
 
-	printInfo("=== End Module Debug ===")
+// cliTest - тест firestarter в runner.Test.Data: спецификация и история попыток для файлов тестов
+type cliTest struct {
+	spec    TestSpec
+	history []TestAttempt
 }
 
-// Функция для генерации имени файла драйвера
-func getDriverFileName(driverName, kernelVersion string) string {
-	return fmt.Sprintf("%s_%s.ko", driverName, kernelVersion)
+// executeCLITest - попытка теста для runner: шаблоны аргументов, iperf3, cgroup и SEL после провала
+func executeCLITest(ctx context.Context, run runner.Run) runner.Result {
+	test := run.Test.Data.(*cliTest)
+	if n := len(test.history); n > 0 && test.history[n-1].Status == "TIMEOUT" && run.Timeout > test.history[n-1].Timeout {
+		printInfo(fmt.Sprintf("Test '%s': timeout extended from %s to %s after TIMEOUT", test.spec.Name, test.history[n-1].Timeout, run.Timeout))
+	}
+	result, output := executeTest(ctx, test.spec, run.Timeout)
+	result.Attempts = run.Attempt
+	result.Output = output
+	result = recordAttempt(result, test.history)
+	test.history = result.History
+	return runnerResult(result)
 }
 
-// Функция для проверки существования скомпилированного драйвера
-func checkCompiledDriver(driverDir, driverName, kernelVersion string) (string, bool) {
-	driverFileName := getDriverFileName(driverName, kernelVersion)
-	driverPath := filepath.Join(driverDir, driverFileName)
+// runnerResult - TestResult в виде результата runner; полный TestResult едет в Data
+func runnerResult(result TestResult) runner.Result {
+	return runner.Result{
+		Name:        result.Name,
+		Description: result.Description,
+		Status:      result.Status,
+		Error:       result.Error,
+		Output:      result.Output,
+		Command:     result.Command,
+		Required:    result.Required,
+		Started:     result.Started,
+		Duration:    result.Duration,
+		Attempts:    result.Attempts,
+		Flappy:      result.Flappy,
+		Wait:        result.WaitDuration,
+		SkipReason:  result.SkipReason,
+		SkipDetail:  result.SkipDetail,
+		Data:        result,
+
+		Timeout:         result.Timeout,
+		TimeoutExtended: result.TimeoutExtended,
+	}
+}
 
-	if _, err := os.Stat(driverPath); err == nil {
-		printInfo(fmt.Sprintf("Found compiled driver: %s", driverPath))
-		return driverPath, true
+// testResultFrom - TestResult попытки с решениями runner (статус, число попыток, пропуск, ожидание ресурсов)
+func testResultFrom(r runner.Result) TestResult {
+	result, _ := r.Data.(TestResult)
+	result.Name = r.Name
+	result.Description = r.Description
+	result.Required = r.Required
+	result.Status = r.Status
+	result.Error = r.Error
+	result.Attempts = r.Attempts
+	result.Flappy = r.Flappy
+	result.SkipReason = r.SkipReason
+	result.SkipDetail = r.SkipDetail
+	result.WaitDuration = r.Wait
+	result.Timeout = r.Timeout
+	result.TimeoutExtended = r.TimeoutExtended
+	return result
+}
+
+// askRunnerAction - вопрос оператору по упавшему тесту; BLOCK (провал required теста блокирует прошивку) - принять провал
+func askRunnerAction(test runner.Test, _ runner.Result) runner.Action {
+	switch askTestAction(test.Data.(*cliTest).spec) {
+	case "RETRY":
+		return runner.ActionRetry
+	case "SKIP":
+		return runner.ActionSkip
+	default:
+		return runner.ActionContinue
 	}
+}
 
-	return "", false
+// cliSink выводит события runner в консоль оператора, публикует и сохраняет окончательные результаты
+type cliSink struct {
+	runner.NopSink
+	out  *OutputManager
+	peak int
 }
 
-// Функция для проверки исходников драйвера rtnicpg
-func checkRtnicpgSources(driverDir string) (string, bool) {
-	rtnicpgDir := filepath.Join(driverDir, "rtnicpg")
-	makefilePath := filepath.Join(rtnicpgDir, "Makefile")
+func (s *cliSink) AttemptStarted(_ string, test runner.Test, _ int) {
+	s.out.PrintResult(time.Now(), test.Name, "RUNNING", 0, "")
+}
 
-	// Проверяем существование папки rtnicpg
-	if _, err := os.Stat(rtnicpgDir); os.IsNotExist(err) {
-		return "", false
+func (s *cliSink) AttemptFinished(_ string, test runner.Test, result runner.Result) {
+	s.out.PrintResult(time.Now(), test.Name, result.Status, result.Duration, result.Error)
+	spec := test.Data.(*cliTest).spec
+	if result.Output != "" && !(result.Status == "PASSED" && spec.Collapse) {
+		s.out.PrintSection(test.Name+" Output", testDescription(spec), result.Output, result.Status)
 	}
+}
 
-	// Проверяем существование Makefile
-	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
-		return "", false
+func (s *cliSink) Retrying(_ string, test runner.Test, previous runner.Result, attempt int) {
+	// Показываем вывод предыдущего неудачного теста перед повтором
+	if previous.Output != "" {
+		fmt.Printf("%sPrevious test output:%s\n", ColorYellow, ColorReset)
+		s.out.PrintSection(test.Name+" Previous Output", testDescription(test.Data.(*cliTest).spec), previous.Output, previous.Status)
 	}
-
-	printInfo(fmt.Sprintf("Found rtnicpg sources: %s", rtnicpgDir))
-	return rtnicpgDir, true
+	fmt.Printf("%sRetrying test '%s' (attempt %d)...%s\n\n", ColorBlue, test.Name, attempt, ColorReset)
 }
 
-// Функция для проверки требований к сборке
-func checkBuildRequirements() error {
-	printInfo("Checking build requirements...")
+func (s *cliSink) MaxAttemptsReached(_ string, test runner.Test, maxAttempts int) {
+	fmt.Printf("%sMaximum retry attempts (%d) reached for test '%s'%s\n", ColorRed, maxAttempts, test.Name, ColorReset)
+}
 
-	// Проверяем наличие make
-	if _, err := exec.LookPath("make"); err != nil {
-		return fmt.Errorf("make not found - install build-essential package")
+func (s *cliSink) ParallelFinished(_ string, failed int) {
+	s.out.ClearStatus()
+	if failed > 0 {
+		fmt.Printf("\n%sParallel complete: %d failed test(s)%s\n", ColorYellow, failed, ColorReset)
+	} else {
+		fmt.Printf("\n%sAll parallel tests passed%s\n", ColorGreen, ColorReset)
 	}
+}
 
-	// Проверяем наличие компилятора
-	if _, err := exec.LookPath("gcc"); err != nil {
-		return fmt.Errorf("gcc not found - install build-essential package")
+func (s *cliSink) ReviewFailed(_ string, test runner.Test, result runner.Result, index, total int) {
+	if index > 1 {
+		fmt.Println()
 	}
+	fmt.Printf("%sProcessing failed test %d/%d: %s%s\n", ColorBlue, index, total, test.Name, ColorReset)
 
-	// Проверяем наличие заголовков ядра
-	kernelVersion, err := getKernelVersion()
-	if err != nil {
-		return fmt.Errorf("failed to get kernel version: %v", err)
+	// Всегда показываем причину и вывод перед retry/skip
+	fmt.Printf("  Status: %s%s%s\n", ColorRed, result.Status, ColorReset)
+	if result.Error != "" {
+		fmt.Printf("  Error : %s\n", result.Error)
 	}
-
-	kernelHeadersPath := fmt.Sprintf("/lib/modules/%s/build", kernelVersion)
-	if _, err := os.Stat(kernelHeadersPath); os.IsNotExist(err) {
-		return fmt.Errorf("kernel headers not found at %s - install linux-headers-%s package",
-			kernelHeadersPath, kernelVersion)
+	if result.Output != "" {
+		s.out.PrintSection(test.Name+" Output", testDescription(test.Data.(*cliTest).spec), result.Output, result.Status)
 	}
-
-	printSuccess("Build requirements check passed")
-	return nil
 }
 
-// Функция для диагностики сетевых интерфейсов и драйверов
-func debugNetworkInterfaces(interfaces []NetworkInterface) {
-	printInfo("=== Network Interface Debug Information ===")
+func (s *cliSink) TestFinished(group string, _ runner.Test, r runner.Result) {
+	result := testResultFrom(r)
+	if result.SkipReason == SkipOperator {
+		s.out.PrintResult(time.Now(), result.Name, result.Status, result.Duration, result.Error)
+	}
+	publishTestResult(result)
+	recordSessionState(group, result)
+}
 
-	for _, iface := range interfaces {
-		if iface.Name == "lo" {
-			continue // Skip loopback
-		}
+func (s *cliSink) GroupFinished(_ runner.Group, _ []runner.Result, stats runner.GroupStats) {
+	s.peak = stats.Peak
+}
 
-		// Получаем дополнительную информацию через разные методы
-		ethtoolDriver := getDriverViaEthtool(iface.Name)
-		sysfsDriver := getDriverViaSysfs(iface.Name)
+func runTestGroup(tests []TestSpec, parallel bool, outputMgr *OutputManager, groupName, globalTimeout string, maxParallel int) []TestResult {
+	// В компактном режиме группу показывает строка статуса
+	if outputMgr.compact == nil {
+		fmt.Printf("\n%s%s%s\n", ColorWhite, strings.ToUpper(groupName), ColorReset)
 
-		driverType := "UNKNOWN"
-		if iface.Driver != "" {
-			if isRealtekDriver(iface.Driver) {
-				driverType = "REALTEK"
-			} else if strings.Contains(strings.ToLower(iface.Driver), "intel") ||
-				iface.Driver == "igb" || iface.Driver == "e1000e" ||
-				iface.Driver == "ixgbe" || iface.Driver == "i40e" || iface.Driver == "ice" {
-				driverType = "INTEL"
-			} else {
-				driverType = "OTHER"
+		mode := "Sequential"
+		if parallel {
+			mode = "Parallel"
+			if maxParallel > 0 && maxParallel < len(tests) {
+				mode = fmt.Sprintf("Parallel (max %d)", maxParallel)
 			}
 		}
 
-		printInfo(fmt.Sprintf("Interface %s:", iface.Name))
-		printInfo(fmt.Sprintf("  Current Driver: %s [%s]", iface.Driver, driverType))
-		printInfo(fmt.Sprintf("  Ethtool Driver: %s", ethtoolDriver))
-		printInfo(fmt.Sprintf("  Sysfs Driver: %s", sysfsDriver))
-		printInfo(fmt.Sprintf("  MAC: %s", iface.MAC))
-		printInfo(fmt.Sprintf("  State: %s", iface.State))
-		printInfo(fmt.Sprintf("  IP: %s", iface.IP))
-		printInfo("---")
-	}
-
-	printInfo("=== End Debug Information ===")
-}
+		fmt.Printf("Mode: %s%s%s | Tests: %s%d%s | Timeout: %s%s%s\n",
+			ColorCyan, mode, ColorReset,
+			ColorGreen, len(tests), ColorReset,
+			ColorYellow, func() string {
+				if globalTimeout != "" {
+					return globalTimeout
+				}
+				return "30s (default)"
+			}(), ColorReset)
 
-// Получение драйвера через ethtool
-func getDriverViaEthtool(interfaceName string) string {
-	cmd := exec.Command("ethtool", "-i", interfaceName)
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Sprintf("ethtool_error: %v", err)
+		printSeparator()
 	}
-
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "driver:") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				return strings.TrimSpace(parts[1])
-			}
+	addPlannedTests(len(tests))
+	outputMgr.BeginGroup(groupName, len(tests))
+
+	// Выполнение - тем же runner, что доступен встраивающим программам
+	group := runner.Group{Name: groupName, Parallel: parallel, Timeout: globalTimeout, MaxParallel: maxParallel}
+	for _, test := range tests {
+		rt := runner.Test{
+			Name:        test.Name,
+			Description: test.Description,
+			Command:     test.Command,
+			Args:        test.Args,
+			Timeout:     test.Timeout,
+			Required:    test.Required,
+			Resources:   testResources(test),
+			Data:        &cliTest{spec: test},
+
+			TimeoutRetryMultiplier: test.TimeoutRetryMultiplier,
+			MaxTimeout:             test.MaxTimeout,
+		}
+		if r, ok := resumedTestResult(groupName, test.Name); ok {
+			done := runnerResult(r)
+			rt.Done = &done
+		} else if r, ok := deselectedTestResult(groupName, test); ok {
+			done := runnerResult(r)
+			rt.Done = &done
 		}
+		group.Tests = append(group.Tests, rt)
 	}
-	return "not_found"
-}
-
-// Получение драйвера через sysfs
-func getDriverViaSysfs(interfaceName string) string {
-	driverPath := fmt.Sprintf("/sys/class/net/%s/device/driver", interfaceName)
-	if link, err := os.Readlink(driverPath); err == nil {
-		return filepath.Base(link)
-	} else {
-		return fmt.Sprintf("sysfs_error: %v", err)
+	sink := &cliSink{out: outputMgr}
+	testRunner := runner.New(runner.Config{MaxRetries: maxTestAttempts, Groups: []runner.Group{group},
+		TimeoutRetryMultiplier: timeoutRetryMultiplier, MaxTimeout: maxTestTimeout},
+		runner.WithExecutor(runner.ExecutorFunc(executeCLITest)),
+		runner.WithPrompter(runner.PrompterFunc(askRunnerAction)),
+		runner.WithSink(sink))
+	runResults, _ := testRunner.Execute(context.Background())
+	results := make([]TestResult, len(runResults))
+	for i, r := range runResults {
+		results[i] = testResultFrom(r)
 	}
-}
+	peakParallel := sink.peak
 
-// Функция для сохранения скомпилированного драйвера
-func saveCompiledDriver(sourceDir, driverDir, driverName, kernelVersion string) (string, error) {
-	printInfo("Saving compiled driver...")
+	// Выводим сводку группы в enterprise стиле
+	outputMgr.ClearStatus()
+	fmt.Printf("\n%sGROUP RESULTS%s\n", ColorWhite, ColorReset)
+	printSeparator()
 
-	sourcePath := filepath.Join(sourceDir, "pgdrv.ko")
-	targetFileName := getDriverFileName(driverName, kernelVersion)
-	targetPath := filepath.Join(driverDir, targetFileName)
+	passed := 0
+	failed := 0
+	skipped := 0
 
-	// Создаем директорию для драйверов если она не существует
-	if err := os.MkdirAll(driverDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create driver directory %s: %v", driverDir, err)
-	}
+	var passedTests []string
+	var failedTests []string
+	var skippedTests []TestResult
 
-	// Копируем файл
-	sourceFile, err := os.Open(sourcePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to open source driver %s: %v", sourcePath, err)
+	for _, result := range results {
+		switch result.Status {
+		case "PASSED":
+			passed++
+			passedTests = append(passedTests, result.Name)
+		case "FAILED", "TIMEOUT":
+			failed++
+			failedTests = append(failedTests, result.Name)
+		case "SKIPPED":
+			skipped++
+			skippedTests = append(skippedTests, result)
+		}
 	}
-	defer sourceFile.Close()
 
-	targetFile, err := os.Create(targetPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create target driver %s: %v", targetPath, err)
+	// Определяем статус группы
+	groupStatus := "PASSED"
+	if failed > 0 {
+		groupStatus = "FAILED"
+	} else if skipped > 0 {
+		groupStatus = "PARTIAL"
 	}
-	defer targetFile.Close()
 
-	// Копируем содержимое
-	if _, err := sourceFile.WriteTo(targetFile); err != nil {
-		return "", fmt.Errorf("failed to copy driver content: %v", err)
+	// Выводим статистику
+	fmt.Printf("  %s%-20s%s: ", ColorWhite, groupName, ColorReset)
+	switch groupStatus {
+	case "PASSED":
+		fmt.Printf("%s PASSED %s", ColorBgGreen, ColorReset)
+	case "FAILED":
+		fmt.Printf("%s FAILED %s %s(%d of %d tests failed)%s",
+			ColorBgRed, ColorReset, ColorGray, failed, len(tests), ColorReset)
+	case "PARTIAL":
+		fmt.Printf("%s PARTIAL %s %s(%d passed, %d skipped)%s",
+			ColorBgYellow, ColorReset, ColorGray, passed, skipped, ColorReset)
 	}
+	fmt.Println()
 
-	// Устанавливаем права доступа
-	if err := os.Chmod(targetPath, 0644); err != nil {
-		printWarning(fmt.Sprintf("Failed to set permissions on %s: %v", targetPath, err))
+	// Выводим списки тестов
+	if len(passedTests) > 0 {
+		fmt.Printf("  %sPassed:%s %s\n", ColorGreen, ColorReset, strings.Join(passedTests, ", "))
 	}
-
-	printSuccess(fmt.Sprintf("Driver saved as: %s", targetPath))
-	return targetPath, nil
-}
-
-// Driver management functions
-func unloadNetworkDriver(driverName string) error {
-	if driverName == "" {
-		return fmt.Errorf("driver name is empty")
+	if len(failedTests) > 0 {
+		fmt.Printf("  %sFailed:%s %s\n", ColorRed, ColorReset, strings.Join(failedTests, ", "))
+	}
+	printSkippedTests("  ", skippedTests)
+	if parallel {
+		fmt.Printf("  %sPeak concurrency:%s %d of %d tests\n", ColorWhite, ColorReset, peakParallel, len(tests))
+		var waits []string
+		for i, result := range results {
+			if result.WaitDuration >= time.Second {
+				waits = append(waits, fmt.Sprintf("%s %s (%s)", result.Name, result.WaitDuration.Round(time.Second), strings.Join(testResources(tests[i]), ", ")))
+			}
+		}
+		if len(waits) > 0 {
+			fmt.Printf("  %sResource waits:%s %s\n", ColorWhite, ColorReset, strings.Join(waits, "; "))
+		}
 	}
 
-	printInfo(fmt.Sprintf("Unloading driver: %s", driverName))
-
-	// Сначала попробуем выгрузить по имени модуля
-	cmd := exec.Command("rmmod", driverName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// Если не получилось, попробуем форсированно
-		printWarning(fmt.Sprintf("Normal rmmod failed, trying force: %v", err))
-		cmd = exec.Command("rmmod", "-f", driverName)
-		output, err = cmd.CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("rmmod failed: %v\nOutput: %s", err, string(output))
+	if showResources {
+		fmt.Printf("  %sResources:%s\n", ColorWhite, ColorReset)
+		for _, result := range results {
+			if result.Resources == nil {
+				continue
+			}
+			fmt.Printf("    %-24s %s%s%s\n", result.Name, ColorGray, formatResourceUsage(result.Resources), ColorReset)
 		}
 	}
 
-	printSuccess(fmt.Sprintf("Driver %s unloaded successfully", driverName))
-	return nil
+	return results
 }
 
-func reloadIntelDrivers(drivers []string) {
-	for _, driver := range drivers {
-		if err := loadNetworkDriver(driver); err != nil {
-			printWarning(fmt.Sprintf("Failed to reload driver %s: %v", driver, err))
-		} else {
-			printSuccess(fmt.Sprintf("Driver %s reloaded successfully", driver))
-		}
-		time.Sleep(1 * time.Second) // Небольшая пауза между загрузкой драйверов
+// testGroupRef - группа тестов с идентификатором для ссылок из pipeline.order
+type testGroupRef struct {
+	ID       string // parallel1, sequential2, ...
+	Alias    string // group1, group2, ... в порядке выполнения по умолчанию
+	Name     string
+	Tests    []TestSpec
+	Parallel bool
+
+	Timeout       string // Таймаут группы (пусто - tests.timeout)
+	SkipCondition string
+	MaxParallel   int // Предел группы (0 - tests.max_parallel)
+}
+
+// groupName - имя группы из конфига или имя по умолчанию
+func groupName(group TestGroupSpec, fallback string) string {
+	if group.Name != "" {
+		return group.Name
 	}
+	return fallback
 }
 
-// Функция для загрузки стандартного сетевого драйвера (улучшенная версия)
-func loadNetworkDriver(driverName string) error {
-	if driverName == "" {
-		return fmt.Errorf("driver name is empty")
+// timeout - таймаут тестов группы без своего timeout
+func (g testGroupRef) timeout(globalTimeout string) string {
+	if g.Timeout != "" {
+		return g.Timeout
 	}
+	return globalTimeout
+}
 
-	printInfo(fmt.Sprintf("Loading driver: %s", driverName))
-	cmd := exec.Command("modprobe", driverName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("modprobe failed: %v\nOutput: %s", err, string(output))
+// maxParallel - предел одновременных тестов параллельной группы
+func (g testGroupRef) maxParallel() int {
+	if g.MaxParallel > 0 {
+		return g.MaxParallel
 	}
+	return maxParallelTests
+}
 
-	// Ждем загрузки драйвера с таймаутом
-	if err := waitForDriverLoad(driverName, 10); err != nil {
-		printWarning(fmt.Sprintf("Driver load verification timeout: %v", err))
-	} else {
-		printSuccess(fmt.Sprintf("Driver %s loaded successfully", driverName))
+// skipConditionTimeout - предел выполнения skip_condition группы
+const skipConditionTimeout = 30 * time.Second
+
+// groupSkipped выполняет skip_condition группы через sh -c: код 0 - группа пропускается.
+// Ошибка запуска или таймаут - группа выполняется (лишний прогон лучше пропущенной проверки)
+func groupSkipped(condition string) bool {
+	if condition == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), skipConditionTimeout)
+	defer cancel()
+	err := tracedRun(exec.CommandContext(ctx, "sh", "-c", condition))
+	if err == nil {
+		return true
+	}
+	var exitErr *exec.ExitError
+	if ctx.Err() != nil || !errors.As(err, &exitErr) {
+		printWarning(fmt.Sprintf("skip_condition %q failed: %v - group will run", condition, err))
 	}
+	return false
+}
 
-	return nil
+// skippedGroupResults - результаты тестов группы, пропущенной по skip_condition
+func skippedGroupResults(group testGroupRef) []TestResult {
+	printWarning(fmt.Sprintf("%s skipped: skip_condition %q exited 0", group.Name, group.SkipCondition))
+	addPlannedTests(len(group.Tests))
+	results := make([]TestResult, len(group.Tests))
+	for i, test := range group.Tests {
+		results[i] = skipTest(TestResult{
+			Name:        test.Name,
+			Description: test.Description,
+			Required:    test.Required,
+		}, SkipCondition, fmt.Sprintf("skip_condition %q exited 0", group.SkipCondition), "Skipped by group skip_condition")
+		publishTestResult(results[i])
+	}
+	return results
 }
 
-// Функция для получения версии текущего ядра
-func getKernelVersion() (string, error) {
-	cmd := exec.Command("uname", "-r")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", fmt.Errorf("failed to get kernel version: %v", err)
+// PipelineStep - один шаг плана выполнения
+type PipelineStep struct {
+	Kind   string // "tests" или "flash"
+	Target string // ID группы или операция; пусто - все
+}
+
+func (step PipelineStep) String() string {
+	if step.Target == "" {
+		return step.Kind
 	}
+	return step.Kind + ":" + step.Target
+}
 
-	version := strings.TrimSpace(string(output))
-	return version, nil
+// listTestGroups перечисляет группы тестов в порядке выполнения по умолчанию
+func listTestGroups(tests TestsConfig) []testGroupRef {
+	var groups []testGroupRef
+	for i, g := range tests.ParallelGroups {
+		groups = append(groups, testGroupRef{
+			ID:            fmt.Sprintf("parallel%d", i+1),
+			Name:          groupName(g, fmt.Sprintf("Parallel Group %d", i+1)),
+			Tests:         g.Tests,
+			Parallel:      true,
+			Timeout:       g.Timeout,
+			SkipCondition: g.SkipCondition,
+			MaxParallel:   g.MaxParallel,
+		})
+	}
+	for i, g := range tests.SequentialGroups {
+		groups = append(groups, testGroupRef{
+			ID:            fmt.Sprintf("sequential%d", i+1),
+			Name:          groupName(g, fmt.Sprintf("Sequential Group %d", i+1)),
+			Tests:         g.Tests,
+			Timeout:       g.Timeout,
+			SkipCondition: g.SkipCondition,
+		})
+	}
+	for i := range groups {
+		groups[i].Alias = fmt.Sprintf("group%d", i+1)
+	}
+	return groups
 }
 
-// Функция для подготовки pgdrv драйвера с проверкой начального состояния
-func preparePgdrvDriver(driverDir, originalDriver string, primaryInterface *NetworkInterface) (string, error) {
-	printInfo("Checking initial driver state...")
+// selectTestGroups возвращает группы для шага; пустой target - все группы
+func selectTestGroups(groups []testGroupRef, target string) []testGroupRef {
+	if target == "" {
+		return groups
+	}
+	for _, g := range groups {
+		if strings.EqualFold(g.ID, target) || strings.EqualFold(g.Alias, target) || strings.EqualFold(g.Name, target) {
+			return []testGroupRef{g}
+		}
+	}
+	return nil
+}
 
-	// Проверяем начальное состояние
-	pgdrvLoaded, realtekActive := checkInitialDriverState(primaryInterface)
+// deselectedTests - тесты, выключенные оператором в меню -interactive-tests (ключ sessionStateKey)
+var deselectedTests map[string]bool
 
-	printInfo(fmt.Sprintf("Initial state: pgdrv loaded=%t, realtek active=%t", pgdrvLoaded, realtekActive))
+// testSelectionItem - строка меню выбора тестов
+type testSelectionItem struct {
+	group string
+	test  TestSpec
+}
 
-	if pgdrvLoaded && !realtekActive {
-		// Случай 1: pgdrv уже загружен и нет конфликтующих Realtek драйверов
-		printSuccess("pgdrv already loaded and no conflicting Realtek drivers - ready for flashing")
-		return "pgdrv_already_loaded", nil
+// selectTestsInteractively показывает пронумерованный список тестов всех групп и переключает их до пустого ввода:
+// номера через пробел или запятую - переключить, a - включить все, n - выключить все.
+// Возвращает выключенные тесты; конфиг на диске не меняется
+func selectTestsInteractively(groups []testGroupRef, reader *bufio.Reader) map[string]bool {
+	var items []testSelectionItem
+	for _, g := range groups {
+		for _, test := range g.Tests {
+			items = append(items, testSelectionItem{group: g.Name, test: test})
+		}
+	}
+	disabled := make(map[string]bool)
+	if len(items) == 0 {
+		return disabled
 	}
 
-	if pgdrvLoaded && realtekActive {
-		// Случай 2: pgdrv загружен, но есть активный Realtek драйвер - конфликт
-		printWarning("pgdrv loaded but Realtek driver also active - resolving conflict")
+	for {
+		fmt.Printf("\n%sTEST SELECTION%s\n", ColorWhite, ColorReset)
+		printSeparator()
+		enabled := 0
+		group := ""
+		for i, item := range items {
+			if item.group != group {
+				group = item.group
+				fmt.Printf("  %s%s%s\n", ColorCyan, group, ColorReset)
+			}
+			mark := fmt.Sprintf("%s[x]%s", ColorGreen, ColorReset)
+			if disabled[sessionStateKey(item.group, item.test.Name)] {
+				mark = fmt.Sprintf("%s[ ]%s", ColorRed, ColorReset)
+			} else {
+				enabled++
+			}
+			required := ""
+			if item.test.Required {
+				required = fmt.Sprintf(" %s(required)%s", ColorYellow, ColorReset)
+			}
+			fmt.Printf("  %3d. %s %s%s\n", i+1, mark, item.test.Name, required)
+		}
+		fmt.Printf("%d of %d test(s) enabled. Numbers toggle, a = all, n = none, Enter = start: ", enabled, len(items))
 
-		// Выгружаем оба драйвера
-		if err := unloadPgdrvDriver(); err != nil {
-			printError(fmt.Sprintf("Failed to unload pgdrv: %v", err))
+		input, err := reader.ReadString('\n')
+		input = strings.TrimSpace(input)
+		if input == "" {
+			return disabled
 		}
-		if err := unloadNetworkDriver(primaryInterface.Driver); err != nil {
-			printError(fmt.Sprintf("Failed to unload Realtek driver %s: %v", primaryInterface.Driver, err))
+		switch strings.ToLower(input) {
+		case "a":
+			disabled = make(map[string]bool)
+		case "n":
+			for _, item := range items {
+				disabled[sessionStateKey(item.group, item.test.Name)] = true
+			}
+		default:
+			fields := strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+			for _, field := range fields {
+				n, convErr := strconv.Atoi(field)
+				if convErr != nil || n < 1 || n > len(items) {
+					printWarning(fmt.Sprintf("Invalid test number: %s (1-%d)", field, len(items)))
+					continue
+				}
+				key := sessionStateKey(items[n-1].group, items[n-1].test.Name)
+				if disabled[key] {
+					delete(disabled, key)
+				} else {
+					disabled[key] = true
+				}
+			}
 		}
-
-		printInfo("Both drivers unloaded, proceeding to load clean pgdrv...")
-	} else if !pgdrvLoaded && realtekActive {
-		// Случай 3: Стандартная ситуация - pgdrv не загружен, Realtek активен
-		printInfo("Standard case: unloading Realtek driver to load pgdrv")
-		if err := unloadNetworkDriver(primaryInterface.Driver); err != nil {
-			return "", fmt.Errorf("failed to unload Realtek driver %s: %v", primaryInterface.Driver, err)
+		if err != nil {
+			// stdin закрыт - подтверждать нечем, берем текущий выбор
+			return disabled
 		}
-	} else {
-		// Случай 4: Ни один драйвер не загружен
-		printInfo("No conflicting drivers found, proceeding to load pgdrv")
 	}
-
-	// Загружаем pgdrv драйвер
-	return loadFlashingDriver(driverDir, originalDriver)
 }
 
-// Заменяем функцию compileFlashingDriver на реальную реализацию
-func compileFlashingDriver(driverDir string, originalDriver string) (string, error) {
-	printInfo("Compiling rtnicpg driver from sources...")
+// skipSet - имена тестов, исключенных на этот запуск флагом skipSetFlag (-skip-tests или -only-tests)
+var skipSet map[string]bool
+var skipSetFlag string
 
-	// Проверяем наличие необходимых инструментов для компиляции
-	if err := checkBuildRequirements(); err != nil {
-		return "", fmt.Errorf("build requirements not met: %v", err)
-	}
+// tagExcluded - тесты групп, отсеченные фильтром по тегам (ключ sessionStateKey, см. TagSelection)
+var tagExcluded map[string]bool
 
-	// Ищем исходники rtnicpg
-	sourceDir, found := checkRtnicpgSources(driverDir)
-	if !found {
-		return "", fmt.Errorf("rtnicpg source directory not found in %s", driverDir)
+// buildTestSkipSet разбирает -skip-tests / -only-tests: имена сверяются со всеми тестами конфига,
+// -only-tests исключает все тесты не из списка
+func buildTestSkipSet(tests []TestSpec, only, skip string) (map[string]bool, error) {
+	if only != "" && skip != "" {
+		return nil, fmt.Errorf("-skip-tests and -only-tests cannot be used together")
 	}
-
-	// Сохраняем текущую директорию
-	originalDir, err := os.Getwd()
-	if err != nil {
-		return "", fmt.Errorf("failed to get current directory: %v", err)
+	list := only
+	if list == "" {
+		list = skip
 	}
 
-	// Переходим в директорию с исходниками
-	if err := os.Chdir(sourceDir); err != nil {
-		return "", fmt.Errorf("failed to change to source directory %s: %v", sourceDir, err)
+	known := make(map[string]bool)
+	for _, test := range tests {
+		known[test.Name] = true
 	}
-
-	// Восстанавливаем директорию при выходе
-	defer func() {
-		os.Chdir(originalDir)
-	}()
-
-	// Очищаем предыдущие артефакты сборки
-	printInfo("Cleaning previous build artifacts...")
-	cleanCmd := exec.Command("make", "clean")
-	cleanCmd.Dir = sourceDir
-	if output, err := cleanCmd.CombinedOutput(); err != nil {
-		printWarning(fmt.Sprintf("Clean failed (non-critical): %v\nOutput: %s", err, string(output)))
+	listed := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("test '%s' is not defined in the configuration", name)
+		}
+		listed[name] = true
+	}
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("no test names given")
+	}
+	if skip != "" {
+		return listed, nil
 	}
 
-	// Получаем версию ядра для переменной окружения
-	kernelVersion, err := getKernelVersion()
-	if err != nil {
-		return "", fmt.Errorf("failed to get kernel version: %v", err)
+	skipped := make(map[string]bool)
+	for name := range known {
+		if !listed[name] {
+			skipped[name] = true
+		}
 	}
+	return skipped, nil
+}
 
-	// Компилируем драйвер
-	printInfo("Building driver module...")
-	buildCmd := exec.Command("make", "all")
-	buildCmd.Dir = sourceDir
-	buildCmd.Env = append(os.Environ(),
-		"KERNELDIR=/lib/modules/"+kernelVersion+"/build",
-	)
+// deselectedTestResult возвращает SKIPPED для теста, выключенного в меню -interactive-tests,
+// исключенного -skip-tests/-only-tests (skipSet) или отсеченного фильтром по тегам (tagExcluded)
+func deselectedTestResult(groupName string, test TestSpec) (TestResult, bool) {
+	detail, message := "deselected in -interactive-tests menu", "Deselected by operator"
+	switch {
+	case skipSet[test.Name]:
+		detail, message = skipSetFlag, fmt.Sprintf("skipped via %s flag", skipSetFlag)
+	case tagExcluded[sessionStateKey(groupName, test.Name)]:
+		detail, message = "tag filter", "Excluded by tag filter"
+	case !deselectedTests[sessionStateKey(groupName, test.Name)]:
+		return TestResult{}, false
+	}
+	r := skipTest(TestResult{
+		Name:        test.Name,
+		Description: test.Description,
+		Required:    test.Required,
+	}, SkipOperator, detail, message)
+	printInfo(fmt.Sprintf("%s: %s - skipped", test.Name, strings.ToLower(message[:1])+message[1:]))
+	outputManager.CountResult(test.Name, r.Status)
+	publishTestResult(r)
+	recordSessionState(groupName, r)
+	return r, true
+}
 
-	output, err := buildCmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("compilation failed: %v\nOutput: %s", err, string(output))
+// buildExecutionPlan строит план из pipeline.order (по умолчанию tests -> flash) и проверяет ссылки
+// configuredOps - flash.operations из конфига до фильтра -flash-ops (шаги с отфильтрованными операциями пропускаются)
+func buildExecutionPlan(config Config, configuredOps []string, testsOnly, flashOnly bool) ([]PipelineStep, error) {
+	order := config.Pipeline.Order
+	if len(order) == 0 {
+		order = []string{"tests", "flash"}
 	}
 
-	// Проверяем, что файл pgdrv.ko был создан
-	compiledDriverPath := filepath.Join(sourceDir, "pgdrv.ko")
-	if _, err := os.Stat(compiledDriverPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("compilation succeeded but pgdrv.ko not found at %s", compiledDriverPath)
-	}
+	groups := listTestGroups(config.Tests)
+	var plan []PipelineStep
 
-	printSuccess("Driver compilation completed successfully")
+	for _, entry := range order {
+		kind, target, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		step := PipelineStep{Kind: strings.ToLower(kind), Target: strings.TrimSpace(target)}
 
-	// Сохраняем драйвер в папку драйверов
-	savedDriverPath, err := saveCompiledDriver(sourceDir, driverDir, originalDriver, kernelVersion)
-	if err != nil {
-		return "", fmt.Errorf("failed to save compiled driver: %v", err)
+		switch step.Kind {
+		case "tests":
+			if step.Target != "" && selectTestGroups(groups, step.Target) == nil {
+				return nil, fmt.Errorf("pipeline.order: unknown test group '%s'", step.Target)
+			}
+			if flashOnly {
+				continue
+			}
+		case "flash":
+			if step.Target != "" && !hasFlashOperation(FlashConfig{Enabled: true, Operations: configuredOps}, step.Target) {
+				return nil, fmt.Errorf("pipeline.order: flash operation '%s' is not listed in flash.operations", step.Target)
+			}
+			if testsOnly || !config.Flash.Enabled {
+				continue
+			}
+			if step.Target != "" && !hasFlashOperation(config.Flash, step.Target) {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("pipeline.order: unknown step '%s' (expected tests[:group] or flash[:operation])", entry)
+		}
+
+		plan = append(plan, step)
 	}
 
-	return savedDriverPath, nil
+	return plan, nil
 }
 
-// Функция для определения является ли драйвер Realtek'овским
-func isRealtekDriver(driverName string) bool {
-	realtekDrivers := []string{
-		"r8169",   // Realtek RTL8169/8110 PCI Gigabit Ethernet
-		"r8168",   // Realtek RTL8168 PCI Express Gigabit Ethernet
-		"rtl8169", // Alternative name
-		"rtl8168", // Alternative name
-		"r8125",   // Realtek RTL8125 2.5Gigabit Ethernet
-		"rtl8125", // Alternative name
-		"8139too", // Realtek RTL-8139 (legacy)
-		"8139cp",  // Realtek RTL-8139C+ (legacy)
-		"rtl8139", // Alternative name (legacy)
-		"r8152",   // Realtek RTL8152/RTL8153 USB Ethernet
-		"rtl8152", // Alternative name
-	}
+// planFormat - значение флага -print-plan: "-print-plan" - текст, "-print-plan=json" - JSON
+type planFormat string
 
-	driverLower := strings.ToLower(driverName)
-	for _, realtekDriver := range realtekDrivers {
-		if driverLower == realtekDriver {
-			return true
-		}
+func (f *planFormat) String() string { return string(*f) }
+
+func (f *planFormat) Set(value string) error {
+	switch value {
+	case "true", "text":
+		*f = "text"
+	case "json":
+		*f = "json"
+	case "false":
+		*f = ""
+	default:
+		return fmt.Errorf("unknown plan format %q (expected text or json)", value)
 	}
-	return false
+	return nil
 }
 
-// Функция для поиска Realtek интерфейса среди доступных (обновленная с диагностикой)
-func findRealtekInterface(interfaces []NetworkInterface) *NetworkInterface {
-	printInfo("Searching for Realtek interfaces...")
+// IsBoolFlag позволяет писать -print-plan без значения
+func (f *planFormat) IsBoolFlag() bool { return true }
+
+// PlanTest - тест в плане выполнения с итоговым таймаутом
+type PlanTest struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Command       string   `json:"command"`
+	Args          []string `json:"args"`
+	Timeout       string   `json:"timeout"`
+	TimeoutSource string   `json:"timeout_source"` // test, global или default
+	Required      bool     `json:"required"`
+	Collapse      bool     `json:"collapse"`
+	Resources     []string `json:"resources,omitempty"` // После подстановки алиасов
+	Steps         []string `json:"steps,omitempty"`     // Команды шагов составного теста
+	Skipped       string   `json:"skipped,omitempty"`   // Почему запуск запишет тест как SKIPPED (-skip-tests, skip_condition)
+}
 
-	var realtekInterfaces []*NetworkInterface
+// PlanGroup - группа тестов в плане
+type PlanGroup struct {
+	ID    string     `json:"id"`
+	Alias string     `json:"alias,omitempty"`
+	Name  string     `json:"name"`
+	Mode  string     `json:"mode"` // parallel или sequential
+	Tests []PlanTest `json:"tests"`
+
+	MaxParallel   int    `json:"max_parallel,omitempty"`   // max_parallel группы или tests.max_parallel для параллельной группы
+	SkipCondition string `json:"skip_condition,omitempty"` // При запуске проверяется заново, перед группой
+	Skipped       string `json:"skipped,omitempty"`        // skip_condition выполнен при построении плана и вернул 0
+}
 
-	// Собираем все Realtek интерфейсы
-	for i := range interfaces {
-		if interfaces[i].Driver != "" && isRealtekDriver(interfaces[i].Driver) {
-			realtekInterfaces = append(realtekInterfaces, &interfaces[i])
-			printInfo(fmt.Sprintf("Found Realtek interface: %s (Driver: %s, State: %s, IP: %s)",
-				interfaces[i].Name, interfaces[i].Driver, interfaces[i].State, interfaces[i].IP))
+// PlanFlashOperation - операция прошивки и что она изменяет
+type PlanFlashOperation struct {
+	Operation string   `json:"operation"`
+	Method    string   `json:"method,omitempty"`
+	Targets   []string `json:"targets"`
+
+	Drivers *DriverContext `json:"drivers,omitempty"` // mac: драйверы и утилита образа (только чтение modinfo/ethtool)
+}
+
+// PlanStep - шаг pipeline в плане
+type PlanStep struct {
+	Number         int                  `json:"number"`
+	Step           string               `json:"step"`
+	Groups         []PlanGroup          `json:"groups,omitempty"`
+	Flash          []PlanFlashOperation `json:"flash,omitempty"`
+	PostFlashTests *PlanGroup           `json:"post_flash_tests,omitempty"`
+}
+
+// PlanLog - куда попадут результаты сессии
+type PlanLog struct {
+	SaveLocal  bool   `json:"save_local"`
+	LogDir     string `json:"log_dir,omitempty"`
+	Transcript bool   `json:"transcript"`
+	HTMLReport bool   `json:"html_report"`
+	SendLogs   bool   `json:"send_logs"`
+	Server     string `json:"server,omitempty"`
+	RemoteDir  string `json:"remote_dir,omitempty"`
+}
+
+// ExecutionPlan - что выполнит запуск с данным конфигом и флагами (-print-plan)
+type ExecutionPlan struct {
+	Config           string      `json:"config"`
+	Product          string      `json:"product"`
+	Mode             string      `json:"mode"` // full, tests-only или flash-only
+	HardwareQueried  bool        `json:"hardware_queried"`
+	HardwareQueries  []string    `json:"hardware_queries,omitempty"` // Что выполнялось на системе при построении плана
+	Steps            []PlanStep  `json:"steps"`
+	PostRebootGroups []PlanGroup `json:"post_reboot_groups,omitempty"`
+	Log              PlanLog     `json:"log"`
+
+	Tags          *TagSelection `json:"tags,omitempty"`           // Фильтр по тегам (-tags, -exclude-tags, tests.default_tags)
+	TestGenerator string        `json:"test_generator,omitempty"` // tests.test_generator_command: его тесты добавляются при запуске сессии
+}
+
+func planTestGroup(group testGroupRef, globalTimeout string) PlanGroup {
+	mode := "sequential"
+	if group.Parallel {
+		mode = "parallel"
+	}
+	pg := PlanGroup{ID: group.ID, Alias: group.Alias, Name: group.Name, Mode: mode, Tests: []PlanTest{}}
+	if group.Parallel {
+		pg.MaxParallel = group.maxParallel()
+	}
+	pg.SkipCondition = group.SkipCondition
+	for _, test := range group.Tests {
+		timeout, source := effectiveTestTimeout(test, group.timeout(globalTimeout))
+		command := test.Command
+		if test.Type == "iperf3" && command == "" {
+			command = "iperf3 (built-in)"
 		}
-	}
+		args := test.Args
+		if args == nil {
+			args = []string{}
+		}
+		var steps []string
+		for _, step := range test.Steps {
+			steps = append(steps, step.commandLine())
+		}
+		pg.Tests = append(pg.Tests, PlanTest{
+			Steps:         steps,
+			Name:          test.Name,
+			Type:          test.Type,
+			Command:       command,
+			Args:          args,
+			Timeout:       timeout.String(),
+			TimeoutSource: source,
+			Required:      test.Required,
+			Resources:     testResources(test),
+			Collapse:      test.Collapse,
+		})
+	}
+	return pg
+}
 
-	if len(realtekInterfaces) == 0 {
-		printWarning("No Realtek interfaces found by driver name")
-		return nil
+// planSkipTests отмечает тесты, которые запуск с этими флагами запишет как SKIPPED (как deselectedTestResult).
+// Группы после перезагрузки выполняет -continue без флагов - к ним не применяется
+func planSkipTests(group *PlanGroup) {
+	for i := range group.Tests {
+		switch {
+		case skipSet[group.Tests[i].Name]:
+			group.Tests[i].Skipped = "skipped via " + skipSetFlag + " flag"
+		case tagExcluded[sessionStateKey(group.Name, group.Tests[i].Name)]:
+			group.Tests[i].Skipped = "excluded by tag filter"
+		}
 	}
+}
 
-	// Сначала ищем активный Realtek интерфейс с IP
-	for _, iface := range realtekInterfaces {
-		if iface.IP != "" && iface.State == "UP" {
-			printSuccess(fmt.Sprintf("Selected active Realtek interface with IP: %s", iface.Name))
-			return iface
+// planGroupSkipCondition выполняет skip_condition группы плана, как это сделает запуск перед группой
+func planGroupSkipCondition(report *ExecutionPlan, group *PlanGroup) {
+	if group.SkipCondition == "" || len(group.Tests) == 0 {
+		return
+	}
+	report.HardwareQueried = true
+	report.HardwareQueries = append(report.HardwareQueries, fmt.Sprintf("skip_condition of %s (sh -c)", group.ID))
+	if !groupSkipped(group.SkipCondition) {
+		return
+	}
+	group.Skipped = fmt.Sprintf("skip_condition %q exited 0", group.SkipCondition)
+	for i := range group.Tests {
+		if group.Tests[i].Skipped == "" {
+			group.Tests[i].Skipped = "group skip_condition"
 		}
 	}
+}
 
-	// Если не нашли активный с IP, ищем активный без IP
-	for _, iface := range realtekInterfaces {
-		if iface.State == "UP" {
-			printInfo(fmt.Sprintf("Selected active Realtek interface (no IP): %s", iface.Name))
-			return iface
+// flashOperationTargets описывает, что изменит операция прошивки
+func flashOperationTargets(operation string, config Config) []string {
+	fieldTargets := func(id string) []string {
+		var targets []string
+		for _, f := range config.Flash.Fields {
+			if f.Flash && f.ID == id {
+				targets = append(targets, fmt.Sprintf("field %s (%s)", f.ID, f.Name))
+			}
 		}
+		return targets
 	}
 
-	// Если не нашли активный, берем первый найденный
-	printWarning(fmt.Sprintf("Selected inactive Realtek interface: %s", realtekInterfaces[0].Name))
-	return realtekInterfaces[0]
+	var targets []string
+	switch operation {
+	case "serial", "fru":
+		targets = fieldTargets("system-serial-number")
+		if operation == "fru" {
+			targets = append(targets, fmt.Sprintf("FRU chip (blank size %d bytes)", config.Flash.FRUBlankSizeBytes))
+		}
+	case "mac":
+		targets = fieldTargets("mac_address")
+		if len(config.Flash.VenDevice) > 0 {
+			targets = append(targets, "NIC "+strings.Join(config.Flash.VenDevice, ", "))
+		}
+	case "nic-checksum":
+		target := "all Intel NICs"
+		if len(config.Flash.VenDevice) > 0 {
+			target = "NIC " + strings.Join(config.Flash.VenDevice, ", ")
+		}
+		targets = append(targets, fmt.Sprintf("EEPROM checksum of %s (eeupdate64e %s)", target, strings.Join(checksumFixArgs(config.Flash), " ")))
+	case "smbios":
+		targets = smbiosPlannedInvocations(config.Flash.SMBIOS, config.System)
+	case "efi":
+		for _, name := range []string{config.System.EfiSnName, config.System.EfiMacName} {
+			if name != "" {
+				targets = append(targets, fmt.Sprintf("EFI variable %s-%s", name, config.System.GuidPrefix))
+			}
+		}
+	}
+	if targets == nil {
+		targets = []string{}
+	}
+	return targets
 }
 
-// Flashing execution functions
-func executeRtnicFlashing(targetMAC string) error {
-	// Remove colons from MAC for rtnic
-	macWithoutColons := strings.ReplaceAll(targetMAC, ":", "")
+// buildPlanReport собирает план без запуска команд: все данные берутся из конфига
+func buildPlanReport(config Config, configPath string, steps []PipelineStep, testsOnly, flashOnly bool) ExecutionPlan {
+	report := ExecutionPlan{
+		Config:  configPath,
+		Product: config.System.Product,
+		Mode:    "full",
+		Steps:   []PlanStep{},
 
-	printInfo(fmt.Sprintf("Executing rtnic flashing for MAC: %s", targetMAC))
+		TestGenerator: config.Tests.TestGeneratorCommand,
+	}
+	if testsOnly {
+		report.Mode = "tests-only"
+	} else if flashOnly {
+		report.Mode = "flash-only"
+	}
 
-	// Execute rtnic with required arguments
-	cmd := exec.Command("rtnic", "/efuse", "/nicmac", "/nodeid", macWithoutColons)
-	output, err := cmd.CombinedOutput()
+	groups := listTestGroups(config.Tests)
+	lastFlashStep := -1
+	for i, step := range steps {
+		if step.Kind == "flash" {
+			lastFlashStep = i
+		}
+	}
 
-	if err != nil {
-		return fmt.Errorf("rtnic command failed: %v\nOutput: %s", err, string(output))
+	for i, step := range steps {
+		ps := PlanStep{Number: i + 1, Step: step.String()}
+		switch step.Kind {
+		case "tests":
+			for _, g := range selectTestGroups(groups, step.Target) {
+				pg := planTestGroup(g, config.Tests.Timeout)
+				planSkipTests(&pg)
+				if !groupTagExcluded(g) {
+					planGroupSkipCondition(&report, &pg)
+				}
+				ps.Groups = append(ps.Groups, pg)
+			}
+		case "flash":
+			ops := config.Flash.Operations
+			if step.Target != "" {
+				ops = []string{step.Target}
+			}
+			for _, op := range ops {
+				fo := PlanFlashOperation{Operation: op, Targets: flashOperationTargets(op, config)}
+				if op == "mac" {
+					fo.Method = config.Flash.Method
+					if fo.Method == "auto" {
+						fo.Method = "auto (detected from NIC driver at flash time)"
+					}
+				}
+				ps.Flash = append(ps.Flash, fo)
+			}
+			if i == lastFlashStep && len(config.Flash.PostFlashTests) > 0 {
+				group := planTestGroup(testGroupRef{ID: "post-flash", Name: "Post-flash verification", Tests: config.Flash.PostFlashTests}, config.Tests.Timeout)
+				planSkipTests(&group)
+				ps.PostFlashTests = &group
+			}
+		}
+		report.Steps = append(report.Steps, ps)
 	}
 
-	// Check if output indicates success
-	outputStr := string(output)
-	if strings.Contains(strings.ToLower(outputStr), "error") || strings.Contains(strings.ToLower(outputStr), "fail") {
-		return fmt.Errorf("rtnic reported error: %s", outputStr)
+	if !flashOnly {
+		for i, g := range config.Tests.PostRebootGroups {
+			report.PostRebootGroups = append(report.PostRebootGroups, planTestGroup(testGroupRef{
+				ID:    fmt.Sprintf("post-reboot%d", i+1),
+				Name:  fmt.Sprintf("Post-reboot Group %d", i+1),
+				Tests: g,
+			}, config.Tests.Timeout))
+		}
 	}
 
-	printSuccess("rtnic flashing command completed successfully")
-	return nil
+	report.Log = PlanLog{
+		SaveLocal:  config.Log.SaveLocal,
+		Transcript: config.Log.SaveTranscript,
+		HTMLReport: config.Log.HTMLReport,
+		SendLogs:   config.Log.SendLogs,
+	}
+	if config.Log.SaveLocal || config.Log.SaveTranscript || config.Log.HTMLReport {
+		report.Log.LogDir = config.Log.LogDir
+	}
+	if config.Log.SendLogs {
+		report.Log.Server = config.Log.Server
+		parts := []string{}
+		if config.Log.ServerDir != "" {
+			parts = append(parts, config.Log.ServerDir)
+		}
+		if config.Log.GroupByStation {
+			parts = append(parts, "<station>")
+		}
+		parts = append(parts, "<product>")
+		if config.Log.OpName != "" {
+			parts = append(parts, config.Log.OpName)
+		}
+		report.Log.RemoteDir = strings.Join(parts, "/")
+	}
+	return report
 }
 
-func restoreIPAddress(interfaceName, ipAddress string) error {
-	if interfaceName == "" || ipAddress == "" {
-		return fmt.Errorf("interface name or IP address is empty")
+func printPlanGroup(group PlanGroup, indent string) {
+	title := group.ID
+	if group.Alias != "" {
+		title += " / " + group.Alias
 	}
-
-	printInfo(fmt.Sprintf("Restoring IP %s to interface %s", ipAddress, interfaceName))
-
-	// First ensure interface is up
-	cmd := exec.Command("ip", "link", "set", interfaceName, "up")
-	cmd.Run()
-
-	time.Sleep(1 * time.Second)
-
-	// Assign IP address (assuming /24 subnet)
-	cmd = exec.Command("ip", "addr", "add", ipAddress+"/24", "dev", interfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		// IP might already be assigned, check if it's actually there
-		checkCmd := exec.Command("ip", "addr", "show", interfaceName)
-		checkOutput, _ := checkCmd.Output()
-		if strings.Contains(string(checkOutput), ipAddress) {
-			printSuccess(fmt.Sprintf("IP %s already assigned to %s", ipAddress, interfaceName))
-			return nil
+	mode := group.Mode
+	if group.MaxParallel > 0 {
+		mode = fmt.Sprintf("%s, max %d", mode, group.MaxParallel)
+	}
+	if group.SkipCondition != "" {
+		mode = fmt.Sprintf("%s, skip if: %s", mode, group.SkipCondition)
+	}
+	fmt.Printf("%s%s%s%s %s[%s]%s\n", indent, ColorWhite, title, ColorReset, ColorGray, mode, ColorReset)
+	if group.Skipped != "" {
+		fmt.Printf("%s  %sSKIPPED: %s%s\n", indent, ColorYellow, group.Skipped, ColorReset)
+	}
+	if len(group.Tests) == 0 {
+		fmt.Printf("%s  %s(no tests)%s\n", indent, ColorGray, ColorReset)
+	}
+	for i, test := range group.Tests {
+		flags := []string{"optional"}
+		if test.Required {
+			flags[0] = "required"
+		}
+		if test.Collapse {
+			flags = append(flags, "collapse")
+		}
+		if test.Skipped != "" {
+			flags = append(flags, "SKIPPED: "+test.Skipped)
+		}
+		fmt.Printf("%s  %d. %s%s%s %s(%s)%s\n", indent, i+1, ColorCyan, test.Name, ColorReset, ColorGray, strings.Join(flags, ", "), ColorReset)
+		if len(test.Steps) > 0 {
+			for j, step := range test.Steps {
+				fmt.Printf("%s     step %d  : %s\n", indent, j+1, step)
+			}
+		} else {
+			fmt.Printf("%s     command : %s\n", indent, strings.TrimSpace(test.Command+" "+strings.Join(test.Args, " ")))
+		}
+		fmt.Printf("%s     timeout : %s (%s)\n", indent, test.Timeout, test.TimeoutSource)
+		if len(test.Resources) > 0 {
+			fmt.Printf("%s     locks   : %s\n", indent, strings.Join(test.Resources, ", "))
 		}
-		return fmt.Errorf("failed to assign IP: %v\nOutput: %s", err, string(output))
 	}
-
-	printSuccess(fmt.Sprintf("IP %s restored to interface %s", ipAddress, interfaceName))
-	return nil
 }
 
-func runFlashing(config FlashConfig, flashData *FlashData, systemConfig SystemConfig) ([]FlashResult, bool) {
-	var results []FlashResult
-	var serialNumberChanged bool = false
-
-	if !config.Enabled {
-		return results, false
+// printExecutionPlan выводит план в виде текста или JSON (стабильный формат для сравнения в CI)
+func printExecutionPlan(report ExecutionPlan, format planFormat) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal plan: %v", err)
+		}
+		fmt.Println(string(data))
+		return nil
 	}
 
-	fmt.Println(strings.Repeat("-", 80))
-
-	// Логируем то, что будем прошивать
-	printInfo("Flashing operations summary:")
-	if flashData.SystemSerial != "" {
-		printInfo(fmt.Sprintf("  System Serial -> %s", flashData.SystemSerial))
+	fmt.Printf("\n%sEXECUTION PLAN%s\n", ColorWhite, ColorReset)
+	printThickSeparator()
+	fmt.Printf("  Configuration     : %s%s%s\n", ColorYellow, report.Config, ColorReset)
+	fmt.Printf("  Target Product    : %s%s%s\n", ColorCyan, report.Product, ColorReset)
+	fmt.Printf("  Mode              : %s\n", report.Mode)
+	if report.Tags != nil {
+		fmt.Printf("  Tag Filter        : %s\n", report.Tags.String())
 	}
-	if flashData.IOBoard != "" {
-		printInfo(fmt.Sprintf("  IO Board      -> %s", flashData.IOBoard))
+	if report.TestGenerator != "" {
+		fmt.Printf("  Test Generator    : %s %s(tests are added at session start)%s\n", report.TestGenerator, ColorGray, ColorReset)
 	}
-	if flashData.MAC != "" {
-		printInfo(fmt.Sprintf("  MAC Address   -> %s", flashData.MAC))
+	if len(report.HardwareQueries) > 0 {
+		fmt.Printf("  Hardware Queries  : %s%s%s\n", ColorGray, strings.Join(report.HardwareQueries, "; "), ColorReset)
+	} else {
+		fmt.Printf("  Hardware Queries  : %snone - plan is built from configuration only%s\n", ColorGray, ColorReset)
 	}
 
-	for _, operation := range config.Operations {
-		result := FlashResult{
-			Operation: operation,
-			Status:    "PASSED",
+	for _, step := range report.Steps {
+		fmt.Printf("\n%s[%d/%d] %s%s\n", ColorWhite, step.Number, len(report.Steps), step.Step, ColorReset)
+		printSeparator()
+		for _, g := range step.Groups {
+			printPlanGroup(g, "  ")
 		}
-
-		startTime := time.Now()
-
-		switch operation {
-		case "mac":
-			printInfo(fmt.Sprintf("Flashing MAC address: %s", flashData.MAC))
-			err := flashMAC(config, systemConfig, flashData.MAC)
-			if err != nil {
-				result.Status = "FAILED"
-				result.Details = fmt.Sprintf("MAC flash failed: %v", err)
+		for _, op := range step.Flash {
+			fmt.Printf("  %s%-6s%s", ColorYellow, op.Operation, ColorReset)
+			if op.Method != "" {
+				fmt.Printf(" method: %s |", op.Method)
 			}
-
-		case "efi":
-			printInfo("Updating EFI variables")
-			efiChanged, efiSerialChanged, err := updateEFIVariables(systemConfig, flashData)
-			if err != nil {
-				result.Status = "FAILED"
-				result.Details = fmt.Sprintf("EFI update failed: %v", err)
-			} else if !efiChanged {
-				result.Status = "SKIPPED"
-				result.Details = "All EFI variables already have correct values"
-			}
-
-			if efiSerialChanged {
-				serialNumberChanged = true
+			if len(op.Targets) > 0 {
+				fmt.Printf(" %s", strings.Join(op.Targets, "; "))
+			} else {
+				fmt.Printf(" %s(no targets configured)%s", ColorGray, ColorReset)
 			}
-
-		case "fru":
-			printInfo("Flashing FRU chip...")
-			if flashData.SystemSerial != "" {
-				fruSerialChanged, err := flashFRU(systemConfig, flashData.SystemSerial)
-				if err != nil {
-					result.Status = "FAILED"
-					result.Details = fmt.Sprintf("FRU flash failed: %v", err)
-				} else if !fruSerialChanged {
-					result.Status = "SKIPPED"
-					result.Details = "FRU already contains target serial number"
-				} else {
-					printSuccess("FRU chip flashed successfully")
-					serialNumberChanged = true
+			fmt.Println()
+			if op.Drivers != nil {
+				fmt.Printf("         %s%s%s\n", ColorGray, formatDriverContext(op.Drivers), ColorReset)
+				for _, iface := range op.Drivers.Before {
+					fmt.Printf("         %s%s: %s %s, firmware %s%s\n", ColorGray,
+						iface.Interface, iface.Driver, iface.Version, iface.FirmwareVersion, ColorReset)
 				}
-			} else {
-				result.Status = "FAILED"
-				result.Details = "No system serial number provided for FRU flashing"
 			}
 		}
-
-		result.Duration = time.Since(startTime)
-		results = append(results, result)
-
-		outputManager.PrintResult(time.Now(), operation, result.Status, result.Duration, result.Details)
+		if step.PostFlashTests != nil {
+			printPlanGroup(*step.PostFlashTests, "  ")
+		}
 	}
-
-	return results, serialNumberChanged
-}
-
-func validateEFISystem() error {
-	// Check if system supports EFI variables
-	if _, err := os.Stat("/sys/firmware/efi/efivars"); os.IsNotExist(err) {
-		return fmt.Errorf("EFI variables not supported on this system (efivars not found)")
+	if len(report.Steps) == 0 {
+		printWarning("Nothing would run with this configuration and flags")
 	}
 
-	// Try to create UEFI context
-	ctx := efivario.NewDefaultContext()
-	if ctx == nil {
-		return fmt.Errorf("failed to create UEFI context")
+	if len(report.PostRebootGroups) > 0 {
+		fmt.Printf("\n%sAFTER REBOOT (firestarter -continue)%s\n", ColorWhite, ColorReset)
+		printSeparator()
+		for _, g := range report.PostRebootGroups {
+			printPlanGroup(g, "  ")
+		}
 	}
 
-	printSuccess("EFI system validation passed")
+	fmt.Printf("\n%sLOG DESTINATIONS%s\n", ColorWhite, ColorReset)
+	printSeparator()
+	if report.Log.LogDir != "" {
+		fmt.Printf("  Local             : %s (save: %v, transcript: %v, html report: %v)\n",
+			report.Log.LogDir, report.Log.SaveLocal, report.Log.Transcript, report.Log.HTMLReport)
+	} else {
+		fmt.Printf("  Local             : %sdisabled%s\n", ColorGray, ColorReset)
+	}
+	if report.Log.SendLogs {
+		fmt.Printf("  Server            : %s:%s\n", report.Log.Server, report.Log.RemoteDir)
+	} else {
+		fmt.Printf("  Server            : %sdisabled%s\n", ColorGray, ColorReset)
+	}
+	fmt.Println()
 	return nil
 }
 
-func setEFIVariable(guidPrefix, varName, value string) error {
-	printInfo(fmt.Sprintf("Setting EFI variable %q to: %q", varName, value))
+// runTestsStep выполняет шаг тестирования по выбранным группам и печатает сводку
+func runTestsStep(testsConfig TestsConfig, groups []testGroupRef, label string) []TestResult {
+	var results []TestResult
 
-	// Проверка имени и содержимого переменной
-	if varName == "" || len(varName) > 1024 {
-		return fmt.Errorf("invalid variable name")
-	}
-	if len(value) == 0 || len(value) > 1024 {
-		return fmt.Errorf("invalid value length")
-	}
+	fmt.Printf("\n%sTESTING PHASE %s%s\n", ColorWhite, label, ColorReset)
+	printThickSeparator()
 
-	// Парсим GUID
-	varGUID, err := efiguid.FromString(guidPrefix)
-	if err != nil {
-		return fmt.Errorf("invalid GUID format '%s': %v", guidPrefix, err)
+	// Count tests
+	totalTests := 0
+	for _, g := range groups {
+		totalTests += len(g.Tests)
 	}
+	fmt.Printf("Total Tests: %s%d%s | Global Timeout: %s%s%s\n",
+		ColorGreen, totalTests, ColorReset,
+		ColorYellow, func() string {
+			if testsConfig.Timeout != "" {
+				return testsConfig.Timeout
+			}
+			return "30s (default)"
+		}(), ColorReset)
 
-	ctx := efivario.NewDefaultContext()
-	if ctx == nil {
-		return fmt.Errorf("failed to create UEFI context")
+	// Run tests
+	testsStart := time.Now()
+	for _, g := range groups {
+		sessionTimer.begin("tests: " + g.Name)
+		var groupResults []TestResult
+		if !groupTagExcluded(g) && groupSkipped(g.SkipCondition) {
+			groupResults = skippedGroupResults(g)
+		} else {
+			groupResults = runTestGroup(g.Tests, g.Parallel, outputManager, g.Name, g.timeout(testsConfig.Timeout), g.maxParallel())
+		}
+		for i := range groupResults {
+			groupResults[i].Group = g.ID
+		}
+		sessionTimer.setSlowest("tests: "+g.Name, groupResults)
+		results = append(results, groupResults...)
 	}
+	testsDuration := time.Since(testsStart)
 
-	const (
-		EFI_VARIABLE_NON_VOLATILE       = 0x00000001
-		EFI_VARIABLE_BOOTSERVICE_ACCESS = 0x00000002
-		EFI_VARIABLE_RUNTIME_ACCESS     = 0x00000004
-	)
-
-	attributes := efivario.Attributes(
-		EFI_VARIABLE_NON_VOLATILE |
-			EFI_VARIABLE_BOOTSERVICE_ACCESS |
-			EFI_VARIABLE_RUNTIME_ACCESS,
-	)
+	// Tests summary
+	printTestsSummary(results, testsDuration)
 
-	data := []byte(value)
+	// List failed tests by name
+	var failedNames []string
+	for _, r := range results {
+		if r.Status == "FAILED" || r.Status == "TIMEOUT" {
+			failedNames = append(failedNames, r.Name)
+		}
+	}
+	if len(failedNames) > 0 {
+		fmt.Printf("%sFailed tests:%s %s\n\n",
+			ColorRed, ColorReset, strings.Join(failedNames, ", "))
+	}
 
-	fmt.Printf("→ Writing EFI var: name=%q, guid=%s, len=%d, attrs=0x%X\n",
-		varName, varGUID.String(), len(data), uint32(attributes))
+	return results
+}
 
-	fmt.Printf("→ EFI var: data=%s\n",
-		data)
+// authenticateOperator запрашивает имя оператора (в -non-interactive берется op_name из конфига)
+// и проверяет его внешней командой. Ненулевой код возврата команды - отказ в доступе.
+func authenticateOperator(config LogConfig) (string, error) {
+	operator := config.OpName
 
-	err = ctx.Set(varName, varGUID, attributes, data)
-	if err != nil {
-		if strings.Contains(err.Error(), "invalid argument") {
-			printError("Hint: check if efivarfs is mounted as rw and that the data format is valid")
-			printError("Some firmware may also reject certain variable names or GUIDs")
+	if isInteractive() {
+		reader := bufio.NewReader(operatorInput())
+		fmt.Printf("\nOperator name %s[%s]%s: ", ColorGreen, operator, ColorReset)
+		stopWait := sessionTimer.operatorWait()
+		input, err := reader.ReadString('\n')
+		stopWait()
+		if err != nil && input == "" {
+			return "", fmt.Errorf("failed to read operator name: %v", err)
+		}
+		if input = strings.TrimSpace(input); input != "" {
+			operator = input
 		}
-		return fmt.Errorf("failed to set EFI variable %s: %v", varName, err)
 	}
 
-	// Проверка записи
-	readBuf := make([]byte, 1024)
-	readAttrs, n, err := ctx.Get(varName, varGUID, readBuf)
-	if err != nil {
-		printWarning(fmt.Sprintf("Variable %s was set but cannot be read back: %v", varName, err))
-	} else {
-		readData := readBuf[:n]
-		fmt.Printf("→ Read back EFI var: len=%d (written=%d)\n", n, len(data))
-		fmt.Printf("→ Attributes: 0x%X\n", uint32(readAttrs))
+	if operator == "" {
+		return "", fmt.Errorf("operator name is empty")
+	}
 
-		if bytes.Equal(readData, data) {
-			printSuccess(fmt.Sprintf("EFI variable %s verified value: %q (attrs: 0x%x)", varName, readData, readAttrs))
-		} else {
-			printWarning(fmt.Sprintf(
-				"EFI variable %s value mismatch:\n  expected (len %d): %q (hex: %X)\n       got (len %d): %q (hex: %X)",
-				varName, len(data), data, data, len(readData), readData, readData,
-			))
+	printInfo(fmt.Sprintf("Authenticating operator: %s", operator))
+	cmd := exec.Command(config.OperatorAuthCommand, operator)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := tracedRun(cmd); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			printError(msg)
 		}
+		recordAudit("operator_auth", operator, "FAILED", msg)
+		return operator, fmt.Errorf("operator %s not authorized: %v", operator, err)
 	}
 
-	return nil
+	recordAudit("operator_auth", operator, "PASSED", "")
+	auditSession.Operator = operator
+	printSuccess(fmt.Sprintf("Operator %s authenticated", operator))
+	return operator, nil
 }
 
-func testServerConnection(config LogConfig) error {
-	if !config.SendLogs || config.Server == "" {
-		return nil
+// confirmSecondOperator запрашивает бейдж второго оператора: он должен подходить под pattern
+// и отличаться от основного оператора
+func confirmSecondOperator(primary, pattern string) (string, error) {
+	var regex *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if regex, err = regexp.Compile(pattern); err != nil {
+			return "", fmt.Errorf("invalid operator_pattern: %v", err)
+		}
 	}
 
-	// Parse server (user@host format)
-	serverParts := strings.Split(config.Server, "@")
-	if len(serverParts) != 2 {
-		return fmt.Errorf("invalid server format, expected user@host: %s", config.Server)
-	}
-
-	user := serverParts[0]
-	host := serverParts[1]
-	serverAddr := fmt.Sprintf("%s@%s", user, host)
+	defer sessionTimer.operatorWait()()
+	reader := bufio.NewReader(operatorInput())
+	maxAttempts := 3
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		fmt.Printf("\n%sSecond operator confirmation required.%s Enter badge: ", ColorYellow, ColorReset)
+		input, err := reader.ReadString('\n')
+		if err != nil && input == "" {
+			return "", fmt.Errorf("failed to read badge: %v", err)
+		}
+		badge := strings.TrimSpace(input)
 
-	printInfo(fmt.Sprintf("Testing connection to server: %s", serverAddr))
+		switch {
+		case badge == "":
+			printError("Badge cannot be empty")
+		case regex != nil && !regex.MatchString(badge):
+			printError(fmt.Sprintf("Badge '%s' does not match operator pattern %s", badge, pattern))
+		case strings.EqualFold(badge, primary):
+			printError("Second operator must be different from the primary operator")
+		default:
+			printSuccess(fmt.Sprintf("Second operator %s confirmed", badge))
+			return badge, nil
+		}
+	}
 
-	// Test SSH connection
-	testCmd := exec.Command("ssh",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "ConnectTimeout=5",
-		"-o", "BatchMode=yes",
-		serverAddr,
-		"echo 'Connection test successful'")
+	return "", fmt.Errorf("second operator confirmation failed after %d attempts", maxAttempts)
+}
 
-	if output, err := testCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("server connection test failed: %v\nOutput: %s", err, string(output))
+// flashVariantIDs - отсортированные ключи variants по всем полям
+func flashVariantIDs(fields []FlashField) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, field := range fields {
+		for id := range field.Variants {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
 	}
+	sort.Strings(ids)
+	return ids
+}
 
-	printSuccess("Server connection test passed")
+// validateFlashVariants проверяет, что у всех полей с variants одинаковый набор вариантов и regex компилируются
+func validateFlashVariants(fields []FlashField) error {
+	ids := flashVariantIDs(fields)
+	for _, field := range fields {
+		if len(field.Variants) == 0 {
+			continue
+		}
+		for _, id := range ids {
+			v, ok := field.Variants[id]
+			if !ok {
+				return fmt.Errorf("flash.fields %s: variant %q is missing (all fields with variants must list %s)", field.ID, id, strings.Join(ids, ", "))
+			}
+			if _, err := regexp.Compile(v.Regex); err != nil {
+				return fmt.Errorf("flash.fields %s: variant %s: invalid regex: %v", field.ID, id, err)
+			}
+		}
+	}
 	return nil
 }
 
-func sendLogToServer(log SessionLog, config LogConfig) error {
-	if !config.SendLogs || config.Server == "" {
-		return nil
+// detectProductVariant определяет вариант изделия: вывод variant_command или SKU Number (dmidecode).
+// Значение сравнивается с ключами variants без учета регистра
+func detectProductVariant(command string, ids []string) (string, error) {
+	var output []byte
+	var err error
+	if command != "" {
+		output, err = tracedOutput(exec.Command("sh", "-c", command))
+	} else {
+		output, err = tracedOutput(exec.Command("dmidecode", "-s", "system-sku-number"))
+	}
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSpace(string(output))
+	for _, id := range ids {
+		if strings.EqualFold(id, value) {
+			return id, nil
+		}
 	}
+	if value == "" {
+		return "", fmt.Errorf("empty variant value")
+	}
+	return "", fmt.Errorf("%q is not a configured variant", value)
+}
 
-	printInfo(fmt.Sprintf("Sending log to server: %s", config.Server))
+// cloneFlashFields - глубокая копия полей: закрепление варианта не должно менять общий конфиг
+// (пакетный режим вызывает getFlashData для каждой строки CSV)
+func cloneFlashFields(fields []FlashField) []FlashField {
+	if fields == nil {
+		return nil
+	}
+	clone := make([]FlashField, len(fields))
+	for i, field := range fields {
+		clone[i] = field
+		if field.Variants != nil {
+			clone[i].Variants = make(map[string]FlashFieldVariant, len(field.Variants))
+			for id, v := range field.Variants {
+				clone[i].Variants[id] = v
+			}
+		}
+	}
+	return clone
+}
 
-	// Marshal to YAML
-	data, err := yaml.Marshal(log)
-	if err != nil {
-		return fmt.Errorf("failed to marshal log: %v", err)
+// pinFlashVariant заменяет формат полей с variants форматом выбранного варианта
+func pinFlashVariant(fields []FlashField, id string) {
+	for i := range fields {
+		if v, ok := fields[i].Variants[id]; ok {
+			fields[i].Regex = v.Regex
+			fields[i].Variants = nil
+		}
 	}
+}
 
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "system_validator_*.yaml")
-	if err != nil {
-		return fmt.Errorf("failed to create temp file: %v", err)
+// matchFlashField проверяет значение по формату поля. Для поля с незакрепленными variants
+// пробует все варианты и возвращает совпавший
+func matchFlashField(field FlashField, value string) (string, bool) {
+	if len(field.Variants) == 0 {
+		regex, err := regexp.Compile(field.Regex)
+		return "", err == nil && regex.MatchString(value)
+	}
+	ids := make([]string, 0, len(field.Variants))
+	for id := range field.Variants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if regex, err := regexp.Compile(field.Variants[id].Regex); err == nil && regex.MatchString(value) {
+			return id, true
+		}
 	}
-	defer os.Remove(tmpFile.Name())
+	return "", false
+}
 
-	_, err = tmpFile.Write(data)
-	if err != nil {
-		tmpFile.Close()
-		return fmt.Errorf("failed to write temp file: %v", err)
+// flashFieldFormat - формат поля для оператора: regex или regex каждого варианта с примером
+func flashFieldFormat(field FlashField) string {
+	if len(field.Variants) == 0 {
+		return field.Regex
+	}
+	var parts []string
+	for _, id := range flashVariantIDs([]FlashField{field}) {
+		v := field.Variants[id]
+		part := fmt.Sprintf("%s: %s", id, v.Regex)
+		if v.Example != "" {
+			part += fmt.Sprintf(", e.g. %s", v.Example)
+		}
+		parts = append(parts, part)
 	}
-	tmpFile.Close()
+	return "any variant - " + strings.Join(parts, " | ")
+}
 
-	// Generate remote filename with state
-	timestamp := log.Timestamp.Format("20060102_150405")
-	remoteFile := fmt.Sprintf("%s_%s_%s_%s.yaml", log.System.Product, log.System.MBSerial, timestamp, log.State)
+// readLineContext читает строку, пока не отменен ctx. Чтение в отдельной горутине:
+// после отмены она остается ждать stdin, но ее результат уже никому не нужен
+func readLineContext(ctx context.Context, reader *bufio.Reader) (string, error) {
+	type line struct {
+		text string
+		err  error
+	}
+	ch := make(chan line, 1)
+	go func() {
+		text, err := reader.ReadString('\n')
+		ch <- line{text, err}
+	}()
+	select {
+	case l := <-ch:
+		return l.text, l.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
 
-	// Build remote directory path
-	remoteDirParts := []string{}
-	if config.ServerDir != "" {
-		remoteDirParts = append(remoteDirParts, config.ServerDir)
+// preset - готовые значения полей (пакетный режим): ввод и экран подтверждения пропускаются
+func getFlashData(config FlashConfig, systemConfig SystemConfig, systemInfo SystemInfo, preset map[string]string) (*FlashData, error) {
+	productName := systemInfo.Product
+	if !config.Enabled || len(config.Fields) == 0 {
+		return nil, nil
 	}
-	if log.System.Product != "" {
-		remoteDirParts = append(remoteDirParts, log.System.Product)
+	config.Fields = cloneFlashFields(config.Fields)
+
+	if productName == "" {
+		return nil, fmt.Errorf("product name not detected")
 	}
-	if config.OpName != "" {
-		remoteDirParts = append(remoteDirParts, config.OpName)
+
+	printSectionHeader("FLASH DATA COLLECTION")
+	fmt.Printf("Product: %s%s%s\n", ColorGreen, productName, ColorReset)
+	fmt.Printf("Method: %s%s%s\n", ColorGreen, config.Method, ColorReset)
+	fmt.Printf("Operations: %s%s%s\n", ColorGreen, strings.Join(config.Operations, ", "), ColorReset)
+	if len(config.VenDevice) > 0 {
+		fmt.Printf("Target Devices: %s%s%s\n", ColorYellow, strings.Join(config.VenDevice, ", "), ColorReset)
 	}
 
-	var remoteDir string
-	if len(remoteDirParts) > 0 {
-		remoteDir = strings.Join(remoteDirParts, "/")
-	} else {
-		remoteDir = "."
+	// Вариант изделия выбирает формат полей с variants; не определен - подходит формат любого варианта
+	var variant *ProductVariant
+	if ids := flashVariantIDs(config.Fields); len(ids) > 0 {
+		if id, err := detectProductVariant(config.VariantCommand, ids); err != nil {
+			printWarning(fmt.Sprintf("Product variant not detected (%v) - accepting any of: %s", err, strings.Join(ids, ", ")))
+		} else {
+			variant = &ProductVariant{ID: id, Source: "detected"}
+			pinFlashVariant(config.Fields, id)
+			fmt.Printf("Variant: %s%s%s\n", ColorGreen, id, ColorReset)
+		}
 	}
 
-	// Parse server (user@host format)
-	serverParts := strings.Split(config.Server, "@")
-	if len(serverParts) != 2 {
-		return fmt.Errorf("invalid server format, expected user@host: %s", config.Server)
+	// Спрашиваем только поля, нужные выбранным операциям
+	var neededFields []FlashField
+	for _, field := range config.Fields {
+		if fieldNeeded(field, config.Operations) {
+			neededFields = append(neededFields, field)
+		} else {
+			fmt.Printf("  %s[SKIP]%s %s (not used by selected operations)\n", ColorGray, ColorReset, field.Name)
+		}
 	}
+	config.Fields = neededFields
 
-	user := serverParts[0]
-	host := serverParts[1]
-	serverAddr := fmt.Sprintf("%s@%s", user, host)
+	// Prepare fields that need flashing
+	requiredFields := make(map[string]*FlashField)
+	flashFields := make(map[string]*FlashField)
 
-	fmt.Printf("Remote: %s:%s/%s\n", serverAddr, remoteDir, remoteFile)
+	fmt.Printf("\nRequired fields:\n")
+	for i := range config.Fields {
+		field := &config.Fields[i]
+		if len(field.Variants) == 0 {
+			_, err := regexp.Compile(field.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex for field %s: %v", field.Name, err)
+			}
+		}
 
-	// Step 1: Create remote directories if they don't exist
-	if remoteDir != "." {
-		createCmd := fmt.Sprintf("mkdir -p \"%s\"", remoteDir)
-		cmd := exec.Command("ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "ConnectTimeout=10",
-			serverAddr, createCmd)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to create remote directory: %v", err)
+		requiredFields[field.ID] = field
+		if field.Flash {
+			flashFields[field.ID] = field
+			fmt.Printf("  %s[FLASH]%s %s (format: %s)\n", ColorYellow, ColorReset, field.Name, flashFieldFormat(*field))
+		} else {
+			fmt.Printf("  %s[STORE]%s %s (format: %s)\n", ColorBlue, ColorReset, field.Name, flashFieldFormat(*field))
 		}
 	}
 
-	// Step 2: Upload file
-	remoteFullPath := fmt.Sprintf("%s/%s", remoteDir, remoteFile)
-	scpTarget := fmt.Sprintf("%s:%s", serverAddr, remoteFullPath)
-
-	cmd := exec.Command("scp",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "ConnectTimeout=10",
-		tmpFile.Name(), scpTarget)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to upload file: %v\nOutput: %s", err, string(output))
+	// Первое совпадение по формату варианта закрепляет вариант для остальных полей
+	matchField := func(field *FlashField, value string) bool {
+		matched, ok := matchFlashField(*field, value)
+		if ok && matched != "" && variant == nil {
+			variant = &ProductVariant{ID: matched, Source: "matched"}
+			pinFlashVariant(config.Fields, matched)
+			printInfo(fmt.Sprintf("Product variant %s selected by the format of %s", matched, field.Name))
+		}
+		return ok
 	}
 
-	printSuccess("Log successfully sent to server")
-	return nil
-}
+	provided := make(map[string]string)
+	reader := bufio.NewReader(operatorInput())
 
-// getCurrentFRUSerial читает текущий серийный номер из FRU чипа
-func getCurrentFRUSerial() (string, error) {
-	cmd := exec.Command("ipmitool", "fru", "print", "0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", err
+	// Ввод ограничен flash.input_timeout целиком, а не каждой строкой
+	inputCtx, cancelInput := context.WithCancel(context.Background())
+	defer cancelInput()
+	var inputTimeout time.Duration
+	if config.InputTimeout != "" {
+		inputTimeout, _ = time.ParseDuration(config.InputTimeout) // Проверено в validateConfig
+		timer := time.AfterFunc(inputTimeout, cancelInput)
+		defer timer.Stop()
 	}
 
-	outputStr := string(output)
-	lines := strings.Split(outputStr, "\n")
+	// Пустые значения не прошиваются: операции, которым нужны поля, пропускаются в runFlashing
+	if preset == nil && !isInteractive() && len(requiredFields) > 0 {
+		printWarning("Non-interactive mode: flash data is not prompted, operations that need it will be SKIPPED")
+		return &FlashData{Variant: variant, Missing: noFlashDataReason}, nil
+	}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.HasPrefix(line, "Board Serial") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				serial := strings.TrimSpace(parts[1])
-				if serial == "" || serial == "Not Specified" || serial == "Unknown" {
-					return "", fmt.Errorf("no valid serial number found in FRU")
-				}
-				return serial, nil
+	if preset != nil {
+		for fieldID, field := range requiredFields {
+			value := strings.TrimSpace(preset[fieldID])
+			if value == "" {
+				return nil, fmt.Errorf("no value for field %s (%s)", field.Name, fieldID)
+			}
+			if !matchField(field, value) {
+				return nil, fmt.Errorf("value %q for field %s does not match %s", value, field.Name, flashFieldFormat(*field))
+			}
+			value, err := normalizeFieldValue(fieldID, value)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", field.Name, err)
 			}
+			provided[fieldID] = value
 		}
+	} else {
+		fmt.Printf("\nEnter values (program will auto-detect field type):\n")
 	}
 
-	return "", fmt.Errorf("Board Serial field not found in FRU data")
-}
-
-func checkFRUStatus() (*FRUStatus, error) {
-	printInfo("Checking FRU chip status...")
+	for len(provided) < len(requiredFields) {
+		fmt.Printf("\nRemaining fields: %d\n", len(requiredFields)-len(provided))
+		fmt.Printf("Enter value: ")
 
-	status := &FRUStatus{}
+		input, err := readLineContext(inputCtx, reader)
+		if inputCtx.Err() != nil {
+			fmt.Println()
+			return nil, fmt.Errorf("input timeout after %s: required fields not provided", inputTimeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+		input = strings.TrimSpace(input)
 
-	// Try to read FRU data using ipmitool
-	cmd := exec.Command("ipmitool", "fru", "print", "0")
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+		if input == "" {
+			fmt.Printf("%sInput cannot be empty. Please re-enter.%s\n", ColorRed, ColorReset)
+			continue
+		}
 
-	if err != nil {
-		printWarning(fmt.Sprintf("FRU read returned error: %v", err))
-		status.CanRead = false
-		status.ErrorMessage = err.Error()
+		matched := false
+		for fieldID, field := range requiredFields {
+			if _, ok := provided[fieldID]; ok {
+				continue
+			}
 
-		// Check specific error patterns that indicate FRU needs initialization
-		outputLower := strings.ToLower(outputStr)
+			if matchField(field, input) {
+				matched = true
+				value, err := normalizeFieldValue(fieldID, input)
+				if err != nil {
+					fmt.Printf("%s%s rejected: %v. Please re-enter.%s\n", ColorRed, field.Name, err, ColorReset)
+					break
+				}
+				provided[fieldID] = value
+				flashStatus := ""
+				if field.Flash {
+					flashStatus = fmt.Sprintf(" %s[WILL FLASH]%s", ColorYellow, ColorReset)
+				} else {
+					flashStatus = fmt.Sprintf(" %s[STORED ONLY]%s", ColorBlue, ColorReset)
+				}
+				fmt.Printf("%s%s accepted: %s%s%s\n", ColorGreen, field.Name, value, flashStatus, ColorReset)
+				break
+			}
+		}
 
-		if strings.Contains(outputLower, "unknown fru header version") {
-			status.IsEmpty = true
-			status.HasBadSum = true // Corrupted header also needs blank flash
-			printWarning("FRU has corrupted header (Unknown FRU header version) - needs initialization")
-		} else if strings.Contains(outputLower, "no fru data") ||
-			strings.Contains(outputLower, "invalid") ||
-			strings.Contains(outputLower, "empty") {
-			status.IsEmpty = true
-			printWarning("FRU appears to be empty")
-		} else if strings.Contains(outputLower, "checksum") ||
-			strings.Contains(outputLower, "badchecksum") {
-			status.HasBadSum = true
-			printWarning("FRU has bad checksum")
-		} else if strings.Contains(outputLower, "fru read failed") ||
-			strings.Contains(outputLower, "fru data checksum") {
-			status.HasBadSum = true
-			printWarning("FRU data corruption detected")
-		} else {
-			// For any other FRU read error, assume it needs reinitialization
-			status.IsEmpty = true
-			status.HasBadSum = true
-			printWarning(fmt.Sprintf("FRU read failed with unknown error - assuming corruption: %s", outputStr))
+		if !matched {
+			fmt.Printf("%sInput does not match any expected format. Please try again.%s\n", ColorRed, ColorReset)
 		}
-	} else {
-		status.CanRead = true
-		status.IsPresent = true
+	}
 
-		// Check if FRU has actual valid data
-		if strings.Contains(outputStr, "Board Mfg") ||
-			strings.Contains(outputStr, "Board Product") ||
-			strings.Contains(outputStr, "Board Serial") {
-			printSuccess("FRU contains valid data")
-		} else {
-			status.IsEmpty = true
-			printInfo("FRU is readable but appears empty")
+	var review *FlashReview
+	if preset != nil {
+		printFlashReview(config, systemConfig, systemInfo, provided)
+		review = &FlashReview{Confirmed: copyStringMap(provided), AutoConfirm: true, Timestamp: time.Now()}
+		review.TimestampOffset = sessionOffset(review.Timestamp)
+	} else {
+		// Подтверждение ограничено тем же flash.input_timeout, отсчет заново
+		reviewCtx, cancelReview := context.WithCancel(context.Background())
+		defer cancelReview()
+		if inputTimeout > 0 {
+			timer := time.AfterFunc(inputTimeout, cancelReview)
+			defer timer.Stop()
+		}
+		var err error
+		review, err = reviewFlashData(reviewCtx, inputTimeout, config, systemConfig, systemInfo, provided, reader)
+		if errors.Is(err, errFlashAborted) {
+			return &FlashData{Review: review}, err
+		}
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// Summary of status
-	if status.IsEmpty && status.HasBadSum {
-		printInfo("FRU Status: Corrupted/Empty - requires blank initialization")
-	} else if status.IsEmpty {
-		printInfo("FRU Status: Empty - requires initialization")
-	} else if status.HasBadSum {
-		printInfo("FRU Status: Bad checksum - requires reinitialization")
-	} else if status.CanRead {
-		printInfo("FRU Status: Valid data present")
+	flashData := buildFlashData(provided)
+	flashData.Review = review
+	flashData.Variant = variant
+
+	fmt.Printf("\n%sCollected data summary:%s\n", ColorGreen, ColorReset)
+	if flashData.SystemSerial != "" {
+		fmt.Printf("  System Serial: %s\n", flashData.SystemSerial)
+	}
+	if flashData.IOBoard != "" {
+		fmt.Printf("  IO Board: %s\n", flashData.IOBoard)
+	}
+	if flashData.MAC != "" {
+		fmt.Printf("  MAC Address: %s\n", flashData.MAC)
 	}
 
-	return status, nil
+	return flashData, nil
 }
 
-func createFRUBlankFile() (string, error) {
-	printInfo("Creating blank FRU file (2048 null bytes - equivalent to 'dd if=/dev/zero bs=2048 count=1')...")
-
-	tmpFile, err := os.CreateTemp("", "fru_blank_*.bin")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %v", err)
+// normalizeFieldValue проверяет значение поля сверх regex; MAC приводится к виду AA:BB:CC:DD:EE:FF
+func normalizeFieldValue(fieldID, value string) (string, error) {
+	if fieldID == "mac_address" {
+		return normalizeMAC(value)
 	}
-	defer tmpFile.Close()
+	return value, nil
+}
 
-	// Write 2048 null bytes (same as dd if=/dev/zero of=file bs=2048 count=1)
-	nullData := make([]byte, 2048)
-	bytesWritten, err := tmpFile.Write(nullData)
-	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("failed to write blank data: %v", err)
-	}
+// buildFlashData раскладывает введенные значения по полям FlashData
+func buildFlashData(provided map[string]string) *FlashData {
+	flashData := &FlashData{}
 
-	if bytesWritten != 2048 {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("wrote %d bytes, expected 2048", bytesWritten)
+	// Map fields to FlashData structure
+	for fieldID, value := range provided {
+		switch fieldID {
+		case "system-serial-number":
+			flashData.SystemSerial = value
+		case "io_board":
+			flashData.IOBoard = value
+		case "mac_address":
+			flashData.MAC = value
+		}
 	}
 
-	printSuccess(fmt.Sprintf("Blank FRU file created: %s (%d bytes)", tmpFile.Name(), bytesWritten))
-	return tmpFile.Name(), nil
+	return flashData
 }
 
-func flashFRUFile(filename string) error {
-	printInfo(fmt.Sprintf("Flashing FRU file: %s", filename))
-
-	// Use ipmitool to write FRU file
-	cmd := exec.Command("ipmitool", "fru", "write", "0", filename)
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
+// flashOperationFields - какие поля ввода нужны каждой операции прошивки
+var flashOperationFields = map[string][]string{
+	"serial": {"system-serial-number"},
+	"mac":    {"mac_address"},
+	"efi":    {"system-serial-number", "mac_address"},
+	"fru":    {"system-serial-number"},
+	"smbios": {"system-serial-number", "io_board", "mac_address"},
 
-	if err != nil {
-		return fmt.Errorf("FRU flash failed: %v\nOutput: %s", err, outputStr)
-	}
+	"nic-checksum": {},
+}
 
-	// Check for success indicators in output
-	if strings.Contains(strings.ToLower(outputStr), "success") ||
-		strings.Contains(strings.ToLower(outputStr), "written") ||
-		len(outputStr) == 0 { // Sometimes ipmitool outputs nothing on success
-		printSuccess("FRU file flashed successfully")
+// fieldConsumers возвращает операции прошивки из конфига, которые используют поле
+func fieldConsumers(field FlashField, operations []string) []string {
+	if !field.Flash {
 		return nil
 	}
 
-	// Check for error indicators
-	if strings.Contains(strings.ToLower(outputStr), "error") ||
-		strings.Contains(strings.ToLower(outputStr), "fail") {
-		return fmt.Errorf("FRU flash reported error: %s", outputStr)
+	var consumers []string
+	for _, op := range operations {
+		for _, id := range flashOperationFields[op] {
+			if id == field.ID {
+				consumers = append(consumers, op)
+			}
+		}
 	}
-
-	// If no clear indicators, assume success (some ipmitool versions are quiet)
-	printSuccess("FRU flash command completed")
-	return nil
+	return consumers
 }
 
-func generateFRUFile(systemConfig SystemConfig, serialNumber string) (string, error) {
-	printInfo("Generating FRU file with frugen...")
-
-	// Create temporary file for FRU output
-	tmpFile, err := os.CreateTemp("", "fru_generated_*.bin")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp file: %v", err)
+// fieldNeeded решает, спрашивать ли поле при выбранных операциях. Поля только для лога
+// и поля, не привязанные ни к одной операции, спрашиваются всегда.
+func fieldNeeded(field FlashField, operations []string) bool {
+	if !field.Flash {
+		return true
 	}
-	tmpFile.Close() // Close it so frugen can write to it
-
-	// Prepare frugen command
-	manufacturer := systemConfig.Manufacturer
-	if manufacturer == "" {
-		manufacturer = "Unknown" // fallback
+	for _, ids := range flashOperationFields {
+		for _, id := range ids {
+			if id == field.ID {
+				return len(fieldConsumers(field, operations)) > 0
+			}
+		}
 	}
+	return true
+}
 
-	product := systemConfig.Product
-	if product == "" {
-		product = "Unknown" // fallback
+// filterFlashOperations применяет -flash-ops / -skip-flash-ops к списку операций из конфига
+func filterFlashOperations(configured []string, only, skip string) ([]string, error) {
+	if only != "" && skip != "" {
+		return nil, fmt.Errorf("-flash-ops and -skip-flash-ops cannot be used together")
 	}
-
-	cmd := exec.Command("frugen",
-		"--board-mfg", manufacturer,
-		"--board-pname", product,
-		"--board-serial", serialNumber,
-		"--ascii",
-		tmpFile.Name())
-
-	printInfo(fmt.Sprintf("Executing: frugen --board-mfg \"%s\" --board-pname \"%s\" --board-serial \"%s\" --ascii %s",
-		manufacturer, product, serialNumber, tmpFile.Name()))
-
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
-
-	if err != nil {
-		os.Remove(tmpFile.Name())
-		return "", fmt.Errorf("frugen failed: %v\nOutput: %s", err, outputStr)
+	if only == "" && skip == "" {
+		return configured, nil
 	}
 
-	// Check if file was actually created
-	if _, err := os.Stat(tmpFile.Name()); os.IsNotExist(err) {
-		return "", fmt.Errorf("frugen did not create output file")
+	list := only
+	if list == "" {
+		list = skip
 	}
 
-	printSuccess(fmt.Sprintf("FRU file generated: %s", tmpFile.Name()))
-	if outputStr != "" {
-		printInfo(fmt.Sprintf("frugen output: %s", outputStr))
+	selected := make(map[string]bool)
+	for _, op := range strings.Split(list, ",") {
+		op = strings.ToLower(strings.TrimSpace(op))
+		if op == "" {
+			continue
+		}
+		if !hasFlashOperation(FlashConfig{Enabled: true, Operations: configured}, op) {
+			return nil, fmt.Errorf("flash operation '%s' is not listed in flash.operations (%s)", op, strings.Join(configured, ", "))
+		}
+		selected[op] = true
 	}
 
-	return tmpFile.Name(), nil
+	var effective []string
+	for _, op := range configured {
+		if selected[op] == (only != "") {
+			effective = append(effective, op)
+		}
+	}
+	if len(effective) == 0 {
+		return nil, fmt.Errorf("no flash operations left to run")
+	}
+	return effective, nil
 }
 
-func verifyFRUData(expectedManufacturer, expectedProduct, expectedSerial string) error {
-	printInfo("Verifying FRU data...")
-
-	// Wait a moment for FRU to be readable after flashing
-	time.Sleep(2 * time.Second)
+// TagSelection - фильтр по тегам на этот запуск и что он отсек.
+//
+// Порядок отбора: сначала -tests-only/-flash-only (фазы), затем -flash-ops/-skip-flash-ops (явный список
+// операций), затем теги: include (-tags, без него tests.default_tags), после него -exclude-tags.
+// Отсеченные тесты остаются в сессии как SKIPPED; тест, исключенный и -skip-tests/-only-tests, и тегами,
+// записывается с причиной -skip-tests/-only-tests. skip_condition группы проверяется при запуске, если в
+// группе остался хоть один тест, не отсеченный тегами. post_flash_tests и post_reboot_groups фильтром
+// не затрагиваются
+type TagSelection struct {
+	Include []string `yaml:"include,omitempty" json:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Source  string   `yaml:"source" json:"source"` // cli или config (tests.default_tags)
+
+	ExcludedTests    int      `yaml:"excluded_tests" json:"excluded_tests"`
+	ExcludedFlashOps []string `yaml:"excluded_flash_ops,omitempty" json:"excluded_flash_ops,omitempty"`
+}
 
-	cmd := exec.Command("ipmitool", "fru", "print", "0")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to read FRU for verification: %v", err)
+func (t *TagSelection) String() string {
+	var parts []string
+	if len(t.Include) > 0 {
+		parts = append(parts, "include "+strings.Join(t.Include, ","))
+	}
+	if len(t.Exclude) > 0 {
+		parts = append(parts, "exclude "+strings.Join(t.Exclude, ","))
 	}
+	text := fmt.Sprintf("%s (%s) - %d test(s) excluded", strings.Join(parts, ", "), t.Source, t.ExcludedTests)
+	if len(t.ExcludedFlashOps) > 0 {
+		text += ", flash operations excluded: " + strings.Join(t.ExcludedFlashOps, ", ")
+	}
+	return text
+}
 
-	outputStr := string(output)
-	lines := strings.Split(outputStr, "\n")
+// splitTags разбирает список тегов через запятую
+func splitTags(list string) []string {
+	var tags []string
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
 
-	var foundMfg, foundProduct, foundSerial string
+// tagsAllowed: при include нужен хотя бы один тег из списка, ни один тег не должен быть в exclude
+func tagsAllowed(tags, include, exclude []string) bool {
+	has := func(list []string) bool {
+		for _, tag := range tags {
+			for _, want := range list {
+				if strings.EqualFold(tag, want) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if len(include) > 0 && !has(include) {
+		return false
+	}
+	return !has(exclude)
+}
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+// applyTagFilter отбирает тесты групп и операции прошивки по фильтру; конфиг не меняется.
+// excluded - отсеченные тесты (ключ sessionStateKey), при запуске они записываются как SKIPPED.
+// Отсеченные операции попадают в selection.ExcludedFlashOps. unknown - теги фильтра, которые не
+// встречаются ни в одном тесте, группе или операции
+func applyTagFilter(config Config, selection *TagSelection) (excluded map[string]bool, unknown []string) {
+	defined := make(map[string]bool)
+	mark := func(tags []string) {
+		for _, tag := range tags {
+			defined[strings.ToLower(tag)] = true
+		}
+	}
 
-		if strings.HasPrefix(line, "Board Mfg") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				foundMfg = strings.TrimSpace(parts[1])
-			}
-		} else if strings.HasPrefix(line, "Board Product") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				foundProduct = strings.TrimSpace(parts[1])
+	excluded = make(map[string]bool)
+	specs := append(append([]TestGroupSpec{}, config.Tests.ParallelGroups...), config.Tests.SequentialGroups...)
+	for i, g := range listTestGroups(config.Tests) {
+		groupTags := specs[i].Tags
+		mark(groupTags)
+		for _, test := range g.Tests {
+			mark(test.Tags)
+			tags := test.Tags
+			if len(tags) == 0 {
+				tags = groupTags
 			}
-		} else if strings.HasPrefix(line, "Board Serial") {
-			parts := strings.SplitN(line, ":", 2)
-			if len(parts) == 2 {
-				foundSerial = strings.TrimSpace(parts[1])
+			if !tagsAllowed(tags, selection.Include, selection.Exclude) {
+				excluded[sessionStateKey(g.Name, test.Name)] = true
+				selection.ExcludedTests++
 			}
 		}
 	}
 
-	// Check each field
-	var errors []string
+	for _, op := range config.Flash.Operations {
+		tags := config.Flash.OperationTags[op]
+		mark(tags)
+		if len(tags) > 0 && !tagsAllowed(tags, selection.Include, selection.Exclude) {
+			selection.ExcludedFlashOps = append(selection.ExcludedFlashOps, op)
+		}
+	}
 
-	if foundMfg != expectedManufacturer {
-		errors = append(errors, fmt.Sprintf("Manufacturer mismatch: expected '%s', found '%s'", expectedManufacturer, foundMfg))
+	for _, tag := range append(append([]string{}, selection.Include...), selection.Exclude...) {
+		if !defined[tag] {
+			unknown = append(unknown, tag)
+		}
 	}
+	return excluded, unknown
+}
 
-	if foundProduct != expectedProduct {
-		errors = append(errors, fmt.Sprintf("Product mismatch: expected '%s', found '%s'", expectedProduct, foundProduct))
+// groupTagExcluded - все тесты группы отсечены фильтром по тегам; skip_condition такой группы не выполняется
+func groupTagExcluded(g testGroupRef) bool {
+	for _, test := range g.Tests {
+		if !tagExcluded[sessionStateKey(g.Name, test.Name)] {
+			return false
+		}
 	}
+	return len(g.Tests) > 0
+}
 
-	if foundSerial != expectedSerial {
-		errors = append(errors, fmt.Sprintf("Serial mismatch: expected '%s', found '%s'", expectedSerial, foundSerial))
+// onBoardValue собирает текущие значения поля на плате для сравнения перед прошивкой
+func onBoardValue(field FlashField, config FlashConfig, systemConfig SystemConfig, systemInfo SystemInfo) string {
+	var parts []string
+
+	switch field.ID {
+	case "system-serial-number":
+		if systemInfo.OriginalMBSerial != "" {
+			parts = append(parts, "DMI: "+systemInfo.OriginalMBSerial)
+		}
+		for _, op := range config.Operations {
+			switch op {
+			case "fru":
+				if serial, err := getCurrentFRUSerial(); err == nil {
+					parts = append(parts, "FRU: "+serial)
+				}
+			case "efi":
+				if systemConfig.EfiSnName != "" {
+					if manager, err := newEFIVarManager(systemConfig.GuidPrefix); err == nil {
+						if value, err := getEFIVariable(manager, systemConfig.EfiSnName, systemConfig.EFIVarEncoding); err == nil {
+							parts = append(parts, "EFI: "+value)
+						}
+					}
+				}
+			}
+		}
+	case "mac_address":
+		if len(systemInfo.OriginalMACs) > 0 {
+			parts = append(parts, strings.Join(systemInfo.OriginalMACs, ", "))
+		}
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("FRU verification failed:\n  - %s", strings.Join(errors, "\n  - "))
+	if len(parts) == 0 {
+		return "-"
 	}
+	return strings.Join(parts, " | ")
+}
 
-	printSuccess("FRU verification passed")
-	printInfo(fmt.Sprintf("  Manufacturer: %s", foundMfg))
-	printInfo(fmt.Sprintf("  Product: %s", foundProduct))
-	printInfo(fmt.Sprintf("  Serial: %s", foundSerial))
+// printFlashReview выводит экран подтверждения собранных данных
+func printFlashReview(config FlashConfig, systemConfig SystemConfig, systemInfo SystemInfo, provided map[string]string) {
+	printSubHeader("FLASH DATA REVIEW", "Verify scanned values before anything is written to hardware")
+	printSeparator()
 
-	return nil
-}
+	anyFlash := false
+	for i, field := range config.Fields {
+		consumers := fieldConsumers(field, config.Operations)
+		if len(consumers) > 0 {
+			anyFlash = true
+		}
 
-func askFRURetryAction(message string) string {
-	fmt.Printf("\n%s=== FRU FLASHING ERROR ===%s\n", ColorRed, ColorReset)
-	fmt.Printf("%s\n", message)
-	fmt.Println("Choose action:")
-	fmt.Printf("  %s[Y]%s Yes - Retry FRU flashing (default)\n", ColorGreen, ColorReset)
-	fmt.Printf("  %s[A]%s Abort - Stop FRU flashing and continue program\n", ColorYellow, ColorReset)
-	fmt.Printf("  %s[S]%s Skip - Skip FRU flashing by operator decision\n", ColorBlue, ColorReset)
-	fmt.Printf("Choice [Y/a/s]: ")
+		usage := fmt.Sprintf("%sstore only%s", ColorBlue, ColorReset)
+		if len(consumers) > 0 {
+			usage = fmt.Sprintf("%s%s%s", ColorYellow, strings.Join(consumers, ", "), ColorReset)
+		}
 
-	reader := bufio.NewReader(os.Stdin)
-	input, err := reader.ReadString('\n')
-	if err != nil {
-		return "RETRY" // default on error
+		fmt.Printf("  %d) %-20s: %s%s%s\n", i+1, field.Name, ColorCyan, provided[field.ID], ColorReset)
+		fmt.Printf("     %-20s: %s\n", "Used by", usage)
+		fmt.Printf("     %-20s: %s%s%s\n", "On board now", ColorGray,
+			onBoardValue(field, config, systemConfig, systemInfo), ColorReset)
 	}
+	printSeparator()
 
-	choice := strings.ToUpper(strings.TrimSpace(input))
-	if choice == "" {
-		choice = "Y" // default
+	if anyFlash {
+		fmt.Printf("%sWARNING: continuing will overwrite the on-board values listed above.%s\n", ColorRed, ColorReset)
+	} else {
+		fmt.Printf("%sNo field is marked for flashing - values will only be stored in the log.%s\n", ColorBlue, ColorReset)
 	}
+}
 
-	switch choice {
-	case "Y", "YES":
-		return "RETRY"
-	case "A", "ABORT":
-		return "ABORT"
-	case "S", "SKIP":
-		return "SKIP"
-	default:
-		fmt.Printf("Invalid choice '%s', defaulting to retry.\n", choice)
-		return "RETRY"
+// reviewFlashData показывает собранные данные и позволяет оператору подтвердить, исправить или отменить их
+func reviewFlashData(ctx context.Context, timeout time.Duration, config FlashConfig, systemConfig SystemConfig, systemInfo SystemInfo, provided map[string]string, reader *bufio.Reader) (*FlashReview, error) {
+	review := &FlashReview{}
+	readLine := func() (string, error) {
+		line, err := readLineContext(ctx, reader)
+		if ctx.Err() != nil {
+			fmt.Println()
+			return "", fmt.Errorf("input timeout after %s: flash data not confirmed", timeout)
+		}
+		return line, err
 	}
-}
 
-// Модифицированная функция updateEFIVariables с возвращением информации об изменениях серийного номера
-func updateEFIVariables(config SystemConfig, flashData *FlashData) (bool, bool, error) {
-	printInfo("Updating EFI variables...")
+	for {
+		printFlashReview(config, systemConfig, systemInfo, provided)
 
-	// Validate EFI system before proceeding
-	if err := validateEFISystem(); err != nil {
-		return false, false, fmt.Errorf("EFI system validation failed: %v", err)
-	}
+		if !isInteractive() {
+			printInfo("Non-interactive mode: continuing automatically")
+			review.AutoConfirm = true
+			break
+		}
 
-	anyChanges := false
-	serialChanged := false
+		fmt.Printf("Choose action: %s[C]%s Continue, %s[E]%s Edit <field #>, %s[A]%s Abort: ",
+			ColorGreen, ColorReset, ColorYellow, ColorReset, ColorRed, ColorReset)
+		input, err := readLine()
+		if err != nil {
+			return nil, err
+		}
 
-	// Update system serial number EFI variable
-	if flashData.SystemSerial != "" && config.EfiSnName != "" {
-		// Проверяем существующее значение
-		existingSerial, err := getEFIVariable(config.GuidPrefix, config.EfiSnName)
-		if err == nil && existingSerial == flashData.SystemSerial {
-			printInfo(fmt.Sprintf("EFI variable %s already contains target value: %s - skipping",
-				config.EfiSnName, flashData.SystemSerial))
-		} else {
-			if err == nil {
-				printInfo(fmt.Sprintf("EFI variable %s current value: %s, updating to: %s",
-					config.EfiSnName, existingSerial, flashData.SystemSerial))
+		// Пустой ввод (лишний Enter сканера) - не подтверждение: нужен явный выбор
+		parts := strings.Fields(strings.TrimSpace(input))
+		if len(parts) == 0 {
+			fmt.Printf("%sNo action chosen - type C, E or A%s\n", ColorRed, ColorReset)
+			continue
+		}
+		choice := strings.ToUpper(parts[0])
+
+		switch choice {
+		case "C", "CONTINUE":
+			review.Confirmed = copyStringMap(provided)
+			review.Timestamp = time.Now()
+			review.TimestampOffset = sessionOffset(review.Timestamp)
+			return review, nil
+		case "A", "ABORT":
+			review.Confirmed = copyStringMap(provided)
+			review.Aborted = true
+			review.Timestamp = time.Now()
+			review.TimestampOffset = sessionOffset(review.Timestamp)
+			return review, errFlashAborted
+		case "E", "EDIT":
+			selector := ""
+			if len(parts) > 1 {
+				selector = strings.Join(parts[1:], " ")
 			} else {
-				printInfo(fmt.Sprintf("EFI variable %s does not exist, creating with value: %s",
-					config.EfiSnName, flashData.SystemSerial))
+				fmt.Printf("Field number to edit: ")
+				line, err := readLine()
+				if err != nil {
+					return nil, err
+				}
+				selector = strings.TrimSpace(line)
 			}
 
-			err := setEFIVariable(config.GuidPrefix, config.EfiSnName, flashData.SystemSerial)
+			field := findReviewField(config.Fields, selector)
+			if field == nil {
+				fmt.Printf("%sUnknown field '%s'%s\n", ColorRed, selector, ColorReset)
+				continue
+			}
+
+			edit, err := editFlashField(*field, provided[field.ID], readLine)
 			if err != nil {
-				return false, false, fmt.Errorf("failed to set serial EFI variable: %v", err)
+				return nil, err
 			}
-			anyChanges = true
-			serialChanged = true // Серийный номер изменился!
+			if edit != nil {
+				provided[field.ID] = edit.NewValue
+				review.Edits = append(review.Edits, *edit)
+			}
+		default:
+			fmt.Printf("%sInvalid choice '%s'%s\n", ColorRed, choice, ColorReset)
 		}
 	}
 
-	// Update MAC address EFI variable
-	if flashData.MAC != "" && config.EfiMacName != "" {
-		// Convert MAC to the format expected by EFI (remove colons, uppercase)
-		hexMAC := strings.ReplaceAll(strings.ToUpper(flashData.MAC), ":", "")
-
-		// Проверяем существующее значение
-		existingMAC, err := getEFIVariable(config.GuidPrefix, config.EfiMacName)
-		if err == nil && existingMAC == hexMAC {
-			printInfo(fmt.Sprintf("EFI variable %s already contains target value: %s (MAC: %s) - skipping",
-				config.EfiMacName, hexMAC, flashData.MAC))
-		} else {
-			if err == nil {
-				printInfo(fmt.Sprintf("EFI variable %s current value: %s, updating to: %s (MAC: %s)",
-					config.EfiMacName, existingMAC, hexMAC, flashData.MAC))
-			} else {
-				printInfo(fmt.Sprintf("EFI variable %s does not exist, creating with value: %s (MAC: %s)",
-					config.EfiMacName, hexMAC, flashData.MAC))
-			}
+	review.Confirmed = copyStringMap(provided)
+	review.Timestamp = time.Now()
+	review.TimestampOffset = sessionOffset(review.Timestamp)
+	return review, nil
+}
 
-			err := setEFIVariable(config.GuidPrefix, config.EfiMacName, hexMAC)
-			if err != nil {
-				return false, false, fmt.Errorf("failed to set MAC EFI variable: %v", err)
-			}
-			anyChanges = true
-			// MAC не требует перезагрузки, serialChanged остается прежним
+// findReviewField ищет поле по номеру на экране, ID или имени
+func findReviewField(fields []FlashField, selector string) *FlashField {
+	if n, err := strconv.Atoi(selector); err == nil && n >= 1 && n <= len(fields) {
+		return &fields[n-1]
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].ID, selector) || strings.EqualFold(fields[i].Name, selector) {
+			return &fields[i]
 		}
 	}
+	return nil
+}
 
-	if anyChanges {
-		printSuccess("EFI variables updated successfully")
-	} else {
-		printSuccess("All EFI variables already have correct values - no changes needed")
+// editFlashField повторно запрашивает значение одного поля с проверкой по его regex
+func editFlashField(field FlashField, current string, readLine func() (string, error)) (*FlashFieldEdit, error) {
+	regex, err := regexp.Compile(field.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex for field %s: %v", field.Name, err)
 	}
 
-	return anyChanges, serialChanged, nil
-}
+	for {
+		fmt.Printf("New value for %s (format: %s, empty to keep '%s'): ", field.Name, field.Regex, current)
+		input, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		input = strings.TrimSpace(input)
 
-// Модифицированная функция flashFRU с возвращением информации об изменении серийного номера
-func flashFRU(systemConfig SystemConfig, serialNumber string) (bool, error) {
-	// Проверяем существующий серийный номер в FRU (НЕ в dmidecode!)
-	currentSerial, err := getCurrentFRUSerial()
-	if err == nil && currentSerial == serialNumber {
-		printInfo(fmt.Sprintf("FRU already contains target serial number: %s - skipping FRU flashing", serialNumber))
-		return false, nil // Серийный номер не изменился
-	}
+		if input == "" || input == current {
+			return nil, nil
+		}
+		if !regex.MatchString(input) {
+			fmt.Printf("%sValue does not match %s. Please try again.%s\n", ColorRed, field.Regex, ColorReset)
+			continue
+		}
+		if input, err = normalizeFieldValue(field.ID, input); err != nil {
+			fmt.Printf("%s%v. Please try again.%s\n", ColorRed, err, ColorReset)
+			continue
+		}
+		if input == current {
+			return nil, nil
+		}
 
-	if err == nil {
-		printInfo(fmt.Sprintf("Current FRU serial: %s, updating to: %s", currentSerial, serialNumber))
-	} else {
-		printInfo(fmt.Sprintf("Could not read current FRU serial (%v), proceeding with FRU flash to: %s", err, serialNumber))
+		fmt.Printf("%s%s changed: %s -> %s%s\n", ColorGreen, field.Name, current, input, ColorReset)
+		now := time.Now()
+		return &FlashFieldEdit{
+			Field:           field.ID,
+			OldValue:        current,
+			NewValue:        input,
+			Timestamp:       now,
+			TimestampOffset: sessionOffset(now),
+		}, nil
 	}
+}
 
-	printSubHeader("FRU CHIP FLASHING", fmt.Sprintf("Target Serial: %s | Manufacturer: %s", serialNumber, systemConfig.Manufacturer))
+func copyStringMap(m map[string]string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
 
-	// Step 1: Check current FRU status
-	status, err := checkFRUStatus()
+// captureNICInventory собирает физические порты (с device в sysfs): драйвер, постоянный MAC, линк и скорость
+func captureNICInventory(cmdRunner CommandRunner) ([]NICPort, error) {
+	interfaces, err := readNetworkInterfaces(cmdRunner)
 	if err != nil {
-		return false, fmt.Errorf("failed to check FRU status: %v", err)
+		return nil, err
 	}
-
-	// Step 2: If FRU has bad checksum or is empty, flash blank first
-	needsBlankFlash := status.HasBadSum || status.IsEmpty || !status.CanRead
-
-	if needsBlankFlash {
-		if status.HasBadSum && status.IsEmpty {
-			printInfo("FRU has corrupted header - initializing with blank data...")
-		} else if status.HasBadSum {
-			printInfo("FRU has bad checksum - clearing with blank data...")
-		} else if status.IsEmpty {
-			printInfo("FRU is empty - initializing with blank data...")
-		} else {
-			printInfo("FRU is unreadable - clearing with blank data...")
+	var ports []NICPort
+	for _, iface := range interfaces {
+		base := filepath.Join("/sys/class/net", iface.Name)
+		if _, err := cmdRunner.Readlink(filepath.Join(base, "device")); err != nil {
+			continue // lo, мосты, veth
 		}
-
-		blankFile, err := createFRUBlankFile()
-		if err != nil {
-			return false, fmt.Errorf("failed to create blank FRU file: %v", err)
+		port := NICPort{Name: iface.Name, MAC: iface.MAC, Driver: iface.Driver}
+		if output, err := cmdRunner.Run("ethtool", "-P", iface.Name); err == nil {
+			port.PermMAC = parseEthtoolPermAddr(string(output))
 		}
-		defer os.Remove(blankFile)
-
-		printInfo("Flashing 2048-byte null file to clear FRU...")
-		if err := flashFRUFile(blankFile); err != nil {
-			return false, fmt.Errorf("failed to flash blank FRU: %v", err)
+		if output, err := cmdRunner.Run("ethtool", iface.Name); err == nil {
+			port.Link, port.SpeedMbps = parseEthtoolLink(string(output))
+		} else {
+			// Без ethtool - carrier и speed из sysfs
+			port.Link = readSysfsValue(filepath.Join(base, "carrier")) == "1"
+			if speed, err := strconv.Atoi(readSysfsValue(filepath.Join(base, "speed"))); err == nil && speed > 0 {
+				port.SpeedMbps = speed
+			}
 		}
-
-		printSuccess("Blank FRU flash completed")
-
-		// Wait for FRU to be ready after blank flash
-		printInfo("Waiting for FRU to stabilize...")
-		time.Sleep(3 * time.Second)
+		ports = append(ports, port)
 	}
+	return ports, nil
+}
 
-	// Step 3: Generate and flash FRU with retries
-	attempts := 0
-	maxAttempts := 3
-	var lastError error
-
-	for attempts < maxAttempts {
-		attempts++
-		printInfo(fmt.Sprintf("FRU generation and flashing attempt %d/%d...", attempts, maxAttempts))
-
-		// Generate FRU file
-		fruFile, err := generateFRUFile(systemConfig, serialNumber)
-		if err != nil {
-			lastError = fmt.Errorf("FRU generation failed: %v", err)
-			printError(lastError.Error())
-		} else {
-			defer os.Remove(fruFile)
-
-			// Flash FRU file
-			if err := flashFRUFile(fruFile); err != nil {
-				lastError = fmt.Errorf("FRU flashing failed: %v", err)
-				printError(lastError.Error())
-			} else {
-				// Verify FRU data
-				if err := verifyFRUData(systemConfig.Manufacturer, systemConfig.Product, serialNumber); err != nil {
-					lastError = fmt.Errorf("FRU verification failed: %v", err)
-					printError(lastError.Error())
-				} else {
-					// Success!
-					printSuccess("FRU flashing completed successfully")
-					return true, nil // Серийный номер был изменен!
-				}
+// parseEthtoolPermAddr разбирает "Permanent address: xx:xx:..." (нулевой адрес - драйвер его не сообщает)
+func parseEthtoolPermAddr(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Permanent address:"); ok {
+			mac := strings.ToUpper(strings.TrimSpace(value))
+			if mac == "" || mac == "00:00:00:00:00:00" {
+				return ""
 			}
+			return mac
 		}
+	}
+	return ""
+}
 
-		// If we failed and have more attempts, ask user what to do
-		if attempts < maxAttempts {
-			action := askFRURetryAction(fmt.Sprintf("FRU flashing failed (attempt %d/%d): %v", attempts, maxAttempts, lastError))
-			switch action {
-			case "SKIP":
-				printWarning("FRU flashing skipped by operator")
-				return false, nil
-			case "ABORT":
-				return false, fmt.Errorf("FRU flashing aborted by operator")
-			case "RETRY":
-				printInfo("Retrying FRU flashing...")
-				continue
+// parseEthtoolLink разбирает "Link detected" и "Speed: 1000Mb/s" из вывода ethtool <iface>
+func parseEthtoolLink(output string) (bool, int) {
+	link, speed := false, 0
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Link detected:"); ok {
+			link = strings.TrimSpace(value) == "yes"
+		}
+		if value, ok := strings.CutPrefix(line, "Speed:"); ok {
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "Mb/s")); err == nil && n > 0 {
+				speed = n
 			}
 		}
 	}
-
-	// All attempts failed
-	return false, fmt.Errorf("FRU flashing failed after %d attempts: %v", maxAttempts, lastError)
+	return link, speed
 }
 
-func findBootDevice() (string, error) {
-	output, err := runCommand("findmnt", "/", "-o", "SOURCE", "-n")
-	if err != nil {
-		return "", fmt.Errorf("findmnt failed: %v", err)
+// describeNICPort - состояние порта для таблицы разницы
+func describeNICPort(p NICPort) string {
+	state := "no link"
+	if p.Link {
+		state = "link"
+		if p.SpeedMbps > 0 {
+			state = fmt.Sprintf("link %dMb/s", p.SpeedMbps)
+		}
 	}
-	output = strings.TrimSpace(output)
-	loopRegex := regexp.MustCompile(`^/dev/loop[0-9]+$`)
-	if output == "airootfs" || loopRegex.MatchString(output) {
-		// If running from ArchISO, check if /run/archiso/bootmnt is mounted
-		bootMntSource, err := runCommand("findmnt", "/run/archiso/bootmnt", "-o", "SOURCE", "-n")
-		if err == nil && bootMntSource != "" {
-			bootMntSource = strings.TrimSpace(bootMntSource)
-			printDebug(fmt.Sprintf("Found archiso boot mount: %s", bootMntSource))
+	if p.Driver != "" {
+		return p.Driver + ", " + state
+	}
+	return state
+}
 
-			// Extract the disk device from the partition (e.g. /dev/sda1 -> /dev/sda)
-			if strings.Contains(bootMntSource, "nvme") {
-				// For NVMe devices: /dev/nvme0n1p1 -> /dev/nvme0n1
-				devRegex := regexp.MustCompile(`p[0-9]+$`)
-				return devRegex.ReplaceAllString(bootMntSource, ""), nil
-			} else {
-				// For other devices: /dev/sda1 -> /dev/sda
-				devRegex := regexp.MustCompile(`[0-9]+$`)
-				return devRegex.ReplaceAllString(bootMntSource, ""), nil
+// diffNICInventory сравнивает порты до и после сессии. Порты сопоставляются по постоянному MAC
+// (имена меняются после перезагрузки драйвера), затем по имени; порт, прошитый в этой сессии,
+// находится по flashedMAC. Сам MAC адрес не сравнивается - его меняет прошивка
+func diffNICInventory(before, after []NICPort, flashedMAC string) []NICChange {
+	matched := make([]int, len(before)) // Индекс в after + 1, 0 - не найден
+	used := make([]bool, len(after))
+	match := func(same func(b, a NICPort) bool) {
+		for i, b := range before {
+			if matched[i] != 0 {
+				continue
+			}
+			for j, a := range after {
+				if !used[j] && same(b, a) {
+					matched[i], used[j] = j+1, true
+					break
+				}
 			}
 		}
-		return "LOOP", nil
 	}
-	// For NVMe devices, name looks like "/dev/nvme0n1p1" - parent disk: "/dev/nvme0n1"
-	if strings.Contains(output, "nvme") {
-		devRegex := regexp.MustCompile(`p[0-9]+$`)
-		return devRegex.ReplaceAllString(output, ""), nil
+	match(func(b, a NICPort) bool { return b.PermMAC != "" && b.PermMAC == a.PermMAC })
+	match(func(b, a NICPort) bool { return b.Name == a.Name })
+	if flashedMAC != "" {
+		match(func(b, a NICPort) bool {
+			return (a.MAC == flashedMAC || a.PermMAC == flashedMAC) && b.Driver == a.Driver
+		})
 	}
-	// For other devices, e.g. "/dev/sda2" - parent disk: "/dev/sda"
-	devRegex := regexp.MustCompile(`[0-9]+$`)
-	return devRegex.ReplaceAllString(output, ""), nil
-}
 
-func listRealDisks() ([]string, error) {
-	output, err := runCommand("lsblk", "-d", "-o", "NAME,TYPE", "-rn")
-	if err != nil {
-		return nil, fmt.Errorf("lsblk failed: %v", err)
+	var changes []NICChange
+	for i, b := range before {
+		if matched[i] == 0 {
+			changes = append(changes, NICChange{Port: b.Name, Change: "missing", Before: describeNICPort(b), After: "-", Regression: true})
+			continue
+		}
+		a := after[matched[i]-1]
+		if a.Name != b.Name {
+			changes = append(changes, NICChange{Port: b.Name, Change: "renamed", Before: b.Name, After: a.Name})
+		}
+		if b.Driver != "" && a.Driver != b.Driver {
+			changes = append(changes, NICChange{Port: a.Name, Change: "driver", Before: b.Driver, After: a.Driver, Regression: true})
+		}
+		if b.Link && !a.Link {
+			changes = append(changes, NICChange{Port: a.Name, Change: "link_lost", Before: describeNICPort(b), After: describeNICPort(a), Regression: true})
+		} else if b.Link && b.SpeedMbps > 0 && a.SpeedMbps != b.SpeedMbps {
+			changes = append(changes, NICChange{Port: a.Name, Change: "speed", Before: fmt.Sprintf("%dMb/s", b.SpeedMbps), After: fmt.Sprintf("%dMb/s", a.SpeedMbps), Regression: true})
+		}
 	}
-	var disks []string
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && fields[1] == "disk" {
-			disks = append(disks, "/dev/"+fields[0])
+	for j, a := range after {
+		if !used[j] {
+			changes = append(changes, NICChange{Port: a.Name, Change: "added", After: describeNICPort(a)})
 		}
 	}
-	return disks, nil
+	return changes
 }
 
-func isEfiPartition(part string) bool {
-	output, err := runCommand("blkid", "-o", "export", part)
-	if err != nil {
-		return false
+// nicSettleTimeout - сколько ждать возврата линков после перезагрузки драйверов перед выводом о регрессии
+const nicSettleTimeout = 15 * time.Second
+
+// checkNICConsistency снимает инвентарь в конце сессии и оформляет разницу как тест nic-consistency
+func checkNICConsistency(before []NICPort, flashedMAC string) (*NICConsistency, TestResult) {
+	start := time.Now()
+	result := TestResult{Name: "nic-consistency", Status: "PASSED", Required: true, Phase: "session-end", Group: "nic-consistency",
+		Description: "Network ports at session end match the start of the session"}
+	consistency := &NICConsistency{Before: before}
+
+	deadline := start.Add(nicSettleTimeout)
+	for {
+		after, err := captureNICInventory(pollingRunner()) // Нужно текущее состояние, а не снимок начала сессии
+		if err != nil {
+			result.Status = "FAILED"
+			result.Error = fmt.Sprintf("failed to capture network interfaces: %v", err)
+			break
+		}
+		consistency.After = after
+		consistency.Changes = diffNICInventory(before, after, flashedMAC)
+		if nicRegressions(consistency.Changes) == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
 	}
-	lines := strings.Split(output, "\n")
-	for _, line := range lines {
-		if matched, _ := regexp.MatchString(`^TYPE=(fat|vfat|msdos)`, line); matched {
-			return true
+
+	if n := nicRegressions(consistency.Changes); n > 0 {
+		result.Status = "FAILED"
+		result.Error = fmt.Sprintf("%d network port regression(s) since session start", n)
+	}
+	if len(consistency.Changes) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%-16s %-10s %-28s %s\n", "PORT", "CHANGE", "BEFORE", "AFTER")
+		for _, c := range consistency.Changes {
+			fmt.Fprintf(&b, "%-16s %-10s %-28s %s\n", c.Port, c.Change, c.Before, c.After)
 		}
+		result.Output = b.String()
 	}
-	return false
+	result.Attempts = 1
+	result.Duration = time.Since(start)
+	return consistency, result
 }
 
-// Improved function to find external EFI partition with prioritization for the boot device
-func findExternalEfiPartition(bootDev string) (string, string, error) {
-	disks, err := listRealDisks()
-	if err != nil {
-		return "", "", err
+func nicRegressions(changes []NICChange) int {
+	n := 0
+	for _, c := range changes {
+		if c.Regression {
+			n++
+		}
 	}
+	return n
+}
 
-	printDebug(fmt.Sprintf("All disks: %v", disks))
-	printDebug(fmt.Sprintf("Boot device: %s", bootDev))
-
-	// Check if we're running from ArchISO/live environment
+// smartDirName - каталог полного вывода smartctl/nvme-cli в каталоге сессии
+const smartDirName = "smart"
 
-	// Check what device /run/archiso/bootmnt is mounted on (if we're in a live environment)
-	var archisoDev string
-	if bootMntSource, err := runCommand("findmnt", "/run/archiso/bootmnt", "-o", "SOURCE", "-n"); err == nil && bootMntSource != "" {
-		bootMntSource = strings.TrimSpace(bootMntSource)
-		printDebug(fmt.Sprintf("Found archiso boot mount: %s", bootMntSource))
+// captureSMART снимает SMART всех дисков, кроме загрузочного. С непустым dir полный вывод (текст и JSON)
+// сохраняется в dir/<phase>_<диск>.*; возвращает снимки и сохраненные файлы относительно каталога сессии
+func captureSMART(phase, dir string) ([]smart.Device, []string) {
+	disks, err := listRealDisks()
+	if err != nil {
+		printWarning(fmt.Sprintf("SMART snapshot skipped: %v", err))
+		return nil, nil
+	}
+	boot, _ := findBootDevice()
 
-		// Extract the disk device from the partition (e.g. /dev/sda1 -> /dev/sda)
-		if strings.Contains(bootMntSource, "nvme") {
-			// For NVMe devices: /dev/nvme0n1p1 -> /dev/nvme0n1
-			devRegex := regexp.MustCompile(`p[0-9]+$`)
-			archisoDev = devRegex.ReplaceAllString(bootMntSource, "")
-		} else {
-			// For other devices: /dev/sda1 -> /dev/sda
-			devRegex := regexp.MustCompile(`[0-9]+$`)
-			archisoDev = devRegex.ReplaceAllString(bootMntSource, "")
+	var files []string
+	save := func(name string, data []byte) {
+		if dir == "" || len(bytes.TrimSpace(data)) == 0 {
+			return
 		}
-		printDebug(fmt.Sprintf("Extracted archiso device: %s", archisoDev))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			printWarning(fmt.Sprintf("SMART output not saved: %v", err))
+			return
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			printWarning(fmt.Sprintf("SMART output not saved: %v", err))
+			return
+		}
+		files = append(files, filepath.Join(smartDirName, name))
 	}
 
-	// First check for EFI partitions on the boot device itself (if we're booting from ArchISO)
-	var bootDevEfiPartitions []struct {
-		disk      string
-		partition string
+	var devices []smart.Device
+	for _, disk := range disks {
+		if disk == boot {
+			continue
+		}
+		device := captureSMARTDevice(disk, phase+"_"+filepath.Base(disk), save)
+		if device.Error != "" {
+			printWarning(fmt.Sprintf("SMART %s: %s", disk, device.Error))
+		}
+		devices = append(devices, device)
 	}
+	return devices, files
+}
 
-	var otherEfiPartitions []struct {
-		disk      string
-		partition string
+// captureSMARTDevice снимает smartctl -x (текст и --json); NVMe, которые smartctl не поддерживает, - через nvme smart-log
+func captureSMARTDevice(disk, base string, save func(name string, data []byte)) smart.Device {
+	text, _ := runCommand("smartctl", "-x", disk)
+	save(base+".txt", []byte(text+"\n"))
+	// Ненулевой код smartctl - битовая маска предупреждений, JSON при этом полный
+	data, err := tracedOutput(exec.Command("smartctl", "-x", "--json", disk))
+	save(base+".json", data)
+	device := smart.Device{Error: fmt.Sprintf("smartctl produced no output: %v", err)}
+	if len(bytes.TrimSpace(data)) > 0 {
+		if device, err = smart.ParseSmartctlJSON(data); err != nil {
+			device = smart.Device{Error: err.Error()}
+		}
+	}
+	device.Name = disk
+	if !device.Empty() || !strings.HasPrefix(filepath.Base(disk), "nvme") {
+		return device
 	}
 
-	// First pass - collect all EFI partitions and separate them into boot device partitions and others
-	for _, dev := range disks {
-		// Determine if this disk is our boot device
-		isBootDevice := dev == bootDev || dev == archisoDev
-
-		printDebug(fmt.Sprintf("Checking disk: %s for partitions (boot device: %v)", dev, isBootDevice))
+	nvmeText, _ := runCommand("nvme", "smart-log", disk)
+	save(base+".nvme.txt", []byte(nvmeText+"\n"))
+	nvmeData, _ := tracedOutput(exec.Command("nvme", "smart-log", disk, "-o", "json"))
+	save(base+".nvme.json", nvmeData)
+	attrs, err := smart.ParseNVMeSmartLog(nvmeData)
+	if err != nil {
+		device.Error = fmt.Sprintf("%s; nvme-cli: %v", device.Error, err)
+		return device
+	}
+	sysfs := func(name string) string {
+		data, _ := os.ReadFile(filepath.Join("/sys/block", filepath.Base(disk), "device", name))
+		return strings.TrimSpace(string(data))
+	}
+	return smart.Device{Name: disk, Model: sysfs("model"), Serial: sysfs("serial"), Protocol: "NVMe", Source: smart.SourceNVMeCLI, Attributes: attrs}
+}
 
-		// Get all partitions for this disk
-		output, err := runCommand("lsblk", "-nlo", "NAME", dev)
-		if err != nil {
-			printDebug(fmt.Sprintf("Error listing partitions for %s: %v", dev, err))
-			continue
+// checkSMARTDelta снимает SMART после тестов и оформляет разницу со снимком report.Before как тест smart-delta
+func checkSMARTDelta(report *SMARTReport, dir string, config SMARTConfig) TestResult {
+	start := time.Now()
+	result := TestResult{Name: "smart-delta", Status: "PASSED", Required: true, Group: "smart-delta",
+		Description: "Disk SMART attributes did not worsen during the tests"}
+
+	after, files := captureSMART("after", dir)
+	report.After = after
+	report.Files = append(report.Files, files...)
+	report.Changes = smart.Diff(report.Before, after, smart.Thresholds{
+		ReallocatedSectors: config.MaxReallocatedIncrease,
+		MediaErrors:        config.MaxMediaErrorsIncrease,
+		WearLevelPercent:   config.MaxWearIncrease,
+		TemperatureMaxC:    config.MaxTemperatureCelsius,
+	})
+
+	if n := smart.Worsened(report.Changes); n > 0 {
+		result.Status = "FAILED"
+		result.Error = fmt.Sprintf("%d disk SMART regression(s) since the start of the tests", n)
+	}
+	if len(report.Changes) > 0 {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%-16s %-20s %-28s %-28s %s\n", "DEVICE", "ATTRIBUTE", "BEFORE", "AFTER", "DELTA")
+		for _, c := range report.Changes {
+			delta := ""
+			if c.Delta != 0 {
+				delta = fmt.Sprintf("%+d", c.Delta)
+			}
+			if c.Worsened {
+				delta += " !"
+			}
+			fmt.Fprintf(&b, "%-16s %-20s %-28s %-28s %s\n", c.Device, c.Attribute, c.Before, c.After, delta)
 		}
+		result.Output = b.String()
+	}
+	result.Attempts = 1
+	result.Duration = time.Since(start)
+	return result
+}
 
-		partitions := strings.Split(output, "\n")
-		for _, part := range partitions {
-			part = strings.TrimSpace(part)
-
-			// Skip the disk itself from lsblk output
-			if part == filepath.Base(dev) {
-				continue
-			}
+func getSystemInfo() (SystemInfo, error) {
+	now := time.Now()
+	info := SystemInfo{
+		Timestamp:       now,
+		TimestampOffset: sessionOffset(now),
+	}
 
-			// Construct full path to partition
-			partPath := "/dev/" + part
-			printDebug(fmt.Sprintf("Checking partition: %s", partPath))
-
-			// Skip if it's the same as disk device
-			if partPath == dev {
-				printDebug(fmt.Sprintf("Skipping partition %s as it's the same as disk device", partPath))
-				continue
-			}
-
-			if isEfiPartition(partPath) {
-				printDebug(fmt.Sprintf("Found EFI partition: %s on disk: %s", partPath, dev))
+	// Get IP address
+	if ip, err := getIPAddress(); err == nil {
+		info.IP = ip
+	}
 
-				// Add to appropriate list based on whether it's on the boot device
-				if isBootDevice {
-					bootDevEfiPartitions = append(bootDevEfiPartitions, struct {
-						disk      string
-						partition string
-					}{dev, partPath})
-				} else {
-					otherEfiPartitions = append(otherEfiPartitions, struct {
-						disk      string
-						partition string
-					}{dev, partPath})
+	// Get original MAC addresses from all network interfaces
+	if interfaces, err := getCurrentNetworkInterfaces(); err == nil {
+		var originalMACs []string
+		for _, iface := range interfaces {
+			if iface.MAC != "" && iface.Name != "lo" { // Исключаем loopback
+				// Нормализуем MAC для единообразия
+				normalizedMAC, err := normalizeMAC(iface.MAC)
+				if err != nil {
+					printWarning(fmt.Sprintf("Interface %s skipped in original MACs: %v", iface.Name, err))
+					continue
 				}
+				originalMACs = append(originalMACs, normalizedMAC)
 			}
 		}
-	}
+		info.OriginalMACs = originalMACs
 
-	// First try EFI partitions on the boot device (if any)
-	if len(bootDevEfiPartitions) > 0 {
-		if len(bootDevEfiPartitions) > 1 {
-			printDebug(fmt.Sprintf("Multiple EFI partitions found on boot device. Using the first one."))
-			for i, part := range bootDevEfiPartitions {
-				printDebug(fmt.Sprintf("Boot device EFI partition %d: disk=%s, partition=%s", i+1, part.disk, part.partition))
-			}
+		if len(originalMACs) > 0 {
+			printInfo(fmt.Sprintf("Collected %d original MAC address(es): %s",
+				len(originalMACs), strings.Join(originalMACs, ", ")))
 		}
-		printDebug(fmt.Sprintf("Selected EFI partition on boot device: %s", bootDevEfiPartitions[0].partition))
-		return bootDevEfiPartitions[0].disk, bootDevEfiPartitions[0].partition, nil
+	} else {
+		printWarning(fmt.Sprintf("Failed to collect original MAC addresses: %v", err))
 	}
 
-	// If no EFI partitions found on boot device, fall back to other disks
-	if len(otherEfiPartitions) > 0 {
-		if len(otherEfiPartitions) > 1 {
-			printDebug(fmt.Sprintf("Multiple EFI partitions found on other devices. Using the first one."))
-			for i, part := range otherEfiPartitions {
-				printDebug(fmt.Sprintf("Other device EFI partition %d: disk=%s, partition=%s", i+1, part.disk, part.partition))
-			}
-		}
-		printDebug(fmt.Sprintf("Selected EFI partition on non-boot device: %s", otherEfiPartitions[0].partition))
-		return otherEfiPartitions[0].disk, otherEfiPartitions[0].partition, nil
+	// Run dmidecode
+	cmd := exec.Command("dmidecode")
+	output, err := tracedOutput(cmd)
+	if err != nil {
+		return info, fmt.Errorf("failed to run dmidecode: %v", err)
 	}
 
-	// If we get here, no EFI partition was found
-	return "", "", errors.New("no EFI partition found on any disk")
-}
+	// Parse dmidecode output
+	dmidecodeData := parseDMIDecode(string(output))
+	info.DMIDecode = dmidecodeData
 
-// getEFIVariable читает существующую EFI переменную
-func getEFIVariable(guidPrefix, varName string) (string, error) {
-	// Парсим GUID
-	varGUID, err := efiguid.FromString(guidPrefix)
-	if err != nil {
-		return "", fmt.Errorf("invalid GUID format '%s': %v", guidPrefix, err)
+	// Extract key information and save original values
+	if systemInfo, ok := dmidecodeData["System Information"].(map[string]interface{}); ok {
+		if product, ok := systemInfo["Product Name"].(string); ok {
+			info.Product = product
+		}
 	}
 
-	ctx := efivario.NewDefaultContext()
-	if ctx == nil {
-		return "", fmt.Errorf("failed to create UEFI context")
+	if baseboardInfo, ok := dmidecodeData["Base Board Information"].(map[string]interface{}); ok {
+		if serial, ok := baseboardInfo["Serial Number"].(string); ok {
+			info.OriginalMBSerial = serial // Сохраняем оригинальный серийник
+			printInfo(fmt.Sprintf("Original motherboard serial: %s", serial))
+		}
+		if version, ok := baseboardInfo["Version"].(string); ok {
+			info.BoardVersion = version
+		}
+		if manufacturer, ok := baseboardInfo["Manufacturer"].(string); ok {
+			info.BoardManufacturer = manufacturer
+		}
+		if product, ok := baseboardInfo["Product Name"].(string); ok {
+			info.BoardProduct = product
+		}
 	}
 
-	// Читаем переменную
-	readBuf := make([]byte, 1024)
-	_, n, err := ctx.Get(varName, varGUID, readBuf)
-	if err != nil {
-		return "", err // Переменная не существует или не читается
+	info.BIOSVendor, info.BIOSVersion, info.BIOSReleaseDate = parseBIOSInformation(dmidecodeData)
+
+	if devices, err := getPCIDeviceIDs(); err == nil {
+		info.PCIDevices = devices
+	} else {
+		printWarning(fmt.Sprintf("Failed to collect PCI devices: %v", err))
 	}
 
-	readData := readBuf[:n]
-	return string(readData), nil
+	return info, nil
 }
 
-// bootctl mounts external EFI partition, copies contents of efishell directory (ctefi)
-// and sets one-time boot entry (via setOneTimeBoot). Do not change this function!
-func bootctl() error {
-	// Determine boot device
-	bootDev, err := findBootDevice()
-	if err != nil {
-		return fmt.Errorf("Could not determine boot device: %v", err)
+// parseBIOSInformation извлекает vendor/version/date из секции dmidecode type 0
+func parseBIOSInformation(dmidecodeData map[string]interface{}) (vendor, version, date string) {
+	biosInfo, ok := dmidecodeData["BIOS Information"].(map[string]interface{})
+	if !ok {
+		return "", "", ""
 	}
+	vendor, _ = biosInfo["Vendor"].(string)
+	version, _ = biosInfo["Version"].(string)
+	date, _ = biosInfo["Release Date"].(string)
+	return vendor, version, date
+}
 
-	printDebug(fmt.Sprintf("Detected boot device: %s", bootDev))
+// stationEnvVar - переменная окружения с ID станции, если в конфиге не задан
+const stationEnvVar = "FIRESTARTER_STATION_ID"
 
-	// Find external EFI partition
-	targetDevice, targetEfi, err := findExternalEfiPartition(bootDev)
-	if err != nil || targetDevice == "" || targetEfi == "" {
-		return errors.New("No external EFI partition found")
+// collectStationInfo определяет станцию: station_id из конфига, иначе из окружения
+func collectStationInfo(config LogConfig) StationInfo {
+	station := StationInfo{ID: config.StationID, FirestarterVersion: VERSION}
+	if station.ID == "" {
+		station.ID = os.Getenv(stationEnvVar)
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		station.Hostname = hostname
 	}
+	return station
+}
 
-	// Additional check to ensure targetEfi is a partition, not the whole disk
-	if targetEfi == targetDevice {
-		return fmt.Errorf("targetEfi cannot be the same as targetDevice: %s", targetEfi)
+// stationDirName - уровень каталога станции на сервере логов (ID, иначе hostname)
+func stationDirName(station StationInfo) string {
+	if station.ID != "" {
+		return sanitizeFileName(station.ID)
 	}
+	if station.Hostname != "" {
+		return sanitizeFileName(station.Hostname)
+	}
+	return "unknown_station"
+}
 
-	printDebug("targetDevice: " + targetDevice)
-	printDebug("targetEFI: " + targetEfi)
+// sessionClockStart - момент запуска по монотонным часам; смещения от него не зависят от коррекции системного времени
+var sessionClockStart = time.Now()
 
-	// No need to mount and copy files, as all necessary information is in EFI variables
-	printDebug("Using EFI variables instead of copying files to EFI partition")
+// sessionOffset - монотонное смещение момента t (полученного time.Now() в этом процессе) от запуска программы
+func sessionOffset(t time.Time) time.Duration {
+	return t.Sub(sessionClockStart).Round(time.Millisecond)
+}
 
-	// Call setOneTimeBoot function to create new entry and set BootNext
-	if err := setOneTimeBoot(targetDevice, targetEfi); err != nil {
-		return fmt.Errorf("setOneTimeBoot error: %v", err)
+// clockFloorDate - нижняя граница правдоподобной даты; при сборке: -ldflags "-X main.clockFloorDate=YYYY-MM-DD"
+var clockFloorDate = "2025-01-01"
+
+// sessionClock - результат проверки времени в начале сессии (nil - проверка не выполнялась)
+var sessionClock *ClockCheck
+
+// clockFloor возвращает нижнюю границу даты из system.clock_floor или даты сборки
+func clockFloor(config SystemConfig) time.Time {
+	value := clockFloorDate
+	if config.ClockFloor != "" {
+		value = config.ClockFloor
 	}
+	floor, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		printWarning(fmt.Sprintf("Invalid clock floor %q (expected YYYY-MM-DD), date plausibility check disabled", value))
+		return time.Time{}
+	}
+	return floor
+}
 
-	if err = runCommandNoOutput("bootctl", "set-oneshot", "03-efishell.conf"); err != nil {
-		printError("Failed to set one-time boot entry: " + err.Error())
-		os.Exit(1)
-	} else {
-		printDebug("One-time boot entry set successfully.")
+// maxClockSkew - допустимое расхождение системного времени с эталоном
+func maxClockSkew(config SystemConfig) time.Duration {
+	if config.MaxClockSkewMinutes > 0 {
+		return time.Duration(config.MaxClockSkewMinutes) * time.Minute
 	}
+	return 5 * time.Minute
+}
 
-	return nil
+// evaluateClock решает, можно ли доверять системному времени now: оно не раньше floor
+// и отличается от эталона reference (nil - эталона нет) не больше чем на maxSkew
+func evaluateClock(now, floor time.Time, reference *time.Time, maxSkew time.Duration) (suspect bool, skew time.Duration, reason string) {
+	if reference != nil {
+		skew = reference.Sub(now)
+		if skew > maxSkew || skew < -maxSkew {
+			return true, skew, fmt.Sprintf("system clock differs from reference by %s (limit %s)", skew.Round(time.Second), maxSkew)
+		}
+	}
+	if !floor.IsZero() && now.Before(floor) {
+		return true, skew, fmt.Sprintf("system clock %s is before %s", now.Format("2006-01-02 15:04:05"), floor.Format("2006-01-02"))
+	}
+	return false, skew, ""
 }
 
-// setOneTimeBoot creates a new one-time boot entry and sets BootNext
-func setOneTimeBoot(targetDevice, targetEfi string) error {
-	printDebug(fmt.Sprintf("setOneTimeBoot: targetDevice=%s, targetEfi=%s", targetDevice, targetEfi))
+var (
+	ntpdateOffsetRegex = regexp.MustCompile(`offset ([-+]?[0-9]*\.?[0-9]+)`)
+	chronyOffsetRegex  = regexp.MustCompile(`wrong by ([-+]?[0-9]*\.?[0-9]+) seconds`)
+)
 
-	// Use the regular expression that should not be changed - DO NOT TOUCH!
-	re := regexp.MustCompile(`(?im)^Boot([0-9A-Fa-f]{4})(\*?)\s+OneTimeBoot\t(.+)$`)
+// queryNTPOffset запрашивает у NTP сервера поправку (сервер минус система) без изменения часов: ntpdate -q или chronyd -Q
+func queryNTPOffset(server string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	// Check if there are conflicting entries
-	out, err := runCommand("efibootmgr")
+	var cmd *exec.Cmd
+	var regex *regexp.Regexp
+	if _, err := exec.LookPath("ntpdate"); err == nil {
+		cmd, regex = exec.CommandContext(ctx, "ntpdate", "-q", server), ntpdateOffsetRegex
+	} else if _, err := exec.LookPath("chronyd"); err == nil {
+		cmd, regex = exec.CommandContext(ctx, "chronyd", "-Q", fmt.Sprintf("server %s iburst", server)), chronyOffsetRegex
+	} else {
+		return 0, fmt.Errorf("neither ntpdate nor chronyd found in PATH")
+	}
+
+	output, err := tracedCombinedOutput(cmd)
 	if err != nil {
-		return fmt.Errorf("efibootmgr failed: %v", err)
+		return 0, fmt.Errorf("%s failed: %v (%s)", filepath.Base(cmd.Path), err, strings.TrimSpace(string(output)))
+	}
+	match := regex.FindStringSubmatch(string(output))
+	if match == nil {
+		return 0, fmt.Errorf("no offset in %s output: %s", filepath.Base(cmd.Path), strings.TrimSpace(string(output)))
+	}
+	seconds, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %v", match[1], err)
 	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
 
-	// Find only entries that conflict (have the same boot path)
-	matches := re.FindAllStringSubmatch(out, -1)
+// correctClock устанавливает системное время: по NTP (ntpdate -b / chronyd -q) или date -s от часов BMC
+func correctClock(reference string, config SystemConfig, target time.Time) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-	// Define the boot path for our new entry
-	targetBootPath := "\\EFI\\BOOT\\shellx64.efi -delay:0"
+	var cmd *exec.Cmd
+	if reference == "bmc" {
+		cmd = exec.CommandContext(ctx, "date", "-s", fmt.Sprintf("@%d", target.Unix()))
+	} else if _, err := exec.LookPath("ntpdate"); err == nil {
+		cmd = exec.CommandContext(ctx, "ntpdate", "-b", config.NTPServer)
+	} else {
+		cmd = exec.CommandContext(ctx, "chronyd", "-q", fmt.Sprintf("server %s iburst", config.NTPServer))
+	}
 
-	// Determine partition number for the new device
-	var partition string
+	tool := filepath.Base(cmd.Path)
+	if output, err := tracedCombinedOutput(cmd); err != nil {
+		return tool, fmt.Errorf("%s failed: %v (%s)", tool, err, strings.TrimSpace(string(output)))
+	}
+	if reference == "bmc" {
+		tool = "bmc"
+	}
+	return tool, nil
+}
 
-	// Extract the partition number from targetEfi path
-	if strings.Contains(targetDevice, "nvme") {
-		// For NVMe devices, name looks like "/dev/nvme0n1p1" - parent disk: "/dev/nvme0n1"
-		// Verify that targetEfi has format like /dev/nvme0n1p1
-		nvmePartRegex := regexp.MustCompile(`^(/dev/nvme[0-9]+n[0-9]+)p([0-9]+)$`)
-		matches := nvmePartRegex.FindStringSubmatch(targetEfi)
-		if len(matches) == 3 {
-			printDebug(fmt.Sprintf("NVMe partition identified: disk=%s, partition=%s", matches[1], matches[2]))
-			// Check if targetDevice matches the disk part
-			if matches[1] != targetDevice {
-				printDebug(fmt.Sprintf("Warning: Extracted disk %s doesn't match targetDevice %s", matches[1], targetDevice))
-				// Use the matched disk as targetDevice for consistency
-				targetDevice = matches[1]
-			}
-			partition = matches[2]
+// checkSystemClock проверяет системное время по NTP (system.ntp_server) или BMC и нижней границе даты;
+// при system.fix_clock исправляет его. Вызывается до того, как время попадет в ID сессии и имена логов.
+func checkSystemClock(config SystemConfig) *ClockCheck {
+	check := &ClockCheck{Reference: "none"}
+	maxSkew := maxClockSkew(config)
+
+	var reference *time.Time
+	now := time.Now()
+	if config.NTPServer != "" {
+		if offset, err := queryNTPOffset(config.NTPServer); err == nil {
+			now = time.Now()
+			ref := now.Add(offset)
+			reference = &ref
+			check.Reference = "ntp:" + config.NTPServer
 		} else {
-			return fmt.Errorf("invalid NVMe partition format: %s", targetEfi)
+			printWarning(fmt.Sprintf("NTP time check failed: %v", err))
 		}
-	} else {
-		// For other devices, e.g. "/dev/sda1" - parent disk: "/dev/sda"
-		stdPartRegex := regexp.MustCompile(`^(/dev/[a-z]+)([0-9]+)$`)
-		matches := stdPartRegex.FindStringSubmatch(targetEfi)
-		if len(matches) == 3 {
-			printDebug(fmt.Sprintf("Standard partition identified: disk=%s, partition=%s", matches[1], matches[2]))
-			// Check if targetDevice matches the disk part
-			if matches[1] != targetDevice {
-				printDebug(fmt.Sprintf("Warning: Extracted disk %s doesn't match targetDevice %s", matches[1], targetDevice))
-				// Use the matched disk as targetDevice for consistency
-				targetDevice = matches[1]
-			}
-			partition = matches[2]
-		} else {
-			return fmt.Errorf("invalid partition format: %s", targetEfi)
+	}
+	if reference == nil {
+		if clock := readBMCClock(); clock != nil {
+			now = time.Now()
+			ref := now.Add(time.Duration(clock.SkewSeconds) * time.Second)
+			reference = &ref
+			check.Reference = "bmc"
 		}
 	}
 
-	if partition == "" {
-		return fmt.Errorf("could not determine partition number from targetEfi: %s", targetEfi)
+	floor := clockFloor(config)
+	suspect, skew, reason := evaluateClock(now, floor, reference, maxSkew)
+	check.CheckedAt = now
+	check.CheckedAtOffset = sessionOffset(now)
+	check.SkewSeconds = int64(skew.Round(time.Second).Seconds())
+	check.Suspect = suspect
+	check.Reason = reason
+	if !suspect || !config.FixClock {
+		return check
 	}
 
-	printDebug(fmt.Sprintf("Using disk device: %s, partition: %s", targetDevice, partition))
-
-	// Remove only entries that conflict with our target entry
-	for _, match := range matches {
-		bootNum := match[1]
+	if reference == nil {
+		check.Error = "no NTP server or BMC clock to correct from"
+		return check
+	}
+	target := time.Now().Add(skew)
+	tool, err := correctClock(check.Reference, config, target)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.CorrectedBy = tool
 
-		// Get more detailed info about the entry
-		bootInfo, err := runCommand("efibootmgr", "-v", "-b", bootNum)
-		if err != nil {
-			printDebug(fmt.Sprintf("[WARNING] Failed to get info for Boot%s: %v", bootNum, err))
-			continue
-		}
+	// Время исправлено, если теперь оно правдоподобно (эталон уже учтен - проверяем нижнюю границу)
+	now = time.Now()
+	if stillSuspect, _, stillReason := evaluateClock(now, floor, nil, maxSkew); stillSuspect {
+		check.Error = "clock still implausible after correction: " + stillReason
+		return check
+	}
+	check.Corrected = true
+	check.Suspect = false
+	return check
+}
 
-		// Check if the entry contains the same boot path
-		if strings.Contains(bootInfo, targetBootPath) {
-			printDebug("[INFO] Removing conflicting OneTimeBoot entry: Boot" + bootNum)
-			if err := runCommandNoOutput("efibootmgr", "-B", "-b", bootNum); err != nil {
-				printDebug(fmt.Sprintf("[WARNING] Failed to remove Boot%s: %v", bootNum, err))
-			}
+// printClockCheck выводит результат проверки времени; недостоверное время выделяется баннером
+func printClockCheck(check *ClockCheck) {
+	switch {
+	case check.Corrected:
+		printSuccess(fmt.Sprintf("System clock corrected by %s (skew was %+ds from %s)", check.CorrectedBy, check.SkewSeconds, check.Reference))
+	case check.Suspect:
+		fmt.Printf("\n%s SYSTEM CLOCK IS NOT TRUSTED %s\n", ColorBgRed, ColorReset)
+		printError(check.Reason)
+		if check.Error != "" {
+			printError("Clock correction failed: " + check.Error)
 		} else {
-			printDebug("[INFO] Keeping non-conflicting OneTimeBoot entry: Boot" + bootNum)
+			printWarning("Set system.fix_clock: true to correct the clock automatically")
 		}
+		printWarning("Log names and timestamps of this session may be wrong; session offsets keep event order")
+	case check.Reference != "none":
+		printDebug(fmt.Sprintf("System clock OK (skew %+ds from %s)", check.SkewSeconds, check.Reference))
 	}
+}
 
-	printDebug("targetDevice: " + targetDevice)
-	printDebug("Partition: " + partition)
+// annotateClockCheck переносит результат проверки времени в лог сессии
+func annotateClockCheck(log *SessionLog, check *ClockCheck) {
+	if check == nil {
+		return
+	}
+	log.Clock = check
+	log.ClockSuspect = check.Suspect
+}
 
-	printDebug("[INFO] Creating new OneTimeBoot entry")
-	// Create a new entry without displaying command result
-	createCmd := exec.Command("efibootmgr",
-		"-c",
-		"-d", targetDevice,
-		"-p", partition,
-		"-L", "OneTimeBoot",
-		"-l", targetBootPath)
-	// Hide efibootmgr output, keep only debug messages
-	var createOut bytes.Buffer
-	createCmd.Stdout = &createOut
-	createCmd.Stderr = &createOut
-	if err := createCmd.Run(); err != nil {
-		printDebug("[ERROR] efibootmgr create output: " + createOut.String())
-		return fmt.Errorf("failed to create new boot entry: %v", err)
+// readBMCClock читает часы BMC. Без BMC/ipmitool возвращает nil (не ошибка для станции).
+func readBMCClock() *BMCClock {
+	if !bmcAvailable() {
+		return nil
 	}
 
-	// Find the created entry with OneTimeBoot label
-	out, err = runCommand("efibootmgr", "-v")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	output, err := tracedOutput(exec.CommandContext(ctx, "ipmitool", "sel", "time", "get"))
+	now := time.Now()
 	if err != nil {
-		return fmt.Errorf("efibootmgr failed after creation: %v", err)
+		printDebug(fmt.Sprintf("BMC time not available: %v", err))
+		return nil
 	}
-	matches = re.FindAllStringSubmatch(out, -1)
-	if len(matches) == 0 {
-		return errors.New("new OneTimeBoot entry not found after creation")
+
+	bmcTime, err := parseSELTime(string(output))
+	if err != nil {
+		printDebug(fmt.Sprintf("BMC time not parsed: %v", err))
+		return nil
 	}
+	return &BMCClock{Time: bmcTime, SkewSeconds: int64(bmcTime.Sub(now).Round(time.Second).Seconds())}
+}
 
-	// Find our new entry - it should be the last created with this label
-	var bootNum string
-	for _, match := range matches {
-		candidateBootNum := match[1]
-		bootInfo, err := runCommand("efibootmgr", "-v", "-b", candidateBootNum)
-		if err == nil && strings.Contains(bootInfo, targetBootPath) &&
-			strings.Contains(bootInfo, targetDevice) {
-			bootNum = candidateBootNum
+// bmcAvailable сообщает, есть ли в системе IPMI устройство (без него ipmitool до BMC не достучится)
+func bmcAvailable() bool {
+	return fileExists("/dev/ipmi0") || fileExists("/dev/ipmi/0") || fileExists("/dev/ipmidev/0")
+}
+
+// parseSELTime разбирает вывод ipmitool sel time get ("01/15/2024 10:30:45", новые версии добавляют зону)
+func parseSELTime(output string) (time.Time, error) {
+	value := strings.TrimSpace(output)
+	if lines := strings.Split(value, "\n"); len(lines) > 0 {
+		value = strings.TrimSpace(lines[len(lines)-1])
+	}
+
+	if t, err := time.Parse("01/02/2006 15:04:05 MST", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("01/02/2006 15:04:05", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unexpected SEL time format %q", value)
+}
+
+// selCommandTimeout - предел ipmitool sel list (большой SEL на медленном BMC читается десятки секунд)
+const selCommandTimeout = 60 * time.Second
+
+// selCollector отслеживает записи SEL, добавленные после начала сессии. SEL никогда не очищается автоматически
+type selCollector struct {
+	mu      sync.Mutex
+	lastID  uint32    // Последняя запись до начала сессии (0 - SEL был пуст)
+	started time.Time // Начало сессии по часам BMC - отбор по времени, если SEL очистили во время сессии
+	events  []SELEvent
+	seen    map[uint32]bool
+}
+
+// selTracker - сбор SEL текущей сессии, nil без BMC
+var selTracker *selCollector
+
+// selOnFailure - забирать SEL сразу после упавшего теста (log.sel_on_failure)
+var selOnFailure bool
+
+// startSELCollection запоминает последнюю запись SEL; без BMC - одна информационная строка
+func startSELCollection(clock *BMCClock) {
+	if !bmcAvailable() {
+		printInfo("SEL collection skipped: no BMC (IPMI device not found)")
+		return
+	}
+	output, err := runIPMITool("sel", "list", "last", "1")
+	if err != nil {
+		printInfo(fmt.Sprintf("SEL collection skipped: %v", err))
+		return
+	}
+
+	c := &selCollector{started: time.Now(), seen: make(map[uint32]bool)}
+	if clock != nil {
+		c.started = c.started.Add(time.Duration(clock.SkewSeconds) * time.Second)
+	}
+	if entries := parseSELList(output); len(entries) > 0 {
+		c.lastID = entries[len(entries)-1].ID
+	}
+	selTracker = c
+	printInfo(fmt.Sprintf("SEL baseline: last record %04x", c.lastID))
+}
+
+// collect забирает записи SEL, появившиеся после начала сессии и еще не учтенные
+func (c *selCollector) collect(afterTest string) ([]SELEvent, error) {
+	output, err := runIPMITool("sel", "list")
+	if err != nil {
+		return nil, err
+	}
+	entries := parseSELList(output)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Записи с начала сессии на месте - новые те, что после нее по ID; иначе SEL очищали, отбираем по времени
+	byID := c.lastID == 0
+	for _, e := range entries {
+		if e.ID == c.lastID {
+			byID = true
 			break
 		}
 	}
 
-	if bootNum == "" {
-		// If we didn't find an exact match, use the last entry
-		bootNum = matches[len(matches)-1][1]
+	var fresh []SELEvent
+	for _, e := range entries {
+		if c.seen[e.ID] {
+			continue
+		}
+		if byID && e.ID <= c.lastID {
+			continue
+		}
+		if !byID && !e.Timestamp.IsZero() && e.Timestamp.Before(c.started) {
+			continue
+		}
+		c.seen[e.ID] = true
+		e.Severity = selSeverity(e)
+		e.AfterTest = afterTest
+		c.events = append(c.events, e)
+		fresh = append(fresh, e)
 	}
+	return fresh, nil
+}
 
-	printDebug("[INFO] New OneTimeBoot entry created: Boot" + bootNum)
+// collectSELAfterFailure показывает записи SEL, появившиеся к моменту провала теста
+func collectSELAfterFailure(testName string) {
+	if selTracker == nil {
+		return
+	}
+	events, err := selTracker.collect(testName)
+	if err != nil {
+		printWarning(fmt.Sprintf("SEL not read after '%s' failed: %v", testName, err))
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	printWarning(fmt.Sprintf("SEL: %d new event(s) by the time '%s' failed (worst: %s)", len(events), testName, worstSELSeverity(events)))
+	for _, e := range events {
+		fmt.Printf("    %s%s%s\n", ColorGray, formatSELEvent(e), ColorReset)
+	}
+}
 
-	// Set BootNext to the created entry
-	if err := runCommandNoOutput("efibootmgr", "-n", bootNum); err != nil {
-		out2, err2 := runCommand("efibootmgr", "-v")
-		if err2 == nil && strings.Contains(out2, "BootNext: "+bootNum) {
-			printDebug("BootNext is already set to Boot" + bootNum)
+// finishSELCollection забирает оставшиеся новые записи и возвращает все записи SEL сессии
+func finishSELCollection() []SELEvent {
+	if selTracker == nil {
+		return nil
+	}
+	if _, err := selTracker.collect(""); err != nil {
+		printWarning(fmt.Sprintf("SEL not read at session end: %v", err))
+	}
+
+	selTracker.mu.Lock()
+	defer selTracker.mu.Unlock()
+	events := append([]SELEvent(nil), selTracker.events...)
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events
+}
+
+// runIPMITool выполняет ipmitool с ограничением по времени
+func runIPMITool(args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), selCommandTimeout)
+	defer cancel()
+	output, err := tracedCombinedOutput(exec.CommandContext(ctx, "ipmitool", args...))
+	if err != nil {
+		return "", fmt.Errorf("ipmitool %s failed: %v (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return string(output), nil
+}
+
+// parseSELList разбирает ipmitool sel list: обычный формат "ID | дата | время | сенсор | событие | направление"
+// и подробный (ipmitool -v sel list) с блоками "SEL Record ID : ..."
+func parseSELList(output string) []SELEvent {
+	if strings.Contains(output, "SEL Record ID") {
+		return parseSELVerbose(output)
+	}
+
+	var events []SELEvent
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		id, err := strconv.ParseUint(fields[0], 16, 32)
+		if err != nil {
+			continue
+		}
+		event := SELEvent{
+			ID:        uint32(id),
+			Timestamp: parseSELEntryTime(fields[1] + " " + fields[2]),
+			Sensor:    fields[3],
+			Event:     fields[4],
+		}
+		if len(fields) > 5 {
+			event.Direction = fields[5]
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// parseSELVerbose разбирает подробный вывод: записи разделены пустыми строками, поля "Имя : значение"
+func parseSELVerbose(output string) []SELEvent {
+	var events []SELEvent
+	var current *SELEvent
+	var sensorType, sensorNumber, eventType string
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Sensor = sensorType
+		if sensorNumber != "" {
+			current.Sensor = strings.TrimSpace(fmt.Sprintf("%s #0x%s", sensorType, sensorNumber))
+		}
+		if current.Event == "" {
+			current.Event = eventType
+		}
+		events = append(events, *current)
+		current = nil
+		sensorType, sensorNumber, eventType = "", "", ""
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if key == "SEL Record ID" {
+			flush()
+			id, err := strconv.ParseUint(value, 16, 32)
+			if err != nil {
+				continue
+			}
+			current = &SELEvent{ID: uint32(id)}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch key {
+		case "Timestamp":
+			current.Timestamp = parseSELEntryTime(value)
+		case "Sensor Type":
+			sensorType = value
+		case "Sensor Number":
+			sensorNumber = value
+		case "Event Type":
+			eventType = value
+		case "Description":
+			current.Event = value
+		case "Event Direction":
+			switch {
+			case strings.HasPrefix(value, "Assertion"):
+				current.Direction = "Asserted"
+			case strings.HasPrefix(value, "Deassertion"):
+				current.Direction = "Deasserted"
+			default:
+				current.Direction = value
+			}
+		}
+	}
+	flush()
+	return events
+}
+
+// parseSELEntryTime разбирает время записи SEL; Pre-Init и неизвестный формат - нулевое время
+func parseSELEntryTime(value string) time.Time {
+	t, err := parseSELTime(strings.Join(strings.Fields(value), " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// selSeverity оценивает запись SEL: critical - некорректируемые ошибки и критические пороги, warning - корректируемые и некритичные
+func selSeverity(e SELEvent) string {
+	if e.Direction == "Deasserted" {
+		return "info"
+	}
+	text := " " + strings.ToLower(e.Sensor+" "+e.Event)
+	for _, marker := range []string{"non-recoverable", "uncorrectable", "thermal trip", "fatal", "machine check", " critical"} {
+		if strings.Contains(text, marker) {
+			return "critical"
+		}
+	}
+	for _, marker := range []string{"non-critical", "correctable", "predictive", "degraded", "redundancy lost", "going high", "going low"} {
+		if strings.Contains(text, marker) {
+			return "warning"
+		}
+	}
+	return "info"
+}
+
+// worstSELSeverity - самая серьезная оценка среди записей
+func worstSELSeverity(events []SELEvent) string {
+	rank := map[string]int{"info": 0, "warning": 1, "critical": 2}
+	worst := "info"
+	for _, e := range events {
+		if rank[e.Severity] > rank[worst] {
+			worst = e.Severity
+		}
+	}
+	return worst
+}
+
+// formatSELEvent - запись SEL одной строкой для консоли
+func formatSELEvent(e SELEvent) string {
+	when := "Pre-Init"
+	if !e.Timestamp.IsZero() {
+		when = e.Timestamp.Format("2006-01-02 15:04:05")
+	}
+	line := fmt.Sprintf("%04x %s %s: %s", e.ID, when, e.Sensor, e.Event)
+	if e.Direction != "" {
+		line += " (" + e.Direction + ")"
+	}
+	return line + " [" + e.Severity + "]"
+}
+
+// getPCIDeviceIDs возвращает уникальные vendor:device всех PCI устройств (из sysfs, без lspci)
+func getPCIDeviceIDs() ([]string, error) {
+	const pciDir = "/sys/bus/pci/devices"
+
+	addresses, err := sysRunner.ReadDir(pciDir)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var devices []string
+	for _, addr := range addresses {
+		vendor := strings.TrimPrefix(readSysfsValue(filepath.Join(pciDir, addr, "vendor")), "0x")
+		device := strings.TrimPrefix(readSysfsValue(filepath.Join(pciDir, addr, "device")), "0x")
+		if vendor == "" || device == "" {
+			continue
+		}
+		id := strings.ToLower(vendor + ":" + device)
+		if !seen[id] {
+			seen[id] = true
+			devices = append(devices, id)
+		}
+	}
+	sort.Strings(devices)
+	return devices, nil
+}
+
+// IdentifierCheck - результат проверки одного признака продукта
+type IdentifierCheck struct {
+	Type     string `yaml:"type"`
+	Expected string `yaml:"expected"`
+	Actual   string `yaml:"actual"`
+	Matched  bool   `yaml:"matched"`
+}
+
+// ProductIdentificationResult - доказательства, по которым плата принята (или отклонена)
+type ProductIdentificationResult struct {
+	Mode      string            `yaml:"mode"`
+	Matched   bool              `yaml:"matched"`
+	MatchedBy string            `yaml:"matched_by,omitempty"` // Первый совпавший признак по приоритету
+	Checks    []IdentifierCheck `yaml:"checks"`
+}
+
+// identifyProduct проверяет признаки продукта из конфигурации. Возвращает nil, если проверять нечего
+// (product не задан или не определен, а identifiers не настроены).
+func identifyProduct(config SystemConfig, info SystemInfo) *ProductIdentificationResult {
+	identifiers := config.Identification.Identifiers
+	if len(identifiers) == 0 {
+		if config.Product == "" || info.Product == "" {
 			return nil
 		}
-		return fmt.Errorf("failed to set BootNext to %s: %v", bootNum, err)
+		identifiers = []ProductIdentifier{{Type: "product_name", Value: config.Product}}
 	}
 
-	out3, err3 := runCommand("efibootmgr", "-v")
-	if err3 == nil && strings.Contains(out3, "BootNext: "+bootNum) {
-		printDebug("BootNext is set to Boot" + bootNum)
-		return nil
+	result := &ProductIdentificationResult{Mode: config.Identification.Match}
+	if result.Mode != "all" {
+		result.Mode = "any"
 	}
 
-	return fmt.Errorf("failed to verify BootNext setting for Boot%s", bootNum)
+	allMatched := true
+	for _, id := range identifiers {
+		check := checkIdentifier(id, info)
+		result.Checks = append(result.Checks, check)
+		if check.Matched {
+			if result.MatchedBy == "" {
+				result.MatchedBy = check.Type
+			}
+		} else {
+			allMatched = false
+		}
+	}
+
+	if result.Mode == "all" {
+		result.Matched = allMatched
+	} else {
+		result.Matched = result.MatchedBy != ""
+	}
+	return result
 }
 
-// calculateSessionState определяет общий статус сессии на основе результатов тестов и прошивки
-func calculateSessionState(testResults []TestResult, flashResults []FlashResult) string {
-	// Проверяем критические тесты
-	for _, result := range testResults {
-		if result.Required && (result.Status == "FAILED" || result.Status == "TIMEOUT") {
-			return "failed"
+func checkIdentifier(id ProductIdentifier, info SystemInfo) IdentifierCheck {
+	check := IdentifierCheck{Type: id.Type, Expected: id.Value}
+
+	switch id.Type {
+	case "product_name":
+		check.Actual = info.Product
+		check.Matched = info.Product == id.Value
+	case "baseboard_product":
+		check.Actual = info.BoardProduct
+		check.Matched = info.BoardProduct == id.Value
+	case "baseboard_manufacturer":
+		check.Actual = info.BoardManufacturer
+		check.Matched = info.BoardManufacturer == id.Value
+	case "bios_version":
+		check.Actual = info.BIOSVersion
+		re, err := regexp.Compile(id.Value)
+		if err != nil {
+			check.Actual = fmt.Sprintf("invalid regex: %v", err)
+			return check
+		}
+		check.Matched = info.BIOSVersion != "" && re.MatchString(info.BIOSVersion)
+	case "pci_devices":
+		check.Expected = strings.Join(id.Devices, ", ")
+		present := make(map[string]bool)
+		for _, dev := range info.PCIDevices {
+			present[dev] = true
+		}
+		var missing []string
+		for _, dev := range id.Devices {
+			if !present[strings.ToLower(dev)] {
+				missing = append(missing, dev)
+			}
+		}
+		if len(missing) > 0 {
+			check.Actual = "missing " + strings.Join(missing, ", ")
+		} else {
+			check.Actual = "all present"
 		}
+		check.Matched = len(id.Devices) > 0 && len(missing) == 0
+	default:
+		check.Actual = "unknown identifier type"
 	}
 
-	// Проверяем результаты прошивки
-	for _, flashResult := range flashResults {
-		if flashResult.Status == "FAILED" {
-			return "failed"
+	return check
+}
+
+// DRAMModule - установленный модуль памяти (dmidecode type 17)
+type DRAMModule struct {
+	Locator      string `yaml:"locator"`
+	BankLocator  string `yaml:"bank_locator,omitempty"`
+	Size         string `yaml:"size"`
+	Type         string `yaml:"type,omitempty"`
+	Speed        string `yaml:"speed,omitempty"`
+	Manufacturer string `yaml:"manufacturer,omitempty"`
+	SerialNumber string `yaml:"serial_number,omitempty"`
+	PartNumber   string `yaml:"part_number,omitempty"`
+}
+
+// StorageDevice - разъем или слот, пригодный для контроллера накопителей (dmidecode type 8/9)
+type StorageDevice struct {
+	Source      string `yaml:"source"` // "port" (type 8) или "slot" (type 9)
+	Designation string `yaml:"designation"`
+	Type        string `yaml:"type,omitempty"`
+	Usage       string `yaml:"usage,omitempty"`
+	BusAddress  string `yaml:"bus_address,omitempty"`
+}
+
+// HardwareInventory - полная выгрузка железа для систем учета (режим -inventory)
+type HardwareInventory struct {
+	SystemInfo `yaml:",inline"`
+
+	BIOSVendor         string          `yaml:"bios_vendor,omitempty"`
+	DIMMs              []DRAMModule    `yaml:"dimms,omitempty"`
+	StorageControllers []StorageDevice `yaml:"storage_controllers,omitempty"`
+}
+
+// parseDMIRecords разбирает вывод dmidecode -t на отдельные записи (в отличие от parseDMIDecode
+// сохраняет повторяющиеся записи одного типа, например все слоты памяти)
+func parseDMIRecords(output string) []map[string]string {
+	var records []map[string]string
+	var current map[string]string
+
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "Handle ") {
+			if current != nil {
+				records = append(records, current)
+			}
+			current = make(map[string]string)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		// Значения атрибутов идут с одним табом, вложенные списки - с двумя
+		if strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "\t\t") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), ":"); ok {
+				current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if current != nil {
+		records = append(records, current)
+	}
+
+	return records
+}
+
+// runDMIDecodeType запускает dmidecode для указанных типов
+func runDMIDecodeType(types ...string) (string, error) {
+	var args []string
+	for _, t := range types {
+		args = append(args, "-t", t)
+	}
+	output, err := tracedOutput(exec.Command("dmidecode", args...))
+	if err != nil {
+		return "", fmt.Errorf("dmidecode %s failed: %v", strings.Join(args, " "), err)
+	}
+	return string(output), nil
+}
+
+// readSysfsDMI читает поле из /sys/class/dmi/id (доступно и без root для большинства полей)
+func readSysfsDMI(name string) string {
+	data, err := os.ReadFile(filepath.Join("/sys/class/dmi/id", name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// collectHardwareInventory собирает SystemInfo и дополнительные данные dmidecode без запуска тестов
+func collectHardwareInventory() (*HardwareInventory, error) {
+	inventory := &HardwareInventory{}
+
+	info, err := getSystemInfo()
+	if err != nil {
+		// Без root dmidecode недоступен - берем основное из sysfs
+		printWarning(fmt.Sprintf("dmidecode unavailable (%v), using /sys/class/dmi/id", err))
+		info.Product = readSysfsDMI("product_name")
+		info.OriginalMBSerial = readSysfsDMI("board_serial")
+		info.BIOSVersion = readSysfsDMI("bios_version")
+		info.BIOSReleaseDate = readSysfsDMI("bios_date")
+		info.BoardVersion = readSysfsDMI("board_version")
+		info.BoardManufacturer = readSysfsDMI("board_vendor")
+		inventory.BIOSVendor = readSysfsDMI("bios_vendor")
+		inventory.SystemInfo = info
+		return inventory, nil
+	}
+	inventory.SystemInfo = info
+
+	if biosInfo, ok := info.DMIDecode["BIOS Information"].(map[string]interface{}); ok {
+		if vendor, ok := biosInfo["Vendor"].(string); ok {
+			inventory.BIOSVendor = vendor
+		}
+	}
+
+	if output, err := runDMIDecodeType("17"); err != nil {
+		printWarning(err.Error())
+	} else {
+		for _, rec := range parseDMIRecords(output) {
+			size := rec["Size"]
+			if size == "" || strings.Contains(size, "No Module Installed") {
+				continue
+			}
+			inventory.DIMMs = append(inventory.DIMMs, DRAMModule{
+				Locator:      rec["Locator"],
+				BankLocator:  rec["Bank Locator"],
+				Size:         size,
+				Type:         rec["Type"],
+				Speed:        rec["Speed"],
+				Manufacturer: rec["Manufacturer"],
+				SerialNumber: rec["Serial Number"],
+				PartNumber:   rec["Part Number"],
+			})
+		}
+	}
+
+	if output, err := runDMIDecodeType("8"); err != nil {
+		printWarning(err.Error())
+	} else {
+		storagePort := regexp.MustCompile(`(?i)sata|sas|scsi|nvme|m\.2|ide`)
+		for _, rec := range parseDMIRecords(output) {
+			designation := rec["Internal Reference Designator"]
+			if designation == "" || designation == "Not Specified" {
+				designation = rec["External Reference Designator"]
+			}
+			portType := rec["Port Type"]
+			if !storagePort.MatchString(portType + " " + designation + " " + rec["Internal Connector Type"]) {
+				continue
+			}
+			inventory.StorageControllers = append(inventory.StorageControllers, StorageDevice{
+				Source:      "port",
+				Designation: designation,
+				Type:        portType,
+			})
+		}
+	}
+
+	if output, err := runDMIDecodeType("9"); err != nil {
+		printWarning(err.Error())
+	} else {
+		for _, rec := range parseDMIRecords(output) {
+			if rec["Current Usage"] != "In Use" {
+				continue
+			}
+			inventory.StorageControllers = append(inventory.StorageControllers, StorageDevice{
+				Source:      "slot",
+				Designation: rec["Designation"],
+				Type:        rec["Type"],
+				Usage:       rec["Current Usage"],
+				BusAddress:  rec["Bus Address"],
+			})
+		}
+	}
+
+	return inventory, nil
+}
+
+// runInventoryMode записывает инвентаризацию в YAML файл и завершает работу
+func runInventoryMode(outputPath string) error {
+	printSectionHeader("HARDWARE INVENTORY")
+
+	inventory, err := collectHardwareInventory()
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(inventory)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %v", err)
+	}
+
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write inventory: %v", err)
+	}
+
+	printSuccess(fmt.Sprintf("Inventory saved: %s (%d DIMM(s), %d storage controller(s))",
+		outputPath, len(inventory.DIMMs), len(inventory.StorageControllers)))
+	return nil
+}
+
+func getIPAddress() (string, error) {
+	cmd := exec.Command("hostname", "-I")
+	output, err := tracedOutput(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	ips := strings.Fields(string(output))
+	if len(ips) > 0 {
+		return ips[0], nil
+	}
+
+	return "", fmt.Errorf("no IP address found")
+}
+
+func parseDMIDecode(output string) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	lines := strings.Split(output, "\n")
+	var currentSection string
+	var currentData map[string]interface{}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		// Check if this is a section header
+		if !strings.HasPrefix(line, "\t") && strings.Contains(line, "Information") {
+			if currentSection != "" && currentData != nil {
+				result[currentSection] = currentData
+			}
+			currentSection = line
+			currentData = make(map[string]interface{})
+			continue
+		}
+
+		// Parse key-value pairs
+		if strings.Contains(line, ":") && currentData != nil {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				key := strings.TrimSpace(parts[0])
+				value := strings.TrimSpace(parts[1])
+				currentData[key] = value
+			}
+		}
+	}
+
+	// Add the last section
+	if currentSection != "" && currentData != nil {
+		result[currentSection] = currentData
+	}
+
+	return result
+}
+
+// Network interface management functions
+func getCurrentNetworkInterfaces() ([]NetworkInterface, error) {
+	return readNetworkInterfaces(sysRunner)
+}
+
+// readNetworkInterfaces читает интерфейсы через runner (pollingRunner() - в обход кэша)
+func readNetworkInterfaces(cmdRunner CommandRunner) ([]NetworkInterface, error) {
+	interfaces, err := readSysfsInterfaces(cmdRunner)
+	if err != nil {
+		// sysfs недоступен - разбираем ip -json addr show, на старом iproute2 - текстовый вывод
+		interfaces, err = readIPAddrInterfaces(cmdRunner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get network interfaces: %v", err)
+		}
+	}
+
+	// Get driver information for each interface
+	for i := range interfaces {
+		if driver, err := getInterfaceDriver(cmdRunner, interfaces[i].Name); err == nil {
+			interfaces[i].Driver = driver
+		}
+	}
+
+	return interfaces, nil
+}
+
+// readSysfsInterfaces читает интерфейсы из /sys/class/net (порядок по ifindex, как у ip),
+// IPv4 адреса берутся одним вызовом ip -o -4 addr show
+func readSysfsInterfaces(cmdRunner CommandRunner) ([]NetworkInterface, error) {
+	names, err := cmdRunner.ReadDir("/sys/class/net")
+	if err != nil {
+		return nil, err
+	}
+
+	type indexed struct {
+		index int
+		iface NetworkInterface
+	}
+	var list []indexed
+
+	for _, name := range names {
+		base := filepath.Join("/sys/class/net", name)
+		iface := NetworkInterface{Name: name}
+
+		index := 0
+		if data, err := cmdRunner.ReadFile(filepath.Join(base, "ifindex")); err == nil {
+			index, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+
+		switch readSysfsValue(filepath.Join(base, "operstate")) {
+		case "up":
+			iface.State = "UP"
+		case "down":
+			iface.State = "DOWN"
+		}
+
+		// Только Ethernet (ARPHRD_ETHER = 1), как link/ether в выводе ip
+		if readSysfsValue(filepath.Join(base, "type")) == "1" {
+			iface.MAC = strings.ToUpper(readSysfsValue(filepath.Join(base, "address")))
+		}
+
+		list = append(list, indexed{index: index, iface: iface})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].index < list[j].index })
+
+	addresses := make(map[string]string)
+	if output, err := cmdRunner.Run("ip", "-json", "-4", "addr", "show"); err == nil {
+		if parsed, err := parseIPAddrJSON(output); err == nil {
+			for _, iface := range parsed {
+				if iface.IP != "" {
+					addresses[iface.Name] = iface.IP
+				}
+			}
+		}
+	}
+	if len(addresses) == 0 {
+		if output, err := cmdRunner.Run("ip", "-o", "-4", "addr", "show"); err == nil {
+			for _, line := range strings.Split(string(output), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) >= 4 && fields[2] == "inet" && !strings.HasPrefix(fields[3], "127.0.0.1") {
+					addresses[strings.TrimSuffix(fields[1], ":")] = strings.Split(fields[3], "/")[0]
+				}
+			}
+		}
+	}
+
+	interfaces := make([]NetworkInterface, 0, len(list))
+	for _, item := range list {
+		item.iface.IP = addresses[item.iface.Name]
+		interfaces = append(interfaces, item.iface)
+	}
+	return interfaces, nil
+}
+
+func readSysfsValue(path string) string {
+	data, err := sysRunner.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// readIPAddrInterfaces читает интерфейсы через ip -json addr show; iproute2 без -json - текстовый разбор
+func readIPAddrInterfaces(cmdRunner CommandRunner) ([]NetworkInterface, error) {
+	if output, err := cmdRunner.Run("ip", "-json", "addr", "show"); err == nil {
+		if interfaces, err := parseIPAddrJSON(output); err == nil {
+			return interfaces, nil
+		}
+	}
+	output, err := cmdRunner.Run("ip", "addr", "show")
+	if err != nil {
+		return nil, err
+	}
+	return parseIPAddrShow(string(output)), nil
+}
+
+// ipAddrJSON - интерфейс в выводе ip -json addr show (только нужные поля)
+type ipAddrJSON struct {
+	IfName    string `json:"ifname"`
+	OperState string `json:"operstate"`
+	LinkType  string `json:"link_type"`
+	Address   string `json:"address"`
+	AddrInfo  []struct {
+		Family string `json:"family"`
+		Local  string `json:"local"`
+	} `json:"addr_info"`
+}
+
+// parseIPAddrJSON разбирает ip -json addr show. VLAN и bond приходят отдельными записями
+// с чистым ifname (без @parent), состояние - из operstate
+func parseIPAddrJSON(output []byte) ([]NetworkInterface, error) {
+	var entries []ipAddrJSON
+	if err := json.Unmarshal(output, &entries); err != nil {
+		return nil, fmt.Errorf("invalid ip -json output: %v", err)
+	}
+
+	interfaces := make([]NetworkInterface, 0, len(entries))
+	for _, entry := range entries {
+		iface := NetworkInterface{Name: entry.IfName}
+		switch entry.OperState {
+		case "UP":
+			iface.State = "UP"
+		case "DOWN":
+			iface.State = "DOWN"
+		}
+		if entry.LinkType == "ether" {
+			iface.MAC = strings.ToUpper(entry.Address)
+		}
+		for _, addr := range entry.AddrInfo {
+			if addr.Family == "inet" && addr.Local != "127.0.0.1" {
+				iface.IP = addr.Local
+				break
+			}
+		}
+		interfaces = append(interfaces, iface)
+	}
+	return interfaces, nil
+}
+
+// parseIPAddrShow разбирает текстовый вывод ip addr show (iproute2 без -json)
+func parseIPAddrShow(output string) []NetworkInterface {
+	var interfaces []NetworkInterface
+
+	lines := strings.Split(output, "\n")
+	var currentInterface *NetworkInterface
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		// Parse interface name and state
+		if strings.Contains(line, ": ") && !strings.HasPrefix(line, " ") {
+			if currentInterface != nil {
+				interfaces = append(interfaces, *currentInterface)
+			}
+
+			// Extract interface name (VLAN: "eth0.100@eth0" - родитель после @ не входит в имя)
+			parts := strings.Split(line, ":")
+			if len(parts) >= 2 {
+				name := strings.SplitN(strings.TrimSpace(parts[1]), "@", 2)[0]
+				currentInterface = &NetworkInterface{Name: name}
+
+				// Extract state
+				if strings.Contains(line, "state UP") {
+					currentInterface.State = "UP"
+				} else if strings.Contains(line, "state DOWN") {
+					currentInterface.State = "DOWN"
+				}
+			}
+		}
+
+		// Parse MAC address
+		if currentInterface != nil && strings.Contains(line, "link/ether") {
+			parts := strings.Fields(line)
+			if len(parts) >= 2 {
+				currentInterface.MAC = strings.ToUpper(parts[1])
+			}
+		}
+
+		// Parse IP address
+		if currentInterface != nil && strings.Contains(line, "inet ") && !strings.Contains(line, "127.0.0.1") {
+			parts := strings.Fields(line)
+			if len(parts) >= 2 {
+				ip := strings.Split(parts[1], "/")[0]
+				currentInterface.IP = ip
+			}
+		}
+	}
+
+	// Add the last interface
+	if currentInterface != nil {
+		interfaces = append(interfaces, *currentInterface)
+	}
+
+	return interfaces
+}
+
+func getInterfaceDriver(cmdRunner CommandRunner, interfaceName string) (string, error) {
+	// Сначала sysfs - не требует запуска процесса
+	driverPath := fmt.Sprintf("/sys/class/net/%s/device/driver", interfaceName)
+	if link, err := cmdRunner.Readlink(driverPath); err == nil {
+		return filepath.Base(link), nil
+	}
+
+	// Fallback: ethtool (виртуальные интерфейсы без device)
+	output, err := cmdRunner.Run("ethtool", "-i", interfaceName)
+	if err == nil {
+		lines := strings.Split(string(output), "\n")
+		for _, line := range lines {
+			if strings.HasPrefix(line, "driver:") {
+				parts := strings.SplitN(line, ":", 2)
+				if len(parts) == 2 {
+					return strings.TrimSpace(parts[1]), nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("driver not found for interface %s", interfaceName)
+}
+
+func getIntelNetworkDrivers() ([]string, error) {
+	printInfo("Detecting Intel network drivers...")
+
+	// Получаем список всех Intel сетевых карт через lspci
+	output, err := sysRunner.Run("lspci", "-nn", "-d", "8086:")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run lspci: %v", err)
+	}
+
+	var drivers []string
+	driverSet := make(map[string]bool) // Для удаления дубликатов
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		// Ищем сетевые контроллеры (Ethernet controller, Network controller)
+		if strings.Contains(strings.ToLower(line), "ethernet") ||
+			strings.Contains(strings.ToLower(line), "network") {
+
+			// Извлекаем PCI адрес (первая часть строки до пробела)
+			parts := strings.Fields(line)
+			if len(parts) == 0 {
+				continue
+			}
+			pciAddr := parts[0]
+
+			// Получаем драйвер для этого устройства
+			driverPath := fmt.Sprintf("/sys/bus/pci/devices/0000:%s/driver", pciAddr)
+			if link, err := sysRunner.Readlink(driverPath); err == nil {
+				driverName := filepath.Base(link)
+				if !driverSet[driverName] {
+					drivers = append(drivers, driverName)
+					driverSet[driverName] = true
+					printInfo(fmt.Sprintf("Found Intel driver: %s (PCI: %s)", driverName, pciAddr))
+				}
+			}
+		}
+	}
+
+	if len(drivers) == 0 {
+		printWarning("No Intel network drivers found, trying common drivers...")
+		// Fallback к общим Intel драйверам
+		commonDrivers := []string{"igb", "e1000e", "ixgbe", "i40e", "ice"}
+		for _, driver := range commonDrivers {
+			// Проверяем, загружен ли драйвер
+			if loaded, err := isModuleLoaded(driver); err == nil && loaded {
+				drivers = append(drivers, driver)
+				printInfo(fmt.Sprintf("Found loaded Intel driver: %s", driver))
+			}
+		}
+	}
+
+	printSuccess(fmt.Sprintf("Detected %d Intel network driver(s)", len(drivers)))
+	return drivers, nil
+}
+
+// allowSpecialMAC разрешает multicast, нулевой и широковещательный MAC (flash.allow_special_mac, только для лабораторий)
+var allowSpecialMAC bool
+
+// macLayouts - допустимая группировка MAC: по 2 цифры через ":" или "-", по 4 через "." (Cisco) или без разделителей
+var macLayouts = []*regexp.Regexp{
+	regexp.MustCompile(`^[0-9A-F]{2}(:[0-9A-F]{2}){5}$`),
+	regexp.MustCompile(`^[0-9A-F]{2}(-[0-9A-F]{2}){5}$`),
+	regexp.MustCompile(`^[0-9A-F]{4}(\.[0-9A-F]{4}){2}$`),
+	regexp.MustCompile(`^[0-9A-F]{12}$`),
+}
+
+// normalizeMAC приводит MAC к виду AA:BB:CC:DD:EE:FF.
+// Разделители: ":", "-", "." (формат Cisco aabb.ccdd.eeff) или без разделителей, группировка проверяется по macLayouts.
+// Не 12 hex-цифр, multicast, 00:00:00:00:00:00 и FF:FF:FF:FF:FF:FF - ошибка
+func normalizeMAC(mac string) (string, error) {
+	// Remove any separators and convert to uppercase
+	clean := strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac))
+	clean = strings.ToUpper(clean)
+
+	if len(clean) != 12 {
+		return "", fmt.Errorf("invalid MAC address %q: expected 12 hex digits, got %d", mac, len(clean))
+	}
+	raw, err := hex.DecodeString(clean)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address %q: not a hex value", mac)
+	}
+	grouped := false
+	for _, layout := range macLayouts {
+		grouped = grouped || layout.MatchString(strings.ToUpper(strings.TrimSpace(mac)))
+	}
+	if !grouped {
+		return "", fmt.Errorf("invalid MAC address %q: expected XX:XX:XX:XX:XX:XX, XX-XX-XX-XX-XX-XX, XXXX.XXXX.XXXX or 12 hex digits", mac)
+	}
+
+	if !allowSpecialMAC {
+		switch {
+		case clean == "000000000000":
+			return "", fmt.Errorf("invalid MAC address %q: all-zero address", mac)
+		case clean == "FFFFFFFFFFFF":
+			return "", fmt.Errorf("invalid MAC address %q: broadcast address", mac)
+		case raw[0]&0x01 != 0:
+			return "", fmt.Errorf("invalid MAC address %q: multicast address (bit 0 of the first octet is set)", mac)
+		}
+	}
+
+	// Add colons in standard format
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s",
+		clean[0:2], clean[2:4], clean[4:6], clean[6:8], clean[8:10], clean[10:12]), nil
+}
+
+func isTargetMACPresent(targetMAC string, interfaces []NetworkInterface) (bool, string) {
+	normalizedTarget, err := normalizeMAC(targetMAC)
+	if err != nil {
+		printWarning(fmt.Sprintf("MAC presence check skipped: %v", err))
+		return false, ""
+	}
+
+	for _, iface := range interfaces {
+		// Интерфейсы без настоящего MAC (туннели, нулевой адрес) совпасть с целевым не могут
+		if mac, err := normalizeMAC(iface.MAC); err == nil && mac == normalizedTarget {
+			return true, iface.Name
+		}
+	}
+
+	return false, ""
+}
+
+func askFlashRetryAction(message string) string {
+	defer sessionTimer.operatorWait()()
+
+	fmt.Printf("\n%s%s%s\n", ColorRed, tr("mac_flash_error.title"), ColorReset)
+	fmt.Printf("%s\n", message)
+	fmt.Println(tr("prompt.choose_action"))
+	fmt.Printf("  %s[Y]%s %s\n", ColorGreen, ColorReset, tr("mac_flash_error.retry"))
+	fmt.Printf("  %s[A]%s %s\n", ColorYellow, ColorReset, tr("mac_flash_error.abort"))
+	fmt.Printf("  %s[S]%s %s\n", ColorBlue, ColorReset, tr("mac_flash_error.skip"))
+	fmt.Printf("Choice [Y/a/s]: ")
+
+	reader := bufio.NewReader(operatorInput())
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return "RETRY" // default on error
+	}
+
+	choice := strings.ToUpper(strings.TrimSpace(input))
+	if choice == "" {
+		choice = "Y" // default
+	}
+
+	switch choice {
+	case "Y", "YES":
+		return "RETRY"
+	case "A", "ABORT":
+		return "ABORT"
+	case "S", "SKIP":
+		return "SKIP"
+	default:
+		fmt.Println(tr("prompt.invalid_choice", choice))
+		return "RETRY"
+	}
+}
+
+// checkMACs проверяет по истории MAC, которые получат карты, до начала прошивки (nil - без проверки)
+func flashMAC(flashConfig FlashConfig, systemConfig SystemConfig, mac string, checkMACs func(macs []string) error) (*FlashMACSummary, error) {
+	method := flashConfig.Method
+
+	// Утилиты прошивки и incrementMAC ждут AA:BB:CC:DD:EE:FF
+	mac, err := normalizeMAC(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	// Step 1: Get current network interfaces and save original MACs
+	interfaces, err := getCurrentNetworkInterfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network interfaces: %v", err)
+	}
+
+	if method == "" || method == "auto" {
+		detected, err := detectFlashMethod(interfaces)
+		if err != nil {
+			return nil, fmt.Errorf("failed to auto-detect flash method: %v", err)
+		}
+		method = detected
+		printInfo(fmt.Sprintf("Auto-detected flash method: %s", method))
+	}
+
+	printSubHeader("MAC ADDRESS FLASHING", fmt.Sprintf("Method: %s | Target MAC: %s", method, mac))
+
+	// Log original MAC addresses before flashing
+	printInfo("Original MAC addresses before flashing:")
+	for _, iface := range interfaces {
+		if iface.MAC != "" && iface.Name != "lo" {
+			printInfo(fmt.Sprintf("  %s: %s [%s]", iface.Name, iface.MAC, iface.Driver))
+		}
+	}
+
+	// Step 2: Check if target MAC already exists
+	exists, interfaceName := isTargetMACPresent(mac, interfaces)
+	if exists {
+		printSuccess(fmt.Sprintf("Target MAC %s already present on interface %s - skipping flash", mac, interfaceName))
+		return nil, nil
+	}
+
+	// Step 3: Show current network state
+	fmt.Printf("\nCurrent network interfaces:\n")
+	for _, iface := range interfaces {
+		status := "DOWN"
+		if iface.State == "UP" {
+			status = fmt.Sprintf("UP (IP: %s)", iface.IP)
+		}
+		fmt.Printf("  %s: %s [%s] - %s\n", iface.Name, iface.MAC, iface.Driver, status)
+	}
+
+	// Step 4: Execute flashing based on method
+	var summary FlashMACSummary
+	summary.Method = method
+	summary.TargetMAC = mac
+
+	switch method {
+	case "rtnicpg":
+		err = flashMACWithRtnicpg(mac, interfaces, systemConfig, &summary)
+	case "eeupdate":
+		err = flashMACWithEeupdate(mac, interfaces, flashConfig, systemConfig, &summary, checkMACs)
+	default:
+		return nil, fmt.Errorf("unknown flash method: %s", method)
+	}
+
+	// Драйверы после перезагрузки модулей - и при неудаче, именно тогда они нужнее всего
+	if summary.Driver != nil {
+		if after, ierr := readNetworkInterfaces(pollingRunner()); ierr == nil {
+			summary.Driver.After = interfaceDrivers(after, driverModuleNames(summary.Driver))
+		}
+	}
+
+	if err != nil {
+		return &summary, fmt.Errorf("MAC flashing failed: %v", err)
+	}
+
+	if summary.Success {
+		printSuccess(fmt.Sprintf("MAC address flashed successfully using %s method", method))
+	}
+
+	// Коммутатор помнит старый MAC для этого IP - объявляем новый, иначе пакеты теряются до истечения ARP
+	if summary.IPRestored && (flashConfig.SendGratuitousARP == nil || *flashConfig.SendGratuitousARP) {
+		ip := strings.SplitN(summary.OriginalIP, "/", 2)[0]
+		var err error
+		summary.GratuitousARP, err = sendGratuitousARP(summary.InterfaceName, ip)
+		if err != nil {
+			summary.GratuitousARPError = err.Error()
+			printWarning(fmt.Sprintf("Gratuitous ARP for %s on %s failed: %v", ip, summary.InterfaceName, err))
+		}
+	}
+
+	if summary.IPRestored && flashConfig.VerifyConnectivity {
+		verifyFlashedConnectivity(&summary)
+	}
+
+	return &summary, nil
+}
+
+// verifyFlashedConnectivity пингует шлюз по умолчанию с интерфейса с новым MAC и отмечает результат в сводке.
+// Недоступный шлюз не проваливает прошивку, но попадает в лог (FlashResult.Network)
+func verifyFlashedConnectivity(summary *FlashMACSummary) {
+	gateway, err := defaultGateway(summary.InterfaceName)
+	if err == nil {
+		summary.GatewayIP = gateway
+		err = verifyNetworkConnectivity(summary.InterfaceName, gateway, 10*time.Second)
+	}
+	if err != nil {
+		summary.GatewayError = err.Error()
+		printWarning(fmt.Sprintf("Network connectivity check on %s failed: %v", summary.InterfaceName, err))
+		return
+	}
+	summary.NetworkReachable = true
+	printSuccess(fmt.Sprintf("Gateway %s reachable via %s", gateway, summary.InterfaceName))
+}
+
+// macNetworkCheck - состояние сети после прошивки MAC для лога; nil, если у интерфейса не было IP
+// и MAC не найден ни на одном интерфейсе
+func macNetworkCheck(summary *FlashMACSummary) *MACNetworkCheck {
+	if summary == nil || (summary.InterfaceName == "" && summary.OriginalIP == "") {
+		return nil
+	}
+	check := &MACNetworkCheck{
+		Interface:          summary.InterfaceName,
+		IPRestoreError:     summary.IPRestoreError,
+		GratuitousARP:      summary.GratuitousARP,
+		GratuitousARPError: summary.GratuitousARPError,
+		Gateway:            summary.GatewayIP,
+		GatewayError:       summary.GatewayError,
+	}
+	if summary.NetworkReachable || summary.GatewayError != "" {
+		reachable := summary.NetworkReachable
+		check.GatewayReachable = &reachable
+	}
+	if summary.OriginalIP != "" {
+		check.OriginalIP = summary.OriginalIPCIDR
+		if check.OriginalIP == "" {
+			check.OriginalIP = summary.OriginalIP
+		}
+		restored := summary.IPRestored
+		check.IPRestored = &restored
+	}
+	if summary.OriginalDefaultRoute != "" {
+		check.DefaultRoute = summary.OriginalDefaultRoute
+		restored := summary.RouteRestored
+		check.RouteRestored = &restored
+		check.RouteRestoreError = summary.RouteRestoreError
+	}
+	return check
+}
+
+// collectDriverContext собирает версии утилиты прошивки, модулей и драйверов интерфейсов,
+// которые они обслуживают. Ничего не меняет и не прерывает прошивку при недоступных данных.
+func collectDriverContext(tool toolVersionCommand, modules []string, interfaces []NetworkInterface) *DriverContext {
+	dc := &DriverContext{Tool: tool.Tool, ToolVersion: "unknown"}
+	if version, err := queryToolVersion(tool); err == nil {
+		dc.ToolVersion = version
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range modules {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		module := ModuleVersion{Name: name, Version: "unknown", SrcVersion: "unknown"}
+		if output, err := sysRunner.Run("modinfo", name); err == nil {
+			module.Version, module.SrcVersion = parseModinfo(string(output))
+		}
+		dc.Modules = append(dc.Modules, module)
+	}
+
+	dc.Before = interfaceDrivers(interfaces, driverModuleNames(dc))
+	return dc
+}
+
+// driverModuleNames - имена модулей контекста (для выбора интерфейсов)
+func driverModuleNames(dc *DriverContext) map[string]bool {
+	names := make(map[string]bool)
+	for _, m := range dc.Modules {
+		names[m.Name] = true
+	}
+	return names
+}
+
+// interfaceDrivers возвращает ethtool -i интерфейсов, чей драйвер входит в modules
+func interfaceDrivers(interfaces []NetworkInterface, modules map[string]bool) []InterfaceDriver {
+	var result []InterfaceDriver
+	for _, iface := range interfaces {
+		if iface.Name == "lo" || !modules[iface.Driver] {
+			continue
+		}
+		info := InterfaceDriver{Interface: iface.Name, Driver: iface.Driver, Version: "unknown", FirmwareVersion: "unknown"}
+		if output, err := sysRunner.Run("ethtool", "-i", iface.Name); err == nil {
+			info = parseEthtoolInfo(iface.Name, string(output))
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// parseModinfo извлекает version и srcversion из вывода modinfo (у in-tree модулей version часто нет)
+func parseModinfo(output string) (version, srcversion string) {
+	version, srcversion = "unknown", "unknown"
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "srcversion":
+			srcversion = value
+		}
+	}
+	return version, srcversion
+}
+
+// parseEthtoolInfo разбирает ethtool -i: driver, version, firmware-version, bus-info
+func parseEthtoolInfo(iface, output string) InterfaceDriver {
+	info := InterfaceDriver{Interface: iface, Driver: "unknown", Version: "unknown", FirmwareVersion: "unknown"}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == "" || value == "N/A" {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "driver":
+			info.Driver = value
+		case "version":
+			info.Version = value
+		case "firmware-version":
+			info.FirmwareVersion = value
+		case "bus-info":
+			info.BusInfo = value
+		}
+	}
+	return info
+}
+
+// probePlanDrivers дополняет операции mac в плане драйверами, которые стоят в образе,
+// чтобы проверить конфиг против набора драйверов без прошивки
+func probePlanDrivers(report *ExecutionPlan, method string) {
+	for i := range report.Steps {
+		for j := range report.Steps[i].Flash {
+			op := &report.Steps[i].Flash[j]
+			if op.Operation != "mac" {
+				continue
+			}
+			interfaces, err := getCurrentNetworkInterfaces()
+			if err != nil {
+				continue
+			}
+			resolved := method
+			if resolved == "" || resolved == "auto" {
+				if resolved, err = detectFlashMethod(interfaces); err != nil {
+					resolved = "eeupdate"
+				}
+			}
+
+			tool := eeupdateVersionCommand()
+			var modules []string
+			if resolved == "rtnicpg" {
+				tool = rtnicVersionCommand
+				for _, iface := range interfaces {
+					if isRealtekDriver(iface.Driver) {
+						modules = append(modules, iface.Driver)
+					}
+				}
+				modules = append(modules, "pgdrv")
+			} else {
+				for _, iface := range interfaces {
+					if isIntelDriver(iface.Driver) {
+						modules = append(modules, iface.Driver)
+					}
+				}
+			}
+			op.Drivers = collectDriverContext(tool, modules, interfaces)
+			report.HardwareQueried = true
+			report.HardwareQueries = append(report.HardwareQueries, "read-only driver probe for mac (modinfo, ethtool -i)")
+		}
+	}
+}
+
+// formatDriverContext - краткая строка для Details результата прошивки
+func formatDriverContext(dc *DriverContext) string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("%s %s", dc.Tool, dc.ToolVersion))
+	for _, m := range dc.Modules {
+		parts = append(parts, fmt.Sprintf("%s %s (srcversion %s)", m.Name, m.Version, m.SrcVersion))
+	}
+	return "drivers: " + strings.Join(parts, ", ")
+}
+
+// defaultGateway возвращает шлюз маршрута по умолчанию через интерфейс (ip route show dev <iface> default)
+func defaultGateway(iface string) (string, error) {
+	output, err := tracedOutput(exec.Command("ip", "route", "show", "dev", iface, "default"))
+	if err != nil {
+		return "", fmt.Errorf("ip route failed: %v", err)
+	}
+	fields := strings.Fields(string(output))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "via" {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no default route via %s", iface)
+}
+
+// verifyNetworkConnectivity пингует шлюз с интерфейса после смены MAC
+func verifyNetworkConnectivity(iface, gateway string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	output, err := tracedCombinedOutput(exec.CommandContext(ctx, "ping", "-c", "3", "-W", "2", "-I", iface, gateway))
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("ping %s timed out after %s", gateway, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("gateway %s unreachable: %v\nOutput: %s", gateway, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Исход рассылки gratuitous ARP после прошивки MAC
+const (
+	arpSent    = "sent"
+	arpSkipped = "skipped" // arping нет в PATH
+	arpFailed  = "failed"
+)
+
+// sendGratuitousARP рассылает unsolicited ARP (arping -U). Без arping в PATH пропускает (arpSkipped без ошибки)
+func sendGratuitousARP(iface, ip string) (string, error) {
+	if _, err := exec.LookPath("arping"); err != nil {
+		printInfo("arping not found - gratuitous ARP skipped")
+		return arpSkipped, nil
+	}
+
+	output, err := tracedCombinedOutput(exec.Command("arping", "-U", "-I", iface, "-c", "3", ip))
+	if err != nil {
+		return arpFailed, fmt.Errorf("%v\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	printSuccess(fmt.Sprintf("Gratuitous ARP sent for %s on %s", ip, iface))
+	return arpSent, nil
+}
+
+// MACUniquenessResult - результат поиска прошитого MAC у других станций подсети
+type MACUniquenessResult struct {
+	Status    string // clean, duplicate или skipped
+	Interface string
+	Range     string
+	Conflicts []string // IP адреса, ответившие с тем же MAC
+	Reason    string   // Почему проверка пропущена
+}
+
+func arpScanTimeout(config FlashConfig) time.Duration {
+	if config.ArpScanTimeout != "" {
+		if t, err := time.ParseDuration(config.ArpScanTimeout); err == nil && t > 0 {
+			return t
+		}
+		printWarning(fmt.Sprintf("Invalid arp_scan_timeout %q, using 5s", config.ArpScanTimeout))
+	}
+	return 5 * time.Second
+}
+
+// linkLocalAddress строит временный адрес 169.254.x.y из двух последних байт MAC
+func linkLocalAddress(mac net.HardwareAddr) string {
+	x, y := mac[len(mac)-2], mac[len(mac)-1]
+	// 169.254.0.0/24 и 169.254.255.0/24 зарезервированы (RFC 3927)
+	if x == 0 || x == 255 {
+		x = 1
+	}
+	return fmt.Sprintf("169.254.%d.%d", x, y)
+}
+
+// arpScanHosts возвращает адреса /24 вокруг ip: более широкие подсети не успеть опросить за разумное время
+func arpScanHosts(ip net.IP) (string, []net.IP) {
+	base := ip.To4().Mask(net.CIDRMask(24, 32))
+	var hosts []net.IP
+	for i := 1; i < 255; i++ {
+		host := net.IPv4(base[0], base[1], base[2], byte(i)).To4()
+		if !host.Equal(ip) {
+			hosts = append(hosts, host)
+		}
+	}
+	return fmt.Sprintf("%s/24", base), hosts
+}
+
+var arpingReplyRegex = regexp.MustCompile(`reply from (\S+) \[([0-9A-Fa-f:]{17})\]`)
+
+// scanMACUniqueness ищет в подсети другую станцию с тем же MAC (дубликаты этикеток).
+// UDP датаграммы на все адреса /24 заставляют ядро разрешить их через ARP, после чего в таблице соседей
+// ищутся записи с нашим MAC. Если arping есть, дополнительно выполняется arping -D для своего адреса.
+// Интерфейс без адреса получает временный link-local, который удаляется после проверки.
+func scanMACUniqueness(mac string, timeout time.Duration) MACUniquenessResult {
+	result := MACUniquenessResult{Status: "skipped"}
+
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		result.Reason = fmt.Sprintf("invalid MAC %s: %v", mac, err)
+		return result
+	}
+
+	interfaces, err := readNetworkInterfaces(pollingRunner())
+	if err != nil {
+		result.Reason = err.Error()
+		return result
+	}
+	var iface *NetworkInterface
+	for i := range interfaces {
+		if strings.EqualFold(interfaces[i].MAC, mac) {
+			iface = &interfaces[i]
+			break
+		}
+	}
+	if iface == nil {
+		result.Reason = fmt.Sprintf("no interface carries MAC %s", mac)
+		return result
+	}
+	result.Interface = iface.Name
+	if iface.State != "UP" {
+		result.Reason = fmt.Sprintf("interface %s is down", iface.Name)
+		return result
+	}
+
+	ip := iface.IP
+	if ip == "" {
+		ip = linkLocalAddress(hw)
+		cidr := ip + "/16"
+		if output, err := tracedCombinedOutput(exec.Command("ip", "addr", "add", cidr, "dev", iface.Name)); err != nil {
+			result.Reason = fmt.Sprintf("failed to assign temporary address %s: %v (%s)", cidr, err, strings.TrimSpace(string(output)))
+			return result
+		}
+		printInfo(fmt.Sprintf("Assigned temporary link-local address %s to %s", cidr, iface.Name))
+		defer func() {
+			if output, err := tracedCombinedOutput(exec.Command("ip", "addr", "del", cidr, "dev", iface.Name)); err != nil {
+				printWarning(fmt.Sprintf("Failed to remove temporary address %s from %s: %v (%s)", cidr, iface.Name, err, strings.TrimSpace(string(output))))
+			} else {
+				printInfo(fmt.Sprintf("Temporary address %s removed from %s", cidr, iface.Name))
+			}
+		}()
+	}
+
+	localIP := net.ParseIP(ip).To4()
+	if localIP == nil {
+		result.Reason = fmt.Sprintf("interface %s has no usable IPv4 address", iface.Name)
+		return result
+	}
+	rangeName, hosts := arpScanHosts(localIP)
+	result.Range = rangeName
+
+	printInfo(fmt.Sprintf("Scanning %s via %s for other stations using MAC %s (%s)...", rangeName, iface.Name, mac, timeout))
+	deadline := time.Now().Add(timeout)
+
+	for _, host := range hosts {
+		if time.Now().After(deadline) {
+			printWarning("ARP scan time budget exhausted before all probes were sent")
+			break
+		}
+		// Порт discard: ответ не нужен, важен только ARP запрос перед отправкой
+		conn, err := net.DialUDP("udp4", &net.UDPAddr{IP: localIP}, &net.UDPAddr{IP: host, Port: 9})
+		if err != nil {
+			continue
+		}
+		conn.Write([]byte{0})
+		conn.Close()
+	}
+
+	conflicts := make(map[string]bool)
+	if _, err := exec.LookPath("arping"); err == nil {
+		wait := int(time.Until(deadline).Seconds())
+		if wait < 1 {
+			wait = 1
+		}
+		// arping -D завершается с кодом 1, если кто-то ответил за наш адрес
+		output, _ := tracedCombinedOutput(exec.Command("arping", "-D", "-I", iface.Name, "-c", "2", "-w", strconv.Itoa(wait), ip))
+		for _, match := range arpingReplyRegex.FindAllStringSubmatch(string(output), -1) {
+			if strings.EqualFold(match[2], mac) {
+				conflicts[match[1]] = true
+			} else {
+				printWarning(fmt.Sprintf("Address %s is also used by %s", match[1], strings.ToUpper(match[2])))
+			}
+		}
+	} else if remaining := time.Until(deadline); remaining > 0 {
+		time.Sleep(remaining)
+	}
+
+	output, err := tracedOutput(exec.Command("ip", "neigh", "show", "dev", iface.Name))
+	if err != nil {
+		result.Reason = fmt.Sprintf("failed to read neighbor table: %v", err)
+		return result
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		for i := 1; i+1 < len(fields); i++ {
+			if fields[i] == "lladdr" && strings.EqualFold(fields[i+1], mac) {
+				conflicts[fields[0]] = true
+			}
+		}
+	}
+
+	for conflictIP := range conflicts {
+		result.Conflicts = append(result.Conflicts, conflictIP)
+	}
+	sort.Strings(result.Conflicts)
+
+	if len(result.Conflicts) > 0 {
+		result.Status = "duplicate"
+	} else {
+		result.Status = "clean"
+	}
+	return result
+}
+
+// printMACConflictBanner - красный баннер: MAC уже используется другой станцией
+func printMACConflictBanner(mac string, conflicts []string) {
+	line := strings.Repeat(" ", 80)
+	fmt.Printf("\n%s%s%s\n", ColorBgRed, line, ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, fmt.Sprintf("  !!! DUPLICATE MAC %s DETECTED ON THE NETWORK !!!", mac), ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, fmt.Sprintf("  Answering from: %s", strings.Join(conflicts, ", ")), ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, "  QUARANTINE BOTH UNITS and report the duplicate label.", ColorReset)
+	fmt.Printf("%s%s%s\n\n", ColorBgRed, line, ColorReset)
+}
+
+// rescanPCIBus пишет "1" в файл пересканирования PCI и ждет перечисления устройств
+func rescanPCIBus(path string) error {
+	if path == "" {
+		path = "/sys/bus/pci/rescan"
+	}
+
+	printInfo(fmt.Sprintf("Rescanning PCI bus via %s...", path))
+	if err := os.WriteFile(path, []byte("1"), 0200); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	time.Sleep(2 * time.Second)
+
+	invalidateSystemCache()
+	printSuccess("PCI bus rescan completed")
+	return nil
+}
+
+// eeupdateSearchPaths - system.eeupdate_search_paths
+var eeupdateSearchPaths []string
+
+// needsEeupdate - операции, которые работают через Intel eeupdate
+func needsEeupdate(config FlashConfig) bool {
+	if hasFlashOperation(config, "nic-checksum") {
+		return true
+	}
+	return hasFlashOperation(config, "mac") && resolveFlashMethod(config.Method) != "rtnicpg"
+}
+
+// eeupdateBinaryNames возвращает имена сборок eeupdate для архитектуры uname -m.
+// Неизвестная архитектура - обе сборки, сначала 64-битная
+func eeupdateBinaryNames(machine string) []string {
+	switch machine {
+	case "x86_64", "amd64", "aarch64", "arm64":
+		return []string{"eeupdate64e"}
+	case "i386", "i486", "i586", "i686", "x86":
+		return []string{"eeupdate32e"}
+	default:
+		return []string{"eeupdate64e", "eeupdate32e"}
+	}
+}
+
+// selectEeupdateBinary ищет сборку eeupdate под архитектуру станции: сначала в searchPaths, затем в PATH
+func selectEeupdateBinary(searchPaths []string) (string, error) {
+	machine := ""
+	if output, err := tracedOutput(exec.Command("uname", "-m")); err == nil {
+		machine = strings.TrimSpace(string(output))
+	}
+	names := eeupdateBinaryNames(machine)
+
+	for _, name := range names {
+		for _, dir := range searchPaths {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode()&0111 != 0 {
+				return path, nil
+			}
+		}
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	if machine == "" {
+		machine = "unknown"
+	}
+	return "", fmt.Errorf("eeupdate not found for architecture %s (looked for %s in system.eeupdate_search_paths and PATH)",
+		machine, strings.Join(names, ", "))
+}
+
+// newEeupdateClient создает клиента для найденной сборки eeupdate
+func newEeupdateClient() (*EeupdateClient, error) {
+	binary, err := selectEeupdateBinary(eeupdateSearchPaths)
+	if err != nil {
+		return nil, err
+	}
+	printDebug(fmt.Sprintf("Using eeupdate binary: %s", binary))
+	return &EeupdateClient{BinaryPath: binary}, nil
+}
+
+// EeupdateClient - запуск Intel eeupdate64e/eeupdate32e с единым разбором кодов выхода.
+// Каталог утилиты задается через cmd.Dir, текущий каталог процесса не меняется.
+type EeupdateClient struct {
+	BinaryPath string // Путь к eeupdate (newEeupdateClient выбирает по архитектуре; пусто - eeupdate64e из PATH)
+	WorkDir    string // Рабочий каталог утилиты (пусто - текущий)
+}
+
+// run выполняет eeupdate64e. Код выхода 2 (нет драйвера) не считается ошибкой - утилита работает и без него.
+func (c *EeupdateClient) run(args ...string) (string, int, error) {
+	binary := c.BinaryPath
+	if binary == "" {
+		binary = "eeupdate64e"
+	}
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = c.WorkDir
+	output, err := tracedCombinedOutput(cmd)
+	outputStr := string(output)
+
+	if err != nil {
+		exitError, ok := err.(*exec.ExitError)
+		if !ok {
+			// Non-ExitError (like command not found)
+			return outputStr, -1, err
+		}
+		if exitError.ExitCode() != 2 {
+			return outputStr, exitError.ExitCode(), err
+		}
+		return outputStr, 2, nil
+	}
+	return outputStr, 0, nil
+}
+
+// Discover находит Intel NIC (eeupdate64e /MAC_DUMP_ALL) с необязательным фильтром vendor-device
+func (c *EeupdateClient) Discover(venDeviceFilter []string) ([]IntelNIC, error) {
+	printInfo("Discovering Intel network cards...")
+
+	outputStr, exitCode, err := c.run("/MAC_DUMP_ALL")
+	if err != nil {
+		if exitCode > 0 {
+			return nil, fmt.Errorf("eeupdate64e discovery failed with exit code %d: %v\nOutput: %s", exitCode, err, outputStr)
+		}
+		return nil, fmt.Errorf("eeupdate64e discovery failed: %v\nOutput: %s", err, outputStr)
+	}
+	if exitCode == 2 {
+		// Exit code 2 usually means no driver found, but utility can still work
+		printInfo("eeupdate64e reports no driver (exit code 2), but continuing...")
+	}
+
+	// Parse output to find NIC indices regardless of exit code
+	var allNICs []IntelNIC
+	lines := strings.Split(outputStr, "\n")
+
+	for _, line := range lines {
+		// Parse lines with device IDs (8086-XXXX format indicates Intel)
+		if strings.Contains(line, "8086-") {
+			fields := strings.Fields(line)
+			if len(fields) >= 5 {
+				// First field should be NIC index
+				nicIndex, err := strconv.Atoi(fields[0])
+				if err != nil {
+					continue
+				}
+
+				// Extract vendor-device ID (format: 8086-1521)
+				venDevice := fields[4]
+				description := strings.Join(fields[5:], " ")
+
+				nic := IntelNIC{
+					Index:        nicIndex,
+					VendorDevice: venDevice,
+					Description:  description,
+					PCIAddress:   fmt.Sprintf("%s:%s.%s", fields[1], fields[2], fields[3]),
+				}
+
+				allNICs = append(allNICs, nic)
+				printInfo(fmt.Sprintf("Found Intel NIC %d: %s (%s)", nicIndex, venDevice, description))
+			}
+		}
+	}
+
+	if len(allNICs) == 0 {
+		// If no NICs found in parsing, but we got output, try common indices
+		if len(outputStr) > 100 { // Substantial output suggests NICs might be there
+			printInfo("No NICs found in parsing, but substantial output detected. Trying common indices...")
+			for i := 1; i <= 6; i++ {
+				allNICs = append(allNICs, IntelNIC{Index: i, VendorDevice: "unknown", Description: "Unknown Intel NIC"})
+			}
+		} else {
+			return nil, fmt.Errorf("no Intel network cards found in output")
+		}
+	}
+
+	// Apply vendor-device filter if specified
+	var filteredNICs []IntelNIC
+	if len(venDeviceFilter) > 0 {
+		printInfo(fmt.Sprintf("Applying vendor-device filter: %s", strings.Join(venDeviceFilter, ", ")))
+		for _, nic := range allNICs {
+			for _, filter := range venDeviceFilter {
+				if nic.VendorDevice == filter {
+					filteredNICs = append(filteredNICs, nic)
+					printInfo(fmt.Sprintf("NIC %d matches filter %s", nic.Index, filter))
+					break
+				}
+			}
+		}
+		if len(filteredNICs) == 0 {
+			return nil, fmt.Errorf("no NICs match the specified vendor-device filter: %s", strings.Join(venDeviceFilter, ", "))
+		}
+	} else {
+		filteredNICs = allNICs
+	}
+
+	printSuccess(fmt.Sprintf("Discovery completed: found %d Intel NIC(s) (after filtering)", len(filteredNICs)))
+	return filteredNICs, nil
+}
+
+// incrementMAC increases MAC address by 1 (handles hexadecimal arithmetic)
+func incrementMAC(mac string) (string, error) {
+	// Split MAC address into bytes
+	parts := strings.Split(mac, ":")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("invalid MAC address format: %s", mac)
+	}
+
+	// Convert the last byte to an integer, increment it, and convert back
+	lastByte, err := strconv.ParseUint(parts[5], 16, 8)
+	if err != nil {
+		return "", fmt.Errorf("invalid MAC address byte: %s", parts[5])
+	}
+
+	// Increment with overflow handling
+	lastByte = (lastByte + 1) % 256
+
+	// If the last byte overflows, increment the previous byte
+	if lastByte == 0 {
+		fifthByte, err := strconv.ParseUint(parts[4], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid MAC address byte: %s", parts[4])
+		}
+		fifthByte = (fifthByte + 1) % 256
+		parts[4] = fmt.Sprintf("%02x", fifthByte)
+	}
+
+	// Update the last byte
+	parts[5] = fmt.Sprintf("%02x", lastByte)
+
+	// Join parts back together
+	return strings.Join(parts, ":"), nil
+}
+
+// FlashMAC записывает MAC в NVM карты с индексом nicIndex (eeupdate64e /NIC=N /MAC=...)
+func (c *EeupdateClient) FlashMAC(nicIndex int, targetMAC string) error {
+	cleanMac := strings.ReplaceAll(targetMAC, ":", "")
+
+	printInfo(fmt.Sprintf("Executing eeupdate flashing for NIC %d, MAC: %s", nicIndex, targetMAC))
+
+	// Execute eeupdate64e with NIC and MAC parameters
+	outputStr, exitCode, err := c.run(fmt.Sprintf("/NIC=%d", nicIndex), fmt.Sprintf("/MAC=%s", cleanMac))
+	if err != nil {
+		// Other exit codes might be more serious
+		printError(fmt.Sprintf("eeupdate64e failed with exit code %d for NIC %d", exitCode, nicIndex))
+		printError(fmt.Sprintf("Output: %s", outputStr))
+		return fmt.Errorf("eeupdate64e command failed with exit code %d: %v", exitCode, err)
+	}
+	if exitCode == 2 {
+		// Exit code 2 usually means no driver, but flashing might still work
+		printInfo(fmt.Sprintf("eeupdate64e reports no driver (exit code 2) for NIC %d, checking output for success...", nicIndex))
+	}
+
+	// Check output for success/failure indicators regardless of exit code
+	outputLower := strings.ToLower(outputStr)
+
+	// Look for specific success patterns from eeupdate
+	if strings.Contains(outputStr, "Updating Mac Address") && strings.Contains(outputStr, "Done") {
+		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
+		return nil
+	}
+
+	if strings.Contains(outputStr, "Updating Checksum and CRCs") && strings.Contains(outputStr, "Done") {
+		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
+		return nil
+	}
+
+	// Other positive indicators
+	if strings.Contains(outputLower, "success") ||
+		strings.Contains(outputLower, "complete") ||
+		strings.Contains(outputLower, "updated") ||
+		strings.Contains(outputLower, "written") {
+		printSuccess(fmt.Sprintf("eeupdate flashing completed for NIC %d", nicIndex))
+		return nil
+	}
+
+	// Negative indicators (but exclude our own error headers)
+	if (strings.Contains(outputLower, "error") && !strings.Contains(outputLower, "mac flashing error")) ||
+		strings.Contains(outputLower, "fail") ||
+		strings.Contains(outputLower, "invalid") {
+		return fmt.Errorf("eeupdate reported error for NIC %d (exit code %d): %s", nicIndex, exitCode, outputStr)
+	}
+
+	// If no clear indicators but we got substantial output, assume it worked
+	if len(outputStr) > 50 && exitCode == 0 {
+		printSuccess(fmt.Sprintf("eeupdate command completed for NIC %d", nicIndex))
+		return nil
+	}
+
+	// If exit code 2 but minimal output, still try to continue
+	if exitCode == 2 {
+		printInfo(fmt.Sprintf("eeupdate completed for NIC %d with driver warning (exit code 2)", nicIndex))
+		return nil
+	}
+
+	// Default case - if we get here, status is unclear
+	printInfo(fmt.Sprintf("eeupdate command status unclear for NIC %d (exit code %d), assuming success", nicIndex, exitCode))
+	return nil
+}
+
+// defaultChecksumFixArgs - пересчет контрольной суммы и CRC в NVM без изменения MAC
+var defaultChecksumFixArgs = []string{"/CALCCHKSUM"}
+
+// checksumFixArgs - аргументы nic-checksum из flash.checksum_fix_args (у разных версий eeupdate флаг отличается)
+func checksumFixArgs(config FlashConfig) []string {
+	if len(config.ChecksumFixArgs) > 0 {
+		return config.ChecksumFixArgs
+	}
+	return defaultChecksumFixArgs
+}
+
+var (
+	// Сообщения eeupdate о неверной сумме до исправления (формулировка зависит от версии)
+	checksumInvalidRegex = regexp.MustCompile(`(?i)checksum (is )?(not valid|invalid|bad|mismatch)|bad checksum|invalid checksum`)
+	// Признаки записи новой суммы
+	checksumUpdatedRegex = regexp.MustCompile(`(?i)updating checksum( and crcs)?\.*\s*done|checksum (updated|fixed|corrected)`)
+	checksumFailedRegex  = regexp.MustCompile(`(?i)(checksum|eeprom|nvm)[^\n]*(fail|error)|error[^\n]*(checksum|eeprom|nvm)`)
+)
+
+// parseChecksumRepair разбирает вывод eeupdate с аргументами исправления суммы: была ли сумма неверной
+// и записана ли новая. before - valid/invalid, after - valid/repaired/failed
+func parseChecksumRepair(output string) (before, after string) {
+	before = "valid"
+	if checksumInvalidRegex.MatchString(output) {
+		before = "invalid"
+	}
+	switch {
+	case checksumFailedRegex.MatchString(output):
+		after = "failed"
+	case before == "valid":
+		after = "valid"
+	case checksumUpdatedRegex.MatchString(output):
+		after = "repaired"
+	default:
+		after = "failed"
+	}
+	return before, after
+}
+
+// FixChecksum пересчитывает контрольную сумму EEPROM карты nicIndex
+func (c *EeupdateClient) FixChecksum(nicIndex int, fixArgs []string) (NICChecksum, error) {
+	result := NICChecksum{Index: nicIndex}
+	args := append([]string{fmt.Sprintf("/NIC=%d", nicIndex)}, fixArgs...)
+	outputStr, exitCode, err := c.run(args...)
+	if err != nil {
+		return result, fmt.Errorf("eeupdate64e %s failed with exit code %d: %v\nOutput: %s", strings.Join(args, " "), exitCode, err, outputStr)
+	}
+	if exitCode == 2 {
+		printInfo(fmt.Sprintf("eeupdate64e reports no driver (exit code 2) for NIC %d, checking output for checksum status...", nicIndex))
+	}
+	result.Before, result.After = parseChecksumRepair(outputStr)
+	if result.After == "failed" {
+		result.Details = strings.TrimSpace(outputStr)
+	}
+	return result, nil
+}
+
+// repairNICChecksums - операция nic-checksum: исправление контрольной суммы EEPROM Intel NIC без ввода данных.
+// Все суммы уже верные - SKIPPED
+func repairNICChecksums(config FlashConfig) FlashResult {
+	result := FlashResult{Operation: "nic-checksum", Status: "PASSED"}
+	client, err := newEeupdateClient()
+	if err != nil {
+		result.Status = "FAILED"
+		result.Details = err.Error()
+		return result
+	}
+	nics, err := client.Discover(config.VenDevice)
+	if err != nil {
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf("failed to discover Intel NICs: %v", err)
+		return result
+	}
+	// Discover при нераспознанном выводе подставляет индексы наугад - чинить сумму на угаданных картах нельзя.
+	// Ни одной карты - провал, а не SKIPPED: проверять было нечего
+	var found []IntelNIC
+	for _, nic := range nics {
+		if nic.VendorDevice != "unknown" {
+			found = append(found, nic)
+		}
+	}
+	if len(found) == 0 {
+		result.Status = "FAILED"
+		result.Details = "no Intel NICs found in eeupdate output, EEPROM checksum not checked"
+		return result
+	}
+	nics = found
+
+	var repaired, failed []string
+	for _, nic := range nics {
+		checksum, err := client.FixChecksum(nic.Index, checksumFixArgs(config))
+		checksum.VendorDevice = nic.VendorDevice
+		if err != nil {
+			checksum.After = "failed"
+			checksum.Details = err.Error()
+		}
+		result.NICs = append(result.NICs, checksum)
+
+		switch checksum.After {
+		case "failed":
+			failed = append(failed, strconv.Itoa(nic.Index))
+			printError(fmt.Sprintf("NIC %d (%s): checksum repair failed", nic.Index, nic.VendorDevice))
+		case "repaired":
+			repaired = append(repaired, strconv.Itoa(nic.Index))
+			printSuccess(fmt.Sprintf("NIC %d (%s): checksum repaired", nic.Index, nic.VendorDevice))
+		default:
+			printInfo(fmt.Sprintf("NIC %d (%s): checksum already valid", nic.Index, nic.VendorDevice))
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf("checksum repair failed on NIC %s", strings.Join(failed, ", "))
+		if len(repaired) > 0 {
+			result.Details += fmt.Sprintf("; repaired NIC %s", strings.Join(repaired, ", "))
+		}
+	case len(repaired) == 0:
+		result.Status = "SKIPPED"
+		result.Details = fmt.Sprintf("EEPROM checksum already valid on %d NIC(s)", len(nics))
+	default:
+		result.Details = fmt.Sprintf("repaired NIC %s", strings.Join(repaired, ", "))
+	}
+	return result
+}
+
+var eeupdateMACRegex = regexp.MustCompile(`\b[0-9A-Fa-f]{12}\b`)
+
+// DumpMAC читает MAC из NVM карты (eeupdate64e /NIC=N /MAC_DUMP) в формате AA:BB:CC:DD:EE:FF
+func (c *EeupdateClient) DumpMAC(nicIndex int) (string, error) {
+	outputStr, exitCode, err := c.run(fmt.Sprintf("/NIC=%d", nicIndex), "/MAC_DUMP")
+	if err != nil {
+		return "", fmt.Errorf("eeupdate64e MAC dump failed with exit code %d: %v", exitCode, err)
+	}
+
+	raw := eeupdateMACRegex.FindString(outputStr)
+	if raw == "" {
+		return "", fmt.Errorf("no MAC address in eeupdate64e output for NIC %d", nicIndex)
+	}
+	raw = strings.ToUpper(raw)
+	parts := make([]string, 0, 6)
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, raw[i:i+2])
+	}
+	return strings.Join(parts, ":"), nil
+}
+
+func flashMACWithEeupdate(targetMAC string, interfaces []NetworkInterface, flashConfig FlashConfig, systemConfig SystemConfig, summary *FlashMACSummary, checkMACs func(macs []string) error) error {
+	printInfo("Starting eeupdate MAC flashing process...")
+
+	// Часть Intel NIC появляется только после пересканирования шины
+	if flashConfig.PCIRescanBeforeFlash {
+		if err := rescanPCIBus(systemConfig.PCIRescanPath); err != nil {
+			printWarning(fmt.Sprintf("PCI rescan failed: %v", err))
+		}
+	}
+
+	// Step 1: Save current IP
+	var originalIP string
+	for _, iface := range interfaces {
+		if iface.IP != "" && iface.State == "UP" {
+			originalIP = iface.IP
+			summary.OriginalIPCIDR = captureIPCIDR(iface.Name, iface.IP)
+			summary.OriginalDefaultRoute = captureDefaultRoute(iface.Name)
+			break
+		}
+	}
+	summary.OriginalIP = originalIP
+
+	if originalIP != "" {
+		printInfo(fmt.Sprintf("Current IP address saved: %s", summary.OriginalIPCIDR))
+	}
+
+	// Step 2: Get Intel network drivers before discovery
+	intelDrivers, err := getIntelNetworkDrivers()
+	if err != nil {
+		printWarning(fmt.Sprintf("Failed to detect Intel drivers: %v", err))
+		intelDrivers = []string{"igb"} // Fallback к наиболее распространенному
+	}
+
+	// Step 3: Discover Intel NICs with optional filtering
+	client, err := newEeupdateClient()
+	if err != nil {
+		return err
+	}
+	printInfo("Scanning for Intel network cards...")
+	intelNICs, err := client.Discover(flashConfig.VenDevice)
+	if err != nil {
+		return fmt.Errorf("failed to discover Intel NICs: %v", err)
+	}
+
+	if len(intelNICs) == 0 {
+		return fmt.Errorf("no Intel network cards found")
+	}
+
+	// Extract indices for summary
+	var nicIndices []int
+	for _, nic := range intelNICs {
+		nicIndices = append(nicIndices, nic.Index)
+	}
+	summary.NICIndices = nicIndices
+
+	// Нумерация eeupdate не всегда совпадает с физическим порядком портов - порядок назначения MAC из flash.nic_order
+	currentMACs := make(map[int]string)
+	if flashConfig.NICOrder.Mode == nicOrderByMACCurrent {
+		for _, nic := range intelNICs {
+			if mac, err := client.DumpMAC(nic.Index); err == nil {
+				currentMACs[nic.Index] = mac
+			}
+		}
+	}
+	orderedNICs, err := orderIntelNICs(intelNICs, flashConfig.NICOrder, currentMACs)
+	if err != nil {
+		summary.Error = err.Error()
+		return err
+	}
+	assignments, err := assignNICMACs(orderedNICs, targetMAC, currentMACs)
+	if err != nil {
+		return err
+	}
+	summary.NICMapping = &NICMapping{Order: flashConfig.NICOrder.String(), Assignments: assignments}
+
+	printSuccess(fmt.Sprintf("Found %d Intel NIC(s) for flashing:", len(intelNICs)))
+	printNICMapping(*summary.NICMapping)
+	// Базовый MAC проверен до вызова; остальные карты получают его инкременты - их в историю тоже
+	if checkMACs != nil {
+		var next []string
+		for _, a := range assignments[1:] {
+			next = append(next, a.MAC)
+		}
+		if err := checkMACs(next); err != nil {
+			summary.Error = err.Error()
+			return err
+		}
+	}
+	if flashConfig.NICOrder.configured() && !confirmNICMapping(bufio.NewReader(operatorInput())) {
+		summary.Error = "NIC mapping rejected by operator"
+		return fmt.Errorf("NIC mapping rejected by operator")
+	}
+
+	// Версии до выгрузки: после прошивки сравниваются с тем, что загрузилось обратно
+	summary.Driver = collectDriverContext(toolVersionCommand{client.BinaryPath, []string{"/h"}}, intelDrivers, interfaces)
+
+	// Step 4: Unload Intel drivers before flashing
+	printInfo("Unloading Intel network drivers for flashing...")
+	for _, driver := range intelDrivers {
+		if err := unloadNetworkDriver(driver); err != nil {
+			printWarning(fmt.Sprintf("Failed to unload driver %s: %v", driver, err))
+		} else {
+			printSuccess(fmt.Sprintf("Driver %s unloaded successfully", driver))
+		}
+	}
+
+	// Wait for drivers to fully unload
+	unloadStart := time.Now()
+	for _, driver := range intelDrivers {
+		if !waitForModuleState(driver, false, 5*time.Second) {
+			printWarning(fmt.Sprintf("Driver %s still loaded", driver))
+		}
+	}
+	printDebug(fmt.Sprintf("Driver unload wait took %s", time.Since(unloadStart).Round(time.Millisecond)))
+
+	// Step 5: Flash each NIC with incremented MAC addresses
+	attempts := 0
+	maxAttempts := 3
+	var lastError error
+
+	for attempts < maxAttempts {
+		attempts++
+		printInfo(fmt.Sprintf("Flashing attempt %d/%d...", attempts, maxAttempts))
+
+		success := true
+		flashedNICs := 0
+
+		for _, nic := range assignments {
+			currentMAC := nic.MAC
+			printInfo(fmt.Sprintf("Flashing NIC %d (%s) with MAC %s...", nic.Index, nic.VendorDevice, currentMAC))
+			if err := client.FlashMAC(nic.Index, currentMAC); err != nil {
+				printError(fmt.Sprintf("Failed to flash NIC %d: %v", nic.Index, err))
+				lastError = fmt.Errorf("failed to flash NIC %d: %v", nic.Index, err)
+				success = false
+				break
+			}
+
+			// Проверяем, что в NVM записан именно нужный MAC (нечитаемый вывод - только предупреждение)
+			if dumped, err := client.DumpMAC(nic.Index); err != nil {
+				printWarning(fmt.Sprintf("Could not read back MAC of NIC %d: %v", nic.Index, err))
+			} else if !strings.EqualFold(dumped, currentMAC) {
+				printError(fmt.Sprintf("NIC %d reports MAC %s after flashing, expected %s", nic.Index, dumped, currentMAC))
+				lastError = fmt.Errorf("NIC %d read back MAC %s, expected %s", nic.Index, dumped, currentMAC)
+				success = false
+				break
+			}
+
+			flashedNICs++
+			printSuccess(fmt.Sprintf("NIC %d flashing completed with MAC %s", nic.Index, currentMAC))
+		}
+
+		if success {
+			printSuccess(fmt.Sprintf("All %d NICs flashed successfully with incremented MAC addresses", flashedNICs))
+			lastError = nil
+			break
+		}
+
+		if attempts < maxAttempts {
+			action := askFlashRetryAction(fmt.Sprintf("eeupdate flashing failed (attempt %d/%d): %v", attempts, maxAttempts, lastError))
+			if action == "SKIP" {
+				summary.Success = false
+				summary.Error = "Skipped by operator"
+				// Reload drivers before exiting
+				reloadIntelDrivers(intelDrivers)
+				return nil
+			}
+			if action == "ABORT" {
+				summary.Success = false
+				summary.Error = fmt.Sprintf("Aborted by operator after %d attempts", attempts)
+				// Reload drivers before exiting
+				reloadIntelDrivers(intelDrivers)
+				return fmt.Errorf("flashing aborted by operator")
+			}
+			// Continue to retry if action == "RETRY"
+		}
+	}
+
+	if lastError != nil && attempts >= maxAttempts {
+		summary.Success = false
+		summary.Error = fmt.Sprintf("Max attempts reached: %v", lastError)
+		// Reload drivers before exiting
+		reloadIntelDrivers(intelDrivers)
+		return lastError
+	}
+
+	// Step 6: Reload Intel drivers after flashing
+	printInfo("Reloading Intel network drivers...")
+	reloadIntelDrivers(intelDrivers)
+
+	// Wait for interfaces to come up with the new MACs
+	var expectedMACs []string
+	for _, a := range assignments {
+		expectedMACs = append(expectedMACs, a.MAC)
+	}
+	newInterfaces, _ := waitForMACs(expectedMACs, 15*time.Second)
+	if stable, _, err := readStableNetworkInterfaces(10 * time.Second); err == nil {
+		newInterfaces = stable
+	}
+
+	// Step 7: Verify that at least the first MAC address is present
+	printInfo("Verifying MAC address presence...")
+	if newInterfaces == nil {
+		printError("Warning: failed to verify MAC flashing: no network interfaces read")
+	} else {
+		// Check for the primary MAC address (first one)
+		exists, interfaceName := isTargetMACPresent(targetMAC, newInterfaces)
+		if exists {
+			summary.Success = true
+			summary.InterfaceName = interfaceName
+			printSuccess(fmt.Sprintf("SUCCESS: Primary MAC %s found on interface %s", targetMAC, interfaceName))
+
+			// Also check for incremented MAC addresses and report them
+			for _, a := range assignments[1:] {
+				exists, ifaceName := isTargetMACPresent(a.MAC, newInterfaces)
+				if exists {
+					printSuccess(fmt.Sprintf("Additional MAC %s (NIC %d) found on interface %s", a.MAC, a.Index, ifaceName))
+				} else {
+					printError(fmt.Sprintf("Warning: Expected MAC %s (NIC %d) not found on any interface", a.MAC, a.Index))
+				}
+			}
+
+			if err := bringInterfaceUp(interfaceName, 10*time.Second); err != nil {
+				printWarning(err.Error())
+			}
+
+			// Try to restore IP address to the primary interface
+			if originalIP != "" {
+				printInfo(fmt.Sprintf("Restoring original IP address: %s", summary.OriginalIPCIDR))
+				if err := restoreIPAddress(interfaceName, summary.OriginalIPCIDR); err != nil {
+					summary.IPRestoreError = err.Error()
+					printError(fmt.Sprintf("Warning: failed to restore IP %s: %v", originalIP, err))
+				} else {
+					printSuccess(fmt.Sprintf("IP address %s restored successfully", originalIP))
+					summary.IPRestored = true
+					restoreSummaryRoute(interfaceName, summary)
+				}
+			}
+		} else {
+			printError("Primary MAC not found on any interface after flashing")
+			action := askFlashRetryAction(fmt.Sprintf("Flashing completed but target MAC %s not found on any interface", targetMAC))
+			if action == "SKIP" {
+				summary.Success = false
+				summary.Error = "MAC not found after flashing - skipped by operator"
+				return nil
+			}
+			if action == "ABORT" {
+				summary.Success = false
+				summary.Error = "MAC not found after flashing - aborted by operator"
+				return fmt.Errorf("MAC not found after flashing - aborted by operator")
+			}
+			summary.Success = false
+			summary.Error = "MAC not found after flashing"
+			return fmt.Errorf("target MAC not found after flashing")
+		}
+	}
+
+	return nil
+}
+
+// Функция для проверки загрузки pgdrv модуля
+func verifyPgdrvLoaded() error {
+	loaded, err := isModuleLoaded("pgdrv")
+	if err != nil {
+		return err
+	}
+	if loaded {
+		return nil
+	}
+
+	return fmt.Errorf("pgdrv module not found in /proc/modules")
+}
+
+// Функция ожидания загрузки pgdrv
+func waitForPgdrvLoad(timeoutSeconds int) error {
+	if waitForModuleState("pgdrv", true, time.Duration(timeoutSeconds)*time.Second) {
+		return nil
+	}
+	return fmt.Errorf("timeout waiting for pgdrv module to load")
+}
+
+// Функция ожидания выгрузки pgdrv
+func waitForPgdrvUnload(timeoutSeconds int) error {
+	if waitForModuleState("pgdrv", false, time.Duration(timeoutSeconds)*time.Second) {
+		return nil
+	}
+	return fmt.Errorf("timeout waiting for pgdrv module to unload")
+}
+
+// pgdrvDevicePath - символьное устройство, через которое rtnic работает с pgdrv
+const pgdrvDevicePath = "/dev/pgdrv"
+
+// Функция для загрузки rtnicpg драйвера из файла
+func loadRtnicpgDriverFromPath(driverPath string) error {
+	printInfo(fmt.Sprintf("Loading rtnicpg driver from: %s", driverPath))
+
+	// Проверяем существование файла
+	if _, err := os.Stat(driverPath); os.IsNotExist(err) {
+		return fmt.Errorf("driver file not found: %s", driverPath)
+	}
+
+	// Загружаем драйвер
+	output, err := sysRunner.Exec(context.Background(), "insmod", driverPath)
+	if err != nil {
+		if !isStaleModuleError(string(output)) {
+			return fmt.Errorf("insmod failed: %v\nOutput: %s\n%s", err, string(output), collectPgdrvDiagnostics(driverPath))
+		}
+
+		// Предыдущий аварийный запуск оставил модуль наполовину зарегистрированным
+		printWarning("insmod reports pgdrv already exists - removing stale module and retrying once")
+		if rmErr := removeStalePgdrv(); rmErr != nil {
+			return fmt.Errorf("stale pgdrv recovery failed: %v\nOutput: %s\n%s", rmErr, string(output), collectPgdrvDiagnostics(driverPath))
+		}
+
+		output, err = sysRunner.Exec(context.Background(), "insmod", driverPath)
+		if err != nil {
+			return fmt.Errorf("insmod failed after stale module recovery: %v\nOutput: %s\n%s", err, string(output), collectPgdrvDiagnostics(driverPath))
+		}
+		printSuccess("Stale pgdrv module removed, insmod succeeded on retry")
+	}
+
+	// Ждем загрузки pgdrv модуля с таймаутом
+	if err := waitForPgdrvLoad(5); err != nil {
+		return fmt.Errorf("pgdrv driver verification failed: %v\n%s", err, collectPgdrvDiagnostics(driverPath))
+	}
+
+	// Модуль загружен, но rtnic нужен еще узел устройства
+	if err := ensurePgdrvDeviceNode(); err != nil {
+		return fmt.Errorf("pgdrv loaded but not usable by rtnic: %v\n%s", err, collectPgdrvDiagnostics(driverPath))
+	}
+
+	printSuccess("pgdrv driver loaded and verified successfully")
+	return nil
+}
+
+// isStaleModuleError определяет, что insmod упал из-за уже зарегистрированного модуля (EEXIST)
+func isStaleModuleError(output string) bool {
+	lower := strings.ToLower(output)
+	return strings.Contains(lower, "file exists") || strings.Contains(lower, "eexist")
+}
+
+// removeStalePgdrv выгружает оставшийся pgdrv (сначала обычным rmmod, затем -f) и ждет выгрузки
+func removeStalePgdrv() error {
+	if output, err := runRmmod("pgdrv"); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		printWarning(fmt.Sprintf("Normal rmmod failed, trying force: %v", err))
+		if output, err = runRmmod("-f", "pgdrv"); err != nil {
+			return fmt.Errorf("rmmod -f pgdrv failed: %v\nOutput: %s", err, string(output))
+		}
+	}
+
+	if err := waitForPgdrvUnload(3); err != nil {
+		return fmt.Errorf("pgdrv still registered after rmmod: %v", err)
+	}
+	return nil
+}
+
+// ensurePgdrvDeviceNode проверяет sysfs и /dev узлы pgdrv, при необходимости создает /dev/pgdrv по major из /proc/devices
+func ensurePgdrvDeviceNode() error {
+	if _, err := sysRunner.ReadDir("/sys/module/pgdrv"); err != nil {
+		return fmt.Errorf("sysfs node /sys/module/pgdrv missing: %v", err)
+	}
+
+	if devices, err := sysRunner.ReadDir(filepath.Dir(pgdrvDevicePath)); err == nil && slices.Contains(devices, filepath.Base(pgdrvDevicePath)) {
+		return nil
+	}
+
+	major, err := getCharDeviceMajor("pgdrv")
+	if err != nil {
+		return fmt.Errorf("%s missing and pgdrv not registered as char device: %v", pgdrvDevicePath, err)
+	}
+
+	printWarning(fmt.Sprintf("%s missing, creating device node (major %d)", pgdrvDevicePath, major))
+	if output, err := sysRunner.Exec(context.Background(), "mknod", pgdrvDevicePath, "c", strconv.Itoa(major), "0"); err != nil {
+		return fmt.Errorf("failed to create %s: %v\nOutput: %s", pgdrvDevicePath, err, string(output))
+	}
+	return nil
+}
+
+// getCharDeviceMajor ищет major номер символьного устройства в /proc/devices
+func getCharDeviceMajor(name string) (int, error) {
+	data, err := sysRunner.ReadFile("/proc/devices")
+	if err != nil {
+		return 0, err
+	}
+
+	inChar := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		switch line {
+		case "Character devices:":
+			inChar = true
+			continue
+		case "Block devices:":
+			inChar = false
+			continue
+		}
+		fields := strings.Fields(line)
+		if inChar && len(fields) == 2 && fields[1] == name {
+			return strconv.Atoi(fields[0])
+		}
+	}
+	return 0, fmt.Errorf("%s not found in /proc/devices", name)
+}
+
+// collectPgdrvDiagnostics собирает контекст для ошибки загрузки pgdrv: dmesg, lsmod, modinfo
+func collectPgdrvDiagnostics(driverPath string) string {
+	var b strings.Builder
+	b.WriteString("pgdrv diagnostics:")
+
+	if output, err := pollingRunner().Run("dmesg"); err == nil {
+		var matched []string
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.Contains(strings.ToLower(line), "pgdrv") {
+				matched = append(matched, line)
+			}
+		}
+		if len(matched) > 10 {
+			matched = matched[len(matched)-10:]
+		}
+		b.WriteString("\n  dmesg (pgdrv):")
+		if len(matched) == 0 {
+			b.WriteString(" <no entries>")
+		}
+		for _, line := range matched {
+			b.WriteString("\n    " + strings.TrimSpace(line))
+		}
+	} else {
+		b.WriteString(fmt.Sprintf("\n  dmesg: %v", err))
+	}
+
+	lsmodLine := "<not loaded>"
+	if lines, err := loadedModules(); err == nil {
+		for _, line := range lines {
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "pgdrv" {
+				lsmodLine = strings.TrimSpace(line)
+				break
+			}
+		}
+	}
+	b.WriteString("\n  /proc/modules: " + lsmodLine)
+
+	if output, err := sysRunner.Run("modinfo", driverPath); err == nil {
+		b.WriteString("\n  modinfo:")
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			b.WriteString("\n    " + line)
+		}
+	} else {
+		b.WriteString(fmt.Sprintf("\n  modinfo %s: %v", driverPath, err))
+	}
+
+	return b.String()
+}
+
+// Функция для выгрузки pgdrv модуля
+func unloadPgdrvDriver() error {
+	printInfo("Unloading pgdrv module")
+
+	// Проверяем, загружен ли pgdrv
+	if err := verifyPgdrvLoaded(); err != nil {
+		printInfo("pgdrv module not loaded, nothing to unload")
+		return nil
+	}
+
+	// Выгружаем модуль pgdrv
+	output, err := runRmmod("pgdrv")
+	if errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err != nil {
+		// Если не получилось, попробуем форсированно
+		printWarning(fmt.Sprintf("Normal rmmod failed, trying force: %v", err))
+		output, err = runRmmod("-f", "pgdrv")
+		if errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("rmmod pgdrv failed: %v\nOutput: %s", err, string(output))
+		}
+	}
+
+	invalidateSystemCache()
+
+	// Ждем выгрузки модуля с таймаутом
+	if err := waitForPgdrvUnload(3); err != nil {
+		printWarning("pgdrv module still appears loaded after rmmod")
+	} else {
+		printSuccess("pgdrv module unloaded successfully")
+	}
+
+	return nil
+}
+
+// Функция ожидания загрузки сетевого драйвера
+func waitForDriverLoad(driverName string, timeoutSeconds int) error {
+	if waitForModuleState(driverName, true, time.Duration(timeoutSeconds)*time.Second) {
+		return nil
+	}
+	return fmt.Errorf("timeout waiting for driver %s to load", driverName)
+}
+
+// Функция для проверки первоначального состояния драйверов
+func checkInitialDriverState(primaryInterface *NetworkInterface) (pgdrvLoaded bool, realtekActive bool) {
+	// Проверяем загружен ли pgdrv
+	pgdrvLoaded = (verifyPgdrvLoaded() == nil)
+
+	// Проверяем активен ли Realtek драйвер
+	realtekActive = false
+	if primaryInterface != nil && primaryInterface.Driver != "" && isRealtekDriver(primaryInterface.Driver) {
+		realtekActive, _ = isModuleLoaded(primaryInterface.Driver)
+	}
+
+	return pgdrvLoaded, realtekActive
+}
+
+// Заменяем функцию loadFlashingDriver на версию без хардкодных sleep'ов
+func loadFlashingDriver(driverDir, originalDriver string) (string, error) {
+	printInfo(fmt.Sprintf("Loading flashing driver for: %s", originalDriver))
+
+	// Получаем версию ядра
+	kernelVersion, err := getKernelVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get kernel version: %v", err)
+	}
+
+	// Step 1: Проверяем наличие готового скомпилированного драйвера
+	compiledDriverPath, found := checkCompiledDriver(driverDir, originalDriver, kernelVersion)
+	if found {
+		printInfo("Attempting to use pre-compiled rtnicpg driver...")
+		if err := loadRtnicpgDriverFromPath(compiledDriverPath); err == nil {
+			printSuccess("Pre-compiled pgdrv driver loaded successfully")
+			return compiledDriverPath, nil
+		} else {
+			printWarning(fmt.Sprintf("Pre-compiled driver failed to load: %v", err))
+			printInfo("Will attempt to recompile driver...")
+
+			// Убираем возможно частично загруженный модуль
+			unloadPgdrvDriver()
+		}
+	}
+
+	// Step 2: Компилируем новый драйвер
+	printInfo("Compiling new rtnicpg driver...")
+	compiledPath, err := compileFlashingDriver(driverDir, originalDriver)
+	if err != nil {
+		return "", fmt.Errorf("failed to compile driver: %v", err)
+	}
+
+	// Step 3: Загружаем новый драйвер
+	if err := loadRtnicpgDriverFromPath(compiledPath); err != nil {
+		return "", fmt.Errorf("failed to load compiled pgdrv driver: %v", err)
+	}
+
+	printSuccess("rtnicpg driver compiled and pgdrv module loaded successfully")
+	return compiledPath, nil
+}
+
+// Модифицированная функция flashMACWithRtnicpg для работы с Realtek драйверами
+func flashMACWithRtnicpg(targetMAC string, interfaces []NetworkInterface, systemConfig SystemConfig, summary *FlashMACSummary) error {
+	printInfo("Starting rtnicpg MAC flashing process with Realtek driver detection...")
+
+	// Диагностика интерфейсов для отладки
+	debugNetworkInterfaces(interfaces)
+	debugLoadedModules()
+
+	// Step 1: Сначала попытаемся найти Realtek интерфейс
+	primaryInterface := findRealtekInterface(interfaces)
+
+	// Step 1.1: Если Realtek не найден, используем fallback на старую логику
+	if primaryInterface == nil {
+		printWarning("No Realtek network interface found, using fallback to any active interface...")
+		printInfo("Available interfaces:")
+		for _, iface := range interfaces {
+			if iface.Name != "lo" {
+				driverType := "UNKNOWN"
+				if iface.Driver != "" {
+					if isRealtekDriver(iface.Driver) {
+						driverType = "REALTEK"
+					} else if strings.Contains(strings.ToLower(iface.Driver), "intel") ||
+						iface.Driver == "igb" || iface.Driver == "e1000e" ||
+						iface.Driver == "ixgbe" || iface.Driver == "i40e" || iface.Driver == "ice" {
+						driverType = "INTEL"
+					} else {
+						driverType = "OTHER"
+					}
+				}
+				printInfo(fmt.Sprintf("  [%s] %s: MAC=%s Driver=%s State=%s IP=%s",
+					driverType, iface.Name, iface.MAC, iface.Driver, iface.State, iface.IP))
+			}
+		}
+
+		// Fallback: ищем любой активный интерфейс с IP (как в оригинальном коде)
+		for i := range interfaces {
+			if interfaces[i].IP != "" && interfaces[i].State == "UP" {
+				primaryInterface = &interfaces[i]
+				printWarning(fmt.Sprintf("Using fallback interface %s (Driver: %s) - rtnicpg may work with non-Realtek drivers",
+					interfaces[i].Name, interfaces[i].Driver))
+				break
+			}
+		}
+
+		if primaryInterface == nil {
+			return fmt.Errorf("no active network interface with IP found")
+		}
+	}
+
+	summary.OriginalIP = primaryInterface.IP
+	summary.OriginalIPCIDR = captureIPCIDR(primaryInterface.Name, primaryInterface.IP)
+	summary.OriginalDefaultRoute = captureDefaultRoute(primaryInterface.Name)
+	summary.OriginalDriver = primaryInterface.Driver
+
+	printInfo(fmt.Sprintf("Using interface %s (IP: %s, Driver: %s, State: %s)",
+		primaryInterface.Name, primaryInterface.IP, primaryInterface.Driver, primaryInterface.State))
+
+	// Step 2: Если интерфейс неактивен, попытаемся его поднять (но не будем ждать)
+	if primaryInterface.State != "UP" {
+		printInfo(fmt.Sprintf("Interface %s is DOWN, attempting to bring it UP...", primaryInterface.Name))
+		cmd := exec.Command("ip", "link", "set", primaryInterface.Name, "up")
+		if err := tracedRun(cmd); err != nil {
+			printWarning(fmt.Sprintf("Failed to bring interface UP: %v", err))
+		} else {
+			printInfo(fmt.Sprintf("Interface %s UP command sent (not waiting for activation)", primaryInterface.Name))
+		}
+	}
+
+	// Step 3: Подготовка pgdrv драйвера с проверкой начального состояния.
+	// Cleanup отложен на любой выход (в том числе panic); после прошивки он вызывается явно - до проверки MAC.
+	session := newRtnicpgSession(primaryInterface)
+	defer session.Cleanup()
+
+	summary.Driver = collectDriverContext(rtnicVersionCommand, []string{primaryInterface.Driver, "pgdrv"}, interfaces)
+
+	if err := session.PrepareDriver(systemConfig.DriverDir); err != nil {
+		return err
+	}
+
+	// Step 4: Flash MAC using rtnic
+	attempts := 0
+	maxAttempts := 3
+	var flashErr error
+
+	for attempts < maxAttempts {
+		attempts++
+		printInfo(fmt.Sprintf("Flashing MAC attempt %d/%d using rtnic (pgdrv loaded)...", attempts, maxAttempts))
+
+		flashErr = session.Flash(targetMAC)
+		if flashErr == nil {
+			printSuccess(fmt.Sprintf("rtnic flashing completed successfully on attempt %d", attempts))
+			break
+		}
+
+		printError(fmt.Sprintf("rtnic flashing failed on attempt %d: %v", attempts, flashErr))
+
+		if attempts < maxAttempts {
+			action := askFlashRetryAction(fmt.Sprintf("rtnic flashing failed (attempt %d): %v", attempts, flashErr))
+			if action == "SKIP" {
+				summary.Success = false
+				summary.Error = "Skipped by operator"
+				break
+			}
+			if action == "ABORT" {
+				summary.Success = false
+				summary.Error = "Aborted by operator"
+				flashErr = fmt.Errorf("flashing aborted by operator")
+				break
+			}
+			if action != "RETRY" {
+				break
+			}
+		}
+	}
+
+	// Step 5: Cleanup - unload pgdrv module and restore original driver
+	session.Cleanup()
+
+	// Step 5.1: Verify cleanup state
+	debugLoadedModules()
+
+	// Проверяем результат флэширования
+	if flashErr != nil && attempts >= maxAttempts {
+		summary.Success = false
+		summary.Error = fmt.Sprintf("Max attempts reached: %v", flashErr)
+		return flashErr
+	}
+
+	if summary.Error != "" {
+		return fmt.Errorf("%s", summary.Error)
+	}
+
+	// Step 6: Verify MAC was flashed
+	printInfo("Verifying MAC address after flashing...")
+
+	// Ждем, пока восстановленный драйвер поднимет интерфейс с новым MAC
+	interfaceName, waitErr := waitForMACPresent(targetMAC, 15*time.Second)
+	newInterfaces, _, err := readStableNetworkInterfaces(10 * time.Second)
+	if err != nil {
+		printError(fmt.Sprintf("Warning: failed to verify MAC flashing: %v", err))
+		summary.Success = false
+		summary.Error = "Failed to verify flashing result"
+		return fmt.Errorf("failed to verify MAC flashing: %v", err)
+	}
+
+	if waitErr == nil {
+		summary.Success = true
+		summary.InterfaceName = interfaceName
+		printSuccess(fmt.Sprintf("SUCCESS: MAC %s found on interface %s", targetMAC, interfaceName))
+
+		if err := bringInterfaceUp(interfaceName, 10*time.Second); err != nil {
+			printWarning(err.Error())
+		}
+
+		// Попытаемся восстановить IP адрес, если он был
+		if summary.OriginalIP != "" {
+			printInfo(fmt.Sprintf("Attempting to restore original IP address: %s", summary.OriginalIPCIDR))
+			if err := restoreIPAddress(interfaceName, summary.OriginalIPCIDR); err != nil {
+				summary.IPRestoreError = err.Error()
+				printWarning(fmt.Sprintf("Failed to restore IP %s: %v", summary.OriginalIP, err))
+			} else {
+				printSuccess(fmt.Sprintf("IP address %s restored successfully", summary.OriginalIP))
+				summary.IPRestored = true
+				restoreSummaryRoute(interfaceName, summary)
+			}
+		}
+	} else {
+		printError(fmt.Sprintf("FAILURE: Target MAC %s not found on any interface after flashing", targetMAC))
+
+		// Показываем текущие MAC адреса для отладки
+		printInfo("Current MAC addresses after flashing:")
+		for _, iface := range newInterfaces {
+			if iface.MAC != "" && iface.Name != "lo" {
+				driverType := "OTHER"
+				if isRealtekDriver(iface.Driver) {
+					driverType = "REALTEK"
+				}
+				printInfo(fmt.Sprintf("  [%s] %s: %s", driverType, iface.Name, iface.MAC))
+			}
+		}
+
+		action := askFlashRetryAction(fmt.Sprintf("Flashing completed but target MAC %s not found on any interface", targetMAC))
+		if action == "SKIP" {
+			summary.Success = false
+			summary.Error = "MAC not found after flashing - skipped by operator"
+			return nil
+		}
+		if action == "ABORT" {
+			summary.Success = false
+			summary.Error = "MAC not found after flashing - aborted by operator"
+			return fmt.Errorf("MAC not found after flashing - aborted by operator")
+		}
+		summary.Success = false
+		summary.Error = "MAC not found after flashing"
+		return fmt.Errorf("target MAC not found after flashing")
+	}
+
+	return nil
+}
+
+// Диагностическая функция для отладки модулей
+func debugLoadedModules() {
+	printInfo("=== Loaded Network Modules Debug ===")
+
+	lines, err := loadedModules()
+	if err != nil {
+		printError(fmt.Sprintf("Failed to list modules: %v", err))
+		return
+	}
+
+	printInfo("Network-related modules:")
+
+	pgdrvFound := false
+	for _, line := range lines { // Формат /proc/modules: name size refcount deps state offset
+		if strings.Contains(line, "r8") ||
+			strings.Contains(line, "rtl") ||
+			strings.Contains(line, "8139") ||
+			strings.Contains(line, "igb") ||
+			strings.Contains(line, "e1000") ||
+			strings.Contains(line, "ixgbe") ||
+			strings.Contains(line, "i40e") ||
+			strings.Contains(line, "ice") ||
+			strings.Contains(line, "pgdrv") {
+			parts := strings.Fields(line)
+			if len(parts) >= 3 {
+				status := ""
+				if parts[0] == "pgdrv" {
+					status = " ← RTNICPG FLASHING DRIVER"
+					pgdrvFound = true
+				}
+				printInfo(fmt.Sprintf("  %s (used by %s, refs: %s)%s", parts[0], parts[2], parts[1], status))
+			}
+		}
+	}
+
+	if pgdrvFound {
+		printSuccess("pgdrv module is currently loaded")
+	} else {
+		printInfo("pgdrv module is not loaded")
+	}
+
+	printInfo("=== End Module Debug ===")
+}
+
+// Функция для генерации имени файла драйвера
+func getDriverFileName(driverName, kernelVersion string) string {
+	return fmt.Sprintf("%s_%s.ko", driverName, kernelVersion)
+}
+
+// Функция для проверки существования скомпилированного драйвера
+func checkCompiledDriver(driverDir, driverName, kernelVersion string) (string, bool) {
+	driverFileName := getDriverFileName(driverName, kernelVersion)
+	driverPath := filepath.Join(driverDir, driverFileName)
+
+	if _, err := os.Stat(driverPath); err == nil {
+		printInfo(fmt.Sprintf("Found compiled driver: %s", driverPath))
+		return driverPath, true
+	}
+
+	return "", false
+}
+
+// Функция для проверки исходников драйвера rtnicpg
+func checkRtnicpgSources(driverDir string) (string, bool) {
+	rtnicpgDir := filepath.Join(driverDir, "rtnicpg")
+	makefilePath := filepath.Join(rtnicpgDir, "Makefile")
+
+	// Проверяем существование папки rtnicpg
+	if _, err := os.Stat(rtnicpgDir); os.IsNotExist(err) {
+		return "", false
+	}
+
+	// Проверяем существование Makefile
+	if _, err := os.Stat(makefilePath); os.IsNotExist(err) {
+		return "", false
+	}
+
+	printInfo(fmt.Sprintf("Found rtnicpg sources: %s", rtnicpgDir))
+	return rtnicpgDir, true
+}
+
+// Функция для проверки требований к сборке
+func checkBuildRequirements() error {
+	printInfo("Checking build requirements...")
+
+	// Проверяем наличие make
+	if _, err := exec.LookPath("make"); err != nil {
+		return fmt.Errorf("make not found - install build-essential package")
+	}
+
+	// Проверяем наличие компилятора
+	if _, err := exec.LookPath("gcc"); err != nil {
+		return fmt.Errorf("gcc not found - install build-essential package")
+	}
+
+	// Проверяем наличие заголовков ядра
+	kernelVersion, err := getKernelVersion()
+	if err != nil {
+		return fmt.Errorf("failed to get kernel version: %v", err)
+	}
+
+	kernelHeadersPath := fmt.Sprintf("/lib/modules/%s/build", kernelVersion)
+	if _, err := os.Stat(kernelHeadersPath); os.IsNotExist(err) {
+		return fmt.Errorf("kernel headers not found at %s - install linux-headers-%s package",
+			kernelHeadersPath, kernelVersion)
+	}
+
+	printSuccess("Build requirements check passed")
+	return nil
+}
+
+// Функция для диагностики сетевых интерфейсов и драйверов
+func debugNetworkInterfaces(interfaces []NetworkInterface) {
+	printInfo("=== Network Interface Debug Information ===")
+
+	for _, iface := range interfaces {
+		if iface.Name == "lo" {
+			continue // Skip loopback
+		}
+
+		// Получаем дополнительную информацию через разные методы
+		ethtoolDriver := getDriverViaEthtool(iface.Name)
+		sysfsDriver := getDriverViaSysfs(iface.Name)
+
+		driverType := "UNKNOWN"
+		if iface.Driver != "" {
+			if isRealtekDriver(iface.Driver) {
+				driverType = "REALTEK"
+			} else if strings.Contains(strings.ToLower(iface.Driver), "intel") ||
+				iface.Driver == "igb" || iface.Driver == "e1000e" ||
+				iface.Driver == "ixgbe" || iface.Driver == "i40e" || iface.Driver == "ice" {
+				driverType = "INTEL"
+			} else {
+				driverType = "OTHER"
+			}
+		}
+
+		printInfo(fmt.Sprintf("Interface %s:", iface.Name))
+		printInfo(fmt.Sprintf("  Current Driver: %s [%s]", iface.Driver, driverType))
+		printInfo(fmt.Sprintf("  Ethtool Driver: %s", ethtoolDriver))
+		printInfo(fmt.Sprintf("  Sysfs Driver: %s", sysfsDriver))
+		printInfo(fmt.Sprintf("  MAC: %s", iface.MAC))
+		printInfo(fmt.Sprintf("  State: %s", iface.State))
+		printInfo(fmt.Sprintf("  IP: %s", iface.IP))
+		printInfo("---")
+	}
+
+	printInfo("=== End Debug Information ===")
+}
+
+// Получение драйвера через ethtool
+func getDriverViaEthtool(interfaceName string) string {
+	cmd := exec.Command("ethtool", "-i", interfaceName)
+	output, err := tracedOutput(cmd)
+	if err != nil {
+		return fmt.Sprintf("ethtool_error: %v", err)
+	}
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "driver:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				return strings.TrimSpace(parts[1])
+			}
+		}
+	}
+	return "not_found"
+}
+
+// Получение драйвера через sysfs
+func getDriverViaSysfs(interfaceName string) string {
+	driverPath := fmt.Sprintf("/sys/class/net/%s/device/driver", interfaceName)
+	if link, err := os.Readlink(driverPath); err == nil {
+		return filepath.Base(link)
+	} else {
+		return fmt.Sprintf("sysfs_error: %v", err)
+	}
+}
+
+// Функция для сохранения скомпилированного драйвера
+func saveCompiledDriver(sourceDir, driverDir, driverName, kernelVersion string) (string, error) {
+	printInfo("Saving compiled driver...")
+
+	sourcePath := filepath.Join(sourceDir, "pgdrv.ko")
+	targetFileName := getDriverFileName(driverName, kernelVersion)
+	targetPath := filepath.Join(driverDir, targetFileName)
+
+	// Создаем директорию для драйверов если она не существует
+	if err := os.MkdirAll(driverDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create driver directory %s: %v", driverDir, err)
+	}
+
+	// Копируем файл
+	sourceFile, err := os.Open(sourcePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source driver %s: %v", sourcePath, err)
+	}
+	defer sourceFile.Close()
+
+	targetFile, err := os.Create(targetPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create target driver %s: %v", targetPath, err)
+	}
+	defer targetFile.Close()
+
+	// Копируем содержимое
+	if _, err := sourceFile.WriteTo(targetFile); err != nil {
+		return "", fmt.Errorf("failed to copy driver content: %v", err)
+	}
+
+	// Устанавливаем права доступа
+	if err := os.Chmod(targetPath, 0644); err != nil {
+		printWarning(fmt.Sprintf("Failed to set permissions on %s: %v", targetPath, err))
+	}
+
+	printSuccess(fmt.Sprintf("Driver saved as: %s", targetPath))
+	return targetPath, nil
+}
+
+// Driver management functions
+// driverUnloadTimeout ограничивает один вызов rmmod (system.driver_unload_timeout_seconds)
+var driverUnloadTimeout = 10 * time.Second
+
+// runRmmod выполняет rmmod с таймаутом: модуль с неотпущенными ссылками может повесить его навсегда
+func runRmmod(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), driverUnloadTimeout)
+	defer cancel()
+
+	output, err := sysRunner.Exec(ctx, "rmmod", args...)
+	if ctx.Err() == context.DeadlineExceeded {
+		printError(fmt.Sprintf("driver unload timed out after %s", driverUnloadTimeout))
+		return output, fmt.Errorf("rmmod %s: %w", strings.Join(args, " "), context.DeadlineExceeded)
+	}
+	return output, err
+}
+
+func unloadNetworkDriver(driverName string) error {
+	if driverName == "" {
+		return fmt.Errorf("driver name is empty")
+	}
+
+	printInfo(fmt.Sprintf("Unloading driver: %s", driverName))
+
+	// Сначала попробуем выгрузить по имени модуля
+	output, err := runRmmod(driverName)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err != nil {
+		// Если не получилось, попробуем форсированно
+		printWarning(fmt.Sprintf("Normal rmmod failed, trying force: %v", err))
+		output, err = runRmmod("-f", driverName)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("rmmod failed: %v\nOutput: %s", err, string(output))
+		}
+	}
+
+	invalidateSystemCache()
+	printSuccess(fmt.Sprintf("Driver %s unloaded successfully", driverName))
+	return nil
+}
+
+// maxParallelDriverReloads ограничивает число одновременных modprobe
+const maxParallelDriverReloads = 4
+
+func reloadIntelDrivers(drivers []string) {
+	start := time.Now()
+	sem := make(chan struct{}, maxParallelDriverReloads)
+	var wg sync.WaitGroup
+
+	// Драйверы независимы - грузим параллельно, каждый ждем через waitForDriverLoad
+	for _, driver := range drivers {
+		wg.Add(1)
+		go func(driver string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if err := loadNetworkDriver(driver); err != nil {
+				printWarning(fmt.Sprintf("Failed to reload driver %s: %v", driver, err))
+			} else {
+				printSuccess(fmt.Sprintf("Driver %s reloaded successfully", driver))
+			}
+		}(driver)
+	}
+	wg.Wait()
+
+	printDebug(fmt.Sprintf("Driver reload took %s", time.Since(start).Round(time.Millisecond)))
+}
+
+// pollUntil проверяет условие каждые interval до успеха или таймаута; возвращает затраченное время
+func pollUntil(timeout, interval time.Duration, check func() bool) (time.Duration, bool) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	for {
+		if check() {
+			return time.Since(start), true
+		}
+		if time.Now().After(deadline) {
+			return time.Since(start), false
+		}
+		time.Sleep(interval)
+	}
+}
+
+// readStableNetworkInterfaces перечитывает интерфейсы, пока их число не совпадет в двух чтениях подряд
+// и не покроет сетевые PCI функции из lspci (или не выйдет timeout). После перезагрузки драйверов
+// ip/sysfs отдают список, в котором часть портов еще не создана. Возвращает число чтений
+func readStableNetworkInterfaces(timeout time.Duration) ([]NetworkInterface, int, error) {
+	expected := countNetworkPCIFunctions()
+	var current []NetworkInterface
+	var lastErr error
+	attempts, previous := 0, -1
+
+	elapsed, ok := pollUntil(timeout, 500*time.Millisecond, func() bool {
+		attempts++
+		list, err := readNetworkInterfaces(pollingRunner())
+		if err != nil {
+			lastErr = err
+			return false
+		}
+		current = list
+		stable := len(list) == previous
+		previous = len(list)
+		return stable && countPCIBackedInterfaces(list) >= expected
+	})
+
+	if current == nil && lastErr != nil {
+		return nil, attempts, lastErr
+	}
+	backed := countPCIBackedInterfaces(current)
+	if ok {
+		printInfo(fmt.Sprintf("Network interfaces stable after %d read(s): %d interface(s), %d/%d PCI network function(s)",
+			attempts, len(current), backed, expected))
+	} else {
+		printWarning(fmt.Sprintf("Network interface list may be incomplete after %d read(s) in %s: %d/%d PCI network function(s) have interfaces",
+			attempts, elapsed.Round(time.Millisecond), backed, expected))
+	}
+	return current, attempts, nil
+}
+
+// pciPassthroughDrivers - драйверы проброса в ВМ: функция под ними сетевого интерфейса не создает
+var pciPassthroughDrivers = map[string]bool{"vfio-pci": true, "pci-stub": true}
+
+// countNetworkPCIFunctions считает PCI функции класса 02xx (сетевые контроллеры) с привязанным сетевым драйвером.
+// Функция без драйвера (отключенный порт, нет модуля) или под vfio интерфейса не создаст - ждать ее нельзя.
+// Сначала sysfs, без него - lspci -nk; ничего не доступно - 0, сверка по PCI не выполняется
+func countNetworkPCIFunctions() int {
+	const devices = "/sys/bus/pci/devices"
+	if names, err := sysRunner.ReadDir(devices); err == nil {
+		count := 0
+		for _, name := range names {
+			if !strings.HasPrefix(readSysfsValue(filepath.Join(devices, name, "class")), "0x02") {
+				continue
+			}
+			if link, err := sysRunner.Readlink(filepath.Join(devices, name, "driver")); err == nil && !pciPassthroughDrivers[filepath.Base(link)] {
+				count++
+			}
+		}
+		return count
+	}
+	output, err := sysRunner.Run("lspci", "-nk")
+	if err != nil {
+		return 0
+	}
+	return parseLspciNetworkFunctions(string(output))
+}
+
+// parseLspciNetworkFunctions считает в выводе lspci -nk сетевые функции со строкой "Kernel driver in use"
+func parseLspciNetworkFunctions(output string) int {
+	count := 0
+	network := false
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			fields := strings.Fields(line) // 00:1f.6 0200: 8086:15bb (rev 10)
+			network = len(fields) >= 2 && strings.HasPrefix(fields[1], "02")
+			continue
+		}
+		if driver, ok := strings.CutPrefix(strings.TrimSpace(line), "Kernel driver in use:"); ok && network {
+			if !pciPassthroughDrivers[strings.TrimSpace(driver)] {
+				count++
+			}
+			network = false
+		}
+	}
+	return count
+}
+
+// countPCIBackedInterfaces - интерфейсы с PCI устройством (без lo, VLAN, bond и прочих виртуальных)
+func countPCIBackedInterfaces(interfaces []NetworkInterface) int {
+	count := 0
+	for _, iface := range interfaces {
+		if _, err := sysRunner.Readlink(filepath.Join("/sys/class/net", iface.Name, "device")); err == nil {
+			count++
+		}
+	}
+	return count
+}
+
+// waitForMACs ждет появления всех ожидаемых MAC адресов на интерфейсах.
+// Интерфейсы перечитываются на каждом опросе в обход кэша, чтобы не принять старое состояние.
+func waitForMACs(expected []string, timeout time.Duration) ([]NetworkInterface, bool) {
+	var interfaces []NetworkInterface
+
+	elapsed, ok := pollUntil(timeout, 500*time.Millisecond, func() bool {
+		current, err := readNetworkInterfaces(pollingRunner())
+		if err != nil {
+			return false
+		}
+		interfaces = current
+		for _, mac := range expected {
+			if present, _ := isTargetMACPresent(mac, current); !present {
+				return false
+			}
+		}
+		return true
+	})
+
+	if ok {
+		printDebug(fmt.Sprintf("All %d expected MAC(s) appeared after %s", len(expected), elapsed.Round(time.Millisecond)))
+	} else {
+		printWarning(fmt.Sprintf("Not all expected MACs appeared within %s", elapsed.Round(time.Millisecond)))
+	}
+	return interfaces, ok
+}
+
+// waitForMACPresent ждет появления MAC на любом интерфейсе и возвращает имя интерфейса
+func waitForMACPresent(mac string, timeout time.Duration) (string, error) {
+	var name string
+	elapsed, ok := pollUntil(timeout, 500*time.Millisecond, func() bool {
+		interfaces, err := readNetworkInterfaces(pollingRunner())
+		if err != nil {
+			return false
+		}
+		var present bool
+		present, name = isTargetMACPresent(mac, interfaces)
+		return present
+	})
+
+	if !ok {
+		return "", fmt.Errorf("MAC %s did not appear within %s", mac, elapsed.Round(time.Millisecond))
+	}
+	printDebug(fmt.Sprintf("MAC %s appeared on %s after %s", mac, name, elapsed.Round(time.Millisecond)))
+	return name, nil
+}
+
+// bringInterfaceUp поднимает интерфейс и ждет state UP. Ждать без ip link set up бессмысленно:
+// после перезагрузки драйвера интерфейс остается DOWN, пока его не поднимут
+func bringInterfaceUp(name string, timeout time.Duration) error {
+	printInfo(fmt.Sprintf("Bringing interface %s UP...", name))
+	tracedRun(exec.Command("ip", "link", "set", name, "up"))
+	return waitForInterfaceUp(name, timeout)
+}
+
+// waitForInterfaceUp опрашивает ip link show, пока интерфейс не перейдет в state UP
+func waitForInterfaceUp(name string, timeout time.Duration) error {
+	elapsed, ok := pollUntil(timeout, 200*time.Millisecond, func() bool {
+		output, err := tracedOutput(exec.Command("ip", "link", "show", name))
+		return err == nil && strings.Contains(string(output), "state UP")
+	})
+
+	if !ok {
+		return fmt.Errorf("interface %s not UP after %s", name, elapsed.Round(time.Millisecond))
+	}
+	printDebug(fmt.Sprintf("Interface %s UP after %s", name, elapsed.Round(time.Millisecond)))
+	return nil
+}
+
+// waitForFRUReady ждет, пока FRU снова читается через ipmitool (после записи)
+func waitForFRUReady(timeout time.Duration) bool {
+	elapsed, ok := pollUntil(timeout, 500*time.Millisecond, func() bool {
+		return tracedRun(exec.Command("ipmitool", "fru", "print", "0")) == nil
+	})
+
+	if ok {
+		printDebug(fmt.Sprintf("FRU ready after %s", elapsed.Round(time.Millisecond)))
+	} else {
+		printWarning(fmt.Sprintf("FRU not readable after %s", elapsed.Round(time.Millisecond)))
+	}
+	return ok
+}
+
+// Функция для загрузки стандартного сетевого драйвера (улучшенная версия)
+func loadNetworkDriver(driverName string) error {
+	if driverName == "" {
+		return fmt.Errorf("driver name is empty")
+	}
+
+	printInfo(fmt.Sprintf("Loading driver: %s", driverName))
+	cmd := exec.Command("modprobe", driverName)
+	output, err := tracedCombinedOutput(cmd)
+	invalidateSystemCache()
+	if err != nil {
+		return fmt.Errorf("modprobe failed: %v\nOutput: %s", err, string(output))
+	}
+
+	// Ждем загрузки драйвера с таймаутом
+	if err := waitForDriverLoad(driverName, 10); err != nil {
+		printWarning(fmt.Sprintf("Driver load verification timeout: %v", err))
+	} else {
+		printSuccess(fmt.Sprintf("Driver %s loaded successfully", driverName))
+	}
+
+	return nil
+}
+
+// Функция для получения версии текущего ядра
+func getKernelVersion() (string, error) {
+	output, err := sysRunner.Run("uname", "-r")
+	if err != nil {
+		return "", fmt.Errorf("failed to get kernel version: %v", err)
+	}
+
+	version := strings.TrimSpace(string(output))
+	return version, nil
+}
+
+// Функция для подготовки pgdrv драйвера с проверкой начального состояния
+func preparePgdrvDriver(driverDir, originalDriver string, primaryInterface *NetworkInterface) (string, error) {
+	printInfo("Checking initial driver state...")
+
+	// Проверяем начальное состояние
+	pgdrvLoaded, realtekActive := checkInitialDriverState(primaryInterface)
+
+	printInfo(fmt.Sprintf("Initial state: pgdrv loaded=%t, realtek active=%t", pgdrvLoaded, realtekActive))
+
+	if pgdrvLoaded && !realtekActive {
+		// Случай 1: pgdrv уже загружен и нет конфликтующих Realtek драйверов
+		if err := ensurePgdrvDeviceNode(); err != nil {
+			// Модуль висит без рабочего узла - перезагружаем его с нуля
+			printWarning(fmt.Sprintf("Pre-loaded pgdrv is not usable (%v) - reloading", err))
+			if err := removeStalePgdrv(); err != nil {
+				return "", fmt.Errorf("failed to remove unusable pgdrv: %v", err)
+			}
+			return loadFlashingDriver(driverDir, originalDriver)
+		}
+		printSuccess("pgdrv already loaded and no conflicting Realtek drivers - ready for flashing")
+		return pgdrvPreloaded, nil
+	}
+
+	if pgdrvLoaded && realtekActive {
+		// Случай 2: pgdrv загружен, но есть активный Realtek драйвер - конфликт
+		printWarning("pgdrv loaded but Realtek driver also active - resolving conflict")
+
+		// Выгружаем оба драйвера
+		if err := unloadPgdrvDriver(); err != nil {
+			printError(fmt.Sprintf("Failed to unload pgdrv: %v", err))
+		}
+		if err := unloadNetworkDriver(primaryInterface.Driver); err != nil {
+			printError(fmt.Sprintf("Failed to unload Realtek driver %s: %v", primaryInterface.Driver, err))
+		}
+
+		printInfo("Both drivers unloaded, proceeding to load clean pgdrv...")
+	} else if !pgdrvLoaded && realtekActive {
+		// Случай 3: Стандартная ситуация - pgdrv не загружен, Realtek активен
+		printInfo("Standard case: unloading Realtek driver to load pgdrv")
+		if err := unloadNetworkDriver(primaryInterface.Driver); err != nil {
+			return "", fmt.Errorf("failed to unload Realtek driver %s: %v", primaryInterface.Driver, err)
+		}
+	} else {
+		// Случай 4: Ни один драйвер не загружен
+		printInfo("No conflicting drivers found, proceeding to load pgdrv")
+	}
+
+	// Загружаем pgdrv драйвер
+	return loadFlashingDriver(driverDir, originalDriver)
+}
+
+// Заменяем функцию compileFlashingDriver на реальную реализацию
+func compileFlashingDriver(driverDir string, originalDriver string) (string, error) {
+	printInfo("Compiling rtnicpg driver from sources...")
+
+	// Проверяем наличие необходимых инструментов для компиляции
+	if err := checkBuildRequirements(); err != nil {
+		return "", fmt.Errorf("build requirements not met: %v", err)
+	}
+
+	// Ищем исходники rtnicpg
+	sourceDir, found := checkRtnicpgSources(driverDir)
+	if !found {
+		return "", fmt.Errorf("rtnicpg source directory not found in %s", driverDir)
+	}
+
+	// Сохраняем текущую директорию
+	originalDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %v", err)
+	}
+
+	// Переходим в директорию с исходниками
+	if err := os.Chdir(sourceDir); err != nil {
+		return "", fmt.Errorf("failed to change to source directory %s: %v", sourceDir, err)
+	}
+
+	// Восстанавливаем директорию при выходе
+	defer func() {
+		os.Chdir(originalDir)
+	}()
+
+	// Очищаем предыдущие артефакты сборки
+	printInfo("Cleaning previous build artifacts...")
+	cleanCmd := exec.Command("make", "clean")
+	cleanCmd.Dir = sourceDir
+	if output, err := tracedCombinedOutput(cleanCmd); err != nil {
+		printWarning(fmt.Sprintf("Clean failed (non-critical): %v\nOutput: %s", err, string(output)))
+	}
+
+	// Получаем версию ядра для переменной окружения
+	kernelVersion, err := getKernelVersion()
+	if err != nil {
+		return "", fmt.Errorf("failed to get kernel version: %v", err)
+	}
+
+	// Компилируем драйвер
+	printInfo("Building driver module...")
+	buildCmd := exec.Command("make", "all")
+	buildCmd.Dir = sourceDir
+	buildCmd.Env = append(os.Environ(),
+		"KERNELDIR=/lib/modules/"+kernelVersion+"/build",
+	)
+
+	output, err := tracedCombinedOutput(buildCmd)
+	if err != nil {
+		return "", fmt.Errorf("compilation failed: %v\nOutput: %s", err, string(output))
+	}
+
+	// Проверяем, что файл pgdrv.ko был создан
+	compiledDriverPath := filepath.Join(sourceDir, "pgdrv.ko")
+	if _, err := os.Stat(compiledDriverPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("compilation succeeded but pgdrv.ko not found at %s", compiledDriverPath)
+	}
+
+	printSuccess("Driver compilation completed successfully")
+
+	// Сохраняем драйвер в папку драйверов
+	savedDriverPath, err := saveCompiledDriver(sourceDir, driverDir, originalDriver, kernelVersion)
+	if err != nil {
+		return "", fmt.Errorf("failed to save compiled driver: %v", err)
+	}
+
+	return savedDriverPath, nil
+}
+
+// Функция для определения является ли драйвер Realtek'овским
+func isRealtekDriver(driverName string) bool {
+	realtekDrivers := []string{
+		"r8169",   // Realtek RTL8169/8110 PCI Gigabit Ethernet
+		"r8168",   // Realtek RTL8168 PCI Express Gigabit Ethernet
+		"rtl8169", // Alternative name
+		"rtl8168", // Alternative name
+		"r8125",   // Realtek RTL8125 2.5Gigabit Ethernet
+		"rtl8125", // Alternative name
+		"8139too", // Realtek RTL-8139 (legacy)
+		"8139cp",  // Realtek RTL-8139C+ (legacy)
+		"rtl8139", // Alternative name (legacy)
+		"r8152",   // Realtek RTL8152/RTL8153 USB Ethernet
+		"rtl8152", // Alternative name
+	}
+
+	driverLower := strings.ToLower(driverName)
+	for _, realtekDriver := range realtekDrivers {
+		if driverLower == realtekDriver {
+			return true
+		}
+	}
+	return false
+}
+
+// isIntelDriver проверяет, является ли драйвер драйвером Intel NIC (прошивка через eeupdate)
+func isIntelDriver(driverName string) bool {
+	intelDrivers := []string{"igb", "e1000e", "ixgbe", "i40e", "ice"}
+
+	driverLower := strings.ToLower(driverName)
+	for _, intelDriver := range intelDrivers {
+		if driverLower == intelDriver {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFlashMethod выбирает метод прошивки MAC по активным драйверам сетевых карт.
+// Realtek имеет приоритет: на платах с Realtek штатной картой Intel обычно нет.
+func detectFlashMethod(interfaces []NetworkInterface) (string, error) {
+	for _, iface := range interfaces {
+		if isRealtekDriver(iface.Driver) {
+			return "rtnicpg", nil
+		}
+	}
+	for _, iface := range interfaces {
+		if isIntelDriver(iface.Driver) {
+			return "eeupdate", nil
+		}
+	}
+	return "", fmt.Errorf("no Realtek or Intel network driver is active")
+}
+
+// resolveFlashMethod возвращает метод прошивки для pre-flight проверок.
+// Для "auto" метод определяется по интерфейсам, при неудаче считается eeupdate (прежнее значение по умолчанию).
+func resolveFlashMethod(method string) string {
+	if method != "" && method != "auto" {
+		return method
+	}
+	interfaces, err := getCurrentNetworkInterfaces()
+	if err != nil {
+		return "eeupdate"
+	}
+	detected, err := detectFlashMethod(interfaces)
+	if err != nil {
+		return "eeupdate"
+	}
+	return detected
+}
+
+// Функция для поиска Realtek интерфейса среди доступных (обновленная с диагностикой)
+func findRealtekInterface(interfaces []NetworkInterface) *NetworkInterface {
+	printInfo("Searching for Realtek interfaces...")
+
+	var realtekInterfaces []*NetworkInterface
+
+	// Собираем все Realtek интерфейсы
+	for i := range interfaces {
+		if interfaces[i].Driver != "" && isRealtekDriver(interfaces[i].Driver) {
+			realtekInterfaces = append(realtekInterfaces, &interfaces[i])
+			printInfo(fmt.Sprintf("Found Realtek interface: %s (Driver: %s, State: %s, IP: %s)",
+				interfaces[i].Name, interfaces[i].Driver, interfaces[i].State, interfaces[i].IP))
+		}
+	}
+
+	if len(realtekInterfaces) == 0 {
+		printWarning("No Realtek interfaces found by driver name")
+		return nil
+	}
+
+	// Сначала ищем активный Realtek интерфейс с IP
+	for _, iface := range realtekInterfaces {
+		if iface.IP != "" && iface.State == "UP" {
+			printSuccess(fmt.Sprintf("Selected active Realtek interface with IP: %s", iface.Name))
+			return iface
+		}
+	}
+
+	// Если не нашли активный с IP, ищем активный без IP
+	for _, iface := range realtekInterfaces {
+		if iface.State == "UP" {
+			printInfo(fmt.Sprintf("Selected active Realtek interface (no IP): %s", iface.Name))
+			return iface
+		}
+	}
+
+	// Если не нашли активный, берем первый найденный
+	printWarning(fmt.Sprintf("Selected inactive Realtek interface: %s", realtekInterfaces[0].Name))
+	return realtekInterfaces[0]
+}
+
+// Flashing execution functions
+
+// pgdrvPreloaded - DriverPath, когда pgdrv был загружен до начала прошивки
+const pgdrvPreloaded = "pgdrv_already_loaded"
+
+// RtnicpgSession ведет жизненный цикл pgdrv на время прошивки Realtek:
+// подготовка модуля, прошивка rtnic и возврат штатного драйвера интерфейса.
+type RtnicpgSession struct {
+	DriverPath     string // Загруженный pgdrv.ko или pgdrvPreloaded
+	RtnicBinary    string // Утилита rtnic (по умолчанию ищется в PATH)
+	OriginalDriver string // Драйвер интерфейса до прошивки, восстанавливается в Cleanup
+
+	iface       *NetworkInterface
+	pgdrvLoaded bool // pgdrv загружен этой сессией и должен быть выгружен
+	cleaned     bool
+}
+
+func newRtnicpgSession(iface *NetworkInterface) *RtnicpgSession {
+	return &RtnicpgSession{
+		RtnicBinary:    "rtnic",
+		OriginalDriver: iface.Driver,
+		iface:          iface,
+	}
+}
+
+// PrepareDriver выгружает штатный драйвер и загружает (при необходимости собирает) pgdrv
+func (s *RtnicpgSession) PrepareDriver(driverDir string) error {
+	driverPath, err := preparePgdrvDriver(driverDir, s.OriginalDriver, s.iface)
+	if err != nil {
+		printWarning("Failed to prepare pgdrv driver, original driver will be restored")
+		return fmt.Errorf("failed to prepare pgdrv driver: %v", err)
+	}
+	s.DriverPath = driverPath
+	s.pgdrvLoaded = driverPath != pgdrvPreloaded
+
+	if err := verifyPgdrvLoaded(); err != nil {
+		printError("pgdrv module not found after preparation, original driver will be restored")
+		s.pgdrvLoaded = false
+		return fmt.Errorf("pgdrv module verification failed: %v", err)
+	}
+	printSuccess("pgdrv module confirmed loaded and ready for flashing")
+	return nil
+}
+
+// Flash прошивает MAC в efuse через rtnic (pgdrv должен быть загружен)
+func (s *RtnicpgSession) Flash(targetMAC string) error {
+	// Remove colons from MAC for rtnic
+	macWithoutColons := strings.ReplaceAll(targetMAC, ":", "")
+
+	printInfo(fmt.Sprintf("Executing rtnic flashing for MAC: %s", targetMAC))
+
+	// Execute rtnic with required arguments
+	cmd := exec.Command(s.RtnicBinary, "/efuse", "/nicmac", "/nodeid", macWithoutColons)
+	output, err := tracedCombinedOutput(cmd)
+
+	if err != nil {
+		return fmt.Errorf("rtnic command failed: %v\nOutput: %s", err, string(output))
+	}
+
+	// Check if output indicates success
+	outputStr := string(output)
+	if strings.Contains(strings.ToLower(outputStr), "error") || strings.Contains(strings.ToLower(outputStr), "fail") {
+		return fmt.Errorf("rtnic reported error: %s", outputStr)
+	}
+
+	printSuccess("rtnic flashing command completed successfully")
+	return nil
+}
+
+// Cleanup выгружает pgdrv, загруженный сессией, и возвращает штатный драйвер.
+// Повторный вызов ничего не делает, поэтому его можно и отложить, и вызвать явно.
+// Предзагруженный pgdrv остается активным, как и до прошивки.
+func (s *RtnicpgSession) Cleanup() error {
+	if s.cleaned {
+		return nil
+	}
+	s.cleaned = true
+
+	if s.DriverPath == pgdrvPreloaded {
+		printInfo("pgdrv was pre-loaded, leaving it active (not restoring original driver)")
+		return nil
+	}
+
+	printInfo("Cleaning up: unloading pgdrv and restoring original driver...")
+	var errs []string
+	if s.pgdrvLoaded {
+		if err := unloadPgdrvDriver(); err != nil {
+			printError(fmt.Sprintf("Warning: failed to unload pgdrv module: %v", err))
+			errs = append(errs, err.Error())
+		}
+		s.pgdrvLoaded = false
+	}
+
+	if err := loadNetworkDriver(s.OriginalDriver); err != nil {
+		printError(fmt.Sprintf("Warning: failed to restore original driver %s: %v", s.OriginalDriver, err))
+		errs = append(errs, err.Error())
+	} else {
+		printSuccess(fmt.Sprintf("Original driver %s restored successfully", s.OriginalDriver))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("rtnicpg cleanup: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// inetCIDRRegex - адрес с префиксом из строки "inet 192.168.1.5/27 brd ..." вывода ip addr show
+var inetCIDRRegex = regexp.MustCompile(`\binet (\d+\.\d+\.\d+\.\d+/\d+)`)
+
+// interfaceIPCIDR возвращает адрес ip интерфейса вместе с длиной префикса
+func interfaceIPCIDR(interfaceName, ip string) (string, error) {
+	output, err := tracedOutput(exec.Command("ip", "addr", "show", "dev", interfaceName))
+	if err != nil {
+		return "", fmt.Errorf("ip addr show %s failed: %v", interfaceName, err)
+	}
+	for _, match := range inetCIDRRegex.FindAllStringSubmatch(string(output), -1) {
+		if strings.SplitN(match[1], "/", 2)[0] == ip {
+			return match[1], nil
+		}
+	}
+	return "", fmt.Errorf("no inet %s on %s", ip, interfaceName)
+}
+
+// captureIPCIDR сохраняет адрес интерфейса с префиксом до выгрузки драйвера.
+// Если префикс не прочитать, остается прежнее предположение /24
+func captureIPCIDR(interfaceName, ip string) string {
+	if ip == "" {
+		return ""
+	}
+	cidr, err := interfaceIPCIDR(interfaceName, ip)
+	if err != nil {
+		printWarning(fmt.Sprintf("Cannot read prefix length of %s (%v), assuming /24", ip, err))
+		return ip + "/24"
+	}
+	return cidr
+}
+
+// captureDefaultRoute сохраняет строку маршрута по умолчанию через интерфейс (пусто, если его нет)
+func captureDefaultRoute(interfaceName string) string {
+	output, err := tracedOutput(exec.Command("ip", "route", "show", "default", "dev", interfaceName))
+	if err != nil {
+		printDebug(fmt.Sprintf("Cannot read default route of %s: %v", interfaceName, err))
+		return ""
+	}
+	route := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+	if route != "" {
+		printInfo(fmt.Sprintf("Default route saved: %s dev %s", route, interfaceName))
+	}
+	return route
+}
+
+// restoreDefaultRoute добавляет сохраненный маршрут по умолчанию обратно с тем же шлюзом и метрикой
+func restoreDefaultRoute(interfaceName, route string) error {
+	var gateway, metric string
+	fields := strings.Fields(route)
+	for i := 0; i+1 < len(fields); i++ {
+		switch fields[i] {
+		case "via":
+			gateway = fields[i+1]
+		case "metric":
+			metric = fields[i+1]
+		}
+	}
+	if gateway == "" {
+		return fmt.Errorf("no gateway in route %q", route)
+	}
+
+	args := []string{"route", "add", "default", "via", gateway, "dev", interfaceName}
+	if metric != "" {
+		args = append(args, "metric", metric)
+	}
+	output, err := tracedCombinedOutput(exec.Command("ip", args...))
+	if err != nil {
+		// Маршрут мог вернуть сам NetworkManager/dhclient
+		if strings.Contains(string(output), "File exists") {
+			return nil
+		}
+		return fmt.Errorf("ip %s failed: %v\nOutput: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// restoreSummaryRoute восстанавливает маршрут по умолчанию после возврата IP и отмечает это в сводке
+func restoreSummaryRoute(interfaceName string, summary *FlashMACSummary) {
+	if summary.OriginalDefaultRoute == "" {
+		return
+	}
+	if err := restoreDefaultRoute(interfaceName, summary.OriginalDefaultRoute); err != nil {
+		summary.RouteRestoreError = err.Error()
+		printWarning(fmt.Sprintf("Failed to restore default route on %s: %v", interfaceName, err))
+		return
+	}
+	summary.RouteRestored = true
+	printSuccess(fmt.Sprintf("Default route restored: %s dev %s", summary.OriginalDefaultRoute, interfaceName))
+}
+
+// restoreIPAddress назначает адрес в виде ip/префикс (как сохранил captureIPCIDR)
+func restoreIPAddress(interfaceName, ipCIDR string) error {
+	if interfaceName == "" || ipCIDR == "" {
+		return fmt.Errorf("interface name or IP address is empty")
+	}
+	if !strings.Contains(ipCIDR, "/") {
+		return fmt.Errorf("IP address %s has no prefix length", ipCIDR)
+	}
+	ipAddress := strings.SplitN(ipCIDR, "/", 2)[0]
+
+	printInfo(fmt.Sprintf("Restoring IP %s to interface %s", ipCIDR, interfaceName))
+
+	// First ensure interface is up
+	cmd := exec.Command("ip", "link", "set", interfaceName, "up")
+	tracedRun(cmd)
+
+	time.Sleep(1 * time.Second)
+
+	cmd = exec.Command("ip", "addr", "add", ipCIDR, "dev", interfaceName)
+	output, err := tracedCombinedOutput(cmd)
+	if err != nil {
+		// IP might already be assigned, check if it's actually there
+		checkCmd := exec.Command("ip", "addr", "show", interfaceName)
+		checkOutput, _ := tracedOutput(checkCmd)
+		if strings.Contains(string(checkOutput), ipAddress) {
+			printSuccess(fmt.Sprintf("IP %s already assigned to %s", ipAddress, interfaceName))
+			return nil
+		}
+		return fmt.Errorf("failed to assign IP: %v\nOutput: %s", err, string(output))
+	}
+
+	invalidateSystemCache()
+	printSuccess(fmt.Sprintf("IP %s restored to interface %s", ipCIDR, interfaceName))
+	return nil
+}
+
+// smbiosDMIKeywords - ключ dmidecode -s для проверки текущего значения строки перед записью
+var smbiosDMIKeywords = map[string]string{
+	"/SM": "system-manufacturer",
+	"/SP": "system-product-name",
+	"/SV": "system-version",
+	"/SS": "system-serial-number",
+	"/SK": "system-sku-number",
+	"/SF": "system-family",
+	"/BM": "baseboard-manufacturer",
+	"/BP": "baseboard-product-name",
+	"/BV": "baseboard-version",
+	"/BS": "baseboard-serial-number",
+	"/BT": "baseboard-asset-tag",
+}
+
+// smbiosSourceValue возвращает значение для строки SMBIOS из данных прошивки или конфига
+func smbiosSourceValue(source string, systemConfig SystemConfig, flashData *FlashData) (string, error) {
+	switch source {
+	case "system_serial":
+		return flashData.SystemSerial, nil
+	case "io_board":
+		return flashData.IOBoard, nil
+	case "mac":
+		return strings.ReplaceAll(strings.ToUpper(flashData.MAC), ":", ""), nil
+	case "manufacturer":
+		return systemConfig.Manufacturer, nil
+	case "product":
+		return systemConfig.Product, nil
+	default:
+		return "", fmt.Errorf("unknown SMBIOS value source %q (expected system_serial, io_board, mac, manufacturer or product)", source)
+	}
+}
+
+// smbiosOptions - опции утилиты в стабильном порядке
+func smbiosOptions(config SMBIOSConfig) []string {
+	options := make([]string, 0, len(config.Strings))
+	for option := range config.Strings {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+	return options
+}
+
+// smbiosCommandLine - вызов утилиты для одной строки SMBIOS в том виде, в каком он выполняется
+func smbiosCommandLine(tool, option, value string) string {
+	return fmt.Sprintf("%s %s %s", tool, option, shellQuote(value))
+}
+
+// smbiosPlannedInvocations перечисляет вызовы утилиты для плана. Значения из конфига подставляются,
+// вводимые оператором (серийный номер, IO, MAC) показываются как <источник>
+func smbiosPlannedInvocations(config SMBIOSConfig, systemConfig SystemConfig) []string {
+	var invocations []string
+	for _, option := range smbiosOptions(config) {
+		source := config.Strings[option]
+		var line string
+		switch source {
+		case "system_serial", "io_board", "mac":
+			line = fmt.Sprintf("%s %s <%s>", config.ToolPath, option, source)
+		default:
+			value, err := smbiosSourceValue(source, systemConfig, &FlashData{})
+			if err != nil || value == "" {
+				invocations = append(invocations, fmt.Sprintf("%s skipped (no %s value)", option, source))
+				continue
+			}
+			line = smbiosCommandLine(config.ToolPath, option, value)
+		}
+		if keyword, ok := smbiosDMIKeywords[strings.ToUpper(option)]; ok {
+			line += fmt.Sprintf(" (unless dmidecode -s %s already matches)", keyword)
+		}
+		invocations = append(invocations, line)
+	}
+	return invocations
+}
+
+// readDMIString читает текущее значение строки через dmidecode -s
+func readDMIString(keyword string) (string, error) {
+	output, err := tracedOutput(exec.Command("dmidecode", "-s", keyword))
+	if err != nil {
+		return "", fmt.Errorf("dmidecode -s %s failed: %v", keyword, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// flashSMBIOS записывает строки SMBIOS утилитой вендора по одной; строки с уже верным значением пропускаются.
+// details - итог по каждой строке для FlashResult.
+func flashSMBIOS(config SMBIOSConfig, systemConfig SystemConfig, flashData *FlashData) (changed bool, details []string, err error) {
+	if config.ToolPath == "" {
+		return false, nil, fmt.Errorf("flash.smbios.tool_path is not set")
+	}
+	tool, err := exec.LookPath(config.ToolPath)
+	if err != nil {
+		return false, nil, fmt.Errorf("SMBIOS tool %s not found: %v", config.ToolPath, err)
+	}
+	if len(config.Strings) == 0 {
+		return false, nil, fmt.Errorf("flash.smbios.strings is empty")
+	}
+
+	var successRegex *regexp.Regexp
+	if config.SuccessRegex != "" {
+		if successRegex, err = regexp.Compile(config.SuccessRegex); err != nil {
+			return false, nil, fmt.Errorf("invalid flash.smbios.success_regex: %v", err)
+		}
+	}
+
+	var failed []string
+	for _, option := range smbiosOptions(config) {
+		value, err := smbiosSourceValue(config.Strings[option], systemConfig, flashData)
+		if err != nil {
+			return changed, details, err
+		}
+		if value == "" {
+			details = append(details, fmt.Sprintf("%s skipped (no %s value)", option, config.Strings[option]))
+			continue
+		}
+
+		if keyword, ok := smbiosDMIKeywords[strings.ToUpper(option)]; ok {
+			if current, err := readDMIString(keyword); err == nil && current == value {
+				printInfo(fmt.Sprintf("SMBIOS %s already contains %q - skipping", option, value))
+				details = append(details, fmt.Sprintf("%s already %s", option, value))
+				continue
+			} else if err == nil {
+				printInfo(fmt.Sprintf("SMBIOS %s current value: %q, updating to: %q", option, current, value))
+			}
+		}
+
+		printDebug("Executing: " + smbiosCommandLine(tool, option, value))
+		output, runErr := tracedCombinedOutput(exec.Command(tool, option, value))
+		ok := runErr == nil
+		if ok && successRegex != nil && !successRegex.Match(output) {
+			ok = false
+			runErr = fmt.Errorf("output does not match success_regex")
+		}
+		if !ok {
+			printError(fmt.Sprintf("SMBIOS %s write failed: %v\nOutput: %s", option, runErr, strings.TrimSpace(string(output))))
+			details = append(details, fmt.Sprintf("%s FAILED (%v)", option, runErr))
+			failed = append(failed, option)
+			continue
+		}
+
+		printSuccess(fmt.Sprintf("SMBIOS %s set to %q", option, value))
+		details = append(details, fmt.Sprintf("%s -> %s", option, value))
+		changed = true
+	}
+
+	if len(failed) > 0 {
+		return changed, details, fmt.Errorf("failed to write %s", strings.Join(failed, ", "))
+	}
+	return changed, details, nil
+}
+
+// logDir - куда сохранять дампы FRU до/после прошивки
+func runFlashing(config FlashConfig, flashData *FlashData, systemConfig SystemConfig, logDir string) ([]FlashResult, bool) {
+	var results []FlashResult
+	var serialNumberChanged bool = false
+
+	if !config.Enabled {
+		return results, false
+	}
+
+	fmt.Println(strings.Repeat("-", 80))
+
+	// Логируем то, что будем прошивать
+	printInfo("Flashing operations summary:")
+	if flashData.SystemSerial != "" {
+		printInfo(fmt.Sprintf("  System Serial -> %s", flashData.SystemSerial))
+	}
+	if flashData.IOBoard != "" {
+		printInfo(fmt.Sprintf("  IO Board      -> %s", flashData.IOBoard))
+	}
+	if flashData.MAC != "" {
+		printInfo(fmt.Sprintf("  MAC Address   -> %s", flashData.MAC))
+	}
+
+	if result := checkFlashSerialUniqueness(config, flashData.SystemSerial); result != nil {
+		outputManager.PrintResult(time.Now(), result.Operation, result.Status, result.Duration, result.Details)
+		return append(results, *result), false
+	}
+
+	if config.PSUCheckEnabled {
+		sessionTimer.begin("verification: psu")
+		startTime := time.Now()
+		if err := checkPSUStatus(config.PSUSensorThresholds); err != nil {
+			printError(fmt.Sprintf("Power supply check failed: %v", err))
+			recordAudit("flash_psu_check", "", "FAILED", err.Error())
+			result := FlashResult{Operation: "psu-check", Status: "FAILED", Details: "Flashing aborted: " + err.Error(), Duration: time.Since(startTime)}
+			outputManager.PrintResult(time.Now(), result.Operation, result.Status, result.Duration, result.Details)
+			return append(results, result), false
+		}
+		printSuccess("Power supply sensors are within limits")
+	}
+
+	if config.TemperatureCheckEnabled {
+		if result := waitForSafeTemperature(config.MaxTemperatureCelsius); result != nil {
+			results = append(results, *result)
+			outputManager.PrintResult(time.Now(), result.Operation, result.Status, result.Duration, result.Details)
+			return results, false
+		}
+	}
+
+	for _, operation := range config.Operations {
+		result := FlashResult{
+			Operation: operation,
+			Status:    "PASSED",
+		}
+		var uniqueness *FlashResult // Отдельный результат mac-uniqueness после прошивки MAC
+
+		if flashData.Missing != "" && len(flashOperationFields[operation]) > 0 {
+			result.Status = "SKIPPED"
+			result.Details = flashData.Missing
+			recordAudit("flash_"+operation, "", result.Status, result.Details)
+			results = append(results, result)
+			outputManager.PrintResult(time.Now(), operation, result.Status, 0, result.Details)
+			continue
+		}
+
+		sessionTimer.begin("flash: " + operation)
+		startTime := time.Now()
+
+		switch operation {
+		case "mac":
+			checkMACs := func(macs []string) error {
+				return verifyMACHistory(macs, flashData.SystemSerial, logDir, config.RequireUniqueMACFlash)
+			}
+			if err := checkMACs([]string{flashData.MAC}); err != nil {
+				result.Status = "FAILED"
+				result.Details = err.Error()
+				printError(result.Details)
+				recordAudit("flash_mac", flashData.MAC, result.Status, result.Details)
+				break
+			}
+
+			printInfo(fmt.Sprintf("Flashing MAC address: %s", flashData.MAC))
+			summary, err := flashMAC(config, systemConfig, flashData.MAC, checkMACs)
+			if summary != nil {
+				result.Drivers = summary.Driver
+				result.NICMapping = summary.NICMapping
+				result.Network = macNetworkCheck(summary)
+			}
+			if err != nil {
+				result.Status = "FAILED"
+				result.Details = fmt.Sprintf("MAC flash failed: %v", err)
+				if result.Drivers != nil {
+					result.Details += " | " + formatDriverContext(result.Drivers)
+				}
+			} else {
+				flashedMAC = flashData.MAC
+				var entries []MACHistoryEntry
+				for _, mac := range assignedMACs(summary, flashData.MAC) {
+					entries = append(entries, MACHistoryEntry{MAC: mac, Serial: flashData.SystemSerial, Timestamp: time.Now(), Session: auditSession.SessionID})
+				}
+				if err := appendMACHistory(logDir, entries...); err != nil {
+					printWarning(fmt.Sprintf("MAC history not updated: %v", err))
+				}
+			}
+			recordAudit("flash_mac", flashData.MAC, result.Status, result.Details)
+
+			if err == nil && config.ArpScan {
+				sessionTimer.begin("verification: mac-uniqueness")
+				uniqueness = checkMACUniqueness(flashData.MAC, arpScanTimeout(config))
+			}
+
+		case "efi":
+			printInfo("Updating EFI variables")
+			backup := &efiBackup{Dir: logDir, Serial: flashData.SystemSerial, Session: auditSession.SessionID}
+			efiChanged, efiSerialChanged, err := updateEFIVariables(systemConfig, flashData, backup)
+			if err == nil && flashData.SystemSerial != "" {
+				recordSerialHistory(flashData.SystemSerial, "efi")
+			}
+			if err != nil {
+				result.Status = "FAILED"
+				result.Details = fmt.Sprintf("EFI update failed: %v", err)
+			} else if !efiChanged {
+				result.Status = "SKIPPED"
+				result.Details = "All EFI variables already have correct values"
+			}
+			if len(backup.Files) > 0 {
+				dumpInfo := "EFI dumps: " + strings.Join(backup.Files, ", ")
+				if result.Details != "" {
+					dumpInfo = result.Details + " | " + dumpInfo
+				}
+				result.Details = dumpInfo
+			}
+
+			if efiSerialChanged {
+				serialNumberChanged = true
+				result.SerialChanged = true
+			}
+
+		case "nic-checksum":
+			printInfo("Repairing Intel NIC EEPROM checksums...")
+			checksum := repairNICChecksums(config)
+			result.Status, result.Details, result.NICs = checksum.Status, checksum.Details, checksum.NICs
+			recordAudit("flash_nic_checksum", "", result.Status, result.Details)
+
+		case "smbios":
+			printInfo("Writing SMBIOS strings...")
+			changed, details, err := flashSMBIOS(config.SMBIOS, systemConfig, flashData)
+			result.Details = strings.Join(details, "; ")
+			if err != nil {
+				result.Status = "FAILED"
+				result.Details = strings.TrimPrefix(result.Details+"; ", "; ") + fmt.Sprintf("SMBIOS update failed: %v", err)
+			} else if !changed {
+				result.Status = "SKIPPED"
+			} else {
+				printSuccess("SMBIOS strings updated - reboot required")
+				serialNumberChanged = true // SMBIOS применяется только после сброса
+				result.SerialChanged = true
+			}
+			recordAudit("flash_smbios", flashData.SystemSerial, result.Status, result.Details)
+
+		case "fru":
+			printInfo("Flashing FRU chip...")
+			if flashData.SystemSerial != "" {
+				fruSerialChanged, dumps, err := flashFRU(systemConfig, flashData.SystemSerial, logDir)
+				if err == nil {
+					recordSerialHistory(flashData.SystemSerial, "fru")
+				}
+				if err != nil {
+					result.Status = "FAILED"
+					result.Details = fmt.Sprintf("FRU flash failed: %v", err)
+				} else if !fruSerialChanged {
+					result.Status = "SKIPPED"
+					result.Details = "FRU already contains target serial number"
+				} else {
+					printSuccess("FRU chip flashed successfully")
+					serialNumberChanged = true
+					result.SerialChanged = true
+				}
+				if len(dumps) > 0 {
+					dumpInfo := "FRU dumps: " + strings.Join(dumps, ", ")
+					if result.Details != "" {
+						dumpInfo = result.Details + " | " + dumpInfo
+					}
+					result.Details = dumpInfo
+				}
+			} else {
+				result.Status = "FAILED"
+				result.Details = "No system serial number provided for FRU flashing"
+			}
+		}
+
+		result.Duration = time.Since(startTime)
+		if uniqueness != nil {
+			result.Duration -= uniqueness.Duration
+		}
+		results = append(results, result)
+
+		outputManager.PrintResult(time.Now(), operation, result.Status, result.Duration, result.Details)
+
+		if uniqueness != nil {
+			results = append(results, *uniqueness)
+			outputManager.PrintResult(time.Now(), uniqueness.Operation, uniqueness.Status, uniqueness.Duration, uniqueness.Details)
+		}
+	}
+
+	return results, serialNumberChanged
+}
+
+// temperatureRegex - значение датчика в ipmitool sdr: "CPU1 Temp | 30h | ok | 3.1 | 45 degrees C"
+var temperatureRegex = regexp.MustCompile(`([+-]?[\d.]+) degrees`)
+
+// checkSystemTemperature читает датчики ipmitool sdr type Temperature и возвращает ошибку
+// со списком датчиков выше maxTemp. Датчики без значения (ns, disabled) пропускаются
+func checkSystemTemperature(maxTemp float64) error {
+	output, err := runIPMITool("sdr", "type", "Temperature")
+	if err != nil {
+		return err
+	}
+	var hot []string
+	sensors := 0
+	for _, line := range strings.Split(output, "\n") {
+		match := temperatureRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			continue
+		}
+		sensors++
+		if value > maxTemp {
+			name := strings.TrimSpace(strings.SplitN(line, "|", 2)[0])
+			hot = append(hot, fmt.Sprintf("%s %.0f°C", name, value))
+		}
+	}
+	if sensors == 0 {
+		return fmt.Errorf("no temperature readings in ipmitool sdr output")
+	}
+	if len(hot) > 0 {
+		return fmt.Errorf("temperature above %.0f°C: %s", maxTemp, strings.Join(hot, ", "))
+	}
+	return nil
+}
+
+// sdrSensor - строка ipmitool sdr type: "PSU1 VIN | 5Ah | ok | 10.1 | 228 Volts"
+type sdrSensor struct {
+	Name     string
+	Status   string // ok, ns, cr, nr, nc, lnr, ...
+	Value    float64
+	HasValue bool   // Дискретные датчики ("Presence detected") числа не имеют
+	Event    string // Текст дискретного датчика: "Presence detected, Power Supply AC lost"
+}
+
+var sdrValueRegex = regexp.MustCompile(`^[+-]?[\d.]+`)
+
+// parseSDRSensors разбирает вывод ipmitool sdr type <тип>
+func parseSDRSensors(output string) []sdrSensor {
+	var sensors []sdrSensor
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 5 {
+			continue
+		}
+		sensor := sdrSensor{Name: strings.TrimSpace(fields[0]), Status: strings.ToLower(strings.TrimSpace(fields[2]))}
+		reading := strings.TrimSpace(fields[4])
+		if value := sdrValueRegex.FindString(reading); value != "" {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				sensor.Value, sensor.HasValue = v, true
+			}
+		}
+		if !sensor.HasValue {
+			sensor.Event = reading
+		}
+		sensors = append(sensors, sensor)
+	}
+	return sensors
+}
+
+// psuFaultEvents - события дискретного датчика блока питания (ipmitool, тип 08h), означающие неисправность.
+// Статус такого датчика остается "ok", поэтому его нужно проверять по тексту события
+var psuFaultEvents = []string{"failure detected", "predictive failure", "ac lost", "out-of-range", "config error"}
+
+// psuSensorFaults возвращает события неисправности из текста дискретного датчика
+func psuSensorFaults(event string) []string {
+	var faults []string
+	for _, part := range strings.Split(event, ",") {
+		part = strings.TrimSpace(part)
+		lower := strings.ToLower(part)
+		for _, fault := range psuFaultEvents {
+			if strings.Contains(lower, fault) {
+				faults = append(faults, part)
+				break
+			}
+		}
+	}
+	return faults
+}
+
+// checkPSUStatus проверяет датчики блоков питания и линии VIN/VOUT: состояние по порогам BMC
+// и показания из thresholds (не ниже заданного). Ошибка перечисляет все датчики вне диапазона
+func checkPSUStatus(thresholds map[string]float64) error {
+	var sensors []sdrSensor
+	for _, sensorType := range []string{"Power Supply", "Voltage"} {
+		output, err := runIPMITool("sdr", "type", sensorType)
+		if err != nil {
+			return err
+		}
+		for _, sensor := range parseSDRSensors(output) {
+			upper := strings.ToUpper(sensor.Name)
+			if sensorType == "Voltage" && !strings.Contains(upper, "VIN") && !strings.Contains(upper, "VOUT") {
+				if _, named := thresholds[sensor.Name]; !named {
+					continue
+				}
+			}
+			sensors = append(sensors, sensor)
+		}
+	}
+
+	problems := psuSensorProblems(sensors, thresholds)
+	for _, problem := range problems {
+		printWarning("  PSU sensor out of range: " + problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d power sensor(s) out of range: %s", len(problems), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// psuSensorProblems - датчики питания вне нормы: статус BMC, события дискретных датчиков, пороги thresholds
+func psuSensorProblems(sensors []sdrSensor, thresholds map[string]float64) []string {
+	var problems []string
+	found := make(map[string]bool)
+	for _, sensor := range sensors {
+		found[sensor.Name] = true
+		if sensor.Status != "ok" && sensor.Status != "ns" {
+			problems = append(problems, fmt.Sprintf("%s status %s", sensor.Name, sensor.Status))
+			continue
+		}
+		if faults := psuSensorFaults(sensor.Event); len(faults) > 0 {
+			problems = append(problems, fmt.Sprintf("%s: %s", sensor.Name, strings.Join(faults, ", ")))
+			continue
+		}
+		if limit, ok := thresholds[sensor.Name]; ok {
+			if !sensor.HasValue {
+				problems = append(problems, fmt.Sprintf("%s has no reading", sensor.Name))
+			} else if sensor.Value < limit {
+				problems = append(problems, fmt.Sprintf("%s %.2f below %.2f", sensor.Name, sensor.Value, limit))
+			}
+		}
+	}
+	var names []string
+	for name := range thresholds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !found[name] {
+			problems = append(problems, fmt.Sprintf("%s not found", name))
+		}
+	}
+	return problems
+}
+
+// macHistoryFileName - история прошитых MAC в log_dir (YAML список, только дозапись)
+const macHistoryFileName = "mac_history.yaml"
+
+// MACHistoryEntry - успешная прошивка MAC: какой плате и в какой сессии
+type MACHistoryEntry struct {
+	MAC       string    `yaml:"mac"`
+	Serial    string    `yaml:"serial"`
+	Timestamp time.Time `yaml:"timestamp"`
+	Session   string    `yaml:"session"`
+}
+
+// lockHistory берет эксклюзивную блокировку истории name в dir (параллельные сессии станции); возвращает разблокировку
+func lockHistory(dir, name string) (func(), error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, name+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("lock %s: %v", name, err)
+	}
+	return func() {
+		syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+		lock.Close()
+	}, nil
+}
+
+// appendMACHistory дописывает записи в mac_history.yaml
+func appendMACHistory(logDir string, entries ...MACHistoryEntry) error {
+	for i := range entries {
+		entries[i].MAC = strings.ToUpper(entries[i].MAC)
+	}
+	return appendHistory(logDir, macHistoryFileName, entries)
+}
+
+// assignedMACs - все MAC, записанные картам: таблица eeupdate или один MAC (rtnicpg, MAC уже на месте)
+func assignedMACs(summary *FlashMACSummary, mac string) []string {
+	if summary == nil || summary.NICMapping == nil || len(summary.NICMapping.Assignments) == 0 {
+		return []string{mac}
+	}
+	macs := make([]string, 0, len(summary.NICMapping.Assignments))
+	for _, a := range summary.NICMapping.Assignments {
+		macs = append(macs, a.MAC)
+	}
+	return macs
+}
+
+// readHistory читает историю name из dir под блокировкой; нет файла - пустая история
+func readHistory(dir, name string, history any) error {
+	unlock, err := lockHistory(dir, name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	unlock()
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, history); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// appendHistory дописывает записи (срез) элементами YAML списка, не перечитывая файл
+func appendHistory(dir, name string, entries any) error {
+	unlock, err := lockHistory(dir, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := yaml.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// checkMACHistory ищет MAC в log_dir/mac_history.yaml: прошивался ли он и на какую плату и когда - последний раз
+func checkMACHistory(mac, logDir string) (bool, string, time.Time, error) {
+	var history []MACHistoryEntry
+	if err := readHistory(logDir, macHistoryFileName, &history); err != nil {
+		return false, "", time.Time{}, err
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if strings.EqualFold(history[i].MAC, mac) {
+			return true, history[i].Serial, history[i].Timestamp, nil
+		}
+	}
+	return false, "", time.Time{}, nil
+}
+
+// verifyMACHistory проверяет по истории MAC, которые получат карты; при находке печатает предупреждение о последней прошивке.
+// С requireUnique MAC, прошитый другой плате (или плате без серийника), - ошибка; историю, которую не прочитать,
+// в этом режиме тоже нельзя считать чистой
+func verifyMACHistory(macs []string, serial, logDir string, requireUnique bool) error {
+	for _, mac := range macs {
+		found, previous, seenAt, err := checkMACHistory(mac, logDir)
+		if err != nil {
+			if requireUnique {
+				return fmt.Errorf("MAC history unreadable, uniqueness not verified (require_unique_mac_flash): %v", err)
+			}
+			printWarning(fmt.Sprintf("MAC history not checked: %v", err))
+			return nil
+		}
+		if !found {
+			continue
+		}
+		seen := seenAt.Format("2006-01-02 15:04:05")
+		printWarning(fmt.Sprintf("MAC %s was previously flashed to serial %s at %s", strings.ToUpper(mac), previous, seen))
+		if requireUnique && (previous != serial || previous == "") {
+			return fmt.Errorf("MAC %s was previously flashed to serial %s at %s (require_unique_mac_flash)", strings.ToUpper(mac), previous, seen)
+		}
+	}
+	return nil
+}
+
+// checkFlashSerialUniqueness проверяет серийный номер по истории перед прошивкой FRU/EFI.
+// Повтор - предупреждение, с enforce_unique_serials - отказ (и если историю не прочитать); результат только при отказе
+func checkFlashSerialUniqueness(config FlashConfig, serial string) *FlashResult {
+	if serial == "" || (!hasFlashOperation(config, "fru") && !hasFlashOperation(config, "efi")) {
+		return nil
+	}
+	// Плата уже несет этот номер (повторный запуск на той же плате) - это не дубликат
+	testSystemInfoMutex.Lock()
+	current := testSystemInfo.OriginalMBSerial
+	testSystemInfoMutex.Unlock()
+	if strings.EqualFold(current, serial) {
+		return nil
+	}
+
+	startTime := time.Now()
+	unique, err := checkSerialUniqueness(serial, serialHistory.Dir)
+	if unique {
+		return nil
+	}
+	if !config.EnforceUniqueSerials {
+		if errors.Is(err, errSerialNotUnique) {
+			printWarning(err.Error())
+		} else {
+			printWarning(fmt.Sprintf("Serial history not checked: %v", err))
+		}
+		return nil
+	}
+	details := fmt.Sprintf("Flashing aborted: %v (enforce_unique_serials)", err)
+	printError(details)
+	recordAudit("flash_serial_check", serial, "FAILED", err.Error())
+	return &FlashResult{Operation: "serial-uniqueness", Status: "FAILED", Details: details, Duration: time.Since(startTime)}
+}
+
+// serialHistoryFileName - история прошитых серийных номеров (YAML список, только дозапись)
+const serialHistoryFileName = "serial_history.yaml"
+
+// serialHistory - где ведется история серийных номеров (log.serial_history_dir, иначе log_dir) и от имени какой станции
+var serialHistory struct {
+	Dir     string
+	Station string
+}
+
+// SerialHistoryEntry - успешная запись серийного номера в FRU или EFI
+type SerialHistoryEntry struct {
+	Serial    string    `yaml:"serial"`
+	Operation string    `yaml:"operation"` // fru или efi
+	Timestamp time.Time `yaml:"timestamp"`
+	Session   string    `yaml:"session"`
+	Station   string    `yaml:"station,omitempty"` // Каталог может быть общим для станций (NFS)
+}
+
+// errSerialNotUnique - серийный номер уже записан в другой сессии; текст ошибки называет эту сессию
+var errSerialNotUnique = errors.New("serial number is not unique")
+
+// appendSerialHistory дописывает запись в serial_history.yaml
+func appendSerialHistory(historyDir string, entry SerialHistoryEntry) error {
+	return appendHistory(historyDir, serialHistoryFileName, []SerialHistoryEntry{entry})
+}
+
+// checkSerialUniqueness ищет серийный номер в serial_history.yaml: true - других сессий с ним нет.
+// При находке возвращает errSerialNotUnique с последней сессией, записавшей номер; записи текущей сессии не считаются
+func checkSerialUniqueness(serial string, historyDir string) (bool, error) {
+	var history []SerialHistoryEntry
+	if err := readHistory(historyDir, serialHistoryFileName, &history); err != nil {
+		return false, err
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		if !strings.EqualFold(entry.Serial, serial) || entry.Session == auditSession.SessionID {
+			continue
+		}
+		where := ""
+		if entry.Station != "" {
+			where = " on station " + entry.Station
+		}
+		return false, fmt.Errorf("%w: %s was flashed (%s) in session %s%s at %s", errSerialNotUnique,
+			serial, entry.Operation, entry.Session, where, entry.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	return true, nil
+}
+
+// recordSerialHistory дописывает успешно записанный серийный номер; ошибка истории не отменяет прошивку
+func recordSerialHistory(serial, operation string) {
+	entry := SerialHistoryEntry{Serial: serial, Operation: operation, Timestamp: time.Now(), Session: auditSession.SessionID, Station: serialHistory.Station}
+	if err := appendSerialHistory(serialHistory.Dir, entry); err != nil {
+		printWarning(fmt.Sprintf("Serial history not updated: %v", err))
+	}
+}
+
+// waitForSafeTemperature проверяет температуру до первой операции прошивки; оператор может дать плате
+// остыть и повторить, пропустить проверку или отменить прошивку. Возвращает результат только при отмене
+func waitForSafeTemperature(maxTemp float64) *FlashResult {
+	sessionTimer.begin("verification: temperature")
+	startTime := time.Now()
+	for {
+		err := checkSystemTemperature(maxTemp)
+		if err == nil {
+			printSuccess(fmt.Sprintf("All temperature sensors below %.0f°C", maxTemp))
+			return nil
+		}
+		printError(fmt.Sprintf("Temperature check failed: %v", err))
+		if !isInteractive() {
+			recordAudit("flash_temperature_check", "", "FAILED", err.Error())
+			return &FlashResult{Operation: "temperature-check", Status: "FAILED", Details: err.Error(), Duration: time.Since(startTime)}
+		}
+		switch askFlashRetryAction(fmt.Sprintf("%v\nLet the board cool down before retrying.", err)) {
+		case "SKIP":
+			printWarning("Temperature check skipped by operator")
+			recordAudit("flash_temperature_check", "", "SKIPPED", err.Error())
+			return nil
+		case "ABORT":
+			recordAudit("flash_temperature_check", "", "FAILED", err.Error())
+			return &FlashResult{Operation: "temperature-check", Status: "FAILED", Details: "Flashing aborted: " + err.Error(), Duration: time.Since(startTime)}
+		}
+	}
+}
+
+// checkMACUniqueness выполняет scanMACUniqueness и оформляет результат как операцию mac-uniqueness
+func checkMACUniqueness(mac string, timeout time.Duration) *FlashResult {
+	startTime := time.Now()
+	scan := scanMACUniqueness(mac, timeout)
+	result := &FlashResult{Operation: "mac-uniqueness"}
+
+	switch scan.Status {
+	case "duplicate":
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf("MAC %s also answers from %s (%s via %s)", mac, strings.Join(scan.Conflicts, ", "), scan.Range, scan.Interface)
+		printMACConflictBanner(mac, scan.Conflicts)
+	case "clean":
+		result.Status = "PASSED"
+		result.Details = fmt.Sprintf("clean: no other station uses %s in %s via %s", mac, scan.Range, scan.Interface)
+		printSuccess(fmt.Sprintf("MAC %s is unique in %s", mac, scan.Range))
+	default:
+		result.Status = "SKIPPED"
+		result.Details = fmt.Sprintf("scan skipped: %s", scan.Reason)
+		printWarning(fmt.Sprintf("MAC uniqueness scan skipped: %s", scan.Reason))
+	}
+
+	result.Duration = time.Since(startTime)
+	recordAudit("mac_uniqueness", mac, result.Status, result.Details)
+	return result
+}
+
+func validateEFISystem() error {
+	// Check if system supports EFI variables
+	if _, err := os.Stat("/sys/firmware/efi/efivars"); os.IsNotExist(err) {
+		return fmt.Errorf("EFI variables not supported on this system (efivars not found)")
+	}
+
+	// Try to create UEFI context
+	ctx := efivario.NewDefaultContext()
+	if ctx == nil {
+		return fmt.Errorf("failed to create UEFI context")
+	}
+
+	printSuccess("EFI system validation passed")
+	return nil
+}
+
+// efiVarMaxSize - предел имени и значения переменной, больше прошивки вендора не принимают
+const efiVarMaxSize = 1024
+
+// EFIVarManager - EFI переменные вендора под одним GUID с едиными атрибутами записи (NV | BS | RT)
+type EFIVarManager struct {
+	GUID  efiguid.GUID
+	ctx   efivario.Context
+	attrs efivario.Attributes
+}
+
+// newEFIVarManager разбирает GUID из system.guid_prefix и открывает контекст efivarfs
+func newEFIVarManager(guidPrefix string) (*EFIVarManager, error) {
+	varGUID, err := efiguid.FromString(guidPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GUID format '%s': %v", guidPrefix, err)
+	}
+
+	ctx := efivario.NewDefaultContext()
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to create UEFI context")
+	}
+
+	return &EFIVarManager{
+		GUID:  varGUID,
+		ctx:   ctx,
+		attrs: efivario.NonVolatile | efivario.BootServiceAccess | efivario.RuntimeAccess,
+	}, nil
+}
+
+// Set записывает значение переменной как есть (без кодирования)
+func (m *EFIVarManager) Set(name string, value []byte) error {
+	return m.setWithAttrs(name, m.attrs, value)
+}
+
+// setWithAttrs - Set с явными атрибутами (откат возвращает атрибуты из дампа)
+func (m *EFIVarManager) setWithAttrs(name string, attrs efivario.Attributes, value []byte) error {
+	if name == "" || len(name) > efiVarMaxSize {
+		return fmt.Errorf("invalid variable name")
+	}
+	if len(value) == 0 || len(value) > efiVarMaxSize {
+		return fmt.Errorf("invalid value length")
+	}
+
+	if err := m.ctx.Set(name, m.GUID, attrs, value); err != nil {
+		if strings.Contains(err.Error(), "invalid argument") {
+			printError("Hint: check if efivarfs is mounted as rw and that the data format is valid")
+			printError("Some firmware may also reject certain variable names or GUIDs")
+		}
+		return fmt.Errorf("failed to set EFI variable %s: %v", name, err)
+	}
+	return nil
+}
+
+// Get читает сырое значение переменной; отсутствующая переменная - ошибка efivario.ErrNotFound
+func (m *EFIVarManager) Get(name string) ([]byte, error) {
+	_, data, err := m.getWithAttrs(name)
+	return data, err
+}
+
+func (m *EFIVarManager) getWithAttrs(name string) (efivario.Attributes, []byte, error) {
+	buf := make([]byte, efiVarMaxSize)
+	attrs, n, err := m.ctx.Get(name, m.GUID, buf)
+	if err != nil {
+		return 0, nil, err
+	}
+	return attrs, buf[:n], nil
+}
+
+// Delete удаляет переменную
+func (m *EFIVarManager) Delete(name string) error {
+	if err := m.ctx.Delete(name, m.GUID); err != nil {
+		return fmt.Errorf("failed to delete EFI variable %s: %v", name, err)
+	}
+	return nil
+}
+
+// List возвращает имена переменных под GUID менеджера
+func (m *EFIVarManager) List() ([]string, error) {
+	it, err := m.ctx.VariableNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate EFI variables: %v", err)
+	}
+	defer it.Close()
+
+	var names []string
+	for it.Next() {
+		if item := it.Value(); item.GUID == m.GUID {
+			names = append(names, item.Name)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to enumerate EFI variables: %v", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// efiBackup - куда сохранять дампы EFI переменных до/после записи (для -rollback-efi)
+type efiBackup struct {
+	Dir     string
+	Serial  string
+	Session string   // ID сессии в имени дампа: повторная прошивка той же платы не затирает дампы прошлой
+	Files   []string // Сохраненные дампы в порядке записи
+}
+
+// efiAttrsSuffix - атрибуты переменной рядом с дампом (<дамп>.attrs), откат пишет переменную с ними же
+const efiAttrsSuffix = ".attrs"
+
+func (b *efiBackup) path(varName, suffix string) string {
+	session := b.Session
+	if session == "" {
+		session = time.Now().Format("20060102_150405")
+	}
+	return filepath.Join(b.Dir, fmt.Sprintf("%s_efi_%s_%s_%s.bin", sanitizeFileName(b.Serial), sanitizeFileName(varName), suffix, sanitizeFileName(session)))
+}
+
+// save пишет дамп переменной и ее атрибуты; ошибка не мешает прошивке, но откат станет невозможен
+func (b *efiBackup) save(varName, suffix string, attrs efivario.Attributes, data []byte) {
+	if b == nil {
+		return
+	}
+	path := b.path(varName, suffix)
+	if err := os.MkdirAll(b.Dir, 0755); err != nil {
+		printWarning(fmt.Sprintf("Could not create EFI backup directory: %v", err))
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		printWarning(fmt.Sprintf("Could not save EFI backup %s: %v", path, err))
+		return
+	}
+	if err := os.WriteFile(path+efiAttrsSuffix, []byte(fmt.Sprintf("0x%08X\n", uint32(attrs))), 0644); err != nil {
+		printWarning(fmt.Sprintf("Could not save EFI attributes %s%s: %v", path, efiAttrsSuffix, err))
+	}
+	printInfo(fmt.Sprintf("EFI variable %s %s-dump saved: %s", varName, suffix, path))
+	b.Files = append(b.Files, path)
+}
+
+// readEFIBackupAttrs читает атрибуты, сохраненные рядом с дампом; у дампов старых версий их нет
+func readEFIBackupAttrs(backupPath string) (efivario.Attributes, bool, error) {
+	data, err := os.ReadFile(backupPath + efiAttrsSuffix)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	attrs, err := strconv.ParseUint(strings.TrimSpace(string(data)), 0, 32)
+	if err != nil || attrs == 0 {
+		return 0, false, fmt.Errorf("invalid EFI attributes in %s%s: %q", backupPath, efiAttrsSuffix, strings.TrimSpace(string(data)))
+	}
+	return efivario.Attributes(attrs), true, nil
+}
+
+// encodeEFIValue преобразует строку в байты переменной согласно efi_var_encoding
+func encodeEFIValue(value, encoding string) ([]byte, error) {
+	switch strings.ToLower(encoding) {
+	case "", "ascii":
+		return []byte(value), nil
+	case "utf16le":
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(value))
+	default:
+		return nil, fmt.Errorf("unknown EFI variable encoding %q (expected ascii or utf16le)", encoding)
+	}
+}
+
+// decodeEFIValue - обратное преобразование для сравнения с записываемым значением
+func decodeEFIValue(data []byte, encoding string) (string, error) {
+	switch strings.ToLower(encoding) {
+	case "", "ascii":
+		return string(data), nil
+	case "utf16le":
+		decoded, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return "", err
+		}
+		// Часть прошивок хранит завершающий UTF-16 ноль
+		return strings.TrimRight(string(decoded), "\x00"), nil
+	default:
+		return "", fmt.Errorf("unknown EFI variable encoding %q (expected ascii or utf16le)", encoding)
+	}
+}
+
+// efiReadBackTimeout возвращает время ожидания переменной после записи
+func efiReadBackTimeout(config SystemConfig) time.Duration {
+	if config.EFIVariableReadBackTimeout != "" {
+		if t, err := time.ParseDuration(config.EFIVariableReadBackTimeout); err == nil && t > 0 {
+			return t
+		}
+		printWarning(fmt.Sprintf("Invalid efi_variable_read_back_timeout %q, using 2s", config.EFIVariableReadBackTimeout))
+	}
+	return 2 * time.Second
+}
+
+// backup - необязательное сохранение значений до/после записи (nil - без дампов)
+// readBackTimeout - сколько ждать, пока прошивка отдаст только что записанную переменную
+func setEFIVariable(m *EFIVarManager, varName, value, encoding string, backup *efiBackup, readBackTimeout time.Duration) error {
+	printInfo(fmt.Sprintf("Setting EFI variable %q to: %q", varName, value))
+
+	data, err := encodeEFIValue(value, encoding)
+	if err != nil {
+		return err
+	}
+
+	// Сохраняем текущее значение и атрибуты, если переменная уже существует
+	if backup != nil {
+		if prevAttrs, prev, err := m.getWithAttrs(varName); err == nil {
+			backup.save(varName, "before", prevAttrs, prev)
+		}
+	}
+
+	fmt.Printf("→ Writing EFI var: name=%q, guid=%s, len=%d, attrs=0x%X\n",
+		varName, m.GUID.String(), len(data), uint32(m.attrs))
+
+	fmt.Printf("→ EFI var: data=%q (encoding: %s)\n",
+		value, strings.ToLower(encoding))
+
+	if err := m.Set(varName, data); err != nil {
+		return err
+	}
+
+	// Проверка записи. Часть прошивок какое-то время после записи отвечает "not found" - опрашиваем.
+	var readAttrs efivario.Attributes
+	var readData []byte
+	pollUntil(readBackTimeout, 100*time.Millisecond, func() bool {
+		readAttrs, readData, err = m.getWithAttrs(varName)
+		return !errors.Is(err, efivario.ErrNotFound)
+	})
+	if err != nil {
+		printWarning(fmt.Sprintf("Variable %s was set but cannot be read back: %v", varName, err))
+	} else {
+		fmt.Printf("→ Read back EFI var: len=%d (written=%d)\n", len(readData), len(data))
+		fmt.Printf("→ Attributes: 0x%X\n", uint32(readAttrs))
+
+		if bytes.Equal(readData, data) {
+			printSuccess(fmt.Sprintf("EFI variable %s verified value: %q (attrs: 0x%x)", varName, value, readAttrs))
+		} else {
+			printWarning(fmt.Sprintf(
+				"EFI variable %s value mismatch:\n  expected (len %d): %q (hex: %X)\n       got (len %d): %q (hex: %X)",
+				varName, len(data), data, data, len(readData), readData, readData,
+			))
+		}
+		backup.save(varName, "after", readAttrs, readData)
+	}
+
+	return nil
+}
+
+// restoreEFIVariable записывает в переменную сохраненные байты из дампа с исходными атрибутами
+func restoreEFIVariable(m *EFIVarManager, varName, backupPath string) error {
+	data, err := os.ReadFile(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup %s: %v", backupPath, err)
+	}
+	if len(data) == 0 {
+		return fmt.Errorf("backup %s is empty", backupPath)
+	}
+	attrs, ok, err := readEFIBackupAttrs(backupPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		attrs = m.attrs
+		printWarning(fmt.Sprintf("No saved attributes for %s, restoring with 0x%X", backupPath, uint32(attrs)))
+	}
+
+	if err := m.setWithAttrs(varName, attrs, data); err != nil {
+		return fmt.Errorf("failed to restore EFI variable %s: %v", varName, err)
+	}
+
+	printSuccess(fmt.Sprintf("EFI variable %s restored from %s (%d bytes, attrs 0x%X)", varName, backupPath, len(data), uint32(attrs)))
+	return nil
+}
+
+// efiBeforeDumpRegex - дампы "до записи" в деталях результата: <serial>_efi_<var>_before_<session>.bin
+// (и <serial>_efi_<var>_before.bin старых версий)
+var efiBeforeDumpRegex = regexp.MustCompile(`[^\s,]+_efi_[^\s,]+?_before(?:_[^\s,]+?)?\.bin`)
+
+// efiBackupVarName извлекает имя переменной из имени дампа "до записи"
+func efiBackupVarName(path string) (string, bool) {
+	base := filepath.Base(path)
+	idx := strings.Index(base, "_efi_")
+	if idx < 0 {
+		return "", false
+	}
+	rest := base[idx+len("_efi_"):]
+	end := strings.LastIndex(rest, "_before")
+	if end <= 0 {
+		return "", false
+	}
+	return rest[:end], true
+}
+
+// runEFIRollback восстанавливает EFI переменные из дампов "до записи" указанной сессии (в обратном порядке)
+func runEFIRollback(logPath, guidPrefix string) error {
+	if guidPrefix == "" {
+		return fmt.Errorf("system.guid_prefix is not set in configuration")
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to read session log: %v", err)
+	}
+
+	var log SessionLog
+	if err := yaml.Unmarshal(data, &log); err != nil {
+		return fmt.Errorf("failed to parse session log: %v", err)
+	}
+
+	var backups []string
+	for _, r := range log.FlashResults {
+		if r.Operation != "efi" {
+			continue
+		}
+		for _, path := range efiBeforeDumpRegex.FindAllString(r.Details, -1) {
+			path = strings.TrimSuffix(path, ",")
+			if !fileExists(path) {
+				// Путь в логе относительный к каталогу запуска - пробуем рядом с YAML
+				path = filepath.Join(filepath.Dir(logPath), filepath.Base(path))
+			}
+			backups = append(backups, path)
+		}
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no EFI backups (_before) found for session %s", log.SessionID)
+	}
+
+	printSubHeader("EFI ROLLBACK", fmt.Sprintf("Session %s | %d variable(s)", log.SessionID, len(backups)))
+
+	manager, err := newEFIVarManager(guidPrefix)
+	if err != nil {
+		return err
+	}
+
+	auditSession.SessionID = log.SessionID
+	auditSession.LogDir = filepath.Dir(logPath)
+
+	var failed int
+	for i := len(backups) - 1; i >= 0; i-- {
+		varName, ok := efiBackupVarName(backups[i])
+		if !ok {
+			printError(fmt.Sprintf("Cannot determine variable name from %s", filepath.Base(backups[i])))
+			failed++
+			continue
+		}
+
+		if err := restoreEFIVariable(manager, varName, backups[i]); err != nil {
+			printError(err.Error())
+			recordAudit("set_efi_var", varName, "FAILED", "rollback: "+err.Error())
+			failed++
+			continue
+		}
+		recordAudit("set_efi_var", varName, "PASSED", "rollback from "+backups[i])
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d EFI variable(s) could not be restored", failed, len(backups))
+	}
+	return nil
+}
+
+// efiMaintenanceLogName - журнал удалений -efi-maintenance/-efi-cleanup в log_dir (JSON по строке, как audit.log)
+const efiMaintenanceLogName = "efi_maintenance.log"
+
+// Виды объектов обслуживания EFI
+const (
+	efiItemBootEntry = "boot-entry"
+	efiItemVariable  = "variable"
+)
+
+// efiGlobalVariableGUID - GUID стандартных переменных UEFI (EFI_GLOBAL_VARIABLE)
+var efiGlobalVariableGUID = efiguid.MustFromString("8be4df61-93ca-11d2-aa0d-00e098032b8c")
+
+// efiProtectedNameRegex - стандартные загрузочные переменные, которые обслуживание не удаляет никогда
+var efiProtectedNameRegex = regexp.MustCompile(`^(BootOrder|BootCurrent|BootNext|Boot[0-9A-Fa-f]{4}|Timeout|PlatformLang|Lang)$`)
+
+// EFIMaintenanceItem - найденная запись загрузки или переменная firestarter
+type EFIMaintenanceItem struct {
+	Kind   string // boot-entry или variable
+	Name   string // Boot0003 или имя переменной
+	GUID   efiguid.GUID
+	Orphan bool   // efi_sn_name/efi_mac_name под другим GUID (старый guid_prefix)
+	Active bool   // Запись загрузки активна (*)
+	Value  string // Путь записи или значение переменной
+	Attrs  efivario.Attributes
+}
+
+// target - имя объекта для журнала и вывода
+func (item EFIMaintenanceItem) target() string {
+	if item.Kind == efiItemBootEntry {
+		return item.Name
+	}
+	return item.Name + "-" + item.GUID.String()
+}
+
+// efiProtected - объекты, которые нельзя удалять: глобальный GUID UEFI и стандартные загрузочные переменные
+func efiProtected(name string, guid efiguid.GUID) bool {
+	return guid == efiGlobalVariableGUID || efiProtectedNameRegex.MatchString(name)
+}
+
+// findOneTimeBootEntries - записи efibootmgr с меткой system.efi_boot_entry_label (то же выражение, что у setOneTimeBoot)
+func findOneTimeBootEntries() ([]EFIMaintenanceItem, error) {
+	out, err := runCommand("efibootmgr", "-v")
+	if err != nil {
+		return nil, fmt.Errorf("efibootmgr failed: %v", err)
+	}
+	var items []EFIMaintenanceItem
+	for _, match := range oneTimeBootRegex().FindAllStringSubmatch(out, -1) {
+		items = append(items, EFIMaintenanceItem{
+			Kind:   efiItemBootEntry,
+			Name:   "Boot" + strings.ToUpper(match[1]),
+			Active: match[2] == "*",
+			Value:  strings.TrimSpace(match[3]),
+		})
+	}
+	return items, nil
+}
+
+// findFirestarterEFIVariables - все переменные под system.guid_prefix, а с otherGUIDs - еще efi_sn_name/efi_mac_name
+// под любыми другими GUID (кроме глобального). Значения декодируются по efi_var_encoding
+func findFirestarterEFIVariables(ctx efivario.Context, config SystemConfig, otherGUIDs bool) ([]EFIMaintenanceItem, error) {
+	var ours efiguid.GUID
+	hasOurs := config.GuidPrefix != ""
+	if hasOurs {
+		var err error
+		if ours, err = efiguid.FromString(config.GuidPrefix); err != nil {
+			return nil, fmt.Errorf("invalid GUID format '%s': %v", config.GuidPrefix, err)
+		}
+	}
+
+	it, err := ctx.VariableNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate EFI variables: %v", err)
+	}
+	defer it.Close()
+
+	var items []EFIMaintenanceItem
+	for it.Next() {
+		v := it.Value()
+		if efiProtected(v.Name, v.GUID) {
+			continue
+		}
+		item := EFIMaintenanceItem{Kind: efiItemVariable, Name: v.Name, GUID: v.GUID}
+		switch {
+		case hasOurs && v.GUID == ours:
+		case otherGUIDs && v.Name != "" && (v.Name == config.EfiSnName || v.Name == config.EfiMacName):
+			item.Orphan = true
+		default:
+			continue
+		}
+
+		buf := make([]byte, efiVarMaxSize)
+		attrs, n, err := ctx.Get(v.Name, v.GUID, buf)
+		if err != nil {
+			item.Value = fmt.Sprintf("<unreadable: %v>", err)
+		} else {
+			item.Attrs = attrs
+			item.Value = formatEFIValue(buf[:n], config.EFIVarEncoding)
+		}
+		items = append(items, item)
+	}
+	if err := it.Err(); err != nil {
+		return nil, fmt.Errorf("failed to enumerate EFI variables: %v", err)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Orphan != items[j].Orphan {
+			return !items[i].Orphan
+		}
+		return items[i].target() < items[j].target()
+	})
+	return items, nil
+}
+
+// formatEFIValue - значение для показа: строка в efi_var_encoding, если она печатная, иначе hex
+func formatEFIValue(data []byte, encoding string) string {
+	if text, err := decodeEFIValue(data, encoding); err == nil && text != "" && strings.IndexFunc(text, func(r rune) bool {
+		return r < 0x20 || r == 0x7f || r == 0xfffd
+	}) < 0 {
+		return fmt.Sprintf("%q", text)
+	}
+	return fmt.Sprintf("hex %X", data)
+}
+
+// deleteEFIMaintenanceItem удаляет запись загрузки через efibootmgr, переменную - через efivario
+// (он снимает FS_IMMUTABLE_FL с файла efivarfs перед удалением)
+func deleteEFIMaintenanceItem(ctx efivario.Context, item EFIMaintenanceItem) error {
+	if item.Kind == efiItemBootEntry {
+		num := strings.TrimPrefix(item.Name, "Boot")
+		if output, err := runCommand("efibootmgr", "-B", "-b", num); err != nil {
+			return fmt.Errorf("efibootmgr -B -b %s failed: %v (%s)", num, err, output)
+		}
+		return nil
+	}
+	if efiProtected(item.Name, item.GUID) {
+		return fmt.Errorf("%s is a protected variable", item.Name)
+	}
+	if err := ctx.Delete(item.Name, item.GUID); err != nil {
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EROFS) {
+			return fmt.Errorf("failed to delete EFI variable %s: %v (is efivarfs mounted read-write?)", item.target(), err)
+		}
+		return fmt.Errorf("failed to delete EFI variable %s: %v", item.target(), err)
+	}
+	return nil
+}
+
+// logEFIMaintenance пишет удаление в efi_maintenance.log; ошибка журнала не отменяет удаление
+func logEFIMaintenance(logDir string, item EFIMaintenanceItem, deleteErr error) {
+	entry := AuditEntry{
+		Timestamp: time.Now(),
+		SessionID: "efi-maintenance",
+		Operator:  os.Getenv("SUDO_USER"),
+		Action:    "delete_efi_" + strings.ReplaceAll(item.Kind, "-", "_"),
+		Target:    item.target(),
+		Result:    "PASSED",
+		Details:   item.Value,
+	}
+	if deleteErr != nil {
+		entry.Result = "FAILED"
+		entry.Details = deleteErr.Error()
+	}
+	if err := appendAuditLogFile(entry, logDir, efiMaintenanceLogName); err != nil {
+		printWarning(fmt.Sprintf("EFI maintenance log: %v", err))
+	}
+}
+
+// collectEFIMaintenanceItems - записи загрузки с нашей меткой и переменные firestarter
+func collectEFIMaintenanceItems(ctx efivario.Context, config SystemConfig, otherGUIDs bool) ([]EFIMaintenanceItem, error) {
+	items, err := findOneTimeBootEntries()
+	if err != nil {
+		printWarning(fmt.Sprintf("Boot entries not listed: %v", err))
+	}
+	vars, err := findFirestarterEFIVariables(ctx, config, otherGUIDs)
+	if err != nil {
+		return items, err
+	}
+	return append(items, vars...), nil
+}
+
+// printEFIMaintenanceItems печатает нумерованный список объектов обслуживания
+func printEFIMaintenanceItems(items []EFIMaintenanceItem, selected map[int]bool) {
+	for i, item := range items {
+		mark := "[ ]"
+		if selected[i] {
+			mark = fmt.Sprintf("%s[x]%s", ColorRed, ColorReset)
+		}
+		switch item.Kind {
+		case efiItemBootEntry:
+			active := ""
+			if item.Active {
+				active = " (active)"
+			}
+			fmt.Printf("  %3d. %s %-10s %s%s%s%s\n", i+1, mark, item.Name, ColorGray, item.Value, ColorReset, active)
+		default:
+			orphan := ""
+			if item.Orphan {
+				orphan = fmt.Sprintf(" %s(other GUID)%s", ColorYellow, ColorReset)
+			}
+			fmt.Printf("  %3d. %s %s-%s%s attrs 0x%X\n       %s\n", i+1, mark, item.Name, item.GUID, orphan, uint32(item.Attrs), item.Value)
+		}
+	}
+}
+
+// runEFIMaintenance - режим -efi-maintenance: показать записи загрузки и переменные firestarter
+// и удалить выбранные оператором после подтверждения
+func runEFIMaintenance(config *Config) int {
+	if err := validateEFISystem(); err != nil {
+		printError(err.Error())
+		return 1
+	}
+	ctx := efivario.NewDefaultContext()
+	logDir := logDirPath(config.Log)
+	reader := bufio.NewReader(operatorInput())
+	otherGUIDs := config.System.EFICleanup.OtherGUIDs
+
+	for {
+		items, err := collectEFIMaintenanceItems(ctx, config.System, otherGUIDs)
+		if err != nil {
+			printError(err.Error())
+			return 1
+		}
+		printSubHeader("EFI MAINTENANCE", fmt.Sprintf("Label %q | GUID %s", efiBootEntryLabel, config.System.GuidPrefix))
+		if len(items) == 0 {
+			printSuccess("No firestarter boot entries or EFI variables found")
+			return 0
+		}
+
+		selected := map[int]bool{}
+		for {
+			printEFIMaintenanceItems(items, selected)
+			fmt.Printf("%d selected. Numbers toggle, a = all, n = none, d = delete selected, Enter = exit: ", len(selected))
+			input, readErr := reader.ReadString('\n')
+			input = strings.ToLower(strings.TrimSpace(input))
+			if input == "" || readErr != nil {
+				return 0
+			}
+			if input == "d" {
+				break
+			}
+			switch input {
+			case "a":
+				for i := range items {
+					selected[i] = true
+				}
+			case "n":
+				selected = map[int]bool{}
+			default:
+				for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+					n, convErr := strconv.Atoi(field)
+					if convErr != nil || n < 1 || n > len(items) {
+						printWarning(fmt.Sprintf("Invalid item number: %s (1-%d)", field, len(items)))
+						continue
+					}
+					if selected[n-1] {
+						delete(selected, n-1)
+					} else {
+						selected[n-1] = true
+					}
+				}
+			}
+		}
+		if len(selected) == 0 {
+			printWarning("Nothing selected")
+			continue
+		}
+
+		fmt.Printf("%sDelete %d item(s)? This cannot be undone [y/N]:%s ", ColorRed, len(selected), ColorReset)
+		answer, _ := reader.ReadString('\n')
+		if answer = strings.ToUpper(strings.TrimSpace(answer)); answer != "Y" && answer != "YES" {
+			printInfo("Nothing deleted")
+			continue
+		}
+		for i, item := range items {
+			if !selected[i] {
+				continue
+			}
+			err := deleteEFIMaintenanceItem(ctx, item)
+			logEFIMaintenance(logDir, item, err)
+			if err != nil {
+				printError(err.Error())
+			} else {
+				printSuccess(fmt.Sprintf("Deleted %s", item.target()))
+			}
+		}
+	}
+}
+
+// efiCleanupSelected - удаляет ли -efi-cleanup объект по политике. Переменные под чужим GUID удаляются
+// только если GUID явно перечислен в orphan_guids: other_guids без списка их только показывает
+func efiCleanupSelected(policy EFICleanupPolicy, item EFIMaintenanceItem) bool {
+	switch {
+	case item.Kind == efiItemBootEntry:
+		return policy.BootEntries
+	case item.Orphan:
+		for _, guid := range policy.OrphanGUIDs {
+			if allowed, err := efiguid.FromString(guid); err == nil && allowed == item.GUID {
+				return true
+			}
+		}
+		return false
+	}
+	return slices.Contains(policy.Variables, "*") || slices.Contains(policy.Variables, item.Name)
+}
+
+// runEFICleanup - режим -efi-cleanup: удаление по system.efi_cleanup без вопросов. Код 1 - хотя бы одно удаление не удалось
+func runEFICleanup(config *Config) int {
+	policy := config.System.EFICleanup
+	if !policy.BootEntries && len(policy.Variables) == 0 && len(policy.OrphanGUIDs) == 0 {
+		printError("-efi-cleanup requires system.efi_cleanup in configuration (boot_entries, variables or orphan_guids)")
+		return 1
+	}
+	if err := validateEFISystem(); err != nil {
+		printError(err.Error())
+		return 1
+	}
+	ctx := efivario.NewDefaultContext()
+	items, err := collectEFIMaintenanceItems(ctx, config.System, policy.OtherGUIDs || len(policy.OrphanGUIDs) > 0)
+	if err != nil {
+		printError(err.Error())
+		return 1
+	}
+
+	logDir := logDirPath(config.Log)
+	var deleted, failed int
+	for _, item := range items {
+		if !efiCleanupSelected(policy, item) {
+			if item.Orphan {
+				printWarning(fmt.Sprintf("Kept %s: GUID not in system.efi_cleanup.orphan_guids", item.target()))
+			}
+			continue
+		}
+		err := deleteEFIMaintenanceItem(ctx, item)
+		logEFIMaintenance(logDir, item, err)
+		if err != nil {
+			printError(err.Error())
+			failed++
+			continue
+		}
+		printSuccess(fmt.Sprintf("Deleted %s (%s)", item.target(), item.Value))
+		deleted++
+	}
+	printInfo(fmt.Sprintf("EFI cleanup: %d deleted, %d failed", deleted, failed))
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func testServerConnection(config LogConfig) error {
+	if !config.SendLogs || config.Server == "" {
+		return nil
+	}
+
+	printInfo(fmt.Sprintf("Testing connection to server: %s", config.Server))
+
+	check := checkUploadPath(config)
+	if !check.Reachable {
+		return fmt.Errorf("server connection test failed: %s", check.Error)
+	}
+
+	printSuccess("Server connection test passed")
+	return nil
+}
+
+// uploadBindAddress возвращает локальный адрес для ssh/scp (log.bind_address или IPv4 log.bind_interface).
+// Пустая строка - привязка не настроена.
+func uploadBindAddress(config LogConfig) (string, error) {
+	if config.BindAddress != "" {
+		return config.BindAddress, nil
+	}
+	if config.BindInterface != "" {
+		return interfaceIPv4(config.BindInterface)
+	}
+	return "", nil
+}
+
+// probeServer проверяет ssh до сервера логов с заданного локального адреса
+func probeServer(serverAddr, bindAddress string) error {
+	args := append(sshOptions(bindAddress),
+		"-o", "BatchMode=yes",
+		serverAddr,
+		"echo 'Connection test successful'")
+
+	if output, err := tracedCombinedOutput(exec.Command("ssh", args...)); err != nil {
+		return fmt.Errorf("%v (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// checkUploadPath проверяет связь с сервером логов через предпочтительный адрес.
+// Если он недоступен (интерфейс без адреса после прошивки MAC, порт упал) - пробует любой маршрут.
+// После прошивки маршруты меняются, поэтому проверка повторяется непосредственно перед загрузкой.
+func checkUploadPath(config LogConfig) UploadCheck {
+	now := time.Now()
+	check := UploadCheck{
+		CheckedAt:       now,
+		CheckedAtOffset: sessionOffset(now),
+		Route:           "unreachable",
+		Interface:       config.BindInterface,
+	}
+
+	serverParts := strings.Split(config.Server, "@")
+	if len(serverParts) != 2 {
+		check.Error = fmt.Sprintf("invalid server format, expected user@host: %s", config.Server)
+		return check
+	}
+	serverAddr := config.Server
+
+	bindAddress, err := uploadBindAddress(config)
+	if err == nil {
+		err = probeServer(serverAddr, bindAddress)
+	}
+	if err == nil {
+		check.Reachable = true
+		check.BindAddress = bindAddress
+		check.Route = "default"
+		if bindAddress != "" {
+			check.Route = "bound"
+			printInfo(fmt.Sprintf("Log server reachable from %s", bindAddress))
+		}
+		return check
+	}
+
+	if config.BindAddress == "" && config.BindInterface == "" {
+		check.Error = err.Error()
+		return check
+	}
+
+	preferred := config.BindAddress
+	if preferred == "" {
+		preferred = config.BindInterface
+	}
+	check.Warning = fmt.Sprintf("preferred upload path via %s is down: %v", preferred, err)
+	printWarning(fmt.Sprintf("Preferred upload path via %s is down (%v), falling back to any working route", preferred, err))
+
+	if err := probeServer(serverAddr, ""); err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	check.Reachable = true
+	check.Route = "fallback"
+	printWarning("Log server reachable only via default route - upload will not use the configured binding")
+	return check
+}
+
+// sendLogToServer загружает YAML лог сессии и дополнительные артефакты (транскрипт и т.п.) рядом с ним
+// Каждый файл сначала пишется под временным именем, проверяется по sha256 и только потом переименовывается.
+// Если загрузка так и не удалась, лог складывается в локальный outbox.
+// out - фоновая выгрузка (asyncLogUpload); nil - сообщения сразу в консоль
+func sendLogToServer(log SessionLog, config LogConfig, artifacts []string, out *backgroundUpload) (err error) {
+	if !config.SendLogs || config.Server == "" {
+		return nil
+	}
+
+	out.print(ColorBlue, fmt.Sprintf("Sending log to server: %s", config.Server))
+
+	// Путь проверяется заново (main делает это до сохранения лога, чтобы результат попал в YAML)
+	if log.Upload == nil {
+		check := checkUploadPath(config)
+		log.Upload = &check
+	}
+
+	// Marshal to YAML
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log: %v", err)
+	}
+
+	// Generate remote filename with state
+	remoteFile := sessionLogFileName(log)
+
+	// Обезличивается только то, что уходит с площадки: saveLog уже сохранил полный лог
+	if len(config.Redact.Fields) > 0 {
+		redactor := newLogRedactor(config.Redact)
+		if data, err = redactor.redactYAML(data); err != nil {
+			return fmt.Errorf("failed to redact log: %v", err)
+		}
+		redacted, cleanup, err := redactor.redactArtifacts(artifacts)
+		if err != nil {
+			return fmt.Errorf("failed to redact artifacts: %v", err)
+		}
+		defer cleanup()
+		artifacts = redacted
+		if err := redactor.saveMap(); err != nil {
+			out.print(ColorYellow, fmt.Sprintf("Redaction map not saved: %v", err))
+		}
+		out.print(ColorBlue, fmt.Sprintf("Log redacted for upload (%s, %s): %d value(s) replaced",
+			strings.Join(config.Redact.Fields, ", "), redactor.mode, redactor.count))
+		remoteFile = redactor.logFileName(log)
+	}
+
+	// Лог кладется в outbox до начала выгрузки: если main перестанет ждать (log.upload_wait_timeout)
+	// и процесс завершится посреди scp, лог останется в outbox и уйдет при следующем сбросе
+	var spooled string
+	out.apply(func() {
+		var spoolErr error
+		if spooled, spoolErr = spoolLog(data, remoteFile, config, errUploadInProgress); spoolErr != nil {
+			out.print(ColorYellow, fmt.Sprintf("Failed to spool log to outbox before upload: %v", spoolErr))
+		}
+	})
+
+	// После abandon лог в outbox и манифест принадлежат flushOutbox в main - горутина их не трогает
+	defer out.apply(func() {
+		manifestStatus := manifestUploaded
+		var manifestErr error
+		if err == nil {
+			if spooled != "" {
+				if rmErr := unspoolLog(spooled); rmErr != nil {
+					out.print(ColorYellow, fmt.Sprintf("Uploaded log left in outbox: %v", rmErr))
+				}
+			}
+		} else if path, spoolErr := spoolLog(data, remoteFile, config, err); spoolErr != nil {
+			out.print(ColorRed, fmt.Sprintf("Failed to spool log to outbox: %v", spoolErr))
+			manifestStatus, manifestErr = manifestUploadFailed, err
+		} else {
+			out.print(ColorYellow, fmt.Sprintf("Log kept in outbox for later upload: %s", path))
+			manifestStatus, manifestErr = manifestUploadSpooled, err
+		}
+		if err := updateManifestUpload(config, log.SessionID, manifestStatus, manifestErr); err != nil {
+			out.print(ColorYellow, fmt.Sprintf("Manifest not updated: %v", err))
+		}
+	})
+
+	// Create temporary file
+	tmpFile, err := os.CreateTemp("", "system_validator_*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write temp file: %v", err)
+	}
+	tmpFile.Close()
+
+	remoteDir := remoteLogDir(config, log)
+
+	// Parse server (user@host format)
+	serverParts := strings.Split(config.Server, "@")
+	if len(serverParts) != 2 {
+		return fmt.Errorf("invalid server format, expected user@host: %s", config.Server)
+	}
+
+	user := serverParts[0]
+	host := serverParts[1]
+	serverAddr := fmt.Sprintf("%s@%s", user, host)
+
+	if !log.Upload.Reachable {
+		return fmt.Errorf("log server unreachable: %s", log.Upload.Error)
+	}
+	opts := sshOptions(log.Upload.BindAddress)
+
+	out.print("", fmt.Sprintf("Remote: %s:%s/%s", serverAddr, remoteDir, remoteFile))
+
+	// Step 1: Create remote directories if they don't exist
+	if remoteDir != "." {
+		if _, err := runRemote(opts, serverAddr, "mkdir", "-p", "--", remoteDir); err != nil {
+			return fmt.Errorf("failed to create remote directory: %v", err)
+		}
+	}
+
+	retries := config.UploadRetries
+	if retries <= 0 {
+		retries = 3
+	}
+
+	// Step 2: Upload artifacts next to the YAML (<yaml name>_<artifact name>).
+	// YAML загружается последним - его появление означает, что сессия выгружена целиком.
+	remoteBase := strings.TrimSuffix(remoteFile, ".yaml")
+	for _, artifact := range artifacts {
+		remoteArtifact := fmt.Sprintf("%s/%s_%s", remoteDir, remoteBase, filepath.Base(artifact))
+		if err := uploadVerified(opts, serverAddr, artifact, remoteArtifact, retries, out); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", filepath.Base(artifact), err)
+		}
+	}
+
+	// Step 3: Upload log
+	remoteFullPath := fmt.Sprintf("%s/%s", remoteDir, remoteFile)
+	if err := uploadVerified(opts, serverAddr, tmpFile.Name(), remoteFullPath, retries, out); err != nil {
+		return fmt.Errorf("failed to upload file: %v", err)
+	}
+
+	out.print(ColorGreen, "Log successfully sent to server")
+	return nil
+}
+
+// remoteLogDir - каталог лога на сервере: server_dir[/<станция>][/<продукт>][/<op_name>]
+func remoteLogDir(config LogConfig, log SessionLog) string {
+	remoteDirParts := []string{}
+	if config.ServerDir != "" {
+		remoteDirParts = append(remoteDirParts, config.ServerDir)
+	}
+	if config.GroupByStation {
+		remoteDirParts = append(remoteDirParts, stationDirName(log.System.Station))
+	}
+	if log.System.Product != "" {
+		remoteDirParts = append(remoteDirParts, sanitizeFileName(log.System.Product))
+	}
+	if config.OpName != "" {
+		remoteDirParts = append(remoteDirParts, sanitizeFileName(config.OpName))
+	}
+	if len(remoteDirParts) == 0 {
+		return "."
+	}
+	return strings.Join(remoteDirParts, "/")
+}
+
+// defaultUploadWaitTimeout - сколько main ждет выгрузку лога после итогов, если log.upload_wait_timeout не задан
+const defaultUploadWaitTimeout = 10 * time.Second
+
+// errUploadInProgress - выгрузка еще идет, когда печатаются итоги
+var errUploadInProgress = errors.New("upload in progress")
+
+// uploadWaitTimeout - log.upload_wait_timeout (проверен в validateConfig) или значение по умолчанию
+func uploadWaitTimeout(config LogConfig) time.Duration {
+	if config.UploadWaitTimeout == "" {
+		return defaultUploadWaitTimeout
+	}
+	d, _ := time.ParseDuration(config.UploadWaitTimeout)
+	return d
+}
+
+// backgroundUpload - выгрузка лога в фоне. Сообщения горутины копятся и печатаются из main,
+// после abandon горутина не пишет ни в консоль (и транскрипт), ни в outbox, ни в манифест
+type backgroundUpload struct {
+	Done <-chan error
+
+	mu        sync.Mutex
+	abandoned bool
+	messages  [][2]string // Цвет и текст
+}
+
+// print откладывает сообщение до flush; без фоновой выгрузки (nil) печатает сразу
+func (u *backgroundUpload) print(color, message string) {
+	if u == nil {
+		printColored(color, message)
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.abandoned {
+		u.messages = append(u.messages, [2]string{color, message})
+	}
+}
+
+// apply выполняет изменение локальных файлов (outbox, манифест), пока main не отказался от выгрузки.
+// Блокировка держится на время f: после возврата из abandon горутина уже ничего не меняет
+func (u *backgroundUpload) apply(f func()) {
+	if u == nil {
+		f()
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.abandoned {
+		f()
+	}
+}
+
+// flush печатает накопленные сообщения (вызывается из main)
+func (u *backgroundUpload) flush() {
+	u.mu.Lock()
+	messages := u.messages
+	u.messages = nil
+	u.mu.Unlock()
+	for _, m := range messages {
+		printColored(m[0], m[1])
+	}
+}
+
+// abandon - main больше не ждет: накопленное печатается, дальнейший вывод и изменения файлов горутины отбрасываются
+func (u *backgroundUpload) abandon() {
+	u.mu.Lock()
+	u.abandoned = true
+	u.mu.Unlock()
+	u.flush()
+}
+
+// asyncLogUpload выгружает лог в фоне. Горутина получает глубокую копию лога и свою копию списка артефактов,
+// результат пишет в буферизованный канал - ей не нужно, чтобы его кто-то читал
+func asyncLogUpload(log SessionLog, config LogConfig, artifacts []string) (*backgroundUpload, error) {
+	// Путь проверяется здесь, а не в горутине: checkUploadPath печатает в консоль
+	if log.Upload == nil {
+		check := checkUploadPath(config)
+		log.Upload = &check
+	}
+	copied, err := cloneSessionLog(log)
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	upload := &backgroundUpload{Done: done}
+	artifacts = append([]string(nil), artifacts...)
+	go func() {
+		done <- sendLogToServer(copied, config, artifacts, upload)
+	}()
+	return upload, nil
+}
+
+// cloneSessionLog копирует лог через YAML: выгружается тот же YAML, так что копия не теряет ничего нужного
+func cloneSessionLog(log SessionLog) (SessionLog, error) {
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		return SessionLog{}, fmt.Errorf("failed to marshal log: %v", err)
+	}
+	var copied SessionLog
+	if err := yaml.Unmarshal(data, &copied); err != nil {
+		return SessionLog{}, fmt.Errorf("failed to copy log: %v", err)
+	}
+	return copied, nil
+}
+
+// sshBaseOptions - общие опции ssh/scp для сервера логов
+var sshBaseOptions = []string{
+	"-o", "StrictHostKeyChecking=no",
+	"-o", "UserKnownHostsFile=/dev/null",
+	"-o", "ConnectTimeout=10",
+}
+
+// sshOptions - опции ssh/scp с привязкой к локальному адресу (пустой адрес - без привязки)
+func sshOptions(bindAddress string) []string {
+	opts := append([]string{}, sshBaseOptions...)
+	if bindAddress != "" {
+		opts = append(opts, "-o", "BindAddress="+bindAddress)
+	}
+	return opts
+}
+
+// defaultConfigCacheDir - кэш конфигов станции, если config_source.cache_dir не задан
+const defaultConfigCacheDir = "/var/lib/firestarter/config-cache"
+
+// stationConfigSource - откуда взят конфиг этого запуска (nil без config_source)
+var stationConfigSource *ConfigSourceInfo
+
+// configSourceOptions собирает источник конфига станции: HTTP или scp с теми же ssh опциями, что у выгрузки логов
+func configSourceOptions(config *Config) (configsource.Options, error) {
+	source := config.ConfigSource.URL
+	if source == "" {
+		source = config.Log.Server + ":" + filepath.Join(config.Log.ServerDir, "configs")
+	}
+	bindAddress, err := uploadBindAddress(config.Log)
+	if err != nil {
+		printWarning(fmt.Sprintf("Config source: %v, using default route", err))
+		bindAddress = ""
+	}
+	fetcher, err := configsource.NewFetcher(source, append(sshOptions(bindAddress), "-o", "BatchMode=yes"))
+	if err != nil {
+		return configsource.Options{}, err
+	}
+	cacheDir := config.ConfigSource.CacheDir
+	if cacheDir == "" {
+		cacheDir = defaultConfigCacheDir
+	}
+	return configsource.Options{
+		Fetcher:   fetcher,
+		Cache:     configsource.Cache{Dir: cacheDir},
+		Product:   config.System.Product,
+		StationID: collectStationInfo(config.Log).ID,
+		Validate:  validateRemoteConfig,
+		Now:       time.Now,
+	}, nil
+}
+
+// validateRemoteConfig проверяет загруженный конфиг до записи в кэш тем же loadConfig, что и локальный
+func validateRemoteConfig(data []byte) error {
+	_, err := loadConfigData(data)
+	return err
+}
+
+// loadConfigData разбирает загруженный конфиг через временный файл тем же loadConfig, что и локальный.
+// Поэтому include в нем не поддерживаются
+func loadConfigData(data []byte) (*Config, error) {
+	tmp, err := os.CreateTemp("", "firestarter-config-*.yaml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %v", err)
+	}
+	_, config, err := loadConfig(tmp.Name())
+	return config, err
+}
+
+// defaultConfigFetchTimeout - сколько ждать сервер конфига по -config-url
+const defaultConfigFetchTimeout = 30 * time.Second
+
+// configInsecure отключает проверку TLS сертификата сервера для -config-url (-config-insecure)
+var configInsecure bool
+
+// ConfigURLCacheMeta - сведения о копии конфига, загруженного по -config-url (config_cache.meta.json)
+type ConfigURLCacheMeta struct {
+	URL       string    `json:"url"`
+	ETag      string    `json:"etag,omitempty"`
+	SHA256    string    `json:"sha256"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// configURLCachePath - ~/.firestarter/config_cache.yaml: последний конфиг, загруженный по -config-url
+func configURLCachePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/root"
+	}
+	return filepath.Join(home, ".firestarter", "config_cache.yaml")
+}
+
+// loadConfigURLCache возвращает метаданные кэша, если он загружен с этого URL и не поврежден
+func loadConfigURLCache(cachePath, url string) (ConfigURLCacheMeta, bool) {
+	var meta ConfigURLCacheMeta
+	metaData, err := os.ReadFile(strings.TrimSuffix(cachePath, ".yaml") + ".meta.json")
+	if err != nil || json.Unmarshal(metaData, &meta) != nil || meta.URL != url {
+		return meta, false
+	}
+	data, err := os.ReadFile(cachePath)
+	return meta, err == nil && configsource.Sum(data) == meta.SHA256
+}
+
+// storeConfigURLCache атомарно заменяет кэш -config-url; метаданные пишутся после конфига,
+// поэтому прерванная запись дает несовпадение хеша, а не чужой ETag
+func storeConfigURLCache(cachePath string, data []byte, meta ConfigURLCacheMeta) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return fmt.Errorf("failed to create cache dir: %v", err)
+	}
+	metaData, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{cachePath, data}, {strings.TrimSuffix(cachePath, ".yaml") + ".meta.json", metaData}} {
+		tmp := file.path + ".tmp"
+		if err := os.WriteFile(tmp, file.data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", tmp, err)
+		}
+		if err := os.Rename(tmp, file.path); err != nil {
+			os.Remove(tmp)
+			return fmt.Errorf("failed to replace %s: %v", file.path, err)
+		}
+	}
+	return nil
+}
+
+// fetchConfig загружает конфиг по -config-url (GET, TLS проверяется без -config-insecure) и кэширует его
+// в ~/.firestarter/config_cache.yaml. С кэшем того же URL запрос условный по ETag: 304 - берется кэш.
+// Сервер недоступен или конфиг не проходит проверку - работаем на кэше с предупреждением
+func fetchConfig(url string, timeout time.Duration) (*Config, error) {
+	cachePath := configURLCachePath()
+	meta, cached := loadConfigURLCache(cachePath, url)
+	etag := ""
+	if cached {
+		etag = meta.ETag
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: configInsecure},
+		},
+	}
+	data, newETag, err := configsource.FetchURL(client, url, etag)
+	if err == configsource.ErrNotModified {
+		_, config, loadErr := loadConfig(cachePath)
+		if loadErr == nil {
+			printSuccess(fmt.Sprintf("Configuration: %s not modified, using cached copy (sha256 %s)", url, meta.SHA256))
+			stationConfigSource = &ConfigSourceInfo{Source: configsource.SourceRemote, Location: url, SHA256: meta.SHA256, FetchedAt: meta.FetchedAt}
+			return config, nil
+		}
+		// Кэш не читается новой версией firestarter - загружаем заново без условия
+		data, newETag, err = configsource.FetchURL(client, url, "")
+	}
+
+	var config *Config
+	if err == nil {
+		if config, err = loadConfigData(data); err != nil {
+			err = fmt.Errorf("configuration %s is invalid: %v", url, err)
+		}
+	}
+	if err == nil {
+		sum := configsource.Sum(data)
+		now := time.Now()
+		if storeErr := storeConfigURLCache(cachePath, data, ConfigURLCacheMeta{URL: url, ETag: newETag, SHA256: sum, FetchedAt: now}); storeErr != nil {
+			printWarning(fmt.Sprintf("Configuration cache not updated: %v", storeErr))
+		}
+		printSuccess(fmt.Sprintf("Configuration: %s (sha256 %s)", url, sum))
+		stationConfigSource = &ConfigSourceInfo{Source: configsource.SourceRemote, Location: url, SHA256: sum, FetchedAt: now}
+		return config, nil
+	}
+
+	if !cached {
+		return nil, err
+	}
+	_, config, loadErr := loadConfig(cachePath)
+	if loadErr != nil {
+		return nil, fmt.Errorf("%v; cached copy %s is unusable: %v", err, cachePath, loadErr)
+	}
+	printConfigFallbackBanner(configsource.Result{Source: configsource.SourceCache, Path: cachePath, Location: url, FetchedAt: meta.FetchedAt, FetchErr: err})
+	stationConfigSource = &ConfigSourceInfo{Source: configsource.SourceCache, Location: url, SHA256: meta.SHA256, FetchedAt: meta.FetchedAt, Error: err.Error()}
+	return config, nil
+}
+
+// localConfigSource - запуск на локальном конфиге, потому что сервер и кэш недоступны
+func localConfigSource(localPath string, cause error) *ConfigSourceInfo {
+	info := &ConfigSourceInfo{Source: configsource.SourceLocal, Error: cause.Error()}
+	if data, err := os.ReadFile(localPath); err == nil {
+		info.SHA256 = configsource.Sum(data)
+	}
+	return info
+}
+
+// resolveStationConfig заменяет локальный конфиг загруженным с сервера или из кэша.
+// Недоступный источник не останавливает станцию: работаем на кэше или локальном файле с предупреждением
+func resolveStationConfig(local *Config, localPath string) (*Config, string) {
+	opts, err := configSourceOptions(local)
+	if err != nil {
+		stationConfigSource = localConfigSource(localPath, err)
+		printConfigFallbackBanner(configsource.Result{Source: configsource.SourceLocal, Path: localPath, FetchErr: err})
+		return local, localPath
+	}
+
+	result := configsource.Resolve(opts, localPath)
+	stationConfigSource = &ConfigSourceInfo{
+		Source:    result.Source,
+		Location:  result.Location,
+		SHA256:    result.SHA256,
+		FetchedAt: result.FetchedAt,
+	}
+	if result.FetchErr != nil {
+		stationConfigSource.Error = result.FetchErr.Error()
+	}
+
+	if result.Source == configsource.SourceRemote {
+		printSuccess(fmt.Sprintf("Configuration: %s (sha256 %s)", result.Location, result.SHA256))
+	} else {
+		printConfigFallbackBanner(result)
+	}
+	if result.Source == configsource.SourceLocal {
+		return local, localPath
+	}
+
+	// Кэш проверяется при записи; здесь он может не пройти только после обновления firestarter
+	_, config, err := loadConfig(result.Path)
+	if err != nil {
+		printWarning(fmt.Sprintf("Configuration %s is unusable (%v), using local %s", result.Path, err, localPath))
+		stationConfigSource = localConfigSource(localPath, err)
+		return local, localPath
+	}
+	return config, result.Path
+}
+
+// printConfigFallbackBanner предупреждает, что станция работает не на свежем конфиге с сервера
+func printConfigFallbackBanner(result configsource.Result) {
+	lines := []string{"  REMOTE CONFIGURATION NOT LOADED - USING LOCAL FILE", fmt.Sprintf("  File: %s", result.Path)}
+	if result.Source == configsource.SourceCache {
+		age := "<1m"
+		if d := time.Since(result.FetchedAt); d >= time.Minute {
+			age = strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+		}
+		lines = []string{
+			"  REMOTE CONFIGURATION NOT LOADED - USING CACHED COPY",
+			fmt.Sprintf("  Cached %s ago (%s) from %s", age, result.FetchedAt.Format("2006-01-02 15:04"), result.Location),
+		}
+	}
+	if result.FetchErr != nil {
+		lines = append(lines, fmt.Sprintf("  Reason: %v", result.FetchErr))
+	}
+
+	line := strings.Repeat(" ", 80)
+	fmt.Printf("\n%s%s%s\n", ColorBgYellow, line, ColorReset)
+	for _, text := range lines {
+		fmt.Printf("%s%-80.80s%s\n", ColorBgYellow, text, ColorReset)
+	}
+	fmt.Printf("%s%s%s\n\n", ColorBgYellow, line, ColorReset)
+}
+
+// runConfigRefresh - режим -refresh-config: загрузить конфиг станции в кэш без запуска сессии
+func runConfigRefresh(config *Config) int {
+	if config.ConfigSource == nil {
+		printError("-refresh-config requires config_source in configuration")
+		return 1
+	}
+	opts, err := configSourceOptions(config)
+	if err != nil {
+		printError(fmt.Sprintf("Invalid config_source: %v", err))
+		return 1
+	}
+	result, err := configsource.Refresh(opts)
+	if err != nil {
+		printError(fmt.Sprintf("Configuration refresh failed: %v", err))
+		return 1
+	}
+	printSuccess(fmt.Sprintf("Configuration %s cached as %s (sha256 %s)", result.Location, result.Path, result.SHA256))
+	return 0
+}
+
+// runRemote выполняет команду на сервере логов. ssh передает команду удаленному shell одной строкой,
+// поэтому каждый аргумент экранируется отдельно и не может стать частью другой команды.
+func runRemote(opts []string, serverAddr string, argv ...string) ([]byte, error) {
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	args := append(append([]string{}, opts...), "--", serverAddr, strings.Join(quoted, " "))
+	return tracedCombinedOutput(exec.Command("ssh", args...))
+}
+
+// shellQuote заключает строку в одинарные кавычки для POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// uploadVerified загружает файл (или каталог) под временным именем, проверяет контрольную сумму
+// и атомарно переименовывает его в remotePath. При несовпадении - удаление и повтор с паузой.
+func uploadVerified(opts []string, serverAddr, localPath, remotePath string, retries int, out *backgroundUpload) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+
+	var localSum string
+	if !info.IsDir() {
+		if localSum, err = fileSHA256(localPath); err != nil {
+			return fmt.Errorf("failed to checksum %s: %v", localPath, err)
+		}
+	}
+
+	tmpRemote := remotePath + ".part"
+	var lastErr error
+	for attempt := 1; attempt <= retries; attempt++ {
+		if attempt > 1 {
+			backoff := time.Duration(attempt-1) * 2 * time.Second
+			out.print(ColorYellow, fmt.Sprintf("Upload of %s failed (%v), retrying in %s (attempt %d/%d)",
+				filepath.Base(localPath), lastErr, backoff, attempt, retries))
+			time.Sleep(backoff)
+		}
+
+		runRemote(opts, serverAddr, "rm", "-rf", "--", tmpRemote)
+		args := append(append([]string{}, opts...), "-r", localPath, fmt.Sprintf("%s:%s", serverAddr, tmpRemote))
+		if output, err := tracedCombinedOutput(exec.Command("scp", args...)); err != nil {
+			lastErr = fmt.Errorf("scp failed: %v (%s)", err, strings.TrimSpace(string(output)))
+			continue
+		}
+
+		if !info.IsDir() {
+			if err := verifyRemoteFile(opts, serverAddr, tmpRemote, localSum, info.Size()); err != nil {
+				lastErr = err
+				runRemote(opts, serverAddr, "rm", "-f", "--", tmpRemote)
+				continue
+			}
+		}
+
+		if output, err := runRemote(opts, serverAddr, "rm", "-rf", "--", remotePath); err != nil {
+			lastErr = fmt.Errorf("rename failed: %v (%s)", err, strings.TrimSpace(string(output)))
+			continue
+		}
+		if output, err := runRemote(opts, serverAddr, "mv", "-f", "--", tmpRemote, remotePath); err != nil {
+			lastErr = fmt.Errorf("rename failed: %v (%s)", err, strings.TrimSpace(string(output)))
+			continue
+		}
+
+		if info.IsDir() {
+			out.print(ColorBlue, fmt.Sprintf("Uploaded %s (attempt %d/%d)", filepath.Base(localPath), attempt, retries))
+		} else {
+			out.print(ColorBlue, fmt.Sprintf("Uploaded %s verified (attempt %d/%d, sha256 %s)", filepath.Base(localPath), attempt, retries, localSum))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("upload not verified after %d attempt(s): %v", retries, lastErr)
+}
+
+// verifyRemoteFile сравнивает sha256 удаленного файла с локальным (или размер, если sha256sum нет)
+func verifyRemoteFile(opts []string, serverAddr, remotePath, localSum string, localSize int64) error {
+	output, err := runRemote(opts, serverAddr, "sha256sum", "--", remotePath)
+	if fields := strings.Fields(string(output)); err == nil && len(fields) > 0 && len(fields[0]) == 64 {
+		if fields[0] != localSum {
+			return fmt.Errorf("checksum mismatch: local %s, remote %s", localSum, fields[0])
+		}
+		return nil
+	}
+
+	// sha256sum недоступен - сравниваем размер
+	output, err = runRemote(opts, serverAddr, "wc", "-c", "--", remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to verify remote file: %v", err)
+	}
+	sizeField := strings.TrimSpace(string(output))
+	if fields := strings.Fields(sizeField); len(fields) > 0 {
+		sizeField = fields[0]
+	}
+	remoteSize, err := strconv.ParseInt(sizeField, 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse remote size %q", strings.TrimSpace(string(output)))
+	}
+	if remoteSize != localSize {
+		return fmt.Errorf("size mismatch: local %d, remote %d", localSize, remoteSize)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// spoolLog сохраняет не выгруженный лог в <log_dir>/outbox вместе с пояснением причины
+func spoolLog(data []byte, name string, config LogConfig, reason error) (string, error) {
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+	outbox := filepath.Join(logDir, "outbox")
+	if err := os.MkdirAll(outbox, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outbox, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+
+	note := fmt.Sprintf("time: %s\nserver: %s\nserver_dir: %s\npid: %d\nreason: %v\n",
+		time.Now().Format(time.RFC3339), config.Server, config.ServerDir, os.Getpid(), reason)
+	if err := os.WriteFile(path+".note.txt", []byte(note), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// unspoolLog убирает из outbox выгруженный лог и его пояснение
+func unspoolLog(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path + ".note.txt"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// spooledByLiveProcess - лог в outbox положил процесс, который еще работает (его выгрузка идет прямо сейчас)
+func spooledByLiveProcess(path string) bool {
+	note, err := os.ReadFile(path + ".note.txt")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(note), "\n") {
+		if value, ok := strings.CutPrefix(line, "pid: "); ok {
+			pid, err := strconv.Atoi(strings.TrimSpace(value))
+			return err == nil && pid > 0 && syscall.Kill(pid, 0) == nil
+		}
+	}
+	return false
+}
+
+// flushOutbox выгружает логи из outbox (прошлые неудачные выгрузки и брошенные на середине)
+// и отмечает результат в манифесте. Логи, чья выгрузка еще идет в другом процессе, не трогаются
+func flushOutbox(config LogConfig) (int, error) {
+	outbox := filepath.Join(logDirPath(config), "outbox")
+	items, err := os.ReadDir(outbox)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var pending []string
+	for _, item := range items {
+		path := filepath.Join(outbox, item.Name())
+		if item.Type().IsRegular() && strings.HasSuffix(item.Name(), ".yaml") && !spooledByLiveProcess(path) {
+			pending = append(pending, path)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	if len(strings.Split(config.Server, "@")) != 2 {
+		return 0, fmt.Errorf("invalid server format, expected user@host: %s", config.Server)
+	}
+	check := checkUploadPath(config)
+	if !check.Reachable {
+		return 0, fmt.Errorf("log server unreachable: %s", check.Error)
+	}
+	opts := sshOptions(check.BindAddress)
+	retries := config.UploadRetries
+	if retries <= 0 {
+		retries = 3
+	}
+
+	uploaded := 0
+	for _, path := range pending {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return uploaded, err
+		}
+		// Лог в outbox уже обезличен (если нужно): каталог и сессия берутся из него самого
+		var log SessionLog
+		if err := yaml.Unmarshal(data, &log); err != nil {
+			printWarning(fmt.Sprintf("Outbox: %s is not a session log: %v", filepath.Base(path), err))
+			continue
+		}
+		remoteDir := remoteLogDir(config, log)
+		err = nil
+		if remoteDir != "." {
+			if output, mkErr := runRemote(opts, config.Server, "mkdir", "-p", "--", remoteDir); mkErr != nil {
+				err = fmt.Errorf("failed to create remote directory: %v (%s)", mkErr, strings.TrimSpace(string(output)))
+			}
+		}
+		if err == nil {
+			err = uploadVerified(opts, config.Server, path, remoteDir+"/"+filepath.Base(path), retries, nil)
+		}
+		if err != nil {
+			spoolLog(data, filepath.Base(path), config, err)
+			if err := updateManifestUpload(config, log.SessionID, manifestUploadSpooled, err); err != nil {
+				printWarning(fmt.Sprintf("Manifest not updated: %v", err))
+			}
+			printWarning(fmt.Sprintf("Outbox: %s not uploaded: %v", filepath.Base(path), err))
+			continue
+		}
+		if err := unspoolLog(path); err != nil {
+			printWarning(fmt.Sprintf("Uploaded log left in outbox: %v", err))
+		}
+		if err := updateManifestUpload(config, log.SessionID, manifestUploaded, nil); err != nil {
+			printWarning(fmt.Sprintf("Manifest not updated: %v", err))
+		}
+		printSuccess(fmt.Sprintf("Outbox: %s uploaded", filepath.Base(path)))
+		uploaded++
+	}
+	return uploaded, nil
+}
+
+// Статусы выгрузки в манифесте
+const (
+	manifestUploadPending  = "pending"  // Лог сохранен, выгрузка еще не закончилась
+	manifestUploaded       = "uploaded" // Лог на сервере
+	manifestUploadSpooled  = "spooled"  // Не выгружен, лежит в outbox
+	manifestUploadFailed   = "failed"   // Не выгружен и не сохранен в outbox
+	manifestUploadDisabled = "local"    // send_logs выключен - только локальная копия
+)
+
+var manifestFileRegex = regexp.MustCompile(`^manifest_(\d{8})\.yaml(\.lock|\.uploaded)?$`)
+
+// ManifestRecord - одна завершенная сессия в дневном манифесте станции
+type ManifestRecord struct {
+	SessionID   string     `yaml:"session"`
+	Completed   time.Time  `yaml:"completed"`
+	Product     string     `yaml:"product"`
+	Serial      string     `yaml:"serial,omitempty"`
+	State       string     `yaml:"state"`
+	LogFile     string     `yaml:"log_file"`         // Имя лога на сервере (при log.redact - обезличенное, как и serial)
+	SHA256      string     `yaml:"sha256,omitempty"` // Локального лога (выгруженная копия может быть обезличена)
+	Upload      string     `yaml:"upload"`           // pending, uploaded, spooled, failed, local
+	UploadError string     `yaml:"upload_error,omitempty"`
+	UploadedAt  *time.Time `yaml:"uploaded_at,omitempty"`
+}
+
+// Manifest - manifest_YYYYMMDD.yaml: сессии, завершенные станцией за день
+type Manifest struct {
+	Date     string           `yaml:"date"`
+	Station  string           `yaml:"station"`
+	Sessions []ManifestRecord `yaml:"sessions"`
+}
+
+// logDirPath - log_dir или каталог по умолчанию
+func logDirPath(config LogConfig) string {
+	if config.LogDir == "" {
+		return "logs"
+	}
+	return config.LogDir
+}
+
+// manifestFileName - манифест дня t. Сессия попадает в манифест дня, когда она завершилась
+// (начатая до полуночи и законченная после - в манифест нового дня)
+func manifestFileName(t time.Time) string {
+	return "manifest_" + t.Format("20060102") + ".yaml"
+}
+
+// modifyManifest меняет манифест под flock (несколько терминалов на одной станции пишут в один log_dir)
+// и заменяет файл атомарно: временный файл в том же каталоге, затем rename
+func modifyManifest(path string, modify func(*Manifest) bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s: %v", path, err)
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	var manifest Manifest
+	if data, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if !modify(&manifest) {
+		return nil
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".manifest_*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordManifest добавляет сессию в манифест текущего дня; повторная запись той же сессии
+// (-continue после перезагрузки) заменяет прежнюю
+func recordManifest(config LogConfig, log SessionLog, savedLog string, now time.Time) error {
+	record := ManifestRecord{
+		SessionID: log.SessionID,
+		Completed: now,
+		Product:   log.System.Product,
+		Serial:    log.System.MBSerial,
+		State:     log.State,
+		LogFile:   sessionLogFileName(log),
+		Upload:    manifestUploadPending,
+	}
+	if len(config.Redact.Fields) > 0 {
+		// Манифест уходит на сервер: серийник и имя лога в нем те же, что в обезличенном логе
+		redactor := newLogRedactor(config.Redact)
+		record.LogFile = redactor.logFileName(log)
+		if redactor.fields[redactSerials] && strings.TrimSpace(record.Serial) != "" {
+			record.Serial = redactor.replacement(strings.TrimSpace(record.Serial), redactSerials, false)
+		}
+	}
+	if !config.SendLogs || config.Server == "" {
+		record.Upload = manifestUploadDisabled
+	}
+	if savedLog != "" {
+		if sum, err := fileSHA256(savedLog); err == nil {
+			record.SHA256 = sum
+		}
+	}
+
+	return modifyManifest(filepath.Join(logDirPath(config), manifestFileName(now)), func(m *Manifest) bool {
+		m.Date = now.Format("2006-01-02")
+		m.Station = stationDirName(log.System.Station)
+		for i := range m.Sessions {
+			if m.Sessions[i].SessionID == record.SessionID {
+				m.Sessions[i] = record
+				return true
+			}
+		}
+		m.Sessions = append(m.Sessions, record)
+		return true
+	})
+}
+
+// updateManifestUpload отмечает результат выгрузки лога сессии в манифесте, где она записана
+// (самые новые манифесты первыми - выгрузка могла закончиться уже на следующий день)
+func updateManifestUpload(config LogConfig, sessionID, status string, uploadErr error) error {
+	if !config.Manifest {
+		return nil
+	}
+	logDir := logDirPath(config)
+	items, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil
+	}
+	var manifests []string
+	for _, item := range items {
+		if m := manifestFileRegex.FindStringSubmatch(item.Name()); m != nil && m[2] == "" {
+			manifests = append(manifests, item.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(manifests)))
+
+	now := time.Now()
+	for _, name := range manifests {
+		found := false
+		err := modifyManifest(filepath.Join(logDir, name), func(m *Manifest) bool {
+			for i := range m.Sessions {
+				if m.Sessions[i].SessionID != sessionID {
+					continue
+				}
+				found = true
+				m.Sessions[i].Upload = status
+				m.Sessions[i].UploadError = ""
+				m.Sessions[i].UploadedAt = nil
+				if uploadErr != nil {
+					m.Sessions[i].UploadError = uploadErr.Error()
+				}
+				if status == manifestUploaded {
+					m.Sessions[i].UploadedAt = &now
+				}
+				return true
+			}
+			return false
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if found {
+			return nil
+		}
+	}
+	return nil
+}
+
+// manifestUploadDue - пора ли выгружать манифест дня day: прошлые дни - всегда,
+// текущий - после log.manifest_upload_time или принудительно (-upload-manifest)
+func manifestUploadDue(day, now time.Time, uploadTime string, force bool) bool {
+	today := now.Format("20060102")
+	if day.Format("20060102") < today || force {
+		return true
+	}
+	if uploadTime == "" {
+		return false
+	}
+	at, err := time.Parse("15:04", uploadTime)
+	if err != nil {
+		return false
+	}
+	return now.Hour()*60+now.Minute() >= at.Hour()*60+at.Minute()
+}
+
+// uploadManifests выгружает манифесты, которые пора выгрузить и которые изменились после прошлой выгрузки
+// (sha256 выгруженной версии - в manifest_YYYYMMDD.yaml.uploaded). Возвращает число выгруженных
+func uploadManifests(config LogConfig, now time.Time, force bool) (int, error) {
+	logDir := logDirPath(config)
+	items, err := os.ReadDir(logDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	uploaded := 0
+	for _, item := range items {
+		m := manifestFileRegex.FindStringSubmatch(item.Name())
+		if m == nil || m[2] != "" {
+			continue
+		}
+		day, err := time.ParseInLocation("20060102", m[1], now.Location())
+		if err != nil || !manifestUploadDue(day, now, config.ManifestUploadTime, force) {
+			continue
+		}
+
+		path := filepath.Join(logDir, item.Name())
+		// Снимок под блокировкой: пока идет scp, другой терминал может дописать манифест
+		var snapshot []byte
+		var readErr error
+		if err := modifyManifest(path, func(*Manifest) bool {
+			snapshot, readErr = os.ReadFile(path)
+			return false
+		}); err != nil {
+			return uploaded, err
+		}
+		if readErr != nil {
+			return uploaded, readErr
+		}
+		sum := sha256.Sum256(snapshot)
+		digest := hex.EncodeToString(sum[:])
+		if marker, err := os.ReadFile(path + ".uploaded"); err == nil && strings.TrimSpace(string(marker)) == digest {
+			continue
+		}
+
+		if err := uploadManifestSnapshot(config, item.Name(), snapshot); err != nil {
+			return uploaded, fmt.Errorf("%s: %v", item.Name(), err)
+		}
+		if err := os.WriteFile(path+".uploaded", []byte(digest+"\n"), 0644); err != nil {
+			printWarning(fmt.Sprintf("Manifest upload marker not saved: %v", err))
+		}
+		printSuccess(fmt.Sprintf("Manifest uploaded: %s", item.Name()))
+		uploaded++
+	}
+	return uploaded, nil
+}
+
+// uploadManifestSnapshot выгружает манифест в server_dir[/<станция>]/manifests/<станция>_manifest_YYYYMMDD.yaml
+func uploadManifestSnapshot(config LogConfig, name string, data []byte) error {
+	if len(strings.Split(config.Server, "@")) != 2 {
+		return fmt.Errorf("invalid server format, expected user@host: %s", config.Server)
+	}
+	check := checkUploadPath(config)
+	if !check.Reachable {
+		return fmt.Errorf("log server unreachable: %s", check.Error)
+	}
+	opts := sshOptions(check.BindAddress)
+
+	station := stationDirName(collectStationInfo(config))
+	remoteDirParts := []string{}
+	if config.ServerDir != "" {
+		remoteDirParts = append(remoteDirParts, config.ServerDir)
+	}
+	if config.GroupByStation {
+		remoteDirParts = append(remoteDirParts, station)
+	}
+	remoteDir := strings.Join(append(remoteDirParts, "manifests"), "/")
+	if _, err := runRemote(opts, config.Server, "mkdir", "-p", "--", remoteDir); err != nil {
+		return fmt.Errorf("failed to create remote directory: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp("", "firestarter_manifest_*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	tmpFile.Close()
+
+	retries := config.UploadRetries
+	if retries <= 0 {
+		retries = 3
+	}
+	return uploadVerified(opts, config.Server, tmpFile.Name(), fmt.Sprintf("%s/%s_%s", remoteDir, station, name), retries, nil)
+}
+
+// Категории log.redact.fields
+const (
+	redactSerials  = "serials"
+	redactMACs     = "macs"
+	redactIPs      = "ips"
+	redactOperator = "operator"
+)
+
+// redactSaltEnvVar - соль hash, если log.redact.salt не задан (чтобы не хранить ее в конфиге на сервере)
+const redactSaltEnvVar = "FIRESTARTER_REDACT_SALT"
+
+var (
+	redactMACRegex = regexp.MustCompile(`(?i)\b[0-9a-f]{2}(?:[:-][0-9a-f]{2}){5}\b`)
+	redactIPRegex  = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)
+)
+
+// redactKeyCategory - категория поля лога по его ключу (mb_serial, "Serial Number" из dmidecode, original_macs...)
+func redactKeyCategory(key string) string {
+	k := strings.ToLower(key)
+	switch {
+	case strings.Contains(k, "serial"):
+		return redactSerials
+	case k == "mac" || k == "macs" || strings.HasSuffix(k, "_mac") || strings.HasSuffix(k, "_macs"):
+		return redactMACs
+	case k == "ip" || strings.HasSuffix(k, "_ip"):
+		return redactIPs
+	case k == "operator" || k == "operators" || k == "op_name":
+		return redactOperator
+	}
+	return ""
+}
+
+// validateRedactConfig проверяет категории и режим; для hash нужна соль, иначе серийники перебираются по словарю
+func validateRedactConfig(config RedactConfig) error {
+	for _, field := range config.Fields {
+		switch field {
+		case redactSerials, redactMACs, redactIPs, redactOperator:
+		default:
+			return fmt.Errorf("unknown field category %q (serials, macs, ips, operator)", field)
+		}
+	}
+	switch config.Mode {
+	case "", "hash":
+		if len(config.Fields) > 0 && config.Salt == "" && os.Getenv(redactSaltEnvVar) == "" {
+			return fmt.Errorf("mode hash needs salt (or %s)", redactSaltEnvVar)
+		}
+	case "mask", "drop":
+	default:
+		return fmt.Errorf("unknown mode %q (hash, mask, drop)", config.Mode)
+	}
+	return nil
+}
+
+// logRedactor заменяет чувствительные значения в копии лога; одно и то же значение всегда получает одну замену
+type logRedactor struct {
+	mode    string
+	salt    string
+	mapFile string
+	fields  map[string]bool
+	known   map[string]string // Значения из полей с известным ключом -> категория (ищутся и в свободном тексте)
+	mapping map[string]string // Оригинал -> замена
+	count   int               // Сколько замен сделано
+}
+
+func newLogRedactor(config RedactConfig) *logRedactor {
+	r := &logRedactor{
+		mode:    config.Mode,
+		salt:    config.Salt,
+		mapFile: config.MapFile,
+		fields:  make(map[string]bool),
+		known:   make(map[string]string),
+		mapping: make(map[string]string),
+	}
+	if r.mode == "" {
+		r.mode = "hash"
+	}
+	if r.salt == "" {
+		r.salt = os.Getenv(redactSaltEnvVar)
+	}
+	for _, field := range config.Fields {
+		r.fields[field] = true
+	}
+	return r
+}
+
+// replacement - замена значения: hash - "h:" и первые 16 hex sha256(соль, значение), mask - середина звездочками,
+// drop - пусто для целого поля и "[redacted]" внутри текста. MAC сравниваются без учета регистра
+func (r *logRedactor) replacement(value, category string, inText bool) string {
+	key := value
+	if category == redactMACs {
+		key = strings.ToLower(value)
+	}
+	r.count++
+	if r.mode == "drop" {
+		if inText {
+			return "[redacted]"
+		}
+		return ""
+	}
+	if replaced, ok := r.mapping[key]; ok {
+		return replaced
+	}
+	var replaced string
+	switch r.mode {
+	case "mask":
+		runes := []rune(value)
+		if len(runes) <= 4 {
+			replaced = strings.Repeat("*", len(runes))
+		} else {
+			replaced = string(runes[:2]) + strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-2:])
+		}
+	default:
+		sum := sha256.Sum256([]byte(r.salt + "\x00" + key))
+		replaced = "h:" + hex.EncodeToString(sum[:])[:16]
+	}
+	r.mapping[key] = replaced
+	return replaced
+}
+
+// scrubText заменяет в свободном тексте (ошибки, вывод тестов, dmidecode) известные значения и MAC/IP по шаблону
+func (r *logRedactor) scrubText(text string) string {
+	// Длинные значения первыми, чтобы серийник не заменился по частям
+	known := make([]string, 0, len(r.known))
+	for value := range r.known {
+		known = append(known, value)
+	}
+	sort.Slice(known, func(i, j int) bool { return len(known[i]) > len(known[j]) })
+	for _, value := range known {
+		if strings.Contains(text, value) {
+			text = strings.ReplaceAll(text, value, r.replacement(value, r.known[value], true))
+		}
+	}
+	if r.fields[redactMACs] {
+		text = redactMACRegex.ReplaceAllStringFunc(text, func(m string) string { return r.replacement(m, redactMACs, true) })
+	}
+	if r.fields[redactIPs] {
+		text = redactIPRegex.ReplaceAllStringFunc(text, func(m string) string { return r.replacement(m, redactIPs, true) })
+	}
+	return text
+}
+
+// walkYAML обходит строковые скаляры документа; category - категория ключа, под которым лежит значение
+// (для списков наследуется элементами: original_macs, operators)
+func walkYAML(node *yaml.Node, category string, visit func(node *yaml.Node, category string)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkYAML(child, "", visit)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			walkYAML(node.Content[i+1], redactKeyCategory(node.Content[i].Value), visit)
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			walkYAML(child, category, visit)
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!str" {
+			visit(node, category)
+		}
+	}
+}
+
+// redactYAML обезличивает YAML лога. Меняются только значения строковых скаляров, ключи и структура
+// остаются прежними, поэтому серверные парсеры читают лог как обычно
+func (r *logRedactor) redactYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	// Первый проход собирает значения полей с известным ключом - их же ищем в тексте на втором
+	walkYAML(&doc, "", func(node *yaml.Node, category string) {
+		value := strings.TrimSpace(node.Value)
+		if r.fields[category] && len(value) >= 3 {
+			r.known[value] = category
+		}
+	})
+	walkYAML(&doc, "", func(node *yaml.Node, category string) {
+		if r.fields[category] && strings.TrimSpace(node.Value) != "" {
+			node.Value = r.replacement(strings.TrimSpace(node.Value), category, false)
+		} else {
+			node.Value = r.scrubText(node.Value)
+		}
+	})
+	return yaml.Marshal(&doc)
+}
+
+// redactArtifacts копирует артефакты (файлы и каталоги) во временный каталог с обезличенным текстом.
+// Имена сохраняются - на сервере артефакты называются по базовому имени
+func (r *logRedactor) redactArtifacts(artifacts []string) ([]string, func(), error) {
+	if len(artifacts) == 0 {
+		return nil, func() {}, nil
+	}
+	tmpDir, err := os.MkdirTemp("", "firestarter_redacted_*")
+	if err != nil {
+		return nil, func() {}, err
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+
+	var redacted []string
+	for _, artifact := range artifacts {
+		target := filepath.Join(tmpDir, filepath.Base(artifact))
+		err := filepath.WalkDir(artifact, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(artifact, path)
+			if err != nil {
+				return err
+			}
+			dest := filepath.Join(target, rel)
+			if d.IsDir() {
+				return os.MkdirAll(dest, 0755)
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(dest, []byte(r.scrubText(string(content))), 0644)
+		})
+		if err != nil {
+			cleanup()
+			return nil, func() {}, fmt.Errorf("%s: %v", filepath.Base(artifact), err)
+		}
+		redacted = append(redacted, target)
+	}
+	return redacted, cleanup, nil
+}
+
+// logFileName - имя лога на сервере и в outbox: серийник в имени заменяется так же, как в самом логе
+// (в режиме drop - session-<ID>), иначе имя файла выдает то, что скрыто внутри
+func (r *logRedactor) logFileName(log SessionLog) string {
+	if r.fields[redactSerials] && strings.TrimSpace(log.System.MBSerial) != "" {
+		log.System.MBSerial = r.replacement(strings.TrimSpace(log.System.MBSerial), redactSerials, false)
+	}
+	return sessionLogFileName(log)
+}
+
+// saveMap дописывает соответствия оригинал -> замена в log.redact.map_file (только локально, права 0600).
+// В режиме drop восстанавливать нечего - файл не пишется
+func (r *logRedactor) saveMap() error {
+	if r.mapFile == "" || r.mode == "drop" || len(r.mapping) == 0 {
+		return nil
+	}
+	existing := make(map[string]string)
+	if data, err := os.ReadFile(r.mapFile); err == nil {
+		if err := yaml.Unmarshal(data, &existing); err != nil {
+			return fmt.Errorf("%s: %v", r.mapFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for original, replaced := range r.mapping {
+		existing[original] = replaced
+	}
+	data, err := yaml.Marshal(existing)
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(r.mapFile); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(r.mapFile, data, 0600)
+}
+
+// getCurrentFRUSerial читает текущий серийный номер из FRU чипа
+func getCurrentFRUSerial() (string, error) {
+	cmd := exec.Command("ipmitool", "fru", "print", "0")
+	output, err := tracedCombinedOutput(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	for _, field := range parseFRUFields(string(output)) {
+		if field.Name == "Board Serial" {
+			if field.Value == "" || field.Value == "Not Specified" || field.Value == "Unknown" {
+				return "", fmt.Errorf("no valid serial number found in FRU")
+			}
+			return field.Value, nil
+		}
+	}
+
+	return "", fmt.Errorf("Board Serial field not found in FRU data")
+}
+
+// parseFRUFields разбирает вывод ipmitool fru print в список "имя: значение" (в порядке вывода)
+func parseFRUFields(output string) []FRUField {
+	var fields []FRUField
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if name == "" || strings.HasPrefix(name, "FRU Device Description") {
+			continue
+		}
+		fields = append(fields, FRUField{Name: name, Value: strings.TrimSpace(parts[1])})
+	}
+	return fields
+}
+
+// fruChecksumOK - сумма байт области FRU по модулю 256 должна быть равна нулю
+func fruChecksumOK(data []byte) bool {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum == 0
+}
+
+// analyzeFRUBinary проверяет бинарный образ FRU по спецификации IPMI FRU:
+// common header (8 байт) и области chassis/board/product со своими контрольными суммами
+func analyzeFRUBinary(raw []byte, status *FRUStatus) {
+	status.IsEmpty = false
+	status.HeaderBadSum = false
+	status.BadAreas = nil
+
+	blank := true
+	for _, b := range raw {
+		if b != 0x00 && b != 0xFF {
+			blank = false
+			break
+		}
+	}
+	if len(raw) < 8 || blank {
+		status.IsEmpty = true
+		status.HasBadSum = false
+		return
+	}
+
+	if raw[0]&0x0F != 0x01 || !fruChecksumOK(raw[:8]) {
+		status.HeaderBadSum = true
+		status.HasBadSum = true
+		return
+	}
+
+	// Смещения областей в header задаются в 8-байтных блоках; internal use area своей суммы не имеет
+	areas := []struct {
+		name  string
+		index int
+	}{{"chassis", 2}, {"board", 3}, {"product", 4}}
+	for _, area := range areas {
+		offset := int(raw[area.index]) * 8
+		if offset == 0 {
+			continue
+		}
+		if offset+2 > len(raw) {
+			status.BadAreas = append(status.BadAreas, area.name)
+			continue
+		}
+		length := int(raw[offset+1]) * 8
+		if length == 0 || offset+length > len(raw) || !fruChecksumOK(raw[offset:offset+length]) {
+			status.BadAreas = append(status.BadAreas, area.name)
+		}
+	}
+	status.HasBadSum = len(status.BadAreas) > 0
+}
+
+// Health классифицирует состояние FRU; код используется как код выхода -fru-status
+func (s *FRUStatus) Health() (string, int) {
+	switch {
+	case s.Raw == nil && !s.CanRead:
+		return "unreadable", 1
+	case s.IsEmpty:
+		return "empty", 2
+	case s.HeaderBadSum:
+		return "common header checksum bad", 3
+	case len(s.BadAreas) > 0:
+		return fmt.Sprintf("%s area checksum bad", strings.Join(s.BadAreas, ", ")), 4
+	case s.HasBadSum:
+		return "checksum bad", 4
+	}
+	return "healthy", 0
+}
+
+// needsBlankFlash - полная очистка нужна только без валидного header; испорченную область
+// перестраивает обычная запись сгенерированного FRU
+func (s *FRUStatus) needsBlankFlash() bool {
+	if s.Raw == nil {
+		return s.HasBadSum || s.IsEmpty || !s.CanRead
+	}
+	return s.IsEmpty || s.HeaderBadSum
+}
+
+func checkFRUStatus() (*FRUStatus, error) {
+	printInfo("Checking FRU chip status...")
+
+	status := &FRUStatus{}
+
+	// Try to read FRU data using ipmitool
+	cmd := exec.Command("ipmitool", "fru", "print", "0")
+	output, err := tracedCombinedOutput(cmd)
+	outputStr := string(output)
+
+	if err != nil {
+		printWarning(fmt.Sprintf("FRU read returned error: %v", err))
+		status.CanRead = false
+		status.ErrorMessage = err.Error()
+
+		// Check specific error patterns that indicate FRU needs initialization
+		outputLower := strings.ToLower(outputStr)
+
+		if strings.Contains(outputLower, "unknown fru header version") {
+			status.IsEmpty = true
+			status.HasBadSum = true // Corrupted header also needs blank flash
+			printWarning("FRU has corrupted header (Unknown FRU header version) - needs initialization")
+		} else if strings.Contains(outputLower, "no fru data") ||
+			strings.Contains(outputLower, "invalid") ||
+			strings.Contains(outputLower, "empty") {
+			status.IsEmpty = true
+			printWarning("FRU appears to be empty")
+		} else if strings.Contains(outputLower, "checksum") ||
+			strings.Contains(outputLower, "badchecksum") {
+			status.HasBadSum = true
+			printWarning("FRU has bad checksum")
+		} else if strings.Contains(outputLower, "fru read failed") ||
+			strings.Contains(outputLower, "fru data checksum") {
+			status.HasBadSum = true
+			printWarning("FRU data corruption detected")
+		} else {
+			// For any other FRU read error, assume it needs reinitialization
+			status.IsEmpty = true
+			status.HasBadSum = true
+			printWarning(fmt.Sprintf("FRU read failed with unknown error - assuming corruption: %s", outputStr))
+		}
+	} else {
+		status.CanRead = true
+		status.IsPresent = true
+		status.Fields = parseFRUFields(outputStr)
+
+		// Check if FRU has actual valid data
+		if strings.Contains(outputStr, "Board Mfg") ||
+			strings.Contains(outputStr, "Board Product") ||
+			strings.Contains(outputStr, "Board Serial") {
+			printSuccess("FRU contains valid data")
+		} else {
+			status.IsEmpty = true
+			printInfo("FRU is readable but appears empty")
+		}
+	}
+
+	// Бинарный образ точнее текстовых эвристик: различает header и отдельные области
+	if raw, err := readFRUBinary(0); err != nil {
+		printWarning(fmt.Sprintf("Raw FRU read failed, relying on fru print only: %v", err))
+	} else {
+		status.Raw = raw
+		analyzeFRUBinary(raw, status)
+	}
+
+	// Summary of status
+	if status.IsEmpty && status.HasBadSum {
+		printInfo("FRU Status: Corrupted/Empty - requires blank initialization")
+	} else if status.IsEmpty {
+		printInfo("FRU Status: Empty - requires initialization")
+	} else if status.HeaderBadSum {
+		printInfo("FRU Status: Common header checksum bad - requires blank initialization")
+	} else if len(status.BadAreas) > 0 {
+		printInfo(fmt.Sprintf("FRU Status: Bad checksum in %s area - will be rebuilt", strings.Join(status.BadAreas, ", ")))
+	} else if status.HasBadSum {
+		printInfo("FRU Status: Bad checksum - requires reinitialization")
+	} else if status.CanRead {
+		printInfo("FRU Status: Valid data present")
+	}
+
+	return status, nil
+}
+
+// readFRUBinary читает содержимое FRU во временный файл и возвращает байты
+func readFRUBinary(deviceID uint8) ([]byte, error) {
+	tmpDir, err := os.MkdirTemp("", "fru_read_")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "fru.bin")
+	if err := dumpFRUBinary(deviceID, path); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// runFRUStatus - режим -fru-status: отчет о FRU без запуска пайплайна. Возвращает код выхода.
+func runFRUStatus() int {
+	printSubHeader("FRU STATUS", "ipmitool fru print 0 / fru read 0")
+	printSeparator()
+
+	status, err := checkFRUStatus()
+	if err != nil {
+		printError(fmt.Sprintf("FRU status check failed: %v", err))
+		return 1
+	}
+	health, code := status.Health()
+
+	fmt.Println()
+	fmt.Printf("  Present         : %t\n", status.IsPresent)
+	fmt.Printf("  Readable        : %t\n", status.CanRead)
+	fmt.Printf("  Empty           : %t\n", status.IsEmpty)
+	fmt.Printf("  Header checksum : %s\n", map[bool]string{false: "OK", true: "BAD"}[status.HeaderBadSum])
+	if len(status.BadAreas) > 0 {
+		fmt.Printf("  Bad areas       : %s\n", strings.Join(status.BadAreas, ", "))
+	}
+	if status.ErrorMessage != "" {
+		fmt.Printf("  Error           : %s\n", status.ErrorMessage)
+	}
+	color := ColorGreen
+	if code != 0 {
+		color = ColorRed
+	}
+	fmt.Printf("  Health          : %s%s%s (exit code %d)\n", color, strings.ToUpper(health), ColorReset, code)
+
+	if len(status.Fields) > 0 {
+		printSubHeader("Decoded fields", "")
+		for _, field := range status.Fields {
+			fmt.Printf("  %-24s: %s\n", field.Name, field.Value)
+		}
+	}
+
+	if status.Raw != nil {
+		printSubHeader("Raw FRU", fmt.Sprintf("%d bytes", len(status.Raw)))
+		fmt.Print(hex.Dump(status.Raw))
+	}
+
+	return code
+}
+
+// fruBlankSize - размер нулевого образа очистки FRU (flash.fru_blank_size_bytes)
+var fruBlankSize = 2048
+
+func createFRUBlankFile() (string, error) {
+	printInfo(fmt.Sprintf("Creating blank FRU file (%d null bytes - equivalent to 'dd if=/dev/zero bs=%d count=1')...", fruBlankSize, fruBlankSize))
+
+	tmpFile, err := os.CreateTemp("", "fru_blank_*.bin")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	defer tmpFile.Close()
+
+	// Write null bytes (same as dd if=/dev/zero of=file bs=<size> count=1)
+	nullData := make([]byte, fruBlankSize)
+	bytesWritten, err := tmpFile.Write(nullData)
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write blank data: %v", err)
+	}
+
+	if bytesWritten != fruBlankSize {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("wrote %d bytes, expected %d", bytesWritten, fruBlankSize)
+	}
+
+	printSuccess(fmt.Sprintf("Blank FRU file created: %s (%d bytes)", tmpFile.Name(), bytesWritten))
+	return tmpFile.Name(), nil
+}
+
+func flashFRUFile(filename string) error {
+	printInfo(fmt.Sprintf("Flashing FRU file: %s", filename))
+
+	// Use ipmitool to write FRU file
+	cmd := exec.Command("ipmitool", "fru", "write", "0", filename)
+	output, err := tracedCombinedOutput(cmd)
+	outputStr := string(output)
+
+	if err != nil {
+		return fmt.Errorf("FRU flash failed: %v\nOutput: %s", err, outputStr)
+	}
+
+	// Check for success indicators in output
+	if strings.Contains(strings.ToLower(outputStr), "success") ||
+		strings.Contains(strings.ToLower(outputStr), "written") ||
+		len(outputStr) == 0 { // Sometimes ipmitool outputs nothing on success
+		printSuccess("FRU file flashed successfully")
+		return nil
+	}
+
+	// Check for error indicators
+	if strings.Contains(strings.ToLower(outputStr), "error") ||
+		strings.Contains(strings.ToLower(outputStr), "fail") {
+		return fmt.Errorf("FRU flash reported error: %s", outputStr)
+	}
+
+	// If no clear indicators, assume success (some ipmitool versions are quiet)
+	printSuccess("FRU flash command completed")
+	return nil
+}
+
+func generateFRUFile(systemConfig SystemConfig, serialNumber string) (string, error) {
+	printInfo("Generating FRU file with frugen...")
+
+	// Create temporary file for FRU output
+	tmpFile, err := os.CreateTemp("", "fru_generated_*.bin")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %v", err)
+	}
+	tmpFile.Close() // Close it so frugen can write to it
+
+	// Prepare frugen command
+	manufacturer := systemConfig.Manufacturer
+	if manufacturer == "" {
+		manufacturer = "Unknown" // fallback
+	}
+
+	product := systemConfig.Product
+	if product == "" {
+		product = "Unknown" // fallback
+	}
+
+	cmd := exec.Command("frugen",
+		"--board-mfg", manufacturer,
+		"--board-pname", product,
+		"--board-serial", serialNumber,
+		"--ascii",
+		tmpFile.Name())
+
+	printInfo(fmt.Sprintf("Executing: frugen --board-mfg \"%s\" --board-pname \"%s\" --board-serial \"%s\" --ascii %s",
+		manufacturer, product, serialNumber, tmpFile.Name()))
+
+	output, err := tracedCombinedOutput(cmd)
+	outputStr := string(output)
+
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("frugen failed: %v\nOutput: %s", err, outputStr)
+	}
+
+	// Check if file was actually created
+	if _, err := os.Stat(tmpFile.Name()); os.IsNotExist(err) {
+		return "", fmt.Errorf("frugen did not create output file")
+	}
+
+	printSuccess(fmt.Sprintf("FRU file generated: %s", tmpFile.Name()))
+	if outputStr != "" {
+		printInfo(fmt.Sprintf("frugen output: %s", outputStr))
+	}
+
+	return tmpFile.Name(), nil
+}
+
+func verifyFRUData(expectedManufacturer, expectedProduct, expectedSerial string) error {
+	printInfo("Verifying FRU data...")
+
+	// Wait for FRU to be readable after flashing
+	waitForFRUReady(10 * time.Second)
+
+	cmd := exec.Command("ipmitool", "fru", "print", "0")
+	output, err := tracedCombinedOutput(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to read FRU for verification: %v", err)
+	}
+
+	outputStr := string(output)
+	lines := strings.Split(outputStr, "\n")
+
+	var foundMfg, foundProduct, foundSerial string
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "Board Mfg") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				foundMfg = strings.TrimSpace(parts[1])
+			}
+		} else if strings.HasPrefix(line, "Board Product") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				foundProduct = strings.TrimSpace(parts[1])
+			}
+		} else if strings.HasPrefix(line, "Board Serial") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				foundSerial = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+
+	// Check each field
+	var errors []string
+
+	if foundMfg != expectedManufacturer {
+		errors = append(errors, fmt.Sprintf("Manufacturer mismatch: expected '%s', found '%s'", expectedManufacturer, foundMfg))
+	}
+
+	if foundProduct != expectedProduct {
+		errors = append(errors, fmt.Sprintf("Product mismatch: expected '%s', found '%s'", expectedProduct, foundProduct))
+	}
+
+	if foundSerial != expectedSerial {
+		errors = append(errors, fmt.Sprintf("Serial mismatch: expected '%s', found '%s'", expectedSerial, foundSerial))
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("FRU verification failed:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+
+	printSuccess("FRU verification passed")
+	printInfo(fmt.Sprintf("  Manufacturer: %s", foundMfg))
+	printInfo(fmt.Sprintf("  Product: %s", foundProduct))
+	printInfo(fmt.Sprintf("  Serial: %s", foundSerial))
+
+	return nil
+}
+
+func askFRURetryAction(message string) string {
+	defer sessionTimer.operatorWait()()
+
+	fmt.Printf("\n%s%s%s\n", ColorRed, tr("fru_flash_error.title"), ColorReset)
+	fmt.Printf("%s\n", message)
+	fmt.Println(tr("prompt.choose_action"))
+	fmt.Printf("  %s[Y]%s %s\n", ColorGreen, ColorReset, tr("fru_flash_error.retry"))
+	fmt.Printf("  %s[A]%s %s\n", ColorYellow, ColorReset, tr("fru_flash_error.abort"))
+	fmt.Printf("  %s[S]%s %s\n", ColorBlue, ColorReset, tr("fru_flash_error.skip"))
+	fmt.Printf("Choice [Y/a/s]: ")
+
+	reader := bufio.NewReader(operatorInput())
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return "RETRY" // default on error
+	}
+
+	choice := strings.ToUpper(strings.TrimSpace(input))
+	if choice == "" {
+		choice = "Y" // default
+	}
+
+	switch choice {
+	case "Y", "YES":
+		return "RETRY"
+	case "A", "ABORT":
+		return "ABORT"
+	case "S", "SKIP":
+		return "SKIP"
+	default:
+		fmt.Println(tr("prompt.invalid_choice", choice))
+		return "RETRY"
+	}
+}
+
+// Модифицированная функция updateEFIVariables с возвращением информации об изменениях серийного номера
+func updateEFIVariables(config SystemConfig, flashData *FlashData, backup *efiBackup) (bool, bool, error) {
+	printInfo("Updating EFI variables...")
+
+	// Validate EFI system before proceeding
+	if err := validateEFISystem(); err != nil {
+		return false, false, fmt.Errorf("EFI system validation failed: %v", err)
+	}
+
+	manager, err := newEFIVarManager(config.GuidPrefix)
+	if err != nil {
+		return false, false, err
+	}
+	if names, err := manager.List(); err == nil {
+		printDebug(fmt.Sprintf("EFI variables under %s: %s", manager.GUID.String(), strings.Join(names, ", ")))
+	}
+
+	anyChanges := false
+	serialChanged := false
+
+	// Update system serial number EFI variable
+	if flashData.SystemSerial != "" && config.EfiSnName != "" {
+		// Проверяем существующее значение
+		existingSerial, err := getEFIVariable(manager, config.EfiSnName, config.EFIVarEncoding)
+		if err == nil && existingSerial == flashData.SystemSerial {
+			printInfo(fmt.Sprintf("EFI variable %s already contains target value: %s - skipping",
+				config.EfiSnName, flashData.SystemSerial))
+		} else {
+			if err == nil {
+				printInfo(fmt.Sprintf("EFI variable %s current value: %s, updating to: %s",
+					config.EfiSnName, existingSerial, flashData.SystemSerial))
+			} else {
+				printInfo(fmt.Sprintf("EFI variable %s does not exist, creating with value: %s",
+					config.EfiSnName, flashData.SystemSerial))
+			}
+
+			err := setEFIVariable(manager, config.EfiSnName, flashData.SystemSerial, config.EFIVarEncoding, backup, efiReadBackTimeout(config))
+			if err != nil {
+				recordAudit("set_efi_var", config.EfiSnName, "FAILED", err.Error())
+				return false, false, fmt.Errorf("failed to set serial EFI variable: %v", err)
+			}
+			recordAudit("set_efi_var", config.EfiSnName, "PASSED", fmt.Sprintf("%s -> %s", existingSerial, flashData.SystemSerial))
+			anyChanges = true
+			serialChanged = true // Серийный номер изменился!
+		}
+	}
+
+	// Update MAC address EFI variable
+	if flashData.MAC != "" && config.EfiMacName != "" {
+		// Convert MAC to the format expected by EFI (remove colons, uppercase)
+		normalized, err := normalizeMAC(flashData.MAC)
+		if err != nil {
+			recordAudit("set_efi_var", config.EfiMacName, "FAILED", err.Error())
+			return false, false, fmt.Errorf("MAC EFI variable not set: %v", err)
+		}
+		hexMAC := strings.ReplaceAll(normalized, ":", "")
+
+		// Проверяем существующее значение
+		existingMAC, err := getEFIVariable(manager, config.EfiMacName, config.EFIVarEncoding)
+		if err == nil && existingMAC == hexMAC {
+			printInfo(fmt.Sprintf("EFI variable %s already contains target value: %s (MAC: %s) - skipping",
+				config.EfiMacName, hexMAC, flashData.MAC))
+		} else {
+			if err == nil {
+				printInfo(fmt.Sprintf("EFI variable %s current value: %s, updating to: %s (MAC: %s)",
+					config.EfiMacName, existingMAC, hexMAC, flashData.MAC))
+			} else {
+				printInfo(fmt.Sprintf("EFI variable %s does not exist, creating with value: %s (MAC: %s)",
+					config.EfiMacName, hexMAC, flashData.MAC))
+			}
+
+			err := setEFIVariable(manager, config.EfiMacName, hexMAC, config.EFIVarEncoding, backup, efiReadBackTimeout(config))
+			if err != nil {
+				recordAudit("set_efi_var", config.EfiMacName, "FAILED", err.Error())
+				return false, false, fmt.Errorf("failed to set MAC EFI variable: %v", err)
+			}
+			recordAudit("set_efi_var", config.EfiMacName, "PASSED", fmt.Sprintf("%s -> %s", existingMAC, hexMAC))
+			anyChanges = true
+			// MAC не требует перезагрузки, serialChanged остается прежним
+		}
+	}
+
+	if anyChanges {
+		printSuccess("EFI variables updated successfully")
+	} else {
+		printSuccess("All EFI variables already have correct values - no changes needed")
+	}
+
+	return anyChanges, serialChanged, nil
+}
+
+// dumpFRUBinary сохраняет бинарное содержимое FRU устройства в файл
+func dumpFRUBinary(deviceID uint8, outputPath string) error {
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return fmt.Errorf("failed to create dump directory: %v", err)
+	}
+
+	cmd := exec.Command("ipmitool", "fru", "read", strconv.Itoa(int(deviceID)), outputPath)
+	if output, err := tracedCombinedOutput(cmd); err != nil {
+		return fmt.Errorf("ipmitool fru read failed: %v\nOutput: %s", err, string(output))
+	}
+
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		return fmt.Errorf("FRU dump %s is empty or missing", outputPath)
+	}
+	return nil
+}
+
+var fruBeforeDumpRegex = regexp.MustCompile(`\S+_fru_before_\S+?\.bin`)
+
+// findFRUBackup ищет дамп FRU "до прошивки" для сессии: сначала в результатах прошивки,
+// затем рядом с YAML по серийнику платы
+func findFRUBackup(log SessionLog, logPath string) (string, error) {
+	for _, r := range log.FlashResults {
+		if r.Operation != "fru" {
+			continue
+		}
+		if path := fruBeforeDumpRegex.FindString(r.Details); path != "" {
+			if _, err := os.Stat(path); err == nil {
+				return path, nil
+			}
+			// Путь в логе относительный к каталогу запуска - пробуем рядом с YAML
+			if candidate := filepath.Join(filepath.Dir(logPath), filepath.Base(path)); fileExists(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+
+	if log.System.MBSerial != "" {
+		pattern := filepath.Join(filepath.Dir(logPath), sanitizeFileName(log.System.MBSerial)+"_fru_before_*.bin")
+		if matches, _ := filepath.Glob(pattern); len(matches) > 0 {
+			sort.Strings(matches)
+			return matches[len(matches)-1], nil
+		}
+	}
+
+	return "", fmt.Errorf("no FRU backup (_fru_before_) found for session %s", log.SessionID)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// runFRURollback восстанавливает FRU из дампа, сделанного перед прошивкой в указанной сессии
+func runFRURollback(logPath string) error {
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		return fmt.Errorf("failed to read session log: %v", err)
+	}
+
+	var log SessionLog
+	if err := yaml.Unmarshal(data, &log); err != nil {
+		return fmt.Errorf("failed to parse session log: %v", err)
+	}
+
+	backup, err := findFRUBackup(log, logPath)
+	if err != nil {
+		return err
+	}
+
+	printSubHeader("FRU ROLLBACK", fmt.Sprintf("Session %s | Board %s", log.SessionID, log.System.MBSerial))
+	printInfo(fmt.Sprintf("Backup file: %s", backup))
+
+	if isInteractive() {
+		fmt.Printf("Write this backup to FRU device 0? %s[y/N]%s: ", ColorGreen, ColorReset)
+		input, _ := bufio.NewReader(operatorInput()).ReadString('\n')
+		if answer := strings.ToLower(strings.TrimSpace(input)); answer != "y" && answer != "yes" {
+			printInfo("FRU rollback cancelled by operator")
+			return nil
+		}
+	}
+
+	auditSession.SessionID = log.SessionID
+	auditSession.LogDir = filepath.Dir(logPath)
+
+	if err := flashFRUFile(backup); err != nil {
+		recordAudit("flash_fru", backup, "FAILED", "rollback: "+err.Error())
+		return err
+	}
+	recordAudit("flash_fru", backup, "PASSED", "rollback")
+	printSuccess("FRU restored from backup")
+	return nil
+}
+
+// Модифицированная функция flashFRU с возвращением информации об изменении серийного номера
+// dumps - пути к бинарным дампам FRU до и после прошивки (для -rollback-fru)
+func flashFRU(systemConfig SystemConfig, serialNumber, logDir string) (changed bool, dumps []string, err error) {
+	defer func() {
+		switch {
+		case err != nil:
+			recordAudit("flash_fru", serialNumber, "FAILED", err.Error())
+		case changed:
+			recordAudit("flash_fru", serialNumber, "PASSED", "")
+		default:
+			recordAudit("flash_fru", serialNumber, "SKIPPED", "")
+		}
+	}()
+
+	// Проверяем существующий серийный номер в FRU (НЕ в dmidecode!)
+	currentSerial, err := getCurrentFRUSerial()
+	if err == nil && currentSerial == serialNumber {
+		printInfo(fmt.Sprintf("FRU already contains target serial number: %s - skipping FRU flashing", serialNumber))
+		return false, dumps, nil // Серийный номер не изменился
+	}
+
+	if err == nil {
+		printInfo(fmt.Sprintf("Current FRU serial: %s, updating to: %s", currentSerial, serialNumber))
+	} else {
+		printInfo(fmt.Sprintf("Could not read current FRU serial (%v), proceeding with FRU flash to: %s", err, serialNumber))
+	}
+
+	printSubHeader("FRU CHIP FLASHING", fmt.Sprintf("Target Serial: %s | Manufacturer: %s", serialNumber, systemConfig.Manufacturer))
+
+	// Step 1: Check current FRU status
+	status, err := checkFRUStatus()
+	if err != nil {
+		return false, dumps, fmt.Errorf("failed to check FRU status: %v", err)
+	}
+
+	// Дамп текущего содержимого FRU для возможного отката
+	timestamp := time.Now().Format("20060102_150405")
+	beforePath := filepath.Join(logDir, fmt.Sprintf("%s_fru_before_%s.bin", sanitizeFileName(serialNumber), timestamp))
+	if err := dumpFRUBinary(0, beforePath); err != nil {
+		printWarning(fmt.Sprintf("Could not dump FRU before flashing (rollback will not be possible): %v", err))
+	} else {
+		printInfo(fmt.Sprintf("FRU backup saved: %s", beforePath))
+		dumps = append(dumps, beforePath)
+	}
+
+	// Step 2: If FRU header is bad or chip is empty, flash blank first
+	if len(status.BadAreas) > 0 && !status.needsBlankFlash() {
+		printInfo(fmt.Sprintf("FRU %s area checksum bad - area will be rebuilt by FRU write, blank flash not needed",
+			strings.Join(status.BadAreas, ", ")))
+	}
+
+	if status.needsBlankFlash() {
+		if status.HeaderBadSum {
+			printInfo("FRU common header checksum is bad - initializing with blank data...")
+		} else if status.HasBadSum && status.IsEmpty {
+			printInfo("FRU has corrupted header - initializing with blank data...")
+		} else if status.HasBadSum {
+			printInfo("FRU has bad checksum - clearing with blank data...")
+		} else if status.IsEmpty {
+			printInfo("FRU is empty - initializing with blank data...")
+		} else {
+			printInfo("FRU is unreadable - clearing with blank data...")
+		}
+
+		blankFile, err := createFRUBlankFile()
+		if err != nil {
+			return false, dumps, fmt.Errorf("failed to create blank FRU file: %v", err)
+		}
+		defer os.Remove(blankFile)
+
+		printInfo(fmt.Sprintf("Flashing %d-byte null file to clear FRU...", fruBlankSize))
+		if err := flashFRUFile(blankFile); err != nil {
+			return false, dumps, fmt.Errorf("failed to flash blank FRU: %v", err)
+		}
+
+		printSuccess("Blank FRU flash completed")
+
+		// Wait for FRU to be ready after blank flash
+		printInfo("Waiting for FRU to stabilize...")
+		waitForFRUReady(10 * time.Second)
+	}
+
+	// Step 3: Generate and flash FRU with retries
+	attempts := 0
+	maxAttempts := 3
+	var lastError error
+
+	for attempts < maxAttempts {
+		attempts++
+		printInfo(fmt.Sprintf("FRU generation and flashing attempt %d/%d...", attempts, maxAttempts))
+
+		// Generate FRU file
+		fruFile, err := generateFRUFile(systemConfig, serialNumber)
+		if err != nil {
+			lastError = fmt.Errorf("FRU generation failed: %v", err)
+			printError(lastError.Error())
+		} else {
+			defer os.Remove(fruFile)
+
+			// Flash FRU file
+			if err := flashFRUFile(fruFile); err != nil {
+				lastError = fmt.Errorf("FRU flashing failed: %v", err)
+				printError(lastError.Error())
+			} else {
+				// Verify FRU data
+				if err := verifyFRUData(systemConfig.Manufacturer, systemConfig.Product, serialNumber); err != nil {
+					lastError = fmt.Errorf("FRU verification failed: %v", err)
+					printError(lastError.Error())
+				} else {
+					// Success!
+					printSuccess("FRU flashing completed successfully")
+					afterPath := filepath.Join(logDir, fmt.Sprintf("%s_fru_after_%s.bin", sanitizeFileName(serialNumber), timestamp))
+					if err := dumpFRUBinary(0, afterPath); err != nil {
+						printWarning(fmt.Sprintf("Could not dump FRU after flashing: %v", err))
+					} else {
+						dumps = append(dumps, afterPath)
+					}
+					return true, dumps, nil // Серийный номер был изменен!
+				}
+			}
+		}
+
+		// If we failed and have more attempts, ask user what to do
+		if attempts < maxAttempts {
+			action := askFRURetryAction(fmt.Sprintf("FRU flashing failed (attempt %d/%d): %v", attempts, maxAttempts, lastError))
+			switch action {
+			case "SKIP":
+				printWarning("FRU flashing skipped by operator")
+				return false, dumps, nil
+			case "ABORT":
+				return false, dumps, fmt.Errorf("FRU flashing aborted by operator")
+			case "RETRY":
+				printInfo("Retrying FRU flashing...")
+				continue
+			}
+		}
+	}
+
+	// All attempts failed
+	return false, dumps, fmt.Errorf("FRU flashing failed after %d attempts: %v", maxAttempts, lastError)
+}
+
+func findBootDevice() (string, error) {
+	output, err := runCommand("findmnt", "/", "-o", "SOURCE", "-n")
+	if err != nil {
+		return "", fmt.Errorf("findmnt failed: %v", err)
+	}
+	output = strings.TrimSpace(output)
+	loopRegex := regexp.MustCompile(`^/dev/loop[0-9]+$`)
+	if output == "airootfs" || loopRegex.MatchString(output) {
+		// If running from ArchISO, check if /run/archiso/bootmnt is mounted
+		bootMntSource, err := runCommand("findmnt", "/run/archiso/bootmnt", "-o", "SOURCE", "-n")
+		if err == nil && bootMntSource != "" {
+			bootMntSource = strings.TrimSpace(bootMntSource)
+			printDebug(fmt.Sprintf("Found archiso boot mount: %s", bootMntSource))
+
+			// Extract the disk device from the partition (e.g. /dev/sda1 -> /dev/sda)
+			if strings.Contains(bootMntSource, "nvme") {
+				// For NVMe devices: /dev/nvme0n1p1 -> /dev/nvme0n1
+				devRegex := regexp.MustCompile(`p[0-9]+$`)
+				return devRegex.ReplaceAllString(bootMntSource, ""), nil
+			} else {
+				// For other devices: /dev/sda1 -> /dev/sda
+				devRegex := regexp.MustCompile(`[0-9]+$`)
+				return devRegex.ReplaceAllString(bootMntSource, ""), nil
+			}
+		}
+		return "LOOP", nil
+	}
+	// For NVMe devices, name looks like "/dev/nvme0n1p1" - parent disk: "/dev/nvme0n1"
+	if strings.Contains(output, "nvme") {
+		devRegex := regexp.MustCompile(`p[0-9]+$`)
+		return devRegex.ReplaceAllString(output, ""), nil
+	}
+	// For other devices, e.g. "/dev/sda2" - parent disk: "/dev/sda"
+	devRegex := regexp.MustCompile(`[0-9]+$`)
+	return devRegex.ReplaceAllString(output, ""), nil
+}
+
+// exitLiveEnvironmentRequired - код выхода при попытке прошивки из установленной ОС
+const exitLiveEnvironmentRequired = 5
+
+// RuntimeEnvironment - результат определения среды запуска
+type RuntimeEnvironment struct {
+	Live       bool   `yaml:"live"`
+	RootSource string `yaml:"root_source"`
+	BootDevice string `yaml:"boot_device,omitempty"`
+	DetectedBy string `yaml:"detected_by,omitempty"` // airootfs, loop, marker
+}
+
+// detectRuntimeEnvironment определяет, запущены ли мы из live образа (airootfs / loop root или файл-маркер)
+func detectRuntimeEnvironment(markerPath string) *RuntimeEnvironment {
+	env := &RuntimeEnvironment{}
+
+	if source, err := runCommand("findmnt", "/", "-o", "SOURCE", "-n"); err == nil {
+		env.RootSource = strings.TrimSpace(source)
+	} else {
+		env.RootSource = "unknown"
+	}
+
+	switch {
+	case env.RootSource == "airootfs":
+		env.Live, env.DetectedBy = true, "airootfs"
+	case regexp.MustCompile(`^/dev/loop[0-9]+$`).MatchString(env.RootSource):
+		env.Live, env.DetectedBy = true, "loop"
+	case markerPath != "":
+		if _, err := os.Stat(markerPath); err == nil {
+			env.Live, env.DetectedBy = true, "marker"
+		}
+	}
+
+	if dev, err := findBootDevice(); err == nil {
+		env.BootDevice = dev
+	}
+
+	return env
+}
+
+// printLiveEnvironmentBanner - заметное предупреждение о запуске из установленной ОС
+func printLiveEnvironmentBanner(env *RuntimeEnvironment) {
+	line := strings.Repeat(" ", 80)
+	fmt.Printf("\n%s%s%s\n", ColorBgRed, line, ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, "  !!! NOT RUNNING FROM LIVE IMAGE - FLASHING IS FORBIDDEN !!!", ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, fmt.Sprintf("  Root filesystem: %s", env.RootSource), ColorReset)
+	fmt.Printf("%s%-80s%s\n", ColorBgRed, "  This looks like an installed OS. Boot the provisioning image and retry.", ColorReset)
+	fmt.Printf("%s%s%s\n\n", ColorBgRed, line, ColorReset)
+}
+
+func listRealDisks() ([]string, error) {
+	output, err := runCommand("lsblk", "-d", "-o", "NAME,TYPE", "-rn")
+	if err != nil {
+		return nil, fmt.Errorf("lsblk failed: %v", err)
+	}
+	var disks []string
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == "disk" {
+			disks = append(disks, "/dev/"+fields[0])
+		}
+	}
+	return disks, nil
+}
+
+func isEfiPartition(part string) bool {
+	output, err := runCommand("blkid", "-o", "export", part)
+	if err != nil {
+		return false
+	}
+	lines := strings.Split(output, "\n")
+	for _, line := range lines {
+		if matched, _ := regexp.MatchString(`^TYPE=(fat|vfat|msdos)`, line); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Improved function to find external EFI partition with prioritization for the boot device
+func findExternalEfiPartition(bootDev string) (string, string, error) {
+	disks, err := listRealDisks()
+	if err != nil {
+		return "", "", err
+	}
+
+	printDebug(fmt.Sprintf("All disks: %v", disks))
+	printDebug(fmt.Sprintf("Boot device: %s", bootDev))
+
+	// Check if we're running from ArchISO/live environment
+
+	// Check what device /run/archiso/bootmnt is mounted on (if we're in a live environment)
+	var archisoDev string
+	if bootMntSource, err := runCommand("findmnt", "/run/archiso/bootmnt", "-o", "SOURCE", "-n"); err == nil && bootMntSource != "" {
+		bootMntSource = strings.TrimSpace(bootMntSource)
+		printDebug(fmt.Sprintf("Found archiso boot mount: %s", bootMntSource))
+
+		// Extract the disk device from the partition (e.g. /dev/sda1 -> /dev/sda)
+		if strings.Contains(bootMntSource, "nvme") {
+			// For NVMe devices: /dev/nvme0n1p1 -> /dev/nvme0n1
+			devRegex := regexp.MustCompile(`p[0-9]+$`)
+			archisoDev = devRegex.ReplaceAllString(bootMntSource, "")
+		} else {
+			// For other devices: /dev/sda1 -> /dev/sda
+			devRegex := regexp.MustCompile(`[0-9]+$`)
+			archisoDev = devRegex.ReplaceAllString(bootMntSource, "")
+		}
+		printDebug(fmt.Sprintf("Extracted archiso device: %s", archisoDev))
+	}
+
+	// First check for EFI partitions on the boot device itself (if we're booting from ArchISO)
+	var bootDevEfiPartitions []struct {
+		disk      string
+		partition string
+	}
+
+	var otherEfiPartitions []struct {
+		disk      string
+		partition string
+	}
+
+	// First pass - collect all EFI partitions and separate them into boot device partitions and others
+	for _, dev := range disks {
+		// Determine if this disk is our boot device
+		isBootDevice := dev == bootDev || dev == archisoDev
+
+		printDebug(fmt.Sprintf("Checking disk: %s for partitions (boot device: %v)", dev, isBootDevice))
+
+		// Get all partitions for this disk
+		output, err := runCommand("lsblk", "-nlo", "NAME", dev)
+		if err != nil {
+			printDebug(fmt.Sprintf("Error listing partitions for %s: %v", dev, err))
+			continue
+		}
+
+		partitions := strings.Split(output, "\n")
+		for _, part := range partitions {
+			part = strings.TrimSpace(part)
+
+			// Skip the disk itself from lsblk output
+			if part == filepath.Base(dev) {
+				continue
+			}
+
+			// Construct full path to partition
+			partPath := "/dev/" + part
+			printDebug(fmt.Sprintf("Checking partition: %s", partPath))
+
+			// Skip if it's the same as disk device
+			if partPath == dev {
+				printDebug(fmt.Sprintf("Skipping partition %s as it's the same as disk device", partPath))
+				continue
+			}
+
+			if isEfiPartition(partPath) {
+				printDebug(fmt.Sprintf("Found EFI partition: %s on disk: %s", partPath, dev))
+
+				// Add to appropriate list based on whether it's on the boot device
+				if isBootDevice {
+					bootDevEfiPartitions = append(bootDevEfiPartitions, struct {
+						disk      string
+						partition string
+					}{dev, partPath})
+				} else {
+					otherEfiPartitions = append(otherEfiPartitions, struct {
+						disk      string
+						partition string
+					}{dev, partPath})
+				}
+			}
+		}
+	}
+
+	// First try EFI partitions on the boot device (if any)
+	if len(bootDevEfiPartitions) > 0 {
+		if len(bootDevEfiPartitions) > 1 {
+			printDebug(fmt.Sprintf("Multiple EFI partitions found on boot device. Using the first one."))
+			for i, part := range bootDevEfiPartitions {
+				printDebug(fmt.Sprintf("Boot device EFI partition %d: disk=%s, partition=%s", i+1, part.disk, part.partition))
+			}
+		}
+		printDebug(fmt.Sprintf("Selected EFI partition on boot device: %s", bootDevEfiPartitions[0].partition))
+		return bootDevEfiPartitions[0].disk, bootDevEfiPartitions[0].partition, nil
+	}
+
+	// If no EFI partitions found on boot device, fall back to other disks
+	if len(otherEfiPartitions) > 0 {
+		if len(otherEfiPartitions) > 1 {
+			printDebug(fmt.Sprintf("Multiple EFI partitions found on other devices. Using the first one."))
+			for i, part := range otherEfiPartitions {
+				printDebug(fmt.Sprintf("Other device EFI partition %d: disk=%s, partition=%s", i+1, part.disk, part.partition))
+			}
+		}
+		printDebug(fmt.Sprintf("Selected EFI partition on non-boot device: %s", otherEfiPartitions[0].partition))
+		return otherEfiPartitions[0].disk, otherEfiPartitions[0].partition, nil
+	}
+
+	// If we get here, no EFI partition was found
+	return "", "", errors.New("no EFI partition found on any disk")
+}
+
+// getEFIVariable читает существующую EFI переменную
+func getEFIVariable(m *EFIVarManager, varName, encoding string) (string, error) {
+	data, err := m.Get(varName)
+	if err != nil {
+		return "", err // Переменная не существует или не читается
+	}
+	return decodeEFIValue(data, encoding)
+}
+
+// bootctl mounts external EFI partition, copies contents of efishell directory (ctefi)
+// and sets one-time boot entry (via setOneTimeBoot). Do not change this function!
+func bootctl() error {
+	// Determine boot device
+	bootDev, err := findBootDevice()
+	if err != nil {
+		return fmt.Errorf("Could not determine boot device: %v", err)
+	}
+
+	printDebug(fmt.Sprintf("Detected boot device: %s", bootDev))
+
+	// Find external EFI partition
+	targetDevice, targetEfi, err := findExternalEfiPartition(bootDev)
+	if err != nil || targetDevice == "" || targetEfi == "" {
+		return errors.New("No external EFI partition found")
+	}
+
+	// Additional check to ensure targetEfi is a partition, not the whole disk
+	if targetEfi == targetDevice {
+		return fmt.Errorf("targetEfi cannot be the same as targetDevice: %s", targetEfi)
+	}
+
+	printDebug("targetDevice: " + targetDevice)
+	printDebug("targetEFI: " + targetEfi)
+
+	// No need to mount and copy files, as all necessary information is in EFI variables
+	printDebug("Using EFI variables instead of copying files to EFI partition")
+
+	// Call setOneTimeBoot function to create new entry and set BootNext
+	if err := setOneTimeBoot(targetDevice, targetEfi); err != nil {
+		return fmt.Errorf("setOneTimeBoot error: %v", err)
+	}
+
+	if err = runCommandNoOutput("bootctl", "set-oneshot", "03-efishell.conf"); err != nil {
+		printError("Failed to set one-time boot entry: " + err.Error())
+		exitSession(1)
+	} else {
+		printDebug("One-time boot entry set successfully.")
+	}
+
+	return nil
+}
+
+// Одноразовая загрузочная запись (system.efi_shell_path / system.efi_boot_entry_label)
+const (
+	defaultEFIShellPath      = "\\EFI\\BOOT\\shellx64.efi -delay:0"
+	defaultEFIBootEntryLabel = "OneTimeBoot"
+)
+
+var (
+	efiShellPath      = defaultEFIShellPath
+	efiBootEntryLabel = defaultEFIBootEntryLabel
+)
+
+// oneTimeBootRegex - строки efibootmgr с нашей меткой: номер записи, флаг активности, путь
+func oneTimeBootRegex() *regexp.Regexp {
+	// Use the regular expression that should not be changed - DO NOT TOUCH!
+	// Меняется только метка (system.efi_boot_entry_label), по умолчанию выражение прежнее
+	return regexp.MustCompile(`(?im)^Boot([0-9A-Fa-f]{4})(\*?)\s+` + regexp.QuoteMeta(efiBootEntryLabel) + `\t(.+)$`)
+}
+
+// setOneTimeBoot creates a new one-time boot entry and sets BootNext
+func setOneTimeBoot(targetDevice, targetEfi string) error {
+	printDebug(fmt.Sprintf("setOneTimeBoot: targetDevice=%s, targetEfi=%s", targetDevice, targetEfi))
+
+	re := oneTimeBootRegex()
+
+	// Check if there are conflicting entries
+	out, err := runCommand("efibootmgr")
+	if err != nil {
+		return fmt.Errorf("efibootmgr failed: %v", err)
+	}
+
+	// Find only entries that conflict (have the same boot path)
+	matches := re.FindAllStringSubmatch(out, -1)
+
+	// Define the boot path for our new entry
+	targetBootPath := efiShellPath
+
+	// Determine partition number for the new device
+	var partition string
+
+	// Extract the partition number from targetEfi path
+	if strings.Contains(targetDevice, "nvme") {
+		// For NVMe devices, name looks like "/dev/nvme0n1p1" - parent disk: "/dev/nvme0n1"
+		// Verify that targetEfi has format like /dev/nvme0n1p1
+		nvmePartRegex := regexp.MustCompile(`^(/dev/nvme[0-9]+n[0-9]+)p([0-9]+)$`)
+		matches := nvmePartRegex.FindStringSubmatch(targetEfi)
+		if len(matches) == 3 {
+			printDebug(fmt.Sprintf("NVMe partition identified: disk=%s, partition=%s", matches[1], matches[2]))
+			// Check if targetDevice matches the disk part
+			if matches[1] != targetDevice {
+				printDebug(fmt.Sprintf("Warning: Extracted disk %s doesn't match targetDevice %s", matches[1], targetDevice))
+				// Use the matched disk as targetDevice for consistency
+				targetDevice = matches[1]
+			}
+			partition = matches[2]
+		} else {
+			return fmt.Errorf("invalid NVMe partition format: %s", targetEfi)
+		}
+	} else {
+		// For other devices, e.g. "/dev/sda1" - parent disk: "/dev/sda"
+		stdPartRegex := regexp.MustCompile(`^(/dev/[a-z]+)([0-9]+)$`)
+		matches := stdPartRegex.FindStringSubmatch(targetEfi)
+		if len(matches) == 3 {
+			printDebug(fmt.Sprintf("Standard partition identified: disk=%s, partition=%s", matches[1], matches[2]))
+			// Check if targetDevice matches the disk part
+			if matches[1] != targetDevice {
+				printDebug(fmt.Sprintf("Warning: Extracted disk %s doesn't match targetDevice %s", matches[1], targetDevice))
+				// Use the matched disk as targetDevice for consistency
+				targetDevice = matches[1]
+			}
+			partition = matches[2]
+		} else {
+			return fmt.Errorf("invalid partition format: %s", targetEfi)
+		}
+	}
+
+	if partition == "" {
+		return fmt.Errorf("could not determine partition number from targetEfi: %s", targetEfi)
+	}
+
+	printDebug(fmt.Sprintf("Using disk device: %s, partition: %s", targetDevice, partition))
+
+	// Remove only entries that conflict with our target entry
+	for _, match := range matches {
+		bootNum := match[1]
+
+		// Get more detailed info about the entry
+		bootInfo, err := runCommand("efibootmgr", "-v", "-b", bootNum)
+		if err != nil {
+			printDebug(fmt.Sprintf("[WARNING] Failed to get info for Boot%s: %v", bootNum, err))
+			continue
+		}
+
+		// Check if the entry contains the same boot path
+		if strings.Contains(bootInfo, targetBootPath) {
+			printDebug("[INFO] Removing conflicting OneTimeBoot entry: Boot" + bootNum)
+			if err := runCommandNoOutput("efibootmgr", "-B", "-b", bootNum); err != nil {
+				printDebug(fmt.Sprintf("[WARNING] Failed to remove Boot%s: %v", bootNum, err))
+			}
+		} else {
+			printDebug("[INFO] Keeping non-conflicting OneTimeBoot entry: Boot" + bootNum)
+		}
+	}
+
+	printDebug("targetDevice: " + targetDevice)
+	printDebug("Partition: " + partition)
+
+	printDebug("[INFO] Creating new OneTimeBoot entry")
+	// Create a new entry without displaying command result
+	createCmd := exec.Command("efibootmgr",
+		"-c",
+		"-d", targetDevice,
+		"-p", partition,
+		"-L", efiBootEntryLabel,
+		"-l", targetBootPath)
+	// Hide efibootmgr output, keep only debug messages
+	var createOut bytes.Buffer
+	createCmd.Stdout = &createOut
+	createCmd.Stderr = &createOut
+	if err := tracedRun(createCmd); err != nil {
+		printDebug("[ERROR] efibootmgr create output: " + createOut.String())
+		return fmt.Errorf("failed to create new boot entry: %v", err)
+	}
+
+	// Find the created entry with OneTimeBoot label
+	out, err = runCommand("efibootmgr", "-v")
+	if err != nil {
+		return fmt.Errorf("efibootmgr failed after creation: %v", err)
+	}
+	matches = re.FindAllStringSubmatch(out, -1)
+	if len(matches) == 0 {
+		return errors.New("new OneTimeBoot entry not found after creation")
+	}
+
+	// Find our new entry - it should be the last created with this label
+	var bootNum string
+	for _, match := range matches {
+		candidateBootNum := match[1]
+		// Между созданием записи и -n состояние не меняется - повторные запросы берем из кэша
+		output, err := sysRunner.Run("efibootmgr", "-v", "-b", candidateBootNum)
+		bootInfo := string(output)
+		if err == nil && strings.Contains(bootInfo, targetBootPath) &&
+			strings.Contains(bootInfo, targetDevice) {
+			bootNum = candidateBootNum
+			break
+		}
+	}
+
+	if bootNum == "" {
+		// If we didn't find an exact match, use the last entry
+		bootNum = matches[len(matches)-1][1]
+	}
+
+	printDebug("[INFO] New OneTimeBoot entry created: Boot" + bootNum)
+
+	// Set BootNext to the created entry
+	if err := runCommandNoOutput("efibootmgr", "-n", bootNum); err != nil {
+		out2, err2 := runCommand("efibootmgr", "-v")
+		if err2 == nil && strings.Contains(out2, "BootNext: "+bootNum) {
+			printDebug("BootNext is already set to Boot" + bootNum)
+			return nil
+		}
+		return fmt.Errorf("failed to set BootNext to %s: %v", bootNum, err)
+	}
+
+	out3, err3 := runCommand("efibootmgr", "-v")
+	if err3 == nil && strings.Contains(out3, "BootNext: "+bootNum) {
+		printDebug("BootNext is set to Boot" + bootNum)
+		return nil
+	}
+
+	return fmt.Errorf("failed to verify BootNext setting for Boot%s", bootNum)
+}
+
+// isPostFlashFailure - упавшая проверка после прошивки всегда валит сессию, даже если не required
+func isPostFlashFailure(result TestResult) bool {
+	return result.Phase == "post-flash" && (result.Status == "FAILED" || result.Status == "TIMEOUT")
+}
+
+// calculateSessionState определяет общий статус сессии на основе результатов тестов и прошивки.
+// "blocked" - required тест упал и прошивка не выполнялась (отличается от обычного "failed"),
+// "precheck_failed" - прошивку не начинали из-за настроек BIOS/ядра.
+func calculateSessionState(testResults []TestResult, flashResults []FlashResult) string {
+	if flashBlockedReason(flashResults) != "" {
+		return "blocked"
+	}
+	for _, fr := range flashResults {
+		if fr.Status == "SKIPPED" && strings.HasPrefix(fr.Details, flashPrecheckPrefix) {
+			return "precheck_failed"
+		}
+	}
+
+	// Проверяем критические тесты
+	for _, result := range testResults {
+		if result.Required && (result.Status == "FAILED" || result.Status == "TIMEOUT") {
+			return "failed"
+		}
+		if isPostFlashFailure(result) {
+			return "failed"
+		}
+	}
+
+	// Проверяем результаты прошивки
+	for _, flashResult := range flashResults {
+		if flashResult.Status == "FAILED" {
+			return "failed"
+		}
+	}
+
+	return "pass"
+}
+
+// requiredTestFailure возвращает имя первого упавшего required теста (проверки после прошивки не учитываются)
+func requiredTestFailure(results []TestResult) string {
+	for _, r := range results {
+		if r.Required && r.Phase != "post-flash" && (r.Status == "FAILED" || r.Status == "TIMEOUT") {
+			return r.Name
+		}
+	}
+	return ""
+}
+
+// flashBlockedReason возвращает причину блокировки прошивки из результатов (пусто - не блокировалась)
+func flashBlockedReason(flashResults []FlashResult) string {
+	for _, fr := range flashResults {
+		if fr.Status == "SKIPPED" && strings.HasPrefix(fr.Details, flashBlockedPrefix) {
+			return fr.Details
+		}
+	}
+	return ""
+}
+
+// saveLog пишет YAML сессии в log_dir и возвращает путь к нему
+func saveLog(log SessionLog, config LogConfig) (string, error) {
+	if !config.SaveLocal {
+		return "", nil
+	}
+
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+
+	// Create log directory
+	err := os.MkdirAll(logDir, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	// Generate filename with state
+	filepath := filepath.Join(logDir, sessionLogFileName(log))
+
+	// Marshal to YAML
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal log: %v", err)
+	}
+
+	// Write to file
+	err = os.WriteFile(filepath, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write log file: %v", err)
+	}
+
+	printSuccess(fmt.Sprintf("Log saved: %s", filepath))
+	return filepath, nil
+}
+
+// Continuation - сессия, ожидающая post_reboot_groups после перезагрузки со сменой серийного номера.
+// Содержит лог сессии в формате session YAML и путь к уже сохраненному логу (заменяется обновленным).
+type Continuation struct {
+	CreatedAt  time.Time  `yaml:"created_at"`
+	Serial     string     `yaml:"serial"`
+	ConfigPath string     `yaml:"config"`
+	SavedLog   string     `yaml:"saved_log,omitempty"`
+	Session    SessionLog `yaml:"session"`
+}
+
+// continuationPath - файл продолжения для платы: <log_dir>/continuation/<serial>.yaml
+func continuationPath(config LogConfig, serial string) string {
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+	return filepath.Join(logDir, "continuation", sanitizeFileName(serial)+".yaml")
+}
+
+func continuationMaxAge(config TestsConfig) time.Duration {
+	if config.ContinuationMaxAge != "" {
+		if d, err := time.ParseDuration(config.ContinuationMaxAge); err == nil && d > 0 {
+			return d
+		}
+		printWarning(fmt.Sprintf("Invalid continuation_max_age %q, using 24h", config.ContinuationMaxAge))
+	}
+	return 24 * time.Hour
+}
+
+// writeContinuation сохраняет продолжение сессии перед перезагрузкой
+func writeContinuation(config LogConfig, c Continuation) (string, error) {
+	path := continuationPath(config, c.Serial)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create continuation directory: %v", err)
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal continuation: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write continuation: %v", err)
+	}
+	return path, nil
+}
+
+func loadContinuation(path string) (*Continuation, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Continuation
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse continuation %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// sessionStateFile - частичный лог незавершенной сессии: <log_dir>/session_current.yaml
+func sessionStateFile(config LogConfig) string {
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+	return filepath.Join(logDir, "session_current.yaml")
+}
+
+// saveSessionState атомарно записывает частичный лог сессии (временный файл + rename),
+// чтобы при пропадании питания на диске остался либо старый, либо новый вариант целиком
+func saveSessionState(log SessionLog, stateFile string) error {
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return fmt.Errorf("failed to create session state directory: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(stateFile), ".session_current_*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create session state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write session state: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync session state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write session state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), stateFile); err != nil {
+		return fmt.Errorf("failed to replace session state: %v", err)
+	}
+	return nil
+}
+
+// sessionState - частичный лог текущей сессии, дописываемый после каждого теста.
+// Path пустой - состояние не ведется (save_local выключен, пакетный режим).
+var sessionState struct {
+	sync.Mutex
+	Log  SessionLog
+	Path string
+	keys map[string]int // <группа>/<тест> -> индекс в Log.TestResults
+}
+
+// resumedResults - завершенные тесты прерванной сессии (-resume), которые не запускаются повторно
+var resumedResults map[string]TestResult
+
+// resumedFlash - успешные операции прошивки прерванной сессии (операция -> результат)
+var resumedFlash map[string]FlashResult
+
+// sessionStateKey - ключ теста в частичном логе: отображаемое имя группы и имя теста
+func sessionStateKey(groupName, testName string) string {
+	return groupName + "/" + testName
+}
+
+// startSessionState начинает частичный лог сессии
+func startSessionState(log SessionLog, stateFile string) {
+	sessionState.Lock()
+	defer sessionState.Unlock()
+
+	log.State = "running"
+	sessionState.Log = log
+	sessionState.Path = stateFile
+	sessionState.keys = make(map[string]int)
+	if err := saveSessionState(sessionState.Log, stateFile); err != nil {
+		printWarning(fmt.Sprintf("Session state not saved: %v", err))
+	}
+}
+
+// recordSessionState добавляет (или обновляет после повтора) результат теста в частичном логе и сохраняет его
+func recordSessionState(groupName string, result TestResult) {
+	sessionState.Lock()
+	defer sessionState.Unlock()
+	if sessionState.Path == "" {
+		return
+	}
+
+	// В файл состояния идет имя группы, под которым ее видел оператор (ID группы ставится позже)
+	result.Group = groupName
+	key := sessionStateKey(groupName, result.Name)
+	if i, ok := sessionState.keys[key]; ok {
+		sessionState.Log.TestResults[i] = result
+	} else {
+		sessionState.keys[key] = len(sessionState.Log.TestResults)
+		sessionState.Log.TestResults = append(sessionState.Log.TestResults, result)
+	}
+	if err := saveSessionState(sessionState.Log, sessionState.Path); err != nil {
+		printWarning(fmt.Sprintf("Session state not saved: %v", err))
+	}
+}
+
+// clearSessionState удаляет частичный лог после того, как сохранен полный
+func clearSessionState() {
+	sessionState.Lock()
+	defer sessionState.Unlock()
+	if sessionState.Path == "" {
+		return
+	}
+	if err := os.Remove(sessionState.Path); err != nil && !os.IsNotExist(err) {
+		printWarning(fmt.Sprintf("Failed to remove session state: %v", err))
+	}
+	sessionState.Path = ""
+}
+
+// resumedTestResult возвращает результат теста, завершенного в прерванной сессии (-resume)
+func resumedTestResult(groupName, testName string) (TestResult, bool) {
+	r, ok := resumedResults[sessionStateKey(groupName, testName)]
+	if !ok {
+		return TestResult{}, false
+	}
+	printInfo(fmt.Sprintf("%s: %s in the interrupted session - not repeated", testName, r.Status))
+	outputManager.CountResult(testName, r.Status)
+	publishTestResult(r)
+	recordSessionState(groupName, r)
+	return r, true
+}
+
+// splitResumedFlash отделяет операции, успешно выполненные в прерванной сессии, от оставшихся
+func splitResumedFlash(operations []string) ([]string, []FlashResult) {
+	var pending []string
+	var done []FlashResult
+	for _, op := range operations {
+		if r, ok := resumedFlash[op]; ok {
+			printInfo(fmt.Sprintf("Flash operation %s: PASSED in the interrupted session - not repeated", op))
+			done = append(done, r)
+			continue
+		}
+		pending = append(pending, op)
+	}
+	return pending, done
+}
+
+// recordSessionFlash добавляет результаты прошивки в частичный лог и сохраняет его
+func recordSessionFlash(results []FlashResult) {
+	sessionState.Lock()
+	defer sessionState.Unlock()
+	if sessionState.Path == "" || len(results) == 0 {
+		return
+	}
+	sessionState.Log.FlashResults = append(sessionState.Log.FlashResults, results...)
+	if err := saveSessionState(sessionState.Log, sessionState.Path); err != nil {
+		printWarning(fmt.Sprintf("Session state not saved: %v", err))
+	}
+}
+
+// recordSessionFlashData сохраняет в частичный лог подтвержденные данные прошивки,
+// чтобы -resume не спрашивал их повторно
+func recordSessionFlashData(review *FlashReview, variant *ProductVariant, operators OperatorPair) {
+	sessionState.Lock()
+	defer sessionState.Unlock()
+	if sessionState.Path == "" {
+		return
+	}
+	sessionState.Log.FlashReview = review
+	sessionState.Log.Variant = variant
+	sessionState.Log.Pipeline.Operators = operators
+	if err := saveSessionState(sessionState.Log, sessionState.Path); err != nil {
+		printWarning(fmt.Sprintf("Session state not saved: %v", err))
+	}
+}
+
+// resumedFlashData восстанавливает данные прошивки прерванной сессии; nil - данные не вводились или ввод прерван
+func resumedFlashData(interrupted SessionLog) *FlashData {
+	review := interrupted.FlashReview
+	if review == nil || review.Aborted || len(review.Confirmed) == 0 {
+		return nil
+	}
+	flashData := buildFlashData(review.Confirmed)
+	flashData.Review = review
+	flashData.Variant = interrupted.Variant
+	return flashData
+}
+
+// flashSerialChanged - изменила ли одна из операций серийный номер (нужна перезагрузка)
+func flashSerialChanged(results []FlashResult) bool {
+	for _, r := range results {
+		if r.SerialChanged {
+			return true
+		}
+	}
+	return false
+}
+
+// firstPendingTest - номер (с 1, по порядку плана) и имя первого теста, не завершенного в прерванной сессии
+func firstPendingTest(plan []PipelineStep, groups []testGroupRef) (int, string) {
+	n := 0
+	for _, step := range plan {
+		if step.Kind != "tests" {
+			continue
+		}
+		for _, g := range selectTestGroups(groups, step.Target) {
+			for _, t := range g.Tests {
+				n++
+				if _, ok := resumedResults[sessionStateKey(g.Name, t.Name)]; !ok {
+					return n, t.Name
+				}
+			}
+		}
+	}
+	return 0, ""
+}
+
+// resumedFrom - ID прерванной сессии для pipeline.resumed_from
+func resumedFrom(interrupted *SessionLog) string {
+	if interrupted == nil {
+		return ""
+	}
+	return interrupted.SessionID
+}
+
+// loadSessionState читает частичный лог незавершенной сессии
+func loadSessionState(stateFile string) (*SessionLog, error) {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	var log SessionLog
+	if err := yaml.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to parse session state %s: %v", stateFile, err)
+	}
+	return &log, nil
+}
+
+// postRebootGroups - группы tests.post_reboot_groups (выполняются последовательно)
+func postRebootGroups(tests TestsConfig) []testGroupRef {
+	var groups []testGroupRef
+	for i, g := range tests.PostRebootGroups {
+		id := fmt.Sprintf("post-reboot%d", i+1)
+		groups = append(groups, testGroupRef{
+			ID:    id,
+			Alias: id,
+			Name:  fmt.Sprintf("Post-Reboot Group %d", i+1),
+			Tests: g,
+		})
+	}
+	return groups
+}
+
+// seedContinuationSystemInfo задает данные для шаблонов args тестов после перезагрузки:
+// данные платы из сохраненной сессии и значения, которые она успешно прошила
+func seedContinuationSystemInfo(session SessionLog) {
+	setTestSystemInfo(session.System)
+	if session.FlashReview != nil {
+		applyFlashedValues(buildFlashData(session.FlashReview.Confirmed), session.FlashResults)
+	}
+}
+
+// runContinueMode находит продолжение для серийного номера платы, выполняет post_reboot_groups
+// и дописывает результаты в исходную сессию (тот же SessionID), после чего лог сохраняется и выгружается заново.
+func runContinueMode(config *Config) int {
+	serial, err := getCurrentFRUSerial()
+	if err != nil {
+		printWarning(fmt.Sprintf("Could not read FRU serial (%v), using SMBIOS board serial", err))
+		info, infoErr := getSystemInfo()
+		if infoErr != nil {
+			printError(fmt.Sprintf("Failed to get system information: %v", infoErr))
+			return 1
+		}
+		serial = info.MBSerial
+	}
+	if serial == "" {
+		printError("Board serial number is empty - cannot match a pending session")
+		return 1
+	}
+
+	path := continuationPath(config.Log, serial)
+	cont, err := loadContinuation(path)
+	if os.IsNotExist(err) {
+		printInfo(fmt.Sprintf("No pending session for board %s - nothing to continue", serial))
+		return 0
+	}
+	if err != nil {
+		printError(err.Error())
+		return 1
+	}
+	if age := time.Since(cont.CreatedAt); age > continuationMaxAge(config.Tests) {
+		printWarning(fmt.Sprintf("Pending session %s for board %s is %s old (limit %s) - ignored, run the full session again",
+			cont.Session.SessionID, serial, age.Round(time.Minute), continuationMaxAge(config.Tests)))
+		return 0
+	}
+
+	sessionLog := cont.Session
+	auditSession.SessionID = sessionLog.SessionID
+	seedContinuationSystemInfo(sessionLog)
+	printSubHeader("CONTINUING SESSION AFTER REBOOT",
+		fmt.Sprintf("Session %s | Board %s | Saved %s", sessionLog.SessionID, serial, cont.CreatedAt.Format("2006-01-02 15:04:05")))
+
+	groups := postRebootGroups(config.Tests)
+	if len(groups) == 0 {
+		printWarning("tests.post_reboot_groups is empty in the current configuration - only the log will be re-sent")
+	}
+
+	start := time.Now()
+	var results []TestResult
+	if len(groups) > 0 {
+		results = runTestsStep(config.Tests, groups, "[post-reboot]")
+		for i := range results {
+			results[i].Phase = "post-reboot"
+		}
+	}
+	duration := time.Since(start)
+
+	var artifacts []string
+	if config.Log.SaveLocal && len(results) > 0 {
+		// Отдельный каталог: вывод тестов до перезагрузки в логе не сохранен, перезаписывать его нельзя
+		postDir := filepath.Join(sessionDir(config.Log, sessionLog.SessionID), "post-reboot")
+		if err := writeTestLogs(results, postDir, config.Log.SplitAttempts); err != nil {
+			printError(fmt.Sprintf("Failed to write test logs: %v", err))
+		} else {
+			for i := range results {
+				if results[i].OutputFile != "" {
+					results[i].OutputFile = filepath.Join("post-reboot", results[i].OutputFile)
+				}
+			}
+			artifacts = append(artifacts, postDir)
+		}
+	}
+
+	sessionLog.TestResults = append(sessionLog.TestResults, results...)
+	sessionLog.State = calculateSessionState(sessionLog.TestResults, sessionLog.FlashResults)
+	sessionLog.Pipeline.Duration += duration
+	sessionLog.Pipeline.Order = append(sessionLog.Pipeline.Order, "tests:post-reboot")
+	sessionLog.Pipeline.PostRebootPending = false
+	sessionLog.Pipeline.Continued = true
+	sessionLog.Upload = nil
+
+	if config.Log.SendLogs && config.Log.Server != "" {
+		check := checkUploadPath(config.Log)
+		sessionLog.Upload = &check
+	}
+
+	savedLog, err := saveLog(sessionLog, config.Log)
+	if err != nil {
+		printError(fmt.Sprintf("Failed to save log: %v", err))
+	} else if cont.SavedLog != "" && savedLog != "" && cont.SavedLog != savedLog {
+		// Состояние сессии изменилось - лог до перезагрузки заменен обновленным
+		if err := os.Remove(cont.SavedLog); err != nil && !os.IsNotExist(err) {
+			printWarning(fmt.Sprintf("Failed to remove superseded log %s: %v", cont.SavedLog, err))
+		}
+	}
+	if config.Log.Manifest {
+		if err := recordManifest(config.Log, sessionLog, savedLog, time.Now()); err != nil {
+			printWarning(fmt.Sprintf("Session not recorded in manifest: %v", err))
+		}
+	}
+	if config.Log.HTMLReport {
+		if reportPath, err := writeHTMLReport(sessionLog, config.Log); err != nil {
+			printError(fmt.Sprintf("Failed to generate HTML report: %v", err))
+		} else {
+			artifacts = append(artifacts, reportPath)
+		}
+	}
+
+	var uploadErr error
+	if config.Log.SendLogs {
+		if uploadErr = sendLogToServer(sessionLog, config.Log, artifacts, nil); uploadErr != nil {
+			printError(fmt.Sprintf("Failed to send log to server: %v", uploadErr))
+		} else if _, err := flushOutbox(config.Log); err != nil {
+			printWarning(fmt.Sprintf("Outbox not flushed: %v", err))
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		printWarning(fmt.Sprintf("Failed to remove continuation file %s: %v", path, err))
+	}
+	recordAudit("continue_session", sessionLog.SessionID, strings.ToUpper(sessionLog.State), fmt.Sprintf("%d post-reboot test(s)", len(results)))
+
+	outputManager.DisableCompact()
+	printExecutionSummary(sessionLog.TestResults, sessionLog.FlashResults, sessionLog.Pipeline.Duration, sessionLog.Upload, uploadErr, sessionLog.SELEvents)
+
+	if sessionLog.State != "pass" {
+		return 1
+	}
+	return 0
+}
+
+var (
+	sessionLogFileRegex = regexp.MustCompile(`^.+_\d{8}_\d{6}_[a-z]+\.yaml$`)
+	sessionDirRegex     = regexp.MustCompile(`^\d+$`)
+	batchSummaryRegex   = regexp.MustCompile(`^batch_\d{8}_\d{6}\.csv$`)
+	flashDumpRegex      = regexp.MustCompile(`_(fru|efi)_.+\.bin$`)
+)
+
+// logDirEntry - единица удаления: файл лога/дампа или каталог сессии целиком
+type logDirEntry struct {
+	path    string
+	size    int64
+	files   int
+	modTime time.Time
+	known   bool // Создан firestarter (иначе посторонний)
+}
+
+// pruneStats - итог очистки для вывода оператору
+type pruneStats struct {
+	Removed int
+	Files   int
+	Bytes   int64
+	Foreign int
+}
+
+// isKnownLogEntry определяет, что запись в log_dir создана firestarter
+func isKnownLogEntry(path string, info os.FileInfo) bool {
+	name := info.Name()
+	if info.IsDir() {
+		// Каталоги сессий (<session id>) и юнитов пакетного режима (<serial>/session.yaml)
+		return sessionDirRegex.MatchString(name) || fileExists(filepath.Join(path, "session.yaml"))
+	}
+	return sessionLogFileRegex.MatchString(name) || batchSummaryRegex.MatchString(name) || flashDumpRegex.MatchString(name) ||
+		manifestFileRegex.MatchString(name)
+}
+
+// scanLogDir собирает записи log_dir, кроме защищенных
+func scanLogDir(logDir string, protected map[string]bool) ([]logDirEntry, int64, error) {
+	items, err := os.ReadDir(logDir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []logDirEntry
+	var total int64
+	for _, item := range items {
+		path := filepath.Join(logDir, item.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue // Удален параллельно - пропускаем
+		}
+
+		entry := logDirEntry{path: path, modTime: info.ModTime(), known: isKnownLogEntry(path, info)}
+		if info.IsDir() {
+			filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+				if err != nil || fi.IsDir() {
+					return nil
+				}
+				entry.size += fi.Size()
+				entry.files++
+				if fi.ModTime().After(entry.modTime) {
+					entry.modTime = fi.ModTime()
+				}
+				return nil
+			})
+		} else {
+			entry.size = info.Size()
+			entry.files = 1
+		}
+
+		total += entry.size
+		if !protected[item.Name()] {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Самые старые - первыми на удаление
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	return entries, total, nil
+}
+
+// pruneLogs применяет политику хранения к log_dir. keep - имена записей текущей сессии.
+func pruneLogs(config LogConfig, now time.Time, keep ...string) (pruneStats, error) {
+	var stats pruneStats
+	policy := config.Retention
+	if policy.MaxTotalMB <= 0 && policy.MaxAgeDays <= 0 {
+		return stats, nil
+	}
+
+	logDir := config.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+
+	// Неотправленные логи, незавершенные сессии и журнал аудита не трогаем никогда
+	protected := map[string]bool{"outbox": true, "audit.log": true, efiMaintenanceLogName: true, macHistoryFileName: true, macHistoryFileName + ".lock": true, serialHistoryFileName: true, serialHistoryFileName + ".lock": true, "continuation": true, "session_current.yaml": true}
+	// Манифест текущего дня еще пополняется
+	today := manifestFileName(now)
+	for _, name := range []string{today, today + ".lock", today + ".uploaded"} {
+		protected[name] = true
+	}
+	for _, name := range keep {
+		if name != "" {
+			protected[filepath.Base(name)] = true
+		}
+	}
+
+	entries, total, err := scanLogDir(logDir, protected)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return stats, fmt.Errorf("failed to scan %s: %v", logDir, err)
+	}
+
+	maxAge := time.Duration(policy.MaxAgeDays) * 24 * time.Hour
+	maxTotal := int64(policy.MaxTotalMB) << 20
+
+	for _, entry := range entries {
+		expired := policy.MaxAgeDays > 0 && now.Sub(entry.modTime) > maxAge
+		oversize := policy.MaxTotalMB > 0 && total > maxTotal
+		if !expired && !oversize {
+			continue
+		}
+		if !entry.known && !policy.Strict {
+			stats.Foreign++
+			continue
+		}
+
+		if err := os.RemoveAll(entry.path); err != nil {
+			printWarning(fmt.Sprintf("Log retention: failed to remove %s: %v", entry.path, err))
+			continue
+		}
+		reason := "size limit"
+		if expired {
+			reason = "older than " + strconv.Itoa(policy.MaxAgeDays) + " days"
+		}
+		printDebug(fmt.Sprintf("Log retention: removed %s (%s)", entry.path, reason))
+
+		total -= entry.size
+		stats.Removed++
+		stats.Files += entry.files
+		stats.Bytes += entry.size
+	}
+
+	if stats.Removed > 0 {
+		printInfo(fmt.Sprintf("Log retention: removed %d entr(ies), %d file(s), %.1f MB freed",
+			stats.Removed, stats.Files, float64(stats.Bytes)/(1<<20)))
+	}
+	if stats.Foreign > 0 {
+		printWarning(fmt.Sprintf("Log retention: skipped %d foreign entr(ies) in %s (set retention.strict to remove them)", stats.Foreign, logDir))
+	}
+	if policy.MaxTotalMB > 0 && total > maxTotal {
+		printWarning(fmt.Sprintf("Log retention: %s is still %.1f MB (limit %d MB)", logDir, float64(total)/(1<<20), policy.MaxTotalMB))
+	}
+	return stats, nil
+}
+
+// sanitizeFileName делает строку безопасной для имени файла/каталога
+func sanitizeFileName(name string) string {
+	name = strings.TrimSpace(name)
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	// Ведущий '-' превратил бы имя в опцию команды
+	result := strings.TrimLeft(strings.Trim(b.String(), "."), "-")
+	if strings.Trim(result, "_") == "" {
+		return "unknown"
+	}
+	if len(result) > maxFileNameComponent {
+		// Обрезанные длинные значения различаются по хешу полного значения
+		sum := sha256.Sum256([]byte(name))
+		result = result[:maxFileNameComponent-9] + "_" + hex.EncodeToString(sum[:4])
+	}
+	return result
+}
+
+// maxFileNameComponent - предел длины одной части имени файла (в имени лога их четыре, лимит ФС 255 байт)
+const maxFileNameComponent = 48
+
+// sessionLogFileName - имя YAML лога сессии: <product>_<serial>_<время>_<state>.yaml.
+// Без серийного номера вместо него ставится session-<ID>, чтобы имена разных сессий не совпадали.
+func sessionLogFileName(log SessionLog) string {
+	serial := log.System.MBSerial
+	if strings.TrimSpace(serial) == "" {
+		serial = "session-" + log.SessionID
+	}
+	return fmt.Sprintf("%s_%s_%s_%s.yaml",
+		sanitizeFileName(log.System.Product),
+		sanitizeFileName(serial),
+		log.Timestamp.Format("20060102_150405"),
+		sanitizeFileName(log.State))
+}
+
+// readBatchInput читает CSV пакетного режима: первая строка - ID полей (FlashField.ID)
+func readBatchInput(path string, fields []FlashField) ([]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open input file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV: %v", err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("input file has no unit rows")
+	}
+
+	header := records[0]
+	columns := make(map[string]bool)
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+		columns[header[i]] = true
+	}
+	for _, field := range fields {
+		if !columns[field.ID] {
+			return nil, fmt.Errorf("input file has no column for field %s (%s)", field.Name, field.ID)
+		}
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, value := range record {
+			row[header[i]] = strings.TrimSpace(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// writeTestLogs пишет полный вывод каждого теста в <dir>/tests/NN_<name>.log (NN - порядок выполнения)
+// и заполняет OutputFile. Повторяющиеся имена тестов дополняются группой.
+func writeTestLogs(results []TestResult, dir string, splitAttempts bool) error {
+	testsDir := filepath.Join(dir, "tests")
+	if err := os.MkdirAll(testsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create tests log directory: %v", err)
+	}
+
+	nameCount := make(map[string]int)
+	for _, r := range results {
+		nameCount[r.Name]++
+	}
+
+	for i := range results {
+		r := &results[i]
+		base := fmt.Sprintf("%02d_%s", i+1, sanitizeFileName(r.Name))
+		if nameCount[r.Name] > 1 && r.Group != "" {
+			base += "_" + sanitizeFileName(r.Group)
+		}
+
+		history := r.History
+		if len(history) == 0 {
+			// Тест не выполнялся (например, пропущен) - пишем только заголовок
+			history = []TestAttempt{{Number: r.Attempts, Status: r.Status, Started: r.Started, Duration: r.Duration, Error: r.Error, Output: r.Output}}
+		}
+
+		if splitAttempts && len(history) > 1 {
+			for _, attempt := range history {
+				name := fmt.Sprintf("%s_attempt%d.log", base, attempt.Number)
+				if err := os.WriteFile(filepath.Join(testsDir, name), formatTestLog(*r, []TestAttempt{attempt}), 0644); err != nil {
+					return fmt.Errorf("failed to write test log %s: %v", name, err)
+				}
+				r.OutputFile = filepath.Join("tests", name)
+			}
+			continue
+		}
+
+		name := base + ".log"
+		if err := os.WriteFile(filepath.Join(testsDir, name), formatTestLog(*r, history), 0644); err != nil {
+			return fmt.Errorf("failed to write test log %s: %v", name, err)
+		}
+		r.OutputFile = filepath.Join("tests", name)
+	}
+
+	return nil
+}
+
+// formatTestLog формирует файл теста: заголовок с итогом и вывод попыток с разделителями
+func formatTestLog(r TestResult, attempts []TestAttempt) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "# Test     : %s\n", r.Name)
+	if r.Group != "" {
+		fmt.Fprintf(&b, "# Group    : %s\n", r.Group)
+	}
+	fmt.Fprintf(&b, "# Command  : %s\n", r.Command)
+	fmt.Fprintf(&b, "# Status   : %s\n", r.Status)
+	if r.Error != "" {
+		fmt.Fprintf(&b, "# Error    : %s\n", r.Error)
+	}
+	fmt.Fprintf(&b, "# Attempts : %d\n", r.Attempts)
+	fmt.Fprintf(&b, "# Duration : %s\n", r.Duration)
+	if r.Timeout > 0 {
+		extended := ""
+		if r.TimeoutExtended {
+			extended = " (extended after TIMEOUT)"
+		}
+		fmt.Fprintf(&b, "# Timeout  : %s%s\n", r.Timeout, extended)
+	}
+	if !r.Started.IsZero() {
+		fmt.Fprintf(&b, "# Started  : %s\n", r.Started.Format(time.RFC3339))
+		fmt.Fprintf(&b, "# Finished : %s\n", r.Started.Add(r.Duration).Format(time.RFC3339))
+	}
+
+	for _, a := range attempts {
+		timeout := ""
+		if a.Timeout > 0 {
+			timeout = fmt.Sprintf(", timeout %s", a.Timeout)
+		}
+		fmt.Fprintf(&b, "\n===== ATTEMPT %d: %s (%s%s) started %s =====\n",
+			a.Number, a.Status, a.Duration, timeout, a.Started.Format("15:04:05"))
+		if a.Error != "" {
+			fmt.Fprintf(&b, "Error: %s\n", a.Error)
+		}
+		b.WriteString(a.Output)
+		if a.Output != "" && !strings.HasSuffix(a.Output, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	return b.Bytes()
+}
+
+// BatchUnitResult - итог прошивки одного изделия в пакетном режиме
+type BatchUnitResult struct {
+	Row      int
+	Serial   string
+	Status   string // "pass", "failed"
+	Duration time.Duration
+	Details  string
+	LogPath  string
+}
+
+// runBatchMode прошивает изделия по строкам CSV без участия оператора.
+// Возвращает количество неуспешных изделий.
+func runBatchMode(config *Config, configPath, inputPath, sessionID string, systemInfo SystemInfo) (int, error) {
+	var needed []FlashField
+	for _, field := range config.Flash.Fields {
+		if fieldNeeded(field, config.Flash.Operations) {
+			needed = append(needed, field)
+		}
+	}
+
+	rows, err := readBatchInput(inputPath, needed)
+	if err != nil {
+		return 0, err
+	}
+
+	logDir := config.Log.LogDir
+	if logDir == "" {
+		logDir = "logs"
+	}
+
+	printSectionHeader("BATCH MODE")
+	fmt.Printf("Input: %s%s%s | Units: %s%d%s | Operations: %s%s%s\n",
+		ColorCyan, inputPath, ColorReset, ColorGreen, len(rows), ColorReset,
+		ColorYellow, strings.Join(config.Flash.Operations, ", "), ColorReset)
+
+	var results []BatchUnitResult
+	passed, failed := 0, 0
+
+	for n, row := range rows {
+		unit := BatchUnitResult{Row: n + 1, Serial: row["system-serial-number"], Status: "failed"}
+		if unit.Serial == "" {
+			unit.Serial = fmt.Sprintf("unit_%03d", n+1)
+		}
+
+		fmt.Printf("\n%sUNIT %d/%d: %s%s\n", ColorWhite, n+1, len(rows), unit.Serial, ColorReset)
+		printThickSeparator()
+
+		start := time.Now()
+		var flashResults []FlashResult
+		flashData, err := getFlashData(config.Flash, config.System, systemInfo, row)
+		if err != nil {
+			unit.Details = fmt.Sprintf("invalid input: %v", err)
+			printError(unit.Details)
+		} else if flashData != nil {
+			flashResults, _ = runFlashing(config.Flash, flashData, config.System, logDir)
+			unit.Status = calculateSessionState(nil, flashResults)
+			for _, r := range flashResults {
+				if r.Status == "FAILED" {
+					unit.Details = r.Details
+					break
+				}
+			}
+		}
+		unit.Duration = time.Since(start)
+
+		unitLog := SessionLog{
+			SessionID:       fmt.Sprintf("%s-%03d", sessionID, n+1),
+			Timestamp:       start,
+			TimestampOffset: sessionOffset(start),
+			State:           unit.Status,
+			Pipeline: PipelineInfo{
+				Mode:     "batch",
+				Config:   configPath,
+				Duration: unit.Duration,
+				Operator: config.Log.OpName,
+				FlashOps: config.Flash.Operations,
+
+				ConfigSource: stationConfigSource,
+			},
+			FlashResults: flashResults,
+			System:       systemInfo,
+		}
+		annotateClockCheck(&unitLog, sessionClock)
+		if flashData != nil {
+			unitLog.FlashReview = flashData.Review
+			unitLog.System.MBSerial = flashData.SystemSerial
+			unitLog.System.IOSerial = flashData.IOBoard
+			unitLog.System.MAC = flashData.MAC
+			unitLog.Variant = flashData.Variant
+		}
+
+		unitDir := filepath.Join(logDir, sanitizeFileName(unit.Serial))
+		unit.LogPath = filepath.Join(unitDir, "session.yaml")
+		if err := os.MkdirAll(unitDir, 0755); err != nil {
+			printError(fmt.Sprintf("Failed to create unit log directory: %v", err))
+		} else if data, err := yaml.Marshal(unitLog); err != nil {
+			printError(fmt.Sprintf("Failed to marshal unit log: %v", err))
+		} else if err := os.WriteFile(unit.LogPath, data, 0644); err != nil {
+			printError(fmt.Sprintf("Failed to write unit log: %v", err))
+		}
+
+		if unit.Status == "pass" {
+			passed++
+		} else {
+			failed++
+		}
+		results = append(results, unit)
+
+		fmt.Printf("%sBatch progress: %d/%d completed | %s%d passed%s | %s%d failed%s\n",
+			ColorWhite, n+1, len(rows), ColorGreen, passed, ColorWhite, ColorRed, failed, ColorReset)
+	}
+
+	summaryPath := filepath.Join(logDir, fmt.Sprintf("batch_%s.csv", time.Now().Format("20060102_150405")))
+	if err := writeBatchSummary(summaryPath, results); err != nil {
+		printError(fmt.Sprintf("Failed to write batch summary: %v", err))
+	} else {
+		printSuccess(fmt.Sprintf("Batch summary saved: %s", summaryPath))
+	}
+
+	return failed, nil
+}
+
+// writeBatchSummary сохраняет итоги пакета в CSV
+func writeBatchSummary(path string, results []BatchUnitResult) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"row", "serial", "status", "duration", "details", "log"})
+	for _, r := range results {
+		writer.Write([]string{
+			strconv.Itoa(r.Row),
+			r.Serial,
+			r.Status,
+			r.Duration.Round(time.Millisecond).String(),
+			r.Details,
+			r.LogPath,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//go:embed report.html.tmpl
+var defaultReportTemplate string
+
+// configTemplate - пример конфига с комментариями для -generate-config. Единственный экземпляр:
+// example/config.yaml - символическая ссылка на этот файл (go:embed ссылки не встраивает)
+//
+//go:embed config.example.yaml
+var configTemplate []byte
+
+// generateConfig записывает шаблон конфига; существующий файл не перезаписывается
+func generateConfig(path string) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, not overwriting", path)
+		}
+		return err
+	}
+	if _, err := file.Write(configTemplate); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// maxReportOutputBytes - предел вывода теста в HTML отчете, полный вывод остается в транскрипте
+const maxReportOutputBytes = 16 * 1024
+
+// truncateUTF8 обрезает строку до limit байт, не разрезая многобайтовый символ
+func truncateUTF8(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
+
+type reportTest struct {
+	TestResult
+	Truncated bool
+}
+
+type reportField struct {
+	Name  string
+	Value string
+}
+
+type reportView struct {
+	Log         SessionLog
+	Tests       []reportTest
+	Inventory   []reportField
+	Generated   string
+	FlappyCount int // Тесты, прошедшие только после повторов
+}
+
+// renderHTMLReport строит HTML отчет по логу сессии. Без внешних ресурсов и без побочных эффектов.
+func renderHTMLReport(log SessionLog, templateText string) ([]byte, error) {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"duration": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	}).Parse(templateText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse report template: %v", err)
+	}
+
+	view := reportView{Log: log, Generated: log.Timestamp.Add(log.Pipeline.Duration).Format("2006-01-02 15:04:05")}
+
+	for _, result := range log.TestResults {
+		test := reportTest{TestResult: result}
+		if len(test.Output) > maxReportOutputBytes {
+			test.Output = truncateUTF8(test.Output, maxReportOutputBytes)
+			test.Truncated = true
+		}
+		view.Tests = append(view.Tests, test)
+		if result.Flappy {
+			view.FlappyCount++
+		}
+	}
+
+	addField := func(name, value string) {
+		if value != "" {
+			view.Inventory = append(view.Inventory, reportField{Name: name, Value: value})
+		}
+	}
+	addField("Product", log.System.Product)
+	addField("Board Serial", log.System.MBSerial)
+	addField("Original Board Serial", log.System.OriginalMBSerial)
+	addField("IO Board Serial", log.System.IOSerial)
+	addField("MAC Address", log.System.MAC)
+	addField("Original MACs", strings.Join(log.System.OriginalMACs, ", "))
+	addField("IP Address", log.System.IP)
+	addField("Board Manufacturer", log.System.BoardManufacturer)
+	addField("Board Version", log.System.BoardVersion)
+	addField("BIOS Vendor", log.System.BIOSVendor)
+	addField("BIOS Version", log.System.BIOSVersion)
+	addField("BIOS Release Date", log.System.BIOSReleaseDate)
+	addField("Station", log.System.Station.ID)
+	addField("Station Host", log.System.Station.Hostname)
+	addField("Firestarter Version", log.System.Station.FirestarterVersion)
+	if log.System.BMCClock != nil {
+		addField("BMC Clock Skew", fmt.Sprintf("%+ds", log.System.BMCClock.SkewSeconds))
+	}
+
+	tools := make([]string, 0, len(log.System.ToolVersions))
+	for tool := range log.System.ToolVersions {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	for _, tool := range tools {
+		addField(tool+" version", log.System.ToolVersions[tool])
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, view); err != nil {
+		return nil, fmt.Errorf("failed to render report: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeHTMLReport сохраняет report.html в каталог сессии и возвращает путь к нему
+func writeHTMLReport(log SessionLog, config LogConfig) (string, error) {
+	templateText := defaultReportTemplate
+	if config.ReportTemplate != "" {
+		data, err := os.ReadFile(config.ReportTemplate)
+		if err != nil {
+			return "", fmt.Errorf("failed to read report template %s: %v", config.ReportTemplate, err)
+		}
+		templateText = string(data)
+	}
+
+	data, err := renderHTMLReport(log, templateText)
+	if err != nil {
+		return "", err
+	}
+
+	dir := sessionDir(config, log.SessionID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create session directory: %v", err)
+	}
+
+	path := filepath.Join(dir, "report.html")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write report: %v", err)
+	}
+	return path, nil
+}
+
+// Действия по завершении сессии (pipeline.finish_action / -finish-action)
+const (
+	finishPrompt                = "prompt"
+	finishReboot                = "reboot"
+	finishShutdown              = "shutdown"
+	finishNone                  = "none"
+	finishRebootIfSerialChanged = "reboot-if-serial-changed"
+)
+
+// defaultFinishCountdown - секунд на отмену автоматической перезагрузки/выключения
+const defaultFinishCountdown = 10
+
+func validateFinishAction(action string) error {
+	switch action {
+	case "", finishPrompt, finishReboot, finishShutdown, finishNone, finishRebootIfSerialChanged:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q (expected prompt, reboot, shutdown, none or reboot-if-serial-changed)", action)
+}
+
+// resolveFinishAction переводит режим в действие с питанием. reboot-if-serial-changed - прежняя
+// логика без вопросов: после смены серийного номера перезагрузка, иначе выключение
+func resolveFinishAction(mode string, serialChanged bool) string {
+	if mode == finishRebootIfSerialChanged {
+		if serialChanged {
+			return finishReboot
+		}
+		return finishShutdown
+	}
+	return mode
+}
+
+func finishCountdown(config PipelineConfig) int {
+	if config.FinishCountdown == nil {
+		return defaultFinishCountdown
+	}
+	return *config.FinishCountdown
+}
+
+// runFinishAction выполняет решение о питании в конце сессии. Вызывается после сохранения и выгрузки лога.
+// bootctl (проход EFI shell) нужен только при смене серийного номера, а не при любой перезагрузке
+func runFinishAction(pipeline PipelineInfo, serialChanged, continuation bool, postRebootGroups, countdown int) {
+	action := pipeline.FinishPowerAction
+	if pipeline.FinishAction == finishPrompt || pipeline.FinishAction == "" {
+		action = askFinishAction(serialChanged, continuation, postRebootGroups)
+	} else {
+		if serialChanged {
+			fmt.Printf("\n%s%s%s\n", ColorYellow, tr("reboot.serial_updated"), ColorReset)
+			if continuation && action == finishReboot {
+				fmt.Printf("%s%s%s\n", ColorYellow, tr("reboot.will_continue", postRebootGroups), ColorReset)
+			}
+		}
+		fmt.Printf("\n%s%s%s\n", ColorWhite, tr("finish.action", action), ColorReset)
+		if action == finishNone {
+			printInfo(tr("finish.none"))
+		} else if !waitFinishCountdown(action, countdown) {
+			printInfo(tr("finish.cancelled"))
+			action = finishNone
+		}
+	}
+
+	switch action {
+	case finishReboot:
+		printInfo(tr("reboot.preparing"))
+		if serialChanged {
+			if err := bootctl(); err != nil {
+				printError("Bootctl error: " + err.Error())
+				exitSession(1)
+			}
+		}
+		printSuccess(tr("reboot.now"))
+		runShutdownHooks()
+		if err := tracedRun(exec.Command("reboot")); err != nil {
+			printError(fmt.Sprintf("Failed to reboot: %v", err))
+			exitSession(1)
+		}
+	case finishShutdown:
+		printInfo(tr("shutdown.preparing"))
+		printSuccess(tr("shutdown.now"))
+		runShutdownHooks()
+		if err := tracedRun(exec.Command("shutdown", "-h", "now")); err != nil {
+			printError(fmt.Sprintf("Failed to shutdown: %v", err))
+			exitSession(1)
+		}
+	default:
+		if serialChanged {
+			printWarning(tr("reboot.note"))
+			if continuation {
+				printWarning(tr("reboot.pending"))
+			}
+		}
+	}
+}
+
+// askFinishAction - прежний вопрос оператору: перезагрузка после смены серийного номера, иначе выключение
+func askFinishAction(serialChanged, continuation bool, postRebootGroups int) string {
+	reader := bufio.NewReader(operatorInput())
+	action, cancelled := finishShutdown, tr("shutdown.cancelled")
+	if serialChanged {
+		// Серийный номер был изменен - требуется перезагрузка
+		fmt.Printf("\n%s%s%s\n", ColorYellow, tr("reboot.serial_updated"), ColorReset)
+		if continuation {
+			fmt.Printf("%s%s%s\n", ColorYellow, tr("reboot.will_continue", postRebootGroups), ColorReset)
+		}
+		fmt.Printf("%s%s%s %s[Y/n]%s: ", ColorWhite, tr("reboot.ask"), ColorReset, ColorGreen, ColorReset)
+		action, cancelled = finishReboot, tr("reboot.cancelled")
+	} else {
+		// Серийный номер не изменялся - можно просто выключить
+		fmt.Printf("\n%s%s%s\n", ColorBlue, tr("shutdown.no_changes"), ColorReset)
+		fmt.Printf("%s%s%s %s[Y/n]%s: ", ColorWhite, tr("shutdown.ask"), ColorReset, ColorGreen, ColorReset)
+	}
+
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		input = "Y"
+	}
+	input = strings.TrimSpace(strings.ToUpper(input))
+	if input == "" || input == "Y" || input == "YES" {
+		return action
+	}
+	printInfo(cancelled)
+	return finishNone
+}
+
+// waitFinishCountdown отсчитывает секунды до действия; любая клавиша отменяет его (false).
+// Без терминала отменить некому - просто ждем
+func waitFinishCountdown(action string, seconds int) bool {
+	if seconds <= 0 {
+		return true
+	}
+
+	keys := make(chan struct{}, 1)
+	if isInteractive() {
+		// Посимвольный ввод без Enter; исходный режим терминала возвращаем при выходе
+		if saved, err := sttyOutput("-g"); err == nil {
+			if _, err := sttyOutput("-icanon", "-echo", "min", "1"); err == nil {
+				defer sttyOutput(strings.TrimSpace(saved))
+				go func() {
+					buf := make([]byte, 1)
+					if n, _ := consoleIn.Read(buf); n > 0 {
+						keys <- struct{}{}
+					}
+				}()
+			}
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for left := seconds; left > 0; left-- {
+		outputManager.ClearStatus()
+		fmt.Printf("\r%s%s%s   ", ColorYellow, tr("finish.countdown", action, left), ColorReset)
+		select {
+		case <-keys:
+			fmt.Println()
+			return false
+		case <-ticker.C:
+		}
+	}
+	fmt.Println()
+	return true
+}
+
+// sttyOutput выполняет stty на терминале оператора
+func sttyOutput(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = consoleIn
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+func main() {
+	var configPath string
+	var configURL string
+	var showVersion bool
+	var testsOnly bool
+	var flashOnly bool
+	var show_Help bool
+	var inventoryPath string
+	var flashOps string
+	var inputFile string
+	var rollbackFRU string
+	var rollbackEFI string
+	var efiMaintenance, efiCleanup bool
+	var skipFlashOps string
+	var pruneLogsOnly bool
+	var generateConfigFile bool
+	var uploadManifestOnly bool
+	var fruStatus bool
+	var continueSession bool
+	var printPlan planFormat
+	var grpcAddr string
+	var resumePath string
+	var quietMode bool
+	var refreshConfig bool
+	var validateConfigOnly bool
+	var finishAction string
+	var includeTags string
+	var completionShell string
+	var interactiveTests bool
+	var skipTests, onlyTests string
+	var excludeTags string
+
+	flag.BoolVar(&continueSession, "continue", false, "Continue the session waiting for this board after the serial-number reboot")
+	flag.StringVar(&resumePath, "resume", "", "Resume an interrupted session from its session_current.yaml: completed tests and flash operations are not repeated")
+	flag.StringVar(&finishAction, "finish-action", "", "What to do when the session ends: prompt, reboot, shutdown, none, reboot-if-serial-changed (overrides pipeline.finish_action)")
+	flag.BoolVar(&validateConfigOnly, "validate-config", false, "Validate the configuration, print it as written and fully expanded, then exit")
+	flag.BoolVar(&refreshConfig, "refresh-config", false, "Download the station configuration from config_source, verify and cache it, then exit")
+	flag.BoolVar(&quietMode, "quiet", false, "Compact output: one live status line instead of test sections (ignored when stdout is not a terminal)")
+	flag.BoolVar(&showResources, "show-resources", false, "Capture and show memory/CPU usage of each test")
+	flag.Var(&printPlan, "print-plan", "Print the execution plan without running anything (-print-plan=json for CI)")
+	flag.BoolVar(&debugMode, "debug", false, "Show debug output (e.g. configuration defaults applied)")
+	flag.BoolVar(&fruStatus, "fru-status", false, "Print FRU health, decoded fields and raw dump, exit with health code")
+	flag.BoolVar(&pruneLogsOnly, "prune-logs", false, "Apply log.retention to the log directory and exit")
+	flag.BoolVar(&uploadManifestOnly, "upload-manifest", false, "Upload today's and any not yet uploaded daily manifests to the log server and exit")
+	flag.StringVar(&configPath, "c", "config.yaml", "Path to configuration file")
+	flag.StringVar(&configURL, "config-url", "", "Load the configuration from this http(s) URL instead of -c (ETag-cached in ~/.firestarter/config_cache.yaml)")
+	flag.BoolVar(&configInsecure, "config-insecure", false, "Skip TLS certificate verification for -config-url")
+	flag.BoolVar(&generateConfigFile, "generate-config", false, "Write a commented configuration template to the -c path if it does not exist and exit")
+	flag.BoolVar(&showVersion, "V", false, "Show version")
+	flag.BoolVar(&testsOnly, "tests-only", false, "Run only tests (skip flashing)")
+	flag.BoolVar(&flashOnly, "flash-only", false, "Run only flashing (skip tests)")
+	flag.BoolVar(&show_Help, "h", false, "Show help")
+	flag.BoolVar(&nonInteractive, "non-interactive", false, "Do not prompt the operator, use defaults")
+	flag.StringVar(&inventoryPath, "inventory", "", "Write hardware inventory to YAML file and exit")
+	flag.StringVar(&flashOps, "flash-ops", "", "Run only these flash operations (comma-separated, e.g. fru,efi)")
+	flag.StringVar(&skipFlashOps, "skip-flash-ops", "", "Skip these flash operations (comma-separated)")
+	flag.StringVar(&completionShell, "completion", "", "Print a shell completion script (bash, zsh or fish) and exit")
+	flag.StringVar(&includeTags, "tags", "", "Run only tests (and tagged flash operations) with any of these tags (comma-separated, overrides tests.default_tags)")
+	flag.StringVar(&excludeTags, "exclude-tags", "", "Do not run tests or flash operations with any of these tags (comma-separated)")
+	flag.StringVar(&skipTests, "skip-tests", "", "Skip these tests (comma-separated test names), recorded as SKIPPED")
+	flag.StringVar(&onlyTests, "only-tests", "", "Run only these tests (comma-separated test names), the rest are SKIPPED")
+	flag.BoolVar(&interactiveTests, "interactive-tests", false, "Choose which tests to run from a numbered menu before the testing phase")
+	flag.StringVar(&inputFile, "input-file", "", "Batch mode: flash units from CSV file (columns = flash field IDs)")
+	flag.StringVar(&rollbackFRU, "rollback-fru", "", "Restore FRU from the backup made during the given session YAML and exit")
+	flag.StringVar(&rollbackEFI, "rollback-efi", "", "Restore EFI variables from the backups made during the given session YAML and exit")
+	flag.BoolVar(&efiMaintenance, "efi-maintenance", false, "List firestarter boot entries and EFI variables and delete selected ones interactively, then exit")
+	flag.BoolVar(&efiCleanup, "efi-cleanup", false, "Delete boot entries and EFI variables according to system.efi_cleanup, then exit")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "Serve session status over gRPC on this address (e.g. :50051) for monitoring dashboards")
+	flag.Parse()
+
+	if show_Help {
+		showHelp()
+		os.Exit(0)
+	}
+	if completionShell != "" {
+		script, err := generateCompletion(completionShell)
+		if err != nil {
+			printError(fmt.Sprintf("-completion: %v", err))
+			os.Exit(1)
+		}
+		fmt.Print(script)
+		os.Exit(0)
+	}
+	if showVersion {
+		fmt.Println(VERSION)
+		os.Exit(0)
+	}
+	if err := validateFinishAction(finishAction); err != nil {
+		printError(fmt.Sprintf("-finish-action: %v", err))
+		os.Exit(1)
+	}
+	if testsOnly && (flashOps != "" || skipFlashOps != "") {
+		printError("-flash-ops/-skip-flash-ops cannot be combined with -tests-only (no flashing would run)")
+		os.Exit(1)
+	}
+	if inputFile != "" {
+		if testsOnly {
+			printError("-input-file cannot be combined with -tests-only")
+			os.Exit(1)
+		}
+		nonInteractive = true // Пакетный режим работает без оператора
+	}
+	if fruStatus {
+		os.Exit(runFRUStatus())
+	}
+	if rollbackFRU != "" {
+		if err := runFRURollback(rollbackFRU); err != nil {
+			printError(fmt.Sprintf("FRU rollback failed: %v", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if inventoryPath != "" {
+		// Режим инвентаризации не требует конфига, root и не трогает EFI переменные
+		if err := runInventoryMode(inventoryPath); err != nil {
+			printError(fmt.Sprintf("Inventory failed: %v", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if configURL != "" {
+		configFlagSet := false
+		flag.Visit(func(f *flag.Flag) { configFlagSet = configFlagSet || f.Name == "c" })
+		if configFlagSet || generateConfigFile {
+			printError("-config-url cannot be combined with -c or -generate-config")
+			os.Exit(1)
+		}
+		if !strings.HasPrefix(configURL, "http://") && !strings.HasPrefix(configURL, "https://") {
+			printError(fmt.Sprintf("-config-url: expected an http:// or https:// URL, got %q", configURL))
+			os.Exit(1)
+		}
+	}
+
+	// Шаблон конфига для новой станции (с -config-url локальный файл не нужен)
+	if _, err := os.Stat(configPath); configURL == "" && os.IsNotExist(err) {
+		if !generateConfigFile {
+			printError("Config file not found. Run with -generate-config to create a template")
+			os.Exit(1)
+		}
+		if err := generateConfig(configPath); err != nil {
+			printError(fmt.Sprintf("Failed to generate config: %v", err))
+			os.Exit(1)
+		}
+		printSuccess(fmt.Sprintf("Generated config at %s, please edit before running", configPath))
+		os.Exit(0)
+	} else if generateConfigFile {
+		printError(fmt.Sprintf("Config file %s already exists, not overwriting", configPath))
+		os.Exit(1)
+	}
+
+	// Enterprise заголовок (JSON план печатается без него, сообщения - в stderr)
+	if printPlan == "json" {
+		messageOutput = os.Stderr
+	} else {
+		fmt.Printf("%sFIRESTARTER%s Hardware Validation System %sv%s%s\n",
+			ColorBlue, ColorReset, ColorGray, VERSION, ColorReset)
+		printThickSeparator()
+	}
+
+	// Load configuration
+	var config *Config
+	var err error
+	if configURL != "" {
+		config, err = fetchConfig(configURL, defaultConfigFetchTimeout)
+		configPath = configURLCachePath()
+	} else {
+		_, config, err = loadConfig(configPath)
+	}
+	if err != nil {
+		printError(fmt.Sprintf("Failed to load configuration: %v", err))
+		os.Exit(1)
+	}
+	if validateConfigOnly {
+		os.Exit(runConfigValidation(configPath))
+	}
+	if refreshConfig {
+		os.Exit(runConfigRefresh(config))
+	}
+	// Конфиг станции с сервера: свежий, иначе последний из кэша, иначе этот локальный файл.
+	// -config-url сам задает источник, config_source в загруженном конфиге не применяется
+	if config.ConfigSource != nil && configURL == "" {
+		config, configPath = resolveStationConfig(config, configPath)
+	}
+	setUILanguage(config.UI.Language)
+	finishOverridden := finishAction != ""
+	if finishOverridden {
+		config.Pipeline.FinishAction = finishAction
+	}
+
+	// Строка статуса перерисовывается через \r - в файл или пайп пишем обычный вывод
+	if quietMode || config.UI.Mode == "compact" {
+		if stdoutIsTerminal() {
+			outputManager.EnableCompact()
+		} else {
+			printInfo("Compact output disabled: stdout is not a terminal")
+		}
+	}
+
+	showResources = showResources || config.Tests.ShowResources
+	eeupdateSearchPaths = config.System.EeupdateSearchPaths
+	efiShellPath = config.System.EFIShellPath
+	efiBootEntryLabel = config.System.EFIBootEntryLabel
+	blockFlashOnRequiredFailure = config.Flash.Enabled && !testsOnly && !config.Flash.AllowOnRequiredFailure
+	maxTestAttempts = config.Tests.MaxRetries
+	maxParallelTests = config.Tests.MaxParallel
+	timeoutRetryMultiplier = config.Tests.TimeoutRetryMultiplier
+	maxTestTimeout = config.Tests.MaxTimeout
+	excludeSkippedFromRate = config.Tests.ExcludeSkippedFromRate
+	resourceAliases = config.Tests.ResourceAliases
+	selOnFailure = config.Log.SELOnFailure
+	serialHistory.Dir = config.Log.SerialHistoryDir
+	if serialHistory.Dir == "" {
+		serialHistory.Dir = logDirPath(config.Log)
+	}
+	if station := collectStationInfo(config.Log); station.ID != "" {
+		serialHistory.Station = station.ID
+	} else {
+		serialHistory.Station = station.Hostname
+	}
+	fruBlankSize = config.Flash.FRUBlankSizeBytes
+	allowSpecialMAC = config.Flash.AllowSpecialMAC
+	if config.System.DriverUnloadTimeoutSeconds > 0 {
+		driverUnloadTimeout = time.Duration(config.System.DriverUnloadTimeoutSeconds) * time.Second
+	}
+
+	if uploadManifestOnly {
+		if !config.Log.Manifest || config.Log.Server == "" {
+			printError("-upload-manifest requires log.manifest and log.server in configuration")
+			os.Exit(1)
+		}
+		// Сначала outbox: статусы выгрузки в манифесте должны быть свежими
+		if _, err := flushOutbox(config.Log); err != nil {
+			printWarning(fmt.Sprintf("Outbox not flushed: %v", err))
+		}
+		count, err := uploadManifests(config.Log, time.Now(), true)
+		if err != nil {
+			printError(fmt.Sprintf("Manifest upload failed: %v", err))
+			os.Exit(1)
+		}
+		if count == 0 {
+			printSuccess("Manifests are up to date on the server")
+		}
+		os.Exit(0)
+	}
+
+	if pruneLogsOnly {
+		policy := config.Log.Retention
+		if policy.MaxTotalMB <= 0 && policy.MaxAgeDays <= 0 {
+			printError("-prune-logs requires log.retention.max_total_mb or max_age_days in configuration")
+			os.Exit(1)
+		}
+		stats, err := pruneLogs(config.Log, time.Now())
+		if err != nil {
+			printError(fmt.Sprintf("Log pruning failed: %v", err))
+			os.Exit(1)
+		}
+		if stats.Removed == 0 {
+			printSuccess("Log retention: nothing to remove")
+		}
+		os.Exit(0)
+	}
+
+	if rollbackEFI != "" {
+		if err := runEFIRollback(rollbackEFI, config.System.GuidPrefix); err != nil {
+			printError(fmt.Sprintf("EFI rollback failed: %v", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if efiCleanup {
+		os.Exit(runEFICleanup(config))
+	}
+	if efiMaintenance {
+		if !isInteractive() {
+			printError("-efi-maintenance needs an operator terminal, use -efi-cleanup for unattended cleanup")
+			os.Exit(1)
+		}
+		os.Exit(runEFIMaintenance(config))
+	}
+
+	// Тесты генератора нужны только сессии; план показывает саму команду генератора
+	if printPlan == "" {
+		if err := expandGeneratedTests(config); err != nil {
+			printError(fmt.Sprintf("Failed to load configuration: %v", err))
+			os.Exit(1)
+		}
+	}
+
+	// Подмножество операций прошивки на этот запуск
+	configuredFlashOps := config.Flash.Operations
+	if flashOps != "" || skipFlashOps != "" {
+		if !config.Flash.Enabled {
+			printError("-flash-ops/-skip-flash-ops require flash.enabled in configuration")
+			os.Exit(1)
+		}
+		effective, err := filterFlashOperations(configuredFlashOps, flashOps, skipFlashOps)
+		if err != nil {
+			printError(fmt.Sprintf("Invalid flash operations selection: %v", err))
+			os.Exit(1)
 		}
+		config.Flash.Operations = effective
 	}
 
-	return "pass"
-}
-
-func saveLog(log SessionLog, config LogConfig) error {
-	if !config.SaveLocal {
-		return nil
+	// Исключение тестов по имени без правки конфига
+	if skipTests != "" || onlyTests != "" {
+		set, err := buildTestSkipSet(configuredTests(config), onlyTests, skipTests)
+		if err != nil {
+			printError(fmt.Sprintf("Invalid test selection: %v", err))
+			os.Exit(1)
+		}
+		skipSet, skipSetFlag = set, "-skip-tests"
+		if onlyTests != "" {
+			skipSetFlag = "-only-tests"
+		}
+		printInfo(fmt.Sprintf("%d test(s) will be skipped (%s)", len(skipSet), skipSetFlag))
 	}
 
-	logDir := config.LogDir
-	if logDir == "" {
-		logDir = "logs"
+	// Фильтр по тегам - после -flash-ops/-skip-flash-ops (порядок описан у TagSelection)
+	var tagSelection *TagSelection
+	if include, exclude := splitTags(includeTags), splitTags(excludeTags); len(include) > 0 || len(exclude) > 0 || len(config.Tests.DefaultTags) > 0 {
+		tagSelection = &TagSelection{Include: include, Exclude: exclude, Source: "cli"}
+		if len(include) == 0 && len(config.Tests.DefaultTags) > 0 {
+			tagSelection.Include = splitTags(strings.Join(config.Tests.DefaultTags, ","))
+			tagSelection.Source = "config"
+		}
+		excluded, unknown := applyTagFilter(*config, tagSelection)
+		for _, tag := range unknown {
+			printWarning(fmt.Sprintf("Tag '%s' does not match any test, group or flash operation", tag))
+		}
+		tagExcluded = excluded
+		if len(tagSelection.ExcludedFlashOps) > 0 {
+			config.Flash.Operations = slices.DeleteFunc(slices.Clone(config.Flash.Operations), func(op string) bool {
+				return slices.Contains(tagSelection.ExcludedFlashOps, op)
+			})
+		}
+		if config.Flash.Enabled && len(config.Flash.Operations) == 0 {
+			printInfo("All flash operations excluded by tags - flashing disabled for this run")
+			config.Flash.Enabled = false
+		}
 	}
 
-	// Create log directory
-	err := os.MkdirAll(logDir, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create log directory: %v", err)
+	// План выполнения без запуска команд и обращения к оборудованию
+	if printPlan != "" {
+		steps, err := buildExecutionPlan(*config, configuredFlashOps, testsOnly, flashOnly)
+		if err != nil {
+			printError(fmt.Sprintf("Invalid pipeline configuration: %v", err))
+			os.Exit(1)
+		}
+		report := buildPlanReport(*config, configPath, steps, testsOnly, flashOnly)
+		report.Tags = tagSelection
+		probePlanDrivers(&report, config.Flash.Method)
+		if err := printExecutionPlan(report, printPlan); err != nil {
+			printError(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	// Generate filename with state
-	timestamp := log.Timestamp.Format("20060102_150405")
-	filename := fmt.Sprintf("%s_%s_%s_%s.yaml", log.System.Product, log.System.MBSerial, timestamp, log.State)
-	filepath := filepath.Join(logDir, filename)
+	// Время проверяется до того, как попадет в ID сессии и имена логов
+	sessionClock = checkSystemClock(config.System)
+	printClockCheck(sessionClock)
 
-	// Marshal to YAML
-	data, err := yaml.Marshal(log)
-	if err != nil {
-		return fmt.Errorf("failed to marshal log: %v", err)
-	}
+	sessionID := fmt.Sprintf("%d", time.Now().Unix())
+	setupSignalHandler()
 
-	// Write to file
-	err = os.WriteFile(filepath, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write log file: %v", err)
+	// Освобождаем место до начала сессии, чтобы сохранение лога не упало на полном диске
+	if _, err := pruneLogs(config.Log, time.Now(), sessionID); err != nil {
+		printWarning(err.Error())
 	}
 
-	printSuccess(fmt.Sprintf("Log saved: %s", filepath))
-	return nil
-}
-
-func main() {
-	var configPath string
-	var showVersion bool
-	var testsOnly bool
-	var flashOnly bool
-	var show_Help bool
-
-	flag.StringVar(&configPath, "c", "config.yaml", "Path to configuration file")
-	flag.BoolVar(&showVersion, "V", false, "Show version")
-	flag.BoolVar(&testsOnly, "tests-only", false, "Run only tests (skip flashing)")
-	flag.BoolVar(&flashOnly, "flash-only", false, "Run only flashing (skip tests)")
-	flag.BoolVar(&show_Help, "h", false, "Show help")
-	flag.Parse()
+	auditSession.SessionID = sessionID
+	auditSession.Operator = config.Log.OpName
+	auditSession.LogDir = config.Log.LogDir
+	if auditSession.LogDir == "" {
+		auditSession.LogDir = "logs"
+	}
 
-	if show_Help {
-		showHelp()
-		os.Exit(0)
+	if continueSession {
+		exitSession(runContinueMode(config))
 	}
-	if showVersion {
-		fmt.Println(VERSION)
-		os.Exit(0)
+
+	// Незавершенная сессия (пропадание питания, kill) оставляет частичный лог
+	var interrupted *SessionLog
+	if resumePath != "" {
+		prev, err := loadSessionState(resumePath)
+		if err != nil {
+			printError(fmt.Sprintf("Cannot resume session: %v", err))
+			exitSession(1)
+		}
+		interrupted = prev
+	} else if config.Log.SaveLocal {
+		if prev, err := loadSessionState(sessionStateFile(config.Log)); err == nil {
+			printWarning(fmt.Sprintf("Warning: incomplete session found from %s, use --resume to continue",
+				prev.Timestamp.Format("2006-01-02 15:04:05")))
+		} else if !os.IsNotExist(err) {
+			printWarning(err.Error())
+		}
 	}
 
-	// Enterprise заголовок
-	fmt.Printf("%sFIRESTARTER%s Hardware Validation System %sv%s%s\n",
-		ColorBlue, ColorReset, ColorGray, VERSION, ColorReset)
-	printThickSeparator()
+	// Console transcript
+	if config.Log.SaveTranscript {
+		transcriptPath := filepath.Join(sessionDir(config.Log, sessionID), "console.txt")
+		t, err := startConsoleTranscript(transcriptPath)
+		if err != nil {
+			printWarning(fmt.Sprintf("Console transcript disabled: %v", err))
+		} else {
+			transcript = t
+			addShutdownHook(func() { transcript.Close() })
+			printInfo(fmt.Sprintf("Console transcript: %s", transcriptPath))
+		}
+	}
 
-	// Load configuration
-	config, err := loadConfig(configPath)
-	if err != nil {
-		printError(fmt.Sprintf("Failed to load configuration: %v", err))
-		os.Exit(1)
+	// Трассировка внешних команд
+	if config.Log.TraceCommands {
+		t, err := startCommandTrace(sessionDir(config.Log, sessionID), config.Log, config.Flash.SMBIOS.ToolPath)
+		if err != nil {
+			printWarning(fmt.Sprintf("Command trace disabled: %v", err))
+		} else {
+			commandTrace = t
+			addShutdownHook(func() { commandTrace.Close() })
+			printInfo(fmt.Sprintf("Command trace: %s", t.path))
+		}
 	}
-	if config.System.RequireRoot && os.Geteuid() != 0 {
-		printError("This program requires root privileges")
-		os.Exit(1)
+
+	// Статус для дашбордов линии; сессия не зависит от того, удалось ли поднять сервер
+	if grpcAddr != "" {
+		if err := startStatusServer(grpcAddr, sessionID, config.System.Product, ""); err != nil {
+			printWarning(fmt.Sprintf("gRPC status server disabled: %v", err))
+		}
 	}
 
 	// System configuration display
@@ -4091,8 +17715,52 @@ func main() {
 	fmt.Printf("  Configuration     : %s%s%s\n", ColorYellow, configPath, ColorReset)
 	fmt.Printf("  Root Required     : %s%v%s\n", ColorYellow, config.System.RequireRoot, ColorReset)
 	fmt.Printf("  Driver Directory  : %s%s%s\n", ColorBlue, config.System.DriverDir, ColorReset)
+	if config.Flash.Enabled {
+		fmt.Printf("  Flash Operations  : %s%s%s\n", ColorYellow, strings.Join(config.Flash.Operations, ", "), ColorReset)
+	}
+	if tagSelection != nil {
+		fmt.Printf("  Tag Filter        : %s%s%s\n", ColorYellow, tagSelection.String(), ColorReset)
+	}
+
+	// Pre-flight checks
+	fmt.Printf("\n%sPRE-FLIGHT CHECKS%s\n", ColorWhite, ColorReset)
+	printSeparator()
+	preWarnings, preErrors := runPreFlightChecks(*config)
+	if !flashOnly {
+		preErrors = append(preErrors, validateTestCommands(runnableTests(config))...)
+	}
+	for _, w := range preWarnings {
+		printWarning("  ! " + w)
+	}
+	for _, e := range preErrors {
+		printError("  ✗ " + e.Error())
+	}
+	if len(preErrors) > 0 {
+		printError(fmt.Sprintf("Pre-flight checks failed with %d error(s)", len(preErrors)))
+		exitSession(1)
+	}
+	printSuccess("✓ Pre-flight checks passed")
+
+	// Требования к BIOS/ядру для прошивки - сразу, а не после тестов и ввода данных
+	var prereqFailures []PrereqFailure
+	if config.Flash.Enabled && !testsOnly && needsFlashPrerequisites(config.Flash) {
+		prereqFailures = checkFlashPrerequisites(config.Flash, config.System, systemPrereqPaths)
+		if len(prereqFailures) > 0 {
+			printError(fmt.Sprintf("Flash prerequisites not met (%d):", len(prereqFailures)))
+			printPrerequisiteFailures(prereqFailures)
+			// Иначе оператор узнает о непрошиваемой плате только после всех тестов
+			if inputFile == "" && !confirmPrerequisiteFailures(prereqFailures, bufio.NewReader(operatorInput())) {
+				recordAudit("flash_precheck", "", "FAILED", "stopped before tests: flash prerequisites not met")
+				exitSession(1)
+			}
+		} else {
+			printSuccess("✓ Flash prerequisites met")
+		}
+	}
 
 	sessionStart := time.Now()
+	sessionTimer = newPhaseTimer(time.Now)
+	sessionTimer.begin("identification")
 
 	// System identification
 	fmt.Printf("\n%sSYSTEM IDENTIFICATION%s\n", ColorWhite, ColorReset)
@@ -4100,23 +17768,75 @@ func main() {
 	systemInfo, err := getSystemInfo()
 	if err != nil {
 		printError(fmt.Sprintf("Failed to get system information: %v", err))
-		os.Exit(1)
+		exitSession(1)
 	}
 	fmt.Printf("  Product Name      : %s%s%s\n", ColorCyan, systemInfo.Product, ColorReset)
 	fmt.Printf("  Board Serial      : %s%s%s\n", ColorCyan, systemInfo.MBSerial, ColorReset)
+	fmt.Printf("  Board             : %s%s %s%s\n", ColorCyan, systemInfo.BoardManufacturer, systemInfo.BoardVersion, ColorReset)
+	fmt.Printf("  BIOS Version      : %s%s (%s)%s\n", ColorCyan, systemInfo.BIOSVersion, systemInfo.BIOSReleaseDate, ColorReset)
 	fmt.Printf("  Network Address   : %s%s%s\n", ColorCyan, systemInfo.IP, ColorReset)
 	fmt.Printf("  Detection Time    : %s%s%s\n", ColorGray, systemInfo.Timestamp.Format("2006-01-02 15:04:05"), ColorReset)
 
+	systemInfo.Station = collectStationInfo(config.Log)
+	setSessionSerial(systemInfo.MBSerial)
+	setTestSystemInfo(systemInfo)
+	stationID := systemInfo.Station.ID
+	if stationID == "" {
+		stationID = "(not set)"
+	}
+	fmt.Printf("  Station           : %s%s%s %s(%s, firestarter %s)%s\n", ColorCyan, stationID, ColorReset,
+		ColorGray, systemInfo.Station.Hostname, VERSION, ColorReset)
+
+	systemInfo.BMCClock = readBMCClock()
+	if clock := systemInfo.BMCClock; clock != nil {
+		skewColor := ColorGray
+		if clock.SkewSeconds > 60 || clock.SkewSeconds < -60 {
+			skewColor = ColorYellow
+		}
+		fmt.Printf("  BMC Clock         : %s%s (skew %+ds)%s\n", skewColor, clock.Time.Format("2006-01-02 15:04:05"), clock.SkewSeconds, ColorReset)
+	}
+	startSELCollection(systemInfo.BMCClock)
+
+	// Порты до прогона и прошивки: в конце сессии сравниваются (отвалившийся порт, другой драйвер, нет линка)
+	nicBefore, err := captureNICInventory(sysRunner)
+	if err != nil {
+		printWarning(fmt.Sprintf("Network port inventory unavailable: %v", err))
+	} else {
+		fmt.Printf("  Network Ports     : %s%d physical%s\n", ColorCyan, len(nicBefore), ColorReset)
+	}
+
+	systemInfo.Environment = detectRuntimeEnvironment(config.System.LiveMarkerPath)
+	if systemInfo.Environment.Live {
+		fmt.Printf("  Environment       : %sLIVE%s %s(root: %s, boot: %s)%s\n", ColorGreen, ColorReset, ColorGray,
+			systemInfo.Environment.RootSource, systemInfo.Environment.BootDevice, ColorReset)
+	} else {
+		fmt.Printf("  Environment       : %sINSTALLED OS%s %s(root: %s, boot: %s)%s\n", ColorRed, ColorReset, ColorGray,
+			systemInfo.Environment.RootSource, systemInfo.Environment.BootDevice, ColorReset)
+	}
+
+	systemInfo.ToolVersions = collectToolVersions(*config)
+	if len(systemInfo.ToolVersions) > 0 {
+		tools := make([]string, 0, len(systemInfo.ToolVersions))
+		for tool := range systemInfo.ToolVersions {
+			tools = append(tools, tool)
+		}
+		sort.Strings(tools)
+		for _, tool := range tools {
+			printDebug(fmt.Sprintf("%s version: %s", tool, systemInfo.ToolVersions[tool]))
+		}
+	}
+
 	// Product compatibility check
-	if config.System.Product != "" && systemInfo.Product != "" {
-		if config.System.Product != systemInfo.Product {
-			if askUserProductMismatch(config.System.Product, systemInfo.Product) {
+	if identification := identifyProduct(config.System, systemInfo); identification != nil {
+		systemInfo.Identification = identification
+		if !identification.Matched {
+			if askUserProductMismatch(config.System.Product, systemInfo.Product, identification) {
 				printInfo("Program terminated by user due to product mismatch")
-				os.Exit(0)
+				exitSession(0)
 			}
 			fmt.Printf("  Configuration     : %sWARNING - Product mismatch%s\n", ColorYellow, ColorReset)
 		} else {
-			fmt.Printf("  Configuration     : %sCompatible%s\n", ColorGreen, ColorReset)
+			fmt.Printf("  Configuration     : %sCompatible (matched by %s)%s\n", ColorGreen, identification.MatchedBy, ColorReset)
 		}
 	} else {
 		if config.System.Product == "" {
@@ -4136,98 +17856,395 @@ func main() {
 		}
 	}
 
-	var allResults []TestResult
-	var flashResults []FlashResult
-	var flashData *FlashData
-
-	// TESTING PHASE [1/2]
-	if !flashOnly {
-		fmt.Printf("\n%sTESTING PHASE [1/2]%s\n", ColorWhite, ColorReset)
-		printThickSeparator()
+	// Защита от прошивки установленной системы
+	if config.System.RequireLiveEnvironment && !systemInfo.Environment.Live {
+		if testsOnly || !config.Flash.Enabled {
+			printWarning("Not running from live image - allowed because no flashing will be performed")
+		} else {
+			printLiveEnvironmentBanner(systemInfo.Environment)
+			exitSession(exitLiveEnvironmentRequired)
+		}
+	}
 
-		// Count tests
-		totalTests := 0
-		for _, g := range config.Tests.ParallelGroups {
-			totalTests += len(g)
+	// Пакетный режим: только прошивка по строкам CSV
+	if inputFile != "" {
+		if !config.Flash.Enabled {
+			printError("-input-file requires flash.enabled in configuration")
+			exitSession(1)
 		}
-		for _, g := range config.Tests.SequentialGroups {
-			totalTests += len(g)
+		if config.Log.OperatorAuthCommand != "" {
+			operator, err := authenticateOperator(config.Log)
+			if err != nil {
+				printError(fmt.Sprintf("Operator authentication failed: %v", err))
+				exitSession(4)
+			}
+			config.Log.OpName = operator
 		}
-		fmt.Printf("Total Tests: %s%d%s | Global Timeout: %s%s%s\n",
-			ColorGreen, totalTests, ColorReset,
-			ColorYellow, func() string {
-				if config.Tests.Timeout != "" {
-					return config.Tests.Timeout
-				}
-				return "30s (default)"
-			}(), ColorReset)
-
-		// Run tests
-		testsStart := time.Now()
-		for i, g := range config.Tests.ParallelGroups {
-			groupName := fmt.Sprintf("Parallel Group %d", i+1)
-			results := runTestGroup(g, true, outputManager, groupName, config.Tests.Timeout)
-			allResults = append(allResults, results...)
+		if len(prereqFailures) > 0 {
+			printError("Batch mode aborted: flash prerequisites not met")
+			exitSession(1)
 		}
-		for i, g := range config.Tests.SequentialGroups {
-			groupName := fmt.Sprintf("Sequential Group %d", i+1)
-			results := runTestGroup(g, false, outputManager, groupName, config.Tests.Timeout)
-			allResults = append(allResults, results...)
+		failed, err := runBatchMode(config, configPath, inputFile, sessionID, systemInfo)
+		if err != nil {
+			printError(fmt.Sprintf("Batch mode failed: %v", err))
+			exitSession(1)
 		}
-		testsDuration := time.Since(testsStart)
+		if failed > 0 {
+			exitSession(1)
+		}
+		exitSession(0)
+	}
 
-		// Tests summary
-		printTestsSummary(allResults, testsDuration)
+	var allResults []TestResult
+	var flashResults []FlashResult
+	var flashData *FlashData
+	var restoredFlashData *FlashData // Данные прошивки прерванной сессии (-resume)
+	var restoredOperators OperatorPair
 
-		// List failed tests by name
-		var failedNames []string
-		for _, r := range allResults {
-			if r.Status == "FAILED" || r.Status == "TIMEOUT" {
-				failedNames = append(failedNames, r.Name)
+	if interrupted != nil {
+		if interrupted.System.OriginalMBSerial != systemInfo.OriginalMBSerial {
+			printWarning(fmt.Sprintf("Interrupted session %s belongs to board %s, not %s - starting from scratch",
+				interrupted.SessionID, interrupted.System.OriginalMBSerial, systemInfo.OriginalMBSerial))
+		} else {
+			resumedResults = make(map[string]TestResult)
+			for _, r := range interrupted.TestResults {
+				resumedResults[sessionStateKey(r.Group, r.Name)] = r
+			}
+			resumedFlash = make(map[string]FlashResult)
+			for _, r := range interrupted.FlashResults {
+				if r.Status == "PASSED" {
+					resumedFlash[r.Operation] = r
+				}
 			}
+			restoredFlashData = resumedFlashData(*interrupted)
+			restoredOperators = interrupted.Pipeline.Operators
+			printInfo(fmt.Sprintf("Interrupted session %s from %s: %d completed test(s), %d flash operation(s) will not be repeated",
+				interrupted.SessionID, interrupted.Timestamp.Format("2006-01-02 15:04:05"), len(resumedResults), len(resumedFlash)))
 		}
-		if len(failedNames) > 0 {
-			fmt.Printf("%sFailed tests:%s %s\n\n",
-				ColorRed, ColorReset, strings.Join(failedNames, ", "))
+	}
+	if config.Log.SaveLocal {
+		startSessionState(SessionLog{
+			SessionID: sessionID,
+			Timestamp: sessionStart,
+			Pipeline: PipelineInfo{
+				Mode:        "full",
+				Config:      configPath,
+				Operator:    config.Log.OpName,
+				ResumedFrom: resumedFrom(interrupted),
+
+				ConfigSource: stationConfigSource,
+			},
+			System:          systemInfo,
+			TimestampOffset: sessionOffset(sessionStart),
+		}, sessionStateFile(config.Log))
+	}
+
+	// Execution plan
+	plan, err := buildExecutionPlan(*config, configuredFlashOps, testsOnly, flashOnly)
+	if err != nil {
+		printError(fmt.Sprintf("Invalid pipeline configuration: %v", err))
+		exitSession(1)
+	}
+	var planOrder []string
+	lastFlashStep, lastTestsStep := -1, -1
+	for i, step := range plan {
+		planOrder = append(planOrder, step.String())
+		switch step.Kind {
+		case "flash":
+			lastFlashStep = i
+		case "tests":
+			lastTestsStep = i
 		}
 	}
-
-	// FLASH data input
-	if !testsOnly && config.Flash.Enabled {
-		flashData, err = getFlashData(config.Flash, systemInfo.Product)
-		if err != nil {
-			printError(fmt.Sprintf("Failed to get flash data: %v", err))
-			os.Exit(1)
+	testGroups := listTestGroups(config.Tests)
+	if interactiveTests && !flashOnly {
+		if isInteractive() {
+			deselectedTests = selectTestsInteractively(testGroups, bufio.NewReader(operatorInput()))
+			if len(deselectedTests) > 0 {
+				printInfo(fmt.Sprintf("%d test(s) deselected - they will be recorded as SKIPPED", len(deselectedTests)))
+			}
+		} else {
+			printWarning("Non-interactive mode: -interactive-tests ignored, all selected tests run")
+		}
+	}
+	if resumedResults != nil {
+		n, name := firstPendingTest(plan, testGroups)
+		if name != "" {
+			printInfo(fmt.Sprintf("Resuming session from test #%d: %s", n, name))
+		} else {
+			printInfo("Resuming session: all tests were completed before the interruption")
 		}
 	}
 
-	// FLASHING PHASE [2/2]
 	var serialNumberChanged bool = false
-	if !testsOnly && config.Flash.Enabled && flashData != nil {
-		fmt.Printf("\n%sFLASHING PHASE [2/2]%s\n", ColorWhite, ColorReset)
-		printThickSeparator()
-		fmt.Printf("Operations: %s%s%s | Method: %s%s%s\n",
-			ColorYellow, strings.Join(config.Flash.Operations, ", "), ColorReset,
-			ColorGreen, config.Flash.Method, ColorReset)
-		flashResults, serialNumberChanged = runFlashing(config.Flash, flashData, config.System)
+	var flashReview *FlashReview
+	var operators OperatorPair
+	flashDataCollected := false
+	flashBlockedBy := "" // Required тест, провал которого блокирует прошивку
+
+	// SMART дисков: снимок перед первым шагом тестов, сравнение после последнего
+	var smartReport *SMARTReport
+	smartDir := ""
+	if config.Log.SaveLocal {
+		smartDir = filepath.Join(sessionDir(config.Log, sessionID), smartDirName)
+	}
+
+	for i, step := range plan {
+		label := fmt.Sprintf("[%d/%d]", i+1, len(plan))
+
+		setSessionPhase(step.Kind)
+		switch step.Kind {
+		case "tests":
+			if config.Tests.SMART.Enabled && smartReport == nil {
+				sessionTimer.begin("verification: smart snapshot")
+				smartReport = &SMARTReport{}
+				smartReport.Before, smartReport.Files = captureSMART("before", smartDir)
+				printInfo(fmt.Sprintf("SMART snapshot before tests: %d disk(s)", len(smartReport.Before)))
+			}
+			results := runTestsStep(config.Tests, selectTestGroups(testGroups, step.Target), label)
+			if smartReport != nil && i == lastTestsStep {
+				sessionTimer.begin("verification: smart-delta")
+				smartResult := checkSMARTDelta(smartReport, smartDir, config.Tests.SMART)
+				outputManager.PrintResult(time.Now(), smartResult.Name, smartResult.Status, smartResult.Duration, smartResult.Error)
+				if smartResult.Output != "" {
+					outputManager.PrintSection(smartResult.Name+" Output", smartResult.Description, smartResult.Output, smartResult.Status)
+				}
+				publishTestResult(smartResult)
+				results = append(results, smartResult)
+			}
+			allResults = append(allResults, results...)
+			if name := requiredTestFailure(results); name != "" && blockFlashOnRequiredFailure && flashBlockedBy == "" {
+				flashBlockedBy = name
+				printError(fmt.Sprintf("Required test '%s' failed - flashing is blocked for this unit", name))
+			}
+
+		case "flash":
+			if flashBlockedBy != "" {
+				ops := config.Flash.Operations
+				if step.Target != "" {
+					ops = []string{step.Target}
+				}
+				for _, op := range ops {
+					flashResults = append(flashResults, FlashResult{
+						Operation: op,
+						Status:    "SKIPPED",
+						Details:   flashBlockedPrefix + flashBlockedBy,
+					})
+					recordAudit("flash_"+op, "", "SKIPPED", flashBlockedPrefix+flashBlockedBy)
+				}
+				printWarning(fmt.Sprintf("Flash step %s skipped: %s%s", step.String(), flashBlockedPrefix, flashBlockedBy))
+				continue
+			}
+			stepOps := config.Flash.Operations
+			if step.Target != "" {
+				stepOps = []string{step.Target}
+			}
+			// Пропускаются только операции, которым мешают непройденные проверки
+			var runnableOps []string
+			for _, op := range stepOps {
+				checks := prereqBlockingChecks(prereqFailures, op)
+				if len(checks) == 0 {
+					runnableOps = append(runnableOps, op)
+					continue
+				}
+				details := flashPrecheckPrefix + strings.Join(checks, ", ")
+				flashResults = append(flashResults, FlashResult{Operation: op, Status: "SKIPPED", Details: details})
+				recordAudit("flash_"+op, "", "SKIPPED", details)
+				printWarning(fmt.Sprintf("Flash operation %s skipped: %s", op, details))
+			}
+			if len(runnableOps) == 0 {
+				continue
+			}
+
+			// FLASH data input - один раз перед первым шагом прошивки
+			if !flashDataCollected {
+				flashDataCollected = true
+				sessionTimer.begin("flash data entry")
+				if config.Log.OperatorAuthCommand != "" {
+					operator, err := authenticateOperator(config.Log)
+					if err != nil {
+						printError(fmt.Sprintf("Operator authentication failed: %v", err))
+						exitSession(4)
+					}
+					config.Log.OpName = operator // В лог пишем подтвержденного оператора
+				}
+				if restoredFlashData != nil {
+					// Данные уже подтверждены в прерванной сессии - повторный ввод мог бы дать другие значения
+					flashData = restoredFlashData
+					flashReview = flashData.Review
+					operators = restoredOperators
+					setSessionSerial(flashData.SystemSerial)
+					printInfo(fmt.Sprintf("Flash data restored from interrupted session %s - not prompted again", interrupted.SessionID))
+				} else {
+					// Ввод и проверка данных оператором целиком - ожидание оператора
+					stopWait := sessionTimer.operatorWait()
+					// Поля только для заблокированных проверками операций не спрашиваются
+					dataFlash := config.Flash
+					dataFlash.Operations = nil
+					for _, op := range config.Flash.Operations {
+						if len(prereqBlockingChecks(prereqFailures, op)) == 0 {
+							dataFlash.Operations = append(dataFlash.Operations, op)
+						}
+					}
+					flashData, err = getFlashData(dataFlash, config.System, systemInfo, nil)
+					stopWait()
+					if flashData != nil {
+						flashReview = flashData.Review
+						setSessionSerial(flashData.SystemSerial)
+					}
+					if errors.Is(err, errFlashAborted) {
+						printWarning("Flashing aborted by operator - no hardware will be modified")
+						flashData = nil
+					} else if err != nil {
+						printError(fmt.Sprintf("Failed to get flash data: %v", err))
+						exitSession(1)
+					}
+				}
+
+				// Второй оператор подтверждает введенные данные
+				if flashData != nil && restoredFlashData == nil && config.Flash.RequireDualOperator {
+					operators = OperatorPair{config.Log.OpName}
+					if !isInteractive() {
+						printWarning("Non-interactive mode: dual operator confirmation skipped")
+					} else if second, err := confirmSecondOperator(config.Log.OpName, config.Flash.OperatorPattern); err != nil {
+						printError(fmt.Sprintf("Dual operator confirmation failed: %v", err))
+						printWarning("Flashing aborted - no hardware will be modified")
+						flashData = nil
+					} else {
+						operators[1] = second
+					}
+				}
+				if flashData != nil {
+					recordSessionFlashData(flashReview, flashData.Variant, operators)
+				}
+			}
+			if flashData == nil {
+				continue
+			}
+
+			stepFlash := config.Flash
+			stepFlash.Operations = runnableOps
+			var done []FlashResult
+			stepFlash.Operations, done = splitResumedFlash(stepFlash.Operations)
+			flashResults = append(flashResults, done...)
+			recordSessionFlash(done)
+			if flashSerialChanged(done) {
+				// Перезагрузка после прерванной прошивки серийного номера еще не выполнена
+				serialNumberChanged = true
+			}
+			if len(stepFlash.Operations) == 0 {
+				continue
+			}
+
+			fmt.Printf("\n%sFLASHING PHASE %s%s\n", ColorWhite, label, ColorReset)
+			printThickSeparator()
+			fmt.Printf("Operations: %s%s%s | Method: %s%s%s\n",
+				ColorYellow, strings.Join(stepFlash.Operations, ", "), ColorReset,
+				ColorGreen, config.Flash.Method, ColorReset)
+			results, changed := runFlashing(stepFlash, flashData, config.System, auditSession.LogDir)
+			flashResults = append(flashResults, results...)
+			recordSessionFlash(results)
+			applyFlashedValues(flashData, results)
+			if changed {
+				serialNumberChanged = true
+			}
+
+			// Проверочные тесты сразу после последнего шага прошивки
+			if i == lastFlashStep && len(config.Flash.PostFlashTests) > 0 {
+				// Прошивка завершена - блокировать больше нечего
+				blockFlashOnRequiredFailure = false
+				sessionTimer.begin("verification: post-flash tests")
+				postResults := runTestGroup(config.Flash.PostFlashTests, false, outputManager, "POST-FLASH VERIFICATION", config.Tests.Timeout, 0)
+				sessionTimer.setSlowest("verification: post-flash tests", postResults)
+				for i := range postResults {
+					postResults[i].Phase = "post-flash"
+					postResults[i].Group = "post-flash"
+				}
+				allResults = append(allResults, postResults...)
+			}
+		}
 	}
 
+	setSessionPhase("finishing")
+	sessionTimer.begin("saving logs")
+
 	// Session duration
 	totalDuration := time.Since(sessionStart)
 
 	// Вычисляем общий статус сессии
+	// Сверка портов после всех прошивок и перезагрузок драйверов
+	var nicConsistency *NICConsistency
+	if len(nicBefore) > 0 {
+		var nicResult TestResult
+		nicConsistency, nicResult = checkNICConsistency(nicBefore, flashedMAC)
+		outputManager.PrintResult(time.Now(), nicResult.Name, nicResult.Status, nicResult.Duration, nicResult.Error)
+		if nicResult.Output != "" {
+			outputManager.PrintSection(nicResult.Name+" Output", nicResult.Description, nicResult.Output, nicResult.Status)
+		}
+		publishTestResult(nicResult)
+		allResults = append(allResults, nicResult)
+	}
+
 	sessionState := calculateSessionState(allResults, flashResults)
 
+	// Полный вывод каждого теста в отдельный файл
+	var artifacts []string
+	if config.Log.SaveLocal {
+		// Развернутый конфиг (с подставленными include/test_library) - что реально выполнялось
+		effectivePath := filepath.Join(sessionDir(config.Log, sessionID), "effective_config.yaml")
+		if err := writeEffectiveConfig(config, effectivePath); err != nil {
+			printError(fmt.Sprintf("Failed to write effective config: %v", err))
+		} else {
+			artifacts = append(artifacts, effectivePath)
+		}
+	}
+	if config.Log.SaveLocal && len(allResults) > 0 {
+		if err := writeTestLogs(allResults, sessionDir(config.Log, sessionID), config.Log.SplitAttempts); err != nil {
+			printError(fmt.Sprintf("Failed to write test logs: %v", err))
+		} else {
+			artifacts = append(artifacts, filepath.Join(sessionDir(config.Log, sessionID), "tests"))
+		}
+	}
+	if smartReport != nil && len(smartReport.Files) > 0 {
+		artifacts = append(artifacts, smartDir)
+	}
+
+	// Записи SEL за сессию (перегрев, ECC во время прогона)
+	selEvents := finishSELCollection()
+
 	// Save & send logs
+	pipelineInfo := PipelineInfo{
+		Mode:               "full",
+		Config:             configPath,
+		Duration:           totalDuration,
+		Operator:           config.Log.OpName,
+		Order:              planOrder,
+		ConfiguredFlashOps: configuredFlashOps,
+		FlashOps:           config.Flash.Operations,
+		Tags:               tagSelection,
+		Operators:          operators,
+		ResumedFrom:        resumedFrom(interrupted),
+		ConfigSource:       stationConfigSource,
+	}
 	sessionLog := SessionLog{
-		SessionID:    fmt.Sprintf("%d", time.Now().Unix()),
+		SessionID:    sessionID,
 		Timestamp:    sessionStart,
 		State:        sessionState,
-		Pipeline:     PipelineInfo{Mode: "full", Config: configPath, Duration: totalDuration, Operator: config.Log.OpName},
+		Pipeline:     pipelineInfo,
 		TestResults:  allResults, // Перенесено выше системной информации
 		FlashResults: flashResults,
+		FlashReview:  flashReview,
+		SELEvents:    selEvents,
 		System:       systemInfo, // Остается внизу, но выше dmidecode
+
+		NICConsistency: nicConsistency,
+		SMART:          smartReport,
+
+		PrerequisiteFailures: prereqFailures,
+
+		TimestampOffset: sessionOffset(sessionStart),
 	}
+	annotateClockCheck(&sessionLog, sessionClock)
 
 	if flashData != nil {
 		// Прошитые значения записываем в основные поля
@@ -4240,6 +18257,7 @@ func main() {
 		if flashData.MAC != "" {
 			sessionLog.System.MAC = flashData.MAC
 		}
+		sessionLog.Variant = flashData.Variant
 
 		printInfo("Log will include both original and flashed values")
 		printInfo(fmt.Sprintf("  Original MB Serial: %s -> Flashed: %s",
@@ -4252,24 +18270,118 @@ func main() {
 		printInfo("No flashing performed - only original values will be logged")
 	}
 
-	if err := saveLog(sessionLog, config.Log); err != nil {
+	if config.Log.SendLogs && config.Log.Server != "" {
+		// Проверка прямо перед загрузкой: после прошивки MAC интерфейсы и маршруты могли поменяться
+		check := checkUploadPath(config.Log)
+		sessionLog.Upload = &check
+	}
+
+	// Тесты, которым нужен новый серийный номер, продолжаются после перезагрузки (-continue)
+	continuation := serialNumberChanged && len(config.Tests.PostRebootGroups) > 0
+	if continuation {
+		sessionLog.Pipeline.PostRebootPending = true
+	}
+
+	// Решение о питании попадает в лог до того, как лог сохранен и выгружен
+	finishMode := config.Pipeline.FinishAction
+	if finishMode == "" {
+		finishMode = finishPrompt
+	}
+	sessionLog.Pipeline.FinishAction = finishMode
+	sessionLog.Pipeline.FinishActionOverridden = finishOverridden
+	if finishMode != finishPrompt {
+		sessionLog.Pipeline.FinishPowerAction = resolveFinishAction(finishMode, serialNumberChanged)
+	}
+
+	// Выгрузка идет после сохранения лога, поэтому в лог она не попадает (только в итоги на экране)
+	sessionLog.Timings = sessionTimer.timings()
+	savedLog, err := saveLog(sessionLog, config.Log)
+	if err != nil {
 		printError(fmt.Sprintf("Failed to save log: %v", err))
+	} else {
+		clearSessionState()
+	}
+	if config.Log.Manifest {
+		if err := recordManifest(config.Log, sessionLog, savedLog, time.Now()); err != nil {
+			printWarning(fmt.Sprintf("Session not recorded in manifest: %v", err))
+		}
+	}
+	if continuation {
+		path, err := writeContinuation(config.Log, Continuation{
+			CreatedAt:  time.Now(),
+			Serial:     sessionLog.System.MBSerial,
+			ConfigPath: configPath,
+			SavedLog:   savedLog,
+			Session:    sessionLog,
+		})
+		if err != nil {
+			printError(fmt.Sprintf("Failed to save session continuation: %v", err))
+			continuation = false
+		} else {
+			printInfo(fmt.Sprintf("Session continuation saved: %s", path))
+		}
+	}
+	if _, err := pruneLogs(config.Log, time.Now(), sessionID, savedLog); err != nil {
+		printWarning(err.Error())
+	}
+	if config.Log.HTMLReport {
+		if reportPath, err := writeHTMLReport(sessionLog, config.Log); err != nil {
+			printError(fmt.Sprintf("Failed to generate HTML report: %v", err))
+		} else {
+			printSuccess(fmt.Sprintf("HTML report saved: %s", reportPath))
+			artifacts = append(artifacts, reportPath)
+		}
 	}
+	if transcript != nil {
+		transcript.Sync()
+		artifacts = append(artifacts, transcript.path)
+	}
+	if commandTrace != nil {
+		// Выгрузка сама вызывает ssh/scp: трассировка закрывается до нее, иначе файл меняется во время проверки суммы
+		artifacts = append(artifacts, commandTrace.path, commandTrace.scriptPath)
+		commandTrace.Close()
+		commandTrace = nil
+	}
+	// Выгрузка идет в фоне, пока оператор смотрит итоги; ждем ее не дольше log.upload_wait_timeout
+	var uploadErr error
+	var upload *backgroundUpload
 	if config.Log.SendLogs {
-		if err := sendLogToServer(sessionLog, config.Log); err != nil {
-			printError(fmt.Sprintf("Failed to send log to server: %v", err))
+		if upload, uploadErr = asyncLogUpload(sessionLog, config.Log, artifacts); uploadErr != nil {
+			printError(fmt.Sprintf("Failed to send log to server: %v", uploadErr))
+		} else {
+			uploadErr = errUploadInProgress
 		}
 	} else {
 		printInfo("Log sending disabled (send_logs: false)")
 	}
 
 	// Final summary
-	printExecutionSummary(allResults, flashResults, totalDuration)
+	outputManager.DisableCompact()
+	printExecutionSummary(allResults, flashResults, totalDuration, sessionLog.Upload, uploadErr, sessionLog.SELEvents)
+	printTimingBreakdown(sessionTimer.timings())
+
+	if upload != nil {
+		sessionTimer.begin("log upload")
+		select {
+		case uploadErr = <-upload.Done:
+			upload.flush()
+			if uploadErr != nil {
+				printError(fmt.Sprintf("Failed to send log to server: %v", uploadErr))
+			}
+		case <-time.After(uploadWaitTimeout(config.Log)):
+			upload.abandon()
+			printWarning("Log upload still in progress, not waiting - the log stays in outbox until it is uploaded")
+			if savedLog != "" {
+				printInfo(fmt.Sprintf("Local log: %s", savedLog))
+			}
+		}
+		sessionTimer.end()
+	}
 
 	// Exit code
 	exitCode := 0
 	for _, r := range allResults {
-		if r.Status == "FAILED" && r.Required {
+		if (r.Status == "FAILED" && r.Required) || isPostFlashFailure(r) {
 			exitCode = 1
 			break
 		}
@@ -4281,62 +18393,22 @@ func main() {
 		}
 	}
 	if exitCode != 0 {
-		fmt.Printf("\n%sExiting with error code %d due to failed critical operations%s\n",
-			ColorRed, exitCode, ColorReset)
+		fmt.Printf("\n%s%s%s\n", ColorRed, tr("summary.exit_code", exitCode), ColorReset)
 	}
 
-	reader := bufio.NewReader(os.Stdin)
-
-	if serialNumberChanged {
-		// Серийный номер был изменен - требуется перезагрузка
-		fmt.Printf("\n%sSerial number was updated. System reboot is required for changes to take effect.%s\n", ColorYellow, ColorReset)
-		fmt.Printf("%sDo you want to reboot the system now?%s %s[Y/n]%s: ", ColorWhite, ColorReset, ColorGreen, ColorReset)
-
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			input = "Y"
-		}
-		input = strings.TrimSpace(strings.ToUpper(input))
-
-		if input == "" || input == "Y" || input == "YES" {
-			printInfo("Preparing system for reboot...")
-
-			if err := bootctl(); err != nil {
-				printError("Bootctl error: " + err.Error())
-				os.Exit(1)
-			}
-
-			printSuccess("System will reboot now...")
-			if err := exec.Command("reboot").Run(); err != nil {
-				printError(fmt.Sprintf("Failed to reboot: %v", err))
-				os.Exit(1)
-			}
-		} else {
-			printInfo("Reboot cancelled by user.")
-			printWarning("Note: Serial number changes require a reboot to take effect.")
-		}
-	} else {
-		// Серийный номер не изменялся - можно просто выключить
-		fmt.Printf("\n%sNo serial number changes were made. System can be safely shut down.%s\n", ColorBlue, ColorReset)
-		fmt.Printf("%sDo you want to shutdown the system now?%s %s[Y/n]%s: ", ColorWhite, ColorReset, ColorGreen, ColorReset)
-
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			input = "Y"
+	if config.Log.SendLogs && config.Log.Server != "" {
+		if _, err := flushOutbox(config.Log); err != nil {
+			printWarning(fmt.Sprintf("Outbox not flushed: %v", err))
 		}
-		input = strings.TrimSpace(strings.ToUpper(input))
-
-		if input == "" || input == "Y" || input == "YES" {
-			printInfo("Preparing system for shutdown...")
-			printSuccess("System will shutdown now...")
-			if err := exec.Command("shutdown", "-h", "now").Run(); err != nil {
-				printError(fmt.Sprintf("Failed to shutdown: %v", err))
-				os.Exit(1)
-			}
-		} else {
-			printInfo("Shutdown cancelled by user.")
+	}
+	if config.Log.Manifest && config.Log.SendLogs && config.Log.Server != "" {
+		if _, err := uploadManifests(config.Log, time.Now(), false); err != nil {
+			printWarning(fmt.Sprintf("Manifest upload failed: %v", err))
 		}
 	}
 
-	os.Exit(exitCode)
+	// Питание трогаем только здесь: лог сохранен, выгрузка завершена или ждать ее дальше не стали, транскрипт сброшен
+	runFinishAction(sessionLog.Pipeline, serialNumberChanged, continuation, len(config.Tests.PostRebootGroups), finishCountdown(config.Pipeline))
+
+	exitSession(exitCode)
 }
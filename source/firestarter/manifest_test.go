@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func readManifest(t *testing.T, config LogConfig, day time.Time) Manifest {
+	t.Helper()
+	var m Manifest
+	data, err := os.ReadFile(filepath.Join(config.LogDir, manifestFileName(day)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func manifestRecord(t *testing.T, m Manifest, sessionID string) ManifestRecord {
+	t.Helper()
+	for _, r := range m.Sessions {
+		if r.SessionID == sessionID {
+			return r
+		}
+	}
+	t.Fatalf("session %s not in manifest %s", sessionID, m.Date)
+	return ManifestRecord{}
+}
+
+// Несколько терминалов станции пишут в один манифест одновременно
+func TestRecordManifestConcurrentAppends(t *testing.T) {
+	config := LogConfig{LogDir: t.TempDir(), Manifest: true}
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.Local)
+
+	const sessions = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, sessions)
+	for i := 0; i < sessions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			log := redactedSession()
+			log.SessionID = fmt.Sprintf("s%02d", i)
+			errs <- recordManifest(config, log, "", now)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := readManifest(t, config, now)
+	if len(m.Sessions) != sessions {
+		t.Fatalf("%d session(s) in the manifest, want %d", len(m.Sessions), sessions)
+	}
+	seen := make(map[string]bool)
+	for _, r := range m.Sessions {
+		seen[r.SessionID] = true
+	}
+	if len(seen) != sessions {
+		t.Fatalf("duplicate records: %+v", m.Sessions)
+	}
+}
+
+// Сессия закончилась до полуночи, выгрузка - после: статус меняется в манифесте вчерашнего дня
+func TestManifestMidnightRollover(t *testing.T) {
+	config := LogConfig{LogDir: t.TempDir(), Manifest: true, SendLogs: true, Server: "user@host"}
+	before := time.Date(2026, 3, 4, 23, 59, 59, 0, time.Local)
+	after := before.Add(2 * time.Second)
+
+	log := redactedSession()
+	if err := recordManifest(config, log, "", before); err != nil {
+		t.Fatal(err)
+	}
+	late := redactedSession()
+	late.SessionID = "after-midnight"
+	if err := recordManifest(config, late, "", after); err != nil {
+		t.Fatal(err)
+	}
+
+	updateManifestUpload(config, log.SessionID, manifestUploaded, nil)
+	if r := manifestRecord(t, readManifest(t, config, before), log.SessionID); r.Upload != manifestUploaded || r.UploadedAt == nil {
+		t.Errorf("yesterday's record: %+v", r)
+	}
+	today := readManifest(t, config, after)
+	if len(today.Sessions) != 1 || today.Sessions[0].SessionID != "after-midnight" || today.Sessions[0].Upload != manifestUploadPending {
+		t.Errorf("today's manifest: %+v", today.Sessions)
+	}
+}
+
+func TestManifestRedactsSerial(t *testing.T) {
+	config := LogConfig{LogDir: t.TempDir(), Manifest: true,
+		Redact: RedactConfig{Fields: []string{redactSerials}, Salt: "salt"}}
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.Local)
+	log := redactedSession()
+	if err := recordManifest(config, log, "", now); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(config.LogDir, manifestFileName(now)))
+	if strings.Contains(string(data), "MB123456") {
+		t.Fatalf("raw serial in the manifest:\n%s", data)
+	}
+	redactor := newLogRedactor(config.Redact)
+	r := manifestRecord(t, readManifest(t, config, now), log.SessionID)
+	if r.Serial != redactor.replacement("MB123456", redactSerials, false) || r.LogFile != redactor.logFileName(log) {
+		t.Errorf("record %+v", r)
+	}
+}
+
+// Выгрузка, брошенная по upload_wait_timeout, заканчивается в фоне и отмечается в манифесте
+func TestManifestStatusAfterDelayedUpload(t *testing.T) {
+	fakeRemote(t, "0.5")
+	config, log := uploadTestConfig(t)
+	config.Manifest = true
+	now := time.Now()
+	if err := recordManifest(config, log, "", now); err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := asyncLogUpload(log, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-upload.Done:
+		t.Fatal("upload finished before the wait timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if r := manifestRecord(t, readManifest(t, config, now), log.SessionID); r.Upload != manifestUploadPending {
+		t.Errorf("during the upload: %+v", r)
+	}
+	if err := <-upload.Done; err != nil {
+		t.Fatal(err)
+	}
+	if r := manifestRecord(t, readManifest(t, config, now), log.SessionID); r.Upload != manifestUploaded || r.UploadedAt == nil {
+		t.Errorf("after the upload: %+v", r)
+	}
+}
+
+// main перестал ждать выгрузку: горутина больше не пишет в консоль, outbox и манифест, лог ждет flushOutbox
+func TestAbandonedUploadStaysQuiet(t *testing.T) {
+	fakeRemote(t, "0.5")
+	config, log := uploadTestConfig(t)
+	config.Manifest = true
+	now := time.Now()
+	if err := recordManifest(config, log, "", now); err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := asyncLogUpload(log, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Лог main меняется дальше - у горутины своя копия
+	log.TestResults[0].Error = "changed after upload start"
+	time.Sleep(100 * time.Millisecond)
+
+	var uploadErr error
+	before := captureStdout(t, func() {
+		upload.abandon()
+		uploadErr = <-upload.Done
+	})
+	if uploadErr != nil {
+		t.Fatal(uploadErr)
+	}
+	if !strings.Contains(before, "Sending log to server") {
+		t.Errorf("messages before the timeout not printed on abandon:\n%s", before)
+	}
+	if strings.Contains(before, "Log successfully sent") {
+		t.Errorf("upload printed after it was abandoned:\n%s", before)
+	}
+	if out := captureStdout(t, upload.flush); out != "" {
+		t.Errorf("messages kept after abandon:\n%s", out)
+	}
+
+	if r := manifestRecord(t, readManifest(t, config, now), log.SessionID); r.Upload != manifestUploadPending {
+		t.Errorf("manifest changed after abandon: %+v", r)
+	}
+	if files := outboxFiles(t, config); len(files) == 0 {
+		t.Error("log removed from outbox after abandon")
+	}
+	data, err := os.ReadFile(filepath.Join(config.ServerDir, "TEST", sessionLogFileName(log)))
+	if err != nil {
+		t.Fatalf("log not on the server: %v", err)
+	}
+	if strings.Contains(string(data), "changed after upload start") {
+		t.Error("upload shares the session log with main")
+	}
+}
+
+// Процесс завершился посреди выгрузки: лог из outbox уходит при следующем сбросе
+func TestFlushOutboxUpdatesManifest(t *testing.T) {
+	fakeRemote(t, "0")
+	config, log := uploadTestConfig(t)
+	config.Manifest = true
+	now := time.Now()
+	if err := recordManifest(config, log, "", now); err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := sessionLogFileName(log)
+	path, err := spoolLog(data, name, config, errUploadInProgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока процесс, положивший лог, жив, его выгрузку не трогаем
+	if n, err := flushOutbox(config); err != nil || n != 0 {
+		t.Fatalf("flushed a log of a live process: %d, %v", n, err)
+	}
+
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+	note := fmt.Sprintf("pid: %d\nreason: %v\n", dead.Process.Pid, errUploadInProgress)
+	if err := os.WriteFile(path+".note.txt", []byte(note), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := flushOutbox(config)
+	if err != nil || n != 1 {
+		t.Fatalf("flushOutbox: %d, %v", n, err)
+	}
+	if files := outboxFiles(t, config); len(files) != 0 {
+		t.Errorf("outbox after flush: %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(config.ServerDir, "TEST", name)); err != nil {
+		t.Errorf("log not on the server: %v", err)
+	}
+	if r := manifestRecord(t, readManifest(t, config, now), log.SessionID); r.Upload != manifestUploaded {
+		t.Errorf("manifest: %+v", r)
+	}
+}
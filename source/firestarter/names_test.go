@@ -0,0 +1,152 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// safeFileName - только символы, которые не нужно экранировать ни в shell, ни в пути
+var safeFileName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// hostileNames - значения из FRU, ввода оператора и конфига, которые не должны попасть в путь как есть
+var hostileNames = []string{
+	"Acme R2100",
+	"$(reboot)",
+	"`id`; rm -rf /",
+	"../../etc/passwd",
+	"..",
+	"-rf",
+	"серийный номер",
+	"  \t\n ",
+	"a/b\\c:d*e?f\"g<h>i|j",
+	strings.Repeat("S", 300),
+	strings.Repeat("Ж", 150),
+}
+
+func TestSanitizeFileNameHostile(t *testing.T) {
+	for _, value := range hostileNames {
+		got := sanitizeFileName(value)
+		if !safeFileName.MatchString(got) {
+			t.Errorf("%q -> %q: unsafe", value, got)
+		}
+		if len(got) > maxFileNameComponent {
+			t.Errorf("%q -> %q: %d bytes, limit %d", value, got, len(got), maxFileNameComponent)
+		}
+	}
+	for value, want := range map[string]string{
+		"Acme R2100": "Acme_R2100",
+		"$(reboot)":  "__reboot_",
+		"..":         "unknown",
+		"  \t\n ":    "unknown",
+		"-rf":        "rf",
+		"SN-01.A_b":  "SN-01.A_b",
+	} {
+		if got := sanitizeFileName(value); got != want {
+			t.Errorf("%q -> %q, want %q", value, got, want)
+		}
+	}
+
+	// Обрезанные значения с общим началом не совпадают
+	long1 := sanitizeFileName(strings.Repeat("S", 300))
+	long2 := sanitizeFileName(strings.Repeat("S", 299) + "T")
+	if long1 == long2 {
+		t.Errorf("truncated serials collide: %s", long1)
+	}
+	if long1 != sanitizeFileName(strings.Repeat("S", 300)) {
+		t.Error("truncation is not deterministic")
+	}
+}
+
+func TestSessionLogFileNameHostile(t *testing.T) {
+	for _, value := range hostileNames {
+		log := SessionLog{
+			SessionID: "20260101-abcd",
+			Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+			State:     value,
+		}
+		log.System.Product = value
+		log.System.MBSerial = value
+		name := sessionLogFileName(log)
+		if !safeFileName.MatchString(name) || !strings.HasSuffix(name, ".yaml") {
+			t.Errorf("%q -> %q: unsafe", value, name)
+		}
+		if len(name) > 255 {
+			t.Errorf("%q -> %d bytes", value, len(name))
+		}
+	}
+
+	// Без серийного номера - ID сессии, а не "unknown": имена разных сессий не совпадают
+	log := SessionLog{SessionID: "20260101-abcd", Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), State: "completed"}
+	log.System.Product = "$(reboot)"
+	log.System.MBSerial = "   "
+	if name := sessionLogFileName(log); name != "__reboot__session-20260101-abcd_20260102_030405_completed.yaml" {
+		t.Errorf("no serial: %s", name)
+	}
+}
+
+func TestRemoteLogDirHostile(t *testing.T) {
+	for _, value := range hostileNames {
+		var log SessionLog
+		log.System.Product = value
+		log.System.Station = StationInfo{ID: value}
+		dir := remoteLogDir(LogConfig{ServerDir: "/srv/logs", GroupByStation: true, OpName: value}, log)
+		rest, ok := strings.CutPrefix(dir, "/srv/logs/")
+		if !ok {
+			t.Errorf("%q -> %q: outside server_dir", value, dir)
+			continue
+		}
+		parts := strings.Split(rest, "/")
+		if len(parts) > 3 {
+			t.Errorf("%q -> %q: extra path levels", value, dir)
+		}
+		for _, part := range parts {
+			if !safeFileName.MatchString(part) || len(part) > maxFileNameComponent {
+				t.Errorf("%q -> %q: unsafe component %q", value, dir, part)
+			}
+		}
+	}
+	if dir := remoteLogDir(LogConfig{}, SessionLog{}); dir != "." {
+		t.Errorf("empty config: %q", dir)
+	}
+}
+
+// Файлы вывода тестов остаются внутри tests/ при любых именах теста и группы
+func TestWriteTestLogsHostileNames(t *testing.T) {
+	var results []TestResult
+	for _, value := range hostileNames {
+		results = append(results, TestResult{Name: value, Group: value, Status: "PASSED"})
+	}
+	// Одинаковые имена дополняются группой
+	results = append(results, TestResult{Name: "$(reboot)", Group: "../up", Status: "FAILED"})
+
+	dir := t.TempDir()
+	if err := writeTestLogs(results, dir, false); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, r := range results {
+		file, ok := strings.CutPrefix(r.OutputFile, "tests"+string(filepath.Separator))
+		if !ok || strings.ContainsRune(file, filepath.Separator) {
+			t.Errorf("%q: output file %q outside tests/", r.Name, r.OutputFile)
+			continue
+		}
+		if !safeFileName.MatchString(file) || len(file) > 255 {
+			t.Errorf("%q: unsafe output file %q", r.Name, file)
+		}
+		if seen[file] {
+			t.Errorf("%q: output file %q reused", r.Name, file)
+		}
+		seen[file] = true
+		if _, err := os.Stat(filepath.Join(dir, r.OutputFile)); err != nil {
+			t.Error(err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "tests" {
+		t.Errorf("files written outside tests/: %v %v", entries, err)
+	}
+}
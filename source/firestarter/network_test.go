@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fakeRunner - подставной CommandRunner: вывод команд, файлы и ссылки /proc и /sys из карт.
+// Вызовы команд записываются в calls
+type fakeRunner struct {
+	mutex    sync.Mutex
+	commands map[string]string // "lspci -nk" -> stdout; нет в карте - ошибка
+	files    map[string]string
+	links    map[string]string
+	calls    []string
+	// exec отвечает на Exec и может менять состояние (files); nil - как Run
+	exec func(command string) (string, error)
+}
+
+func (f *fakeRunner) Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if f.exec == nil {
+		return f.Run(name, args...)
+	}
+	command := strings.Join(append([]string{name}, args...), " ")
+	f.mutex.Lock()
+	f.calls = append(f.calls, command)
+	f.mutex.Unlock()
+	output, err := f.exec(command)
+	return []byte(output), err
+}
+
+func (f *fakeRunner) setFile(path, data string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if data == "" {
+		delete(f.files, path)
+		return
+	}
+	f.files[path] = data
+}
+
+func (f *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls = append(f.calls, command)
+	output, ok := f.commands[command]
+	if !ok {
+		return nil, fmt.Errorf("%s: not found", name)
+	}
+	return []byte(output), nil
+}
+
+func (f *fakeRunner) ReadFile(path string) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	data, ok := f.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(data), nil
+}
+
+// ReadDir - непосредственные потомки каталога среди путей файлов и ссылок
+func (f *fakeRunner) ReadDir(dir string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	seen := make(map[string]bool)
+	for _, paths := range []map[string]string{f.files, f.links} {
+		for p := range paths {
+			if rest, ok := strings.CutPrefix(p, dir+"/"); ok {
+				seen[strings.Split(rest, "/")[0]] = true
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil, os.ErrNotExist
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (f *fakeRunner) Readlink(path string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	link, ok := f.links[path]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	return link, nil
+}
+
+// useRunner подменяет sysRunner на время теста
+func useRunner(t *testing.T, cmdRunner CommandRunner) {
+	t.Helper()
+	saved := sysRunner
+	sysRunner = cmdRunner
+	t.Cleanup(func() { sysRunner = saved })
+}
+
+func testdataFile(t *testing.T, name ...string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(append([]string{"testdata"}, name...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// Порт без драйвера и карта под vfio интерфейсов не создадут и в ожидаемое число не входят
+func TestParseLspciNetworkFunctions(t *testing.T) {
+	if got := parseLspciNetworkFunctions(testdataFile(t, "lspci", "nk_network.txt")); got != 2 {
+		t.Errorf("%d network function(s) with a driver, want 2 (igb and iwlwifi)", got)
+	}
+}
+
+func TestCountNetworkPCIFunctions(t *testing.T) {
+	devices := "/sys/bus/pci/devices"
+	runner := &fakeRunner{
+		files: map[string]string{
+			path.Join(devices, "0000:00:00.0", "class"): "0x060000\n",
+			path.Join(devices, "0000:02:00.0", "class"): "0x020000\n",
+			path.Join(devices, "0000:02:00.1", "class"): "0x020000\n",
+			path.Join(devices, "0000:04:00.0", "class"): "0x020000\n",
+		},
+		links: map[string]string{
+			path.Join(devices, "0000:00:00.0", "driver"): "../../../bus/pci/drivers/skl_uncore",
+			path.Join(devices, "0000:02:00.0", "driver"): "../../../bus/pci/drivers/igb",
+			path.Join(devices, "0000:04:00.0", "driver"): "../../../bus/pci/drivers/vfio-pci",
+		},
+	}
+	useRunner(t, runner)
+	if got := countNetworkPCIFunctions(); got != 1 {
+		t.Errorf("sysfs: %d, want 1", got)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("lspci run with sysfs available: %v", runner.calls)
+	}
+
+	// Без sysfs - lspci -nk
+	useRunner(t, &fakeRunner{commands: map[string]string{"lspci -nk": testdataFile(t, "lspci", "nk_network.txt")}})
+	if got := countNetworkPCIFunctions(); got != 2 {
+		t.Errorf("lspci: %d, want 2", got)
+	}
+	useRunner(t, &fakeRunner{})
+	if got := countNetworkPCIFunctions(); got != 0 {
+		t.Errorf("nothing available: %d", got)
+	}
+}
+
+func describeInterfaces(interfaces []NetworkInterface) string {
+	var lines []string
+	for _, iface := range interfaces {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s %s %s %s", iface.Name, iface.State, iface.MAC, iface.IP)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// VLAN и bond: имя без @parent, у bond и VLAN MAC первого порта, адрес на bond и VLAN, а не на портах
+const vlanBondInterfaces = `lo
+eno1 UP A0:36:9F:00:00:01
+eno2 UP A0:36:9F:00:00:01
+enp4s0 DOWN A0:36:9F:00:00:03
+bond0 UP A0:36:9F:00:00:01 10.0.0.5
+bond0.100 UP A0:36:9F:00:00:01 10.0.100.5`
+
+func TestParseIPAddrJSONVLANBond(t *testing.T) {
+	interfaces, err := parseIPAddrJSON([]byte(testdataFile(t, "ip", "addr_vlan_bond.json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describeInterfaces(interfaces); got != vlanBondInterfaces {
+		t.Errorf("interfaces:\n%s\nwant:\n%s", got, vlanBondInterfaces)
+	}
+	if _, err := parseIPAddrJSON([]byte("1: lo: <LOOPBACK,UP>")); err == nil {
+		t.Error("text output parsed as JSON")
+	}
+}
+
+// Текстовый вывод старого iproute2 дает тот же результат
+func TestParseIPAddrShowVLANBond(t *testing.T) {
+	interfaces := parseIPAddrShow(testdataFile(t, "ip", "addr_vlan_bond.txt"))
+	if got := describeInterfaces(interfaces); got != vlanBondInterfaces {
+		t.Errorf("interfaces:\n%s\nwant:\n%s", got, vlanBondInterfaces)
+	}
+}
+
+// bond и VLAN не имеют PCI устройства и не засчитываются в порты карт
+func TestCountPCIBackedInterfaces(t *testing.T) {
+	interfaces, err := parseIPAddrJSON([]byte(testdataFile(t, "ip", "addr_vlan_bond.json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	useRunner(t, &fakeRunner{links: map[string]string{
+		"/sys/class/net/eno1/device":   "../../../0000:02:00.0",
+		"/sys/class/net/eno2/device":   "../../../0000:02:00.1",
+		"/sys/class/net/enp4s0/device": "../../../0000:04:00.0",
+	}})
+	if got := countPCIBackedInterfaces(interfaces); got != 3 {
+		t.Errorf("%d PCI backed interface(s), want 3", got)
+	}
+}
+
+// fakeTools подставляет в PATH скрипты sh с заданными телами (ip, ping, arping...)
+func fakeTools(t *testing.T, scripts map[string]string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// Недоступный после прошивки шлюз не проваливает операцию, но записывается в лог
+func TestUnreachableGatewayInLog(t *testing.T) {
+	fakeTools(t, map[string]string{
+		"ip":   `echo "default via 10.0.0.1 proto dhcp metric 100"`,
+		"ping": `echo "From 10.0.0.5 icmp_seq=1 Destination Host Unreachable"; exit 1`,
+	})
+	summary := &FlashMACSummary{InterfaceName: "eno1", IPRestored: true}
+	verifyFlashedConnectivity(summary)
+	if summary.NetworkReachable || summary.GatewayIP != "10.0.0.1" || !strings.Contains(summary.GatewayError, "gateway 10.0.0.1 unreachable") {
+		t.Fatalf("summary: %+v", summary)
+	}
+
+	data, err := yaml.Marshal(FlashResult{Operation: "mac", Status: "PASSED", Network: macNetworkCheck(summary)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"interface: eno1", "gateway: 10.0.0.1", "gateway_reachable: false", "gateway_error: |-\n        gateway 10.0.0.1 unreachable: exit status 1"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+
+	fakeTools(t, map[string]string{"ping": "exit 0"})
+	reachable := &FlashMACSummary{InterfaceName: "eno1", IPRestored: true}
+	verifyFlashedConnectivity(reachable)
+	if check := macNetworkCheck(reachable); check.GatewayReachable == nil || !*check.GatewayReachable || check.GatewayError != "" {
+		t.Errorf("reachable: %+v", check)
+	}
+
+	// Проверка выключена - в логе нет вывода о доступности
+	if check := macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1"}); check.GatewayReachable != nil {
+		t.Errorf("unchecked gateway recorded as %v", *check.GatewayReachable)
+	}
+	if macNetworkCheck(&FlashMACSummary{}) != nil {
+		t.Error("network recorded without an interface")
+	}
+}
+
+// Исход gratuitous ARP различает отправку, отсутствие arping и ошибку и попадает в лог
+func TestGratuitousARPOutcome(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if outcome, err := sendGratuitousARP("eno1", "10.0.0.5"); outcome != arpSkipped || err != nil {
+		t.Errorf("no arping: %s %v", outcome, err)
+	}
+
+	fakeTools(t, map[string]string{"arping": `echo "arping: socket: Operation not permitted"; exit 2`})
+	outcome, err := sendGratuitousARP("eno1", "10.0.0.5")
+	if outcome != arpFailed || err == nil || !strings.Contains(err.Error(), "Operation not permitted") {
+		t.Errorf("arping failed: %s %v", outcome, err)
+	}
+	data, _ := yaml.Marshal(macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1", GratuitousARP: outcome, GratuitousARPError: err.Error()}))
+	if !strings.Contains(string(data), "gratuitous_arp: failed\n") || !strings.Contains(string(data), "Operation not permitted") {
+		t.Errorf("log:\n%s", data)
+	}
+
+	fakeTools(t, map[string]string{"arping": "exit 0"})
+	if outcome, err := sendGratuitousARP("eno1", "10.0.0.5"); outcome != arpSent || err != nil {
+		t.Errorf("arping: %s %v", outcome, err)
+	}
+}
+
+// Неудачный возврат IP записывается в лог, даже если прошитый MAC не найден
+func TestIPRestoreInLog(t *testing.T) {
+	failed := &FlashMACSummary{InterfaceName: "eno1", OriginalIP: "192.168.1.5", OriginalIPCIDR: "192.168.1.5/27",
+		IPRestoreError: "ip addr add 192.168.1.5/27 dev eno1 failed: exit status 2"}
+	data, _ := yaml.Marshal(macNetworkCheck(failed))
+	for _, want := range []string{"original_ip: 192.168.1.5/27\n", "ip_restored: false\n", "ip_restore_error: 'ip addr add 192.168.1.5/27 dev eno1 failed"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+
+	lost := macNetworkCheck(&FlashMACSummary{OriginalIP: "10.0.0.5", OriginalIPCIDR: "10.0.0.5/16"})
+	if lost == nil || lost.Interface != "" || lost.IPRestored == nil || *lost.IPRestored {
+		t.Errorf("MAC not found: %+v", lost)
+	}
+
+	restored := macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1", OriginalIP: "10.0.0.5", OriginalIPCIDR: "10.0.0.5/16", IPRestored: true})
+	if restored.IPRestored == nil || !*restored.IPRestored || restored.OriginalIP != "10.0.0.5/16" {
+		t.Errorf("restored: %+v", restored)
+	}
+	if check := macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1"}); check.IPRestored != nil || check.OriginalIP != "" {
+		t.Errorf("interface without an address: %+v", check)
+	}
+}
+
+// Маршрут по умолчанию в логе: не вернулся вместе с IP или ip route add упал
+func TestRouteRestoreInLog(t *testing.T) {
+	const route = "default via 10.0.0.1 proto dhcp metric 100"
+	summary := &FlashMACSummary{InterfaceName: "eno1", OriginalIP: "10.0.0.5", OriginalIPCIDR: "10.0.0.5/24", IPRestored: true,
+		OriginalDefaultRoute: route}
+	fakeTools(t, map[string]string{"ip": `echo "Error: Nexthop has invalid gateway."; exit 2`})
+	restoreSummaryRoute("eno1", summary)
+
+	data, _ := yaml.Marshal(macNetworkCheck(summary))
+	for _, want := range []string{"default_route: " + route + "\n", "route_restored: false\n", "Nexthop has invalid gateway"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log lacks %q:\n%s", want, data)
+		}
+	}
+
+	fakeTools(t, map[string]string{"ip": "exit 0"})
+	summary.RouteRestoreError = ""
+	restoreSummaryRoute("eno1", summary)
+	if check := macNetworkCheck(summary); check.RouteRestored == nil || !*check.RouteRestored || check.RouteRestoreError != "" {
+		t.Errorf("restored: %+v", check)
+	}
+
+	// IP не вернулся - маршрут не восстанавливался, но в логе видно, что он был
+	lost := macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1", OriginalIP: "10.0.0.5", OriginalDefaultRoute: route})
+	if lost.RouteRestored == nil || *lost.RouteRestored {
+		t.Errorf("route after a failed IP restore: %+v", lost)
+	}
+	if check := macNetworkCheck(&FlashMACSummary{InterfaceName: "eno1"}); check.RouteRestored != nil || check.DefaultRoute != "" {
+		t.Errorf("interface without a route: %+v", check)
+	}
+}
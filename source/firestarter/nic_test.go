@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssignedMACs(t *testing.T) {
+	summary := &FlashMACSummary{NICMapping: &NICMapping{Assignments: []NICMACAssignment{
+		{Index: 2, MAC: "00:11:22:33:44:55"},
+		{Index: 1, MAC: "00:11:22:33:44:56"},
+	}}}
+	if got := assignedMACs(summary, "00:11:22:33:44:55"); strings.Join(got, ",") != "00:11:22:33:44:55,00:11:22:33:44:56" {
+		t.Errorf("eeupdate mapping: %v", got)
+	}
+	if got := assignedMACs(&FlashMACSummary{}, "00:11:22:33:44:55"); len(got) != 1 || got[0] != "00:11:22:33:44:55" {
+		t.Errorf("single MAC: %v", got)
+	}
+	if got := assignedMACs(nil, "00:11:22:33:44:55"); len(got) != 1 {
+		t.Errorf("MAC already present: %v", got)
+	}
+}
+
+func TestMACHistoryRecordsEveryPort(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	err := appendMACHistory(dir,
+		MACHistoryEntry{MAC: "00:11:22:33:44:55", Serial: "SN1", Timestamp: now},
+		MACHistoryEntry{MAC: "00:11:22:33:44:56", Serial: "SN1", Timestamp: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, previous, seenAt, err := checkMACHistory("00:11:22:33:44:56", dir)
+	if err != nil || !found || previous != "SN1" || !seenAt.Equal(now) {
+		t.Errorf("checkMACHistory = %v, %q, %v, %v", found, previous, seenAt, err)
+	}
+	if found, _, _, err := checkMACHistory("00:11:22:33:44:57", dir); found || err != nil {
+		t.Errorf("unknown MAC: %v, %v", found, err)
+	}
+
+	// Второй порт первой платы совпал с базовым MAC второй
+	if err := verifyMACHistory([]string{"00:11:22:33:44:56"}, "SN2", dir, true); err == nil || !strings.Contains(err.Error(), "SN1") {
+		t.Errorf("second port of another board: %v", err)
+	}
+	if err := verifyMACHistory([]string{"00:11:22:33:44:56"}, "SN2", dir, false); err != nil {
+		t.Errorf("without require_unique_mac_flash: %v", err)
+	}
+	// Повторная прошивка той же платы
+	if err := verifyMACHistory([]string{"00:11:22:33:44:55", "00:11:22:33:44:56"}, "SN1", dir, true); err != nil {
+		t.Errorf("same board: %v", err)
+	}
+	if err := verifyMACHistory([]string{"00:11:22:33:44:57"}, "SN2", dir, true); err != nil {
+		t.Errorf("new MAC: %v", err)
+	}
+}
+
+func TestMACHistoryFailsClosed(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, macHistoryFileName), []byte("- mac: [unterminated\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyMACHistory([]string{"00:11:22:33:44:55"}, "SN1", dir, true); err == nil || !strings.Contains(err.Error(), "unreadable") {
+		t.Errorf("corrupt history with require_unique_mac_flash: %v", err)
+	}
+	if err := verifyMACHistory([]string{"00:11:22:33:44:55"}, "SN1", dir, false); err != nil {
+		t.Errorf("corrupt history without require_unique_mac_flash: %v", err)
+	}
+	// Пустой каталог - пустая история, а не ошибка
+	if err := verifyMACHistory([]string{"00:11:22:33:44:55"}, "SN1", t.TempDir(), true); err != nil {
+		t.Errorf("no history yet: %v", err)
+	}
+}
+
+// fakeNICs - четыре карты в порядке обнаружения eeupdate; PCI адреса и MAC в NVM перемешаны
+func fakeNICs() ([]IntelNIC, map[int]string) {
+	nics := []IntelNIC{
+		{Index: 1, VendorDevice: "8086-1533", PCIAddress: "4:0.0"},
+		{Index: 2, VendorDevice: "8086-1521", PCIAddress: "2:0.1"},
+		{Index: 3, VendorDevice: "8086-1521", PCIAddress: "2:0.0"},
+		{Index: 4, VendorDevice: "8086-1533", PCIAddress: "10:0.0"},
+	}
+	current := map[int]string{1: "a0:36:9f:00:00:03", 2: "A0-36-9F-00-00-02", 3: "a0:36:9f:00:00:10", 4: "a0:36:9f:00:00:01"}
+	return nics, current
+}
+
+func TestOrderIntelNICs(t *testing.T) {
+	nics, current := fakeNICs()
+	cases := []struct {
+		name  string
+		order NICOrder
+		want  string
+	}{
+		{"discovery", NICOrder{}, "1, 2, 3, 4"},
+		{"indices", NICOrder{Indices: []int{3, 1, 4, 2}}, "3, 1, 4, 2"},
+		// Не перечисленные карты - следом в порядке обнаружения
+		{"partial indices", NICOrder{Indices: []int{4}}, "4, 1, 2, 3"},
+		// Адреса сравниваются числами: 10:0.0 после 4:0.0
+		{"pci address", NICOrder{Mode: nicOrderByPCIAddress}, "3, 2, 1, 4"},
+		{"current MAC", NICOrder{Mode: nicOrderByMACCurrent}, "4, 2, 1, 3"},
+	}
+	for _, c := range cases {
+		ordered, err := orderIntelNICs(nics, c.order, current)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := intelNICIndices(ordered); got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+	if intelNICIndices(nics) != "1, 2, 3, 4" {
+		t.Errorf("input reordered: %s", intelNICIndices(nics))
+	}
+}
+
+func TestOrderIntelNICsErrors(t *testing.T) {
+	nics, current := fakeNICs()
+	if _, err := orderIntelNICs(nics, NICOrder{Indices: []int{1, 5}}, nil); err == nil || !strings.Contains(err.Error(), "NIC 5") {
+		t.Errorf("unknown index: %v", err)
+	}
+
+	unparsed := append([]IntelNIC{}, nics...)
+	unparsed[2].PCIAddress = ""
+	if _, err := orderIntelNICs(unparsed, NICOrder{Mode: nicOrderByPCIAddress}, nil); err == nil || !strings.Contains(err.Error(), "NIC 3") {
+		t.Errorf("no PCI address: %v", err)
+	}
+
+	delete(current, 2)
+	if _, err := orderIntelNICs(nics, NICOrder{Mode: nicOrderByMACCurrent}, current); err == nil || !strings.Contains(err.Error(), "NIC 2") {
+		t.Errorf("no current MAC: %v", err)
+	}
+}
+
+func TestAssignNICMACs(t *testing.T) {
+	nics, current := fakeNICs()
+	ordered, err := orderIntelNICs(nics, NICOrder{Indices: []int{3, 1, 4, 2}}, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assignments, err := assignNICMACs(ordered, "00:11:22:33:44:FE", current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		index int
+		mac   string
+	}{{3, "00:11:22:33:44:FE"}, {1, "00:11:22:33:44:FF"}, {4, "00:11:22:33:45:00"}, {2, "00:11:22:33:45:01"}}
+	if len(assignments) != len(want) {
+		t.Fatalf("%d assignments", len(assignments))
+	}
+	for i, w := range want {
+		a := assignments[i]
+		if a.Index != w.index || !strings.EqualFold(a.MAC, w.mac) || a.CurrentMAC != current[w.index] || a.PCIAddress != ordered[i].PCIAddress {
+			t.Errorf("assignment %d: %+v, want NIC %d -> %s", i, a, w.index, w.mac)
+		}
+	}
+
+	if _, err := assignNICMACs(ordered, "not-a-mac", nil); err == nil {
+		t.Error("invalid base MAC accepted for several NICs")
+	}
+}
+
+func TestConfirmNICMappingRequiresExplicitYes(t *testing.T) {
+	forceInteractive(t)
+	for input, want := range map[string]bool{
+		"y\n":   true,
+		"YES\n": true,
+		"\n":    false, // Лишний Enter сканера
+		"n\n":   false,
+		"":      false, // EOF
+	} {
+		if got := confirmNICMapping(bufio.NewReader(strings.NewReader(input))); got != want {
+			t.Errorf("input %q: %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	for _, tc := range []struct {
+		input, want string
+	}{
+		{"a0:36:9f:12:34:56", "A0:36:9F:12:34:56"},
+		{"A0-36-9F-12-34-56", "A0:36:9F:12:34:56"},
+		{"a036.9f12.3456", "A0:36:9F:12:34:56"},
+		{"A0369F123456", "A0:36:9F:12:34:56"},
+		{"  a0:36:9f:12:34:56\n", "A0:36:9F:12:34:56"},
+		// Локально администрируемый (бит 1) допустим, multicast - нет
+		{"02:00:00:00:00:01", "02:00:00:00:00:01"},
+	} {
+		if got, err := normalizeMAC(tc.input); err != nil || got != tc.want {
+			t.Errorf("%q: %q %v, want %q", tc.input, got, err, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		input, reason string
+	}{
+		{"AA:BB:CC:DD:EE:GG", "not a hex value"},
+		{"A0:36:9F:12:34", "got 10"},
+		{"A0:36:9F:12:34:56:78", "got 14"},
+		{"1234567890123456", "got 16"},
+		{"", "got 0"},
+		{"A0 36 9F 12 34 56", "got 17"},
+		// Разделители есть, но цифры сгруппированы не по формату
+		{"A:AB:BC:CD:DE:EF:F", "expected XX:XX"},
+		{"A0369F:123456", "expected XX:XX"},
+		{"A0:36-9F:12-34:56", "expected XX:XX"},
+		{"a0:369f:12:34:56", "expected XX:XX"},
+		{"A03.69F.123.456", "expected XX:XX"},
+		{"A036.9F12.34:56", "expected XX:XX"},
+		{"A0-369F-123456", "expected XX:XX"},
+		{"01:00:5E:00:00:01", "multicast"},
+		{"33-33-00-00-00-01", "multicast"},
+		{"00:00:00:00:00:00", "all-zero"},
+		{"ff:ff:ff:ff:ff:ff", "broadcast"},
+	} {
+		got, err := normalizeMAC(tc.input)
+		if err == nil || !strings.Contains(err.Error(), tc.reason) {
+			t.Errorf("%q: %q %v, want error with %q", tc.input, got, err, tc.reason)
+		}
+		if got != "" {
+			t.Errorf("%q: invalid input passed through as %q", tc.input, got)
+		}
+	}
+}
+
+// flash.allow_special_mac пропускает multicast, нулевой и FF MAC, но не испорченный ввод
+func TestNormalizeMACAllowSpecial(t *testing.T) {
+	allowSpecialMAC = true
+	defer func() { allowSpecialMAC = false }()
+	for _, input := range []string{"01:00:5e:00:00:01", "000000000000", "FFFF.FFFF.FFFF"} {
+		if _, err := normalizeMAC(input); err != nil {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+	if _, err := normalizeMAC("AA:BB:CC:DD:EE:GG"); err == nil {
+		t.Error("bad hex accepted with allow_special_mac")
+	}
+}
+
+// Regex поля mac_address из шаблона конфига отклоняет multicast и испорченные значения на вводе
+func TestTemplateMACRegex(t *testing.T) {
+	config, err := loadConfigData(configTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pattern string
+	for _, field := range config.Flash.Fields {
+		if field.ID == "mac_address" {
+			pattern = field.Regex
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil || pattern == "" {
+		t.Fatalf("mac_address regex %q: %v", pattern, err)
+	}
+	for input, valid := range map[string]bool{
+		"A0:36:9F:12:34:56": true,
+		"02:00:00:00:00:01": true,
+		"01:00:5E:00:00:01": false,
+		"AA:BB:CC:DD:EE:GG": false,
+		"A0:36:9F:12:34":    false,
+		"A0369F123456":      false,
+	} {
+		if re.MatchString(input) != valid {
+			t.Errorf("%q: match %v, want %v", input, !valid, valid)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// -print-plan=json: сообщения идут в messageOutput (stderr), stdout остается чистым JSON
+func TestMessagesFollowMessageOutput(t *testing.T) {
+	var buf bytes.Buffer
+	saved := messageOutput
+	messageOutput = &buf
+	defer func() { messageOutput = saved }()
+
+	printInfo("2 test(s) will be skipped (-skip-tests)")
+	printWarning("Tag 'x' does not match any test")
+	out := buf.String()
+	if !strings.Contains(out, "2 test(s) will be skipped") || !strings.Contains(out, "Tag 'x'") {
+		t.Fatalf("messages not written to messageOutput: %q", out)
+	}
+}
+
+// captureStdout возвращает все, что f напечатал в os.Stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = saved
+	w.Close()
+	data, _ := io.ReadAll(r)
+	r.Close()
+	return string(data)
+}
+
+// Кадры строки статуса сверяются с эталонными строками, чтобы формат не уплывал
+func TestRenderCompactFrame(t *testing.T) {
+	c := &compactStatus{results: make(map[string]string), active: make(map[string]int)}
+	c.phase, c.total = "parallel", 5
+	start := c.frame()
+	start.Elapsed = 0
+
+	c.update("cpu", "RUNNING")
+	c.update("cpu", "PASSED")
+	c.update("disk", "RUNNING")
+	c.update("disk", "FAILED")
+	c.update("memtest", "RUNNING")
+	c.update("stress", "RUNNING")
+	c.update("disk", "RUNNING") // повтор упавшего теста
+	running := c.frame()
+	running.Elapsed = time.Hour + 2*time.Minute + 3600*time.Millisecond
+
+	c.phase, c.total = "sequential", 2
+	c.update("fan", "TIMEOUT")
+	next := c.frame()
+	next.Elapsed = 59 * time.Second
+
+	for _, tc := range []struct {
+		frame compactFrame
+		width int
+		want  string
+	}{
+		{start, 80, "[parallel] 0/5 | 00:00:00 | passed 0, failed 0 | -"},
+		{running, 80, "[parallel] 2/5 | 01:02:04 | passed 1, failed 1 | disk, memtest, stress"},
+		{running, 30, "[parallel] 2/5 | 01:02:04 | …"},
+		{next, 80, "[sequential] 1/2 | 00:00:59 | passed 1, failed 2 | disk, memtest, stress"},
+	} {
+		if got := renderCompactFrame(tc.frame, tc.width); got != tc.want {
+			t.Errorf("width %d:\n got %q\nwant %q", tc.width, got, tc.want)
+		}
+	}
+}
+
+// Компактный режим скрывает вывод по переданному статусу, а не по заголовку секции
+func TestCompactPrintSection(t *testing.T) {
+	om := &OutputManager{}
+	om.EnableCompact()
+	defer om.DisableCompact()
+
+	out := captureStdout(t, func() {
+		om.PrintSection("cpu Output", "", "all good\n", "PASSED")
+		om.PrintSection("disk Previous Output", "", "read error\n", "FAILED")
+	})
+	if strings.Contains(out, "all good") {
+		t.Errorf("passed test output shown: %q", out)
+	}
+	if !strings.Contains(out, "DISK PREVIOUS OUTPUT") || !strings.Contains(out, "read error") {
+		t.Errorf("failed test output hidden: %q", out)
+	}
+}
+
+// После DisableCompact перерисовка остановлена, вывод снова обычный
+func TestDisableCompact(t *testing.T) {
+	om := &OutputManager{}
+	om.EnableCompact()
+	c := om.compact
+	om.DisableCompact()
+	om.DisableCompact()
+	select {
+	case <-c.done:
+	default:
+		t.Error("redraw goroutine not stopped")
+	}
+	out := captureStdout(t, func() { om.PrintSection("cpu Output", "", "all good\n", "PASSED") })
+	if !strings.Contains(out, "all good") {
+		t.Errorf("output suppressed after DisableCompact: %q", out)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	pgdrvLoadedModules   = "pgdrv 16384 0 - Live 0x0000000000000000 (OE)\nr8169 98304 0 - Live 0x0000000000000000\n"
+	pgdrvUnloadedModules = "r8169 98304 0 - Live 0x0000000000000000\n"
+)
+
+// pgdrvSystem - подставная система для загрузки pgdrv: модуль в /proc/modules и /sys/module меняется
+// вместе с insmod/rmmod. insmod отвечает по очереди ответами из insmod ("" - успех)
+func pgdrvSystem(t *testing.T, loaded bool, insmod ...string) (*fakeRunner, string) {
+	t.Helper()
+	driver := filepath.Join(t.TempDir(), "pgdrv.ko")
+	if err := os.WriteFile(driver, []byte("ko"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{
+		commands: map[string]string{
+			"dmesg":             "[   10.1] pgdrv: loading out-of-tree module taints kernel.\n[   11.2] usb 1-1: new high-speed USB device\n[   12.3] pgdrv: module_layout: kernel tainted.\n",
+			"modinfo " + driver: "filename:       " + driver + "\nversion:        1.0\nvermagic:       5.15.0 SMP mod_unload\n",
+		},
+		files: map[string]string{"/dev/pgdrv": "c"},
+	}
+	setLoaded := func(on bool) {
+		if on {
+			runner.setFile("/proc/modules", pgdrvLoadedModules)
+			runner.setFile("/sys/module/pgdrv/refcnt", "0\n")
+		} else {
+			runner.setFile("/proc/modules", pgdrvUnloadedModules)
+			runner.setFile("/sys/module/pgdrv/refcnt", "")
+		}
+	}
+	setLoaded(loaded)
+
+	runner.exec = func(command string) (string, error) {
+		switch {
+		case strings.HasPrefix(command, "insmod "):
+			if len(insmod) == 0 {
+				t.Fatalf("unexpected %s", command)
+			}
+			output := insmod[0]
+			insmod = insmod[1:]
+			if output != "" {
+				return output, errors.New("exit status 1")
+			}
+			setLoaded(true)
+			return "", nil
+		case command == "rmmod pgdrv" || command == "rmmod -f pgdrv":
+			setLoaded(false)
+			return "", nil
+		}
+		return "", errors.New("exit status 1")
+	}
+	useRunner(t, runner)
+	return runner, driver
+}
+
+// execCalls - изменяющие команды по порядку, путь к драйверу без каталога
+func execCalls(runner *fakeRunner, driver string) string {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	var calls []string
+	for _, call := range runner.calls {
+		if call != "dmesg" && !strings.HasPrefix(call, "modinfo ") {
+			calls = append(calls, strings.ReplaceAll(call, filepath.Dir(driver)+"/", ""))
+		}
+	}
+	return strings.Join(calls, ", ")
+}
+
+const insmodFileExists = "insmod: ERROR: could not insert module pgdrv.ko: File exists\n"
+
+func TestLoadPgdrv(t *testing.T) {
+	runner, driver := pgdrvSystem(t, false, "")
+	if err := loadRtnicpgDriverFromPath(driver); err != nil {
+		t.Fatal(err)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+// insmod упал с EEXIST: выгрузка оставшегося модуля и одна повторная попытка
+func TestLoadPgdrvRecoversStaleModule(t *testing.T) {
+	runner, driver := pgdrvSystem(t, true, insmodFileExists, "")
+	if err := loadRtnicpgDriverFromPath(driver); err != nil {
+		t.Fatal(err)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko, rmmod pgdrv, insmod pgdrv.ko" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+func TestLoadPgdrvForcesStaleRemoval(t *testing.T) {
+	runner, driver := pgdrvSystem(t, true, insmodFileExists, "")
+	plain := runner.exec
+	runner.exec = func(command string) (string, error) {
+		if command == "rmmod pgdrv" {
+			return "rmmod: ERROR: Module pgdrv is in use\n", errors.New("exit status 1")
+		}
+		return plain(command)
+	}
+	if err := loadRtnicpgDriverFromPath(driver); err != nil {
+		t.Fatal(err)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko, rmmod pgdrv, rmmod -f pgdrv, insmod pgdrv.ko" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+// Повторная неудача: в ошибке диагностика - строки dmesg про pgdrv, /proc/modules и modinfo
+func TestLoadPgdrvRetryFails(t *testing.T) {
+	runner, driver := pgdrvSystem(t, true, insmodFileExists, insmodFileExists)
+	err := loadRtnicpgDriverFromPath(driver)
+	if err == nil {
+		t.Fatal("second insmod failure not reported")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"insmod failed after stale module recovery",
+		"pgdrv: module_layout: kernel tainted.",
+		"/proc/modules: <not loaded>",
+		"vermagic:       5.15.0 SMP mod_unload",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error lacks %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "usb 1-1") {
+		t.Errorf("unrelated dmesg lines in the error:\n%s", msg)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko, rmmod pgdrv, insmod pgdrv.ko" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+// Ошибка insmod, не связанная с оставшимся модулем, - без выгрузки и повтора
+func TestLoadPgdrvOtherInsmodError(t *testing.T) {
+	runner, driver := pgdrvSystem(t, false, "insmod: ERROR: could not insert module pgdrv.ko: Invalid module format\n")
+	err := loadRtnicpgDriverFromPath(driver)
+	if err == nil || !strings.Contains(err.Error(), "Invalid module format") || strings.Contains(err.Error(), "stale") {
+		t.Fatalf("error: %v", err)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+// Модуль загружен, а /dev/pgdrv нет - узел создается по major из /proc/devices
+func TestLoadPgdrvCreatesDeviceNode(t *testing.T) {
+	runner, driver := pgdrvSystem(t, false, "")
+	runner.setFile("/dev/pgdrv", "")
+	runner.setFile("/proc/devices", "Character devices:\n  1 mem\n240 pgdrv\n\nBlock devices:\n  8 sd\n240 pgdrv_blk\n")
+	plain := runner.exec
+	runner.exec = func(command string) (string, error) {
+		if command == "mknod /dev/pgdrv c 240 0" {
+			runner.setFile("/dev/pgdrv", "c")
+			return "", nil
+		}
+		return plain(command)
+	}
+	if err := loadRtnicpgDriverFromPath(driver); err != nil {
+		t.Fatal(err)
+	}
+	if got := execCalls(runner, driver); got != "insmod pgdrv.ko, mknod /dev/pgdrv c 240 0" {
+		t.Errorf("calls: %s", got)
+	}
+}
+
+// Модуль загружен, но символьного устройства нет - отдельная понятная ошибка вместо сбоя rtnic позже
+func TestLoadPgdrvNotUsable(t *testing.T) {
+	runner, driver := pgdrvSystem(t, false, "")
+	runner.setFile("/dev/pgdrv", "")
+	runner.setFile("/proc/devices", "Character devices:\n  1 mem\n\nBlock devices:\n  8 sd\n")
+	err := loadRtnicpgDriverFromPath(driver)
+	if err == nil || !strings.Contains(err.Error(), "pgdrv loaded but not usable by rtnic") || !strings.Contains(err.Error(), "/dev/pgdrv missing") {
+		t.Fatalf("error: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// План применяет те же фильтры, что и запуск: -skip-tests и skip_condition групп
+func TestPlanAppliesSkipFilters(t *testing.T) {
+	savedSet, savedFlag := skipSet, skipSetFlag
+	skipSet, skipSetFlag = map[string]bool{"B": true}, "-skip-tests"
+	defer func() { skipSet, skipSetFlag = savedSet, savedFlag }()
+
+	config := Config{Tests: TestsConfig{
+		ParallelGroups: []TestGroupSpec{
+			{Tests: []TestSpec{{Name: "A", Command: "true"}, {Name: "B", Command: "true"}}},
+			{Tests: []TestSpec{{Name: "C", Command: "true"}}, SkipCondition: "true"},
+		},
+		SequentialGroups: []TestGroupSpec{
+			{Tests: []TestSpec{{Name: "D", Command: "true"}}, SkipCondition: "false"},
+		},
+		PostRebootGroups: [][]TestSpec{{{Name: "B", Command: "true"}}},
+	}}
+	steps, err := buildExecutionPlan(config, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buildPlanReport(config, "config.yaml", steps, true, false)
+
+	skipped := make(map[string]string)
+	var groups []PlanGroup
+	for _, step := range report.Steps {
+		groups = append(groups, step.Groups...)
+	}
+	for _, g := range groups {
+		for _, test := range g.Tests {
+			skipped[test.Name] = test.Skipped
+		}
+	}
+	want := map[string]string{
+		"A": "",
+		"B": "skipped via -skip-tests flag",
+		"C": "group skip_condition",
+		"D": "",
+	}
+	for name, reason := range want {
+		if skipped[name] != reason {
+			t.Errorf("%s: skipped %q, want %q", name, skipped[name], reason)
+		}
+	}
+	if len(groups) != 3 || groups[1].Skipped == "" || groups[2].Skipped != "" {
+		t.Errorf("group skip_condition not applied: %+v", groups)
+	}
+	if !report.HardwareQueried || len(report.HardwareQueries) != 2 {
+		t.Errorf("skip_condition runs not reported: %v", report.HardwareQueries)
+	}
+	// -continue выполняет группы после перезагрузки без -skip-tests
+	if report.PostRebootGroups[0].Tests[0].Skipped != "" {
+		t.Errorf("post-reboot test marked skipped: %+v", report.PostRebootGroups[0].Tests[0])
+	}
+}
+
+// План перечисляет точные вызовы утилиты SMBIOS: значения из конфига подставлены, вводимые оператором - заглушки
+func TestPlanListsSMBIOSInvocations(t *testing.T) {
+	config := Config{
+		System: SystemConfig{Manufacturer: "Acme's"},
+		Flash: FlashConfig{SMBIOS: SMBIOSConfig{
+			ToolPath: "/opt/ami/AMIDEEFIx64",
+			Strings:  map[string]string{"/SS": "system_serial", "/SM": "manufacturer", "/SP": "product", "/BS": "io_board"},
+		}},
+	}
+	got := flashOperationTargets("smbios", config)
+	want := []string{
+		"/opt/ami/AMIDEEFIx64 /BS <io_board> (unless dmidecode -s baseboard-serial-number already matches)",
+		`/opt/ami/AMIDEEFIx64 /SM 'Acme'\''s' (unless dmidecode -s system-manufacturer already matches)`,
+		"/SP skipped (no product value)",
+		"/opt/ami/AMIDEEFIx64 /SS <system_serial> (unless dmidecode -s system-serial-number already matches)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("targets:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
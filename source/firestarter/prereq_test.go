@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// prereqFixture - дерево-фикстура /proc и /sys для проверок требований к прошивке
+type prereqFixture struct {
+	root  string
+	paths prereqPaths
+}
+
+func newPrereqFixture(t *testing.T) *prereqFixture {
+	t.Helper()
+	root := t.TempDir()
+	f := &prereqFixture{root: root, paths: prereqPaths{
+		Mounts:   filepath.Join(root, "proc", "mounts"),
+		EFIVars:  filepath.Join(root, "sys", "firmware", "efi", "efivars"),
+		Lockdown: filepath.Join(root, "sys", "kernel", "security", "lockdown"),
+		Cmdline:  filepath.Join(root, "proc", "cmdline"),
+	}}
+	if err := os.MkdirAll(f.paths.EFIVars, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f.write(t, f.paths.Mounts, "efivarfs "+f.paths.EFIVars+" efivarfs rw,nosuid,nodev,noexec,relatime 0 0\n")
+	f.write(t, f.paths.Lockdown, "[none] integrity confidentiality\n")
+	f.write(t, f.paths.Cmdline, "BOOT_IMAGE=/vmlinuz root=/dev/sda1 quiet\n")
+	return f
+}
+
+func (f *prereqFixture) write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func prereqFlash(ops ...string) FlashConfig {
+	return FlashConfig{Enabled: true, Method: "rtnicpg", Operations: ops}
+}
+
+const testGUID = "12345678-1234-1234-1234-123456789abc"
+
+func TestFlashPrerequisitesHealthy(t *testing.T) {
+	f := newPrereqFixture(t)
+	failures := checkFlashPrerequisites(prereqFlash("efi", "mac", "fru"), SystemConfig{GuidPrefix: testGUID}, f.paths)
+	if len(failures) != 0 {
+		t.Fatalf("healthy fixture: %+v", failures)
+	}
+	// Пробная переменная удалена
+	entries, _ := os.ReadDir(f.paths.EFIVars)
+	if len(entries) != 0 {
+		t.Fatalf("test variable left behind: %v", entries)
+	}
+}
+
+func TestEFIVarsReadOnly(t *testing.T) {
+	f := newPrereqFixture(t)
+	f.write(t, f.paths.Mounts, "efivarfs "+f.paths.EFIVars+" efivarfs ro,nosuid,nodev 0 0\n")
+	failure := checkEFIVarsWritable(f.paths.Mounts, f.paths.EFIVars, testGUID)
+	if failure == nil || !strings.Contains(failure.Problem, "read-only") {
+		t.Fatalf("got %+v", failure)
+	}
+}
+
+func TestEFIVarsNotMounted(t *testing.T) {
+	f := newPrereqFixture(t)
+	f.write(t, f.paths.Mounts, "proc /proc proc rw 0 0\n")
+	failure := checkEFIVarsWritable(f.paths.Mounts, f.paths.EFIVars, testGUID)
+	if failure == nil || !strings.Contains(failure.Problem, "not mounted") {
+		t.Fatalf("got %+v", failure)
+	}
+
+	// Нет /sys/firmware/efi - загрузка в legacy (CSM)
+	legacy := filepath.Join(f.root, "legacy", "efi", "efivars")
+	failure = checkEFIVarsWritable(f.paths.Mounts, legacy, testGUID)
+	if failure == nil || !strings.Contains(failure.Problem, "legacy") {
+		t.Fatalf("got %+v", failure)
+	}
+}
+
+func TestSecureBoot(t *testing.T) {
+	f := newPrereqFixture(t)
+	if failure := checkSecureBoot(f.paths.EFIVars); failure != nil {
+		t.Fatalf("no SecureBoot variable: %+v", failure)
+	}
+	path := filepath.Join(f.paths.EFIVars, secureBootVariable)
+	f.write(t, path, "\x06\x00\x00\x00\x00")
+	if failure := checkSecureBoot(f.paths.EFIVars); failure != nil {
+		t.Fatalf("Secure Boot disabled: %+v", failure)
+	}
+	f.write(t, path, "\x06\x00\x00\x00\x01")
+	if failure := checkSecureBoot(f.paths.EFIVars); failure == nil || failure.Check != "Secure Boot" {
+		t.Fatalf("Secure Boot enabled: %+v", failure)
+	}
+}
+
+func TestKernelLockdown(t *testing.T) {
+	f := newPrereqFixture(t)
+	if failure := checkKernelLockdown(f.paths.Lockdown); failure != nil {
+		t.Fatalf("lockdown none: %+v", failure)
+	}
+	f.write(t, f.paths.Lockdown, "none [integrity] confidentiality\n")
+	if failure := checkKernelLockdown(f.paths.Lockdown); failure == nil || !strings.Contains(failure.Problem, "integrity") {
+		t.Fatalf("lockdown integrity: %+v", failure)
+	}
+	if failure := checkKernelLockdown(filepath.Join(f.root, "missing")); failure != nil {
+		t.Fatalf("no lockdown LSM: %+v", failure)
+	}
+}
+
+func TestIOMemRestriction(t *testing.T) {
+	f := newPrereqFixture(t)
+	f.write(t, f.paths.Cmdline, "root=/dev/sda1 iomem=strict quiet\n")
+	if failure := checkIOMemRestriction(f.paths.Cmdline); failure == nil || failure.Check != "iomem" {
+		t.Fatalf("iomem=strict: %+v", failure)
+	}
+	// eeupdate регистры через /dev/mem не читает - iomem не проверяется
+	flash := prereqFlash("mac")
+	flash.Method = "eeupdate"
+	if failures := checkFlashPrerequisites(flash, SystemConfig{}, f.paths); len(failures) != 0 {
+		t.Fatalf("eeupdate with iomem=strict: %+v", failures)
+	}
+}
+
+// Проверка блокирует только зависящие от нее операции; nic-checksum и smbios не блокируются
+func TestPrerequisiteBlockedOperations(t *testing.T) {
+	f := newPrereqFixture(t)
+	f.write(t, filepath.Join(f.paths.EFIVars, secureBootVariable), "\x06\x00\x00\x00\x01")
+	f.write(t, f.paths.Mounts, "efivarfs "+f.paths.EFIVars+" efivarfs ro 0 0\n")
+
+	failures := checkFlashPrerequisites(prereqFlash("efi", "mac", "nic-checksum", "smbios"), SystemConfig{}, f.paths)
+	if len(failures) != 2 {
+		t.Fatalf("got %+v", failures)
+	}
+	if !reflect.DeepEqual(failures[0].Operations, []string{"efi"}) || !reflect.DeepEqual(failures[1].Operations, []string{"efi", "mac"}) {
+		t.Fatalf("blocked operations: %v, %v", failures[0].Operations, failures[1].Operations)
+	}
+	for op, want := range map[string][]string{
+		"efi":          {"efivarfs", "Secure Boot"},
+		"mac":          {"Secure Boot"},
+		"nic-checksum": nil,
+		"smbios":       nil,
+	} {
+		if got := prereqBlockingChecks(failures, op); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: blocked by %v, want %v", op, got, want)
+		}
+	}
+
+	// Только nic-checksum - непройденные проверки ему не мешают
+	if failures := checkFlashPrerequisites(prereqFlash("nic-checksum"), SystemConfig{}, f.paths); len(failures) != 0 {
+		t.Fatalf("nic-checksum blocked: %+v", failures)
+	}
+}
+
+func TestConfirmPrerequisiteFailuresNonInteractive(t *testing.T) {
+	saved := nonInteractive
+	nonInteractive = true
+	defer func() { nonInteractive = saved }()
+
+	failures := []PrereqFailure{{Check: "Secure Boot", Operations: []string{"efi", "mac"}}}
+	if confirmPrerequisiteFailures(failures, bufio.NewReader(strings.NewReader("y\n"))) {
+		t.Fatal("non-interactive session continued past failed prerequisites")
+	}
+}
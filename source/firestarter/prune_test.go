@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mb = 1 << 20
+
+// logDirFixture создает log_dir: сессии разного возраста, outbox с неотправленным логом, посторонний файл.
+// Размеры по 1 MB, outbox - 3 MB, всего 8 MB
+func logDirFixture(t *testing.T, now time.Time) string {
+	t.Helper()
+	dir := t.TempDir()
+	write := func(name string, size int, age time.Duration) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Каталог сессии меняется вместе с последним файлом в нем
+		mtime := now.Add(-age)
+		for ; path != dir; path = filepath.Dir(path) {
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	day := 24 * time.Hour
+	write("notes.txt", 16, 60*day)
+	write("outbox/SN0_20240101_090000_pass.yaml", 3*mb, 50*day)
+	write("SN4_20240101_080000_pass.yaml", mb, 45*day) // текущая сессия (keep)
+	write("SN1_20240105_100000_pass.yaml", mb, 40*day)
+	write("1700000001/memtest.log", mb/2, 20*day)
+	write("1700000001/stress.log", mb/2, 20*day)
+	write("SN2_20240301_100000_fail.yaml", mb, 10*day)
+	write("SN3_20240310_100000_pass.yaml", mb, day)
+	return dir
+}
+
+func remainingEntries(t *testing.T, dir string) string {
+	t.Helper()
+	items, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestPruneLogsByAge(t *testing.T) {
+	now := time.Now()
+	dir := logDirFixture(t, now)
+	config := LogConfig{LogDir: dir, Retention: RetentionConfig{MaxAgeDays: 30}}
+
+	stats, err := pruneLogs(config, now, "SN4_20240101_080000_pass.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Removed != 1 || stats.Files != 1 || stats.Bytes != mb || stats.Foreign != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+	want := "1700000001 SN2_20240301_100000_fail.yaml SN3_20240310_100000_pass.yaml SN4_20240101_080000_pass.yaml notes.txt outbox"
+	if got := remainingEntries(t, dir); got != want {
+		t.Errorf("left:\n %s\nwant:\n %s", got, want)
+	}
+
+	// strict удаляет и посторонние файлы
+	config.Retention.Strict = true
+	if stats, _ := pruneLogs(config, now, "SN4_20240101_080000_pass.yaml"); stats.Removed != 1 || stats.Foreign != 0 || fileExists(filepath.Join(dir, "notes.txt")) {
+		t.Errorf("strict: %+v", stats)
+	}
+}
+
+// По размеру удаляются самые старые записи, пока log_dir не уложится в лимит; outbox и текущая сессия
+// остаются, даже если они старше всех и сами превышают лимит
+func TestPruneLogsBySize(t *testing.T) {
+	now := time.Now()
+	dir := logDirFixture(t, now)
+	config := LogConfig{LogDir: dir, Retention: RetentionConfig{MaxTotalMB: 6}}
+
+	stats, err := pruneLogs(config, now, "SN4_20240101_080000_pass.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Removed != 3 || stats.Files != 4 || stats.Bytes != 3*mb || stats.Foreign != 1 {
+		t.Errorf("stats: %+v", stats)
+	}
+	want := "SN3_20240310_100000_pass.yaml SN4_20240101_080000_pass.yaml notes.txt outbox"
+	if got := remainingEntries(t, dir); got != want {
+		t.Errorf("left:\n %s\nwant:\n %s", got, want)
+	}
+	if !fileExists(filepath.Join(dir, "outbox", "SN0_20240101_090000_pass.yaml")) {
+		t.Error("unsent log removed from outbox")
+	}
+}
+
+func TestPruneLogsWithoutPolicy(t *testing.T) {
+	now := time.Now()
+	dir := logDirFixture(t, now)
+	before := remainingEntries(t, dir)
+	if stats, err := pruneLogs(LogConfig{LogDir: dir}, now); err != nil || stats.Removed != 0 {
+		t.Fatalf("%+v %v", stats, err)
+	}
+	if got := remainingEntries(t, dir); got != before {
+		t.Errorf("entries removed without a retention policy: %s", got)
+	}
+	if stats, err := pruneLogs(LogConfig{LogDir: filepath.Join(dir, "missing"), Retention: RetentionConfig{MaxAgeDays: 1}}, now); err != nil || stats.Removed != 0 {
+		t.Errorf("missing log_dir: %+v %v", stats, err)
+	}
+}
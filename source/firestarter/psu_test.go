@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Вывод ipmitool sdr type "Power Supply": исправные блоки, пропадание AC и отказ со статусом ok
+const sdrPowerSupplyOK = `PS1 Status       | C8h | ok  | 10.1 | Presence detected
+PS2 Status       | C9h | ok  | 10.2 | Presence detected
+PSU1 POUT        | 5Ch | ok  | 10.1 | 240 Watts
+`
+
+const sdrPowerSupplyFaults = `PS1 Status       | C8h | ok  | 10.1 | Presence detected, Power Supply AC lost
+PS2 Status       | C9h | ok  | 10.2 | Presence detected, Failure detected
+PS3 Status       | CAh | ok  | 10.3 | Presence detected, AC out-of-range, but present
+PS4 Status       | CBh | ns  | 10.4 | No Reading
+PSU1 VIN         | 5Ah | cr  | 10.1 | 160 Volts
+`
+
+func TestParseSDRSensors(t *testing.T) {
+	sensors := parseSDRSensors(sdrPowerSupplyOK)
+	want := []sdrSensor{
+		{Name: "PS1 Status", Status: "ok", Event: "Presence detected"},
+		{Name: "PS2 Status", Status: "ok", Event: "Presence detected"},
+		{Name: "PSU1 POUT", Status: "ok", Value: 240, HasValue: true},
+	}
+	if !reflect.DeepEqual(sensors, want) {
+		t.Fatalf("got %+v", sensors)
+	}
+}
+
+func TestPSUSensorProblemsHealthy(t *testing.T) {
+	if problems := psuSensorProblems(parseSDRSensors(sdrPowerSupplyOK), map[string]float64{"PSU1 POUT": 100}); len(problems) != 0 {
+		t.Fatalf("healthy PSUs reported: %v", problems)
+	}
+}
+
+func TestPSUSensorProblemsDiscreteEvents(t *testing.T) {
+	problems := psuSensorProblems(parseSDRSensors(sdrPowerSupplyFaults), nil)
+	want := []string{
+		"PS1 Status: Power Supply AC lost",
+		"PS2 Status: Failure detected",
+		"PS3 Status: AC out-of-range",
+		"PSU1 VIN status cr",
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Fatalf("got %q", problems)
+	}
+}
+
+func TestPSUSensorProblemsThresholds(t *testing.T) {
+	sensors := parseSDRSensors("PSU1 VIN | 5Ah | ok | 10.1 | 190 Volts\nPS1 Status | C8h | ok | 10.1 | Presence detected\n")
+	problems := psuSensorProblems(sensors, map[string]float64{"PSU1 VIN": 200, "PS1 Status": 1, "PSU2 VIN": 200})
+	joined := strings.Join(problems, "; ")
+	for _, want := range []string{"PSU1 VIN 190.00 below 200.00", "PS1 Status has no reading", "PSU2 VIN not found"} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("missing %q in %q", want, joined)
+		}
+	}
+}
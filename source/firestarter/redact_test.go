@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func redactedSession() SessionLog {
+	return SessionLog{
+		SessionID: "20260101-abcd",
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		State:     "completed",
+		System: SystemInfo{
+			Product:          "TEST",
+			MBSerial:         "MB123456",
+			MAC:              "00:11:22:33:44:55",
+			OriginalMBSerial: "OLD98765",
+			OriginalMACs:     []string{"AA:BB:CC:DD:EE:01", "aa:bb:cc:dd:ee:02"},
+		},
+		TestResults: []TestResult{
+			{Name: "nic", Status: "FAILED", Error: "MB123456: port aa:bb:cc:dd:ee:01 link down"},
+		},
+	}
+}
+
+func TestRedactReplacementIsDeterministic(t *testing.T) {
+	a := newLogRedactor(RedactConfig{Fields: []string{redactSerials, redactMACs}, Salt: "site-a"})
+	b := newLogRedactor(RedactConfig{Fields: []string{redactSerials, redactMACs}, Salt: "site-a"})
+	other := newLogRedactor(RedactConfig{Fields: []string{redactSerials}, Salt: "site-b"})
+
+	first := a.replacement("MB123456", redactSerials, false)
+	if !strings.HasPrefix(first, "h:") || len(first) != 18 {
+		t.Fatalf("hash replacement %q", first)
+	}
+	if again := a.replacement("MB123456", redactSerials, true); again != first {
+		t.Errorf("same redactor: %q then %q", first, again)
+	}
+	if fresh := b.replacement("MB123456", redactSerials, false); fresh != first {
+		t.Errorf("same salt: %q and %q", first, fresh)
+	}
+	if salted := other.replacement("MB123456", redactSerials, false); salted == first {
+		t.Errorf("different salt gave the same replacement %q", salted)
+	}
+	// MAC сравниваются без учета регистра
+	if a.replacement("AA:BB:CC:DD:EE:01", redactMACs, false) != a.replacement("aa:bb:cc:dd:ee:01", redactMACs, false) {
+		t.Error("MAC case changed the replacement")
+	}
+}
+
+func TestRedactYAMLRoundTrip(t *testing.T) {
+	log := redactedSession()
+	data, err := yaml.Marshal(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor := newLogRedactor(RedactConfig{Fields: []string{redactSerials, redactMACs}, Salt: "salt"})
+	redacted, err := redactor.redactYAML(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"MB123456", "OLD98765", "00:11:22:33:44:55", "AA:BB:CC:DD:EE:01", "aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02"} {
+		if strings.Contains(strings.ToLower(string(redacted)), strings.ToLower(secret)) {
+			t.Errorf("%s left in the redacted log:\n%s", secret, redacted)
+		}
+	}
+
+	// Структура лога не меняется: сервер читает обезличенный YAML тем же типом
+	var back SessionLog
+	if err := yaml.Unmarshal(redacted, &back); err != nil {
+		t.Fatalf("redacted log does not parse: %v", err)
+	}
+	serial := redactor.replacement("MB123456", redactSerials, false)
+	if back.System.MBSerial != serial || back.System.Product != "TEST" || back.SessionID != log.SessionID {
+		t.Errorf("system %+v", back.System)
+	}
+	if macs := back.System.OriginalMACs; len(macs) != 2 || !strings.HasPrefix(macs[0], "h:") || macs[0] == macs[1] {
+		t.Errorf("original_macs %v", back.System.OriginalMACs)
+	}
+	// Серийник из поля заменен и в тексте ошибки - той же заменой
+	wantErr := serial + ": port " + redactor.replacement("aa:bb:cc:dd:ee:01", redactMACs, true) + " link down"
+	if back.TestResults[0].Error != wantErr {
+		t.Errorf("error %q, want %q", back.TestResults[0].Error, wantErr)
+	}
+	if !back.Timestamp.Equal(log.Timestamp) || back.TestResults[0].Status != "FAILED" {
+		t.Errorf("non-sensitive fields changed: %+v", back)
+	}
+}
+
+// JSON внутри вывода тестов (артефакты, транскрипт) остается валидным JSON после обезличивания
+func TestRedactArtifactsKeepJSON(t *testing.T) {
+	dir := t.TempDir()
+	artifact := filepath.Join(dir, "nic.json")
+	doc := map[string]string{"serial": "MB123456", "mac": "00:11:22:33:44:55", "ip": "10.0.0.7"}
+	data, _ := json.Marshal(doc)
+	if err := os.WriteFile(artifact, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	redactor := newLogRedactor(RedactConfig{Fields: []string{redactSerials, redactMACs, redactIPs}, Salt: "salt"})
+	if _, err := redactor.redactYAML([]byte("system:\n  mb_serial: MB123456\n")); err != nil {
+		t.Fatal(err)
+	}
+	redacted, cleanup, err := redactor.redactArtifacts([]string{artifact})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if filepath.Base(redacted[0]) != "nic.json" {
+		t.Errorf("artifact renamed to %s", redacted[0])
+	}
+	content, err := os.ReadFile(redacted[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back map[string]string
+	if err := json.Unmarshal(content, &back); err != nil {
+		t.Fatalf("redacted JSON does not parse: %v\n%s", err, content)
+	}
+	for key, original := range doc {
+		if back[key] == original || !strings.HasPrefix(back[key], "h:") {
+			t.Errorf("%s: %q", key, back[key])
+		}
+	}
+}
+
+func TestRedactedLogFileName(t *testing.T) {
+	log := redactedSession()
+
+	hash := newLogRedactor(RedactConfig{Fields: []string{redactSerials}, Salt: "salt"})
+	name := hash.logFileName(log)
+	if strings.Contains(name, "MB123456") {
+		t.Fatalf("serial in the uploaded file name: %s", name)
+	}
+	want := "TEST_" + sanitizeFileName(hash.replacement("MB123456", redactSerials, false)) + "_20260102_030405_completed.yaml"
+	if name != want {
+		t.Errorf("hash: %s, want %s", name, want)
+	}
+	if log.System.MBSerial != "MB123456" {
+		t.Error("logFileName changed the caller's log")
+	}
+
+	drop := newLogRedactor(RedactConfig{Fields: []string{redactSerials}, Mode: "drop"})
+	if name := drop.logFileName(log); name != "TEST_session-20260101-abcd_20260102_030405_completed.yaml" {
+		t.Errorf("drop: %s", name)
+	}
+
+	mask := newLogRedactor(RedactConfig{Fields: []string{redactSerials}, Mode: "mask"})
+	if name := mask.logFileName(log); name != "TEST_MB____56_20260102_030405_completed.yaml" {
+		t.Errorf("mask: %s", name)
+	}
+
+	// Серийники не обезличиваются - имя как у локального лога
+	macs := newLogRedactor(RedactConfig{Fields: []string{redactMACs}, Salt: "salt"})
+	if name := macs.logFileName(log); name != sessionLogFileName(log) {
+		t.Errorf("macs only: %s", name)
+	}
+}
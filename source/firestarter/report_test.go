@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 2, "ab"},
+		{"аб", 3, "а"}, // "б" начинается со второго байта предела
+		{"аб", 4, "аб"},
+		{"a€", 3, "a"},
+		{"€", 1, ""},
+	} {
+		if got := truncateUTF8(tc.in, tc.limit); got != tc.want {
+			t.Errorf("truncateUTF8(%q, %d) = %q, want %q", tc.in, tc.limit, got, tc.want)
+		}
+	}
+}
+
+// Кириллический вывод, обрезанный посреди символа, остается валидным UTF-8 и помечается
+func TestRenderHTMLReportTruncatesOnRuneBoundary(t *testing.T) {
+	output := "x" + strings.Repeat("ошибка ", maxReportOutputBytes/len("ошибка ")+1)
+	log := SessionLog{TestResults: []TestResult{{Name: "memtest", Status: "FAILED", Output: output}}}
+	html, err := renderHTMLReport(log, defaultReportTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utf8.Valid(html) {
+		t.Error("report is not valid UTF-8")
+	}
+	if !strings.Contains(string(html), "Output truncated in this report") {
+		t.Error("truncation note missing")
+	}
+}
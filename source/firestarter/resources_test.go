@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Псевдонимы раскрываются до передачи в runner: "scratch-disk" и "disk:nvme0n1" - один ресурс
+func TestTestResourcesAliases(t *testing.T) {
+	saved := resourceAliases
+	resourceAliases = map[string]string{"scratch-disk": "disk:nvme0n1", "uplink": "port:eth1"}
+	defer func() { resourceAliases = saved }()
+
+	got := testResources(TestSpec{Resources: []string{"uplink", "scratch-disk", "gpu", "disk:nvme0n1"}})
+	if strings.Join(got, ",") != "disk:nvme0n1,gpu,port:eth1" {
+		t.Errorf("resources: %v", got)
+	}
+	if got := testResources(TestSpec{}); got != nil {
+		t.Errorf("no resources: %v", got)
+	}
+}
+
+func TestValidateResourceNames(t *testing.T) {
+	config, err := loadConfigData(configTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Tests.ResourceAliases = map[string]string{"scratch-disk": " "}
+	if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), "tests.resource_aliases") {
+		t.Errorf("empty alias target: %v", err)
+	}
+
+	config.Tests.ResourceAliases = nil
+	for _, groups := range [][]TestGroupSpec{config.Tests.ParallelGroups, config.Tests.SequentialGroups} {
+		for i := range groups {
+			if len(groups[i].Tests) > 0 {
+				groups[i].Tests[0].Resources = []string{"gpu", ""}
+				if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), "empty name in resources") {
+					t.Errorf("empty resource name: %v", err)
+				}
+				return
+			}
+		}
+	}
+	t.Fatal("template has no test groups")
+}
+
+// Ожидание ресурса из runner доходит до TestResult и обратно (продолжение сессии)
+func TestResourceWaitRoundTrip(t *testing.T) {
+	result := testResultFrom(runnerResult(TestResult{Name: "fio", Status: "PASSED", WaitDuration: 42 * time.Second}))
+	if result.WaitDuration != 42*time.Second {
+		t.Errorf("wait lost: %s", result.WaitDuration)
+	}
+}
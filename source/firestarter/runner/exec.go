@@ -0,0 +1,79 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// EffectiveTimeout выбирает таймаут теста: свой > группы/конфига > DefaultTimeout.
+// Второе значение - откуда он взят: test, global или default.
+// Неразбираемый timeout теста дает DefaultTimeout, а не таймаут группы
+func EffectiveTimeout(testTimeout, fallback string) (time.Duration, string) {
+	if testTimeout != "" {
+		if t, err := time.ParseDuration(testTimeout); err == nil {
+			return t, "test"
+		}
+	} else if fallback != "" {
+		if t, err := time.ParseDuration(fallback); err == nil {
+			return t, "global"
+		}
+	}
+	return DefaultTimeout, "default"
+}
+
+// Outcome определяет статус и текст ошибки завершившейся команды: истекший таймаут - TIMEOUT,
+// ненулевой код - FAILED с первой строкой "ERROR:" из stderr или кодом выхода
+func Outcome(timedOut bool, timeout time.Duration, err error, stderr string, exitCode int) (status, message string) {
+	switch {
+	case timedOut:
+		return StatusTimeout, fmt.Sprintf("Test timed out after %s", timeout)
+	case err != nil:
+		for _, line := range strings.Split(stderr, "\n") {
+			if strings.HasPrefix(line, "ERROR:") {
+				if message = strings.TrimSpace(strings.TrimPrefix(line, "ERROR:")); message != "" {
+					return StatusFailed, message
+				}
+				break
+			}
+		}
+		return StatusFailed, fmt.Sprintf("Exit code: %d", exitCode)
+	default:
+		return StatusPassed, ""
+	}
+}
+
+// CommandExecutor запускает Test.Command с Test.Args без shell; вывод - stdout, затем stderr
+type CommandExecutor struct{}
+
+func (CommandExecutor) Execute(ctx context.Context, run Run) Result {
+	result := Result{
+		Name:    run.Test.Name,
+		Status:  StatusFailed,
+		Command: strings.TrimSpace(run.Test.Command + " " + strings.Join(run.Test.Args, " ")),
+		Started: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, run.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, run.Test.Command, run.Test.Args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	result.Duration = time.Since(result.Started)
+	result.Output = stdout.String() + stderr.String()
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && ctx.Err() == nil {
+		// Команда не запустилась (нет файла, нет прав)
+		result.Error = err.Error()
+		return result
+	}
+	result.Status, result.Error = Outcome(errors.Is(ctx.Err(), context.DeadlineExceeded), run.Timeout, err, stderr.String(), cmd.ProcessState.ExitCode())
+	return result
+}
@@ -0,0 +1,550 @@
+// Package runner выполняет группы тестов firestarter (повторы, таймауты, параллельность, ресурсы)
+// без привязки к CLI: вопросы оператору, вывод и запуск команд подключаются снаружи.
+// На этом API построен и сам firestarter, поэтому семантика совпадает с CLI.
+package runner
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Статусы результата теста
+const (
+	StatusPassed  = "PASSED"
+	StatusFailed  = "FAILED"
+	StatusTimeout = "TIMEOUT"
+	StatusSkipped = "SKIPPED"
+)
+
+// Причины SKIPPED в Result.SkipReason
+const (
+	SkipOperator   = "operator"   // Оператор (Prompter) выбрал пропуск
+	SkipDependency = "dependency" // Не выполнен тест, от которого зависит этот
+	SkipCondition  = "condition"  // Исключен условием (skip_condition группы)
+	SkipBudget     = "budget"     // Не хватило отведенного времени
+	SkipBlocked    = "blocked"    // Остановлен после падения обязательного теста
+	SkipOther      = "other"      // В том числе отмена контекста Execute
+)
+
+// DefaultTimeout - таймаут теста, если не задан ни у теста, ни у группы/конфига
+const DefaultTimeout = 30 * time.Second
+
+// DefaultMaxRetries - попыток упавшего теста, если Config.MaxRetries не задан
+const DefaultMaxRetries = 5
+
+// DefaultMaxTimeoutFactor - предел увеличенного таймаута без MaxTimeout: во столько раз больше исходного
+const DefaultMaxTimeoutFactor = 4
+
+// Test - один тест группы
+type Test struct {
+	Name        string
+	Description string
+	Command     string
+	Args        []string
+	Timeout     string   // Перекрывает таймаут группы и конфига
+	Required    bool     // Только для Prompter и потребителей результатов
+	Resources   []string // Эксклюзивные ресурсы: тесты с общим ресурсом параллельной группы не идут одновременно
+
+	TimeoutRetryMultiplier float64 // Вместо Config.TimeoutRetryMultiplier (0 - как в конфиге)
+	MaxTimeout             string  // Вместо Config.MaxTimeout
+
+	Done *Result // Готовый результат (например, из прерванной сессии): тест не запускается
+	Data any     // Данные встраивающей программы, передаются в Executor и Sink как есть
+}
+
+// Group - группа тестов: параллельная или последовательная
+type Group struct {
+	Name        string
+	Parallel    bool
+	Tests       []Test
+	Timeout     string // Таймаут тестов группы вместо Config.Timeout
+	MaxParallel int    // Предел одновременно выполняемых тестов (0 - Config.MaxParallel)
+}
+
+// Config - что и как выполнять
+type Config struct {
+	Timeout     string // Таймаут тестов без своего timeout (пусто - DefaultTimeout)
+	MaxRetries  int    // Предел попыток упавшего теста (0 - DefaultMaxRetries)
+	MaxParallel int    // Предел одновременно выполняемых тестов параллельной группы (0 - без ограничения)
+	Groups      []Group
+
+	// Повтор после TIMEOUT идет с таймаутом предыдущей попытки, умноженным на TimeoutRetryMultiplier
+	// (0 или 1 - без увеличения), но не больше MaxTimeout (пусто - DefaultMaxTimeoutFactor исходных таймаутов)
+	TimeoutRetryMultiplier float64
+	MaxTimeout             string
+}
+
+// Result - результат теста (для попытки - результат этой попытки)
+type Result struct {
+	Name        string
+	Description string
+	Group       string
+	Status      string // PASSED, FAILED, TIMEOUT, SKIPPED
+	Error       string
+	Output      string
+	Command     string
+	Required    bool
+	Started     time.Time
+	Duration    time.Duration
+	Attempts    int
+	Flappy      bool          // Прошел только после повторов
+	Wait        time.Duration // Ожидание занятых другими тестами Resources
+
+	Timeout         time.Duration // Таймаут этой попытки (Executor может заполнить свой, если изменил Run.Timeout)
+	TimeoutExtended bool          // Прошел с таймаутом, увеличенным после TIMEOUT
+
+	SkipReason string
+	SkipDetail string
+
+	Data any // Данные Executor для этой попытки (firestarter хранит здесь полный TestResult)
+}
+
+// Action - решение по упавшему тесту
+type Action string
+
+const (
+	ActionRetry    Action = "RETRY"    // Повторить (пока не исчерпан MaxRetries)
+	ActionContinue Action = "CONTINUE" // Принять провал
+	ActionSkip     Action = "SKIP"     // Отметить SKIPPED (operator)
+)
+
+// Prompter решает, что делать с упавшим тестом: интерфейс оператора или автоматическая политика
+type Prompter interface {
+	Failed(test Test, result Result) Action
+}
+
+// PrompterFunc - Prompter из функции
+type PrompterFunc func(test Test, result Result) Action
+
+func (f PrompterFunc) Failed(test Test, result Result) Action { return f(test, result) }
+
+// AcceptFailures - политика без оператора: провал принимается сразу, без повторов (по умолчанию)
+var AcceptFailures Prompter = PrompterFunc(func(Test, Result) Action { return ActionContinue })
+
+// RetryFailures - политика без оператора: повторять до MaxRetries
+var RetryFailures Prompter = PrompterFunc(func(Test, Result) Action { return ActionRetry })
+
+// Run - одна попытка теста для Executor
+type Run struct {
+	Group   string
+	Test    Test
+	Attempt int
+	Timeout time.Duration // Уже выбран по приоритету тест > группа > конфиг > DefaultTimeout
+}
+
+// Executor выполняет одну попытку теста. Статус, ошибку и вывод заполняет он, счетчики попыток - Runner
+type Executor interface {
+	Execute(ctx context.Context, run Run) Result
+}
+
+// ExecutorFunc - Executor из функции
+type ExecutorFunc func(ctx context.Context, run Run) Result
+
+func (f ExecutorFunc) Execute(ctx context.Context, run Run) Result { return f(ctx, run) }
+
+// GroupStats - сводка выполнения группы
+type GroupStats struct {
+	Peak int // Наибольшее число одновременно выполнявшихся тестов
+}
+
+// Sink получает события выполнения вместо прямого вывода в консоль.
+// События параллельной группы приходят из разных горутин - реализация должна быть потокобезопасной
+type Sink interface {
+	GroupStarted(group Group)
+	AttemptStarted(group string, test Test, attempt int)
+	AttemptFinished(group string, test Test, result Result)
+	Retrying(group string, test Test, previous Result, attempt int)
+	MaxAttemptsReached(group string, test Test, maxAttempts int)
+	ParallelFinished(group string, failed int)                             // Параллельный проход окончен, упавшие разбираются по одному
+	ReviewFailed(group string, test Test, result Result, index, total int) // Упавший тест параллельной группы перед вопросом
+	TestFinished(group string, test Test, result Result)                   // Окончательный результат теста
+	GroupFinished(group Group, results []Result, stats GroupStats)
+}
+
+// NopSink ничего не делает; встраивается в свой Sink, чтобы реализовать только нужные события
+type NopSink struct{}
+
+func (NopSink) GroupStarted(Group)                          {}
+func (NopSink) AttemptStarted(string, Test, int)            {}
+func (NopSink) AttemptFinished(string, Test, Result)        {}
+func (NopSink) Retrying(string, Test, Result, int)          {}
+func (NopSink) MaxAttemptsReached(string, Test, int)        {}
+func (NopSink) ParallelFinished(string, int)                {}
+func (NopSink) ReviewFailed(string, Test, Result, int, int) {}
+func (NopSink) TestFinished(string, Test, Result)           {}
+func (NopSink) GroupFinished(Group, []Result, GroupStats)   {}
+
+// Option настраивает Runner
+type Option func(*Runner)
+
+// WithPrompter задает решение по упавшим тестам (по умолчанию AcceptFailures)
+func WithPrompter(p Prompter) Option { return func(r *Runner) { r.prompter = p } }
+
+// WithSink задает получателя событий (по умолчанию NopSink)
+func WithSink(s Sink) Option { return func(r *Runner) { r.sink = s } }
+
+// WithExecutor задает запуск попытки (по умолчанию CommandExecutor)
+func WithExecutor(e Executor) Option { return func(r *Runner) { r.executor = e } }
+
+// Runner выполняет группы Config по порядку
+type Runner struct {
+	config   Config
+	prompter Prompter
+	sink     Sink
+	executor Executor
+	results  chan Result
+	started  atomic.Bool
+}
+
+// New создает Runner. Канал Results рассчитан на все тесты конфига, поэтому его можно не читать
+func New(config Config, opts ...Option) *Runner {
+	r := &Runner{
+		config:   config,
+		prompter: AcceptFailures,
+		sink:     NopSink{},
+		executor: CommandExecutor{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	total := 0
+	for _, group := range config.Groups {
+		total += len(group.Tests)
+	}
+	r.results = make(chan Result, total)
+	return r
+}
+
+// Results - окончательные результаты выполненных тестов по мере готовности (без Test.Done).
+// Канал закрывается, когда Execute возвращается
+func (r *Runner) Results() <-chan Result {
+	return r.results
+}
+
+// Execute выполняет все группы и возвращает результаты в порядке тестов конфига.
+// При отмене ctx незапущенные тесты получают SKIPPED (other), а Execute возвращает ctx.Err()
+func (r *Runner) Execute(ctx context.Context) ([]Result, error) {
+	if !r.started.CompareAndSwap(false, true) {
+		return nil, fmt.Errorf("runner: Execute called more than once")
+	}
+	defer close(r.results)
+
+	var all []Result
+	for _, group := range r.config.Groups {
+		r.sink.GroupStarted(group)
+		var results []Result
+		var stats GroupStats
+		if group.Parallel {
+			results, stats = r.runParallel(ctx, group)
+		} else {
+			results, stats = r.runSequential(ctx, group)
+		}
+		r.sink.GroupFinished(group, results, stats)
+		all = append(all, results...)
+	}
+	return all, ctx.Err()
+}
+
+func (r *Runner) maxAttempts() int {
+	if r.config.MaxRetries > 0 {
+		return r.config.MaxRetries
+	}
+	return DefaultMaxRetries
+}
+
+func (r *Runner) groupTimeout(group Group) string {
+	if group.Timeout != "" {
+		return group.Timeout
+	}
+	return r.config.Timeout
+}
+
+// attemptTimeout - таймаут попытки и увеличен ли он: после TIMEOUT - таймаут предыдущей попытки
+// с множителем и пределом MaxTimeout (без него - DefaultMaxTimeoutFactor x base), после других статусов - как у предыдущей попытки
+func (r *Runner) attemptTimeout(group Group, test Test, previous *Result) (time.Duration, bool) {
+	base, _ := EffectiveTimeout(test.Timeout, r.groupTimeout(group))
+	if previous == nil || previous.Timeout <= 0 {
+		return base, false
+	}
+	timeout := previous.Timeout
+	multiplier := test.TimeoutRetryMultiplier
+	if multiplier == 0 {
+		multiplier = r.config.TimeoutRetryMultiplier
+	}
+	if previous.Status == StatusTimeout && multiplier > 1 {
+		extended := time.Duration(float64(timeout) * multiplier)
+		limit := test.MaxTimeout
+		if limit == "" {
+			limit = r.config.MaxTimeout
+		}
+		maxTimeout, err := time.ParseDuration(limit)
+		if err != nil || maxTimeout <= 0 {
+			maxTimeout = base * DefaultMaxTimeoutFactor
+		}
+		extended = min(extended, maxTimeout)
+		timeout = max(timeout, extended)
+	}
+	return timeout, timeout > base
+}
+
+// attempt выполняет одну попытку и сообщает о ней Sink; previous - предыдущая попытка теста (nil для первой)
+func (r *Runner) attempt(ctx context.Context, group Group, test Test, number int, previous *Result) Result {
+	r.sink.AttemptStarted(group.Name, test, number)
+	timeout, extended := r.attemptTimeout(group, test, previous)
+	result := r.executor.Execute(ctx, Run{Group: group.Name, Test: test, Attempt: number, Timeout: timeout})
+	if result.Name == "" {
+		result.Name = test.Name
+	}
+	if result.Timeout == 0 {
+		result.Timeout = timeout
+	}
+	result.TimeoutExtended = extended && result.Status == StatusPassed
+	result.Description = test.Description
+	result.Required = test.Required
+	result.Group = group.Name
+	result.Attempts = number
+	r.sink.AttemptFinished(group.Name, test, result)
+	return result
+}
+
+// finish отдает окончательный результат Sink и в Results
+func (r *Runner) finish(group Group, test Test, result Result) {
+	r.sink.TestFinished(group.Name, test, result)
+	r.results <- result
+}
+
+// cancelled - результат теста, не запущенного из-за отмены Execute
+func cancelled(group Group, test Test, err error) Result {
+	return Skip(Result{Name: test.Name, Description: test.Description, Required: test.Required, Group: group.Name},
+		SkipOther, err.Error(), "Not run: execution cancelled")
+}
+
+// Skip помечает результат как SKIPPED с причиной; message попадает в Error, как у прежних версий лога
+func Skip(result Result, reason, detail, message string) Result {
+	result.Status = StatusSkipped
+	result.SkipReason = reason
+	result.SkipDetail = detail
+	result.Error = message
+	return result
+}
+
+// runSequential: тест за тестом, после каждого провала - решение Prompter.
+// Исчерпав MaxRetries на повторах, тест выполняется последний раз без вопроса
+func (r *Runner) runSequential(ctx context.Context, group Group) ([]Result, GroupStats) {
+	results := make([]Result, len(group.Tests))
+	stats := GroupStats{}
+	for i, test := range group.Tests {
+		if test.Done != nil {
+			results[i] = *test.Done
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			results[i] = cancelled(group, test, err)
+			continue
+		}
+		stats.Peak = 1
+		results[i] = r.runWithRetries(ctx, group, test)
+		r.finish(group, test, results[i])
+	}
+	return results, stats
+}
+
+func (r *Runner) runWithRetries(ctx context.Context, group Group, test Test) Result {
+	maxAttempts := r.maxAttempts()
+	var result Result
+	var previous *Result
+	attempts := 0
+	for attempts < maxAttempts {
+		attempts++
+		result = r.attempt(ctx, group, test, attempts, previous)
+		previous = &result
+		if result.Status == StatusPassed {
+			result.Flappy = attempts > 1
+			return result
+		}
+		if ctx.Err() != nil {
+			return result
+		}
+		switch r.prompter.Failed(test, result) {
+		case ActionRetry:
+			r.sink.Retrying(group.Name, test, result, attempts+1)
+			continue
+		case ActionSkip:
+			return Skip(result, SkipOperator, fmt.Sprintf("after %d attempt(s)", attempts), "Skipped by operator")
+		default:
+			return result
+		}
+	}
+
+	// Лимит исчерпан повторами: последняя попытка без вопроса, номер попытки не растет
+	r.sink.MaxAttemptsReached(group.Name, test, maxAttempts)
+	final := r.attempt(ctx, group, test, attempts, previous)
+	final.Flappy = final.Status == StatusPassed
+	return final
+}
+
+// runParallel: все тесты сразу (не больше MaxParallel, с учетом Resources), затем упавшие
+// по одному разбираются с Prompter. Повторы здесь ограничены MaxRetries вместе с первой попыткой
+func (r *Runner) runParallel(ctx context.Context, group Group) ([]Result, GroupStats) {
+	results := make([]Result, len(group.Tests))
+	limit := group.MaxParallel
+	if limit <= 0 {
+		limit = r.config.MaxParallel
+	}
+	if limit <= 0 || limit > len(group.Tests) {
+		limit = len(group.Tests)
+	}
+	sem := make(chan struct{}, max(limit, 1))
+	locks := newResourceLocks()
+	var running, peak int32
+	pending := make([]bool, len(group.Tests))
+
+	var wg sync.WaitGroup
+	for i, test := range group.Tests {
+		if test.Done != nil {
+			results[i] = *test.Done
+			continue
+		}
+		pending[i] = true
+		wg.Add(1)
+		go func(idx int, test Test) {
+			defer wg.Done()
+
+			// Ресурсы берутся до слота: ожидающий ресурса тест не занимает место в MaxParallel
+			wait := locks.acquire(test.Resources)
+			defer locks.release(test.Resources)
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := ctx.Err(); err != nil {
+				results[idx] = cancelled(group, test, err)
+				return
+			}
+			now := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if now <= old || atomic.CompareAndSwapInt32(&peak, old, now) {
+					break
+				}
+			}
+
+			res := r.attempt(ctx, group, test, 1, nil)
+			res.Wait = wait
+			results[idx] = res
+			// Упавшие отдаются после разбора - наружу идут только окончательные результаты
+			if res.Status == StatusPassed {
+				r.finish(group, test, res)
+			}
+		}(i, test)
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, res := range results {
+		if pending[i] && (res.Status == StatusFailed || res.Status == StatusTimeout) {
+			failed++
+		}
+	}
+	r.sink.ParallelFinished(group.Name, failed)
+
+	index := 0
+	for i, res := range results {
+		if !pending[i] || res.Status == StatusPassed {
+			continue
+		}
+		test := group.Tests[i]
+		if res.Status == StatusFailed || res.Status == StatusTimeout {
+			index++
+			r.sink.ReviewFailed(group.Name, test, res, index, failed)
+			wait := res.Wait
+			res = r.reviewFailed(ctx, group, test, res)
+			res.Wait = wait
+		}
+		results[i] = res
+		r.finish(group, test, res)
+	}
+	return results, GroupStats{Peak: int(peak)}
+}
+
+// reviewFailed повторяет упавший тест параллельной группы, пока Prompter просит и не исчерпан MaxRetries
+func (r *Runner) reviewFailed(ctx context.Context, group Group, test Test, result Result) Result {
+	maxAttempts := r.maxAttempts()
+	attempts := result.Attempts
+	for attempts < maxAttempts && result.Status != StatusPassed {
+		if ctx.Err() != nil {
+			return result
+		}
+		switch r.prompter.Failed(test, result) {
+		case ActionRetry:
+			attempts++
+			r.sink.Retrying(group.Name, test, result, attempts)
+			result = r.attempt(ctx, group, test, attempts, &result)
+		case ActionSkip:
+			return Skip(result, SkipOperator, fmt.Sprintf("after %d attempt(s)", attempts), "Skipped by operator")
+		default:
+			return result
+		}
+	}
+	if attempts >= maxAttempts && result.Status != StatusPassed {
+		r.sink.MaxAttemptsReached(group.Name, test, maxAttempts)
+	}
+	result.Flappy = result.Status == StatusPassed && result.Attempts > 1
+	return result
+}
+
+// resourceLocks - занятые ресурсы параллельной группы. Тест берет весь набор своих ресурсов разом
+// (или ждет, пока свободны все), поэтому взаимная блокировка невозможна
+type resourceLocks struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	held map[string]bool
+}
+
+func newResourceLocks() *resourceLocks {
+	l := &resourceLocks{held: make(map[string]bool)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire ждет, пока свободны все names, и занимает их; возвращает время ожидания
+func (l *resourceLocks) acquire(names []string) time.Duration {
+	if len(names) == 0 {
+		return 0
+	}
+	start := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for !l.freeLocked(names) {
+		l.cond.Wait()
+	}
+	for _, name := range names {
+		l.held[name] = true
+	}
+	return time.Since(start)
+}
+
+func (l *resourceLocks) freeLocked(names []string) bool {
+	for _, name := range names {
+		if l.held[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// release освобождает names и будит ожидающие тесты
+func (l *resourceLocks) release(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	l.mu.Lock()
+	for _, name := range names {
+		delete(l.held, name)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
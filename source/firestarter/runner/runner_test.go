@@ -0,0 +1,389 @@
+package runner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func execute(t *testing.T, config Config, opts ...Option) []Result {
+	t.Helper()
+	results, err := New(config, opts...).Execute(context.Background())
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	return results
+}
+
+func TestCommandStatuses(t *testing.T) {
+	results := execute(t, Config{Groups: []Group{{
+		Name: "basic",
+		Tests: []Test{
+			{Name: "pass", Command: "true"},
+			{Name: "fail", Command: "false"},
+			{Name: "timeout", Command: "sleep", Args: []string{"5"}, Timeout: "100ms"},
+			{Name: "missing", Command: "/nonexistent/firestarter-test"},
+		},
+	}}})
+
+	want := []string{StatusPassed, StatusFailed, StatusTimeout, StatusFailed}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("%s: status %s, want %s (%s)", r.Name, r.Status, want[i], r.Error)
+		}
+		if r.Group != "basic" || r.Attempts != 1 {
+			t.Errorf("%s: group %q attempts %d", r.Name, r.Group, r.Attempts)
+		}
+	}
+	if results[1].Error != "Exit code: 1" {
+		t.Errorf("fail: error %q", results[1].Error)
+	}
+	if results[2].Duration >= time.Second || results[2].Timeout != 100*time.Millisecond {
+		t.Errorf("timeout: duration %s, timeout %s", results[2].Duration, results[2].Timeout)
+	}
+}
+
+func TestErrorLineFromStderr(t *testing.T) {
+	results := execute(t, Config{Groups: []Group{{
+		Name:  "stderr",
+		Tests: []Test{{Name: "error", Command: "sh", Args: []string{"-c", "echo 'ERROR: disk missing' >&2; exit 3"}}},
+	}}})
+	if results[0].Status != StatusFailed || results[0].Error != "disk missing" {
+		t.Fatalf("got %s %q", results[0].Status, results[0].Error)
+	}
+}
+
+// flakyTest падает, пока не выполнится passOn раз (счетчик в файле)
+func flakyTest(t *testing.T, name string, passOn int) Test {
+	counter := filepath.Join(t.TempDir(), "count")
+	script := `n=$(cat "$1" 2>/dev/null || echo 0); n=$((n+1)); echo $n > "$1"; [ $n -ge "$2" ]`
+	return Test{Name: name, Command: "sh", Args: []string{"-c", script, "sh", counter, strconv.Itoa(passOn)}}
+}
+
+func TestRetries(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		results := execute(t, Config{MaxRetries: 5, Groups: []Group{{
+			Name:     "retry",
+			Parallel: parallel,
+			Tests:    []Test{flakyTest(t, "flaky", 3), {Name: "broken", Command: "false"}},
+		}}}, WithPrompter(RetryFailures))
+
+		flaky, broken := results[0], results[1]
+		if flaky.Status != StatusPassed || flaky.Attempts != 3 || !flaky.Flappy {
+			t.Errorf("parallel=%v flaky: %s attempts %d flappy %v", parallel, flaky.Status, flaky.Attempts, flaky.Flappy)
+		}
+		if broken.Status != StatusFailed || broken.Attempts != 5 || broken.Flappy {
+			t.Errorf("parallel=%v broken: %s attempts %d flappy %v", parallel, broken.Status, broken.Attempts, broken.Flappy)
+		}
+	}
+}
+
+func TestAcceptFailuresDoesNotRetry(t *testing.T) {
+	results := execute(t, Config{Groups: []Group{{Name: "once", Tests: []Test{flakyTest(t, "flaky", 2)}}}})
+	if results[0].Status != StatusFailed || results[0].Attempts != 1 {
+		t.Fatalf("got %s after %d attempt(s)", results[0].Status, results[0].Attempts)
+	}
+}
+
+// peakSink запоминает пик одновременных тестов группы
+type peakSink struct {
+	NopSink
+	mu   sync.Mutex
+	peak map[string]int
+}
+
+func (s *peakSink) GroupFinished(group Group, _ []Result, stats GroupStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peak[group.Name] = stats.Peak
+}
+
+func TestParallelAndSequential(t *testing.T) {
+	sleeps := func() []Test {
+		var tests []Test
+		for _, name := range []string{"a", "b", "c"} {
+			tests = append(tests, Test{Name: name, Command: "sleep", Args: []string{"0.3"}})
+		}
+		return tests
+	}
+	sink := &peakSink{peak: make(map[string]int)}
+
+	start := time.Now()
+	execute(t, Config{Groups: []Group{{Name: "parallel", Parallel: true, Tests: sleeps()}}}, WithSink(sink))
+	parallel := time.Since(start)
+
+	start = time.Now()
+	execute(t, Config{Groups: []Group{{Name: "sequential", Tests: sleeps()}}}, WithSink(sink))
+	sequential := time.Since(start)
+
+	if parallel >= 800*time.Millisecond || sink.peak["parallel"] != 3 {
+		t.Errorf("parallel group took %s, peak %d", parallel, sink.peak["parallel"])
+	}
+	if sequential < 900*time.Millisecond || sink.peak["sequential"] != 1 {
+		t.Errorf("sequential group took %s, peak %d", sequential, sink.peak["sequential"])
+	}
+
+	execute(t, Config{MaxParallel: 2, Groups: []Group{{Name: "limited", Parallel: true, Tests: sleeps()}}}, WithSink(sink))
+	if sink.peak["limited"] != 2 {
+		t.Errorf("MaxParallel 2: peak %d", sink.peak["limited"])
+	}
+}
+
+func TestSharedResourceSerializesParallelTests(t *testing.T) {
+	sink := &peakSink{peak: make(map[string]int)}
+	results := execute(t, Config{Groups: []Group{{Name: "gpu", Parallel: true, Tests: []Test{
+		{Name: "a", Command: "sleep", Args: []string{"0.2"}, Resources: []string{"gpu0"}},
+		{Name: "b", Command: "sleep", Args: []string{"0.2"}, Resources: []string{"gpu0"}},
+	}}}}, WithSink(sink))
+	if sink.peak["gpu"] != 1 {
+		t.Errorf("peak %d with a shared resource", sink.peak["gpu"])
+	}
+	if results[0].Wait+results[1].Wait < 150*time.Millisecond {
+		t.Errorf("no test waited for the resource: %s, %s", results[0].Wait, results[1].Wait)
+	}
+}
+
+// Тесты с разными ресурсами идут одновременно, с общим - по очереди; ожидание видно в Wait
+func TestDisjointResourcesRunConcurrently(t *testing.T) {
+	sink := &peakSink{peak: make(map[string]int)}
+	results := execute(t, Config{Groups: []Group{{Name: "mixed", Parallel: true, Tests: []Test{
+		{Name: "a", Command: "sleep", Args: []string{"0.3"}, Resources: []string{"disk:nvme0n1"}},
+		{Name: "b", Command: "sleep", Args: []string{"0.3"}, Resources: []string{"gpu"}},
+		{Name: "c", Command: "sleep", Args: []string{"0.3"}, Resources: []string{"disk:nvme0n1", "port:eth1"}},
+	}}}}, WithSink(sink))
+	if sink.peak["mixed"] != 2 {
+		t.Errorf("peak %d, want 2", sink.peak["mixed"])
+	}
+	if results[1].Wait > 100*time.Millisecond {
+		t.Errorf("test without a conflict waited %s", results[1].Wait)
+	}
+	if waited := max(results[0].Wait, results[2].Wait); waited < 250*time.Millisecond {
+		t.Errorf("conflicting tests did not wait: %s, %s", results[0].Wait, results[2].Wait)
+	}
+}
+
+// Тест, ждущий ресурс, не занимает слот MaxParallel
+func TestResourceWaitKeepsSlotFree(t *testing.T) {
+	start := time.Now()
+	results := execute(t, Config{MaxParallel: 2, Groups: []Group{{Name: "limited", Parallel: true, Tests: []Test{
+		{Name: "a", Command: "sleep", Args: []string{"0.3"}, Resources: []string{"gpu"}},
+		{Name: "b", Command: "sleep", Args: []string{"0.3"}, Resources: []string{"gpu"}},
+		{Name: "c", Command: "sleep", Args: []string{"0.3"}},
+	}}}})
+	if late := results[2].Started.Sub(start); late > 200*time.Millisecond {
+		t.Errorf("test without resources started after %s", late)
+	}
+}
+
+// Наборы ресурсов берутся целиком: при любом порядке имен ресурс не бывает занят дважды и блокировки нет
+func TestResourceLocksConcurrent(t *testing.T) {
+	locks := newResourceLocks()
+	sets := [][]string{
+		{"disk:nvme0n1"},
+		{"disk:nvme0n1", "gpu"},
+		{"gpu", "disk:nvme0n1"},
+		{"gpu", "port:eth1"},
+		{"port:eth1", "disk:nvme0n1", "gpu"},
+		nil,
+	}
+	holders := map[string]*int32{"disk:nvme0n1": new(int32), "gpu": new(int32), "port:eth1": new(int32)}
+	var violations int32
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for i := 0; i < 24; i++ {
+			wg.Add(1)
+			go func(names []string) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					locks.acquire(names)
+					for _, name := range names {
+						if atomic.AddInt32(holders[name], 1) != 1 {
+							atomic.AddInt32(&violations, 1)
+						}
+					}
+					time.Sleep(10 * time.Microsecond)
+					for _, name := range names {
+						atomic.AddInt32(holders[name], -1)
+					}
+					locks.release(names)
+				}
+			}(sets[i%len(sets)])
+		}
+		wg.Wait()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("resource locks deadlocked")
+	}
+	if violations != 0 {
+		t.Errorf("resource held by two tests %d time(s)", violations)
+	}
+	if len(locks.held) != 0 {
+		t.Errorf("resources left held: %v", locks.held)
+	}
+}
+
+func TestOperatorSkip(t *testing.T) {
+	skip := WithPrompter(PrompterFunc(func(Test, Result) Action { return ActionSkip }))
+	for _, parallel := range []bool{false, true} {
+		results := execute(t, Config{Groups: []Group{{
+			Name:     "skip",
+			Parallel: parallel,
+			Tests:    []Test{{Name: "pass", Command: "true"}, {Name: "fail", Command: "false"}},
+		}}}, skip)
+		if results[0].Status != StatusPassed {
+			t.Errorf("parallel=%v: passing test %s", parallel, results[0].Status)
+		}
+		r := results[1]
+		if r.Status != StatusSkipped || r.SkipReason != SkipOperator || r.Error != "Skipped by operator" || r.SkipDetail != "after 1 attempt(s)" {
+			t.Errorf("parallel=%v: skipped test %+v", parallel, r)
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	r := Skip(Result{Name: "t", Status: StatusFailed, Output: "out"}, SkipDependency, "needs x", "Dependency failed")
+	if r.Status != StatusSkipped || r.SkipReason != SkipDependency || r.SkipDetail != "needs x" || r.Error != "Dependency failed" || r.Output != "out" {
+		t.Fatalf("got %+v", r)
+	}
+}
+
+func TestDoneIsNotRun(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	done := Result{Name: "done", Status: StatusPassed, Output: "from the interrupted session"}
+	r := New(Config{Groups: []Group{{Name: "resume", Tests: []Test{
+		{Name: "done", Command: "touch", Args: []string{marker}, Done: &done},
+		{Name: "new", Command: "true"},
+	}}}})
+	results, err := r.Execute(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("test with Done was executed")
+	}
+	if results[0].Output != done.Output || results[1].Status != StatusPassed {
+		t.Errorf("got %+v", results)
+	}
+
+	// В Results идут только выполненные тесты
+	var streamed []string
+	for res := range r.Results() {
+		streamed = append(streamed, res.Name)
+	}
+	if len(streamed) != 1 || streamed[0] != "new" {
+		t.Errorf("Results: %v", streamed)
+	}
+}
+
+func TestCancelledContextSkipsRemainingTests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err := New(Config{Groups: []Group{{Name: "cancel", Tests: []Test{{Name: "a", Command: "true"}}}}}).Execute(ctx)
+	if err == nil {
+		t.Fatal("Execute returned no error for a cancelled context")
+	}
+	if results[0].Status != StatusSkipped || results[0].SkipReason != SkipOther {
+		t.Fatalf("got %+v", results[0])
+	}
+}
+
+func TestExecuteOnce(t *testing.T) {
+	r := New(Config{})
+	if _, err := r.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Execute(context.Background()); err == nil {
+		t.Fatal("second Execute succeeded")
+	}
+}
+
+func TestEffectiveTimeout(t *testing.T) {
+	cases := []struct {
+		test, fallback string
+		want           time.Duration
+		source         string
+	}{
+		{"5s", "1m", 5 * time.Second, "test"},
+		{"", "1m", time.Minute, "global"},
+		{"", "", DefaultTimeout, "default"},
+		{"bogus", "1m", DefaultTimeout, "default"},
+	}
+	for _, c := range cases {
+		got, source := EffectiveTimeout(c.test, c.fallback)
+		if got != c.want || source != c.source {
+			t.Errorf("EffectiveTimeout(%q, %q) = %s, %s; want %s, %s", c.test, c.fallback, got, source, c.want, c.source)
+		}
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	group := Group{Name: "g", Timeout: "10s"}
+	timedOut := func(d time.Duration) *Result { return &Result{Status: StatusTimeout, Timeout: d} }
+	cases := []struct {
+		name     string
+		config   Config
+		test     Test
+		previous *Result
+		want     time.Duration
+		extended bool
+	}{
+		{"first attempt", Config{TimeoutRetryMultiplier: 2}, Test{}, nil, 10 * time.Second, false},
+		{"no multiplier", Config{}, Test{}, timedOut(10 * time.Second), 10 * time.Second, false},
+		{"doubled", Config{TimeoutRetryMultiplier: 2}, Test{}, timedOut(10 * time.Second), 20 * time.Second, true},
+		{"max_timeout", Config{TimeoutRetryMultiplier: 2, MaxTimeout: "15s"}, Test{}, timedOut(10 * time.Second), 15 * time.Second, true},
+		// Без max_timeout предел - DefaultMaxTimeoutFactor исходных таймаутов
+		{"default cap", Config{TimeoutRetryMultiplier: 3}, Test{}, timedOut(30 * time.Second), 40 * time.Second, true},
+		{"test overrides", Config{TimeoutRetryMultiplier: 2, MaxTimeout: "15s"}, Test{TimeoutRetryMultiplier: 3, MaxTimeout: "1m"}, timedOut(10 * time.Second), 30 * time.Second, true},
+		// После FAILED таймаут не растет, но увеличенный сохраняется
+		{"failed keeps", Config{TimeoutRetryMultiplier: 2}, Test{}, &Result{Status: StatusFailed, Timeout: 20 * time.Second}, 20 * time.Second, true},
+	}
+	for _, c := range cases {
+		got, extended := New(c.config).attemptTimeout(group, c.test, c.previous)
+		if got != c.want || extended != c.extended {
+			t.Errorf("%s: %s extended %v, want %s %v", c.name, got, extended, c.want, c.extended)
+		}
+	}
+}
+
+// Тест укладывается только в увеличенный таймаут: 100ms -> 200ms -> 400ms
+func TestTimeoutRetryMultiplier(t *testing.T) {
+	results := execute(t, Config{TimeoutRetryMultiplier: 2, Groups: []Group{{Name: "slow", Tests: []Test{
+		{Name: "sleep", Command: "sleep", Args: []string{"0.3"}, Timeout: "100ms"},
+	}}}}, WithPrompter(RetryFailures))
+	r := results[0]
+	if r.Status != StatusPassed || r.Attempts != 3 || r.Timeout != 400*time.Millisecond || !r.TimeoutExtended {
+		t.Fatalf("%s after %d attempt(s), timeout %s, extended %v", r.Status, r.Attempts, r.Timeout, r.TimeoutExtended)
+	}
+}
+
+func TestTimeoutRetryCap(t *testing.T) {
+	for _, c := range []struct {
+		maxTimeout string
+		want       time.Duration
+	}{
+		{"150ms", 150 * time.Millisecond},
+		{"", 4 * 100 * time.Millisecond}, // Без max_timeout - не больше 4 исходных
+	} {
+		results := execute(t, Config{MaxRetries: 3, TimeoutRetryMultiplier: 10, MaxTimeout: c.maxTimeout, Groups: []Group{{Name: "hang", Tests: []Test{
+			{Name: "sleep", Command: "sleep", Args: []string{"5"}, Timeout: "100ms"},
+		}}}}, WithPrompter(RetryFailures))
+		r := results[0]
+		if r.Status != StatusTimeout || r.Attempts != 3 || r.Timeout != c.want {
+			t.Errorf("max_timeout %q: %s after %d attempt(s), timeout %s", c.maxTimeout, r.Status, r.Attempts, r.Timeout)
+		}
+		if r.Duration > c.want+time.Second {
+			t.Errorf("max_timeout %q: last attempt ran %s", c.maxTimeout, r.Duration)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// describeSEL - записи SEL строками консоли (с оценкой серьезности)
+func describeSEL(events []SELEvent) string {
+	var lines []string
+	for _, e := range events {
+		e.Severity = selSeverity(e)
+		lines = append(lines, formatSELEvent(e))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Записи, одинаковые в обоих форматах ipmitool sel list; 0006 без направления только в обычном
+const selEventsHead = `0001 Pre-Init System Event #0x01: Timestamp Clock Sync (Asserted) [info]
+0002 2024-01-15 10:30:45 Temperature #0x01: Upper Critical going high (Asserted) [critical]
+0003 2024-01-15 10:31:02 Temperature #0x01: Upper Critical going high (Deasserted) [info]
+0004 2024-01-15 10:40:11 Memory #0x53: Correctable ECC (Asserted) [warning]
+0005 2024-01-15 10:41:30 Processor #0x02: Thermal Trip (Asserted) [critical]
+`
+
+const selEventsTail = `
+001a 2024-01-15 10:45:17 Memory #0x53: Uncorrectable ECC (Asserted) [critical]`
+
+func TestParseSELList(t *testing.T) {
+	want := selEventsHead + "0006 2024-01-15 10:42:00 Power Supply #0x51: Presence detected [info]" + selEventsTail
+	if got := describeSEL(parseSELList(testdataFile(t, "sel", "list.txt"))); got != want {
+		t.Errorf("events:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseSELListVerbose(t *testing.T) {
+	want := selEventsHead + "0006 2024-01-15 10:42:00 Power Supply #0x51: Presence detected (Asserted) [info]" + selEventsTail
+	if got := describeSEL(parseSELList(testdataFile(t, "sel", "list_verbose.txt"))); got != want {
+		t.Errorf("events:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseSELListNoEntries(t *testing.T) {
+	for _, output := range []string{"", "SEL has no entries\n", "Could not open device at /dev/ipmi0 or /dev/ipmi/0 or /dev/ipmidev/0: No such file or directory\n"} {
+		if events := parseSELList(output); len(events) != 0 {
+			t.Errorf("%q: %+v", output, events)
+		}
+	}
+}
+
+func TestWorstSELSeverity(t *testing.T) {
+	if got := worstSELSeverity(nil); got != "info" {
+		t.Errorf("no events: %s", got)
+	}
+	if got := worstSELSeverity([]SELEvent{{Severity: "info"}, {Severity: "warning"}, {Severity: "info"}}); got != "warning" {
+		t.Errorf("warning: %s", got)
+	}
+	if got := worstSELSeverity([]SELEvent{{Severity: "critical"}, {Severity: "warning"}}); got != "critical" {
+		t.Errorf("critical: %s", got)
+	}
+}
+
+// fakeSELIpmitool подставляет ipmitool, печатающий текущее содержимое SEL; возвращает функцию его замены
+func fakeSELIpmitool(t *testing.T) func(fixture string, lines int) {
+	t.Helper()
+	dir := t.TempDir()
+	sel := filepath.Join(dir, "sel.txt")
+	script := "#!/bin/sh\ncat '" + sel + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "ipmitool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return func(fixture string, lines int) {
+		all := strings.SplitAfter(testdataFile(t, "sel", fixture), "\n")
+		if err := os.WriteFile(sel, []byte(strings.Join(all[:lines], "")), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Новые записи отбираются по ID после базовой; каждая попадает в сессию один раз
+func TestSELCollectorByID(t *testing.T) {
+	setSEL := fakeSELIpmitool(t)
+	c := &selCollector{lastID: 3, started: time.Now(), seen: make(map[uint32]bool)}
+
+	setSEL("list.txt", 4)
+	events, err := c.collect("memtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].ID != 4 || events[0].AfterTest != "memtest" || events[0].Severity != "warning" {
+		t.Errorf("after the failure: %+v", events)
+	}
+
+	setSEL("list.txt", 7)
+	if events, _ = c.collect(""); len(events) != 3 || events[0].ID != 5 || events[2].ID != 0x1a || events[0].AfterTest != "" {
+		t.Errorf("at session end: %+v", events)
+	}
+	if events, _ = c.collect(""); len(events) != 0 {
+		t.Errorf("events collected twice: %+v", events)
+	}
+	if len(c.events) != 4 {
+		t.Errorf("%d session event(s), want 4", len(c.events))
+	}
+}
+
+// Базовой записи нет - SEL очищали во время сессии: новые отбираются по времени BMC, Pre-Init остаются
+func TestSELCollectorAfterClear(t *testing.T) {
+	setSEL := fakeSELIpmitool(t)
+	started := time.Date(2024, 1, 15, 10, 40, 0, 0, time.Local)
+	c := &selCollector{lastID: 0x50, started: started, seen: make(map[uint32]bool)}
+
+	setSEL("list.txt", 6)
+	events, err := c.collect("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint32
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	if len(ids) != 4 || ids[0] != 1 || ids[1] != 4 || ids[3] != 6 {
+		t.Errorf("records after the clear: %x", ids)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSeedContinuationSystemInfo(t *testing.T) {
+	defer setTestSystemInfo(SystemInfo{})
+
+	session := SessionLog{
+		System: SystemInfo{Product: "TEST", MBSerial: "OLD0001", MAC: "00:11:22:33:44:55", IOSerial: "IO-OLD"},
+		FlashReview: &FlashReview{Confirmed: map[string]string{
+			"system-serial-number": "NEW0002",
+			"mac_address":          "AA:BB:CC:DD:EE:01",
+			"io_board":             "IO-NEW",
+		}},
+		FlashResults: []FlashResult{
+			{Operation: "fru", Status: "SUCCESS"},
+			{Operation: "mac", Status: "SUCCESS"},
+			{Operation: "smbios", Status: "FAILED"},
+		},
+	}
+	seedContinuationSystemInfo(session)
+
+	test := TestSpec{Name: "post", Args: []string{"--serial={{.MBSerial}}", "--mac={{.MAC}}", "--io={{.IOSerial}}", "{{.Product}}"}}
+	if err := compileTestArgs(&test); err != nil {
+		t.Fatal(err)
+	}
+	testSystemInfoMutex.Lock()
+	info := testSystemInfo
+	testSystemInfoMutex.Unlock()
+	args, err := expandTestArgTemplates(test, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// smbios не прошит - IO остается прежним
+	want := []string{"--serial=NEW0002", "--mac=AA:BB:CC:DD:EE:01", "--io=IO-OLD", "TEST"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("args %v, want %v", args, want)
+	}
+}
+
+func TestSeedContinuationSystemInfoWithoutFlash(t *testing.T) {
+	defer setTestSystemInfo(SystemInfo{})
+
+	seedContinuationSystemInfo(SessionLog{System: SystemInfo{MBSerial: "SN1", MAC: "00:11:22:33:44:55"}})
+	testSystemInfoMutex.Lock()
+	info := testSystemInfo
+	testSystemInfoMutex.Unlock()
+	if info.MBSerial != "SN1" || info.MAC != "00:11:22:33:44:55" {
+		t.Fatalf("got %+v", info)
+	}
+}
+
+// -resume: подтвержденные данные прошивки и признак смены серийного номера переживают прерывание
+func TestResumeRestoresFlashData(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "session_current.yaml")
+	startSessionState(SessionLog{SessionID: "1", Timestamp: time.Now()}, stateFile)
+	defer clearSessionState()
+
+	review := &FlashReview{Confirmed: map[string]string{
+		"system-serial-number": "SN0002",
+		"mac_address":          "AA:BB:CC:DD:EE:01",
+	}}
+	recordSessionFlashData(review, &ProductVariant{ID: "A", Source: "detected"}, OperatorPair{"alice", "bob"})
+	recordSessionFlash([]FlashResult{
+		{Operation: "fru", Status: "PASSED", SerialChanged: true},
+		{Operation: "mac", Status: "PASSED"},
+	})
+
+	interrupted, err := loadSessionState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flashData := resumedFlashData(*interrupted)
+	if flashData == nil {
+		t.Fatal("flash data not restored")
+	}
+	if flashData.SystemSerial != "SN0002" || flashData.MAC != "AA:BB:CC:DD:EE:01" {
+		t.Errorf("restored %+v", flashData)
+	}
+	if flashData.Variant == nil || flashData.Variant.ID != "A" || flashData.Review == nil {
+		t.Errorf("variant %+v, review %+v", flashData.Variant, flashData.Review)
+	}
+	if interrupted.Pipeline.Operators != (OperatorPair{"alice", "bob"}) {
+		t.Errorf("operators %v", interrupted.Pipeline.Operators)
+	}
+	if !flashSerialChanged(interrupted.FlashResults) {
+		t.Error("resumed fru result lost serial_changed")
+	}
+	if flashSerialChanged(interrupted.FlashResults[1:]) {
+		t.Error("mac result reports a serial change")
+	}
+}
+
+func TestResumedFlashDataNotEntered(t *testing.T) {
+	for name, review := range map[string]*FlashReview{
+		"none":    nil,
+		"aborted": {Confirmed: map[string]string{"system-serial-number": "SN1"}, Aborted: true},
+		"empty":   {},
+	} {
+		if fd := resumedFlashData(SessionLog{FlashReview: review}); fd != nil {
+			t.Errorf("%s: restored %+v", name, fd)
+		}
+	}
+}
+
+// Без require_dual_operator операторов в логе нет, а не пустая пара ["", ""]
+func TestPipelineOperatorsOmittedWhenEmpty(t *testing.T) {
+	data, err := yaml.Marshal(PipelineInfo{Mode: "full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "operators") {
+		t.Errorf("empty operators in the log:\n%s", data)
+	}
+	data, err = yaml.Marshal(PipelineInfo{Mode: "full", Operators: OperatorPair{"alice", "bob"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "operators: [alice, bob]\n") {
+		t.Errorf("operators:\n%s", data)
+	}
+
+	// Подтверждение пропущено (-non-interactive): пара из одного оператора читается обратно
+	data, err = yaml.Marshal(PipelineInfo{Mode: "full", Operators: OperatorPair{"alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pipeline PipelineInfo
+	if err := yaml.Unmarshal(data, &pipeline); err != nil || pipeline.Operators != (OperatorPair{"alice", ""}) {
+		t.Errorf("%v %v:\n%s", pipeline.Operators, err, data)
+	}
+	// Больше двух операторов в логе быть не может
+	if err := yaml.Unmarshal([]byte("operators: [alice, bob, carol]\n"), &pipeline); err == nil {
+		t.Error("three operators accepted")
+	}
+}
@@ -0,0 +1,327 @@
+// Package smart разбирает вывод smartctl --json и nvme smart-log -o json в ключевые показатели
+// износа дисков и сравнивает снимки до и после прогона тестов. Запуск утилит - снаружи.
+package smart
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Источник показателей устройства
+const (
+	SourceSmartctl = "smartctl"
+	SourceNVMeCLI  = "nvme-cli"
+)
+
+// Имена показателей в Change.Attribute
+const (
+	AttrReallocated = "reallocated_sectors"
+	AttrMediaErrors = "media_errors"
+	AttrWear        = "wear_level_percent"
+	AttrTemperature = "temperature_max_c"
+	AttrMissing     = "missing" // Устройство пропало между снимками
+	AttrAdded       = "added"   // Появилось после первого снимка (только для информации)
+)
+
+// Attributes - ключевые показатели диска; nil - устройство показатель не сообщает
+type Attributes struct {
+	ReallocatedSectors *int64 `yaml:"reallocated_sectors,omitempty" json:"reallocated_sectors,omitempty"` // ATA 5, SCSI grown defects
+	MediaErrors        *int64 `yaml:"media_errors,omitempty" json:"media_errors,omitempty"`               // NVMe media_errors, ATA 187 (иначе 198)
+	WearLevelPercent   *int64 `yaml:"wear_level_percent,omitempty" json:"wear_level_percent,omitempty"`   // Израсходованный ресурс, %
+	TemperatureMaxC    *int64 `yaml:"temperature_max_c,omitempty" json:"temperature_max_c,omitempty"`     // Максимум с включения (или текущая)
+}
+
+// Empty - ни одного показателя (утилита не поддерживает устройство)
+func (a Attributes) Empty() bool {
+	return a.ReallocatedSectors == nil && a.MediaErrors == nil && a.WearLevelPercent == nil && a.TemperatureMaxC == nil
+}
+
+// Device - снимок одного диска
+type Device struct {
+	Name     string `yaml:"name" json:"name"` // /dev/sda
+	Model    string `yaml:"model,omitempty" json:"model,omitempty"`
+	Serial   string `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"` // ATA, NVMe, SCSI
+	Source   string `yaml:"source,omitempty" json:"source,omitempty"`     // smartctl или nvme-cli
+	Error    string `yaml:"error,omitempty" json:"error,omitempty"`       // Почему показатели не получены
+
+	Attributes `yaml:",inline" json:"attributes"`
+}
+
+// Thresholds - допустимое ухудшение за прогон
+type Thresholds struct {
+	ReallocatedSectors int64 // Прирост переназначенных секторов (0 - любой прирост - провал)
+	MediaErrors        int64 // Прирост ошибок носителя
+	WearLevelPercent   int64 // Прирост израсходованного ресурса, %
+	TemperatureMaxC    int64 // Предел максимальной температуры после прогона (0 - не проверяется)
+}
+
+// Change - отличие показателя диска после прогона от снимка до него
+type Change struct {
+	Device    string `yaml:"device" json:"device"`
+	Serial    string `yaml:"serial,omitempty" json:"serial,omitempty"`
+	Attribute string `yaml:"attribute" json:"attribute"`
+	Before    string `yaml:"before,omitempty" json:"before,omitempty"`
+	After     string `yaml:"after,omitempty" json:"after,omitempty"`
+	Delta     int64  `yaml:"delta,omitempty" json:"delta,omitempty"`
+	Worsened  bool   `yaml:"worsened" json:"worsened"` // Превышен порог - тест smart-delta не пройден
+}
+
+// smartctlOutput - нужная часть smartctl -x --json (smartctl 7.0+)
+type smartctlOutput struct {
+	Smartctl struct {
+		Messages []struct {
+			String   string `json:"string"`
+			Severity string `json:"severity"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	Device struct {
+		Name     string `json:"name"`
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	ModelName    string `json:"model_name"`
+	ScsiModel    string `json:"scsi_model_name"`
+	SerialNumber string `json:"serial_number"`
+
+	ATAAttributes struct {
+		Table []struct {
+			ID    int    `json:"id"`
+			Name  string `json:"name"`
+			Value int64  `json:"value"`
+			Raw   struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	EnduranceUsed *struct {
+		CurrentPercent *int64 `json:"current_percent"`
+	} `json:"endurance_used"`
+
+	NVMeLog *nvmeSmartLog `json:"nvme_smart_health_information_log"`
+
+	ScsiGrownDefects *int64 `json:"scsi_grown_defect_list"`
+	ScsiErrorLog     *struct {
+		Read  scsiErrorCounter `json:"read"`
+		Write scsiErrorCounter `json:"write"`
+	} `json:"scsi_error_counter_log"`
+
+	Temperature struct {
+		Current       *int64 `json:"current"`
+		PowerCycleMax *int64 `json:"power_cycle_max"`
+	} `json:"temperature"`
+}
+
+type scsiErrorCounter struct {
+	TotalUncorrected int64 `json:"total_uncorrected_errors"`
+}
+
+// nvmeSmartLog - SMART/Health log NVMe: в smartctl температура в °C, в nvme-cli - в кельвинах
+type nvmeSmartLog struct {
+	Temperature    *int64 `json:"temperature"`
+	PercentageUsed *int64 `json:"percentage_used"`
+	PercentUsed    *int64 `json:"percent_used"` // nvme-cli
+	MediaErrors    *int64 `json:"media_errors"`
+}
+
+// ataWearAttributes - атрибуты ATA с остатком ресурса в нормализованном значении (100 - новый диск):
+// 177 Samsung Wear_Leveling_Count, 202 Micron/Crucial Percent_Lifetime_Remain, 231 SSD_Life_Left, 233 Intel Media_Wearout_Indicator
+var ataWearAttributes = []int{177, 202, 231, 233}
+
+// ParseSmartctlJSON разбирает вывод smartctl -x --json. Ошибка - только для неразбираемого JSON;
+// устройство без данных SMART возвращается с пустыми Attributes и сообщением smartctl в Error
+func ParseSmartctlJSON(data []byte) (Device, error) {
+	var out smartctlOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return Device{}, fmt.Errorf("invalid smartctl JSON: %v", err)
+	}
+	device := Device{
+		Name:     out.Device.Name,
+		Model:    strings.TrimSpace(out.ModelName),
+		Serial:   strings.TrimSpace(out.SerialNumber),
+		Protocol: out.Device.Protocol,
+		Source:   SourceSmartctl,
+	}
+	if device.Model == "" {
+		device.Model = strings.TrimSpace(out.ScsiModel)
+	}
+
+	raw := make(map[int]int64)
+	normalized := make(map[int]int64)
+	for _, attr := range out.ATAAttributes.Table {
+		raw[attr.ID] = attr.Raw.Value
+		normalized[attr.ID] = attr.Value
+	}
+	if v, ok := raw[5]; ok {
+		device.ReallocatedSectors = &v
+	}
+	if v, ok := raw[187]; ok {
+		device.MediaErrors = &v
+	} else if v, ok := raw[198]; ok {
+		device.MediaErrors = &v
+	}
+	if out.EnduranceUsed != nil && out.EnduranceUsed.CurrentPercent != nil {
+		device.WearLevelPercent = out.EnduranceUsed.CurrentPercent
+	} else {
+		for _, id := range ataWearAttributes {
+			if v, ok := normalized[id]; ok {
+				used := max(100-v, 0)
+				device.WearLevelPercent = &used
+				break
+			}
+		}
+	}
+
+	if log := out.NVMeLog; log != nil {
+		device.MediaErrors = log.MediaErrors
+		device.WearLevelPercent = log.PercentageUsed
+	}
+
+	if out.ScsiGrownDefects != nil {
+		device.ReallocatedSectors = out.ScsiGrownDefects
+	}
+	if log := out.ScsiErrorLog; log != nil {
+		total := log.Read.TotalUncorrected + log.Write.TotalUncorrected
+		device.MediaErrors = &total
+	}
+
+	switch {
+	case out.Temperature.PowerCycleMax != nil && (out.Temperature.Current == nil || *out.Temperature.PowerCycleMax >= *out.Temperature.Current):
+		device.TemperatureMaxC = out.Temperature.PowerCycleMax
+	case out.Temperature.Current != nil:
+		device.TemperatureMaxC = out.Temperature.Current
+	case out.NVMeLog != nil && out.NVMeLog.Temperature != nil:
+		device.TemperatureMaxC = out.NVMeLog.Temperature
+	}
+
+	if device.Empty() {
+		var errs []string
+		for _, m := range out.Smartctl.Messages {
+			if m.Severity == "error" {
+				errs = append(errs, m.String)
+			}
+		}
+		if len(errs) > 0 {
+			device.Error = strings.Join(errs, "; ")
+		} else {
+			device.Error = "no SMART attributes reported"
+		}
+	}
+	return device, nil
+}
+
+// ParseNVMeSmartLog разбирает nvme smart-log -o json (nvme-cli 1.x и 2.x)
+func ParseNVMeSmartLog(data []byte) (Attributes, error) {
+	var log nvmeSmartLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return Attributes{}, fmt.Errorf("invalid nvme smart-log JSON: %v", err)
+	}
+	attrs := Attributes{MediaErrors: log.MediaErrors, WearLevelPercent: log.PercentUsed}
+	if attrs.WearLevelPercent == nil {
+		attrs.WearLevelPercent = log.PercentageUsed
+	}
+	if log.Temperature != nil && *log.Temperature > 0 {
+		celsius := *log.Temperature - 273
+		attrs.TemperatureMaxC = &celsius
+	}
+	if attrs.Empty() {
+		return attrs, fmt.Errorf("nvme smart-log reported no health data")
+	}
+	return attrs, nil
+}
+
+// Diff сравнивает снимки до и после прогона. Диски сопоставляются по серийному номеру
+// (имена /dev могут смениться после сброса контроллера), затем по имени.
+// Пропавший диск - ухудшение, как и диск, который еще виден, но больше не отдает показатели (Error после прогона);
+// показатели, которых нет в одном из снимков, не сравниваются
+func Diff(before, after []Device, t Thresholds) []Change {
+	used := make([]bool, len(after))
+	find := func(b Device) (Device, bool) {
+		for pass := 0; pass < 2; pass++ {
+			for j, a := range after {
+				if used[j] {
+					continue
+				}
+				if (pass == 0 && b.Serial != "" && a.Serial == b.Serial) || (pass == 1 && a.Name == b.Name && (b.Serial == "" || a.Serial == "")) {
+					used[j] = true
+					return a, true
+				}
+			}
+		}
+		return Device{}, false
+	}
+
+	var changes []Change
+	for _, b := range before {
+		a, ok := find(b)
+		if !ok {
+			changes = append(changes, Change{Device: b.Name, Serial: b.Serial, Attribute: AttrMissing, Before: describe(b), After: "-", Worsened: true})
+			continue
+		}
+		if a.Empty() && !b.Empty() {
+			after := a.Error
+			if after == "" {
+				after = "no SMART attributes reported"
+			}
+			changes = append(changes, Change{Device: a.Name, Serial: b.Serial, Attribute: AttrMissing, Before: describe(b), After: after, Worsened: true})
+			continue
+		}
+		counter := func(attr string, bv, av *int64, limit int64) {
+			if bv == nil || av == nil || *av == *bv {
+				return
+			}
+			delta := *av - *bv
+			changes = append(changes, Change{Device: a.Name, Serial: a.Serial, Attribute: attr,
+				Before: fmt.Sprint(*bv), After: fmt.Sprint(*av), Delta: delta, Worsened: delta > limit})
+		}
+		counter(AttrReallocated, b.ReallocatedSectors, a.ReallocatedSectors, t.ReallocatedSectors)
+		counter(AttrMediaErrors, b.MediaErrors, a.MediaErrors, t.MediaErrors)
+		counter(AttrWear, b.WearLevelPercent, a.WearLevelPercent, t.WearLevelPercent)
+
+		if a.TemperatureMaxC != nil {
+			hot := t.TemperatureMaxC > 0 && *a.TemperatureMaxC > t.TemperatureMaxC
+			if hot || (b.TemperatureMaxC != nil && *a.TemperatureMaxC != *b.TemperatureMaxC) {
+				change := Change{Device: a.Name, Serial: a.Serial, Attribute: AttrTemperature, After: fmt.Sprint(*a.TemperatureMaxC), Worsened: hot}
+				if b.TemperatureMaxC != nil {
+					change.Before = fmt.Sprint(*b.TemperatureMaxC)
+					change.Delta = *a.TemperatureMaxC - *b.TemperatureMaxC
+				}
+				changes = append(changes, change)
+			}
+		}
+	}
+	for j, a := range after {
+		if !used[j] {
+			changes = append(changes, Change{Device: a.Name, Serial: a.Serial, Attribute: AttrAdded, Before: "-", After: describe(a)})
+		}
+	}
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Device < changes[j].Device })
+	return changes
+}
+
+// Worsened - число ухудшений сверх порогов
+func Worsened(changes []Change) int {
+	n := 0
+	for _, c := range changes {
+		if c.Worsened {
+			n++
+		}
+	}
+	return n
+}
+
+// describe - диск для таблицы разницы
+func describe(d Device) string {
+	parts := []string{}
+	if d.Model != "" {
+		parts = append(parts, d.Model)
+	}
+	if d.Serial != "" {
+		parts = append(parts, "s/n "+d.Serial)
+	}
+	if len(parts) == 0 {
+		return d.Name
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,169 @@
+package smart
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func value(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprint(*v)
+}
+
+// attrs - показатели в виде "reallocated/media/wear/temperature" ("-" - не сообщается)
+func attrs(a Attributes) string {
+	return strings.Join([]string{value(a.ReallocatedSectors), value(a.MediaErrors), value(a.WearLevelPercent), value(a.TemperatureMaxC)}, "/")
+}
+
+func TestParseSmartctlJSON(t *testing.T) {
+	cases := []struct {
+		file, name, model, serial, protocol, attrs, err string
+	}{
+		{"sata_samsung_860evo.json", "/dev/sda", "Samsung SSD 860 EVO 500GB", "S3Z2NB0K123456A", "ATA", "0/0/4/41", ""},
+		// Нет 187 - ошибки носителя из 198; износ из нормализованного 233
+		{"sata_intel_s4510.json", "/dev/sdb", "INTEL SSDSC2KB480G8", "PHYF912300AB480BGN", "ATA", "2/0/2/29", ""},
+		{"nvme_samsung_980pro.json", "/dev/nvme0", "Samsung SSD 980 PRO 1TB", "S5GXNF0R123456X", "NVMe", "-/0/3/43", ""},
+		// Без блока temperature - температура из SMART/Health log (в smartctl уже в °C)
+		{"nvme_micron_7450.json", "/dev/nvme1", "Micron_7450_MTFDKBG960TFR", "22353A1B2C3D", "NVMe", "-/1/0/38", ""},
+		{"scsi_seagate.json", "/dev/sdc", "ST1200MM0009", "W3P0ABCD", "SCSI", "4/1/-/36", ""},
+		{"usb_bridge_unsupported.json", "/dev/sdd", "", "", "SCSI", "-/-/-/-", "/dev/sdd: Unknown USB bridge [0x152d:0x0578 (0x209)]"},
+	}
+	for _, c := range cases {
+		d, err := ParseSmartctlJSON(fixture(t, c.file))
+		if err != nil {
+			t.Errorf("%s: %v", c.file, err)
+			continue
+		}
+		if d.Name != c.name || d.Model != c.model || d.Serial != c.serial || d.Protocol != c.protocol || d.Source != SourceSmartctl {
+			t.Errorf("%s: device %+v", c.file, d)
+		}
+		if got := attrs(d.Attributes); got != c.attrs {
+			t.Errorf("%s: attributes %s, want %s", c.file, got, c.attrs)
+		}
+		if d.Error != c.err {
+			t.Errorf("%s: error %q, want %q", c.file, d.Error, c.err)
+		}
+	}
+
+	if _, err := ParseSmartctlJSON([]byte("Smartctl open device: /dev/sdx failed")); err == nil {
+		t.Error("text output parsed as JSON")
+	}
+}
+
+func TestParseNVMeSmartLog(t *testing.T) {
+	cases := []struct {
+		file, attrs string
+		fails       bool
+	}{
+		// nvme-cli отдает температуру в кельвинах
+		{"nvmecli_v1_samsung.json", "-/0/3/43", false},
+		{"nvmecli_v2_micron.json", "-/2/1/38", false},
+		{"nvmecli_unsupported.json", "-/-/-/-", true},
+	}
+	for _, c := range cases {
+		a, err := ParseNVMeSmartLog(fixture(t, c.file))
+		if (err != nil) != c.fails {
+			t.Errorf("%s: error %v", c.file, err)
+		}
+		if got := attrs(a); got != c.attrs {
+			t.Errorf("%s: attributes %s, want %s", c.file, got, c.attrs)
+		}
+	}
+}
+
+func parse(t *testing.T, files ...string) []Device {
+	t.Helper()
+	var devices []Device
+	for _, file := range files {
+		d, err := ParseSmartctlJSON(fixture(t, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+func int64p(v int64) *int64 { return &v }
+
+func changeKeys(changes []Change) string {
+	var keys []string
+	for _, c := range changes {
+		key := c.Device + ":" + c.Attribute
+		if c.Worsened {
+			key += "!"
+		}
+		keys = append(keys, key)
+	}
+	return strings.Join(keys, " ")
+}
+
+func TestDiff(t *testing.T) {
+	before := parse(t, "sata_samsung_860evo.json", "sata_intel_s4510.json", "nvme_samsung_980pro.json", "nvme_micron_7450.json")
+	limits := Thresholds{ReallocatedSectors: 0, MediaErrors: 0, WearLevelPercent: 1, TemperatureMaxC: 70}
+
+	if changes := Diff(before, parse(t, "sata_samsung_860evo.json", "sata_intel_s4510.json", "nvme_samsung_980pro.json", "nvme_micron_7450.json"), limits); len(changes) != 0 {
+		t.Fatalf("identical snapshots: %s", changeKeys(changes))
+	}
+
+	after := parse(t, "sata_samsung_860evo.json", "sata_intel_s4510.json", "nvme_samsung_980pro.json", "nvme_micron_7450.json")
+	after[0].ReallocatedSectors = int64p(3) // Прирост переназначенных - провал
+	after[1].WearLevelPercent = int64p(3)   // +1% износа - в пределах порога
+	after[2].TemperatureMaxC = int64p(75)   // Перегрев
+	after[3].Name = "/dev/nvme0"            // Контроллер переименован после сброса - сопоставление по серийнику
+	after[2].Name = "/dev/nvme1"
+	changes := Diff(before, after, limits)
+	want := "/dev/nvme1:temperature_max_c! /dev/sda:reallocated_sectors! /dev/sdb:wear_level_percent"
+	if got := changeKeys(changes); got != want {
+		t.Errorf("changes %s, want %s", got, want)
+	}
+	if Worsened(changes) != 2 {
+		t.Errorf("worsened %d", Worsened(changes))
+	}
+}
+
+func TestDiffMissingAndAdded(t *testing.T) {
+	before := parse(t, "sata_samsung_860evo.json", "nvme_samsung_980pro.json")
+	after := parse(t, "nvme_samsung_980pro.json", "scsi_seagate.json")
+	changes := Diff(before, after, Thresholds{})
+	if got := changeKeys(changes); got != "/dev/sda:missing! /dev/sdc:added" {
+		t.Fatalf("changes %s", got)
+	}
+}
+
+// Диск еще перечисляется, но после прогона не отдает показатели - это то же, что пропавший диск
+func TestDiffDeviceStopsReporting(t *testing.T) {
+	before := parse(t, "sata_samsung_860evo.json", "nvme_micron_7450.json")
+	after := []Device{
+		{Name: "/dev/sda", Source: SourceSmartctl, Error: "Read SMART Data failed: scsi error aborted command"},
+		before[1],
+	}
+	changes := Diff(before, after, Thresholds{})
+	if len(changes) != 1 {
+		t.Fatalf("changes %s", changeKeys(changes))
+	}
+	c := changes[0]
+	if c.Attribute != AttrMissing || !c.Worsened || c.Serial != "S3Z2NB0K123456A" || !strings.Contains(c.After, "Read SMART Data failed") {
+		t.Errorf("change %+v", c)
+	}
+
+	// Диск и до прогона не отдавал показатели - сравнивать нечего
+	unsupported := parse(t, "usb_bridge_unsupported.json")
+	if changes := Diff(unsupported, parse(t, "usb_bridge_unsupported.json"), Thresholds{}); len(changes) != 0 {
+		t.Errorf("unsupported device: %s", changeKeys(changes))
+	}
+}
@@ -0,0 +1,373 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: firestarter.proto
+
+package statuspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Empty struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Empty) Reset() {
+	*x = Empty{}
+	mi := &file_firestarter_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Empty) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Empty) ProtoMessage() {}
+
+func (x *Empty) ProtoReflect() protoreflect.Message {
+	mi := &file_firestarter_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Empty.ProtoReflect.Descriptor instead.
+func (*Empty) Descriptor() ([]byte, []int) {
+	return file_firestarter_proto_rawDescGZIP(), []int{0}
+}
+
+type TestResultEvent struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	SessionId      string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Name           string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Status         string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	DurationMs     int64                  `protobuf:"varint,4,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	Error          string                 `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
+	Required       bool                   `protobuf:"varint,6,opt,name=required,proto3" json:"required,omitempty"`
+	Attempts       int32                  `protobuf:"varint,7,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	FinishedUnixMs int64                  `protobuf:"varint,8,opt,name=finished_unix_ms,json=finishedUnixMs,proto3" json:"finished_unix_ms,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *TestResultEvent) Reset() {
+	*x = TestResultEvent{}
+	mi := &file_firestarter_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TestResultEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TestResultEvent) ProtoMessage() {}
+
+func (x *TestResultEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_firestarter_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TestResultEvent.ProtoReflect.Descriptor instead.
+func (*TestResultEvent) Descriptor() ([]byte, []int) {
+	return file_firestarter_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *TestResultEvent) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *TestResultEvent) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TestResultEvent) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *TestResultEvent) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *TestResultEvent) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *TestResultEvent) GetRequired() bool {
+	if x != nil {
+		return x.Required
+	}
+	return false
+}
+
+func (x *TestResultEvent) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *TestResultEvent) GetFinishedUnixMs() int64 {
+	if x != nil {
+		return x.FinishedUnixMs
+	}
+	return 0
+}
+
+type SessionStatus struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	SessionId      string                 `protobuf:"bytes,1,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Product        string                 `protobuf:"bytes,2,opt,name=product,proto3" json:"product,omitempty"`
+	Serial         string                 `protobuf:"bytes,3,opt,name=serial,proto3" json:"serial,omitempty"`
+	Phase          string                 `protobuf:"bytes,4,opt,name=phase,proto3" json:"phase,omitempty"`
+	ActiveTest     string                 `protobuf:"bytes,5,opt,name=active_test,json=activeTest,proto3" json:"active_test,omitempty"`
+	TestsTotal     int32                  `protobuf:"varint,6,opt,name=tests_total,json=testsTotal,proto3" json:"tests_total,omitempty"`
+	TestsCompleted int32                  `protobuf:"varint,7,opt,name=tests_completed,json=testsCompleted,proto3" json:"tests_completed,omitempty"`
+	Passed         int32                  `protobuf:"varint,8,opt,name=passed,proto3" json:"passed,omitempty"`
+	Failed         int32                  `protobuf:"varint,9,opt,name=failed,proto3" json:"failed,omitempty"`
+	Skipped        int32                  `protobuf:"varint,10,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	StartedUnixMs  int64                  `protobuf:"varint,11,opt,name=started_unix_ms,json=startedUnixMs,proto3" json:"started_unix_ms,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *SessionStatus) Reset() {
+	*x = SessionStatus{}
+	mi := &file_firestarter_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SessionStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SessionStatus) ProtoMessage() {}
+
+func (x *SessionStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_firestarter_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SessionStatus.ProtoReflect.Descriptor instead.
+func (*SessionStatus) Descriptor() ([]byte, []int) {
+	return file_firestarter_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SessionStatus) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *SessionStatus) GetProduct() string {
+	if x != nil {
+		return x.Product
+	}
+	return ""
+}
+
+func (x *SessionStatus) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
+}
+
+func (x *SessionStatus) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *SessionStatus) GetActiveTest() string {
+	if x != nil {
+		return x.ActiveTest
+	}
+	return ""
+}
+
+func (x *SessionStatus) GetTestsTotal() int32 {
+	if x != nil {
+		return x.TestsTotal
+	}
+	return 0
+}
+
+func (x *SessionStatus) GetTestsCompleted() int32 {
+	if x != nil {
+		return x.TestsCompleted
+	}
+	return 0
+}
+
+func (x *SessionStatus) GetPassed() int32 {
+	if x != nil {
+		return x.Passed
+	}
+	return 0
+}
+
+func (x *SessionStatus) GetFailed() int32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *SessionStatus) GetSkipped() int32 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *SessionStatus) GetStartedUnixMs() int64 {
+	if x != nil {
+		return x.StartedUnixMs
+	}
+	return 0
+}
+
+var File_firestarter_proto protoreflect.FileDescriptor
+
+const file_firestarter_proto_rawDesc = "" +
+	"\n" +
+	"\x11firestarter.proto\x12\vfirestarter\"\a\n" +
+	"\x05Empty\"\xf5\x01\n" +
+	"\x0fTestResultEvent\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x1f\n" +
+	"\vduration_ms\x18\x04 \x01(\x03R\n" +
+	"durationMs\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\x12\x1a\n" +
+	"\brequired\x18\x06 \x01(\bR\brequired\x12\x1a\n" +
+	"\battempts\x18\a \x01(\x05R\battempts\x12(\n" +
+	"\x10finished_unix_ms\x18\b \x01(\x03R\x0efinishedUnixMs\"\xd3\x02\n" +
+	"\rSessionStatus\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x01 \x01(\tR\tsessionId\x12\x18\n" +
+	"\aproduct\x18\x02 \x01(\tR\aproduct\x12\x16\n" +
+	"\x06serial\x18\x03 \x01(\tR\x06serial\x12\x14\n" +
+	"\x05phase\x18\x04 \x01(\tR\x05phase\x12\x1f\n" +
+	"\vactive_test\x18\x05 \x01(\tR\n" +
+	"activeTest\x12\x1f\n" +
+	"\vtests_total\x18\x06 \x01(\x05R\n" +
+	"testsTotal\x12'\n" +
+	"\x0ftests_completed\x18\a \x01(\x05R\x0etestsCompleted\x12\x16\n" +
+	"\x06passed\x18\b \x01(\x05R\x06passed\x12\x16\n" +
+	"\x06failed\x18\t \x01(\x05R\x06failed\x12\x18\n" +
+	"\askipped\x18\n" +
+	" \x01(\x05R\askipped\x12&\n" +
+	"\x0fstarted_unix_ms\x18\v \x01(\x03R\rstartedUnixMs2\x9d\x01\n" +
+	"\rStatusService\x12G\n" +
+	"\x11StreamTestResults\x12\x12.firestarter.Empty\x1a\x1c.firestarter.TestResultEvent0\x01\x12C\n" +
+	"\x11GetCurrentSession\x12\x12.firestarter.Empty\x1a\x1a.firestarter.SessionStatusB\x16Z\x14firestarter/statuspbb\x06proto3"
+
+var (
+	file_firestarter_proto_rawDescOnce sync.Once
+	file_firestarter_proto_rawDescData []byte
+)
+
+func file_firestarter_proto_rawDescGZIP() []byte {
+	file_firestarter_proto_rawDescOnce.Do(func() {
+		file_firestarter_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_firestarter_proto_rawDesc), len(file_firestarter_proto_rawDesc)))
+	})
+	return file_firestarter_proto_rawDescData
+}
+
+var file_firestarter_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_firestarter_proto_goTypes = []any{
+	(*Empty)(nil),           // 0: firestarter.Empty
+	(*TestResultEvent)(nil), // 1: firestarter.TestResultEvent
+	(*SessionStatus)(nil),   // 2: firestarter.SessionStatus
+}
+var file_firestarter_proto_depIdxs = []int32{
+	0, // 0: firestarter.StatusService.StreamTestResults:input_type -> firestarter.Empty
+	0, // 1: firestarter.StatusService.GetCurrentSession:input_type -> firestarter.Empty
+	1, // 2: firestarter.StatusService.StreamTestResults:output_type -> firestarter.TestResultEvent
+	2, // 3: firestarter.StatusService.GetCurrentSession:output_type -> firestarter.SessionStatus
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_firestarter_proto_init() }
+func file_firestarter_proto_init() {
+	if File_firestarter_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_firestarter_proto_rawDesc), len(file_firestarter_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_firestarter_proto_goTypes,
+		DependencyIndexes: file_firestarter_proto_depIdxs,
+		MessageInfos:      file_firestarter_proto_msgTypes,
+	}.Build()
+	File_firestarter_proto = out.File
+	file_firestarter_proto_goTypes = nil
+	file_firestarter_proto_depIdxs = nil
+}
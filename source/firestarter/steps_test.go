@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runSteps(t *testing.T, ctx context.Context, timeout time.Duration, steps ...TestStep) TestResult {
+	t.Helper()
+	test := TestSpec{Name: "composite", Steps: steps}
+	if err := compileTestArgs(&test); err != nil {
+		t.Fatal(err)
+	}
+	var result TestResult
+	executeTestSteps(ctx, test, timeout, SystemInfo{}, &result, nil)
+	return result
+}
+
+func stepStatuses(result TestResult) string {
+	var statuses []string
+	for _, s := range result.Steps {
+		statuses = append(statuses, s.Status)
+	}
+	return strings.Join(statuses, " ")
+}
+
+// Таймаут среднего шага: третий не запускается, тест целиком - TIMEOUT
+func TestStepTimeoutSkipsRemainingSteps(t *testing.T) {
+	started := time.Now()
+	result := runSteps(t, context.Background(), time.Minute,
+		TestStep{Name: "prepare", Command: "true"},
+		TestStep{Name: "stress", Command: "sleep", Args: []string{"5"}, Timeout: "100ms"},
+		TestStep{Name: "verify", Command: "true"},
+	)
+	if got := stepStatuses(result); got != "PASSED TIMEOUT SKIPPED" {
+		t.Fatalf("steps %s", got)
+	}
+	if result.Status != "TIMEOUT" || !strings.Contains(result.Error, "step 'stress'") {
+		t.Errorf("test %s: %s", result.Status, result.Error)
+	}
+	if time.Since(started) > 2*time.Second {
+		t.Errorf("step timeout not applied: %s", time.Since(started))
+	}
+}
+
+// Таймаут всего теста останавливает шаги и с continue_on_fail
+func TestTestTimeoutOverridesContinueOnFail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	result := runSteps(t, ctx, 100*time.Millisecond,
+		TestStep{Name: "stress", Command: "sleep", Args: []string{"5"}, ContinueOnFail: true},
+		TestStep{Name: "verify", Command: "true"},
+	)
+	if got := stepStatuses(result); got != "TIMEOUT SKIPPED" {
+		t.Fatalf("steps %s", got)
+	}
+	if result.Status != "TIMEOUT" {
+		t.Errorf("test %s", result.Status)
+	}
+}
+
+func TestContinueOnFailRunsNextSteps(t *testing.T) {
+	result := runSteps(t, context.Background(), time.Minute,
+		TestStep{Name: "optional", Command: "false", ContinueOnFail: true},
+		TestStep{Name: "check", Command: "echo", Args: []string{"ERROR found"}, FailPattern: "ERROR"},
+		TestStep{Name: "never", Command: "true"},
+	)
+	if got := stepStatuses(result); got != "FAILED FAILED SKIPPED" {
+		t.Fatalf("steps %s", got)
+	}
+	if result.Status != "FAILED" || !strings.Contains(result.Error, "fail_pattern") {
+		t.Errorf("test %s: %s", result.Status, result.Error)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// mixedResults - все статусы и причины пропуска в одном наборе
+func mixedResults() []TestResult {
+	return []TestResult{
+		{Name: "cpu", Status: "PASSED"},
+		{Name: "memory", Status: "PASSED"},
+		{Name: "disk", Status: "PASSED"},
+		{Name: "nic", Status: "PASSED", Flappy: true, Attempts: 2},
+		{Name: "usb", Status: "PASSED"},
+		{Name: "gpu", Status: "FAILED", Error: "exit status 1"},
+		{Name: "stress", Status: "TIMEOUT"},
+		{Name: "fan-test", Status: "SKIPPED", SkipReason: SkipOperator},
+		{Name: "psu-test", Status: "SKIPPED", SkipReason: SkipCondition, SkipDetail: "skip_condition"},
+		{Name: "led-test", Status: "SKIPPED", SkipReason: SkipOperator},
+	}
+}
+
+func useSkipPolicy(t *testing.T, exclude bool) {
+	t.Helper()
+	saved := excludeSkippedFromRate
+	excludeSkippedFromRate = exclude
+	t.Cleanup(func() { excludeSkippedFromRate = saved })
+}
+
+// summaryRate - процент успешных из вывода итогов ("" - строки нет)
+func summaryRate(t *testing.T, print func()) string {
+	t.Helper()
+	output := ansiEscape.ReplaceAllString(captureStdout(t, print), "")
+	for _, line := range strings.Split(output, "\n") {
+		if label, value, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(label) == tr("summary.success_rate") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// Итоги тестов и итоги сессии считают процент по одной политике
+func TestSkipPolicyConsistentAcrossSummaries(t *testing.T) {
+	for _, tc := range []struct {
+		exclude bool
+		want    string
+	}{
+		{false, "50%"}, // 5 из 10
+		{true, "71%"},  // 5 из 7: пропущенные не в знаменателе
+	} {
+		useSkipPolicy(t, tc.exclude)
+		results := mixedResults()
+		tests := summaryRate(t, func() { printTestsSummary(results, time.Minute) })
+		session := summaryRate(t, func() { printExecutionSummary(results, nil, time.Minute, nil, nil, nil) })
+		if tests != tc.want || session != tc.want {
+			t.Errorf("exclude_skipped_from_rate %v: tests summary %q, session summary %q, want %q", tc.exclude, tests, session, tc.want)
+		}
+	}
+}
+
+func TestSuccessRate(t *testing.T) {
+	skippedOnly := []TestResult{{Name: "a", Status: "SKIPPED"}, {Name: "b", Status: "SKIPPED"}}
+	passedAndSkipped := []TestResult{{Name: "a", Status: "PASSED"}, {Name: "b", Status: "SKIPPED"}}
+	for _, tc := range []struct {
+		name    string
+		exclude bool
+		results []TestResult
+		rate    int
+		ok      bool
+	}{
+		{"mixed", false, mixedResults(), 50, true},
+		{"mixed", true, mixedResults(), 71, true},
+		{"skipped only", false, skippedOnly, 0, true},
+		// Считать не из чего - строки процента нет, а не 0% или 100%
+		{"skipped only", true, skippedOnly, 0, false},
+		{"passed and skipped", false, passedAndSkipped, 50, true},
+		{"passed and skipped", true, passedAndSkipped, 100, true},
+		{"empty", false, nil, 0, false},
+	} {
+		useSkipPolicy(t, tc.exclude)
+		if rate, ok := successRate(tc.results); rate != tc.rate || ok != tc.ok {
+			t.Errorf("%s, exclude %v: %d%% %v, want %d%% %v", tc.name, tc.exclude, rate, ok, tc.rate, tc.ok)
+		}
+	}
+
+	useSkipPolicy(t, true)
+	if rate := summaryRate(t, func() { printTestsSummary(skippedOnly, time.Minute) }); rate != "" {
+		t.Errorf("rate printed for skipped-only results: %q", rate)
+	}
+}
+
+// Пропущенные в итогах сгруппированы по причине в порядке первого появления
+func TestPrintSkippedTestsByReason(t *testing.T) {
+	results := append(mixedResults(), TestResult{Name: "legacy", Status: "SKIPPED"})
+	output := ansiEscape.ReplaceAllString(captureStdout(t, func() { printSkippedTests("  ", skippedResults(results)) }), "")
+	want := "  Skipped (operator): fan-test, led-test\n  Skipped (condition): psu-test\n  Skipped (other): legacy\n"
+	if output != want {
+		t.Errorf("output:\n%s\nwant:\n%s", output, want)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBIOSInformation(t *testing.T) {
+	data := parseDMIDecode(testdataFile(t, "dmidecode", "full.txt"))
+	vendor, version, date := parseBIOSInformation(data)
+	if vendor != "American Megatrends International, LLC." || version != "1.4b" || date != "07/27/2023" {
+		t.Errorf("BIOS: %q %q %q", vendor, version, date)
+	}
+	// Секции type 1/2 разбираются рядом с type 0 и не смешиваются с ней
+	board, _ := data["Base Board Information"].(map[string]interface{})
+	if board["Serial Number"] != "WM23AS004712" || board["Version"] != "1.02" {
+		t.Errorf("base board: %v", board)
+	}
+	if vendor, version, date := parseBIOSInformation(parseDMIDecode("")); vendor != "" || version != "" || date != "" {
+		t.Errorf("no type 0 section: %q %q %q", vendor, version, date)
+	}
+}
+
+func TestParseSELTime(t *testing.T) {
+	local := time.Date(2024, 1, 15, 10, 30, 45, 0, time.Local)
+	for _, tc := range []struct {
+		output string
+		want   time.Time
+	}{
+		{"01/15/2024 10:30:45\n", local},
+		{"01/15/2024 10:30:45 UTC\n", time.Date(2024, 1, 15, 10, 30, 45, 0, time.UTC)},
+		// Предупреждения ipmitool перед значением
+		{"Get SEL Time command failed, retrying\n01/15/2024 10:30:45\n", local},
+	} {
+		got, err := parseSELTime(tc.output)
+		if err != nil || !got.Equal(tc.want) {
+			t.Errorf("%q: %v %v, want %v", tc.output, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"", "2024-01-15 10:30:45", "Could not open device at /dev/ipmi0"} {
+		if _, err := parseSELTime(bad); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+func TestCollectStationInfo(t *testing.T) {
+	t.Setenv(stationEnvVar, "line2-st07")
+	if station := collectStationInfo(LogConfig{}); station.ID != "line2-st07" || station.FirestarterVersion != VERSION {
+		t.Errorf("from environment: %+v", station)
+	}
+	if station := collectStationInfo(LogConfig{StationID: "st 01/a"}); station.ID != "st 01/a" || stationDirName(station) != "st_01_a" {
+		t.Errorf("from config: %+v -> %s", station, stationDirName(station))
+	}
+	if got := stationDirName(StationInfo{Hostname: "bench-3"}); got != "bench-3" {
+		t.Errorf("hostname fallback: %s", got)
+	}
+	if got := stationDirName(StationInfo{}); got != "unknown_station" {
+		t.Errorf("no identity: %s", got)
+	}
+}
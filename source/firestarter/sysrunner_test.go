@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func (f *fakeRunner) setCommand(command, output string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.commands[command] = output
+}
+
+func (f *fakeRunner) callCount(command string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	count := 0
+	for _, call := range f.calls {
+		if call == command {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCachingRunner(t *testing.T) {
+	inner := &fakeRunner{commands: map[string]string{"uname -r": "6.1.0\n"}}
+	cache := newCachingRunner(inner, time.Hour)
+	for i := 0; i < 3; i++ {
+		if output, err := cache.Run("uname", "-r"); err != nil || string(output) != "6.1.0\n" {
+			t.Fatalf("run %d: %q %v", i, output, err)
+		}
+	}
+	if n := inner.callCount("uname -r"); n != 1 {
+		t.Errorf("%d call(s) within the TTL", n)
+	}
+	cache.Invalidate()
+	cache.Run("uname", "-r")
+	if n := inner.callCount("uname -r"); n != 2 {
+		t.Errorf("%d call(s) after Invalidate", n)
+	}
+
+	expiring := newCachingRunner(inner, time.Millisecond)
+	expiring.Run("uname", "-r")
+	time.Sleep(5 * time.Millisecond)
+	expiring.Run("uname", "-r")
+	if n := inner.callCount("uname -r"); n != 4 {
+		t.Errorf("%d call(s) after the TTL expired", n)
+	}
+}
+
+// Fresh всегда выполняет команду, а ее результат достается следующим обычным чтениям
+func TestCachingRunnerFresh(t *testing.T) {
+	inner := &fakeRunner{commands: map[string]string{"ip -json addr show": "old"}}
+	cache := newCachingRunner(inner, time.Hour)
+	cache.Run("ip", "-json", "addr", "show")
+
+	inner.setCommand("ip -json addr show", "new")
+	fresh := cache.Fresh()
+	for i := 0; i < 2; i++ {
+		if output, _ := fresh.Run("ip", "-json", "addr", "show"); string(output) != "new" {
+			t.Fatalf("fresh run %d: %q", i, output)
+		}
+	}
+	if n := inner.callCount("ip -json addr show"); n != 3 {
+		t.Errorf("%d call(s), want every fresh run executed", n)
+	}
+	if output, _ := cache.Run("ip", "-json", "addr", "show"); string(output) != "new" {
+		t.Errorf("cached read after a fresh one: %q", output)
+	}
+	if n := inner.callCount("ip -json addr show"); n != 3 {
+		t.Errorf("cached read executed the command")
+	}
+}
+
+// Опрос видит MAC, появившийся после того, как список интерфейсов попал в кэш
+func TestWaitForMACPresentBypassesCache(t *testing.T) {
+	inner := &fakeRunner{commands: map[string]string{
+		"ip -json addr show": `[{"ifname":"eno1","operstate":"UP","link_type":"ether","address":"a0:36:9f:00:00:01"}]`,
+	}}
+	useRunner(t, newCachingRunner(inner, time.Hour))
+	if _, err := getCurrentNetworkInterfaces(); err != nil {
+		t.Fatal(err)
+	}
+
+	inner.setCommand("ip -json addr show", `[{"ifname":"eno1","operstate":"UP","link_type":"ether","address":"00:11:22:33:44:55"}]`)
+	name, err := waitForMACPresent("00:11:22:33:44:55", time.Second)
+	if err != nil || name != "eno1" {
+		t.Fatalf("MAC not seen through the cache: %q %v", name, err)
+	}
+	// Обычные чтения после опроса тоже видят новый MAC
+	interfaces, _ := getCurrentNetworkInterfaces()
+	if len(interfaces) != 1 || interfaces[0].MAC != "00:11:22:33:44:55" {
+		t.Errorf("cached interfaces after the poll: %+v", interfaces)
+	}
+}
+
+// Ожидание модуля читает /proc/modules и не запускает процессов
+func TestWaitForModuleStateSpawnsNoProcesses(t *testing.T) {
+	runner := &fakeRunner{files: map[string]string{
+		"/proc/modules": "pgdrv 16384 0 - Live 0x0000000000000000 (OE)\nigb 262144 0 - Live 0x0000000000000000\n",
+	}}
+	useRunner(t, runner)
+	if err := waitForPgdrvLoad(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForDriverLoad("igb", 1); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if waitForModuleState("r8169", true, 300*time.Millisecond) {
+		t.Error("module not in /proc/modules reported as loaded")
+	}
+	if time.Since(started) < 300*time.Millisecond {
+		t.Errorf("gave up after %s", time.Since(started))
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("processes spawned: %s", strings.Join(runner.calls, "; "))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// tagConfig - станция quick: A отбирается тегом, B и C (теги группы) отсекаются, D - в группе с
+// skip_condition, целиком отсеченной тегами. F - post_flash_tests, фильтр их не затрагивает
+func tagConfig(skipConditionMarker string) Config {
+	return Config{
+		Tests: TestsConfig{
+			ParallelGroups: []TestGroupSpec{
+				{Tests: []TestSpec{
+					{Name: "A", Command: "true", Tags: []string{"quick"}},
+					{Name: "B", Command: "true", Tags: []string{"burnin"}},
+					{Name: "C", Command: "true"},
+				}, Tags: []string{"burnin"}},
+			},
+			SequentialGroups: []TestGroupSpec{
+				{Tests: []TestSpec{{Name: "D", Command: "true"}}, Tags: []string{"thermal"}, SkipCondition: "touch " + skipConditionMarker},
+				{Tests: []TestSpec{{Name: "E", Command: "true", Tags: []string{"quick"}}}, SkipCondition: "false"},
+			},
+		},
+		Flash: FlashConfig{
+			Operations:     []string{"efi", "fru"},
+			OperationTags:  map[string][]string{"fru": {"burnin"}},
+			PostFlashTests: []TestSpec{{Name: "F", Command: "true", Tags: []string{"burnin"}}},
+		},
+	}
+}
+
+// useTagFilter применяет фильтр, как main, и восстанавливает глобальные отборы после теста
+func useTagFilter(t *testing.T, config Config, selection *TagSelection, skip map[string]bool) []string {
+	t.Helper()
+	savedSet, savedFlag, savedTags := skipSet, skipSetFlag, tagExcluded
+	t.Cleanup(func() { skipSet, skipSetFlag, tagExcluded = savedSet, savedFlag, savedTags })
+	skipSet, skipSetFlag = skip, "-skip-tests"
+	excluded, unknown := applyTagFilter(config, selection)
+	tagExcluded = excluded
+	return unknown
+}
+
+// Фильтр не трогает конфиг: тесты и операции остаются на месте, отсеченное возвращается отдельно
+func TestApplyTagFilterKeepsConfig(t *testing.T) {
+	config := tagConfig("marker")
+	pristine := tagConfig("marker")
+	selection := &TagSelection{Include: []string{"quick"}, Exclude: []string{"nosuch"}}
+	unknown := useTagFilter(t, config, selection, nil)
+
+	if !reflect.DeepEqual(config, pristine) {
+		t.Errorf("config changed by the tag filter:\n%+v", config)
+	}
+	want := map[string]bool{"Parallel Group 1/B": true, "Parallel Group 1/C": true, "Sequential Group 1/D": true}
+	if !reflect.DeepEqual(tagExcluded, want) {
+		t.Errorf("excluded %v, want %v", tagExcluded, want)
+	}
+	if selection.ExcludedTests != 3 || !reflect.DeepEqual(selection.ExcludedFlashOps, []string{"fru"}) {
+		t.Errorf("selection: %+v", selection)
+	}
+	if !reflect.DeepEqual(unknown, []string{"nosuch"}) {
+		t.Errorf("unknown tags: %v", unknown)
+	}
+}
+
+// Порядок отбора: -skip-tests раньше тегов, теги раньше skip_condition; отсеченные тесты - SKIPPED
+func TestTagFilterPrecedence(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "skip_condition_ran")
+	config := tagConfig(marker)
+	useTagFilter(t, config, &TagSelection{Include: []string{"quick"}}, map[string]bool{"B": true, "E": true})
+
+	results := runTestsStep(config.Tests, listTestGroups(config.Tests), "")
+	got := make(map[string]string)
+	for _, r := range results {
+		got[r.Name] = r.Status + " " + r.SkipDetail
+	}
+	want := map[string]string{
+		"A": "PASSED ",
+		"B": "SKIPPED -skip-tests", // и -skip-tests, и тег - причина -skip-tests
+		"C": "SKIPPED tag filter",  // теги группы
+		"D": "SKIPPED tag filter",
+		"E": "SKIPPED -skip-tests",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results %v, want %v", got, want)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("skip_condition ran for a group excluded by tags")
+	}
+
+	// План показывает то же самое и не выполняет skip_condition отсеченной группы
+	steps, err := buildExecutionPlan(config, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buildPlanReport(config, "config.yaml", steps, true, false)
+	planned := make(map[string]string)
+	for _, step := range report.Steps {
+		for _, g := range step.Groups {
+			for _, test := range g.Tests {
+				planned[test.Name] = test.Skipped
+			}
+		}
+	}
+	wantPlan := map[string]string{
+		"A": "",
+		"B": "skipped via -skip-tests flag",
+		"C": "excluded by tag filter",
+		"D": "excluded by tag filter",
+		"E": "skipped via -skip-tests flag",
+	}
+	if !reflect.DeepEqual(planned, wantPlan) {
+		t.Errorf("plan %v, want %v", planned, wantPlan)
+	}
+	if len(report.HardwareQueries) != 1 {
+		t.Errorf("skip_condition runs in the plan: %v", report.HardwareQueries)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("plan ran skip_condition for a group excluded by tags")
+	}
+}
+
+// Команды отсеченных тестов не проверяются; post_flash_tests фильтр не затрагивает
+func TestRunnableTestsSkipsTagExcluded(t *testing.T) {
+	config := tagConfig("marker")
+	useTagFilter(t, config, &TagSelection{Include: []string{"quick"}}, nil)
+	var names []string
+	for _, test := range runnableTests(&config) {
+		names = append(names, test.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"A", "E", "F"}) {
+		t.Errorf("runnable tests: %v", names)
+	}
+}
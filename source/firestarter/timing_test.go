@@ -0,0 +1,180 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock - часы phaseTimer, которые идут только по advance
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newFakePhaseTimer() (*phaseTimer, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	return newPhaseTimer(clock.Now), clock
+}
+
+func phaseTimes(t *testing.T, timings *Timings) map[string][2]time.Duration {
+	t.Helper()
+	phases := make(map[string][2]time.Duration)
+	for _, seg := range timings.Phases {
+		phases[seg.Name] = [2]time.Duration{seg.Machine, seg.OperatorWait}
+	}
+	return phases
+}
+
+// Ввод оператора вычитается из машинного времени этапа; повторный этап суммируется;
+// время после end - untracked
+func TestPhaseTimerBuckets(t *testing.T) {
+	timer, clock := newFakePhaseTimer()
+	timer.begin("identification")
+	clock.advance(10 * time.Second)
+	timer.begin("flash data entry")
+	stop := timer.operatorWait()
+	clock.advance(40 * time.Second)
+	stop()
+	clock.advance(5 * time.Second)
+	timer.begin("tests: cpu")
+	clock.advance(60 * time.Second)
+	timer.begin("identification")
+	clock.advance(5 * time.Second)
+	timer.end()
+	clock.advance(3 * time.Second)
+
+	timings := timer.timings()
+	want := map[string][2]time.Duration{
+		"identification":   {15 * time.Second, 0},
+		"flash data entry": {5 * time.Second, 40 * time.Second},
+		"tests: cpu":       {60 * time.Second, 0},
+	}
+	if got := phaseTimes(t, timings); len(got) != len(want) || got["identification"] != want["identification"] ||
+		got["flash data entry"] != want["flash data entry"] || got["tests: cpu"] != want["tests: cpu"] {
+		t.Errorf("phases: %v, want %v", got, want)
+	}
+	if timings.Total != 123*time.Second || timings.Machine != 80*time.Second || timings.OperatorWait != 40*time.Second || timings.Untracked != 3*time.Second {
+		t.Errorf("total %s, machine %s, operator %s, untracked %s", timings.Total, timings.Machine, timings.OperatorWait, timings.Untracked)
+	}
+	if timings.Phases[0].Name != "identification" || timings.Phases[2].Name != "tests: cpu" {
+		t.Errorf("phase order: %+v", timings.Phases)
+	}
+}
+
+// Ожидание через границу этапа делится между этапами по времени
+func TestPhaseTimerWaitAcrossPhases(t *testing.T) {
+	timer, clock := newFakePhaseTimer()
+	timer.begin("flash: mac")
+	clock.advance(2 * time.Second)
+	stop := timer.operatorWait()
+	clock.advance(3 * time.Second)
+	timer.begin("verification: psu")
+	clock.advance(4 * time.Second)
+	stop()
+	clock.advance(time.Second)
+
+	got := phaseTimes(t, timer.timings())
+	if got["flash: mac"] != [2]time.Duration{2 * time.Second, 3 * time.Second} || got["verification: psu"] != [2]time.Duration{time.Second, 4 * time.Second} {
+		t.Errorf("phases: %v", got)
+	}
+}
+
+// Вложенные и параллельные ожидания - один интервал; повторный вызов stop ничего не меняет
+func TestPhaseTimerOverlappingWaits(t *testing.T) {
+	timer, clock := newFakePhaseTimer()
+	timer.begin("tests: burn-in")
+	outer := timer.operatorWait()
+	clock.advance(2 * time.Second)
+	inner := timer.operatorWait()
+	clock.advance(3 * time.Second)
+	outer()
+	clock.advance(time.Second)
+	inner()
+	inner()
+	outer()
+	clock.advance(4 * time.Second)
+
+	// Открытый этап учитывается до текущего момента
+	timings := timer.timings()
+	if got := phaseTimes(t, timings)["tests: burn-in"]; got != [2]time.Duration{4 * time.Second, 6 * time.Second} {
+		t.Errorf("machine %s, operator %s", got[0], got[1])
+	}
+	if timings.Total != 10*time.Second || timings.Untracked != 0 {
+		t.Errorf("total %s, untracked %s", timings.Total, timings.Untracked)
+	}
+
+	// Ожидание, идущее в момент timings, уже засчитано оператору
+	stop := timer.operatorWait()
+	clock.advance(5 * time.Second)
+	if got := phaseTimes(t, timer.timings())["tests: burn-in"]; got != [2]time.Duration{4 * time.Second, 11 * time.Second} {
+		t.Errorf("open wait: machine %s, operator %s", got[0], got[1])
+	}
+	stop()
+}
+
+func TestPhaseTimerNil(t *testing.T) {
+	var timer *phaseTimer
+	timer.begin("identification")
+	timer.operatorWait()()
+	timer.setSlowest("identification", nil)
+	timer.end()
+	if timer.phase() != "" || timer.timings() != nil {
+		t.Error("nil timer reported timings")
+	}
+}
+
+func TestSlowestTests(t *testing.T) {
+	timer, clock := newFakePhaseTimer()
+	timer.begin("tests: cpu")
+	clock.advance(time.Minute)
+	timer.setSlowest("tests: cpu", []TestResult{
+		{Name: "a", Status: "PASSED", Duration: 5 * time.Second},
+		{Name: "b", Status: "SKIPPED", Duration: time.Hour},
+		{Name: "c", Status: "FAILED", Duration: 20 * time.Second},
+		{Name: "d", Status: "PASSED", Duration: time.Second},
+		{Name: "e", Status: "TIMEOUT", Duration: 30 * time.Second},
+	})
+	slowest := timer.timings().Phases[0].Slowest
+	var names []string
+	for _, s := range slowest {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "e,c,a" {
+		t.Errorf("slowest: %v", slowest)
+	}
+}
+
+// Доли в таблице считаются от общего времени сессии
+func TestPrintTimingBreakdown(t *testing.T) {
+	timings := &Timings{
+		Total:        200 * time.Second,
+		Machine:      110 * time.Second,
+		OperatorWait: 80 * time.Second,
+		Untracked:    10 * time.Second,
+		Phases: []TimingSegment{
+			{Name: "identification", Machine: 10 * time.Second},
+			{Name: "flash data entry", Machine: 5 * time.Second, OperatorWait: 80 * time.Second},
+			{Name: "tests: cpu", Machine: 95 * time.Second, Slowest: []TestTiming{{Name: "stress", Duration: 90 * time.Second}}},
+		},
+	}
+	output := captureStdout(t, func() { printTimingBreakdown(timings) })
+	for _, want := range []string{
+		"  identification                            10s          -    5.0%\n",
+		"  flash data entry                           5s      1m20s   42.5%\n",
+		"  tests: cpu                              1m35s          -   47.5%\n",
+		"stress 1m30s",
+		"  machine time                            1m50s              55.0%\n",
+		"1m20s   40.0%",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output lacks %q:\n%s", want, output)
+		}
+	}
+	if output := captureStdout(t, func() { printTimingBreakdown(&Timings{}) }); output != "" {
+		t.Errorf("empty timings printed:\n%s", output)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func traceLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []map[string]any
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("not a JSON line: %q", scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestTraceOutputHeadAndTail(t *testing.T) {
+	out := &traceOutput{limit: 4}
+	for _, chunk := range []string{"ab", "cdef", "ghij", "k"} {
+		if n, _ := out.Write([]byte(chunk)); n != len(chunk) {
+			t.Fatalf("short write %d", n)
+		}
+	}
+	if string(out.head) != "abcd" || string(out.tail) != "hijk" || out.total != 11 {
+		t.Fatalf("head %q tail %q total %d", out.head, out.tail, out.total)
+	}
+
+	// Вывод поместился в head - tail пустой
+	short := &traceOutput{limit: 8}
+	short.Write([]byte("ok\n"))
+	if string(short.head) != "ok\n" || len(short.tail) != 0 || short.total != 3 {
+		t.Fatalf("short output: head %q tail %q", short.head, short.tail)
+	}
+}
+
+func TestTraceWriteEntrySizeCap(t *testing.T) {
+	tracer, err := startCommandTrace(t.TempDir(), LogConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+	tracer.maxSize = 200
+
+	entry := []byte(`{"operation":"` + strings.Repeat("x", 80) + `"}`)
+	for i := 0; i < 5; i++ {
+		tracer.writeEntry(entry)
+	}
+
+	lines := traceLines(t, tracer.path)
+	if len(lines) != 3 {
+		t.Fatalf("%d line(s) in the trace, want 2 entries and the limit marker", len(lines))
+	}
+	if msg, _ := lines[2]["error"].(string); !strings.Contains(msg, "trace size limit of 200 bytes") {
+		t.Errorf("limit marker %v", lines[2])
+	}
+	info, _ := os.Stat(tracer.path)
+	if !tracer.full || tracer.size != info.Size() {
+		t.Errorf("full %v, size %d, file %d", tracer.full, tracer.size, info.Size())
+	}
+	// Отметка о пределе пишется один раз
+	tracer.writeEntry([]byte(`{}`))
+	if n := len(traceLines(t, tracer.path)); n != 3 {
+		t.Errorf("%d lines after the limit", n)
+	}
+}
+
+func TestTraceDestructive(t *testing.T) {
+	tracer := &commandTracer{tools: map[string]bool{"afulnx_64": true}}
+	cases := map[string]bool{
+		"eeupdate64e /NIC=1 /MAC_DUMP":         false,
+		"eeupdate64e /ALL":                     false,
+		"eeupdate64e /NIC=1 /MAC=001122334455": true,
+		"/opt/intel/eeupdate64e /nic=2 /a":     true,
+		"rtnicpg /efuse /nodeid 001122334455":  true,
+		"rtnicpg /r":                           false,
+		"ipmitool fru print":                   false,
+		"ipmitool fru write 0 fru.bin":         true,
+		"ipmitool sel clear":                   true,
+		"ipmitool sel time set now":            true,
+		"ipmitool sel time get":                false,
+		"efibootmgr":                           false,
+		"efibootmgr -n 0003":                   true,
+		"efibootmgr --delete-bootnum":          true,
+		"bootctl status":                       false,
+		"bootctl set-oneshot shell.efi":        true,
+		"date":                                 false,
+		"date -s 2026-01-01":                   true,
+		"/sbin/reboot":                         true,
+		"afulnx_64 /SP":                        true, // smbios.tool_path - пишет всегда
+		"dmidecode -t 1":                       false,
+		"":                                     false,
+	}
+	for command, want := range cases {
+		if got := tracer.destructive(strings.Fields(command)); got != want {
+			t.Errorf("%q: destructive %v, want %v", command, got, want)
+		}
+	}
+}
+
+// Вызовы этапа прошивки попадают в скрипт воспроизведения, пишущие - закомментированными
+func TestTraceRecordReplay(t *testing.T) {
+	tracer, err := startCommandTrace(t.TempDir(), LogConfig{TraceOutputBytes: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(operation string, cmd *exec.Cmd) {
+		out := tracer.capture(cmd)
+		started := time.Now()
+		tracer.record(operation, cmd, started, cmd.Run(), out)
+	}
+	run("flash: mac", exec.Command("echo", "/NIC=1", "/MAC_DUMP"))
+	run("identification", exec.Command("true"))
+	// Запускается true: в трассировку и скрипт попадают аргументы, а часы не трогаются
+	clock := exec.Command("true")
+	clock.Args = []string{"date", "-s", "@0"}
+	run("flash: efi", clock)
+	tracer.Close()
+
+	lines := traceLines(t, tracer.path)
+	if len(lines) != 3 || lines[0]["operation"] != "flash: mac" || lines[0]["output_head"] != "/NIC=1 /MAC_DUMP" || lines[0]["output_bytes"] != 17.0 {
+		t.Fatalf("trace %v", lines)
+	}
+	script, err := os.ReadFile(tracer.scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "\n'echo' '/NIC=1' '/MAC_DUMP'\n") || !strings.Contains(string(script), "\n# 'date' '-s' '@0'\n") {
+		t.Errorf("replay script:\n%s", script)
+	}
+	if strings.Contains(string(script), "'true'") {
+		t.Errorf("non-flash command in the replay script:\n%s", script)
+	}
+}
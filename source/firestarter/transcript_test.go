@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withStdin подставляет пайп вместо os.Stdin; возвращает его пишущий конец
+func withStdin(t *testing.T) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = saved
+		r.Close()
+		w.Close()
+	})
+	return w
+}
+
+func startTestTranscript(t *testing.T) (*consoleTranscript, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "console.txt")
+	tr, err := startConsoleTranscript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transcript = tr
+	t.Cleanup(func() {
+		tr.Close()
+		transcript = nil
+	})
+	return tr, path
+}
+
+// Сообщения print* и ответ, прочитанный программой, попадают в транскрипт
+func TestTranscriptRecordsOperatorAnswers(t *testing.T) {
+	stdin := withStdin(t)
+	tr, path := startTestTranscript(t)
+
+	stdin.WriteString("SN123\n")
+	printInfo("Enter serial:")
+	answer, err := bufio.NewReader(operatorInput()).ReadString('\n')
+	if err != nil || answer != "SN123\n" {
+		t.Fatalf("answer %q: %v", answer, err)
+	}
+	tr.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "| Enter serial:\n") || !strings.Contains(string(data), "| SN123\n") {
+		t.Errorf("transcript:\n%s", data)
+	}
+}
+
+// Транскрипт не читает stdin сам: ввод, предназначенный дочернему процессу, достается ему
+func TestTranscriptLeavesStdinToChildren(t *testing.T) {
+	stdin := withStdin(t)
+	startTestTranscript(t)
+
+	stdin.WriteString("child input\n")
+	stdin.Close()
+	cmd := exec.Command("cat")
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "child input\n" {
+		t.Errorf("child read %q", output)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRemote подставляет в PATH ssh и scp, которые выполняют команды локально: "сервер" - это
+// каталог теста. FAKE_SCP_DELAY задерживает scp (выгрузка, которую main перестал ждать)
+func fakeRemote(t *testing.T, scpDelay string) {
+	t.Helper()
+	bin := t.TempDir()
+	ssh := "#!/bin/sh\nwhile [ $# -gt 0 ]; do case \"$1\" in -o) shift 2;; -*) shift;; *) break;; esac; done\n" +
+		"shift\nexec sh -c \"$*\"\n"
+	scp := "#!/bin/sh\nsleep \"${FAKE_SCP_DELAY:-0}\"\n" +
+		"for a; do src=$dst; dst=$a; done\nexec cp -r \"$src\" \"${dst#*:}\"\n"
+	for name, script := range map[string]string{"ssh": ssh, "scp": scp} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_SCP_DELAY", scpDelay)
+}
+
+func uploadTestConfig(t *testing.T) (LogConfig, SessionLog) {
+	config := LogConfig{
+		SendLogs:      true,
+		Server:        "user@host",
+		ServerDir:     t.TempDir(),
+		LogDir:        t.TempDir(),
+		UploadRetries: 1,
+	}
+	log := redactedSession()
+	log.Upload = &UploadCheck{Reachable: true}
+	return config, log
+}
+
+func outboxFiles(t *testing.T, config LogConfig) []string {
+	t.Helper()
+	items, _ := os.ReadDir(filepath.Join(config.LogDir, "outbox"))
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name())
+	}
+	return names
+}
+
+// Выгрузка, которую main бросил по upload_wait_timeout, уже лежит в outbox
+func TestLogSpooledBeforeUpload(t *testing.T) {
+	fakeRemote(t, "1")
+	config, log := uploadTestConfig(t)
+	name := sessionLogFileName(log)
+
+	upload, err := asyncLogUpload(log, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	note, err := os.ReadFile(filepath.Join(config.LogDir, "outbox", name+".note.txt"))
+	if err != nil {
+		t.Fatalf("log not in outbox during the upload: %v", err)
+	}
+	if !strings.Contains(string(note), "reason: "+errUploadInProgress.Error()) {
+		t.Errorf("note:\n%s", note)
+	}
+
+	if err := <-upload.Done; err != nil {
+		t.Fatal(err)
+	}
+	if files := outboxFiles(t, config); len(files) != 0 {
+		t.Errorf("outbox after a successful upload: %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(config.ServerDir, "TEST", name)); err != nil {
+		t.Errorf("log not on the server: %v", err)
+	}
+}
+
+func TestFailedUploadKeepsReason(t *testing.T) {
+	fakeRemote(t, "0")
+	config, log := uploadTestConfig(t)
+	log.Upload = &UploadCheck{Error: "no route"}
+
+	if err := sendLogToServer(log, config, nil, nil); err == nil {
+		t.Fatal("upload to an unreachable server succeeded")
+	}
+	note, err := os.ReadFile(filepath.Join(config.LogDir, "outbox", sessionLogFileName(log)+".note.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(note), "no route") {
+		t.Errorf("note:\n%s", note)
+	}
+}